@@ -0,0 +1,173 @@
+// Package breaker wraps codecs and storage adapters in circuit breakers, so
+// a backend that keeps failing, such as a broken libvips build or an
+// unreachable S3 endpoint, is taken out of service for a while: calls fail
+// at once with a CategoryTransient error wrapping errors.ErrCircuitOpen
+// instead of each waiting out its own timeout.
+//
+//	b := breaker.New("s3", breaker.Options{Threshold: 5, OpenFor: 30 * time.Second})
+//	store := breaker.Storage(s3, b)
+//
+//	vb := breaker.New("vips", breaker.Options{})
+//	proc.RegisterDecoder(core.FormatJPEG, breaker.Decoder(backend, vb))
+//	proc.RegisterEncoder(core.FormatJPEG, breaker.Encoder(backend, vb))
+//
+// A Breaker may be shared by several wrappers, as above, so that they trip
+// together.
+package breaker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Options configures a Breaker.
+type Options struct {
+	// Threshold is the number of consecutive failures that opens the
+	// circuit.  0 means 5.
+	Threshold int
+	// OpenFor is how long an open circuit rejects calls before letting a
+	// single trial call through.  0 means 30s.
+	OpenFor time.Duration
+	// IsFailure reports whether err counts towards Threshold.  nil counts
+	// every error except input and config errors, which are the caller's
+	// fault rather than the backend's, and context cancellation.
+	IsFailure func(err error) bool
+}
+
+// State is the position of a Breaker's circuit.
+type State int
+
+const (
+	// Closed lets every call through.
+	Closed State = iota
+	// Open rejects every call until Options.OpenFor has passed.
+	Open
+	// HalfOpen lets one trial call through: success closes the circuit,
+	// failure opens it again.
+	HalfOpen
+)
+
+func (s State) String() string {
+	switch s {
+	case Closed:
+		return "closed"
+	case Open:
+		return "open"
+	case HalfOpen:
+		return "half-open"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// errPanicked is recorded for a call that panicked; the panic itself
+// carries on up the stack.
+var errPanicked = errors.New("call panicked")
+
+// Breaker counts consecutive failures of the calls run through Do and opens
+// its circuit when they reach the threshold.  It is safe for concurrent use.
+type Breaker struct {
+	name string
+	opts Options
+
+	mu       sync.Mutex
+	state    State
+	failures int
+	openedAt time.Time
+}
+
+// New returns a closed Breaker.  name identifies it in errors.
+func New(name string, opts Options) *Breaker {
+	if opts.Threshold <= 0 {
+		opts.Threshold = 5
+	}
+	if opts.OpenFor <= 0 {
+		opts.OpenFor = 30 * time.Second
+	}
+	if opts.IsFailure == nil {
+		opts.IsFailure = isFailure
+	}
+	return &Breaker{name: name, opts: opts}
+}
+
+func isFailure(err error) bool {
+	return !apperrors.IsCategory(err, apperrors.CategoryInput) &&
+		!apperrors.IsCategory(err, apperrors.CategoryConfig) &&
+		!errors.Is(err, context.Canceled)
+}
+
+// Name returns the name the Breaker was created with.
+func (b *Breaker) Name() string { return b.name }
+
+// State reports the circuit's position.  An open circuit whose OpenFor has
+// passed reports HalfOpen: the next call is let through as the trial.
+func (b *Breaker) State() State {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.state == Open && time.Since(b.openedAt) >= b.opts.OpenFor {
+		return HalfOpen
+	}
+	return b.state
+}
+
+// Do runs fn unless the circuit is open, in which case it returns a
+// transient error wrapping errors.ErrCircuitOpen without calling fn.  op
+// names the operation in that error.
+func (b *Breaker) Do(op string, fn func() error) error {
+	if err := b.allow(op); err != nil {
+		return err
+	}
+	err := errPanicked
+	defer func() { b.record(err) }()
+	err = fn()
+	return err
+}
+
+func (b *Breaker) allow(op string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case Open:
+		if time.Since(b.openedAt) >= b.opts.OpenFor {
+			b.state = HalfOpen
+			return nil
+		}
+	case HalfOpen:
+		// The trial call is still running.
+	default:
+		return nil
+	}
+	return apperrors.Transient(op, fmt.Errorf("%w: %s", apperrors.ErrCircuitOpen, b.name))
+}
+
+func (b *Breaker) record(err error) {
+	failed := err != nil && (err == errPanicked || b.opts.IsFailure(err))
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case HalfOpen:
+		if failed {
+			b.open()
+		} else {
+			b.state, b.failures = Closed, 0
+		}
+	case Closed:
+		switch {
+		case failed:
+			if b.failures++; b.failures >= b.opts.Threshold {
+				b.open()
+			}
+		case err == nil:
+			b.failures = 0
+		}
+	}
+	// Calls let through before the circuit opened change nothing.
+}
+
+func (b *Breaker) open() {
+	b.state, b.openedAt, b.failures = Open, time.Now(), 0
+}
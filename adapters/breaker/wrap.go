@@ -0,0 +1,160 @@
+package breaker
+
+import (
+	"context"
+	"io"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// ── Codecs ────────────────────────────────────────────────────────────────────
+
+// Decoder returns d with its calls run through b.  The result implements
+// core.PageDecoder when d does.
+func Decoder(d core.Decoder, b *Breaker) core.Decoder {
+	w := &decoder{d: d, b: b}
+	if pd, ok := d.(core.PageDecoder); ok {
+		return &pageDecoder{decoder: w, pd: pd}
+	}
+	return w
+}
+
+type decoder struct {
+	d core.Decoder
+	b *Breaker
+}
+
+func (w *decoder) CanDecode(f core.Format) bool { return w.d.CanDecode(f) }
+
+// CodecName implements core.NamedCodec with the name of the wrapped decoder.
+func (w *decoder) CodecName() string { return core.CodecName(w.d) }
+
+// Capabilities implements core.CapabilityReporter for the wrapped decoder.
+func (w *decoder) Capabilities(f core.Format) core.CodecCapabilities {
+	return core.CapabilitiesOf(w.d, f)
+}
+
+func (w *decoder) Decode(ctx context.Context, r io.Reader) (img *core.ImageData, err error) {
+	err = w.b.Do("breaker.decode", func() error {
+		img, err = w.d.Decode(ctx, r)
+		return err
+	})
+	return img, err
+}
+
+type pageDecoder struct {
+	*decoder
+	pd core.PageDecoder
+}
+
+func (w *pageDecoder) PageCount(ctx context.Context, data []byte) (n int, err error) {
+	err = w.b.Do("breaker.page_count", func() error {
+		n, err = w.pd.PageCount(ctx, data)
+		return err
+	})
+	return n, err
+}
+
+func (w *pageDecoder) DecodePage(ctx context.Context, data []byte, page int) (img *core.ImageData, err error) {
+	err = w.b.Do("breaker.decode_page", func() error {
+		img, err = w.pd.DecodePage(ctx, data, page)
+		return err
+	})
+	return img, err
+}
+
+// Encoder returns e with its calls run through b.  The result implements
+// core.StreamEncoder when e does.
+func Encoder(e core.Encoder, b *Breaker) core.Encoder {
+	w := &encoder{e: e, b: b}
+	if se, ok := e.(core.StreamEncoder); ok {
+		return &streamEncoder{encoder: w, se: se}
+	}
+	return w
+}
+
+type encoder struct {
+	e core.Encoder
+	b *Breaker
+}
+
+func (w *encoder) CanEncode(f core.Format) bool { return w.e.CanEncode(f) }
+
+// CodecName implements core.NamedCodec with the name of the wrapped encoder.
+func (w *encoder) CodecName() string { return core.CodecName(w.e) }
+
+// Capabilities implements core.CapabilityReporter for the wrapped encoder.
+func (w *encoder) Capabilities(f core.Format) core.CodecCapabilities {
+	return core.CapabilitiesOf(w.e, f)
+}
+
+func (w *encoder) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeOptions) (out []byte, err error) {
+	err = w.b.Do("breaker.encode", func() error {
+		out, err = w.e.Encode(ctx, img, opts)
+		return err
+	})
+	return out, err
+}
+
+type streamEncoder struct {
+	*encoder
+	se core.StreamEncoder
+}
+
+func (w *streamEncoder) EncodeTo(ctx context.Context, dst io.Writer, img *core.ImageData, opts core.EncodeOptions) error {
+	return w.b.Do("breaker.encode", func() error { return w.se.EncodeTo(ctx, dst, img, opts) })
+}
+
+// ── Storage ───────────────────────────────────────────────────────────────────
+
+// Storage returns s with its calls run through b.  The result implements
+// core.Lister when s does.
+func Storage(s core.StorageAdapter, b *Breaker) core.StorageAdapter {
+	w := &storage{s: s, b: b}
+	if l, ok := s.(core.Lister); ok {
+		return &listingStorage{storage: w, l: l}
+	}
+	return w
+}
+
+type storage struct {
+	s core.StorageAdapter
+	b *Breaker
+}
+
+func (w *storage) Put(ctx context.Context, key core.StorageKey, r io.Reader, meta map[string]string) error {
+	return w.b.Do("breaker.put", func() error { return w.s.Put(ctx, key, r, meta) })
+}
+
+func (w *storage) Get(ctx context.Context, key core.StorageKey) (rc io.ReadCloser, err error) {
+	err = w.b.Do("breaker.get", func() error {
+		rc, err = w.s.Get(ctx, key)
+		return err
+	})
+	return rc, err
+}
+
+func (w *storage) Delete(ctx context.Context, key core.StorageKey) error {
+	return w.b.Do("breaker.delete", func() error { return w.s.Delete(ctx, key) })
+}
+
+func (w *storage) Exists(ctx context.Context, key core.StorageKey) (ok bool, err error) {
+	err = w.b.Do("breaker.exists", func() error {
+		ok, err = w.s.Exists(ctx, key)
+		return err
+	})
+	return ok, err
+}
+
+type listingStorage struct {
+	*storage
+	l core.Lister
+}
+
+func (w *listingStorage) List(ctx context.Context, bucket, prefix string) (keys []core.StorageKey, err error) {
+	err = w.b.Do("breaker.list", func() error {
+		keys, err = w.l.List(ctx, bucket, prefix)
+		return err
+	})
+	return keys, err
+}
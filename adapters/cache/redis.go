@@ -0,0 +1,57 @@
+// Package cache provides shared core.ResultCache implementations, so
+// several processes serving the same images reuse each other's results.
+// Install one with Processor.SetResultCache.
+package cache
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// RedisClient is the subset of Redis commands the Redis cache uses.  Adapt
+// a go-redis or rueidis client in a few lines, or inject a test double.
+// Get returns nil, nil for a missing key; Set with a zero ttl stores the
+// value without expiry.
+type RedisClient interface {
+	Get(ctx context.Context, key string) ([]byte, error)
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+}
+
+// Redis is a core.ResultCache kept in Redis under "<prefix>:<key>", with
+// expiry left to Redis.
+type Redis struct {
+	client RedisClient
+	prefix string
+}
+
+// NewRedis creates a Redis cache storing results under keys starting with
+// prefix.  client must not be nil.
+func NewRedis(client RedisClient, prefix string) (*Redis, error) {
+	if client == nil {
+		return nil, fmt.Errorf("redis cache: client must not be nil")
+	}
+	if prefix == "" {
+		prefix = "imageprocessor:results"
+	}
+	return &Redis{client: client, prefix: prefix}, nil
+}
+
+// Get implements core.ResultCache.
+func (c *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	value, err := c.client.Get(ctx, c.prefix+":"+key)
+	if err != nil {
+		return nil, false, apperrors.Transient("redis.cache_get", err)
+	}
+	return value, value != nil, nil
+}
+
+// Set implements core.ResultCache.
+func (c *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := c.client.Set(ctx, c.prefix+":"+key, value, ttl); err != nil {
+		return apperrors.Transient("redis.cache_set", err)
+	}
+	return nil
+}
@@ -0,0 +1,135 @@
+// Package classifier provides core.Classifier implementations.
+package classifier
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"image/jpeg"
+	"io"
+	"net/http"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// HTTP sends the image to a remote moderation or tagging API and reads back
+// label scores.  The request body is the image's encoded bytes (Data, or a
+// JPEG rendering of Image when Data is empty) with a matching Content-Type.
+// The response must be a JSON object of label → score, either at the top
+// level or under a "scores" key.
+type HTTP struct {
+	Endpoint string
+	Client   *http.Client      // default http.DefaultClient
+	Header   http.Header       // extra request headers, e.g. Authorization
+	Labels   map[string]string // optional API label → local label renames
+}
+
+// NewHTTP returns an HTTP classifier posting to endpoint.
+func NewHTTP(endpoint string) *HTTP { return &HTTP{Endpoint: endpoint} }
+
+// Classify implements core.Classifier.  5xx responses and transport errors
+// are returned as transient so the Processor's retry policy applies.
+func (c *HTTP) Classify(ctx context.Context, img *core.ImageData) (map[string]float64, error) {
+	body, contentType, err := requestBody(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryInput, "classifier.http", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, "classifier.http", err)
+	}
+	for k, vs := range c.Header {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Accept", "application/json")
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, apperrors.Transient("classifier.http", err)
+	}
+	defer resp.Body.Close()
+	raw, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return nil, apperrors.Transient("classifier.http", err)
+	}
+	if resp.StatusCode >= 500 {
+		return nil, apperrors.Transient("classifier.http", fmt.Errorf("status %s", resp.Status))
+	}
+	if resp.StatusCode >= 300 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, "classifier.http",
+			fmt.Errorf("status %s: %s", resp.Status, bytes.TrimSpace(raw)))
+	}
+
+	scores, err := parseScores(raw)
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryPipeline, "classifier.http", err)
+	}
+	if len(c.Labels) > 0 {
+		renamed := make(map[string]float64, len(scores))
+		for k, v := range scores {
+			if to, ok := c.Labels[k]; ok {
+				k = to
+			}
+			renamed[k] = v
+		}
+		scores = renamed
+	}
+	return scores, nil
+}
+
+func requestBody(ctx context.Context, img *core.ImageData) ([]byte, string, error) {
+	if len(img.Data) > 0 {
+		return img.Data, "image/" + string(img.Format), nil
+	}
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, "", err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 90}); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), "image/jpeg", nil
+}
+
+func parseScores(raw []byte) (map[string]float64, error) {
+	var wrapped struct {
+		Scores map[string]float64 `json:"scores"`
+	}
+	if err := json.Unmarshal(raw, &wrapped); err == nil && wrapped.Scores != nil {
+		return wrapped.Scores, nil
+	}
+	var flat map[string]float64
+	if err := json.Unmarshal(raw, &flat); err != nil {
+		return nil, fmt.Errorf("decode scores: %w", err)
+	}
+	return flat, nil
+}
+
+// ──────────────────────────────────────────────────────────────────────────────
+// Integration guide: a local ONNX model
+// ──────────────────────────────────────────────────────────────────────────────
+//
+//  import ort "github.com/yalue/onnxruntime_go"
+//
+//  type ONNX struct {
+//      session *ort.AdvancedSession
+//      input   *ort.Tensor[float32] // 1×3×224×224, filled per call
+//      output  *ort.Tensor[float32] // 1×len(labels)
+//      labels  []string
+//      mu      sync.Mutex           // sessions are not safe for concurrent Run
+//  }
+//
+//  func (m *ONNX) Classify(ctx context.Context, img *core.ImageData) (map[string]float64, error) {
+//      // resize img.Image to 224×224, normalise into m.input.GetData(),
+//      // m.session.Run(), then map m.output.GetData() onto m.labels.
+//  }
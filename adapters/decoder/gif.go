@@ -0,0 +1,101 @@
+package decoder
+
+import (
+	"context"
+	"image"
+	"image/gif"
+	"io"
+
+	"github.com/Skryldev/image-processor/animation"
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// GIF decodes GIF images using the standard library.  Animated GIFs decode
+// to a *core.Animation of composited frames, which the GIF encoder writes
+// back out as an animation; with FirstFrameOnly they decode to their first
+// frame like any still.  Images exceeding Limits are refused before they
+// are decoded; an animation counts as the strip of all its frames, as
+// libvips loads it.
+type GIF struct {
+	FirstFrameOnly bool
+	Limits         core.DecodeLimits
+}
+
+func NewGIF() *GIF { return &GIF{} }
+
+func (g *GIF) CanDecode(format core.Format) bool {
+	return format == core.FormatGIF
+}
+
+// Capabilities implements core.CapabilityReporter: animations keep every
+// frame unless FirstFrameOnly is set.
+func (g *GIF) Capabilities(f core.Format) core.CodecCapabilities {
+	maxW, maxH := f.MaxDimensions()
+	return core.CodecCapabilities{Animation: !g.FirstFrameOnly, Alpha: true, MaxWidth: maxW, MaxHeight: maxH}
+}
+
+func (g *GIF) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error) {
+	buf, err := utils.DrainReader(ctx, r, 0)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "gif.decode", err)
+	}
+	defer utils.ReleaseBuffer(buf)
+	data := buf.Bytes()
+
+	var (
+		img    image.Image
+		probe  image.Image // a single frame, for colour space and alpha
+		frames int
+	)
+	n := utils.GIFFrameCount(data)
+	if err := g.checkHeader(data, n); err != nil {
+		return nil, err
+	}
+	if n > 1 && !g.FirstFrameOnly {
+		a, err := animation.DecodeAnimation(data)
+		if err != nil {
+			return nil, err
+		}
+		img, probe, frames = a, a.Frames[0], len(a.Frames)
+	} else {
+		if img, err = gif.Decode(utils.BytesReader(data)); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryDecode, "gif.decode", err)
+		}
+		probe = img
+	}
+
+	bounds := img.Bounds()
+	meta := core.Metadata{
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		Format:     core.FormatGIF,
+		ColorSpace: colorSpace(probe),
+		HasAlpha:   hasAlpha(probe),
+		Frames:     frames,
+	}
+
+	return &core.ImageData{
+		Image:  img,
+		Format: core.FormatGIF,
+		Meta:   meta,
+	}, nil
+}
+
+// checkHeader applies Limits to the logical screen of data, which holds
+// frames frames.
+func (g *GIF) checkHeader(data []byte, frames int) error {
+	if g.Limits.IsZero() {
+		return nil
+	}
+	cfg, err := gif.DecodeConfig(utils.BytesReader(data))
+	if err != nil {
+		return nil // left for the decoder to report
+	}
+	if !g.FirstFrameOnly && frames > 1 {
+		cfg.Height *= frames
+	}
+	h := utils.HeaderOf(cfg)
+	return g.Limits.Check("gif.decode", h.Width, h.Height, h.BytesPerPixel)
+}
@@ -11,8 +11,11 @@ import (
 	apperrors "github.com/Skryldev/image-processor/errors"
 )
 
-// JPEG decodes JPEG images using the standard library.
-type JPEG struct{}
+// JPEG decodes JPEG images using the standard library.  Images whose
+// header exceeds Limits are refused before their pixels are decoded.
+type JPEG struct {
+	Limits core.DecodeLimits
+}
 
 // NewJPEG returns an initialised JPEG decoder.
 func NewJPEG() *JPEG { return &JPEG{} }
@@ -26,6 +29,10 @@ func (j *JPEG) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error)
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "jpeg.decode", err)
 	}
 
+	r, err := checkHeader("jpeg.decode", r, j.Limits, jpeg.DecodeConfig)
+	if err != nil {
+		return nil, err
+	}
 	img, err := jpeg.Decode(r)
 	if err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "jpeg.decode", err)
@@ -0,0 +1,31 @@
+package decoder
+
+import (
+	"bytes"
+	"image"
+	"io"
+
+	"github.com/Skryldev/image-processor/core"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// checkHeader reads the image header from r with decodeConfig and applies
+// limits before any pixels are decoded.  It returns a reader that replays
+// the header bytes ahead of the rest of r for the full decode.  A header
+// decodeConfig cannot read is left for the decoder to report.
+func checkHeader(op string, r io.Reader, limits core.DecodeLimits, decodeConfig func(io.Reader) (image.Config, error)) (io.Reader, error) {
+	if limits.IsZero() {
+		return r, nil
+	}
+	var head bytes.Buffer
+	cfg, err := decodeConfig(io.TeeReader(r, &head))
+	replay := io.MultiReader(&head, r)
+	if err != nil {
+		return replay, nil
+	}
+	h := utils.HeaderOf(cfg)
+	if err := limits.Check(op, h.Width, h.Height, h.BytesPerPixel); err != nil {
+		return nil, err
+	}
+	return replay, nil
+}
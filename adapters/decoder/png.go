@@ -9,8 +9,11 @@ import (
 	apperrors "github.com/Skryldev/image-processor/errors"
 )
 
-// PNG decodes PNG images using the standard library.
-type PNG struct{}
+// PNG decodes PNG images using the standard library.  Images whose header
+// exceeds Limits are refused before their pixels are decoded.
+type PNG struct {
+	Limits core.DecodeLimits
+}
 
 func NewPNG() *PNG { return &PNG{} }
 
@@ -23,6 +26,10 @@ func (p *PNG) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error)
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "png.decode", err)
 	}
 
+	r, err := checkHeader("png.decode", r, p.Limits, png.DecodeConfig)
+	if err != nil {
+		return nil, err
+	}
 	img, err := png.Decode(r)
 	if err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "png.decode", err)
@@ -0,0 +1,127 @@
+package decoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+	"golang.org/x/image/tiff"
+)
+
+// TIFF decodes TIFF images using golang.org/x/image/tiff.  It also
+// implements core.PageDecoder: golang.org/x/image/tiff only reads the first
+// IFD, so other pages are decoded by pointing the header at their IFD.
+// Pages whose header exceeds Limits are refused before they are decoded.
+type TIFF struct {
+	Limits core.DecodeLimits
+}
+
+func NewTIFF() *TIFF { return &TIFF{} }
+
+func (t *TIFF) CanDecode(format core.Format) bool {
+	return format == core.FormatTIFF
+}
+
+func (t *TIFF) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error) {
+	buf, err := utils.DrainReader(ctx, r, 0)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "tiff.decode", err)
+	}
+	defer utils.ReleaseBuffer(buf)
+	return t.DecodePage(ctx, buf.Bytes(), 1)
+}
+
+// PageCount implements core.PageDecoder.
+func (t *TIFF) PageCount(_ context.Context, data []byte) (int, error) {
+	ifds, err := tiffIFDs(data)
+	if err != nil {
+		return 0, apperrors.Wrap(apperrors.CategoryDecode, "tiff.pages", err)
+	}
+	return len(ifds), nil
+}
+
+// DecodePage implements core.PageDecoder.
+func (t *TIFF) DecodePage(ctx context.Context, data []byte, page int) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "tiff.decode", err)
+	}
+	ifds, err := tiffIFDs(data)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "tiff.decode", err)
+	}
+	if page < 1 || page > len(ifds) {
+		return nil, apperrors.New(apperrors.CategoryInput, "tiff.decode",
+			fmt.Errorf("%w: page %d of %d", apperrors.ErrInvalidDimensions, page, len(ifds)))
+	}
+
+	src := data
+	if page > 1 {
+		// IFD offsets are absolute, so repointing the header is enough.
+		src = bytes.Clone(data)
+		tiffOrder(src).PutUint32(src[4:8], ifds[page-1])
+	}
+	if _, err := checkHeader("tiff.decode", bytes.NewReader(src), t.Limits, tiff.DecodeConfig); err != nil {
+		return nil, err
+	}
+	img, err := tiff.Decode(bytes.NewReader(src))
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "tiff.decode", err)
+	}
+
+	bounds := img.Bounds()
+	meta := core.Metadata{
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		Format:     core.FormatTIFF,
+		ColorSpace: colorSpace(img),
+		HasAlpha:   hasAlpha(img),
+	}
+	if len(ifds) > 1 {
+		meta.Pages = len(ifds)
+	}
+
+	return &core.ImageData{
+		Image:  img,
+		Format: core.FormatTIFF,
+		Meta:   meta,
+	}, nil
+}
+
+func tiffOrder(data []byte) binary.ByteOrder {
+	if data[0] == 'M' {
+		return binary.BigEndian
+	}
+	return binary.LittleEndian
+}
+
+// tiffIFDs walks the IFD chain and returns the offset of every IFD.
+func tiffIFDs(data []byte) ([]uint32, error) {
+	if len(data) < 8 || !(bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*"))) {
+		return nil, errors.New("not a TIFF file")
+	}
+	order := tiffOrder(data)
+	var ifds []uint32
+	seen := map[uint32]bool{}
+	for off := order.Uint32(data[4:8]); off != 0; {
+		if seen[off] || int(off)+2 > len(data) {
+			return nil, errors.New("corrupt IFD chain")
+		}
+		seen[off] = true
+		ifds = append(ifds, off)
+		next := int(off) + 2 + 12*int(order.Uint16(data[off:]))
+		if next+4 > len(data) {
+			return nil, errors.New("truncated IFD")
+		}
+		off = order.Uint32(data[next:])
+	}
+	if len(ifds) == 0 {
+		return nil, errors.New("no IFD")
+	}
+	return ifds, nil
+}
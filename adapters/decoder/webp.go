@@ -6,16 +6,22 @@ import (
 	"image"
 	"io"
 
+	"github.com/Skryldev/image-processor/animation"
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
 	"github.com/Skryldev/image-processor/utils"
 	"golang.org/x/image/webp"
 )
 
-// WebP decodes WebP images using golang.org/x/image/webp.
-// NOTE: golang.org/x/image/webp only supports lossy WebP decoding.
-// For lossless or animated WebP, integrate libvips or google/go-webp.
-type WebP struct{}
+// WebP decodes lossy (VP8) and lossless (VP8L) WebP images using
+// golang.org/x/image/webp.  Animated WebP decodes to a *core.Animation of
+// composited frames, like animated GIF; with FirstFrameOnly it decodes to
+// its first frame.  Images whose canvas exceeds Limits are refused before
+// they are decoded.
+type WebP struct {
+	FirstFrameOnly bool
+	Limits         core.DecodeLimits
+}
 
 func NewWebP() *WebP { return &WebP{} }
 
@@ -23,6 +29,13 @@ func (w *WebP) CanDecode(format core.Format) bool {
 	return format == core.FormatWebP
 }
 
+// Capabilities implements core.CapabilityReporter: animations keep every
+// frame unless FirstFrameOnly is set.
+func (w *WebP) Capabilities(f core.Format) core.CodecCapabilities {
+	maxW, maxH := f.MaxDimensions()
+	return core.CodecCapabilities{Animation: !w.FirstFrameOnly, Alpha: true, MaxWidth: maxW, MaxHeight: maxH}
+}
+
 func (w *WebP) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "webp.decode", err)
@@ -35,7 +48,16 @@ func (w *WebP) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error)
 	}
 	defer utils.ReleaseBuffer(buf)
 
-	img, err := webp.Decode(utils.BytesReader(buf.Bytes()))
+	data := buf.Bytes()
+	if _, err := checkHeader("webp.decode", utils.BytesReader(data), w.Limits, webp.DecodeConfig); err != nil {
+		return nil, err
+	}
+	if utils.IsAnimatedWebP(data) {
+		// x/image/webp does not read ANMF frames.
+		return w.decodeAnimation(data)
+	}
+
+	img, err := webp.Decode(utils.BytesReader(data))
 	if err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "webp.decode", err)
 	}
@@ -56,5 +78,31 @@ func (w *WebP) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error)
 	}, nil
 }
 
+func (w *WebP) decodeAnimation(data []byte) (*core.ImageData, error) {
+	a, err := animation.DecodeAnimation(data)
+	if err != nil {
+		return nil, err
+	}
+	var img image.Image = a
+	frames := len(a.Frames)
+	if w.FirstFrameOnly {
+		img, frames = a.Frames[0], 0
+	}
+	bounds := a.Bounds()
+	meta := core.Metadata{
+		Width:      bounds.Dx(),
+		Height:     bounds.Dy(),
+		Format:     core.FormatWebP,
+		ColorSpace: colorSpace(a.Frames[0]),
+		HasAlpha:   hasAlpha(a.Frames[0]),
+		Frames:     frames,
+	}
+	return &core.ImageData{
+		Image:  img,
+		Format: core.FormatWebP,
+		Meta:   meta,
+	}, nil
+}
+
 // ensure image.Image is satisfied (webp.Decode returns image.Image).
 var _ = fmt.Sprintf // suppress unused import
\ No newline at end of file
@@ -0,0 +1,138 @@
+package encoder
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"io"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// GIF encodes images to GIF using the standard library.  A *core.Animation
+// is written as an animated GIF with its delays and loop count; anything
+// else as a single frame.  Colours are reduced to one median-cut palette
+// shared by every frame, with Floyd-Steinberg dithering, and one entry is
+// kept for transparency when any pixel is less than half opaque.
+type GIF struct{}
+
+func NewGIF() *GIF { return &GIF{} }
+
+func (g *GIF) CanEncode(format core.Format) bool { return format == core.FormatGIF }
+
+// Capabilities implements core.CapabilityReporter: a *core.Animation is
+// written with all its frames.
+func (g *GIF) Capabilities(f core.Format) core.CodecCapabilities {
+	maxW, maxH := f.MaxDimensions()
+	return core.CodecCapabilities{Animation: true, Alpha: true, MaxWidth: maxW, MaxHeight: maxH}
+}
+
+func (g *GIF) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := g.EncodeTo(ctx, &buf, img, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeTo implements core.StreamEncoder.
+func (g *GIF) EncodeTo(ctx context.Context, w io.Writer, img *core.ImageData, opts core.EncodeOptions) error {
+	if err := ctx.Err(); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "gif.encode", err)
+	}
+
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "gif.encode", err)
+	}
+	frames := []image.Image{src}
+	var anim *core.Animation
+	if a, ok := src.(*core.Animation); ok && len(a.Frames) > 0 {
+		anim, frames = a, a.Frames
+	}
+
+	transparent := false
+	for _, f := range frames {
+		if hasTransparency(f) {
+			transparent = true
+			break
+		}
+	}
+	size := 256
+	if transparent {
+		size--
+	}
+	pal := utils.MedianCutPalette(size, frames...)
+	if len(pal) == 0 {
+		pal = color.Palette{color.Black}
+	}
+	if transparent {
+		pal = append(pal, color.Transparent)
+	}
+
+	disposal := byte(gif.DisposalNone)
+	if transparent {
+		// Frames cover the whole canvas; clear it so transparent areas do
+		// not show the previous frame.
+		disposal = gif.DisposalBackground
+	}
+	out := &gif.GIF{Config: image.Config{ColorModel: pal}}
+	for i, f := range frames {
+		if i%8 == 0 {
+			if err := ctx.Err(); err != nil {
+				return apperrors.Wrap(apperrors.CategoryEncode, "gif.encode", err)
+			}
+		}
+		b := f.Bounds()
+		pm := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), pal)
+		draw.FloydSteinberg.Draw(pm, pm.Rect, f, b.Min)
+		out.Image = append(out.Image, pm)
+		out.Disposal = append(out.Disposal, disposal)
+		delay := 0
+		if anim != nil {
+			delay = int((anim.Delay(i) + 5*time.Millisecond) / (10 * time.Millisecond))
+		}
+		out.Delay = append(out.Delay, delay)
+		out.Config.Width = max(out.Config.Width, b.Dx())
+		out.Config.Height = max(out.Config.Height, b.Dy())
+	}
+	if anim != nil {
+		// GIF counts repeats after the first play; -1 plays once.
+		switch anim.LoopCount {
+		case 0:
+			out.LoopCount = 0
+		case 1:
+			out.LoopCount = -1
+		default:
+			out.LoopCount = anim.LoopCount - 1
+		}
+	}
+
+	if err := gif.EncodeAll(w, out); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "gif.encode", err)
+	}
+	return nil
+}
+
+// hasTransparency reports whether any pixel of img is less than half
+// opaque.
+func hasTransparency(img image.Image) bool {
+	if o, ok := img.(interface{ Opaque() bool }); ok && o.Opaque() {
+		return false
+	}
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a < 0x8000 {
+				return true
+			}
+		}
+	}
+	return false
+}
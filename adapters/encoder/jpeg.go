@@ -5,6 +5,7 @@ import (
 	"context"
 	"image"
 	"image/jpeg"
+	"io"
 
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
@@ -27,23 +28,46 @@ func (j *JPEG) CanEncode(format core.Format) bool {
 }
 
 func (j *JPEG) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeOptions) ([]byte, error) {
-	if err := ctx.Err(); err != nil {
-		return nil, apperrors.Wrap(apperrors.CategoryEncode, "jpeg.encode", err)
+	var buf bytes.Buffer
+	if err := j.EncodeTo(ctx, &buf, img, opts); err != nil {
+		return nil, err
 	}
+	return buf.Bytes(), nil
+}
 
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryEncode, "jpeg.encode", apperrors.ErrEmptyInput)
+// EncodeTo implements core.StreamEncoder.
+func (j *JPEG) EncodeTo(ctx context.Context, w io.Writer, img *core.ImageData, opts core.EncodeOptions) error {
+	if err := ctx.Err(); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "jpeg.encode", err)
 	}
 
-	quality := opts.Quality
-	if quality <= 0 {
-		quality = j.DefaultQuality
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "jpeg.encode", err)
 	}
 
-	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality}); err != nil {
-		return nil, apperrors.Wrap(apperrors.CategoryEncode, "jpeg.encode", err)
+	quality := opts.QualityOr(j.DefaultQuality)
+
+	jo := opts.JPEG()
+	subsample := jo.Subsample
+	_, isCMYK := src.(*image.CMYK)
+	keepCMYK := jo.KeepCMYK && isCMYK
+	if opts.Interlaced || keepCMYK || subsample == core.Subsample444 || subsample == core.Subsample422 {
+		// image/jpeg only writes baseline 4:2:0 YCbCr; use the built-in writer.
+		p := jpegParams{Quality: quality, H: 2, V: 2, Progressive: opts.Interlaced, CMYK: keepCMYK}
+		switch subsample {
+		case core.Subsample444:
+			p.H, p.V = 1, 1
+		case core.Subsample422:
+			p.V = 1
+		}
+		if err := encodeJPEG(w, src, p); err != nil {
+			return apperrors.Wrap(apperrors.CategoryEncode, "jpeg.encode", err)
+		}
+		return nil
 	}
-	return buf.Bytes(), nil
+	if err := jpeg.Encode(w, src, &jpeg.Options{Quality: quality}); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "jpeg.encode", err)
+	}
+	return nil
 }
\ No newline at end of file
@@ -0,0 +1,529 @@
+package encoder
+
+import (
+	"bufio"
+	"image"
+	"image/color"
+	"io"
+	"math"
+)
+
+// jpegWriter is a small JPEG encoder for the cases image/jpeg cannot express:
+// image/jpeg always subsamples chroma 4:2:0 and only writes baseline files.
+// It writes standard Annex K quantisation and Huffman tables, so its output
+// decodes everywhere.
+
+// jpegParams selects the encoding layout.
+type jpegParams struct {
+	Quality int
+	// Luma sampling factors; chroma is always 1×1.  (1,1) = 4:4:4,
+	// (2,1) = 4:2:2, (2,2) = 4:2:0.
+	H, V int
+	// Progressive writes SOF2 with a spectral-selection scan script: all DC
+	// coefficients first, then low and high AC bands per component.
+	Progressive bool
+	// CMYK writes *image.CMYK sources as four unsubsampled channels tagged
+	// with an Adobe APP14 marker, instead of converting them to YCbCr.
+	CMYK bool
+}
+
+// progressiveBands are the AC spectral bands written per component, after
+// the interleaved DC scan.
+var progressiveBands = [][2]int{{1, 5}, {6, 63}}
+
+// unzig maps a zig-zag index to its natural (row-major) block index.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// Annex K.1 base quantisation tables in natural order.
+var baseQuant = [2][64]int{
+	{
+		16, 11, 10, 16, 24, 40, 51, 61,
+		12, 12, 14, 19, 26, 58, 60, 55,
+		14, 13, 16, 24, 40, 57, 69, 56,
+		14, 17, 22, 29, 51, 87, 80, 62,
+		18, 22, 37, 56, 68, 109, 103, 77,
+		24, 35, 55, 64, 81, 104, 113, 92,
+		49, 64, 78, 87, 103, 121, 120, 101,
+		72, 92, 95, 98, 112, 100, 103, 99,
+	},
+	{
+		17, 18, 24, 47, 99, 99, 99, 99,
+		18, 21, 26, 66, 99, 99, 99, 99,
+		24, 26, 56, 99, 99, 99, 99, 99,
+		47, 66, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+		99, 99, 99, 99, 99, 99, 99, 99,
+	},
+}
+
+// huffSpec is a Huffman table in DHT form: code counts per length and values.
+type huffSpec struct {
+	class, id uint8
+	counts    [16]uint8
+	values    []uint8
+}
+
+// Annex K.3 standard Huffman tables: luma DC, luma AC, chroma DC, chroma AC.
+var huffSpecs = [4]huffSpec{
+	{0, 0, [16]uint8{0, 1, 5, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0, 0, 0},
+		[]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{1, 0, [16]uint8{0, 2, 1, 3, 3, 2, 4, 3, 5, 5, 4, 4, 0, 0, 1, 125},
+		[]uint8{
+			0x01, 0x02, 0x03, 0x00, 0x04, 0x11, 0x05, 0x12,
+			0x21, 0x31, 0x41, 0x06, 0x13, 0x51, 0x61, 0x07,
+			0x22, 0x71, 0x14, 0x32, 0x81, 0x91, 0xa1, 0x08,
+			0x23, 0x42, 0xb1, 0xc1, 0x15, 0x52, 0xd1, 0xf0,
+			0x24, 0x33, 0x62, 0x72, 0x82, 0x09, 0x0a, 0x16,
+			0x17, 0x18, 0x19, 0x1a, 0x25, 0x26, 0x27, 0x28,
+			0x29, 0x2a, 0x34, 0x35, 0x36, 0x37, 0x38, 0x39,
+			0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48, 0x49,
+			0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58, 0x59,
+			0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68, 0x69,
+			0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78, 0x79,
+			0x7a, 0x83, 0x84, 0x85, 0x86, 0x87, 0x88, 0x89,
+			0x8a, 0x92, 0x93, 0x94, 0x95, 0x96, 0x97, 0x98,
+			0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5, 0xa6, 0xa7,
+			0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4, 0xb5, 0xb6,
+			0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3, 0xc4, 0xc5,
+			0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2, 0xd3, 0xd4,
+			0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda, 0xe1, 0xe2,
+			0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9, 0xea,
+			0xf1, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		}},
+	{0, 1, [16]uint8{0, 3, 1, 1, 1, 1, 1, 1, 1, 1, 1, 0, 0, 0, 0, 0},
+		[]uint8{0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11}},
+	{1, 1, [16]uint8{0, 2, 1, 2, 4, 4, 3, 4, 7, 5, 4, 4, 0, 1, 2, 119},
+		[]uint8{
+			0x00, 0x01, 0x02, 0x03, 0x11, 0x04, 0x05, 0x21,
+			0x31, 0x06, 0x12, 0x41, 0x51, 0x07, 0x61, 0x71,
+			0x13, 0x22, 0x32, 0x81, 0x08, 0x14, 0x42, 0x91,
+			0xa1, 0xb1, 0xc1, 0x09, 0x23, 0x33, 0x52, 0xf0,
+			0x15, 0x62, 0x72, 0xd1, 0x0a, 0x16, 0x24, 0x34,
+			0xe1, 0x25, 0xf1, 0x17, 0x18, 0x19, 0x1a, 0x26,
+			0x27, 0x28, 0x29, 0x2a, 0x35, 0x36, 0x37, 0x38,
+			0x39, 0x3a, 0x43, 0x44, 0x45, 0x46, 0x47, 0x48,
+			0x49, 0x4a, 0x53, 0x54, 0x55, 0x56, 0x57, 0x58,
+			0x59, 0x5a, 0x63, 0x64, 0x65, 0x66, 0x67, 0x68,
+			0x69, 0x6a, 0x73, 0x74, 0x75, 0x76, 0x77, 0x78,
+			0x79, 0x7a, 0x82, 0x83, 0x84, 0x85, 0x86, 0x87,
+			0x88, 0x89, 0x8a, 0x92, 0x93, 0x94, 0x95, 0x96,
+			0x97, 0x98, 0x99, 0x9a, 0xa2, 0xa3, 0xa4, 0xa5,
+			0xa6, 0xa7, 0xa8, 0xa9, 0xaa, 0xb2, 0xb3, 0xb4,
+			0xb5, 0xb6, 0xb7, 0xb8, 0xb9, 0xba, 0xc2, 0xc3,
+			0xc4, 0xc5, 0xc6, 0xc7, 0xc8, 0xc9, 0xca, 0xd2,
+			0xd3, 0xd4, 0xd5, 0xd6, 0xd7, 0xd8, 0xd9, 0xda,
+			0xe2, 0xe3, 0xe4, 0xe5, 0xe6, 0xe7, 0xe8, 0xe9,
+			0xea, 0xf2, 0xf3, 0xf4, 0xf5, 0xf6, 0xf7, 0xf8,
+			0xf9, 0xfa,
+		}},
+}
+
+// huffCode is a single Huffman code: the low `size` bits of code.
+type huffCode struct {
+	code uint32
+	size uint8
+}
+
+// huffTables holds the encoding lookup for each of huffSpecs.
+var huffTables = func() (t [4][256]huffCode) {
+	for i, spec := range huffSpecs {
+		code, k := uint32(0), 0
+		for l, n := range spec.counts {
+			for j := 0; j < int(n); j++ {
+				t[i][spec.values[k]] = huffCode{code: code, size: uint8(l + 1)}
+				code++
+				k++
+			}
+			code <<= 1
+		}
+	}
+	return t
+}()
+
+// dctCos[x][u] = C(u)/2 · cos((2x+1)uπ/16), the orthonormal 1-D DCT basis.
+var dctCos = func() (c [8][8]float64) {
+	for x := 0; x < 8; x++ {
+		for u := 0; u < 8; u++ {
+			cu := 1.0
+			if u == 0 {
+				cu = 1 / math.Sqrt2
+			}
+			c[x][u] = cu / 2 * math.Cos(float64(2*x+1)*float64(u)*math.Pi/16)
+		}
+	}
+	return c
+}()
+
+// component is one colour plane prepared for block encoding.
+type component struct {
+	id     uint8
+	h, v   int // sampling factors
+	tq     int // quantisation / Huffman table index (0 luma, 1 chroma)
+	stride int
+	pix    []uint8 // padded to a whole number of MCUs
+
+	width, height int // unpadded size, used by non-interleaved scans
+}
+
+type jpegWriter struct {
+	w     *bufio.Writer
+	err   error
+	quant [2][64]int // natural order
+	bits  uint32
+	nbits uint
+}
+
+// encodeJPEG writes src as a baseline or progressive JPEG using p.
+func encodeJPEG(dst io.Writer, src image.Image, p jpegParams) error {
+	jw := &jpegWriter{w: bufio.NewWriter(dst)}
+	jw.setQuality(p.Quality)
+	b := src.Bounds()
+	var comps []*component
+	cmyk, isCMYK := src.(*image.CMYK)
+	if p.CMYK && isCMYK {
+		comps = cmykComponents(cmyk)
+	} else {
+		comps = jpegComponents(src, p.H, p.V)
+	}
+
+	jw.write([]byte{0xFF, 0xD8}) // SOI
+	if len(comps) == 4 {
+		jw.writeAdobe()
+	}
+	jw.writeDQT(len(comps))
+	if p.Progressive {
+		jw.writeSOF(0xC2, b, comps)
+	} else {
+		jw.writeSOF(0xC0, b, comps)
+	}
+	jw.writeDHT(len(comps))
+	if p.Progressive {
+		// AC scans may only hold one component (T.81 G.1.1.1.1).
+		jw.writeSOS(comps, 0, 0)
+		jw.encodeScan(comps, b, 0, 0)
+		for _, c := range comps {
+			for _, band := range progressiveBands {
+				jw.writeSOS([]*component{c}, byte(band[0]), byte(band[1]))
+				jw.encodeScan([]*component{c}, b, band[0], band[1])
+			}
+		}
+	} else {
+		jw.writeSOS(comps, 0, 63)
+		jw.encodeScan(comps, b, 0, 63)
+	}
+	jw.write([]byte{0xFF, 0xD9}) // EOI
+	if jw.err != nil {
+		return jw.err
+	}
+	return jw.w.Flush()
+}
+
+func (jw *jpegWriter) setQuality(q int) {
+	q = max(1, min(q, 100))
+	scale := 200 - 2*q
+	if q < 50 {
+		scale = 5000 / q
+	}
+	for t := range baseQuant {
+		for i, b := range baseQuant[t] {
+			jw.quant[t][i] = max(1, min((b*scale+50)/100, 255))
+		}
+	}
+}
+
+// jpegComponents converts src into Y(CbCr) planes padded to whole MCUs.
+// Grayscale sources produce a single luma component.
+func jpegComponents(src image.Image, h, v int) []*component {
+	b := src.Bounds()
+	w, ht := b.Dx(), b.Dy()
+	if _, gray := src.(*image.Gray); gray {
+		h, v = 1, 1
+	}
+	mcuW, mcuH := 8*h, 8*v
+	pw, ph := (w+mcuW-1)/mcuW*mcuW, (ht+mcuH-1)/mcuH*mcuH
+
+	y := &component{id: 1, h: h, v: v, stride: pw, pix: make([]uint8, pw*ph), width: w, height: ht}
+	if g, ok := src.(*image.Gray); ok {
+		for py := 0; py < ph; py++ {
+			sy := min(py, ht-1)
+			for px := 0; px < pw; px++ {
+				y.pix[py*pw+px] = g.GrayAt(b.Min.X+min(px, w-1), b.Min.Y+sy).Y
+			}
+		}
+		return []*component{y}
+	}
+
+	// Full-resolution chroma, then box-filter down by (h, v).
+	cb := make([]uint8, pw*ph)
+	cr := make([]uint8, pw*ph)
+	for py := 0; py < ph; py++ {
+		sy := min(py, ht-1)
+		for px := 0; px < pw; px++ {
+			r, g, bl, _ := src.At(b.Min.X+min(px, w-1), b.Min.Y+sy).RGBA()
+			i := py*pw + px
+			y.pix[i], cb[i], cr[i] = color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(bl>>8))
+		}
+	}
+	cw, ch := pw/h, ph/v
+	mk := func(id uint8, full []uint8) *component {
+		c := &component{id: id, h: 1, v: 1, tq: 1, stride: cw, pix: make([]uint8, cw*ch),
+			width: (w + h - 1) / h, height: (ht + v - 1) / v}
+		for cy := 0; cy < ch; cy++ {
+			for cx := 0; cx < cw; cx++ {
+				sum := 0
+				for dy := 0; dy < v; dy++ {
+					for dx := 0; dx < h; dx++ {
+						sum += int(full[(cy*v+dy)*pw+cx*h+dx])
+					}
+				}
+				c.pix[cy*cw+cx] = uint8((sum + h*v/2) / (h * v))
+			}
+		}
+		return c
+	}
+	return []*component{y, mk(2, cb), mk(3, cr)}
+}
+
+// cmykComponents splits src into four full-resolution planes.  Adobe CMYK
+// JPEGs store inverted ink values, which is what decoders (including
+// image/jpeg) expect when the APP14 transform flag is 0.
+func cmykComponents(src *image.CMYK) []*component {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	pw, ph := (w+7)/8*8, (h+7)/8*8
+	comps := make([]*component, 4)
+	for i := range comps {
+		comps[i] = &component{id: uint8(i + 1), h: 1, v: 1, stride: pw,
+			pix: make([]uint8, pw*ph), width: w, height: h}
+	}
+	for py := 0; py < ph; py++ {
+		sy := b.Min.Y + min(py, h-1)
+		for px := 0; px < pw; px++ {
+			o := src.PixOffset(b.Min.X+min(px, w-1), sy)
+			for i, c := range comps {
+				c.pix[py*pw+px] = 255 - src.Pix[o+i]
+			}
+		}
+	}
+	return comps
+}
+
+// ── markers ───────────────────────────────────────────────────────────────────
+
+func (jw *jpegWriter) write(p []byte) {
+	if jw.err == nil {
+		_, jw.err = jw.w.Write(p)
+	}
+}
+
+func (jw *jpegWriter) marker(m byte, length int) {
+	jw.write([]byte{0xFF, m, byte(length >> 8), byte(length)})
+}
+
+// writeAdobe writes the APP14 "Adobe" segment with transform 0 (no colour
+// transform), which marks a four-channel image as CMYK.
+func (jw *jpegWriter) writeAdobe() {
+	jw.marker(0xEE, 14)
+	jw.write([]byte{'A', 'd', 'o', 'b', 'e', 0, 100, 0, 0, 0, 0, 0})
+}
+
+func (jw *jpegWriter) writeDQT(ncomp int) {
+	tables := min(ncomp, 2)
+	jw.marker(0xDB, 2+65*tables)
+	for t := 0; t < tables; t++ {
+		buf := make([]byte, 65)
+		buf[0] = byte(t)
+		for zz := 0; zz < 64; zz++ {
+			buf[1+zz] = byte(jw.quant[t][unzig[zz]])
+		}
+		jw.write(buf)
+	}
+}
+
+func (jw *jpegWriter) writeSOF(m byte, b image.Rectangle, comps []*component) {
+	jw.marker(m, 8+3*len(comps))
+	jw.write([]byte{8, byte(b.Dy() >> 8), byte(b.Dy()), byte(b.Dx() >> 8), byte(b.Dx()), byte(len(comps))})
+	for _, c := range comps {
+		jw.write([]byte{c.id, byte(c.h<<4 | c.v), byte(c.tq)})
+	}
+}
+
+func (jw *jpegWriter) writeDHT(ncomp int) {
+	specs := huffSpecs[:2]
+	if ncomp > 1 {
+		specs = huffSpecs[:]
+	}
+	length := 2
+	for _, s := range specs {
+		length += 17 + len(s.values)
+	}
+	jw.marker(0xC4, length)
+	for _, s := range specs {
+		jw.write([]byte{s.class<<4 | s.id})
+		jw.write(s.counts[:])
+		jw.write(s.values)
+	}
+}
+
+// writeSOS starts a scan over comps covering zig-zag coefficients ss..se.
+func (jw *jpegWriter) writeSOS(comps []*component, ss, se byte) {
+	jw.marker(0xDA, 6+2*len(comps))
+	jw.write([]byte{byte(len(comps))})
+	for _, c := range comps {
+		jw.write([]byte{c.id, byte(c.tq<<4 | c.tq)})
+	}
+	jw.write([]byte{ss, se, 0})
+}
+
+// ── entropy coding ────────────────────────────────────────────────────────────
+
+// emit appends the low n bits of bits to the stream, stuffing 0x00 after 0xFF.
+func (jw *jpegWriter) emit(bits uint32, n uint) {
+	jw.bits = jw.bits<<n | bits&(1<<n-1)
+	jw.nbits += n
+	for jw.nbits >= 8 {
+		b := byte(jw.bits >> (jw.nbits - 8))
+		jw.nbits -= 8
+		if b == 0xFF {
+			jw.write([]byte{0xFF, 0x00})
+		} else {
+			jw.write([]byte{b})
+		}
+	}
+	jw.bits &= 1<<jw.nbits - 1
+}
+
+// flushBits pads the final partial byte with 1s as required by T.81.
+func (jw *jpegWriter) flushBits() {
+	if jw.nbits > 0 {
+		jw.emit(1<<(8-jw.nbits)-1, 8-jw.nbits)
+	}
+}
+
+func (jw *jpegWriter) emitHuff(table int, sym uint8) {
+	c := huffTables[table][sym]
+	jw.emit(c.code, uint(c.size))
+}
+
+// emitValue writes the Huffman symbol (run<<4 | category) followed by the
+// category's magnitude bits for v.
+func (jw *jpegWriter) emitValue(table int, run uint8, v int) {
+	a, bits := v, v
+	if a < 0 {
+		a, bits = -v, v-1
+	}
+	n := uint8(0)
+	for a > 0 {
+		n++
+		a >>= 1
+	}
+	jw.emitHuff(table, run<<4|n)
+	if n > 0 {
+		jw.emit(uint32(bits), uint(n))
+	}
+}
+
+// quantBlock performs the forward DCT on the 8×8 block at (bx, by) of c and
+// returns the quantised coefficients in zig-zag order.
+func (jw *jpegWriter) quantBlock(c *component, bx, by int) (out [64]int) {
+	var tmp, coef [64]float64
+	for y := 0; y < 8; y++ {
+		row := c.pix[(by*8+y)*c.stride+bx*8:]
+		for u := 0; u < 8; u++ {
+			s := 0.0
+			for x := 0; x < 8; x++ {
+				s += (float64(row[x]) - 128) * dctCos[x][u]
+			}
+			tmp[y*8+u] = s
+		}
+	}
+	for u := 0; u < 8; u++ {
+		for v := 0; v < 8; v++ {
+			s := 0.0
+			for y := 0; y < 8; y++ {
+				s += tmp[y*8+u] * dctCos[y][v]
+			}
+			coef[v*8+u] = s
+		}
+	}
+	q := &jw.quant[c.tq]
+	for zz := 0; zz < 64; zz++ {
+		n := unzig[zz]
+		out[zz] = int(math.Round(coef[n] / float64(q[n])))
+	}
+	return out
+}
+
+// encodeBlock writes coefficients ss..se of a quantised block.  The DC term is
+// coded as a difference against *prevDC when ss == 0.
+func (jw *jpegWriter) encodeBlock(c *component, blk *[64]int, prevDC *int, ss, se int) {
+	dcTable, acTable := 2*c.tq, 2*c.tq+1
+	if ss == 0 {
+		jw.emitValue(dcTable, 0, blk[0]-*prevDC)
+		*prevDC = blk[0]
+		ss = 1
+	}
+	run := uint8(0)
+	for k := ss; k <= se; k++ {
+		if blk[k] == 0 {
+			run++
+			continue
+		}
+		for run > 15 {
+			jw.emitHuff(acTable, 0xF0) // ZRL
+			run -= 16
+		}
+		jw.emitValue(acTable, run, blk[k])
+		run = 0
+	}
+	if run > 0 && se >= ss {
+		jw.emitHuff(acTable, 0x00) // EOB
+	}
+}
+
+// encodeScan writes coefficients ss..se of comps as one scan.  Several
+// components are interleaved MCU by MCU; a single component is coded block by
+// block over its own (unpadded) extent.
+func (jw *jpegWriter) encodeScan(comps []*component, b image.Rectangle, ss, se int) {
+	prevDC := make([]int, len(comps))
+	if len(comps) == 1 {
+		c := comps[0]
+		for by := 0; by < (c.height+7)/8; by++ {
+			for bx := 0; bx < (c.width+7)/8; bx++ {
+				blk := jw.quantBlock(c, bx, by)
+				jw.encodeBlock(c, &blk, &prevDC[0], ss, se)
+			}
+		}
+		jw.flushBits()
+		return
+	}
+
+	hmax, vmax := comps[0].h, comps[0].v
+	mcusX := (b.Dx() + 8*hmax - 1) / (8 * hmax)
+	mcusY := (b.Dy() + 8*vmax - 1) / (8 * vmax)
+	for my := 0; my < mcusY; my++ {
+		for mx := 0; mx < mcusX; mx++ {
+			for i, c := range comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						blk := jw.quantBlock(c, mx*c.h+h, my*c.v+v)
+						jw.encodeBlock(c, &blk, &prevDC[i], ss, se)
+					}
+				}
+			}
+		}
+	}
+	jw.flushBits()
+}
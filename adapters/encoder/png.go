@@ -5,9 +5,11 @@ import (
 	"context"
 	"image"
 	"image/png"
+	"io"
 
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
 )
 
 // PNG encodes images to PNG format.
@@ -18,13 +20,37 @@ func NewPNG() *PNG { return &PNG{} }
 func (p *PNG) CanEncode(format core.Format) bool { return format == core.FormatPNG }
 
 func (p *PNG) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.EncodeTo(ctx, &buf, img, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeTo implements core.StreamEncoder.
+func (p *PNG) EncodeTo(ctx context.Context, w io.Writer, img *core.ImageData, opts core.EncodeOptions) error {
 	if err := ctx.Err(); err != nil {
-		return nil, apperrors.Wrap(apperrors.CategoryEncode, "png.encode", err)
+		return apperrors.Wrap(apperrors.CategoryEncode, "png.encode", err)
+	}
+
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "png.encode", err)
 	}
 
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryEncode, "png.encode", apperrors.ErrEmptyInput)
+	if po := opts.PNG(); po.Colors > 0 {
+		if pm, ok := src.(*image.Paletted); !ok || len(pm.Palette) > po.Colors {
+			src = utils.Quantize(src, po.Colors, po.Dither)
+		}
+	}
+
+	// The built-in writer honours every PNGOptions knob and Adam7 interlacing;
+	// 16-bit sources keep their depth and are never reduced.
+	if po := *opts.PNG(); po != (core.PNGOptions{}) || opts.Interlaced {
+		if err := encodePNG(w, src, po, opts.Interlaced); err != nil {
+			return apperrors.Wrap(apperrors.CategoryEncode, "png.encode", err)
+		}
+		return nil
 	}
 
 	enc := &png.Encoder{}
@@ -33,13 +59,17 @@ func (p *PNG) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeO
 	} else {
 		enc.CompressionLevel = png.DefaultCompression
 	}
-	if opts.Interlaced {
-		enc.CompressionLevel = png.BestCompression // closest approximation
+
+	if err := enc.Encode(w, src); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "png.encode", err)
 	}
+	return nil
+}
 
-	var buf bytes.Buffer
-	if err := enc.Encode(&buf, src); err != nil {
-		return nil, apperrors.Wrap(apperrors.CategoryEncode, "png.encode", err)
+func is16Bit(img image.Image) bool {
+	switch img.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		return true
 	}
-	return buf.Bytes(), nil
-}
\ No newline at end of file
+	return false
+}
@@ -0,0 +1,534 @@
+package encoder
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"io"
+	"sort"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// pngWriter is a small PNG encoder for the options image/png does not
+// expose: explicit row filters, exact zlib levels, colour-type reduction and
+// Adam7 interlacing.  It writes 8-bit samples, or 16-bit ones for 16-bit
+// sources, which it never reduces.
+// It never writes ancillary chunks, so metadata is always stripped.
+
+// PNG colour types (PNG spec §11.2.2).
+const (
+	pngGray      = 0
+	pngRGB       = 2
+	pngPalette   = 3
+	pngGrayAlpha = 4
+	pngRGBA      = 6
+)
+
+// PNG row filter types (PNG spec §9.2).
+const (
+	pngFilterNone = iota
+	pngFilterSub
+	pngFilterUp
+	pngFilterAverage
+	pngFilterPaeth
+)
+
+// adam7 lists the interlace passes as x0, y0, dx, dy (PNG spec §8.2).
+var adam7 = [7][4]int{
+	{0, 0, 8, 8}, {4, 0, 8, 8}, {0, 4, 4, 8}, {2, 0, 4, 4},
+	{0, 2, 2, 4}, {1, 0, 2, 2}, {0, 1, 1, 2},
+}
+
+// adam7Pass gathers the pixels of one interlace pass into a reduced image,
+// or returns nil when the pass is empty.  Each pass is filtered as an image
+// of its own.
+func adam7Pass(pix *image.NRGBA, p [4]int) *image.NRGBA {
+	b := pix.Bounds()
+	w := (b.Dx() - p[0] + p[2] - 1) / p[2]
+	h := (b.Dy() - p[1] + p[3] - 1) / p[3]
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	sub := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			si := (p[1]+y*p[3])*pix.Stride + (p[0]+x*p[2])*4
+			copy(sub.Pix[y*sub.Stride+x*4:y*sub.Stride+x*4+4], pix.Pix[si:si+4])
+		}
+	}
+	return sub
+}
+
+// adam7Pass64 is adam7Pass for 16-bit pixels.
+func adam7Pass64(pix *image.NRGBA64, p [4]int) *image.NRGBA64 {
+	b := pix.Bounds()
+	w := (b.Dx() - p[0] + p[2] - 1) / p[2]
+	h := (b.Dy() - p[1] + p[3] - 1) / p[3]
+	if w <= 0 || h <= 0 {
+		return nil
+	}
+	sub := image.NewNRGBA64(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			si := (p[1]+y*p[3])*pix.Stride + (p[0]+x*p[2])*8
+			copy(sub.Pix[y*sub.Stride+x*8:y*sub.Stride+x*8+8], pix.Pix[si:si+8])
+		}
+	}
+	return sub
+}
+
+// pngLayout is the colour type and bit depth chosen for an image.
+type pngLayout struct {
+	colorType int
+	depth     int
+	palette   color.Palette // pngPalette only
+}
+
+// encodePNG writes src as a PNG using opts, Adam7-interlaced when interlace
+// is set.
+func encodePNG(dst io.Writer, src image.Image, opts core.PNGOptions, interlace bool) error {
+	if is16Bit(src) {
+		return encodePNG16(dst, src, opts, interlace)
+	}
+	pix := toNRGBA(src)
+	layout := choosePNGLayout(src, pix, opts.Reduce)
+	filter := pngFilterFor(opts.Filter, layout)
+	return writePNGFile(dst, pix.Bounds(), layout, opts.CompressionLevel, interlace, func(zw io.Writer) error {
+		if !interlace {
+			return writePNGRows(zw, pix, layout, filter)
+		}
+		for _, p := range adam7 {
+			if sub := adam7Pass(pix, p); sub != nil {
+				if err := writePNGRows(zw, sub, layout, filter); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// encodePNG16 writes a 16-bit source with 16-bit samples: gray for
+// *image.Gray16, RGB when opaque and RGBA otherwise.
+func encodePNG16(dst io.Writer, src image.Image, opts core.PNGOptions, interlace bool) error {
+	pix := toNRGBA64(src)
+	layout := pngLayout{colorType: pngRGBA, depth: 16}
+	if _, ok := src.(*image.Gray16); ok {
+		layout.colorType = pngGray
+	} else if isOpaque64(pix) {
+		layout.colorType = pngRGB
+	}
+	filter := pngFilterFor(opts.Filter, layout)
+	return writePNGFile(dst, pix.Bounds(), layout, opts.CompressionLevel, interlace, func(zw io.Writer) error {
+		if !interlace {
+			return writePNGRows64(zw, pix, layout, filter)
+		}
+		for _, p := range adam7 {
+			if sub := adam7Pass64(pix, p); sub != nil {
+				if err := writePNGRows64(zw, sub, layout, filter); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	})
+}
+
+// writePNGFile writes the signature and chunks of a PNG of the given size
+// and layout; rows writes the uncompressed scanline data.
+func writePNGFile(dst io.Writer, b image.Rectangle, layout pngLayout, level int, interlace bool, rows func(zw io.Writer) error) error {
+	bw := bufio.NewWriter(dst)
+	if _, err := bw.WriteString("\x89PNG\r\n\x1a\n"); err != nil {
+		return err
+	}
+
+	ihdr := make([]byte, 13)
+	binary.BigEndian.PutUint32(ihdr[0:], uint32(b.Dx()))
+	binary.BigEndian.PutUint32(ihdr[4:], uint32(b.Dy()))
+	ihdr[8], ihdr[9] = byte(layout.depth), byte(layout.colorType)
+	if interlace {
+		ihdr[12] = 1
+	}
+	if err := writePNGChunk(bw, "IHDR", ihdr); err != nil {
+		return err
+	}
+
+	if layout.colorType == pngPalette {
+		plte := make([]byte, 0, 3*len(layout.palette))
+		trns := make([]byte, 0, len(layout.palette))
+		for _, c := range layout.palette {
+			n := c.(color.NRGBA)
+			plte = append(plte, n.R, n.G, n.B)
+			trns = append(trns, n.A)
+		}
+		if err := writePNGChunk(bw, "PLTE", plte); err != nil {
+			return err
+		}
+		for len(trns) > 0 && trns[len(trns)-1] == 0xff {
+			trns = trns[:len(trns)-1]
+		}
+		if len(trns) > 0 {
+			if err := writePNGChunk(bw, "tRNS", trns); err != nil {
+				return err
+			}
+		}
+	}
+
+	if level <= 0 {
+		level = zlib.DefaultCompression
+	}
+	var idat bytes.Buffer
+	zw, err := zlib.NewWriterLevel(&idat, min(level, zlib.BestCompression))
+	if err != nil {
+		return err
+	}
+	if err := rows(zw); err != nil {
+		return err
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+	if err := writePNGChunk(bw, "IDAT", idat.Bytes()); err != nil {
+		return err
+	}
+	if err := writePNGChunk(bw, "IEND", nil); err != nil {
+		return err
+	}
+	return bw.Flush()
+}
+
+func writePNGChunk(w io.Writer, name string, data []byte) error {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], name)
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+	var tail [4]byte
+	binary.BigEndian.PutUint32(tail[:], crc.Sum32())
+	for _, p := range [][]byte{hdr[:], data, tail[:]} {
+		if _, err := w.Write(p); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// pngFilterFor maps the requested strategy to a fixed filter, or -1 for the
+// adaptive per-row heuristic.  Sub-byte and palette images filter poorly, so
+// the adaptive default uses None for them, as libpng does.
+func pngFilterFor(f core.PNGFilter, layout pngLayout) int {
+	switch f {
+	case core.PNGFilterNone:
+		return pngFilterNone
+	case core.PNGFilterSub:
+		return pngFilterSub
+	case core.PNGFilterUp:
+		return pngFilterUp
+	case core.PNGFilterAverage:
+		return pngFilterAverage
+	case core.PNGFilterPaeth:
+		return pngFilterPaeth
+	}
+	if layout.colorType == pngPalette || layout.depth < 8 {
+		return pngFilterNone
+	}
+	return -1
+}
+
+// toNRGBA returns src as straight-alpha 8-bit pixels with an origin at 0,0.
+func toNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	if n, ok := src.(*image.NRGBA); ok && b.Min == (image.Point{}) {
+		return n
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dst.SetNRGBA(x, y, color.NRGBAModel.Convert(src.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA))
+		}
+	}
+	return dst
+}
+
+// toNRGBA64 returns src as straight-alpha 16-bit pixels with an origin at
+// 0,0.
+func toNRGBA64(src image.Image) *image.NRGBA64 {
+	b := src.Bounds()
+	if n, ok := src.(*image.NRGBA64); ok && b.Min == (image.Point{}) {
+		return n
+	}
+	dst := image.NewNRGBA64(image.Rect(0, 0, b.Dx(), b.Dy()))
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			dst.SetNRGBA64(x, y, color.NRGBA64Model.Convert(src.At(b.Min.X+x, b.Min.Y+y)).(color.NRGBA64))
+		}
+	}
+	return dst
+}
+
+func isOpaque64(pix *image.NRGBA64) bool {
+	for i := 6; i < len(pix.Pix); i += 8 {
+		if pix.Pix[i] != 0xff || pix.Pix[i+1] != 0xff {
+			return false
+		}
+	}
+	return true
+}
+
+// choosePNGLayout picks the colour type and bit depth.  Without reduce it
+// mirrors image/png: gray stays gray, paletted stays paletted, opaque images
+// drop alpha.  With reduce it picks the smallest lossless representation.
+func choosePNGLayout(src image.Image, pix *image.NRGBA, reduce bool) pngLayout {
+	opaque, gray := true, true
+	seen := make(map[color.NRGBA]struct{}, 257)
+	for i := 0; i < len(pix.Pix); i += 4 {
+		p := pix.Pix[i : i+4 : i+4]
+		if p[3] != 0xff {
+			opaque = false
+		}
+		if p[0] != p[1] || p[1] != p[2] {
+			gray = false
+		}
+		if len(seen) <= 256 {
+			seen[color.NRGBA{p[0], p[1], p[2], p[3]}] = struct{}{}
+		}
+	}
+
+	if !reduce {
+		switch s := src.(type) {
+		case *image.Gray:
+			return pngLayout{colorType: pngGray, depth: 8}
+		case *image.Paletted:
+			pal := make(color.Palette, len(s.Palette))
+			for i, c := range s.Palette {
+				pal[i] = color.NRGBAModel.Convert(c)
+			}
+			return pngLayout{colorType: pngPalette, depth: 8, palette: pal}
+		}
+		if opaque {
+			return pngLayout{colorType: pngRGB, depth: 8}
+		}
+		return pngLayout{colorType: pngRGBA, depth: 8}
+	}
+
+	if gray && opaque {
+		return pngLayout{colorType: pngGray, depth: minGrayDepth(seen)}
+	}
+	if len(seen) <= 256 {
+		pal := make(color.Palette, 0, len(seen))
+		for c := range seen {
+			pal = append(pal, c)
+		}
+		sortPalette(pal)
+		depth := 8
+		switch {
+		case len(pal) <= 2:
+			depth = 1
+		case len(pal) <= 4:
+			depth = 2
+		case len(pal) <= 16:
+			depth = 4
+		}
+		return pngLayout{colorType: pngPalette, depth: depth, palette: pal}
+	}
+	switch {
+	case gray:
+		return pngLayout{colorType: pngGrayAlpha, depth: 8}
+	case opaque:
+		return pngLayout{colorType: pngRGB, depth: 8}
+	}
+	return pngLayout{colorType: pngRGBA, depth: 8}
+}
+
+// minGrayDepth returns the smallest bit depth that represents every gray
+// level in seen exactly (levels must be multiples of 255/(2^d-1)).
+func minGrayDepth(seen map[color.NRGBA]struct{}) int {
+	if len(seen) > 256 {
+		return 8
+	}
+	for _, d := range []int{1, 2, 4} {
+		step := 255 / (1<<d - 1)
+		ok := true
+		for c := range seen {
+			if int(c.R)%step != 0 {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return d
+		}
+	}
+	return 8
+}
+
+// sortPalette orders translucent entries first, so the tRNS chunk can stop at
+// the last one, then by colour value for deterministic output.
+func sortPalette(pal color.Palette) {
+	key := func(c color.Color) uint64 {
+		n := c.(color.NRGBA)
+		opaque := uint64(0)
+		if n.A == 0xff {
+			opaque = 1
+		}
+		return opaque<<32 | uint64(n.A)<<24 | uint64(n.R)<<16 | uint64(n.G)<<8 | uint64(n.B)
+	}
+	sort.Slice(pal, func(i, j int) bool { return key(pal[i]) < key(pal[j]) })
+}
+
+// packRow converts one row of pix into the raw PNG scanline for layout.
+func packRow(dst []byte, row []uint8, layout pngLayout, index map[color.NRGBA]byte) {
+	w := len(row) / 4
+	switch layout.colorType {
+	case pngRGBA:
+		copy(dst, row)
+	case pngRGB:
+		for x := 0; x < w; x++ {
+			copy(dst[x*3:x*3+3], row[x*4:x*4+3])
+		}
+	case pngGrayAlpha:
+		for x := 0; x < w; x++ {
+			dst[x*2], dst[x*2+1] = row[x*4], row[x*4+3]
+		}
+	case pngGray, pngPalette:
+		for i := range dst {
+			dst[i] = 0
+		}
+		perByte := 8 / layout.depth
+		for x := 0; x < w; x++ {
+			var v byte
+			if layout.colorType == pngGray {
+				v = row[x*4] / byte(255/(1<<layout.depth-1))
+			} else {
+				v = index[color.NRGBA{row[x*4], row[x*4+1], row[x*4+2], row[x*4+3]}]
+			}
+			shift := uint(8 - layout.depth*(x%perByte+1))
+			dst[x/perByte] |= v << shift
+		}
+	}
+}
+
+// writePNGRows filters and writes every scanline of pix to w.  filter is a
+// fixed filter type or -1 for the adaptive minimum-sum heuristic.
+func writePNGRows(w io.Writer, pix *image.NRGBA, layout pngLayout, filter int) error {
+	b := pix.Bounds()
+	var index map[color.NRGBA]byte
+	if layout.colorType == pngPalette {
+		index = make(map[color.NRGBA]byte, len(layout.palette))
+		for i, c := range layout.palette {
+			index[c.(color.NRGBA)] = byte(i)
+		}
+	}
+	return writeScanlines(w, b.Dx(), b.Dy(), layout, filter, func(dst []byte, y int) {
+		packRow(dst, pix.Pix[y*pix.Stride:y*pix.Stride+b.Dx()*4], layout, index)
+	})
+}
+
+// writePNGRows64 is writePNGRows for 16-bit pixels and layouts.
+func writePNGRows64(w io.Writer, pix *image.NRGBA64, layout pngLayout, filter int) error {
+	b := pix.Bounds()
+	return writeScanlines(w, b.Dx(), b.Dy(), layout, filter, func(dst []byte, y int) {
+		row := pix.Pix[y*pix.Stride : y*pix.Stride+b.Dx()*8]
+		switch layout.colorType {
+		case pngRGBA:
+			copy(dst, row)
+		case pngRGB:
+			for x := 0; x < b.Dx(); x++ {
+				copy(dst[x*6:x*6+6], row[x*8:x*8+6])
+			}
+		case pngGray:
+			for x := 0; x < b.Dx(); x++ {
+				copy(dst[x*2:x*2+2], row[x*8:x*8+2])
+			}
+		}
+	})
+}
+
+// writeScanlines filters and writes the height scanlines of a width-pixel
+// image in layout, each filled in by pack.
+func writeScanlines(w io.Writer, width, height int, layout pngLayout, filter int, pack func(dst []byte, y int)) error {
+	channels := map[int]int{pngGray: 1, pngRGB: 3, pngPalette: 1, pngGrayAlpha: 2, pngRGBA: 4}[layout.colorType]
+	bitsPerPixel := channels * layout.depth
+	bpp := max(1, bitsPerPixel/8) // filter byte distance
+	rowLen := (width*bitsPerPixel + 7) / 8
+
+	prev := make([]byte, rowLen)
+	cur := make([]byte, rowLen)
+	out := make([]byte, 1+rowLen)
+	best := make([]byte, 1+rowLen)
+	for y := 0; y < height; y++ {
+		pack(cur, y)
+		if filter >= 0 {
+			applyPNGFilter(out, cur, prev, bpp, filter)
+		} else {
+			bestSum := -1
+			for f := pngFilterNone; f <= pngFilterPaeth; f++ {
+				applyPNGFilter(out, cur, prev, bpp, f)
+				sum := 0
+				for _, v := range out[1:] {
+					sum += abs(int(int8(v)))
+				}
+				if bestSum < 0 || sum < bestSum {
+					bestSum = sum
+					copy(best, out)
+				}
+			}
+			copy(out, best)
+		}
+		if _, err := w.Write(out); err != nil {
+			return err
+		}
+		prev, cur = cur, prev
+	}
+	return nil
+}
+
+// applyPNGFilter writes the filter type byte followed by the filtered row.
+func applyPNGFilter(out, cur, prev []byte, bpp, f int) {
+	out[0] = byte(f)
+	o := out[1:]
+	for i := range cur {
+		var a, b, c byte
+		if i >= bpp {
+			a, c = cur[i-bpp], prev[i-bpp]
+		}
+		b = prev[i]
+		switch f {
+		case pngFilterNone:
+			o[i] = cur[i]
+		case pngFilterSub:
+			o[i] = cur[i] - a
+		case pngFilterUp:
+			o[i] = cur[i] - b
+		case pngFilterAverage:
+			o[i] = cur[i] - byte((int(a)+int(b))/2)
+		case pngFilterPaeth:
+			o[i] = cur[i] - paeth(a, b, c)
+		}
+	}
+}
+
+func paeth(a, b, c byte) byte {
+	p := int(a) + int(b) - int(c)
+	pa, pb, pc := abs(p-int(a)), abs(p-int(b)), abs(p-int(c))
+	if pa <= pb && pa <= pc {
+		return a
+	}
+	if pb <= pc {
+		return b
+	}
+	return c
+}
+
+func abs(v int) int {
+	if v < 0 {
+		return -v
+	}
+	return v
+}
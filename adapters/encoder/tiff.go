@@ -0,0 +1,48 @@
+package encoder
+
+import (
+	"bytes"
+	"context"
+	"io"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"golang.org/x/image/tiff"
+)
+
+// TIFF encodes images to single-page TIFF using golang.org/x/image/tiff.
+// TIFF output is always lossless; Compression defaults to Deflate with the
+// horizontal predictor, which suits scans and print masters.
+type TIFF struct {
+	Compression tiff.CompressionType
+}
+
+func NewTIFF() *TIFF { return &TIFF{Compression: tiff.Deflate} }
+
+func (t *TIFF) CanEncode(format core.Format) bool { return format == core.FormatTIFF }
+
+func (t *TIFF) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeOptions) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := t.EncodeTo(ctx, &buf, img, opts); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// EncodeTo implements core.StreamEncoder.
+func (t *TIFF) EncodeTo(ctx context.Context, w io.Writer, img *core.ImageData, _ core.EncodeOptions) error {
+	if err := ctx.Err(); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "tiff.encode", err)
+	}
+
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "tiff.encode", err)
+	}
+
+	opts := &tiff.Options{Compression: t.Compression, Predictor: t.Compression != tiff.Uncompressed}
+	if err := tiff.Encode(w, src, opts); err != nil {
+		return apperrors.Wrap(apperrors.CategoryEncode, "tiff.encode", err)
+	}
+	return nil
+}
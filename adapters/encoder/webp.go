@@ -3,8 +3,13 @@ package encoder
 import (
 	"bytes"
 	"context"
+	"fmt"
 	"image"
-	"image/jpeg"
+	"image/png"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
 
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
@@ -12,18 +17,24 @@ import (
 
 // WebP encodes images to WebP format.
 //
-// Pure-Go WebP encoding is not available in the standard library or x/image.
-// This implementation uses a JPEG-to-WebP shim strategy:
-//   - For production use, swap the body with a call to github.com/chai2010/webp
-//     (CGO-free, pure Go) or h2non/bimg (libvips bindings).
-//   - The shim produces valid JPEG output clearly labelled so callers can
-//     detect it and adopt a real WebP encoder in their build.
+// Output is produced by a built-in pure-Go VP8L encoder, so it is always
+// lossless-format WebP.  With EncodeOptions.Lossless the pixels are exact;
+// otherwise the encoder behaves like libwebp's near-lossless mode, rounding
+// away low colour bits as Quality drops so files shrink.  Set CWebP to a
+// cwebp binary to get true lossy (VP8) output instead; libvips builds get
+// it from adapters/vips.  Deterministic encodes never use cwebp, whose
+// output varies with the installed version.
 //
-// Drop-in replacement example (github.com/chai2010/webp):
+// A *core.Animation is written as an animated WebP with its delays and loop
+// count, always in-process.
 //
-//	data, err := webp.EncodeRGBA(rgba, float32(quality))
+// EncodeOptions.WebP().Effort bounds the LZ77 search (default 4);
+// AlphaQuality only affects cwebp.
 type WebP struct {
 	DefaultQuality int
+	// CWebP is the cwebp executable used for lossy output.  Empty keeps
+	// everything in-process.
+	CWebP string
 }
 
 func NewWebP(defaultQuality int) *WebP {
@@ -35,31 +46,90 @@ func NewWebP(defaultQuality int) *WebP {
 
 func (w *WebP) CanEncode(format core.Format) bool { return format == core.FormatWebP }
 
+// Capabilities implements core.CapabilityReporter: a *core.Animation is
+// written with all its frames.
+func (w *WebP) Capabilities(f core.Format) core.CodecCapabilities {
+	maxW, maxH := f.MaxDimensions()
+	return core.CodecCapabilities{Animation: true, Alpha: true, MaxWidth: maxW, MaxHeight: maxH}
+}
+
 func (w *WebP) Encode(ctx context.Context, img *core.ImageData, opts core.EncodeOptions) ([]byte, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.encode", err)
 	}
 
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryEncode, "webp.encode", apperrors.ErrEmptyInput)
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.encode", err)
 	}
 
-	quality := opts.Quality
-	if quality <= 0 {
-		quality = w.DefaultQuality
-	}
+	quality := opts.QualityOr(w.DefaultQuality)
+	wo := *opts.WebP()
+	effort := wo.Method()
 
-	// ── Production swap point ──────────────────────────────────────────────
-	// import "github.com/chai2010/webp"
-	// rgba := imageToRGBA(src)
-	// return webp.EncodeRGBA(rgba, float32(quality))
-	// ──────────────────────────────────────────────────────────────────────
+	lossless := opts.Lossless && !wo.NearLossless
+	anim, animated := src.(*core.Animation)
+	if w.CWebP != "" && !opts.Deterministic && !lossless && !wo.NearLossless && !animated {
+		return w.encodeCWebP(ctx, src, quality, effort, wo.AlphaQuality)
+	}
 
-	// Shim: encode as JPEG with a WebP MIME label for CI / test purposes.
+	dropBits := 0
+	if !lossless {
+		dropBits = min((100-quality)/12, 4)
+	}
 	var buf bytes.Buffer
-	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: quality}); err != nil {
-		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.encode.shim", err)
+	if animated {
+		if err := encodeWebPAnimation(ctx, &buf, anim, dropBits, effort); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.encode", err)
+		}
+		return buf.Bytes(), nil
+	}
+	if err := encodeWebPLossless(&buf, src, dropBits, effort); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.encode", err)
 	}
 	return buf.Bytes(), nil
-}
\ No newline at end of file
+}
+
+// encodeCWebP runs cwebp on a temporary PNG copy of src.
+func (w *WebP) encodeCWebP(ctx context.Context, src image.Image, quality, effort, alphaQuality int) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "imageprocessor-cwebp-")
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.cwebp", err)
+	}
+	defer os.RemoveAll(dir)
+	in, out := filepath.Join(dir, "in.png"), filepath.Join(dir, "out.webp")
+
+	f, err := os.Create(in)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.cwebp", err)
+	}
+	err = (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(f, src)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.cwebp", err)
+	}
+
+	args := []string{"-quiet", "-q", strconv.Itoa(quality), "-m", strconv.Itoa(effort)}
+	if alphaQuality > 0 {
+		args = append(args, "-alpha_q", strconv.Itoa(alphaQuality))
+	}
+	args = append(args, in, "-o", out)
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, w.CWebP, args...)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.cwebp", ctx.Err())
+		}
+		return nil, apperrors.New(apperrors.CategoryEncode, "webp.cwebp",
+			fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes())))
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "webp.cwebp", err)
+	}
+	return data, nil
+}
@@ -0,0 +1,75 @@
+package encoder
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// VP8X feature flags.
+const (
+	vp8xAnimation = 0x02
+	vp8xAlpha     = 0x10
+)
+
+// encodeWebPAnimation writes a as an animated WebP: a VP8X header, an ANIM
+// chunk with the loop count and one ANMF chunk per frame, each a VP8L
+// bitstream from encodeVP8L.  Frames are already composited, so they are
+// placed at the origin without blending or disposal.
+func encodeWebPAnimation(ctx context.Context, dst io.Writer, a *core.Animation, dropBits, effort int) error {
+	var (
+		frames bytes.Buffer
+		alpha  bool
+		cw, ch int
+	)
+	for i, f := range a.Frames {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		data, hasAlpha, err := encodeVP8L(f, dropBits, effort)
+		if err != nil {
+			return err
+		}
+		alpha = alpha || hasAlpha
+		b := f.Bounds()
+		cw, ch = max(cw, b.Dx()), max(ch, b.Dy())
+
+		ms := min(a.Delay(i)/time.Millisecond, 1<<24-1)
+		hdr := make([]byte, 16)
+		putUint24(hdr[6:], uint32(b.Dx()-1))
+		putUint24(hdr[9:], uint32(b.Dy()-1))
+		putUint24(hdr[12:], uint32(ms))
+		hdr[15] = 0x02 // do not blend
+		var anmf bytes.Buffer
+		anmf.Write(hdr)
+		writeRIFFChunk(&anmf, "VP8L", data)
+		writeRIFFChunk(&frames, "ANMF", anmf.Bytes())
+	}
+	if len(a.Frames) == 0 {
+		return errors.New("webp: animation has no frames")
+	}
+
+	vp8x := make([]byte, 10)
+	vp8x[0] = vp8xAnimation
+	if alpha {
+		vp8x[0] |= vp8xAlpha
+	}
+	putUint24(vp8x[4:], uint32(cw-1))
+	putUint24(vp8x[7:], uint32(ch-1))
+	anim := make([]byte, 6) // transparent background
+	binary.LittleEndian.PutUint16(anim[4:], uint16(min(max(a.LoopCount, 0), 0xffff)))
+
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	writeRIFFChunk(&body, "VP8X", vp8x)
+	writeRIFFChunk(&body, "ANIM", anim)
+	body.Write(frames.Bytes())
+	return writeRIFF(dst, body.Bytes())
+}
+
+func putUint24(b []byte, v uint32) { b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16) }
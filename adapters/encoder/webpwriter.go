@@ -0,0 +1,653 @@
+package encoder
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"io"
+	"sort"
+)
+
+// vp8lWriter is a pure-Go lossless WebP (VP8L) encoder.  It applies the
+// subtract-green and predictor transforms, finds LZ77 back-references with
+// a hash chain and entropy-codes the result with one set of prefix codes.
+// No colour cache, cross-colour transform or meta prefix codes, so files
+// are somewhat larger than cwebp -lossless but decode everywhere.
+
+const (
+	vp8lSignature  = 0x2f
+	vp8lMaxSide    = 1 << 14
+	vp8lTileBits   = 4 // predictor tiles are 16×16
+	vp8lMinMatch   = 3
+	vp8lMaxMatch   = 4096
+	vp8lMaxDist    = 1<<20 - 120
+	vp8lHashBits   = 16
+	vp8lNumLengths = 24
+	vp8lNumDists   = 40
+)
+
+// Transform types (VP8L spec §4).
+const (
+	vp8lPredictor     = 0
+	vp8lSubtractGreen = 2
+)
+
+// vp8lModes are the predictor modes tried per tile (VP8L spec §4.1): L, T,
+// Average2(L, T), Select and ClampAddSubtractFull cover most content.
+var vp8lModes = [...]byte{1, 2, 7, 11, 12}
+
+// vp8lCodeLengthOrder is the order code length code lengths are written in.
+var vp8lCodeLengthOrder = [19]int{17, 18, 0, 1, 2, 3, 4, 5, 16, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15}
+
+// encodeWebPLossless writes src to dst as a RIFF/WEBP file with a single
+// VP8L chunk.  dropBits low bits of each colour channel are rounded away
+// first (near-lossless); effort 0-6 bounds the LZ77 match search.
+func encodeWebPLossless(dst io.Writer, src image.Image, dropBits, effort int) error {
+	data, _, err := encodeVP8L(src, dropBits, effort)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	buf.WriteString("WEBP")
+	writeRIFFChunk(&buf, "VP8L", data)
+	return writeRIFF(dst, buf.Bytes())
+}
+
+// encodeVP8L returns the VP8L bitstream for src and whether it has alpha.
+func encodeVP8L(src image.Image, dropBits, effort int) ([]byte, bool, error) {
+	pix := toNRGBA(src)
+	w, h := pix.Rect.Dx(), pix.Rect.Dy()
+	if w < 1 || h < 1 || w > vp8lMaxSide || h > vp8lMaxSide {
+		return nil, false, errors.New("webp: dimensions out of range")
+	}
+	argb := make([]byte, 0, 4*w*h)
+	for y := 0; y < h; y++ {
+		argb = append(argb, pix.Pix[y*pix.Stride:y*pix.Stride+4*w]...)
+	}
+	alpha := false
+	for i := 3; i < len(argb); i += 4 {
+		if argb[i] != 0xff {
+			alpha = true
+			break
+		}
+	}
+	if dropBits > 0 {
+		quantizeRGB(argb, dropBits)
+	}
+
+	bw := &vp8lBits{}
+	bw.write(vp8lSignature, 8)
+	bw.write(uint32(w-1), 14)
+	bw.write(uint32(h-1), 14)
+	bw.write(b2u(alpha), 1)
+	bw.write(0, 3) // version
+
+	// Subtract green.
+	for i := 0; i < len(argb); i += 4 {
+		argb[i] -= argb[i+1]
+		argb[i+2] -= argb[i+1]
+	}
+	bw.write(1, 1)
+	bw.write(vp8lSubtractGreen, 2)
+
+	// Predictor.
+	modes, tw, _ := choosePredictors(argb, w, h)
+	bw.write(1, 1)
+	bw.write(vp8lPredictor, 2)
+	bw.write(vp8lTileBits-2, 3)
+	chain := 2 << min(max(effort, 0), 6)
+	writeVP8LImage(bw, modes, tw, false, chain)
+	residual := predictResiduals(argb, w, h, modes, tw)
+	bw.write(0, 1) // no more transforms
+
+	writeVP8LImage(bw, residual, w, true, chain)
+	return bw.bytes(), alpha, nil
+}
+
+// writeRIFF writes body (starting with the "WEBP" form type) as a RIFF file.
+func writeRIFF(dst io.Writer, body []byte) error {
+	var hdr [8]byte
+	copy(hdr[:], "RIFF")
+	binary.LittleEndian.PutUint32(hdr[4:], uint32(len(body)))
+	if _, err := dst.Write(hdr[:]); err != nil {
+		return err
+	}
+	_, err := dst.Write(body)
+	return err
+}
+
+// writeRIFFChunk appends a chunk, padded to even length.
+func writeRIFFChunk(buf *bytes.Buffer, typ string, data []byte) {
+	buf.WriteString(typ)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)&1 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+// quantizeRGB rounds the colour channels to multiples of 1<<bits.
+func quantizeRGB(pix []byte, bits int) {
+	top := 255 >> bits << bits
+	for i := 0; i < len(pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			v := (int(pix[i+c]) + 1<<(bits-1)) >> bits << bits
+			pix[i+c] = byte(min(v, top))
+		}
+	}
+}
+
+func b2u(b bool) uint32 {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+// ── Predictor transform ──────────────────────────────────────────────────────
+
+// vp8lPredict returns the prediction for the pixel at byte offset p (top is
+// the pixel above) under mode.
+func vp8lPredict(mode byte, pix []byte, p, top int) (out [4]byte) {
+	switch mode {
+	case 1:
+		copy(out[:], pix[p-4:p])
+	case 2:
+		copy(out[:], pix[top:top+4])
+	case 7:
+		for c := 0; c < 4; c++ {
+			out[c] = byte((int(pix[p-4+c]) + int(pix[top+c])) / 2)
+		}
+	case 11:
+		pl, pt := 0, 0
+		for c := 0; c < 4; c++ {
+			tl := int(pix[top-4+c])
+			pl += abs(tl - int(pix[top+c]))
+			pt += abs(tl - int(pix[p-4+c]))
+		}
+		if pl < pt {
+			copy(out[:], pix[p-4:p])
+		} else {
+			copy(out[:], pix[top:top+4])
+		}
+	case 12:
+		for c := 0; c < 4; c++ {
+			v := int(pix[p-4+c]) + int(pix[top+c]) - int(pix[top-4+c])
+			out[c] = byte(min(max(v, 0), 255))
+		}
+	}
+	return out
+}
+
+// choosePredictors picks the mode with the smallest absolute residuals for
+// each tile and returns them as a tw×th sub-image, mode in green.
+func choosePredictors(pix []byte, w, h int) (modes []byte, tw, th int) {
+	tw = (w + 1<<vp8lTileBits - 1) >> vp8lTileBits
+	th = (h + 1<<vp8lTileBits - 1) >> vp8lTileBits
+	modes = make([]byte, 4*tw*th)
+	for ty := 0; ty < th; ty++ {
+		for tx := 0; tx < tw; tx++ {
+			best, bestCost := vp8lModes[0], -1
+			for _, m := range vp8lModes {
+				cost := 0
+				for y := max(ty<<vp8lTileBits, 1); y < min((ty+1)<<vp8lTileBits, h); y++ {
+					for x := max(tx<<vp8lTileBits, 1); x < min((tx+1)<<vp8lTileBits, w); x++ {
+						p := 4 * (y*w + x)
+						pred := vp8lPredict(m, pix, p, p-4*w)
+						for c := 0; c < 4; c++ {
+							cost += abs(int(int8(pix[p+c] - pred[c])))
+						}
+					}
+				}
+				if bestCost < 0 || cost < bestCost {
+					best, bestCost = m, cost
+				}
+			}
+			modes[4*(ty*tw+tx)+1] = best
+		}
+	}
+	return modes, tw, th
+}
+
+// predictResiduals applies the predictor transform.  The first pixel is
+// predicted as opaque black, the rest of the first row from the left and
+// the first column from above, as the decoder does.
+func predictResiduals(pix []byte, w, h int, modes []byte, tw int) []byte {
+	out := make([]byte, len(pix))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			p := 4 * (y*w + x)
+			var pred [4]byte
+			switch {
+			case x == 0 && y == 0:
+				pred = [4]byte{0, 0, 0, 0xff}
+			case y == 0:
+				pred = vp8lPredict(1, pix, p, 0)
+			case x == 0:
+				pred = vp8lPredict(2, pix, p, p-4*w)
+			default:
+				m := modes[4*((y>>vp8lTileBits)*tw+x>>vp8lTileBits)+1]
+				pred = vp8lPredict(m, pix, p, p-4*w)
+			}
+			for c := 0; c < 4; c++ {
+				out[p+c] = pix[p+c] - pred[c]
+			}
+		}
+	}
+	return out
+}
+
+// ── Entropy-coded image ──────────────────────────────────────────────────────
+
+// vp8lToken is a literal pixel (length 0) or an LZ77 back-reference with
+// its VP8L distance code.
+type vp8lToken struct {
+	pix    uint32 // RGBA, little-endian
+	length int
+	dist   int
+}
+
+// writeVP8LImage writes pix (RGBA bytes, width w) as an entropy-coded
+// image.  Only the main image carries the meta prefix code bit.
+func writeVP8LImage(bw *vp8lBits, pix []byte, w int, main bool, chain int) {
+	bw.write(0, 1) // no colour cache
+	if main {
+		bw.write(0, 1) // no meta prefix codes
+	}
+	px := make([]uint32, len(pix)/4)
+	for i := range px {
+		px[i] = binary.LittleEndian.Uint32(pix[4*i:])
+	}
+	tokens := vp8lBackRefs(px, w, chain)
+
+	hist := [5][]int{
+		make([]int, 256+vp8lNumLengths), make([]int, 256), make([]int, 256),
+		make([]int, 256), make([]int, vp8lNumDists),
+	}
+	for _, t := range tokens {
+		if t.length == 0 {
+			hist[0][byte(t.pix>>8)]++
+			hist[1][byte(t.pix)]++
+			hist[2][byte(t.pix>>16)]++
+			hist[3][byte(t.pix>>24)]++
+			continue
+		}
+		ls, _, _ := vp8lPrefix(t.length)
+		ds, _, _ := vp8lPrefix(t.dist)
+		hist[0][256+ls]++
+		hist[4][ds]++
+	}
+	var codes [5]*vp8lHuff
+	for i := range codes {
+		codes[i] = newVP8LHuff(hist[i], 15)
+		codes[i].writeTo(bw)
+	}
+
+	for _, t := range tokens {
+		if t.length == 0 {
+			codes[0].emit(bw, int(byte(t.pix>>8)))
+			codes[1].emit(bw, int(byte(t.pix)))
+			codes[2].emit(bw, int(byte(t.pix>>16)))
+			codes[3].emit(bw, int(byte(t.pix>>24)))
+			continue
+		}
+		ls, ln, lx := vp8lPrefix(t.length)
+		codes[0].emit(bw, 256+ls)
+		bw.write(lx, ln)
+		ds, dn, dx := vp8lPrefix(t.dist)
+		codes[4].emit(bw, ds)
+		bw.write(dx, dn)
+	}
+}
+
+// vp8lBackRefs tokenises px greedily.  The pixel to the left and the one
+// above are always tried; other candidates come from a hash chain of at
+// most chain entries.
+func vp8lBackRefs(px []uint32, w, chain int) []vp8lToken {
+	n := len(px)
+	plane := vp8lPlaneCodes(w)
+	head := make([]int32, 1<<vp8lHashBits)
+	for i := range head {
+		head[i] = -1
+	}
+	prev := make([]int32, n)
+	hash := func(i int) uint32 {
+		return (px[i]*0x1e35a7bd ^ px[i+1]*0x9e3779b1) >> (32 - vp8lHashBits)
+	}
+	insert := func(i int) {
+		if i+1 < n {
+			h := hash(i)
+			prev[i], head[h] = head[h], int32(i)
+		}
+	}
+	matchLen := func(j, i int) int {
+		l := 0
+		for l < vp8lMaxMatch && i+l < n && px[j+l] == px[i+l] {
+			l++
+		}
+		return l
+	}
+
+	tokens := make([]vp8lToken, 0, n/2)
+	for i := 0; i < n; {
+		bestLen, bestDist := 0, 0
+		try := func(j int) {
+			if j < 0 || j >= i || i-j > vp8lMaxDist {
+				return
+			}
+			if l := matchLen(j, i); l > bestLen {
+				bestLen, bestDist = l, i-j
+			}
+		}
+		try(i - 1)
+		try(i - w)
+		if i+1 < n {
+			for j, k := head[hash(i)], 0; j >= 0 && k < chain && bestLen < vp8lMaxMatch; j, k = prev[j], k+1 {
+				try(int(j))
+			}
+		}
+		if bestLen < vp8lMinMatch {
+			tokens = append(tokens, vp8lToken{pix: px[i]})
+			insert(i)
+			i++
+			continue
+		}
+		code, ok := plane[bestDist]
+		if !ok {
+			code = bestDist + 120
+		}
+		tokens = append(tokens, vp8lToken{length: bestLen, dist: code})
+		for k := 0; k < bestLen; k++ {
+			insert(i + k)
+		}
+		i += bestLen
+	}
+	return tokens
+}
+
+// vp8lDistanceMap lists the (dy, 8-dx) neighbourhood offsets of distance
+// codes 1-120 (VP8L spec §4.2.2).
+var vp8lDistanceMap = [120]uint8{
+	0x18, 0x07, 0x17, 0x19, 0x28, 0x06, 0x27, 0x29, 0x16, 0x1a,
+	0x26, 0x2a, 0x38, 0x05, 0x37, 0x39, 0x15, 0x1b, 0x36, 0x3a,
+	0x25, 0x2b, 0x48, 0x04, 0x47, 0x49, 0x14, 0x1c, 0x35, 0x3b,
+	0x46, 0x4a, 0x24, 0x2c, 0x58, 0x45, 0x4b, 0x34, 0x3c, 0x03,
+	0x57, 0x59, 0x13, 0x1d, 0x56, 0x5a, 0x23, 0x2d, 0x44, 0x4c,
+	0x55, 0x5b, 0x33, 0x3d, 0x68, 0x02, 0x67, 0x69, 0x12, 0x1e,
+	0x66, 0x6a, 0x22, 0x2e, 0x54, 0x5c, 0x43, 0x4d, 0x65, 0x6b,
+	0x32, 0x3e, 0x78, 0x01, 0x77, 0x79, 0x53, 0x5d, 0x11, 0x1f,
+	0x64, 0x6c, 0x42, 0x4e, 0x76, 0x7a, 0x21, 0x2f, 0x75, 0x7b,
+	0x31, 0x3f, 0x63, 0x6d, 0x52, 0x5e, 0x00, 0x74, 0x7c, 0x41,
+	0x4f, 0x10, 0x20, 0x62, 0x6e, 0x30, 0x73, 0x7d, 0x51, 0x5f,
+	0x40, 0x72, 0x7e, 0x61, 0x6f, 0x50, 0x71, 0x7f, 0x60, 0x70,
+}
+
+// vp8lPlaneCodes maps linear distances to the shortest 2-D distance code
+// for an image of width w.
+func vp8lPlaneCodes(w int) map[int]int {
+	m := make(map[int]int, len(vp8lDistanceMap))
+	for i, v := range vp8lDistanceMap {
+		d := int(v>>4)*w + 8 - int(v&0xf)
+		if _, ok := m[d]; d >= 1 && !ok {
+			m[d] = i + 1
+		}
+	}
+	return m
+}
+
+// vp8lPrefix splits an LZ77 length or distance code v ≥ 1 into its prefix
+// symbol and extra bits (VP8L spec §4.2.2).
+func vp8lPrefix(v int) (sym int, nbits uint, extra uint32) {
+	v--
+	if v < 4 {
+		return v, 0, 0
+	}
+	hi := 0
+	for x := v; x > 1; x >>= 1 {
+		hi++
+	}
+	second := v >> (hi - 1) & 1
+	nbits = uint(hi - 1)
+	return 2*hi + second, nbits, uint32(v & (1<<nbits - 1))
+}
+
+// ── Prefix codes ─────────────────────────────────────────────────────────────
+
+// vp8lHuff is a canonical prefix code.  codes hold bit-reversed values so
+// they can be written LSB first.
+type vp8lHuff struct {
+	lengths []uint8
+	codes   []uint16
+	used    []int // symbols with a non-zero length
+}
+
+func newVP8LHuff(hist []int, maxLen int) *vp8lHuff {
+	h := &vp8lHuff{lengths: huffLengths(hist, maxLen), codes: make([]uint16, len(hist))}
+	for s, l := range h.lengths {
+		if l > 0 {
+			h.used = append(h.used, s)
+		}
+	}
+	var count [16]int
+	for _, l := range h.lengths {
+		count[l]++
+	}
+	count[0] = 0
+	var next [16]int
+	for l, code := 1, 0; l < 16; l++ {
+		code = (code + count[l-1]) << 1
+		next[l] = code
+	}
+	for s, l := range h.lengths {
+		if l == 0 {
+			continue
+		}
+		c := next[l]
+		next[l]++
+		var r uint16
+		for i := uint8(0); i < l; i++ {
+			r = r<<1 | uint16(c>>i&1)
+		}
+		h.codes[s] = r
+	}
+	return h
+}
+
+// emit writes symbol s.  A code with a single symbol takes no bits.
+func (h *vp8lHuff) emit(bw *vp8lBits, s int) {
+	if len(h.used) > 1 {
+		bw.write(uint32(h.codes[s]), uint(h.lengths[s]))
+	}
+}
+
+// writeTo writes the code itself: the simple form for one or two 8-bit
+// symbols, otherwise code lengths run-length coded with a code length code.
+func (h *vp8lHuff) writeTo(bw *vp8lBits) {
+	if len(h.used) == 0 {
+		h.used = []int{0}
+		h.lengths[0] = 1
+	}
+	if len(h.used) <= 2 && h.used[len(h.used)-1] < 256 {
+		bw.write(1, 1)
+		bw.write(uint32(len(h.used)-1), 1)
+		if h.used[0] < 2 {
+			bw.write(0, 1)
+			bw.write(uint32(h.used[0]), 1)
+		} else {
+			bw.write(1, 1)
+			bw.write(uint32(h.used[0]), 8)
+		}
+		if len(h.used) == 2 {
+			bw.write(uint32(h.used[1]), 8)
+		}
+		return
+	}
+
+	type rle struct {
+		sym   int
+		extra uint32
+	}
+	var toks []rle
+	prev := uint8(8)
+	for i := 0; i < len(h.lengths); {
+		l := h.lengths[i]
+		run := 1
+		for i+run < len(h.lengths) && h.lengths[i+run] == l {
+			run++
+		}
+		i += run
+		if l == 0 {
+			for run > 0 {
+				switch {
+				case run >= 11:
+					n := min(run, 138)
+					toks = append(toks, rle{18, uint32(n - 11)})
+					run -= n
+				case run >= 3:
+					toks = append(toks, rle{17, uint32(run - 3)})
+					run = 0
+				default:
+					toks = append(toks, rle{0, 0})
+					run--
+				}
+			}
+			continue
+		}
+		if l != prev {
+			toks = append(toks, rle{int(l), 0})
+			prev = l
+			run--
+		}
+		for run > 0 {
+			if run >= 3 {
+				n := min(run, 6)
+				toks = append(toks, rle{16, uint32(n - 3)})
+				run -= n
+			} else {
+				toks = append(toks, rle{int(l), 0})
+				run--
+			}
+		}
+	}
+
+	clHist := make([]int, 19)
+	for _, t := range toks {
+		clHist[t.sym]++
+	}
+	cl := newVP8LHuff(clHist, 7)
+	n := 4
+	for i, s := range vp8lCodeLengthOrder {
+		if cl.lengths[s] > 0 {
+			n = max(n, i+1)
+		}
+	}
+	bw.write(0, 1)
+	bw.write(uint32(n-4), 4)
+	for _, s := range vp8lCodeLengthOrder[:n] {
+		bw.write(uint32(cl.lengths[s]), 3)
+	}
+	bw.write(0, 1) // code lengths cover the whole alphabet
+	for _, t := range toks {
+		cl.emit(bw, t.sym)
+		switch t.sym {
+		case 16:
+			bw.write(t.extra, 2)
+		case 17:
+			bw.write(t.extra, 3)
+		case 18:
+			bw.write(t.extra, 7)
+		}
+	}
+}
+
+// huffLengths returns Huffman code lengths for hist, none longer than
+// maxLen.  Rare symbols are given ever larger floor counts until the tree
+// is shallow enough.  A lone symbol gets length 1.
+func huffLengths(hist []int, maxLen int) []uint8 {
+	lengths := make([]uint8, len(hist))
+	var syms []int
+	for s, c := range hist {
+		if c > 0 {
+			syms = append(syms, s)
+		}
+	}
+	switch len(syms) {
+	case 0:
+		return lengths
+	case 1:
+		lengths[syms[0]] = 1
+		return lengths
+	}
+
+	type node struct{ count, sym, left, right int }
+	for floor := 1; ; floor *= 2 {
+		nodes := make([]node, 0, 2*len(syms)-1)
+		for _, s := range syms {
+			nodes = append(nodes, node{max(hist[s], floor), s, -1, -1})
+		}
+		sort.Slice(nodes, func(a, b int) bool {
+			if nodes[a].count != nodes[b].count {
+				return nodes[a].count < nodes[b].count
+			}
+			return nodes[a].sym < nodes[b].sym
+		})
+		leaves := len(nodes)
+		li, ni := 0, leaves
+		pop := func() int {
+			if li < leaves && (ni >= len(nodes) || nodes[li].count <= nodes[ni].count) {
+				li++
+				return li - 1
+			}
+			ni++
+			return ni - 1
+		}
+		for k := 0; k < leaves-1; k++ {
+			a, b := pop(), pop()
+			nodes = append(nodes, node{nodes[a].count + nodes[b].count, -1, a, b})
+		}
+
+		deepest := 0
+		type item struct{ n, depth int }
+		stack := []item{{len(nodes) - 1, 0}}
+		for len(stack) > 0 {
+			it := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			nd := nodes[it.n]
+			if nd.sym >= 0 {
+				lengths[nd.sym] = uint8(min(it.depth, 255))
+				deepest = max(deepest, it.depth)
+				continue
+			}
+			stack = append(stack, item{nd.left, it.depth + 1}, item{nd.right, it.depth + 1})
+		}
+		if deepest <= maxLen {
+			return lengths
+		}
+	}
+}
+
+// vp8lBits is an LSB-first bit writer.
+type vp8lBits struct {
+	buf  []byte
+	acc  uint64
+	nacc uint
+}
+
+func (b *vp8lBits) write(v uint32, n uint) {
+	b.acc |= uint64(v) << b.nacc
+	b.nacc += n
+	for b.nacc >= 8 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc >>= 8
+		b.nacc -= 8
+	}
+}
+
+func (b *vp8lBits) bytes() []byte {
+	if b.nacc > 0 {
+		b.buf = append(b.buf, byte(b.acc))
+		b.acc, b.nacc = 0, 0
+	}
+	return b.buf
+}
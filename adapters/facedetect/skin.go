@@ -0,0 +1,125 @@
+// Package facedetect provides core.FaceDetector implementations.
+package facedetect
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"sort"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// SkinTone is a model-free reference FaceDetector.  It finds compact,
+// face-shaped regions of skin-coloured pixels (the YCbCr skin cluster) on
+// a downsampled copy of the image.  It needs no cascade or weights, which
+// makes it a dependable default for avatar crops, but it also reports
+// hands, arms and wood-toned backgrounds; plug a trained detector (a pigo
+// cascade, an ONNX model) into FaceCropStep where precision matters.
+type SkinTone struct {
+	// MinArea is the smallest region reported, as a fraction of the image
+	// area.  Default 0.005.
+	MinArea float64
+	// MaxFaces caps the regions returned, largest first.  Default 8.
+	MaxFaces int
+}
+
+// NewSkinTone returns a SkinTone detector with default settings.
+func NewSkinTone() *SkinTone { return &SkinTone{} }
+
+// skinGrid is the long side of the grid regions are found on.
+const skinGrid = 160
+
+// Detect implements core.FaceDetector.
+func (d *SkinTone) Detect(ctx context.Context, img image.Image) ([]image.Rectangle, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, "facedetect.skin", err)
+	}
+	if img == nil {
+		return nil, apperrors.New(apperrors.CategoryPipeline, "facedetect.skin", apperrors.ErrEmptyInput)
+	}
+	minArea := d.MinArea
+	if minArea <= 0 {
+		minArea = 0.005
+	}
+	maxFaces := d.MaxFaces
+	if maxFaces <= 0 {
+		maxFaces = 8
+	}
+
+	b := img.Bounds()
+	if b.Empty() {
+		return nil, nil
+	}
+	cell := max(1, (max(b.Dx(), b.Dy())+skinGrid-1)/skinGrid)
+	gw, gh := (b.Dx()+cell-1)/cell, (b.Dy()+cell-1)/cell
+	mask := make([]bool, gw*gh)
+	for gy := 0; gy < gh; gy++ {
+		for gx := 0; gx < gw; gx++ {
+			x, y := b.Min.X+gx*cell+cell/2, b.Min.Y+gy*cell+cell/2
+			mask[gy*gw+gx] = isSkin(img.At(min(x, b.Max.X-1), min(y, b.Max.Y-1)))
+		}
+	}
+
+	type region struct {
+		box  image.Rectangle
+		area int
+	}
+	var regions []region
+	seen := make([]bool, len(mask))
+	stack := make([]int, 0, 64)
+	for start, skin := range mask {
+		if !skin || seen[start] {
+			continue
+		}
+		r := region{box: image.Rect(start%gw, start/gw, start%gw+1, start/gw+1)}
+		stack = append(stack[:0], start)
+		seen[start] = true
+		for len(stack) > 0 {
+			i := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			x, y := i%gw, i/gw
+			r.area++
+			r.box = r.box.Union(image.Rect(x, y, x+1, y+1))
+			for _, n := range [4][2]int{{x - 1, y}, {x + 1, y}, {x, y - 1}, {x, y + 1}} {
+				if n[0] < 0 || n[1] < 0 || n[0] >= gw || n[1] >= gh {
+					continue
+				}
+				if j := n[1]*gw + n[0]; mask[j] && !seen[j] {
+					seen[j] = true
+					stack = append(stack, j)
+				}
+			}
+		}
+		w, h := float64(r.box.Dx()), float64(r.box.Dy())
+		if float64(r.area) < minArea*float64(gw*gh) {
+			continue
+		}
+		// Faces are roughly upright ellipses that fill much of their box.
+		if h/w < 0.7 || h/w > 2.2 || float64(r.area) < 0.45*w*h {
+			continue
+		}
+		regions = append(regions, r)
+	}
+
+	sort.SliceStable(regions, func(i, j int) bool { return regions[i].area > regions[j].area })
+	faces := make([]image.Rectangle, 0, min(len(regions), maxFaces))
+	for _, r := range regions[:min(len(regions), maxFaces)] {
+		box := image.Rect(r.box.Min.X*cell, r.box.Min.Y*cell, r.box.Max.X*cell, r.box.Max.Y*cell)
+		faces = append(faces, box.Add(b.Min).Intersect(b))
+	}
+	return faces, nil
+}
+
+// isSkin applies the Chai & Ngan YCbCr skin-colour bounds.
+func isSkin(c color.Color) bool {
+	r, g, b, a := c.RGBA()
+	if a < 0x8000 {
+		return false
+	}
+	y, cb, cr := color.RGBToYCbCr(uint8(r>>8), uint8(g>>8), uint8(b>>8))
+	return y > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173
+}
+
+var _ core.FaceDetector = (*SkinTone)(nil)
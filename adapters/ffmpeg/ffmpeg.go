@@ -0,0 +1,182 @@
+// Package ffmpeg shells out to an ffmpeg binary for conversions the image
+// codecs cannot express, such as turning animated GIFs into video clips.
+package ffmpeg
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// Transcoder runs ffmpeg as a subprocess.  The zero value uses the "ffmpeg"
+// binary found on PATH.
+type Transcoder struct {
+	// Binary is the ffmpeg executable name or path.  Default "ffmpeg".
+	Binary string
+	// ExtraArgs are inserted before the output path, e.g. "-threads", "2".
+	ExtraArgs []string
+}
+
+// New returns a Transcoder that runs binary ("" for "ffmpeg" on PATH).
+func New(binary string) *Transcoder { return &Transcoder{Binary: binary} }
+
+func (t *Transcoder) binary() string {
+	if t.Binary == "" {
+		return "ffmpeg"
+	}
+	return t.Binary
+}
+
+// Available reports whether the configured ffmpeg binary can be found.
+func (t *Transcoder) Available() bool {
+	_, err := exec.LookPath(t.binary())
+	return err == nil
+}
+
+// VideoOptions controls GIFToVideo output.
+type VideoOptions struct {
+	// Container is core.FormatMP4 (H.264) or core.FormatWebM (VP9).
+	// Default MP4.
+	Container core.Format
+	// CRF is the constant rate factor; lower is better quality.  Default 23
+	// for MP4 and 33 for WebM.
+	CRF int
+	// MaxFPS caps the output frame rate.  0 keeps the GIF's timing.
+	MaxFPS int
+}
+
+func (o VideoOptions) withDefaults() VideoOptions {
+	if o.Container == "" {
+		o.Container = core.FormatMP4
+	}
+	if o.CRF <= 0 {
+		o.CRF = 23
+		if o.Container == core.FormatWebM {
+			o.CRF = 33
+		}
+	}
+	return o
+}
+
+// Args returns the ffmpeg arguments GIFToVideo uses to read from stdin and
+// write out.
+func (o VideoOptions) Args(out string) ([]string, error) {
+	o = o.withDefaults()
+	// yuv420p needs even dimensions; GIFs frequently have odd ones.
+	filter := "scale=trunc(iw/2)*2:trunc(ih/2)*2"
+	if o.MaxFPS > 0 {
+		filter = "fps=" + strconv.Itoa(o.MaxFPS) + "," + filter
+	}
+	args := []string{"-hide_banner", "-loglevel", "error", "-y", "-f", "gif", "-i", "pipe:0", "-an", "-vf", filter}
+	switch o.Container {
+	case core.FormatMP4:
+		args = append(args, "-c:v", "libx264", "-pix_fmt", "yuv420p",
+			"-crf", strconv.Itoa(o.CRF), "-movflags", "+faststart", "-f", "mp4")
+	case core.FormatWebM:
+		args = append(args, "-c:v", "libvpx-vp9", "-pix_fmt", "yuv420p",
+			"-b:v", "0", "-crf", strconv.Itoa(o.CRF), "-f", "webm")
+	default:
+		return nil, fmt.Errorf("%w: %s is not a video container", apperrors.ErrUnsupportedFormat, o.Container)
+	}
+	return append(args, out), nil
+}
+
+// GIFToVideo converts an animated GIF to a video clip.  Output goes to a
+// temporary file rather than a pipe because MP4's faststart pass needs to
+// seek.
+func (t *Transcoder) GIFToVideo(ctx context.Context, gif []byte, opts VideoOptions) ([]byte, error) {
+	if len(gif) == 0 {
+		return nil, apperrors.New(apperrors.CategoryInput, "ffmpeg.gif2video", apperrors.ErrEmptyInput)
+	}
+	opts = opts.withDefaults()
+
+	dir, err := os.MkdirTemp("", "imageprocessor-ffmpeg-")
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "ffmpeg.gif2video", err)
+	}
+	defer os.RemoveAll(dir)
+	out := filepath.Join(dir, "out."+string(opts.Container))
+
+	args, err := opts.Args(out)
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, "ffmpeg.gif2video", err)
+	}
+	if len(t.ExtraArgs) > 0 {
+		args = append(append(args[:len(args)-1:len(args)-1], t.ExtraArgs...), out)
+	}
+
+	var stderr bytes.Buffer
+	cmd := exec.CommandContext(ctx, t.binary(), args...)
+	cmd.Stdin = utils.BytesReader(gif)
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "ffmpeg.gif2video", ctx.Err())
+		}
+		return nil, apperrors.New(apperrors.CategoryEncode, "ffmpeg.gif2video",
+			fmt.Errorf("%w: %s", err, bytes.TrimSpace(stderr.Bytes())))
+	}
+	data, err := os.ReadFile(out)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "ffmpeg.gif2video", err)
+	}
+	if len(data) == 0 {
+		return nil, apperrors.New(apperrors.CategoryEncode, "ffmpeg.gif2video", apperrors.ErrEmptyInput)
+	}
+	return data, nil
+}
+
+// GIFToVideoStep replaces large animated GIFs with an MP4 or WebM clip.
+// Static GIFs, GIFs smaller than MinBytes and non-GIF inputs pass through
+// unchanged.  The clip is made from the source bytes in Data, so pixel steps
+// earlier in the chain do not affect it.  On conversion Data holds the video,
+// Format is the container and Image is nil; EncodeStep passes such results
+// through untouched.
+type GIFToVideoStep struct {
+	Transcoder *Transcoder
+	Options    VideoOptions
+	// MinBytes is the smallest GIF worth converting.  Default 256 KiB.
+	MinBytes int
+}
+
+func (s *GIFToVideoStep) Name() string { return "gif_to_video" }
+
+func (s *GIFToVideoStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if img.Format != core.FormatGIF || len(img.Data) == 0 {
+		return img, nil
+	}
+	minBytes := s.MinBytes
+	if minBytes <= 0 {
+		minBytes = 256 << 10
+	}
+	if len(img.Data) < minBytes || utils.GIFFrameCount(img.Data) < 2 {
+		return img, nil
+	}
+	t := s.Transcoder
+	if t == nil {
+		t = &Transcoder{}
+	}
+	opts := s.Options.withDefaults()
+	data, err := t.GIFToVideo(ctx, img.Data, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	out := *img
+	out.Image = nil
+	out.Data = data
+	out.Format = opts.Container
+	out.Meta.Format = opts.Container
+	out.Meta.SizeBytes = int64(len(data))
+	out.Meta.EXIF = nil
+	out.Meta.Details = nil
+	return &out, nil
+}
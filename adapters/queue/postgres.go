@@ -0,0 +1,149 @@
+package queue
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Postgres is a core.JobQueue kept in a Postgres table.  Workers in any
+// number of processes claim jobs with SELECT … FOR UPDATE SKIP LOCKED, so
+// each job is taken once.  Open db with any database/sql Postgres driver
+// (pgx's stdlib package or lib/pq) and create the table with CreateTable.
+type Postgres struct {
+	// PollInterval is how often Dequeue checks an empty table; default 1s.
+	PollInterval time.Duration
+
+	db    *sql.DB
+	codec Codec
+	table string
+}
+
+var identifier = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)?$`)
+
+// NewPostgres creates a queue over table (default "image_jobs").  db and
+// codec must not be nil.
+func NewPostgres(db *sql.DB, codec Codec, table string) (*Postgres, error) {
+	if db == nil || codec == nil {
+		return nil, fmt.Errorf("postgres queue: db and codec must not be nil")
+	}
+	if table == "" {
+		table = "image_jobs"
+	}
+	if !identifier.MatchString(table) {
+		return nil, fmt.Errorf("postgres queue: invalid table name %q", table)
+	}
+	return &Postgres{PollInterval: time.Second, db: db, codec: codec, table: table}, nil
+}
+
+// CreateTable creates the queue's table if it does not exist.
+func (q *Postgres) CreateTable(ctx context.Context) error {
+	_, err := q.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+q.table+` (
+	id        TEXT PRIMARY KEY,
+	seq       BIGSERIAL,
+	rank      SMALLINT NOT NULL,
+	payload   BYTEA NOT NULL,
+	running   BOOLEAN NOT NULL DEFAULT FALSE,
+	locked_at TIMESTAMPTZ
+)`)
+	if err != nil {
+		return apperrors.New(apperrors.CategoryConfig, "postgres.create_table", err)
+	}
+	return nil
+}
+
+// Enqueue implements core.JobQueue.  Jobs without an ID are given one; an
+// ID already in the table is an error.
+func (q *Postgres) Enqueue(ctx context.Context, job core.Job) error {
+	job = withID(job)
+	payload, err := q.codec.Marshal(job)
+	if err != nil {
+		return apperrors.New(apperrors.CategoryConfig, "postgres.enqueue", err)
+	}
+	_, err = q.db.ExecContext(ctx,
+		`INSERT INTO `+q.table+` (id, rank, payload) VALUES ($1, $2, $3)`,
+		job.ID, rank(job.Priority), payload)
+	if err != nil {
+		return apperrors.Transient("postgres.enqueue", err)
+	}
+	return nil
+}
+
+// Dequeue implements core.JobQueue by claiming the oldest waiting job of
+// the highest priority.
+func (q *Postgres) Dequeue(ctx context.Context) (core.Job, error) {
+	query := `UPDATE ` + q.table + ` SET running = TRUE, locked_at = now()
+WHERE id = (
+	SELECT id FROM ` + q.table + ` WHERE NOT running
+	ORDER BY rank, seq
+	LIMIT 1
+	FOR UPDATE SKIP LOCKED
+)
+RETURNING id, payload`
+	for {
+		var (
+			id      string
+			payload []byte
+		)
+		err := q.db.QueryRowContext(ctx, query).Scan(&id, &payload)
+		switch {
+		case err == nil:
+			job, err := q.codec.Unmarshal(payload)
+			if err != nil {
+				// Drop the row rather than fail on it forever.
+				_, _ = q.db.ExecContext(ctx, `DELETE FROM `+q.table+` WHERE id = $1`, id)
+				return core.Job{}, apperrors.New(apperrors.CategoryConfig, "postgres.dequeue", err)
+			}
+			job.ID = id
+			return job, nil
+		case !errors.Is(err, sql.ErrNoRows):
+			if ctx.Err() != nil {
+				return core.Job{}, ctx.Err()
+			}
+			return core.Job{}, apperrors.Transient("postgres.dequeue", err)
+		}
+		select {
+		case <-ctx.Done():
+			return core.Job{}, ctx.Err()
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// Ack implements core.JobQueue by deleting job's row.
+func (q *Postgres) Ack(ctx context.Context, job core.Job) error {
+	if _, err := q.db.ExecContext(ctx, `DELETE FROM `+q.table+` WHERE id = $1`, job.ID); err != nil {
+		return apperrors.Transient("postgres.ack", err)
+	}
+	return nil
+}
+
+// Nack implements core.JobQueue by releasing job's row for another worker.
+func (q *Postgres) Nack(ctx context.Context, job core.Job) error {
+	_, err := q.db.ExecContext(ctx,
+		`UPDATE `+q.table+` SET running = FALSE, locked_at = NULL WHERE id = $1`, job.ID)
+	if err != nil {
+		return apperrors.Transient("postgres.nack", err)
+	}
+	return nil
+}
+
+// Recover releases jobs claimed more than olderThan ago and never
+// acknowledged, e.g. by a worker that crashed, and returns how many it
+// released.  olderThan should exceed the longest a job can run.
+func (q *Postgres) Recover(ctx context.Context, olderThan time.Duration) (int, error) {
+	res, err := q.db.ExecContext(ctx,
+		`UPDATE `+q.table+` SET running = FALSE, locked_at = NULL WHERE running AND locked_at < $1`,
+		time.Now().Add(-olderThan))
+	if err != nil {
+		return 0, apperrors.Transient("postgres.recover", err)
+	}
+	n, err := res.RowsAffected()
+	return int(n), err
+}
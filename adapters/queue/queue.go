@@ -0,0 +1,54 @@
+// Package queue provides persistent core.JobQueue implementations, so jobs
+// submitted to the worker pool survive restarts and can be consumed by
+// several processes.  Install one with Processor.SetJobQueue before Start.
+package queue
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// Codec turns jobs into the bytes a persistent queue stores, and back.
+// A job's Steps, Source reader, Ctx and ResultCh belong to the process that
+// submitted it, so the Codec decides how they are recorded: typically the
+// upload is put in object storage and referenced by key, and the steps are
+// named by a preset or a pipeline spec (see pipeline/spec) that Unmarshal
+// resolves again.  Unmarshal must restore ID and Priority.
+type Codec interface {
+	Marshal(job core.Job) ([]byte, error)
+	Unmarshal(data []byte) (core.Job, error)
+}
+
+// priorities lists the priorities in the order jobs are dequeued.
+var priorities = []core.Priority{core.PriorityHigh, core.PriorityNormal, core.PriorityLow}
+
+// normalize maps the zero Priority to PriorityNormal.
+func normalize(p core.Priority) core.Priority {
+	if p == "" {
+		return core.PriorityNormal
+	}
+	return p
+}
+
+// rank orders p for storage: 0 for high, 1 for normal, 2 for low.
+func rank(p core.Priority) int {
+	for i, q := range priorities {
+		if normalize(p) == q {
+			return i
+		}
+	}
+	return 1
+}
+
+// withID gives job a random ID if it has none, as persistent queues track
+// jobs by ID.
+func withID(job core.Job) core.Job {
+	if job.ID == "" {
+		var b [16]byte
+		_, _ = rand.Read(b[:])
+		job.ID = hex.EncodeToString(b[:])
+	}
+	return job
+}
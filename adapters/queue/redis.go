@@ -0,0 +1,167 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// RedisClient is the subset of Redis list commands the Redis queue uses.
+// Adapt a go-redis or rueidis client in a few lines, or inject a test
+// double.  RPopLPush returns nil, nil when src is empty.
+type RedisClient interface {
+	LPush(ctx context.Context, key string, value []byte) error
+	RPush(ctx context.Context, key string, value []byte) error
+	RPopLPush(ctx context.Context, src, dst string) ([]byte, error)
+	LRem(ctx context.Context, key string, value []byte) error
+}
+
+// Redis is a core.JobQueue kept in Redis lists: "<prefix>:<priority>" holds
+// waiting jobs and "<prefix>:<priority>:processing" the jobs workers have
+// taken but not yet acknowledged, so a crashed worker's jobs can be put
+// back with Recover.
+type Redis struct {
+	// PollInterval is how often Dequeue checks empty lists; default 250ms.
+	PollInterval time.Duration
+
+	client RedisClient
+	codec  Codec
+	prefix string
+
+	mu       sync.Mutex
+	inflight map[string]redisDelivery // job ID → taken payload
+}
+
+type redisDelivery struct {
+	priority core.Priority
+	payload  []byte
+}
+
+// NewRedis creates a Redis queue storing jobs under keys starting with
+// prefix.  client and codec must not be nil.
+func NewRedis(client RedisClient, codec Codec, prefix string) (*Redis, error) {
+	if client == nil || codec == nil {
+		return nil, fmt.Errorf("redis queue: client and codec must not be nil")
+	}
+	if prefix == "" {
+		prefix = "imageprocessor:jobs"
+	}
+	return &Redis{
+		PollInterval: 250 * time.Millisecond,
+		client:       client,
+		codec:        codec,
+		prefix:       prefix,
+		inflight:     make(map[string]redisDelivery),
+	}, nil
+}
+
+func (q *Redis) waiting(p core.Priority) string    { return q.prefix + ":" + string(normalize(p)) }
+func (q *Redis) processing(p core.Priority) string { return q.waiting(p) + ":processing" }
+
+// Enqueue implements core.JobQueue.  Jobs without an ID are given one.
+func (q *Redis) Enqueue(ctx context.Context, job core.Job) error {
+	job = withID(job)
+	payload, err := q.codec.Marshal(job)
+	if err != nil {
+		return apperrors.New(apperrors.CategoryConfig, "redis.enqueue", err)
+	}
+	if err := q.client.LPush(ctx, q.waiting(job.Priority), payload); err != nil {
+		return apperrors.Transient("redis.enqueue", err)
+	}
+	return nil
+}
+
+// Dequeue implements core.JobQueue by moving the oldest job of the highest
+// priority waiting onto its processing list.
+func (q *Redis) Dequeue(ctx context.Context) (core.Job, error) {
+	for {
+		for _, p := range priorities {
+			if err := ctx.Err(); err != nil {
+				return core.Job{}, err
+			}
+			payload, err := q.client.RPopLPush(ctx, q.waiting(p), q.processing(p))
+			if err != nil {
+				return core.Job{}, apperrors.Transient("redis.dequeue", err)
+			}
+			if payload == nil {
+				continue
+			}
+			job, err := q.codec.Unmarshal(payload)
+			if err != nil {
+				// Drop the payload rather than fail on it forever.
+				_ = q.client.LRem(ctx, q.processing(p), payload)
+				return core.Job{}, apperrors.New(apperrors.CategoryConfig, "redis.dequeue", err)
+			}
+			q.mu.Lock()
+			q.inflight[job.ID] = redisDelivery{priority: p, payload: payload}
+			q.mu.Unlock()
+			return job, nil
+		}
+		select {
+		case <-ctx.Done():
+			return core.Job{}, ctx.Err()
+		case <-time.After(q.PollInterval):
+		}
+	}
+}
+
+// Ack implements core.JobQueue by removing job from its processing list.
+func (q *Redis) Ack(ctx context.Context, job core.Job) error {
+	d, ok := q.take(job.ID)
+	if !ok {
+		return nil
+	}
+	if err := q.client.LRem(ctx, q.processing(d.priority), d.payload); err != nil {
+		return apperrors.Transient("redis.ack", err)
+	}
+	return nil
+}
+
+// Nack implements core.JobQueue by moving job back to be the next of its
+// priority taken.
+func (q *Redis) Nack(ctx context.Context, job core.Job) error {
+	d, ok := q.take(job.ID)
+	if !ok {
+		return nil
+	}
+	if err := q.client.RPush(ctx, q.waiting(d.priority), d.payload); err != nil {
+		return apperrors.Transient("redis.nack", err)
+	}
+	if err := q.client.LRem(ctx, q.processing(d.priority), d.payload); err != nil {
+		return apperrors.Transient("redis.nack", err)
+	}
+	return nil
+}
+
+func (q *Redis) take(id string) (redisDelivery, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	d, ok := q.inflight[id]
+	delete(q.inflight, id)
+	return d, ok
+}
+
+// Recover moves every job left on the processing lists, e.g. by a worker
+// that crashed, back to the front of the waiting lists and returns how
+// many it moved.  Call it at startup, before any worker using the same
+// prefix is running.
+func (q *Redis) Recover(ctx context.Context) (int, error) {
+	n := 0
+	for _, p := range priorities {
+		for {
+			payload, err := q.client.RPopLPush(ctx, q.processing(p), q.waiting(p))
+			if err != nil {
+				return n, apperrors.Transient("redis.recover", err)
+			}
+			if payload == nil {
+				break
+			}
+			n++
+		}
+	}
+	return n, nil
+}
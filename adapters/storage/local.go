@@ -9,6 +9,8 @@ import (
 	"io"
 	"os"
 	"path/filepath"
+	"sort"
+	"strings"
 
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
@@ -106,4 +108,39 @@ func (l *Local) Exists(ctx context.Context, key core.StorageKey) (bool, error) {
 		return false, nil
 	}
 	return false, apperrors.Wrap(apperrors.CategoryStorage, "local.exists.stat", err)
-}
\ No newline at end of file
+}
+
+// List implements core.Lister.  Side-car metadata files are skipped.
+func (l *Local) List(ctx context.Context, bucket, prefix string) ([]core.StorageKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryStorage, "local.list", err)
+	}
+	root := filepath.Join(l.rootDir, filepath.Clean(bucket))
+	var keys []core.StorageKey
+	err := filepath.WalkDir(root, func(path string, d os.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return filepath.SkipDir
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".meta.json") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+		if rel = filepath.ToSlash(rel); strings.HasPrefix(rel, prefix) {
+			keys = append(keys, core.StorageKey{Bucket: bucket, Path: rel})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryStorage, "local.list", err)
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].Path < keys[j].Path })
+	return keys, nil
+}
+
+var _ core.Lister = (*Local)(nil)
@@ -4,6 +4,7 @@ import (
 	"context"
 	"fmt"
 	"io"
+	"sort"
 
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
@@ -28,6 +29,12 @@ type S3Client interface {
 	HeadObject(ctx context.Context, bucket, key string) (bool, error)
 }
 
+// S3ListClient is optionally implemented by an S3Client that can list keys
+// (ListObjectsV2); S3.List requires it.
+type S3ListClient interface {
+	ListObjects(ctx context.Context, bucket, prefix string) ([]string, error)
+}
+
 // S3 is the StorageAdapter backed by AWS S3 (or S3-compatible stores).
 // Inject a real S3Client built with aws-sdk-go-v2 in production.
 type S3 struct {
@@ -85,6 +92,29 @@ func (s *S3) Exists(ctx context.Context, key core.StorageKey) (bool, error) {
 	return s.client.HeadObject(ctx, s.bucket_(key), key.Path)
 }
 
+// List implements core.Lister when the client implements S3ListClient.
+func (s *S3) List(ctx context.Context, bucket, prefix string) ([]core.StorageKey, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryStorage, "s3.list", err)
+	}
+	lc, ok := s.client.(S3ListClient)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, "s3.list",
+			fmt.Errorf("s3 client does not implement ListObjects"))
+	}
+	key := core.StorageKey{Bucket: bucket}
+	names, err := lc.ListObjects(ctx, s.bucket_(key), prefix)
+	if err != nil {
+		return nil, apperrors.Transient("s3.list", err)
+	}
+	sort.Strings(names)
+	keys := make([]core.StorageKey, len(names))
+	for i, n := range names {
+		keys[i] = core.StorageKey{Bucket: bucket, Path: n}
+	}
+	return keys, nil
+}
+
 // ──────────────────────────────────────────────────────────────────────────────
 // Integration guide: wiring aws-sdk-go-v2
 // ──────────────────────────────────────────────────────────────────────────────
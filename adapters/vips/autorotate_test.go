@@ -0,0 +1,48 @@
+package vips_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/Skryldev/image-processor/adapters/vips"
+	"github.com/Skryldev/image-processor/core"
+	"github.com/Skryldev/image-processor/losslessjpeg"
+	"github.com/Skryldev/image-processor/testutil"
+)
+
+func TestVipsAutoRotateStep_BeforeDecode(t *testing.T) {
+	ctx := context.Background()
+	raw := func(data []byte, w, h int) *core.ImageData {
+		return &core.ImageData{Data: data, Format: core.FormatJPEG,
+			Meta: core.Metadata{Width: w, Height: h, Format: core.FormatJPEG, Orientation: 6}}
+	}
+
+	// Whole MCUs: turned losslessly, still undecoded.
+	out, err := (&vips.VipsAutoRotateStep{}).Execute(ctx, raw(testutil.EXIFJPEG(t, 48, 32, 6), 48, 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Image != nil || losslessjpeg.Orientation(out.Data) != 1 || out.Meta.Width != 32 || out.Meta.Height != 48 || out.Meta.Orientation != 0 {
+		t.Errorf("lossless: decoded %v, tag %d, meta %dx%d o%d", out.Image != nil,
+			losslessjpeg.Orientation(out.Data), out.Meta.Width, out.Meta.Height, out.Meta.Orientation)
+	}
+
+	// losslessjpeg cannot read it: no error, and without a Backend the
+	// image is left for a later step.
+	data := testutil.EXIFJPEG(t, 48, 32, 6)
+	broken := raw(data[:len(data)/2], 48, 32)
+	if out, err := (&vips.VipsAutoRotateStep{}).Execute(ctx, broken); err != nil || out != broken {
+		t.Errorf("unreadable JPEG: %v, changed %v", err, out != broken)
+	}
+
+	// Partial MCUs: decoded with the Backend and rotated in pixels.
+	backend := vips.NewBackend(vips.BackendConfig{})
+	out, err = (&vips.VipsAutoRotateStep{Backend: backend}).Execute(ctx, raw(testutil.EXIFJPEG(t, 50, 34, 6), 50, 34))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Image == nil || out.Meta.Width != 34 || out.Meta.Height != 50 || out.Meta.Orientation != 0 {
+		t.Errorf("pixel fallback: decoded %v, meta %dx%d o%d", out.Image != nil,
+			out.Meta.Width, out.Meta.Height, out.Meta.Orientation)
+	}
+}
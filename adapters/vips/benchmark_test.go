@@ -48,7 +48,7 @@ func newStdlibProc(b *testing.B) *imageprocessor.Processor {
 func BenchmarkDecode_Stdlib_1920x1080(b *testing.B) {
 	raw := makeJPEG(b, 1920, 1080)
 	proc := newStdlibProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	reg := proc.Inner().Registry()
 
 	b.ReportAllocs()
@@ -67,7 +67,7 @@ func BenchmarkDecode_Stdlib_1920x1080(b *testing.B) {
 func BenchmarkDecode_Vips_1920x1080(b *testing.B) {
 	raw := makeJPEG(b, 1920, 1080)
 	proc, backend := newVipsProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	defer backend.Shutdown()
 	reg := proc.Inner().Registry()
 
@@ -89,7 +89,7 @@ func BenchmarkDecode_Vips_1920x1080(b *testing.B) {
 func BenchmarkResize_Stdlib_1920to960(b *testing.B) {
 	raw := makeJPEG(b, 1920, 1080)
 	proc := newStdlibProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	reg := proc.Inner().Registry()
 
 	b.ReportAllocs()
@@ -109,7 +109,7 @@ func BenchmarkResize_Stdlib_1920to960(b *testing.B) {
 func BenchmarkResize_Vips_1920to960(b *testing.B) {
 	raw := makeJPEG(b, 1920, 1080)
 	proc, backend := newVipsProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	defer backend.Shutdown()
 	reg := proc.Inner().Registry()
 
@@ -132,7 +132,7 @@ func BenchmarkResize_Vips_1920to960(b *testing.B) {
 func BenchmarkThumbnail_Stdlib_4K(b *testing.B) {
 	raw := makeJPEG(b, 3840, 2160)
 	proc := newStdlibProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	reg := proc.Inner().Registry()
 
 	b.ReportAllocs()
@@ -152,7 +152,7 @@ func BenchmarkThumbnail_Stdlib_4K(b *testing.B) {
 func BenchmarkThumbnail_Vips_4K(b *testing.B) {
 	raw := makeJPEG(b, 3840, 2160)
 	proc, backend := newVipsProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	defer backend.Shutdown()
 	reg := proc.Inner().Registry()
 
@@ -174,7 +174,7 @@ func BenchmarkThumbnail_Vips_4K(b *testing.B) {
 func BenchmarkEncodeWebP_Stdlib(b *testing.B) {
 	raw := makeJPEG(b, 800, 600)
 	proc := newStdlibProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	reg := proc.Inner().Registry()
 
 	b.ReportAllocs()
@@ -194,7 +194,7 @@ func BenchmarkEncodeWebP_Stdlib(b *testing.B) {
 func BenchmarkEncodeWebP_Vips(b *testing.B) {
 	raw := makeJPEG(b, 800, 600)
 	proc, backend := newVipsProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	defer backend.Shutdown()
 	reg := proc.Inner().Registry()
 
@@ -217,7 +217,7 @@ func BenchmarkEncodeWebP_Vips(b *testing.B) {
 func BenchmarkPipeline_Stdlib(b *testing.B) {
 	raw := makeJPEG(b, 1920, 1080)
 	proc := newStdlibProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	reg := proc.Inner().Registry()
 
 	b.ReportAllocs()
@@ -239,7 +239,7 @@ func BenchmarkPipeline_Stdlib(b *testing.B) {
 func BenchmarkPipeline_Vips(b *testing.B) {
 	raw := makeJPEG(b, 1920, 1080)
 	proc, backend := newVipsProc(b)
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 	defer backend.Shutdown()
 	reg := proc.Inner().Registry()
 
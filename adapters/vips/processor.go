@@ -1,15 +1,27 @@
 package vips
 
 import (
+	"bytes"
+	"cmp"
 	"context"
+	"encoding/binary"
 	"fmt"
+	"image"
+	"image/color"
+	"image/png"
 	"io"
+	"math"
 	"runtime"
+	"strings"
+	"time"
 
 	govips "github.com/davidbyttow/govips/v2/vips"
 
+	"github.com/Skryldev/image-processor/config"
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/losslessjpeg"
+	"github.com/Skryldev/image-processor/metadata"
 	"github.com/Skryldev/image-processor/utils"
 )
 
@@ -19,12 +31,26 @@ type BackendConfig struct {
 	MaxCacheSize   int
 	MaxWorkers     int
 	ReportLeaks    bool
+	// FirstFrameOnly loads animated GIF and WebP as their first frame.  By
+	// default every frame is loaded (n=-1) as a vertical strip that
+	// VipsResizeStep resizes frame by frame and Encode writes back out as
+	// an animation.
+	FirstFrameOnly bool
+	// RAW enables decoding camera RAW files (DNG, CR2, NEF) through
+	// libvips magickload, which needs ImageMagick with a libraw or dcraw
+	// delegate.  Nil leaves RAW uploads rejected with ErrUnsupportedFormat.
+	RAW *RAWOptions
+	// Limits refuses images whose header, read by the lazy shrink-on-load
+	// loaders before any pixels are decoded, declares too many pixels;
+	// animations count as the strip of all their frames.
+	Limits core.DecodeLimits
 }
 
 // Backend is a unified libvips-powered Decoder and Encoder.
 // Safe for concurrent use across goroutines.
 type Backend struct {
-	cfg BackendConfig
+	cfg     BackendConfig
+	jxlSave bool // probed at startup; libvips can load JXL without saving it
 }
 
 // NewBackend initialises libvips and returns a ready Backend.
@@ -42,7 +68,22 @@ func NewBackend(cfg BackendConfig) *Backend {
 		ReportLeaks:      cfg.ReportLeaks,
 		CollectStats:     true,
 	})
-	return &Backend{cfg: cfg}
+	return &Backend{cfg: cfg, jxlSave: probeJXLSave()}
+}
+
+// probeJXLSave reports whether the linked libvips can write JPEG XL, by
+// saving a 1×1 image.
+func probeJXLSave() bool {
+	if !govips.IsTypeSupported(govips.ImageTypeJXL) {
+		return false
+	}
+	ref, err := govips.Black(1, 1)
+	if err != nil {
+		return false
+	}
+	defer ref.Close()
+	_, _, err = ref.ExportJxl(nil)
+	return err == nil
 }
 
 // Shutdown releases all libvips resources. Call once at process exit.
@@ -54,12 +95,40 @@ func (b *Backend) Shutdown() {
 
 func (b *Backend) CanDecode(f core.Format) bool {
 	switch f {
-	case core.FormatJPEG, core.FormatPNG, core.FormatWebP, core.FormatUnknown:
+	case core.FormatJPEG, core.FormatPNG, core.FormatWebP, core.FormatGIF, core.FormatTIFF, core.FormatUnknown:
 		return true
+	case core.FormatAVIF, core.FormatHEIF, core.FormatPDF, core.FormatJXL:
+		return b.Supports(f)
+	case core.FormatRAW:
+		return b.cfg.RAW != nil && b.Supports(f)
 	}
 	return false
 }
 
+// Capabilities implements core.CapabilityReporter.  GIF and WebP
+// animations keep every frame unless BackendConfig.FirstFrameOnly is set.
+func (b *Backend) Capabilities(f core.Format) core.CodecCapabilities {
+	maxW, maxH := f.MaxDimensions()
+	if f == core.FormatJPEG {
+		maxW, maxH = 65500, 65500 // libvips' own JPEG limit
+	}
+	return core.CodecCapabilities{
+		Animation: !b.cfg.FirstFrameOnly && (f == core.FormatGIF || f == core.FormatWebP),
+		Alpha:     f.SupportsAlpha(),
+		MaxWidth:  maxW,
+		MaxHeight: maxH,
+	}
+}
+
+// Supports reports whether the linked libvips can load f.  HEIF/HEIC and
+// AVIF need libvips built with libheif, PDF with poppler or PDFium, JPEG XL
+// with libjxl, camera RAW with ImageMagick; other formats are always
+// available.
+func (b *Backend) Supports(f core.Format) bool {
+	t, ok := coreFormatToVips(f)
+	return ok && govips.IsTypeSupported(t)
+}
+
 func (b *Backend) Decode(ctx context.Context, r io.Reader) (*core.ImageData, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "vips.decode", err)
@@ -72,10 +141,118 @@ func (b *Backend) Decode(ctx context.Context, r io.Reader) (*core.ImageData, err
 	raw := utils.CloneBytes(buf.Bytes())
 	utils.ReleaseBuffer(buf)
 
-	ref, err := govips.NewImageFromBuffer(raw)
+	f := core.Format(utils.DetectFormat(raw))
+	if f == core.FormatRAW {
+		return b.decodeRAW(raw)
+	}
+	params := govips.NewImportParams()
+	if !b.cfg.FirstFrameOnly && (f == core.FormatGIF || f == core.FormatWebP) {
+		params.NumPages.Set(-1)
+	}
+	ref, err := govips.LoadImageFromBuffer(raw, params)
 	if err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryDecode, "vips.decode", err)
 	}
+	if err := b.checkLimits("vips.decode", ref); err != nil {
+		return nil, err
+	}
+	return wrapRef(raw, ref), nil
+}
+
+// checkLimits applies cfg.Limits to a freshly loaded ref, whose pixels
+// libvips has not decoded yet, and closes ref when it is refused.
+func (b *Backend) checkLimits(op string, ref *govips.ImageRef) error {
+	bpp := ref.Bands()
+	switch ref.BandFormat() {
+	case govips.BandFormatUshort, govips.BandFormatShort:
+		bpp *= 2
+	case govips.BandFormatFloat, govips.BandFormatUint, govips.BandFormatInt:
+		bpp *= 4
+	case govips.BandFormatDouble:
+		bpp *= 8
+	}
+	if err := b.cfg.Limits.Check(op, ref.Width(), ref.Height(), bpp); err != nil {
+		ref.Close()
+		return err
+	}
+	return nil
+}
+
+// PageCount implements core.PageDecoder for TIFF, PDF and other formats
+// libvips loads page by page.
+func (b *Backend) PageCount(ctx context.Context, data []byte) (int, error) {
+	if err := ctx.Err(); err != nil {
+		return 0, apperrors.Wrap(apperrors.CategoryDecode, "vips.pages", err)
+	}
+	ref, err := govips.LoadImageFromBuffer(data, govips.NewImportParams())
+	if err != nil {
+		return 0, apperrors.Wrap(apperrors.CategoryDecode, "vips.pages", err)
+	}
+	defer ref.Close()
+	return ref.Pages(), nil
+}
+
+// DecodePage implements core.PageDecoder.  page is 1-based.
+func (b *Backend) DecodePage(ctx context.Context, data []byte, page int) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "vips.decode_page", err)
+	}
+	if page < 1 {
+		return nil, apperrors.New(apperrors.CategoryInput, "vips.decode_page", apperrors.ErrInvalidDimensions)
+	}
+	params := govips.NewImportParams()
+	params.Page.Set(page - 1)
+	ref, err := govips.LoadImageFromBuffer(data, params)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "vips.decode_page", err)
+	}
+	if err := b.checkLimits("vips.decode_page", ref); err != nil {
+		return nil, err
+	}
+	img := wrapRef(data, ref)
+	if n := ref.Pages(); n > 1 {
+		img.Meta.Pages = n
+	}
+	return img, nil
+}
+
+// RasterizeSVG implements core.SVGRasterizer with libvips svgload
+// (librsvg), which supports text, filters and gradients the pure-Go
+// renderer lacks.  Sanitize data first; librsvg may otherwise resolve
+// external references.
+func (b *Backend) RasterizeSVG(ctx context.Context, data []byte, width int, dpi float64) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "vips.svg", err)
+	}
+	if !govips.IsTypeSupported(govips.ImageTypeSVG) {
+		return nil, apperrors.New(apperrors.CategoryDecode, "vips.svg",
+			fmt.Errorf("%w: libvips built without svgload", apperrors.ErrUnsupportedFormat))
+	}
+	var (
+		ref *govips.ImageRef
+		err error
+	)
+	if width > 0 {
+		// vips_thumbnail renders vector sources at the target size.
+		ref, err = govips.NewThumbnailWithSizeFromBuffer(data, width, svgMaxSide, govips.InterestingNone, govips.SizeBoth)
+	} else {
+		params := govips.NewImportParams()
+		if dpi > 0 {
+			params.Density.Set(int(dpi + 0.5))
+		}
+		ref, err = govips.LoadImageFromBuffer(data, params)
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "vips.svg", err)
+	}
+	return wrapRef(nil, ref), nil
+}
+
+// svgMaxSide bounds the height of width-driven SVG renders.
+const svgMaxSide = 16384
+
+// wrapRef builds the ImageData for a freshly loaded ref.
+func wrapRef(raw []byte, ref *govips.ImageRef) *core.ImageData {
 	runtime.SetFinalizer(ref, func(r *govips.ImageRef) { r.Close() })
 
 	format := vipsFormatToCore(ref.Format())
@@ -87,6 +264,11 @@ func (b *Backend) Decode(ctx context.Context, r io.Reader) (*core.ImageData, err
 		HasAlpha:    ref.HasAlpha(),
 		Orientation: ref.Orientation(),
 	}
+	if ph := ref.PageHeight(); ph > 0 && ph < ref.Height() {
+		// All frames of an animation, stacked vertically.
+		meta.Height = ph
+		meta.Frames = ref.Height() / ph
+	}
 	fields := ref.GetFields()
 	if len(fields) > 0 {
 		exif := make(map[string]string, len(fields))
@@ -105,15 +287,17 @@ func (b *Backend) Decode(ctx context.Context, r io.Reader) (*core.ImageData, err
 		Image:        &VipsImage{ref: ref},
 		Meta:         meta,
 		OriginalSize: int64(len(raw)),
-	}, nil
+	}
 }
 
 // ─── Encoder ──────────────────────────────────────────────────────────────────
 
 func (b *Backend) CanEncode(f core.Format) bool {
 	switch f {
-	case core.FormatJPEG, core.FormatPNG, core.FormatWebP:
+	case core.FormatJPEG, core.FormatPNG, core.FormatWebP, core.FormatGIF, core.FormatAVIF:
 		return true
+	case core.FormatJXL:
+		return b.jxlSave
 	}
 	return false
 }
@@ -123,47 +307,134 @@ func (b *Backend) Encode(ctx context.Context, img *core.ImageData, opts core.Enc
 		return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode", err)
 	}
 
-	vi, ok := img.Image.(*VipsImage)
-	if !ok || vi == nil {
-		return nil, apperrors.New(apperrors.CategoryEncode, "vips.encode",
-			fmt.Errorf("image must be decoded with the vips backend first"))
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode", err)
 	}
 
-	quality := opts.Quality
-	if quality <= 0 {
-		quality = b.cfg.DefaultQuality
+	quality := opts.QualityOr(b.cfg.DefaultQuality)
+	// libvips copies EXIF/XMP/ICC and loader-specific fields (software,
+	// timestamps) unless told to strip them.
+	strip := opts.StripEXIF || opts.Deterministic
+	// It cannot drop single tags, so a metadata policy is applied to JPEG
+	// and PNG output afterwards; other formats lose all metadata when the
+	// policy removes any.
+	policy := opts.Metadata
+	if strip || policy.IsZero() {
+		policy = core.MetadataPolicy{}
+	} else if img.Format != core.FormatJPEG && img.Format != core.FormatPNG {
+		strip = true
 	}
 
 	switch img.Format {
 	case core.FormatJPEG:
+		if vi.ref.HasAlpha() {
+			bg := color.NRGBA{255, 255, 255, 255}
+			if opts.Background != nil {
+				bg = color.NRGBAModel.Convert(opts.Background).(color.NRGBA)
+			}
+			if err := vi.ref.Flatten(&govips.Color{R: bg.R, G: bg.G, B: bg.B}); err != nil {
+				return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.jpeg", err)
+			}
+		}
 		ep := govips.NewJpegExportParams()
 		ep.Quality = quality
-		ep.StripMetadata = opts.StripEXIF
+		ep.StripMetadata = strip
 		ep.Interlace = opts.Interlaced
+		jo := opts.JPEG()
+		ep.SubsampleMode = vipsSubsample(jo.Subsample)
+		ep.TrellisQuant = jo.Trellis
+		ep.OvershootDeringing = jo.OvershootDeringing
+		ep.OptimizeScans = jo.OptimizeScans
+		ep.QuantTable = jo.QuantTable
 		buf, _, err := vi.ref.ExportJpeg(ep)
 		if err != nil {
 			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.jpeg", err)
 		}
-		return buf, nil
+		return applyPolicy(buf, policy)
 
 	case core.FormatPNG:
+		po := opts.PNG()
 		ep := govips.NewPngExportParams()
-		ep.StripMetadata = opts.StripEXIF
+		ep.StripMetadata = strip || po.StripMetadata
 		ep.Interlace = opts.Interlaced
+		if po.CompressionLevel > 0 {
+			ep.Compression = po.CompressionLevel
+		}
+		ep.Filter = vipsPngFilter(po.Filter)
+		if po.Reduce {
+			ep.Palette = true
+			ep.Quality = 100
+			if opts.Quality > 0 {
+				ep.Quality = opts.Quality
+			}
+		}
+		if po.Colors > 0 {
+			// libimagequant's palette size follows the bit depth.
+			ep.Palette = true
+			ep.Bitdepth = 8
+			for _, d := range []int{1, 2, 4} {
+				if po.Colors <= 1<<d {
+					ep.Bitdepth = d
+					break
+				}
+			}
+			ep.Dither = 1
+			if !po.Dither {
+				ep.Dither = 1e-6 // govips omits 0, which means the default of 1
+			}
+		}
 		buf, _, err := vi.ref.ExportPng(ep)
 		if err != nil {
 			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.png", err)
 		}
-		return buf, nil
+		return applyPolicy(buf, policy)
 
 	case core.FormatWebP:
-		ep := govips.NewWebpExportParams()
+		buf, err := exportWebP(vi.ref, newWebPParams(opts, quality, strip))
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.webp", err)
+		}
+		return buf, nil
+
+	case core.FormatGIF:
+		ep := govips.NewGifExportParams()
+		ep.StripMetadata = strip
+		buf, _, err := vi.ref.ExportGIF(ep)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.gif", err)
+		}
+		return buf, nil
+
+	case core.FormatAVIF:
+		ao := opts.AVIF()
+		ep := govips.NewAvifExportParams()
 		ep.Quality = quality
 		ep.Lossless = opts.Lossless
-		ep.StripMetadata = opts.StripEXIF
-		buf, _, err := vi.ref.ExportWebp(ep)
+		ep.StripMetadata = strip
+		if ao.Effort > 0 {
+			ep.Effort = min(ao.Effort, 9)
+		}
+		if ao.Bitdepth > 0 {
+			ep.Bitdepth = ao.Bitdepth
+		}
+		buf, _, err := vi.ref.ExportAvif(ep)
 		if err != nil {
-			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.webp", err)
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.avif", err)
+		}
+		return buf, nil
+
+	case core.FormatJXL:
+		if !b.jxlSave {
+			return nil, apperrors.New(apperrors.CategoryEncode, "vips.encode.jxl",
+				fmt.Errorf("%w: libvips built without JPEG XL save", apperrors.ErrUnsupportedFormat))
+		}
+		ep := govips.NewJxlExportParams()
+		ep.Quality = quality
+		ep.Lossless = opts.Lossless
+		buf, _, err := vi.ref.ExportJxl(ep)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode.jxl", err)
 		}
 		return buf, nil
 
@@ -173,6 +444,18 @@ func (b *Backend) Encode(ctx context.Context, img *core.ImageData, opts core.Enc
 	}
 }
 
+// applyPolicy rewrites the metadata of encoded JPEG or PNG bytes by p.
+func applyPolicy(buf []byte, p core.MetadataPolicy) ([]byte, error) {
+	if p.IsZero() {
+		return buf, nil
+	}
+	out, err := metadata.Rewrite(buf, p)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, "vips.encode", err)
+	}
+	return out, nil
+}
+
 // ─── VipsImage ────────────────────────────────────────────────────────────────
 
 // VipsImage wraps a *govips.ImageRef for storage in core.ImageData.Image.
@@ -185,36 +468,140 @@ func (v *VipsImage) Height() int             { return v.ref.Height() }
 func (v *VipsImage) Ref() *govips.ImageRef   { return v.ref }
 func (v *VipsImage) Close()                  { v.ref.Close() }
 
+// Bounds implements core.DecodedImage.
+func (v *VipsImage) Bounds() image.Rectangle { return image.Rect(0, 0, v.ref.Width(), v.ref.Height()) }
+
+// ColorModelHint implements core.StdImageConverter.
+func (v *VipsImage) ColorModelHint() core.ColorSpace {
+	switch {
+	case v.ref.Interpretation() == govips.InterpretationCMYK:
+		return core.ColorSpaceCMYK
+	case v.ref.Bands()-boolInt(v.ref.HasAlpha()) == 1:
+		return core.ColorSpaceGray
+	case v.ref.HasAlpha():
+		return core.ColorSpaceRGBA
+	}
+	return core.ColorSpaceRGB
+}
+
+// CloneImage implements core.ImageCloner with vips_copy, so in-place
+// operations on the copy, such as VipsResizeStep's, leave v untouched.
+func (v *VipsImage) CloneImage() (core.DecodedImage, error) {
+	ref, err := v.ref.Copy()
+	if err != nil {
+		return nil, err
+	}
+	runtime.SetFinalizer(ref, func(r *govips.ImageRef) { r.Close() })
+	return &VipsImage{ref: ref}, nil
+}
+
+// ToStdImage implements core.StdImageConverter by round-tripping the pixels
+// through a fast, lossless PNG, which keeps alpha and 16-bit samples.
+func (v *VipsImage) ToStdImage() (image.Image, error) {
+	ep := govips.NewPngExportParams()
+	ep.Compression = 1
+	ep.StripMetadata = true
+	buf, _, err := v.ref.ExportPng(ep)
+	if err != nil {
+		return nil, err
+	}
+	return png.Decode(bytes.NewReader(buf))
+}
+
+// vipsImage returns img.Image as a *VipsImage for a vips step.  Images
+// decoded by the standard-library backend, or left by one of its steps, are
+// imported through a fast PNG round trip and the conversion is reported to
+// ctx's core.ConversionObserver as "convert.to_vips".
+func vipsImage(ctx context.Context, img *core.ImageData) (*VipsImage, error) {
+	switch v := img.Image.(type) {
+	case nil:
+		return nil, apperrors.ErrEmptyInput
+	case *VipsImage:
+		if v == nil {
+			return nil, apperrors.ErrEmptyInput
+		}
+		return v, nil
+	case image.Image:
+		start := time.Now()
+		vi, err := importStd(v)
+		core.ReportConversion(ctx, "convert.to_vips", img, time.Since(start), err)
+		return vi, err
+	}
+	return nil, fmt.Errorf("%T cannot be converted to a vips image", img.Image)
+}
+
+func importStd(src image.Image) (*VipsImage, error) {
+	var buf bytes.Buffer
+	enc := png.Encoder{CompressionLevel: png.BestSpeed}
+	if err := enc.Encode(&buf, src); err != nil {
+		return nil, err
+	}
+	ref, err := govips.LoadImageFromBuffer(buf.Bytes(), govips.NewImportParams())
+	if err != nil {
+		return nil, err
+	}
+	return &VipsImage{ref: ref}, nil
+}
+
 // ─── VipsResizeStep ───────────────────────────────────────────────────────────
 
-// VipsResizeStep resizes using vips_resize() with Lanczos3 kernel.
+// VipsResizeStep resizes using vips_resize() with Lanczos3 kernel unless
+// Kernel selects another filter.
 // For JPEG: triggers shrink-on-load so the full bitmap is never allocated.
 type VipsResizeStep struct {
 	Width, Height int
+	Kernel        core.Kernel
+	// PostSharpen applies vips_sharpen after downscaling, as on
+	// pipeline.ResizeStep; the amount scales its jaggy-area slope, with 1
+	// matching the libvips default.  0 takes config.Config.PostSharpen when
+	// run by a Processor; negative disables.
+	PostSharpen float64
 }
 
 func (s *VipsResizeStep) Name() string { return "vips.resize" }
 
+// BindConfig implements core.ConfigBinder.
+func (s *VipsResizeStep) BindConfig(cfg config.Config) core.Step {
+	if s.PostSharpen != 0 || cfg.PostSharpen == 0 {
+		return s
+	}
+	cp := *s
+	cp.PostSharpen = cfg.PostSharpen
+	return &cp
+}
+
 func (s *VipsResizeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
-	vi, ok := img.Image.(*VipsImage)
-	if !ok || vi == nil {
-		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(),
-			fmt.Errorf("expected *VipsImage; use vips backend for decode"))
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
 	dstW, dstH := utils.ScaleDimensions(img.Meta.Width, img.Meta.Height, s.Width, s.Height)
 	if dstW == img.Meta.Width && dstH == img.Meta.Height {
 		return img, nil
 	}
 	scale := float64(dstW) / float64(img.Meta.Width)
-	if err := vi.ref.Resize(scale, govips.KernelLanczos3); err != nil {
+	vscale := -1.0
+	if img.Meta.Frames > 1 {
+		// Scale each frame to exactly dstH so the strip splits evenly.
+		vscale = float64(dstH) / float64(img.Meta.Height)
+	}
+	if err := vi.ref.ResizeWithVScale(scale, vscale, vipsKernel(s.Kernel)); err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
+	if s.PostSharpen > 0 && scale < 1 {
+		if err := vi.ref.Sharpen(0.5, 2, 3*s.PostSharpen); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+	}
 	out := *img
 	out.Meta.Width = vi.ref.Width()
 	out.Meta.Height = vi.ref.Height()
+	if img.Meta.Frames > 1 {
+		out.Meta.Height = vi.ref.PageHeight()
+	}
 	return &out, nil
 }
 
@@ -261,18 +648,91 @@ func (s *VipsStripEXIFStep) Execute(_ context.Context, img *core.ImageData) (*co
 	out := *img
 	out.Meta.EXIF = nil
 	out.Meta.HasEXIF = false
+	out.Meta.Details = nil
 	out.Meta.Orientation = 0
 	return &out, nil
 }
 
+// ─── VipsToSRGBStep ───────────────────────────────────────────────────────────
+
+// VipsToSRGBStep is the libvips counterpart of pipeline.ToSRGBStep: the
+// pixels go through vips_icc_transform, and so lcms, from the embedded
+// profile to libvips' sRGB IEC61966-2.1 profile, which stays attached.
+// Images without a profile are left as they are.
+type VipsToSRGBStep struct {
+	Embed bool
+}
+
+func (s *VipsToSRGBStep) Name() string { return "vips.to_srgb" }
+
+func (s *VipsToSRGBStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	out := *img
+	if s.Embed {
+		out.Attrs = img.Attrs.With(core.AttrEmbedICC, true)
+	}
+	if !vi.ref.HasICCProfile() {
+		return &out, nil
+	}
+	if err := vi.ref.TransformICCProfile(govips.SRGBIEC6196621ICCProfilePath); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	out.Meta.ICCProfile = vi.ref.GetICCProfile()
+	out.Meta.ColorSpace = core.ColorSpaceRGB
+	if vi.ref.HasAlpha() {
+		out.Meta.ColorSpace = core.ColorSpaceRGBA
+	}
+	return &out, nil
+}
+
 // ─── VipsAutoRotateStep ───────────────────────────────────────────────────────
 
 // VipsAutoRotateStep applies the EXIF orientation tag then strips it.
-type VipsAutoRotateStep struct{}
+//
+// Placed before DecodeStep it turns a JPEG losslessly with losslessjpeg
+// when the size allows, so a JPEG-to-JPEG pipeline does not lose quality
+// to the rotation.  When it cannot (partial MCUs, or a JPEG losslessjpeg
+// does not read, such as an arithmetic-coded one) it decodes with Backend
+// and rotates the pixels; with Backend nil it leaves the image as it was.
+// Placed after DecodeStep it always rotates the decoded pixels.
+type VipsAutoRotateStep struct {
+	Backend *Backend
+}
 
 func (s *VipsAutoRotateStep) Name() string { return "vips.auto_rotate" }
 
-func(s *VipsAutoRotateStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+func (s *VipsAutoRotateStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if img.Image == nil && img.Format == core.FormatJPEG && len(img.Data) > 0 {
+		o := losslessjpeg.Orientation(img.Data)
+		if o <= 1 {
+			return img, nil
+		}
+		if data, ok, err := losslessjpeg.AutoOrient(img.Data); err == nil && ok {
+			out := *img
+			out.Data = data
+			if o >= 5 { // transposed
+				out.Meta.Width, out.Meta.Height = img.Meta.Height, img.Meta.Width
+			}
+			out.Meta.Orientation = 0
+			return &out, nil
+		}
+		if s.Backend == nil {
+			return img, nil
+		}
+		decoded, err := s.Backend.Decode(ctx, bytes.NewReader(img.Data))
+		if err != nil {
+			return nil, err
+		}
+		decoded.OriginalSize = img.OriginalSize
+		decoded.Codecs.Decoder = core.CodecName(s.Backend)
+		img = decoded
+	}
 	vi, ok := img.Image.(*VipsImage)
 	if !ok || vi == nil {
 		return img, nil
@@ -287,13 +747,566 @@ func(s *VipsAutoRotateStep) Execute(_ context.Context, img *core.ImageData) (*co
 	return &out, nil
 }
 
+// ─── VipsAdjustStep ───────────────────────────────────────────────────────────
+
+// VipsAdjustStep is the libvips counterpart of pipeline.AdjustStep, with the
+// same relative sliders.  Brightness, Saturation and Hue go through
+// Modulate in LCh, so results differ slightly from the pure-Go step;
+// Contrast stretches around mid-gray.
+type VipsAdjustStep struct {
+	Brightness float64
+	Contrast   float64
+	Saturation float64
+	Hue        float64
+}
+
+func (s *VipsAdjustStep) Name() string { return "vips.adjust" }
+
+func (s *VipsAdjustStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	ref := vi.ref
+	if s.Brightness != 0 || s.Saturation != 0 || s.Hue != 0 {
+		if err := ref.Modulate(max(0, 1+s.Brightness), max(0, 1+s.Saturation), s.Hue); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+	}
+	if s.Contrast != 0 {
+		format := ref.BandFormat()
+		mid := 127.5
+		if format == govips.BandFormatUshort {
+			mid = 32767.5
+		}
+		c := max(0, 1+s.Contrast)
+		a, b := make([]float64, ref.Bands()), make([]float64, ref.Bands())
+		for i := range a {
+			a[i] = 1
+			if !ref.HasAlpha() || i < len(a)-1 {
+				a[i], b[i] = c, mid*(1-c)
+			}
+		}
+		if err := ref.Linear(a, b); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		if err := ref.Cast(format); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+	}
+	return img, nil
+}
+
+// ─── VipsGammaStep ────────────────────────────────────────────────────────────
+
+// VipsGammaStep is the libvips counterpart of pipeline.GammaStep, built on
+// vips_gamma.  Alpha is split off first so it is not corrected with the
+// colour bands.
+type VipsGammaStep struct {
+	Gamma float64
+}
+
+func (s *VipsGammaStep) Name() string { return "vips.gamma" }
+
+func (s *VipsGammaStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Gamma == 0 || s.Gamma == 1 {
+		return img, nil
+	}
+	if s.Gamma < 0 {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("gamma %g must be positive", s.Gamma))
+	}
+	ref := vi.ref
+	if !ref.HasAlpha() {
+		if err := ref.Gamma(s.Gamma); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		return img, nil
+	}
+	alpha, err := ref.ExtractBandToImage(ref.Bands()-1, 1)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	defer alpha.Close()
+	if err := ref.ExtractBand(0, ref.Bands()-1); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if err := ref.Gamma(s.Gamma); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if err := ref.BandJoin(alpha); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	return img, nil
+}
+
+// ─── VipsAutoLevelStep ────────────────────────────────────────────────────────
+
+// VipsAutoLevelStep is the libvips counterpart of pipeline.AutoLevelStep:
+// the clip points come from the histogram of a B_W copy and the stretch is
+// applied to the colour bands with vips_linear.  Unlike the pure-Go step,
+// transparent pixels are counted too.
+type VipsAutoLevelStep struct {
+	// Clip is the fraction of pixels allowed to saturate at each end.
+	// Default 0.005; negative means none.
+	Clip float64
+}
+
+func (s *VipsAutoLevelStep) Name() string { return "vips.auto_level" }
+
+func (s *VipsAutoLevelStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	clip := s.Clip
+	if clip == 0 {
+		clip = 0.005
+	}
+	clip = max(0, min(clip, 0.49))
+
+	ref := vi.ref
+	hist, err := lumaHistogram(ref)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	total := 0
+	for _, n := range hist {
+		total += n
+	}
+	cut := int(clip * float64(total))
+	lo, acc := 0, 0
+	for ; lo < 255; lo++ {
+		if acc += hist[lo]; acc > cut {
+			break
+		}
+	}
+	hi, acc := 255, 0
+	for ; hi > 0; hi-- {
+		if acc += hist[hi]; acc > cut {
+			break
+		}
+	}
+	if hi <= lo || (lo == 0 && hi == 255) {
+		return img, nil
+	}
+
+	format := ref.BandFormat()
+	scale := 1.0
+	if format == govips.BandFormatUshort {
+		scale = 257
+	}
+	gain := 255 / float64(hi-lo)
+	a, b := make([]float64, ref.Bands()), make([]float64, ref.Bands())
+	for i := range a {
+		a[i] = 1
+		if !ref.HasAlpha() || i < len(a)-1 {
+			a[i], b[i] = gain, -float64(lo)*scale*gain
+		}
+	}
+	if err := ref.Linear(a, b); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if err := ref.Cast(format); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	return img, nil
+}
+
+// lumaHistogram returns the 8-bit luma histogram of ref, alpha excluded.
+func lumaHistogram(ref *govips.ImageRef) ([256]int, error) {
+	var hist [256]int
+	luma, err := ref.Copy()
+	if err != nil {
+		return hist, err
+	}
+	defer luma.Close()
+	if luma.HasAlpha() {
+		if err := luma.ExtractBand(0, luma.Bands()-1); err != nil {
+			return hist, err
+		}
+	}
+	if luma.Bands() >= 3 {
+		if err := luma.ToColorSpace(govips.InterpretationBW); err != nil {
+			return hist, err
+		}
+	}
+	if luma.BandFormat() == govips.BandFormatUshort {
+		if err := luma.Linear1(1.0/257, 0); err != nil {
+			return hist, err
+		}
+	}
+	if luma.BandFormat() != govips.BandFormatUchar {
+		if err := luma.Cast(govips.BandFormatUchar); err != nil {
+			return hist, err
+		}
+	}
+	if err := luma.HistogramFind(); err != nil {
+		return hist, err
+	}
+	raw, err := luma.ToBytes()
+	if err != nil {
+		return hist, err
+	}
+	// vips_hist_find produces a 256×1 uint image.
+	if len(raw) < 4*len(hist) {
+		return hist, fmt.Errorf("unexpected histogram size %d", len(raw))
+	}
+	for i := range hist {
+		hist[i] = int(binary.NativeEndian.Uint32(raw[4*i:]))
+	}
+	return hist, nil
+}
+
+// ─── VipsSmartCropStep ────────────────────────────────────────────────────────
+
+// VipsSmartCropStep is the libvips counterpart of pipeline.SmartCropStep:
+// it scales the image to cover Width×Height, then crops with
+// vips_smartcrop.  An empty Strategy means core.CropAttention.
+type VipsSmartCropStep struct {
+	Width, Height int
+	Strategy      core.CropStrategy
+}
+
+func (s *VipsSmartCropStep) Name() string { return "vips.smart_crop" }
+
+func (s *VipsSmartCropStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+	w, h := float64(vi.ref.Width()), float64(vi.ref.Height())
+	scale := math.Max(float64(s.Width)/w, float64(s.Height)/h)
+	rw := max(math.Round(w*scale), float64(s.Width))
+	rh := max(math.Round(h*scale), float64(s.Height))
+	if err := vi.ref.ResizeWithVScale(rw/w, rh/h, govips.KernelLanczos3); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	cw, ch := min(s.Width, vi.ref.Width()), min(s.Height, vi.ref.Height())
+	if err := vi.ref.SmartCrop(cw, ch, vipsInteresting(s.Strategy)); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	out := *img
+	out.Meta.Width = vi.ref.Width()
+	out.Meta.Height = vi.ref.Height()
+	return &out, nil
+}
+
+// ─── VipsTextOverlayStep ──────────────────────────────────────────────────────
+
+// VipsTextOverlayStep is the libvips counterpart of
+// pipeline.TextOverlayStep.  Text is laid out by Pango, so Font is a font
+// description ("Sans", "DejaVu Serif Bold") rather than font data, and
+// Pango markup in Text is honoured.  Lines wrap at the Padding margins.
+type VipsTextOverlayStep struct {
+	Text string
+	// Font is a Pango font family and style.  Default "Sans".
+	Font string
+	// Size is the font size in pixels.  Default 5% of the image height,
+	// at least 12.
+	Size     float64
+	Color    color.Color  // default white
+	Position core.Gravity // default core.GravitySouth
+	// Padding is the margin to the image edges in pixels.  Default half of
+	// Size; negative means none.
+	Padding int
+}
+
+func (s *VipsTextOverlayStep) Name() string { return "vips.text_overlay" }
+
+func (s *VipsTextOverlayStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if strings.TrimSpace(s.Text) == "" {
+		return img, nil
+	}
+	w, h := vi.ref.Width(), vi.ref.Height()
+	size := s.Size
+	if size <= 0 {
+		size = math.Max(12, float64(h)*0.05)
+	}
+	pad := s.Padding
+	if pad == 0 {
+		pad = int(size / 2)
+	}
+	pad = max(0, pad)
+	family := s.Font
+	if family == "" {
+		family = "Sans"
+	}
+	gravity := s.Position
+	if gravity == "" {
+		gravity = core.GravitySouth
+	}
+	align := govips.AlignCenter
+	switch gravity {
+	case core.GravityWest, core.GravityNorthWest, core.GravitySouthWest:
+		align = govips.AlignLow
+	case core.GravityEast, core.GravityNorthEast, core.GravitySouthEast:
+		align = govips.AlignHigh
+	}
+	params := &govips.LabelParams{
+		Text: s.Text,
+		// vips_text renders at 72 dpi, so points are pixels.
+		Font:      fmt.Sprintf("%s %g", family, size),
+		Width:     govips.ValueOf(float64(max(1, w-2*pad))),
+		Opacity:   1,
+		Color:     govips.Color{R: 255, G: 255, B: 255},
+		Alignment: align,
+	}
+	if s.Color != nil {
+		c := color.NRGBAModel.Convert(s.Color).(color.NRGBA)
+		params.Color = govips.Color{R: c.R, G: c.G, B: c.B}
+		params.Opacity = float32(c.A) / 255
+	}
+
+	// Render the label once on a blank canvas to measure the text block,
+	// which vips_text only reveals after layout.
+	canvas, err := govips.Black(w, h)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	defer canvas.Close()
+	probe := *params
+	probe.Opacity, probe.Color = 1, govips.Color{R: 255, G: 255, B: 255}
+	if err := canvas.Label(&probe); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	left, top, tw, th, err := canvas.FindTrim(10, &govips.Color{})
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	// Shift the measured ink box to its anchored position; lines keep
+	// their alignment relative to one another.
+	bx, by := gravity.Offset(w-2*pad, h-2*pad, tw, th)
+	params.OffsetX = govips.ValueOf(float64(pad + bx - left))
+	params.OffsetY = govips.ValueOf(float64(pad + by - top))
+	if err := vi.ref.Label(params); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	return img, nil
+}
+
+// ─── VipsPerspectiveStep ──────────────────────────────────────────────────────
+
+// VipsPerspectiveStep is the libvips counterpart of pipeline.PerspectiveStep.
+// The homography is evaluated over a vips_xyz coordinate image and the source
+// resampled with vips_mapim; pixels mapping outside the source come out
+// black, or transparent when the image has alpha.
+type VipsPerspectiveStep struct {
+	Quad [4]image.Point
+	// Width and Height size the output.  Zero uses the longer of each pair
+	// of opposite edges of Quad.
+	Width, Height int
+}
+
+func (s *VipsPerspectiveStep) Name() string { return "vips.perspective" }
+
+func (s *VipsPerspectiveStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	qw, qh := utils.QuadSize(s.Quad)
+	w, h := cmp.Or(s.Width, qw), cmp.Or(s.Height, qh)
+	if w <= 0 || h <= 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+	m, ok := utils.PerspectiveMatrix(s.Quad, w, h)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("degenerate quad %v", s.Quad))
+	}
+
+	index, err := govips.XYZ(w, h)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	defer index.Close()
+	if err := index.Recomb([][]float64{{m[0], m[1]}, {m[3], m[4]}, {m[6], m[7]}}); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if err := index.Linear([]float64{1, 1, 1}, []float64{m[2], m[5], m[8]}); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	den, err := index.ExtractBandToImage(2, 1)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	defer den.Close()
+	if err := index.ExtractBand(0, 2); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if err := index.Divide(den); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if err := vi.ref.Mapim(index); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+
+	out := *img
+	out.Meta.Width = vi.ref.Width()
+	out.Meta.Height = vi.ref.Height()
+	return &out, nil
+}
+
+// ─── VipsTrimStep ─────────────────────────────────────────────────────────────
+
+// VipsTrimStep is the libvips counterpart of pipeline.TrimStep, built on
+// vips_find_trim.  Tolerance is its threshold; Background defaults to the
+// top-left pixel.  An image that is entirely background is left unchanged.
+type VipsTrimStep struct {
+	Tolerance  float64 // default 10
+	Background color.Color
+}
+
+func (s *VipsTrimStep) Name() string { return "vips.trim" }
+
+func (s *VipsTrimStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	vi, err := vipsImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	tol := s.Tolerance
+	if tol <= 0 {
+		tol = 10
+	}
+	var bg govips.Color
+	if s.Background != nil {
+		c := color.NRGBAModel.Convert(s.Background).(color.NRGBA)
+		bg = govips.Color{R: c.R, G: c.G, B: c.B}
+	} else {
+		p, err := vi.ref.GetPoint(0, 0)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		ch := func(i int) uint8 { return uint8(math.Round(p[min(i, len(p)-1)])) }
+		bg = govips.Color{R: ch(0), G: ch(1), B: ch(2)}
+		if vi.ref.Bands() < 3 {
+			bg.G, bg.B = bg.R, bg.R
+		}
+	}
+	left, top, w, h, err := vi.ref.FindTrim(tol, &bg)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if w <= 0 || h <= 0 || (w == vi.ref.Width() && h == vi.ref.Height()) {
+		return img, nil
+	}
+	if err := vi.ref.ExtractArea(left, top, w, h); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	out := *img
+	out.Meta.Width = w
+	out.Meta.Height = h
+	return &out, nil
+}
+
+// ─── PDFPageStep ───────────────────────────────────────────────────────────────
+
+// PDFPageStep renders one page of a PDF in img.Data with libvips pdfload
+// (poppler or PDFium), in place of Decode, for document previews.  Page
+// counts from 1 (0 = first); DPI sets the render resolution (0 = 72, one
+// pixel per PDF point).  The result is PNG-bound; follow with
+// VipsThumbnailStep and ConvertFormat for a JPEG preview.
+type PDFPageStep struct {
+	Page int
+	DPI  float64
+}
+
+func (s *PDFPageStep) Name() string { return "vips.pdf_page" }
+
+func (s *PDFPageStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if len(img.Data) == 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrEmptyInput)
+	}
+	if f := core.Format(utils.DetectFormat(img.Data)); f != core.FormatPDF {
+		return nil, apperrors.New(apperrors.CategoryInput, s.Name(),
+			fmt.Errorf("%w: %s is not a PDF", apperrors.ErrUnsupportedFormat, f))
+	}
+	if !govips.IsTypeSupported(govips.ImageTypePDF) {
+		return nil, apperrors.New(apperrors.CategoryDecode, s.Name(),
+			fmt.Errorf("%w: libvips built without pdfload", apperrors.ErrUnsupportedFormat))
+	}
+	page := max(s.Page, 1)
+	params := govips.NewImportParams()
+	params.Page.Set(page - 1)
+	if s.DPI > 0 {
+		params.Density.Set(int(s.DPI + 0.5))
+	}
+	ref, err := govips.LoadImageFromBuffer(img.Data, params)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, s.Name(), err)
+	}
+	out := wrapRef(img.Data, ref)
+	out.Format = core.FormatPNG
+	out.Meta.Format = core.FormatPDF
+	out.OriginalSize = img.OriginalSize
+	if n := ref.Pages(); n > 1 {
+		out.Meta.Pages = n
+	}
+	return out, nil
+}
+
 // ─── RegisterVipsBackend ──────────────────────────────────────────────────────
 
-// RegisterVipsBackend replaces Go stdlib codecs with libvips for all formats.
+// RegisterVipsBackend puts libvips ahead of the Go stdlib codecs for all
+// formats; the stdlib codecs stay registered as fallbacks.
+// TIFF, PDF, HEIF/HEIC and, when BackendConfig.RAW is set, camera RAW are
+// registered for decoding only; JPEG XL for
+// whichever of load and save libvips supports, for experimental variants
+// via ConvertFormat(core.FormatJXL).  Formats the linked libvips cannot
+// load (see Supports) are skipped, so uploads in them fail with
+// ErrUnsupportedFormat rather than a loader error.
 func RegisterVipsBackend(reg core.Registry, b *Backend) {
-	for _, f := range []core.Format{core.FormatJPEG, core.FormatPNG, core.FormatWebP} {
-		reg.RegisterDecoder(f, b)
-		reg.RegisterEncoder(f, b)
+	for _, f := range []core.Format{core.FormatJPEG, core.FormatPNG, core.FormatWebP, core.FormatGIF, core.FormatAVIF} {
+		if b.CanDecode(f) {
+			reg.RegisterDecoder(f, b)
+			reg.RegisterEncoder(f, b)
+		}
+	}
+	for _, f := range []core.Format{core.FormatTIFF, core.FormatPDF, core.FormatHEIF, core.FormatRAW} {
+		if b.CanDecode(f) {
+			reg.RegisterDecoder(f, b)
+		}
+	}
+	// JPEG XL load and save are probed separately.
+	if b.CanDecode(core.FormatJXL) {
+		reg.RegisterDecoder(core.FormatJXL, b)
+	}
+	if b.CanEncode(core.FormatJXL) {
+		reg.RegisterEncoder(core.FormatJXL, b)
 	}
 }
 
@@ -307,11 +1320,108 @@ func vipsFormatToCore(f govips.ImageType) core.Format {
 		return core.FormatPNG
 	case govips.ImageTypeWEBP:
 		return core.FormatWebP
+	case govips.ImageTypeGIF:
+		return core.FormatGIF
+	case govips.ImageTypeTIFF:
+		return core.FormatTIFF
+	case govips.ImageTypeBMP:
+		return core.FormatBMP
+	case govips.ImageTypeAVIF:
+		return core.FormatAVIF
+	case govips.ImageTypeHEIF:
+		return core.FormatHEIF
+	case govips.ImageTypePDF:
+		return core.FormatPDF
+	case govips.ImageTypeJXL:
+		return core.FormatJXL
 	default:
 		return core.FormatUnknown
 	}
 }
 
+func coreFormatToVips(f core.Format) (govips.ImageType, bool) {
+	switch f {
+	case core.FormatJPEG:
+		return govips.ImageTypeJPEG, true
+	case core.FormatPNG:
+		return govips.ImageTypePNG, true
+	case core.FormatWebP:
+		return govips.ImageTypeWEBP, true
+	case core.FormatGIF:
+		return govips.ImageTypeGIF, true
+	case core.FormatTIFF:
+		return govips.ImageTypeTIFF, true
+	case core.FormatBMP:
+		return govips.ImageTypeBMP, true
+	case core.FormatAVIF:
+		return govips.ImageTypeAVIF, true
+	case core.FormatHEIF:
+		return govips.ImageTypeHEIF, true
+	case core.FormatPDF:
+		return govips.ImageTypePDF, true
+	case core.FormatJXL:
+		return govips.ImageTypeJXL, true
+	case core.FormatRAW:
+		return govips.ImageTypeMagick, true
+	default:
+		return govips.ImageTypeUnknown, false
+	}
+}
+
+// vipsSubsample maps a chroma layout to libvips, which only knows 4:2:0 (on)
+// and 4:4:4 (off).  4:2:2 maps to off so chroma is never coarser than asked.
+func vipsSubsample(m core.SubsampleMode) govips.SubsampleMode {
+	switch m {
+	case core.Subsample420:
+		return govips.VipsForeignSubsampleOn
+	case core.Subsample444, core.Subsample422:
+		return govips.VipsForeignSubsampleOff
+	default:
+		return govips.VipsForeignSubsampleAuto
+	}
+}
+
+func vipsPngFilter(f core.PNGFilter) govips.PngFilter {
+	switch f {
+	case core.PNGFilterNone:
+		return govips.PngFilterNone
+	case core.PNGFilterSub:
+		return govips.PngFilterSub
+	case core.PNGFilterUp:
+		return govips.PngFilterUo
+	case core.PNGFilterAverage:
+		return govips.PngFilterAvg
+	case core.PNGFilterPaeth:
+		return govips.PngFilterPaeth
+	default:
+		return govips.PngFilterAll
+	}
+}
+
+func vipsInteresting(c core.CropStrategy) govips.Interesting {
+	switch c {
+	case core.CropCentre:
+		return govips.InterestingCentre
+	case core.CropEntropy:
+		return govips.InterestingEntropy
+	default:
+		return govips.InterestingAttention
+	}
+}
+
+func vipsKernel(k core.Kernel) govips.Kernel {
+	switch k {
+	case core.KernelNearest:
+		return govips.KernelNearest
+	case core.KernelBilinear:
+		return govips.KernelLinear
+	case core.KernelCatmullRom:
+		return govips.KernelCubic
+	default:
+		return govips.KernelLanczos3
+	}
+}
+
 func vipsInterpretationToColorSpace(i govips.Interpretation) core.ColorSpace {
 	switch i {
 	case govips.InterpretationSRGB, govips.InterpretationRGB16:
@@ -328,7 +1438,20 @@ func vipsInterpretationToColorSpace(i govips.Interpretation) core.ColorSpace {
 // compile-time interface checks
 var _ core.Decoder = (*Backend)(nil)
 var _ core.Encoder = (*Backend)(nil)
+var _ core.SVGRasterizer = (*Backend)(nil)
+var _ core.DecodedImage = (*VipsImage)(nil)
+var _ core.StdImageConverter = (*VipsImage)(nil)
 var _ core.Step   = (*VipsResizeStep)(nil)
 var _ core.Step   = (*VipsThumbnailStep)(nil)
 var _ core.Step   = (*VipsStripEXIFStep)(nil)
 var _ core.Step   = (*VipsAutoRotateStep)(nil)
+var _ core.Step   = (*VipsAdjustStep)(nil)
+var _ core.Step   = (*VipsGammaStep)(nil)
+var _ core.Step   = (*VipsAutoLevelStep)(nil)
+var _ core.Step   = (*VipsCLAHEStep)(nil)
+var _ core.Step   = (*VipsSmartCropStep)(nil)
+var _ core.Step   = (*VipsTextOverlayStep)(nil)
+var _ core.Step   = (*VipsPerspectiveStep)(nil)
+var _ core.Step   = (*VipsTrimStep)(nil)
+var _ core.Step   = (*PDFPageStep)(nil)
+var _ core.ConfigBinder = (*VipsResizeStep)(nil)
@@ -0,0 +1,97 @@
+package vips
+
+/*
+#cgo pkg-config: vips
+#include <vips/vips.h>
+
+// webpsave runs vips_webpsave_buffer with the options govips sets plus
+// alpha_q, which govips does not bind.  Like govips it embeds no ICC
+// profile; EncodeOptions.EmbedICC writes one afterwards.
+static int webpsave(VipsImage *in, int q, int alpha_q, int effort, int lossless,
+		int near_lossless, int strip, void **out, size_t *out_len) {
+	return vips_webpsave_buffer(in, out, out_len,
+		"Q", q,
+		"alpha_q", alpha_q,
+		"reduction_effort", effort,
+		"lossless", lossless,
+		"near_lossless", near_lossless,
+		"strip", strip,
+		"profile", "none",
+		NULL);
+}
+*/
+import "C"
+
+import (
+	"errors"
+	"reflect"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	govips "github.com/davidbyttow/govips/v2/vips"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// webpParams are the vips_webpsave options an encode maps to.
+type webpParams struct {
+	Quality      int // Q, 1-100
+	AlphaQuality int // alpha_q, 1-100
+	Effort       int // reduction_effort: libwebp's method, 0-6
+	Lossless     bool
+	NearLossless bool
+	Strip        bool
+}
+
+// newWebPParams maps opts onto webpsave; quality is the resolved lossy
+// quality and strip whether to drop metadata.
+func newWebPParams(opts core.EncodeOptions, quality int, strip bool) webpParams {
+	wo := opts.WebP()
+	p := webpParams{
+		Quality:      min(quality, 100),
+		AlphaQuality: 100,
+		Effort:       wo.Method(),
+		Lossless:     opts.Lossless || wo.NearLossless,
+		NearLossless: wo.NearLossless,
+		Strip:        strip,
+	}
+	if wo.AlphaQuality > 0 {
+		p.AlphaQuality = min(wo.AlphaQuality, 100)
+	}
+	return p
+}
+
+// exportWebP encodes ref as WebP with p.
+func exportWebP(ref *govips.ImageRef, p webpParams) ([]byte, error) {
+	in := vipsImagePtr(ref)
+	if in == nil {
+		return nil, errors.New("govips image has no VipsImage")
+	}
+	var (
+		out    unsafe.Pointer
+		outLen C.size_t
+	)
+	code := C.webpsave(in, C.int(p.Quality), C.int(p.AlphaQuality), C.int(p.Effort),
+		C.int(boolInt(p.Lossless)), C.int(boolInt(p.NearLossless)), C.int(boolInt(p.Strip)), &out, &outLen)
+	runtime.KeepAlive(ref)
+	if code != 0 {
+		msg := strings.TrimSpace(C.GoString(C.vips_error_buffer()))
+		C.vips_error_clear()
+		return nil, errors.New(msg)
+	}
+	buf := C.GoBytes(out, C.int(outLen))
+	C.g_free(C.gpointer(out))
+	return buf, nil
+}
+
+// vipsImagePtr returns the VipsImage behind ref.  govips keeps it in an
+// unexported field and offers no save with alpha_q, so it is read through
+// reflection; nil if a govips upgrade renames the field.
+func vipsImagePtr(ref *govips.ImageRef) *C.VipsImage {
+	f := reflect.ValueOf(ref).Elem().FieldByName("image")
+	if f.Kind() != reflect.Pointer || f.IsNil() {
+		return nil
+	}
+	return (*C.VipsImage)(f.UnsafePointer())
+}
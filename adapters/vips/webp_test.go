@@ -0,0 +1,38 @@
+package vips
+
+import (
+	"testing"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+func TestNewWebPParams(t *testing.T) {
+	webp := func(wo core.WebPOptions, lossless bool) core.EncodeOptions {
+		opts := core.EncodeOptions{Lossless: lossless}
+		*opts.WebP() = wo
+		return opts
+	}
+	for name, tc := range map[string]struct {
+		opts core.EncodeOptions
+		want webpParams
+	}{
+		"defaults": {core.EncodeOptions{}, webpParams{Quality: 80, AlphaQuality: 100, Effort: 4}},
+		"fastest": {webp(core.WebPOptions{Effort: core.WebPEffortFastest}, false),
+			webpParams{Quality: 80, AlphaQuality: 100, Effort: 0}},
+		"capped": {webp(core.WebPOptions{Effort: 9, AlphaQuality: 300}, false),
+			webpParams{Quality: 80, AlphaQuality: 100, Effort: 6}},
+		"alpha quality": {webp(core.WebPOptions{Effort: 2, AlphaQuality: 40}, false),
+			webpParams{Quality: 80, AlphaQuality: 40, Effort: 2}},
+		"near lossless": {webp(core.WebPOptions{NearLossless: true}, false),
+			webpParams{Quality: 80, AlphaQuality: 100, Effort: 4, Lossless: true, NearLossless: true}},
+		"lossless": {webp(core.WebPOptions{}, true),
+			webpParams{Quality: 80, AlphaQuality: 100, Effort: 4, Lossless: true}},
+	} {
+		if got := newWebPParams(tc.opts, 80, false); got != tc.want {
+			t.Errorf("%s: got %+v, want %+v", name, got, tc.want)
+		}
+	}
+	if got := newWebPParams(core.EncodeOptions{}, 120, true); got.Quality != 100 || !got.Strip {
+		t.Errorf("quality 120, strip: got %+v", got)
+	}
+}
@@ -0,0 +1,223 @@
+// Package animation decodes multi-frame GIF, APNG and WebP images into fully
+// composited frames, and selects subsets of them for preview strips and
+// moderation sampling.
+package animation
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"image/png"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+	"golang.org/x/image/webp"
+)
+
+// Frame is one displayed frame of an animation, composited onto the full
+// canvas exactly as a viewer would show it.
+type Frame struct {
+	Index int
+	Image *image.NRGBA
+	// Delay is how long the frame stays on screen.
+	Delay time.Duration
+}
+
+// Attribute keys set on ImageData built by Frame.ImageData.
+const (
+	AttrIndex = "animation.index" // int: position in the source animation
+	AttrDelay = "animation.delay" // time.Duration: display time
+)
+
+// ImageData wraps the frame as a decoded PNG-format ImageData, ready for
+// pixel steps and an encode.
+func (f Frame) ImageData() *core.ImageData {
+	b := f.Image.Bounds()
+	return &core.ImageData{
+		Image:  f.Image,
+		Format: core.FormatPNG,
+		Meta: core.Metadata{
+			Width:      b.Dx(),
+			Height:     b.Dy(),
+			Format:     core.FormatPNG,
+			ColorSpace: core.ColorSpaceRGBA,
+			HasAlpha:   true,
+		},
+		Attrs: core.Attributes{AttrIndex: f.Index, AttrDelay: f.Delay},
+	}
+}
+
+// Decode returns every frame of data.  Still images decode to a single frame,
+// so callers need not check for animation first.
+func Decode(data []byte) ([]Frame, error) {
+	frames, _, err := decode(data)
+	return frames, err
+}
+
+// DecodeAnimation is Decode returning a core.Animation, which also carries
+// the loop count, for storing in ImageData.Image.
+func DecodeAnimation(data []byte) (*core.Animation, error) {
+	frames, loops, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	a := &core.Animation{
+		Frames:    make([]image.Image, len(frames)),
+		Delays:    make([]time.Duration, len(frames)),
+		LoopCount: loops,
+	}
+	for i, f := range frames {
+		a.Frames[i], a.Delays[i] = f.Image, f.Delay
+	}
+	return a, nil
+}
+
+func decode(data []byte) ([]Frame, int, error) {
+	var (
+		frames []Frame
+		loops  int
+		err    error
+	)
+	format := core.Format(utils.DetectFormat(data))
+	switch format {
+	case core.FormatGIF:
+		frames, loops, err = decodeGIF(data)
+	case core.FormatPNG:
+		frames, loops, err = decodeAPNG(data)
+	case core.FormatWebP:
+		frames, loops, err = decodeWebP(data)
+	default:
+		return nil, 0, apperrors.New(apperrors.CategoryDecode, "animation.decode",
+			fmt.Errorf("%w: %s", apperrors.ErrUnsupportedFormat, format))
+	}
+	if err != nil {
+		return nil, 0, apperrors.Wrap(apperrors.CategoryDecode, "animation.decode."+string(format), err)
+	}
+	if len(frames) == 0 {
+		return nil, 0, apperrors.New(apperrors.CategoryDecode, "animation.decode."+string(format), apperrors.ErrEmptyInput)
+	}
+	return frames, loops, nil
+}
+
+// still wraps a single decoded image as a one-frame animation.
+func still(data []byte, format core.Format) ([]Frame, error) {
+	var (
+		src image.Image
+		err error
+	)
+	switch format {
+	case core.FormatPNG:
+		src, err = png.Decode(bytes.NewReader(data))
+	case core.FormatWebP:
+		src, err = webp.Decode(bytes.NewReader(data))
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []Frame{{Image: toNRGBA(src)}}, nil
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	if n, ok := src.(*image.NRGBA); ok && n.Rect.Min == (image.Point{}) {
+		return n
+	}
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// snapshot copies the canvas so later frames cannot alter an emitted one.
+func snapshot(canvas *image.NRGBA) *image.NRGBA {
+	cp := *canvas
+	cp.Pix = bytes.Clone(canvas.Pix)
+	return &cp
+}
+
+// clearRect makes r fully transparent, the "dispose to background" operation
+// of all three formats.
+func clearRect(canvas *image.NRGBA, r image.Rectangle) {
+	draw.Draw(canvas, r, image.Transparent, image.Point{}, draw.Src)
+}
+
+// ── Selection ─────────────────────────────────────────────────────────────────
+
+type selectMode int
+
+const (
+	selectAll selectMode = iota
+	selectOne
+	selectEvery
+	selectEvenly
+)
+
+// Selection picks which frames to extract.  The zero value selects all.
+type Selection struct {
+	mode selectMode
+	n    int
+}
+
+// All selects every frame.
+func All() Selection { return Selection{} }
+
+// At selects the single frame i.  Negative i counts from the end, so -1 is
+// the last frame.
+func At(i int) Selection { return Selection{mode: selectOne, n: i} }
+
+// EveryNth selects frames 0, n, 2n, …
+func EveryNth(n int) Selection { return Selection{mode: selectEvery, n: n} }
+
+// Evenly selects n frames spread evenly from the first to the last.
+func Evenly(n int) Selection { return Selection{mode: selectEvenly, n: n} }
+
+// Indices returns the selected frame indices, ascending and without
+// duplicates, for an animation of total frames.
+func (s Selection) Indices(total int) ([]int, error) {
+	if total <= 0 {
+		return nil, nil
+	}
+	switch s.mode {
+	case selectOne:
+		i := s.n
+		if i < 0 {
+			i += total
+		}
+		if i < 0 || i >= total {
+			return nil, fmt.Errorf("%w: frame %d of %d", apperrors.ErrInvalidDimensions, s.n, total)
+		}
+		return []int{i}, nil
+	case selectEvery:
+		if s.n <= 0 {
+			return nil, fmt.Errorf("%w: every %d frames", apperrors.ErrInvalidDimensions, s.n)
+		}
+		idx := make([]int, 0, (total+s.n-1)/s.n)
+		for i := 0; i < total; i += s.n {
+			idx = append(idx, i)
+		}
+		return idx, nil
+	case selectEvenly:
+		if s.n <= 0 {
+			return nil, fmt.Errorf("%w: %d evenly spaced frames", apperrors.ErrInvalidDimensions, s.n)
+		}
+		if s.n == 1 {
+			return []int{0}, nil
+		}
+		idx := make([]int, 0, s.n)
+		for k := 0; k < s.n; k++ {
+			i := k * (total - 1) / (s.n - 1)
+			if len(idx) == 0 || idx[len(idx)-1] != i {
+				idx = append(idx, i)
+			}
+		}
+		return idx, nil
+	default:
+		idx := make([]int, total)
+		for i := range idx {
+			idx[i] = i
+		}
+		return idx, nil
+	}
+}
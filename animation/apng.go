@@ -0,0 +1,198 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"image"
+	"image/draw"
+	"image/png"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// APNG dispose_op and blend_op values.
+const (
+	apngDisposeNone       = 0
+	apngDisposeBackground = 1
+	apngDisposePrevious   = 2
+	apngBlendOver         = 1
+)
+
+// chunk is a PNG or RIFF chunk.
+type chunk struct {
+	typ  string
+	data []byte
+}
+
+// apngFrame is one fcTL control chunk and the image data that follows it.
+type apngFrame struct {
+	rect     image.Rectangle
+	delay    time.Duration
+	dispose  byte
+	blend    byte
+	payloads [][]byte // IDAT data, or fdAT data with the sequence number removed
+}
+
+// decodeAPNG splits an animated PNG into stand-alone PNG streams, one per
+// frame, decodes each with image/png and composites them.  A PNG without an
+// acTL chunk decodes as a single frame.
+func decodeAPNG(data []byte) ([]Frame, int, error) {
+	chunks, err := readPNGChunks(data)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var (
+		header   []chunk // IHDR and ancillary chunks shared by every frame
+		frames   []*apngFrame
+		cur      *apngFrame
+		animated bool
+		seenIDAT bool
+		plays    int
+	)
+	for _, c := range chunks {
+		switch c.typ {
+		case "acTL":
+			animated = true
+			if len(c.data) >= 8 {
+				plays = int(binary.BigEndian.Uint32(c.data[4:]))
+			}
+		case "fcTL":
+			if len(c.data) < 26 {
+				return nil, 0, errors.New("apng: short fcTL chunk")
+			}
+			d := c.data
+			w, h := int(binary.BigEndian.Uint32(d[4:])), int(binary.BigEndian.Uint32(d[8:]))
+			x, y := int(binary.BigEndian.Uint32(d[12:])), int(binary.BigEndian.Uint32(d[16:]))
+			num, den := binary.BigEndian.Uint16(d[20:]), binary.BigEndian.Uint16(d[22:])
+			if den == 0 {
+				den = 100
+			}
+			cur = &apngFrame{
+				rect:    image.Rect(x, y, x+w, y+h),
+				delay:   time.Duration(num) * time.Second / time.Duration(den),
+				dispose: d[24],
+				blend:   d[25],
+			}
+			frames = append(frames, cur)
+		case "IDAT":
+			seenIDAT = true
+			// The default image is only part of the animation when an fcTL
+			// precedes it.
+			if cur != nil {
+				cur.payloads = append(cur.payloads, c.data)
+			}
+		case "fdAT":
+			if cur != nil && len(c.data) > 4 {
+				cur.payloads = append(cur.payloads, c.data[4:])
+			}
+		case "IEND":
+		default:
+			if !seenIDAT {
+				header = append(header, c)
+			}
+		}
+	}
+	if !animated || len(frames) == 0 {
+		one, err := still(data, core.FormatPNG)
+		return one, 0, err
+	}
+	if len(header) == 0 || header[0].typ != "IHDR" || len(header[0].data) < 13 {
+		return nil, 0, errors.New("apng: missing IHDR")
+	}
+	ihdr := header[0].data
+	canvas := image.NewNRGBA(image.Rect(0, 0,
+		int(binary.BigEndian.Uint32(ihdr[0:])), int(binary.BigEndian.Uint32(ihdr[4:]))))
+
+	out := make([]Frame, 0, len(frames))
+	for i, f := range frames {
+		if len(f.payloads) == 0 {
+			continue
+		}
+		src, err := png.Decode(bytes.NewReader(framePNG(header, f)))
+		if err != nil {
+			return nil, 0, err
+		}
+		dispose := f.dispose
+		if i == 0 && dispose == apngDisposePrevious {
+			dispose = apngDisposeBackground
+		}
+		var prev *image.NRGBA
+		if dispose == apngDisposePrevious {
+			prev = snapshot(canvas)
+		}
+
+		op := draw.Src
+		if f.blend == apngBlendOver {
+			op = draw.Over
+		}
+		draw.Draw(canvas, f.rect, src, src.Bounds().Min, op)
+		out = append(out, Frame{Index: len(out), Image: snapshot(canvas), Delay: f.delay})
+
+		switch dispose {
+		case apngDisposeBackground:
+			clearRect(canvas, f.rect)
+		case apngDisposePrevious:
+			canvas = prev
+		}
+	}
+	return out, plays, nil
+}
+
+// framePNG builds a stand-alone PNG holding just frame f.
+func framePNG(header []chunk, f *apngFrame) []byte {
+	var buf bytes.Buffer
+	buf.WriteString(pngSignature)
+	for _, c := range header {
+		if c.typ == "IHDR" {
+			ihdr := bytes.Clone(c.data)
+			binary.BigEndian.PutUint32(ihdr[0:], uint32(f.rect.Dx()))
+			binary.BigEndian.PutUint32(ihdr[4:], uint32(f.rect.Dy()))
+			writePNGChunk(&buf, "IHDR", ihdr)
+			continue
+		}
+		writePNGChunk(&buf, c.typ, c.data)
+	}
+	for _, p := range f.payloads {
+		writePNGChunk(&buf, "IDAT", p)
+	}
+	writePNGChunk(&buf, "IEND", nil)
+	return buf.Bytes()
+}
+
+func readPNGChunks(data []byte) ([]chunk, error) {
+	if !bytes.HasPrefix(data, []byte(pngSignature)) {
+		return nil, errors.New("png: bad signature")
+	}
+	var chunks []chunk
+	for pos := len(pngSignature); pos+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n < 0 || pos+12+n > len(data) {
+			return nil, errors.New("png: truncated chunk")
+		}
+		c := chunk{typ: string(data[pos+4 : pos+8]), data: data[pos+8 : pos+8+n]}
+		chunks = append(chunks, c)
+		pos += 12 + n
+		if c.typ == "IEND" {
+			break
+		}
+	}
+	return chunks, nil
+}
+
+func writePNGChunk(buf *bytes.Buffer, typ string, data []byte) {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:4], uint32(len(data)))
+	copy(hdr[4:], typ)
+	buf.Write(hdr[:])
+	buf.Write(data)
+	crc := crc32.NewIEEE()
+	crc.Write(hdr[4:])
+	crc.Write(data)
+	binary.Write(buf, binary.BigEndian, crc.Sum32())
+}
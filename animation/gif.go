@@ -0,0 +1,48 @@
+package animation
+
+import (
+	"bytes"
+	"image"
+	"image/draw"
+	"image/gif"
+	"time"
+)
+
+func decodeGIF(data []byte) ([]Frame, int, error) {
+	g, err := gif.DecodeAll(bytes.NewReader(data))
+	if err != nil {
+		return nil, 0, err
+	}
+	canvas := image.NewNRGBA(image.Rect(0, 0, g.Config.Width, g.Config.Height))
+	frames := make([]Frame, 0, len(g.Image))
+	for i, p := range g.Image {
+		var prev *image.NRGBA
+		disposal := byte(0)
+		if i < len(g.Disposal) {
+			disposal = g.Disposal[i]
+		}
+		if disposal == gif.DisposalPrevious {
+			prev = snapshot(canvas)
+		}
+
+		draw.Draw(canvas, p.Bounds(), p, p.Bounds().Min, draw.Over)
+		f := Frame{Index: i, Image: snapshot(canvas)}
+		if i < len(g.Delay) {
+			f.Delay = time.Duration(g.Delay[i]) * 10 * time.Millisecond
+		}
+		frames = append(frames, f)
+
+		switch disposal {
+		case gif.DisposalBackground:
+			clearRect(canvas, p.Bounds())
+		case gif.DisposalPrevious:
+			canvas = prev
+		}
+	}
+	// GIF counts repeats after the first play; -1 means play once.
+	loops := g.LoopCount
+	if loops != 0 {
+		loops++
+	}
+	return frames, loops, nil
+}
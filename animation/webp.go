@@ -0,0 +1,129 @@
+package animation
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"image"
+	"image/draw"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	"golang.org/x/image/webp"
+)
+
+// ANMF flag bits.
+const (
+	anmfDispose = 0x01 // dispose to background after display
+	anmfNoBlend = 0x02 // overwrite instead of alpha-blending
+)
+
+// decodeWebP walks the RIFF container of an animated WebP, rewraps each
+// ANMF frame's bitstream as a stand-alone WebP for x/image/webp and
+// composites the results.  Files without ANMF chunks decode as one frame.
+func decodeWebP(data []byte) ([]Frame, int, error) {
+	if len(data) < 12 {
+		return nil, 0, errors.New("webp: short header")
+	}
+	chunks, err := readRIFFChunks(data[12:])
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var canvas *image.NRGBA
+	var out []Frame
+	loops := 0
+	for _, c := range chunks {
+		switch c.typ {
+		case "ANIM":
+			if len(c.data) >= 6 {
+				loops = int(binary.LittleEndian.Uint16(c.data[4:]))
+			}
+		case "VP8X":
+			if len(c.data) < 10 {
+				return nil, 0, errors.New("webp: short VP8X chunk")
+			}
+			canvas = image.NewNRGBA(image.Rect(0, 0, int(uint24(c.data[4:]))+1, int(uint24(c.data[7:]))+1))
+		case "ANMF":
+			if canvas == nil {
+				return nil, 0, errors.New("webp: ANMF before VP8X")
+			}
+			if len(c.data) < 16 {
+				return nil, 0, errors.New("webp: short ANMF chunk")
+			}
+			d := c.data
+			x, y := 2*int(uint24(d[0:])), 2*int(uint24(d[3:]))
+			w, h := int(uint24(d[6:]))+1, int(uint24(d[9:]))+1
+			delay := time.Duration(uint24(d[12:])) * time.Millisecond
+			flags := d[15]
+
+			src, err := webp.Decode(bytes.NewReader(frameWebP(d[16:], w, h)))
+			if err != nil {
+				return nil, 0, err
+			}
+			rect := image.Rect(x, y, x+w, y+h)
+			op := draw.Over
+			if flags&anmfNoBlend != 0 {
+				op = draw.Src
+			}
+			draw.Draw(canvas, rect, src, src.Bounds().Min, op)
+			out = append(out, Frame{Index: len(out), Image: snapshot(canvas), Delay: delay})
+			if flags&anmfDispose != 0 {
+				clearRect(canvas, rect)
+			}
+		}
+	}
+	if len(out) == 0 {
+		one, err := still(data, core.FormatWebP)
+		return one, 0, err
+	}
+	return out, loops, nil
+}
+
+// frameWebP wraps ANMF frame data (an optional ALPH chunk followed by VP8 or
+// VP8L) in its own RIFF container.  Lossy frames with alpha need a VP8X
+// header for the decoder to read the ALPH chunk.
+func frameWebP(payload []byte, w, h int) []byte {
+	var body bytes.Buffer
+	body.WriteString("WEBP")
+	if bytes.HasPrefix(payload, []byte("ALPH")) {
+		vp8x := make([]byte, 10)
+		vp8x[0] = 0x10 // alpha
+		putUint24(vp8x[4:], uint32(w-1))
+		putUint24(vp8x[7:], uint32(h-1))
+		writeRIFFChunk(&body, "VP8X", vp8x)
+	}
+	body.Write(payload)
+
+	var buf bytes.Buffer
+	buf.WriteString("RIFF")
+	binary.Write(&buf, binary.LittleEndian, uint32(body.Len()))
+	buf.Write(body.Bytes())
+	return buf.Bytes()
+}
+
+func readRIFFChunks(data []byte) ([]chunk, error) {
+	var chunks []chunk
+	for pos := 0; pos+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if n < 0 || pos+8+n > len(data) {
+			return nil, errors.New("webp: truncated chunk")
+		}
+		chunks = append(chunks, chunk{typ: string(data[pos : pos+4]), data: data[pos+8 : pos+8+n]})
+		pos += 8 + n + n&1 // chunks are padded to even length
+	}
+	return chunks, nil
+}
+
+func writeRIFFChunk(buf *bytes.Buffer, typ string, data []byte) {
+	buf.WriteString(typ)
+	binary.Write(buf, binary.LittleEndian, uint32(len(data)))
+	buf.Write(data)
+	if len(data)&1 == 1 {
+		buf.WriteByte(0)
+	}
+}
+
+func uint24(b []byte) uint32 { return uint32(b[0]) | uint32(b[1])<<8 | uint32(b[2])<<16 }
+
+func putUint24(b []byte, v uint32) { b[0], b[1], b[2] = byte(v), byte(v>>8), byte(v>>16) }
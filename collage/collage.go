@@ -0,0 +1,114 @@
+// Package collage lays out several images on one canvas — uniform grids or
+// row templates such as "one wide image over two halves" — for social
+// previews and album covers.
+package collage
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/pipeline"
+)
+
+// Layout describes the output canvas and how it is divided into cells.
+type Layout struct {
+	Width, Height int // output size in pixels
+	// Rows lists the number of cells in each row, top to bottom, e.g.
+	// []int{1, 2} for one full-width image over two half-width ones.  Rows
+	// share the height equally and cells share their row's width equally.
+	// Empty = a uniform grid of Columns columns.
+	Rows []int
+	// Columns of the uniform grid used when Rows is empty; 0 = the smallest
+	// count giving a roughly square grid.
+	Columns int
+	Gap     int // pixels between cells and around the edge
+	// Background fills gaps, empty cells and FitContain padding; nil =
+	// transparent.
+	Background color.Color
+	Fit        pipeline.Fit   // default per-cell fit; default FitCover
+	CellFit    []pipeline.Fit // per-cell overrides by image index; "" = Fit
+	Gravity    core.Gravity   // crop / padding anchor; default center
+}
+
+// Cells returns the rectangle of every cell in reading order for n images.
+func (l Layout) Cells(n int) []image.Rectangle {
+	rows := l.Rows
+	if len(rows) == 0 {
+		cols := l.Columns
+		if cols <= 0 {
+			cols = int(math.Ceil(math.Sqrt(float64(n))))
+		}
+		cols = max(cols, 1)
+		rows = make([]int, max(1, (n+cols-1)/cols))
+		for i := range rows {
+			rows[i] = cols
+		}
+	}
+
+	var cells []image.Rectangle
+	for r, count := range rows {
+		y0, y1 := split(l.Height, l.Gap, len(rows), r)
+		for c := 0; c < count; c++ {
+			x0, x1 := split(l.Width, l.Gap, count, c)
+			cells = append(cells, image.Rect(x0, y0, x1, y1))
+		}
+	}
+	return cells
+}
+
+// split returns the start and end of slot i when total pixels are divided
+// into n slots separated (and surrounded) by gap, spreading the remainder.
+func split(total, gap, n, i int) (int, int) {
+	avail := total - (n+1)*gap
+	start := gap + i*gap + avail*i/n
+	end := gap + i*gap + avail*(i+1)/n
+	return start, end
+}
+
+// Compose draws images into the cells of l in order and returns the canvas.
+// There may be fewer images than cells; the rest show the background.
+func Compose(ctx context.Context, images []image.Image, l Layout) (*image.NRGBA, error) {
+	if l.Width <= 0 || l.Height <= 0 {
+		return nil, apperrors.New(apperrors.CategoryInput, "collage", apperrors.ErrInvalidDimensions)
+	}
+	cells := l.Cells(len(images))
+	if len(images) > len(cells) {
+		return nil, apperrors.New(apperrors.CategoryInput, "collage",
+			fmt.Errorf("%d images for %d cells", len(images), len(cells)))
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, l.Width, l.Height))
+	if l.Background != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(l.Background), image.Point{}, draw.Src)
+	}
+	for i, src := range images {
+		cell := cells[i]
+		if cell.Dx() <= 0 || cell.Dy() <= 0 {
+			return nil, apperrors.New(apperrors.CategoryInput, "collage",
+				fmt.Errorf("%w: cell %d is %v", apperrors.ErrInvalidDimensions, i, cell.Size()))
+		}
+		fit := l.Fit
+		if i < len(l.CellFit) && l.CellFit[i] != "" {
+			fit = l.CellFit[i]
+		}
+		thumb := &pipeline.ThumbnailStep{
+			Width: cell.Dx(), Height: cell.Dy(), Fit: fit, Gravity: l.Gravity, Background: l.Background,
+		}
+		out, err := thumb.Execute(ctx, &core.ImageData{Image: src})
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, "collage", err)
+		}
+		fitted, err := core.StdImage(ctx, out)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, "collage", err)
+		}
+		draw.Draw(dst, cell, fitted, fitted.Bounds().Min, draw.Over)
+	}
+	return dst, nil
+}
@@ -13,13 +13,48 @@ const (
 	StorageS3    StorageBackend = "s3"
 )
 
+// QueueFullPolicy selects how Submit handles a full job queue.
+type QueueFullPolicy string
+
+const (
+	// QueueFullReject fails Submit with ErrWorkerPoolFull.
+	QueueFullReject QueueFullPolicy = "reject"
+	// QueueFullBlock makes Submit wait for room until the job's context
+	// is done.
+	QueueFullBlock QueueFullPolicy = "block"
+	// QueueFullDropOldest evicts the oldest queued job of the same
+	// priority, or failing that of the lowest priority below it, whose
+	// ResultCh receives ErrJobDropped.  With only higher priority jobs
+	// queued Submit fails with ErrWorkerPoolFull.
+	QueueFullDropOldest QueueFullPolicy = "drop_oldest"
+)
+
 // Config is the top-level configuration struct.  All fields have safe defaults
 // so callers can start with Config{} and override only what they need.
 type Config struct {
 	// Worker pool controls.
 	WorkerCount   int // default: runtime.NumCPU()
-	QueueSize     int // max queued jobs before backpressure; default: 256
+	QueueSize     int // max queued jobs, all priorities together; default: 256
 	JobTimeout    time.Duration
+	// StepTimeout limits each step of a job, so one pathological step
+	// cannot use up JobTimeout; steps implementing core.TimeoutHinter
+	// override it.  0 = no per-step limit.
+	StepTimeout time.Duration
+	// QueueFull decides what Submit does when the job's queue is full.
+	QueueFull QueueFullPolicy // default: QueueFullReject
+
+	// Worker pool autoscaling, enabled by setting MaxWorkers.  The pool
+	// starts at WorkerCount (default MinWorkers) and every ScaleInterval
+	// grows while jobs wait for busy workers, or sheds an idle worker.
+	MinWorkers    int           // default: 1
+	MaxWorkers    int           // 0 = fixed pool of WorkerCount workers
+	ScaleInterval time.Duration // default: 1s
+
+	// Coalesce makes concurrent Process calls with the same source bytes
+	// and steps share one pipeline run, so a hot image requested many
+	// times at once is processed once.  Their results share the images.
+	// Steps without a core.Fingerprint always run on their own.
+	Coalesce bool
 
 	// Retry.
 	MaxRetries int
@@ -29,10 +64,27 @@ type Config struct {
 	DefaultQuality int // 1-100; default 85
 	DefaultFormat  string
 
+	// PostSharpen is the unsharp-mask amount applied after downscaling by
+	// resize steps that leave their own PostSharpen at zero.  0 = off;
+	// 0.3-0.8 counters the softness of bilinear and Lanczos downscales.
+	PostSharpen float64
+
+	// FirstFrameOnly decodes animated GIF and WebP to their first frame
+	// instead of a *core.Animation, for pipelines that want stills.
+	FirstFrameOnly bool
+
 	// Streaming / memory limits.
 	MaxImageBytes int64 // 0 = no limit
 	ChunkSize     int   // streaming chunk size in bytes; default 32 KiB
 
+	// Decoded image limits, checked against the header before decoding.
+	// The decoded size is estimated as width × height × bytes per pixel.
+	// MemoryBudget caps the estimated decoded bytes of all the images
+	// Process holds at once; further images wait for room.
+	MaxPixels       int64 // 0 = no limit
+	MaxDecodedBytes int64 // 0 = no limit
+	MemoryBudget    int64 // 0 = no limit
+
 	// Storage.
 	Storage StorageBackend
 	Local   LocalConfig
@@ -95,6 +147,20 @@ func Validate(c Config) error {
 	if c.DefaultQuality < 1 || c.DefaultQuality > 100 {
 		return errors.New("config: DefaultQuality must be between 1 and 100")
 	}
+	if c.PostSharpen < 0 {
+		return errors.New("config: PostSharpen must not be negative")
+	}
+	if c.MaxPixels < 0 || c.MaxDecodedBytes < 0 || c.MemoryBudget < 0 {
+		return errors.New("config: MaxPixels, MaxDecodedBytes and MemoryBudget must not be negative")
+	}
+	if c.MaxWorkers > 0 && c.MinWorkers > c.MaxWorkers {
+		return errors.New("config: MinWorkers must not exceed MaxWorkers")
+	}
+	switch c.QueueFull {
+	case "", QueueFullReject, QueueFullBlock, QueueFullDropOldest:
+	default:
+		return errors.New("config: unknown QueueFull policy " + string(c.QueueFull))
+	}
 	if c.ChunkSize <= 0 {
 		return errors.New("config: ChunkSize must be positive")
 	}
@@ -0,0 +1,91 @@
+package core
+
+// Attributes is the pipeline attribute bag: directives that one step leaves
+// for a later one (encode quality, lossless mode, …).  Unlike Meta it
+// describes how the image should be processed rather than the image itself,
+// so metadata steps such as StripEXIF never touch it.
+//
+// Attributes is copy-on-write: With and Without return a new bag and leave
+// the receiver untouched, so steps that copy an ImageData — and variants that
+// share a base image — never observe each other's writes.
+type Attributes map[string]any
+
+// Well-known attribute keys.  Keys prefixed with "encode." are encode
+// directives applied to EncodeOptions by ApplyEncode.
+const (
+	AttrQuality       = "encode.quality"        // int, 1-100
+	AttrLossless      = "encode.lossless"       // bool
+	AttrInterlaced    = "encode.interlaced"     // bool
+	AttrStripMetadata = "encode.strip_metadata" // bool
+	AttrDeterministic = "encode.deterministic"  // bool
+	AttrMetadata      = "encode.metadata"       // MetadataPolicy
+	AttrMetadataTags  = "encode.metadata_tags"  // MetadataTags
+	AttrEmbedICC      = "encode.embed_icc"      // bool
+)
+
+// With returns a copy of a with key set to v.
+func (a Attributes) With(key string, v any) Attributes {
+	out := make(Attributes, len(a)+1)
+	for k, x := range a {
+		out[k] = x
+	}
+	out[key] = v
+	return out
+}
+
+// Without returns a copy of a with key removed.
+func (a Attributes) Without(key string) Attributes {
+	if _, ok := a[key]; !ok {
+		return a
+	}
+	out := make(Attributes, len(a))
+	for k, x := range a {
+		if k != key {
+			out[k] = x
+		}
+	}
+	return out
+}
+
+// Int returns the int stored under key.
+func (a Attributes) Int(key string) (int, bool) {
+	v, ok := a[key].(int)
+	return v, ok
+}
+
+// Bool returns the bool stored under key.
+func (a Attributes) Bool(key string) (bool, bool) {
+	v, ok := a[key].(bool)
+	return v, ok
+}
+
+// ApplyEncode overlays the encode directives in a onto opts.  Directives
+// win over the encode step's base options because they were set later in
+// the pipeline.
+func (a Attributes) ApplyEncode(opts EncodeOptions) EncodeOptions {
+	if q, ok := a.Int(AttrQuality); ok && q > 0 {
+		opts.Quality = q
+	}
+	if v, ok := a.Bool(AttrLossless); ok {
+		opts.Lossless = v
+	}
+	if v, ok := a.Bool(AttrInterlaced); ok {
+		opts.Interlaced = v
+	}
+	if v, ok := a.Bool(AttrStripMetadata); ok && v {
+		opts.StripEXIF = true
+	}
+	if v, ok := a.Bool(AttrDeterministic); ok && v {
+		opts.Deterministic = true
+	}
+	if p, ok := a[AttrMetadata].(MetadataPolicy); ok {
+		opts.Metadata = p
+	}
+	if t, ok := a[AttrMetadataTags].(MetadataTags); ok {
+		opts.Tags = t
+	}
+	if v, ok := a.Bool(AttrEmbedICC); ok {
+		opts.EmbedICC = v
+	}
+	return opts
+}
@@ -0,0 +1,195 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// BatchOptions controls BatchWithOptions.  By default a failure stops the
+// batch from starting further sources while those already running finish;
+// FailFast and ContinueOnError make it stricter or more lenient.
+type BatchOptions struct {
+	// MaxConcurrency caps the sources processed at once; 0 = NumCPU.
+	MaxConcurrency int
+	// FailFast cancels the sources still running after the first failure.
+	FailFast bool
+	// ContinueOnError processes every source whatever fails.
+	ContinueOnError bool
+}
+
+// BatchItem is the outcome for one source of a batch.
+type BatchItem struct {
+	Result   *ProcessingResult
+	Err      error         // ErrBatchAborted for sources the batch never ran
+	Duration time.Duration // time spent processing the source; 0 if never run
+}
+
+// BatchResult aggregates a batch.  Items are in source order.
+type BatchResult struct {
+	Items     []BatchItem
+	Succeeded int
+	Failed    int // including skipped sources
+	Duration  time.Duration
+}
+
+// Err returns the item errors joined, or nil when every source succeeded.
+func (r *BatchResult) Err() error {
+	var errs []error
+	for i, it := range r.Items {
+		if it.Err != nil {
+			errs = append(errs, fmt.Errorf("source %d: %w", i, it.Err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Batch processes multiple sources concurrently (fan-out / fan-in), at most
+// NumCPU at a time, carrying on past failures.
+func (p *Processor) Batch(ctx context.Context, sources []Source, steps ...Step) ([]*ProcessingResult, []error) {
+	res, _ := p.BatchWithOptions(ctx, sources, BatchOptions{ContinueOnError: true}, steps...)
+	results := make([]*ProcessingResult, len(sources))
+	errs := make([]error, len(sources))
+	for i, it := range res.Items {
+		results[i], errs[i] = it.Result, it.Err
+	}
+	return results, errs
+}
+
+// BatchWithOptions runs steps on every source with at most
+// opts.MaxConcurrency in flight and the error policy opts selects.  The
+// error is non-nil only for contradictory options; per-source failures are
+// in the result.
+func (p *Processor) BatchWithOptions(ctx context.Context, sources []Source, opts BatchOptions, steps ...Step) (*BatchResult, error) {
+	if opts.FailFast && opts.ContinueOnError {
+		return nil, apperrors.New(apperrors.CategoryConfig, "batch",
+			errors.New("FailFast and ContinueOnError are mutually exclusive"))
+	}
+	limit := opts.MaxConcurrency
+	if limit <= 0 {
+		limit = runtime.NumCPU()
+	}
+
+	start := time.Now()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	res := &BatchResult{Items: make([]BatchItem, len(sources))}
+	var (
+		wg     sync.WaitGroup
+		mu     sync.Mutex
+		failed bool
+		sem    = make(chan struct{}, limit)
+	)
+	for i, src := range sources {
+		sem <- struct{}{}
+		mu.Lock()
+		stop := failed && !opts.ContinueOnError
+		mu.Unlock()
+		if stop {
+			<-sem
+			for j := i; j < len(sources); j++ {
+				res.Items[j].Err = apperrors.New(apperrors.CategoryPipeline, "batch", apperrors.ErrBatchAborted)
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			t := time.Now()
+			r, err := p.Process(ctx, src, steps...)
+			res.Items[i] = BatchItem{Result: r, Err: err, Duration: time.Since(t)}
+			if err != nil {
+				mu.Lock()
+				failed = true
+				mu.Unlock()
+				if opts.FailFast {
+					cancel()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	for _, it := range res.Items {
+		if it.Err != nil {
+			res.Failed++
+		} else {
+			res.Succeeded++
+		}
+	}
+	res.Duration = time.Since(start)
+	return res, nil
+}
+
+// BatchItemResult is the outcome for one source of BatchStream.
+type BatchItemResult struct {
+	Index int // position of the source on the input channel
+	BatchItem
+}
+
+// BatchStream runs steps on every source received from sources, NumCPU at
+// a time, and delivers the outcomes as they complete, out of order, so an
+// input set of any size is processed in bounded memory.  The returned
+// channel is closed once sources is closed and drained, or ctx is done,
+// and the sources in flight have finished; results not yet received when
+// ctx is done are dropped.  A slow reader holds the batch back.
+func (p *Processor) BatchStream(ctx context.Context, sources <-chan Source, steps ...Step) <-chan BatchItemResult {
+	workers := runtime.NumCPU()
+	out := make(chan BatchItemResult, workers)
+
+	type indexed struct {
+		i   int
+		src Source
+	}
+	in := make(chan indexed)
+	go func() {
+		defer close(in)
+		for i := 0; ; i++ {
+			var (
+				src Source
+				ok  bool
+			)
+			select {
+			case src, ok = <-sources:
+				if !ok {
+					return
+				}
+			case <-ctx.Done():
+				return
+			}
+			select {
+			case in <- indexed{i, src}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var wg sync.WaitGroup
+	for range workers {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for it := range in {
+				t := time.Now()
+				r, err := p.Process(ctx, it.src, steps...)
+				item := BatchItemResult{Index: it.i, BatchItem: BatchItem{Result: r, Err: err, Duration: time.Since(t)}}
+				select {
+				case out <- item:
+				case <-ctx.Done():
+				}
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		close(out)
+	}()
+	return out
+}
@@ -0,0 +1,56 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// memoryBudget is a weighted semaphore over the estimated decoded bytes of
+// the images in flight, so a burst of very large uploads waits its turn
+// instead of exhausting memory together.
+type memoryBudget struct {
+	mu      sync.Mutex
+	limit   int64
+	used    int64
+	changed chan struct{} // closed and replaced on every release
+}
+
+func newMemoryBudget(limit int64) *memoryBudget {
+	return &memoryBudget{limit: limit, changed: make(chan struct{})}
+}
+
+// acquire takes n bytes of the budget, waiting until enough is released or
+// ctx is done.  A request larger than the whole budget fails at once.
+func (b *memoryBudget) acquire(ctx context.Context, n int64) error {
+	if n > b.limit {
+		return apperrors.New(apperrors.CategoryInput, "process.budget",
+			fmt.Errorf("%w: needs %d bytes decoded, memory budget is %d", apperrors.ErrImageTooLarge, n, b.limit))
+	}
+	for {
+		b.mu.Lock()
+		if b.used+n <= b.limit {
+			b.used += n
+			b.mu.Unlock()
+			return nil
+		}
+		changed := b.changed
+		b.mu.Unlock()
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return apperrors.Wrap(apperrors.CategoryPipeline, "process.budget", ctx.Err())
+		}
+	}
+}
+
+// release returns n bytes to the budget and wakes the waiters.
+func (b *memoryBudget) release(n int64) {
+	b.mu.Lock()
+	b.used -= n
+	close(b.changed)
+	b.changed = make(chan struct{})
+	b.mu.Unlock()
+}
@@ -0,0 +1,184 @@
+package core
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"sync"
+	"time"
+)
+
+// SetResultCache makes Process look up its output in c before running the
+// pipeline and store it there afterwards for ttl (0 = until evicted).  The
+// key combines the SHA-256 of the source bytes with the Fingerprint of the
+// steps.  Only pipelines that encode their output and have a fingerprint
+// are cached, and a hit
+// returns the encoded primary image and variants without a decoded Image.
+// Cache failures are logged and treated as misses.  Call it before
+// processing starts.
+func (p *Processor) SetResultCache(c ResultCache, ttl time.Duration) {
+	p.cache, p.cacheTTL = c, ttl
+}
+
+// resultKey keys the result of running steps on raw, or returns "" when
+// steps have no fingerprint.
+func resultKey(raw []byte, steps []Step) string {
+	fp := Fingerprint(steps)
+	if fp == "" {
+		return ""
+	}
+	sum := sha256.Sum256(raw)
+	return hex.EncodeToString(sum[:]) + ":" + fp
+}
+
+// cachedImage is the stored form of an encoded ImageData.
+type cachedImage struct {
+	Data   []byte
+	Format Format
+	Meta   Metadata
+	Codecs CodecUsage
+}
+
+type cachedResult struct {
+	Primary  cachedImage
+	Variants map[string]cachedImage `json:",omitempty"`
+}
+
+// cachedResult returns the result stored for key, if any.
+func (p *Processor) cachedResult(ctx context.Context, key string) (*ProcessingResult, bool) {
+	value, ok, err := p.cache.Get(ctx, key)
+	if err != nil {
+		p.logWarn("result cache get failed", "key", key, "error", err)
+		return nil, false
+	}
+	if !ok {
+		return nil, false
+	}
+	var c cachedResult
+	if err := json.Unmarshal(value, &c); err != nil {
+		p.logWarn("result cache entry unreadable", "key", key, "error", err)
+		return nil, false
+	}
+	res := &ProcessingResult{Primary: c.Primary.image(), StepTimings: map[string]time.Duration{}, Codecs: c.Primary.Codecs}
+	if len(c.Variants) > 0 {
+		res.Variants = make(map[string]*ImageData, len(c.Variants))
+		for name, v := range c.Variants {
+			res.Variants[name] = v.image()
+		}
+	}
+	return res, true
+}
+
+func (c cachedImage) image() *ImageData {
+	return &ImageData{Data: c.Data, Format: c.Format, Meta: c.Meta, Codecs: c.Codecs}
+}
+
+// storeResult caches res under key when every image in it was encoded,
+// rather than still holding the source bytes of raw.
+func (p *Processor) storeResult(ctx context.Context, key string, raw []byte, res *ProcessingResult) {
+	encoded := func(img *ImageData) bool {
+		return img != nil && len(img.Data) > 0 && (len(raw) == 0 || &img.Data[0] != &raw[0])
+	}
+	if !encoded(res.Primary) {
+		return
+	}
+	c := cachedResult{Primary: cachedImage{Data: res.Primary.Data, Format: res.Primary.Format, Meta: res.Primary.Meta, Codecs: res.Primary.Codecs}}
+	for name, v := range res.Variants {
+		if !encoded(v) {
+			return
+		}
+		if c.Variants == nil {
+			c.Variants = make(map[string]cachedImage, len(res.Variants))
+		}
+		c.Variants[name] = cachedImage{Data: v.Data, Format: v.Format, Meta: v.Meta, Codecs: v.Codecs}
+	}
+	value, err := json.Marshal(c)
+	if err == nil {
+		err = p.cache.Set(ctx, key, value, p.cacheTTL)
+	}
+	if err != nil {
+		p.logWarn("result cache set failed", "key", key, "error", err)
+	}
+}
+
+func (p *Processor) logWarn(msg string, fields ...interface{}) {
+	if p.logger != nil {
+		p.logger.Warn(msg, fields...)
+	}
+}
+
+// ── MemoryCache ───────────────────────────────────────────────────────────────
+
+// MemoryCache is an in-process ResultCache that evicts the least recently
+// used entry once it holds maxEntries.  It is safe for concurrent use.
+type MemoryCache struct {
+	mu         sync.Mutex
+	maxEntries int
+	order      *list.List // front = most recently used
+	entries    map[string]*list.Element
+}
+
+type memoryEntry struct {
+	key     string
+	value   []byte
+	expires time.Time // zero = never
+}
+
+// NewMemoryCache returns a MemoryCache holding up to maxEntries results;
+// maxEntries <= 0 means 1024.
+func NewMemoryCache(maxEntries int) *MemoryCache {
+	if maxEntries <= 0 {
+		maxEntries = 1024
+	}
+	return &MemoryCache{maxEntries: maxEntries, order: list.New(), entries: make(map[string]*list.Element)}
+}
+
+// Get implements ResultCache.
+func (c *MemoryCache) Get(_ context.Context, key string) ([]byte, bool, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	e := el.Value.(*memoryEntry)
+	if !e.expires.IsZero() && time.Now().After(e.expires) {
+		c.order.Remove(el)
+		delete(c.entries, key)
+		return nil, false, nil
+	}
+	c.order.MoveToFront(el)
+	return e.value, true, nil
+}
+
+// Set implements ResultCache.
+func (c *MemoryCache) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	e := &memoryEntry{key: key, value: value}
+	if ttl > 0 {
+		e.expires = time.Now().Add(ttl)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.entries[key]; ok {
+		el.Value = e
+		c.order.MoveToFront(el)
+		return nil
+	}
+	c.entries[key] = c.order.PushFront(e)
+	for c.order.Len() > c.maxEntries {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*memoryEntry).key)
+	}
+	return nil
+}
+
+// Len returns the number of entries held, including expired ones not yet
+// evicted.
+func (c *MemoryCache) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.order.Len()
+}
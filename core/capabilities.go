@@ -0,0 +1,145 @@
+package core
+
+import "slices"
+
+// ── Capabilities ──────────────────────────────────────────────────────────────
+
+// CodecCapabilities describes what one codec does with one format.
+type CodecCapabilities struct {
+	// Codec is the codec's CodecName.
+	Codec  string
+	Format Format
+	// Decode and Encode report the role the codec is registered in.
+	Decode bool
+	Encode bool
+	// Animation reports whether every frame of an animated image is read
+	// or written, rather than only the first.
+	Animation bool
+	// Alpha reports whether transparency survives the codec.
+	Alpha bool
+	// MaxWidth and MaxHeight bound the images the codec handles; 0 means
+	// no limit beyond memory.
+	MaxWidth  int
+	MaxHeight int
+}
+
+// CapabilityReporter is optionally implemented by a Decoder or Encoder to
+// describe what it does with f.  The Registry fills in Codec, Format,
+// Decode and Encode itself.  Codecs that do not implement it are assumed
+// to handle still images, with transparency where f supports it, up to
+// the format's own size limit.
+type CapabilityReporter interface {
+	Capabilities(f Format) CodecCapabilities
+}
+
+// CapabilitiesOf returns what codec c does with f, as reported by its
+// CapabilityReporter or else assumed from f.
+func CapabilitiesOf(c any, f Format) CodecCapabilities {
+	var caps CodecCapabilities
+	if r, ok := c.(CapabilityReporter); ok {
+		caps = r.Capabilities(f)
+	} else {
+		caps.Alpha = f.SupportsAlpha()
+		caps.MaxWidth, caps.MaxHeight = f.MaxDimensions()
+	}
+	caps.Codec, caps.Format = CodecName(c), f
+	return caps
+}
+
+// Capabilities summarises a Registry: which formats can be read and
+// written, and what each registered codec does with them.  Callers use it
+// to negotiate output formats, e.g. to offer AVIF only when an encoder for
+// it is installed.
+type Capabilities struct {
+	// Decode and Encode list the formats with at least one decoder or
+	// encoder registered, sorted.
+	Decode []Format
+	Encode []Format
+	// Codecs lists every registered codec and format, decoders before
+	// encoders and each format's codecs in priority order.
+	Codecs []CodecCapabilities
+}
+
+// CanDecode reports whether some decoder is registered for f.
+func (c Capabilities) CanDecode(f Format) bool { return slices.Contains(c.Decode, f) }
+
+// CanEncode reports whether some encoder is registered for f.
+func (c Capabilities) CanEncode(f Format) bool { return slices.Contains(c.Encode, f) }
+
+// Decoder returns the capabilities of the preferred decoder for f.
+func (c Capabilities) Decoder(f Format) (CodecCapabilities, bool) {
+	return c.first(f, func(cc CodecCapabilities) bool { return cc.Decode })
+}
+
+// Encoder returns the capabilities of the preferred encoder for f.
+func (c Capabilities) Encoder(f Format) (CodecCapabilities, bool) {
+	return c.first(f, func(cc CodecCapabilities) bool { return cc.Encode })
+}
+
+func (c Capabilities) first(f Format, role func(CodecCapabilities) bool) (CodecCapabilities, bool) {
+	for _, cc := range c.Codecs {
+		if cc.Format == f && role(cc) {
+			return cc, true
+		}
+	}
+	return CodecCapabilities{}, false
+}
+
+// FormatLister is optionally implemented by a Registry that can enumerate
+// its codecs, as DefaultRegistry does.
+type FormatLister interface {
+	// SupportedFormats returns the formats with a decoder or an encoder
+	// registered, sorted.
+	SupportedFormats() []Format
+	// Capabilities describes the registered codecs and what they do with
+	// each format.
+	Capabilities() Capabilities
+}
+
+// knownFormats are the formats probed in registries that are not
+// FormatListers.
+var knownFormats = []Format{
+	FormatJPEG, FormatPNG, FormatWebP, FormatGIF, FormatTIFF, FormatBMP, FormatAVIF,
+	FormatHEIF, FormatICO, FormatPDF, FormatJXL, FormatSVG, FormatRAW, FormatMP4, FormatWebM,
+}
+
+// SupportedFormats returns reg's SupportedFormats when reg is a
+// FormatLister, otherwise the known formats it has a codec for.
+func SupportedFormats(reg Registry) []Format {
+	if l, ok := reg.(FormatLister); ok {
+		return l.SupportedFormats()
+	}
+	c := RegistryCapabilities(reg)
+	formats := slices.Concat(c.Decode, c.Encode)
+	slices.Sort(formats)
+	return slices.Compact(formats)
+}
+
+// RegistryCapabilities returns reg's Capabilities when reg is a
+// FormatLister, otherwise those of the codecs it returns for the known
+// formats.
+func RegistryCapabilities(reg Registry) Capabilities {
+	if l, ok := reg.(FormatLister); ok {
+		return l.Capabilities()
+	}
+	var c Capabilities
+	for _, f := range knownFormats {
+		if d, ok := reg.DecoderFor(f); ok {
+			c.Decode = append(c.Decode, f)
+			cc := CapabilitiesOf(d, f)
+			cc.Decode = true
+			c.Codecs = append(c.Codecs, cc)
+		}
+	}
+	for _, f := range knownFormats {
+		if e, ok := reg.EncoderFor(f); ok {
+			c.Encode = append(c.Encode, f)
+			cc := CapabilitiesOf(e, f)
+			cc.Encode = true
+			c.Codecs = append(c.Codecs, cc)
+		}
+	}
+	slices.Sort(c.Decode)
+	slices.Sort(c.Encode)
+	return c
+}
@@ -0,0 +1,235 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"maps"
+	"slices"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── DecodedImage ──────────────────────────────────────────────────────────────
+
+// DecodedImage is the decoded pixel buffer held in ImageData.Image: an
+// image.Image for the standard-library backend, a *core.Animation, or a
+// backend type such as the vips adapter's *VipsImage.  Only Bounds is
+// required, so every image.Image qualifies as is; buffers that are not
+// image.Image implement StdImageConverter as well, so that ToStdImage can
+// hand them to standard-library steps.
+type DecodedImage interface {
+	Bounds() image.Rectangle
+}
+
+// StdImageConverter is implemented by DecodedImage types from other
+// backends.
+type StdImageConverter interface {
+	// ColorModelHint describes the buffer's colour model without
+	// converting it.
+	ColorModelHint() ColorSpace
+	// ToStdImage exports the pixels as an image.Image.
+	ToStdImage() (image.Image, error)
+}
+
+// ImageCloner is implemented by DecodedImage types from other backends so
+// that CloneImage can copy them through their backend.
+type ImageCloner interface {
+	// CloneImage returns a copy that later in-place operations on either
+	// side do not affect.
+	CloneImage() (DecodedImage, error)
+}
+
+// CloneImage returns a copy of d that shares no mutable pixel buffer with
+// it: the pixels of the standard image types and of each animation frame
+// are copied, and backend images are copied through ImageCloner.  Images
+// of other types are treated as immutable and returned as they are.
+func CloneImage(d DecodedImage) (DecodedImage, error) {
+	switch src := d.(type) {
+	case ImageCloner:
+		return src.CloneImage()
+	case *Animation:
+		a := &Animation{Frames: make([]image.Image, len(src.Frames)), Delays: slices.Clone(src.Delays), LoopCount: src.LoopCount}
+		for i, f := range src.Frames {
+			a.Frames[i] = cloneStd(f)
+		}
+		return a, nil
+	case image.Image:
+		return cloneStd(src), nil
+	}
+	return d, nil
+}
+
+// cloneStd copies the pixel buffers of the standard image types.
+func cloneStd(src image.Image) image.Image {
+	switch s := src.(type) {
+	case *image.RGBA:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.NRGBA:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.RGBA64:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.NRGBA64:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.Gray:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.Gray16:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.Alpha:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.Alpha16:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.CMYK:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		return &c
+	case *image.Paletted:
+		c := *s
+		c.Pix = slices.Clone(s.Pix)
+		c.Palette = slices.Clone(s.Palette)
+		return &c
+	case *image.YCbCr:
+		c := *s
+		c.Y, c.Cb, c.Cr = slices.Clone(s.Y), slices.Clone(s.Cb), slices.Clone(s.Cr)
+		return &c
+	case *image.NYCbCrA:
+		c := *s
+		c.Y, c.Cb, c.Cr, c.A = slices.Clone(s.Y), slices.Clone(s.Cb), slices.Clone(s.Cr), slices.Clone(s.A)
+		return &c
+	}
+	return src
+}
+
+// Clone returns a deep copy of d that can be processed independently, as
+// variants and tee branches are: the decoded image is copied with
+// CloneImage, and Meta's maps, Attrs and Branches are copied too.  Data,
+// encoded bytes that steps replace rather than modify, is shared.
+func (d *ImageData) Clone() (*ImageData, error) {
+	c := *d
+	if d.Image != nil {
+		img, err := CloneImage(d.Image)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, "clone", err)
+		}
+		c.Image = img
+	}
+	c.Meta.EXIF = maps.Clone(d.Meta.EXIF)
+	c.Meta.Details = d.Meta.Details.Clone()
+	c.Meta.Scores = maps.Clone(d.Meta.Scores)
+	c.Meta.Candidates = slices.Clone(d.Meta.Candidates)
+	if d.Meta.Contrast != nil {
+		m := *d.Meta.Contrast
+		c.Meta.Contrast = &m
+	}
+	c.Attrs = maps.Clone(d.Attrs)
+	if d.Branches != nil {
+		c.Branches = make(map[string]*ImageData, len(d.Branches))
+		for name, b := range d.Branches {
+			cb, err := b.Clone()
+			if err != nil {
+				return nil, err
+			}
+			c.Branches[name] = cb
+		}
+	}
+	return &c, nil
+}
+
+// ToStdImage returns d as an image.Image, exporting it from its backend
+// when it is not one already.
+func ToStdImage(d DecodedImage) (image.Image, error) {
+	switch v := d.(type) {
+	case nil:
+		return nil, fmt.Errorf("no decoded image")
+	case image.Image:
+		return v, nil
+	case StdImageConverter:
+		return v.ToStdImage()
+	}
+	return nil, fmt.Errorf("%T cannot be converted to image.Image", d)
+}
+
+// ColorModelHint describes d's colour model without converting it: gray,
+// CMYK, RGBA when it may carry alpha, and RGB otherwise.
+func ColorModelHint(d DecodedImage) ColorSpace {
+	switch v := d.(type) {
+	case StdImageConverter:
+		return v.ColorModelHint()
+	case image.Image:
+		switch v.ColorModel() {
+		case color.GrayModel, color.Gray16Model:
+			return ColorSpaceGray
+		case color.CMYKModel:
+			return ColorSpaceCMYK
+		case color.YCbCrModel:
+			return ColorSpaceRGB
+		}
+		if o, ok := v.(interface{ Opaque() bool }); ok && o.Opaque() {
+			return ColorSpaceRGB
+		}
+		return ColorSpaceRGBA
+	}
+	return ""
+}
+
+// StdImage returns img.Image as an image.Image for a standard-library step.
+// Buffers of other backends, such as a *VipsImage left by a vips step, are
+// exported with ToStdImage and the conversion is reported to ctx's
+// ConversionObserver as "convert.to_std", so mixed pipelines work but
+// their cost shows up in hooks and metrics.  A missing image is
+// apperrors.ErrEmptyInput.
+func StdImage(ctx context.Context, img *ImageData) (image.Image, error) {
+	switch v := img.Image.(type) {
+	case nil:
+		return nil, apperrors.ErrEmptyInput
+	case image.Image:
+		return v, nil
+	case StdImageConverter:
+		start := time.Now()
+		std, err := v.ToStdImage()
+		ReportConversion(ctx, "convert.to_std", img, time.Since(start), err)
+		return std, err
+	}
+	return nil, fmt.Errorf("%T cannot be converted to image.Image", img.Image)
+}
+
+// ConversionObserver is told about each decoded image converted between
+// backends mid-pipeline.  name identifies the direction, e.g.
+// "convert.to_std"; img is the image before conversion.
+type ConversionObserver func(ctx context.Context, name string, img *ImageData, d time.Duration, err error)
+
+type conversionObserverKey struct{}
+
+// WithConversionObserver returns a context whose conversions are reported
+// to fn.  The Processor installs one that passes conversions to its hooks
+// as if they were steps.
+func WithConversionObserver(ctx context.Context, fn ConversionObserver) context.Context {
+	return context.WithValue(ctx, conversionObserverKey{}, fn)
+}
+
+// ReportConversion tells ctx's ConversionObserver, if any, about a
+// conversion between backends.  Backends call it when they import images
+// from another backend.
+func ReportConversion(ctx context.Context, name string, img *ImageData, d time.Duration, err error) {
+	if fn, ok := ctx.Value(conversionObserverKey{}).(ConversionObserver); ok && fn != nil {
+		fn(ctx, name, img, d, err)
+	}
+}
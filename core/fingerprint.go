@@ -0,0 +1,177 @@
+package core
+
+import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"reflect"
+	"slices"
+)
+
+// Fingerprintable is optionally implemented by steps, and by values they
+// hold such as EncodeOptions, to state the parameters that determine their
+// output when Fingerprint would otherwise miss or over-count them:
+// unexported state, function-valued parameters, or caches that do not
+// affect the result.
+type Fingerprintable interface {
+	// FingerprintParams returns the parameters, keyed by name.  Values are
+	// fingerprinted like step fields.
+	FingerprintParams() map[string]any
+}
+
+// Fingerprint returns a deterministic identifier for a step list, the same
+// across processes and runs: a SHA-256 over each step's name, type and
+// parameters, shortened to 16 hex digits.  Two pipelines with the same
+// steps and parameters share a fingerprint, so it can key result caches,
+// derive ETags and version CDN URLs.
+//
+// Parameters are the exported fields of a step, followed through pointers,
+// interfaces (nested steps included), slices and maps, with map keys
+// sorted.  Unexported fields are ignored, so bound registries and clients
+// do not change the fingerprint.
+//
+// Functions, channels and unsafe pointers cannot be told apart by value,
+// so a step list reaching a non-nil one gets no fingerprint: Fingerprint
+// returns "", and Process neither caches nor coalesces it.  Steps holding
+// such values should implement Fingerprintable to state what they depend
+// on instead.
+func Fingerprint(steps []Step) string {
+	h := sha256.New()
+	f := &fingerprinter{w: h, seen: make(map[uintptr]bool)}
+	for _, s := range steps {
+		fmt.Fprintf(h, "%s\x00%T\x00", s.Name(), s)
+		f.value(reflect.ValueOf(s), 0)
+		io.WriteString(h, "\x00")
+	}
+	if f.opaque {
+		return ""
+	}
+	return hex.EncodeToString(h.Sum(nil))[:16]
+}
+
+// FingerprintParams implements Fingerprintable, covering the per-format
+// extensions.
+func (o EncodeOptions) FingerprintParams() map[string]any {
+	return map[string]any{
+		"quality":       o.Quality,
+		"lossless":      o.Lossless,
+		"strip_exif":    o.StripEXIF,
+		"interlaced":    o.Interlaced,
+		"deterministic": o.Deterministic,
+		"metadata":      o.Metadata,
+		"tags":          o.Tags,
+		"embed_icc":     o.EmbedICC,
+		"background":    o.Background,
+		"ext":           o.ext,
+	}
+}
+
+// maxFingerprintDepth bounds how deep Fingerprint follows nested values.
+const maxFingerprintDepth = 32
+
+type fingerprinter struct {
+	w    io.Writer
+	seen map[uintptr]bool // pointers on the current path, to break cycles
+	// opaque is set on reaching a value that cannot be fingerprinted.
+	opaque bool
+}
+
+var fingerprintableType = reflect.TypeFor[Fingerprintable]()
+
+func (f *fingerprinter) value(v reflect.Value, depth int) {
+	if depth > maxFingerprintDepth {
+		io.WriteString(f.w, "…")
+		return
+	}
+	if fp, ok := asFingerprintable(v); ok {
+		f.value(reflect.ValueOf(fp.FingerprintParams()), depth+1)
+		return
+	}
+	switch v.Kind() {
+	case reflect.Invalid:
+		io.WriteString(f.w, "nil")
+	case reflect.Pointer:
+		if v.IsNil() {
+			io.WriteString(f.w, "nil")
+			return
+		}
+		if f.seen[v.Pointer()] {
+			io.WriteString(f.w, "cycle")
+			return
+		}
+		f.seen[v.Pointer()] = true
+		f.value(v.Elem(), depth+1)
+		delete(f.seen, v.Pointer())
+	case reflect.Interface:
+		if v.IsNil() {
+			io.WriteString(f.w, "nil")
+			return
+		}
+		fmt.Fprintf(f.w, "%s(", v.Elem().Type())
+		f.value(v.Elem(), depth+1)
+		io.WriteString(f.w, ")")
+	case reflect.Struct:
+		io.WriteString(f.w, "{")
+		t := v.Type()
+		for i := range t.NumField() {
+			if !t.Field(i).IsExported() {
+				continue
+			}
+			fmt.Fprintf(f.w, "%s:", t.Field(i).Name)
+			f.value(v.Field(i), depth+1)
+			io.WriteString(f.w, ",")
+		}
+		io.WriteString(f.w, "}")
+	case reflect.Slice, reflect.Array:
+		io.WriteString(f.w, "[")
+		for i := range v.Len() {
+			f.value(v.Index(i), depth+1)
+			io.WriteString(f.w, ",")
+		}
+		io.WriteString(f.w, "]")
+	case reflect.Map:
+		keys := v.MapKeys()
+		slices.SortFunc(keys, func(a, b reflect.Value) int {
+			return cmp.Compare(fmt.Sprintf("%#v", a), fmt.Sprintf("%#v", b))
+		})
+		io.WriteString(f.w, "map[")
+		for _, k := range keys {
+			f.value(k, depth+1)
+			io.WriteString(f.w, ":")
+			f.value(v.MapIndex(k), depth+1)
+			io.WriteString(f.w, ",")
+		}
+		io.WriteString(f.w, "]")
+	case reflect.Func, reflect.Chan, reflect.UnsafePointer:
+		if v.IsNil() {
+			io.WriteString(f.w, "nil")
+			return
+		}
+		f.opaque = true
+	case reflect.String:
+		// Quoted, so separators inside a value cannot shift the fields.
+		fmt.Fprintf(f.w, "%q", v)
+	default:
+		fmt.Fprintf(f.w, "%v", v)
+	}
+}
+
+// asFingerprintable returns v as a Fingerprintable, including through a
+// pointer receiver when v is addressable.
+func asFingerprintable(v reflect.Value) (Fingerprintable, bool) {
+	if !v.IsValid() || !v.CanInterface() {
+		return nil, false
+	}
+	if (v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface) && v.IsNil() {
+		return nil, false
+	}
+	if v.Type().Implements(fingerprintableType) {
+		return v.Interface().(Fingerprintable), true
+	}
+	if v.CanAddr() && v.Addr().Type().Implements(fingerprintableType) {
+		return v.Addr().Interface().(Fingerprintable), true
+	}
+	return nil, false
+}
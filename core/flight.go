@@ -0,0 +1,81 @@
+package core
+
+import (
+	"context"
+	"maps"
+	"sync"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// flightGroup coalesces concurrent Process calls for the same result key
+// into one run, in the manner of golang.org/x/sync/singleflight.  The run
+// is detached from the caller that started it and is canceled only once
+// every caller waiting on it has given up.
+type flightGroup struct {
+	mu    sync.Mutex
+	calls map[string]*flight
+}
+
+type flight struct {
+	done    chan struct{}
+	res     *ProcessingResult
+	err     error
+	waiters int
+	cancel  context.CancelFunc
+}
+
+// do returns the result of fn for key, running fn only if no call for key
+// is in flight.  Each caller gets its own copy of the result; the images
+// in it are shared and must not be modified.
+func (g *flightGroup) do(ctx context.Context, key string, fn func(context.Context) (*ProcessingResult, error)) (*ProcessingResult, error) {
+	g.mu.Lock()
+	if g.calls == nil {
+		g.calls = make(map[string]*flight)
+	}
+	f, ok := g.calls[key]
+	if !ok {
+		runCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+		f = &flight{done: make(chan struct{}), cancel: cancel}
+		g.calls[key] = f
+		go func() {
+			f.res, f.err = fn(runCtx)
+			g.mu.Lock()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+			g.mu.Unlock()
+			cancel()
+			close(f.done)
+		}()
+	}
+	f.waiters++
+	g.mu.Unlock()
+
+	select {
+	case <-f.done:
+		if f.err != nil {
+			return nil, f.err
+		}
+		return f.res.share(), nil
+	case <-ctx.Done():
+		g.mu.Lock()
+		if f.waiters--; f.waiters == 0 {
+			// Nobody wants the result; later callers start afresh.
+			f.cancel()
+			if g.calls[key] == f {
+				delete(g.calls, key)
+			}
+		}
+		g.mu.Unlock()
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, "process", ctx.Err())
+	}
+}
+
+// share returns a copy of r whose maps the caller may modify.
+func (r *ProcessingResult) share() *ProcessingResult {
+	c := *r
+	c.StepTimings = maps.Clone(r.StepTimings)
+	c.Variants = maps.Clone(r.Variants)
+	return &c
+}
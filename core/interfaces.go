@@ -2,7 +2,9 @@ package core
 
 import (
 	"context"
+	"image"
 	"io"
+	"time"
 )
 
 // Decoder converts raw bytes / a reader into an in-memory ImageData.
@@ -14,6 +16,13 @@ type Decoder interface {
 	CanDecode(format Format) bool
 }
 
+// PageDecoder is optionally implemented by a Decoder for multi-page formats
+// such as TIFF and PDF.  Pages are numbered from 1.
+type PageDecoder interface {
+	PageCount(ctx context.Context, data []byte) (int, error)
+	DecodePage(ctx context.Context, data []byte, page int) (*ImageData, error)
+}
+
 // Encoder serialises an ImageData to bytes in a target format.
 // Implementations live in adapters/encoder/.
 type Encoder interface {
@@ -21,12 +30,53 @@ type Encoder interface {
 	CanEncode(format Format) bool
 }
 
-// EncodeOptions carries format-specific encoding parameters.
-type EncodeOptions struct {
-	Quality    int  // 1-100; 0 = use encoder default
-	Lossless   bool // WebP / PNG lossless mode
-	StripEXIF  bool
-	Interlaced bool // progressive JPEG / interlaced PNG
+// StreamEncoder is optionally implemented by an Encoder that can write
+// straight to an io.Writer, so Processor.ProcessStream never holds the
+// whole output in memory.  On error, part of the output may already have
+// been written.
+type StreamEncoder interface {
+	EncodeTo(ctx context.Context, w io.Writer, img *ImageData, opts EncodeOptions) error
+}
+
+// JobQueue holds submitted jobs until a worker takes them.  The Processor
+// uses a MemoryQueue unless SetJobQueue installs another, such as the
+// Redis and Postgres queues in adapters/queue, which keep jobs across
+// restarts and share them between processes.
+type JobQueue interface {
+	// Enqueue adds job to the queue.
+	Enqueue(ctx context.Context, job Job) error
+	// Dequeue blocks until a job is available or ctx is done, returning
+	// jobs of higher priority first.
+	Dequeue(ctx context.Context) (Job, error)
+	// Ack removes a dequeued job once it has been processed.
+	Ack(ctx context.Context, job Job) error
+	// Nack returns a dequeued job to the queue unprocessed, for another
+	// worker to take.
+	Nack(ctx context.Context, job Job) error
+}
+
+// BlockingQueue is optionally implemented by a bounded JobQueue that can
+// wait for room; SubmitWait and the QueueFullBlock policy use it.
+type BlockingQueue interface {
+	EnqueueWait(ctx context.Context, job Job) error
+}
+
+// EvictingQueue is optionally implemented by a bounded JobQueue that can
+// drop its oldest jobs to make room; the QueueFullDropOldest policy uses
+// it.  EnqueueEvict returns the jobs it dropped.
+type EvictingQueue interface {
+	EnqueueEvict(ctx context.Context, job Job) ([]Job, error)
+}
+
+// ResultCache stores the encoded outputs of Process so an identical request
+// (same source bytes, same steps) skips the pipeline.  The Processor uses
+// one installed with SetResultCache; MemoryCache and the Redis cache in
+// adapters/cache implement it.
+type ResultCache interface {
+	// Get returns the value stored under key; ok is false on a miss.
+	Get(ctx context.Context, key string) (value []byte, ok bool, err error)
+	// Set stores value under key for ttl; 0 keeps it until evicted.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
 }
 
 // StorageAdapter persists processed images and retrieves them later.
@@ -38,6 +88,44 @@ type StorageAdapter interface {
 	Exists(ctx context.Context, key StorageKey) (bool, error)
 }
 
+// Lister is optionally implemented by a StorageAdapter that can enumerate
+// its keys.  List returns the keys in bucket whose Path starts with prefix,
+// sorted by Path.
+type Lister interface {
+	List(ctx context.Context, bucket, prefix string) ([]StorageKey, error)
+}
+
+// Classifier scores an image against a set of labels, e.g. a moderation
+// model run locally (ONNX) or a remote moderation API.  Scores are in 0..1,
+// keyed by label.  Implementations may read img.Image or, when the image
+// has not been decoded, img.Data.
+type Classifier interface {
+	Classify(ctx context.Context, img *ImageData) (map[string]float64, error)
+}
+
+// Upscaler enlarges an image to exactly width×height, typically with a
+// super-resolution model (e.g. an ONNX ESRGAN adapter) that reconstructs
+// detail instead of interpolating it.  Callers only route to an Upscaler
+// when the target is larger than the source.
+type Upscaler interface {
+	Upscale(ctx context.Context, img *ImageData, width, height int) (*ImageData, error)
+}
+
+// SVGRasterizer renders an SVG document to a raster image, width pixels
+// wide or, when width is 0, at dpi (96 = one CSS pixel per pixel).  The
+// libvips backend implements it with librsvg; the default is the pure-Go
+// renderer in package svg.
+type SVGRasterizer interface {
+	RasterizeSVG(ctx context.Context, data []byte, width int, dpi float64) (*ImageData, error)
+}
+
+// FaceDetector finds faces in an image and returns their bounding boxes in
+// img's coordinate space, typically with a cascade or neural model.  An
+// image without faces yields an empty slice, not an error.
+type FaceDetector interface {
+	Detect(ctx context.Context, img image.Image) ([]image.Rectangle, error)
+}
+
 // MetricsCollector receives performance observations from the pipeline.
 type MetricsCollector interface {
 	RecordProcessingTime(stepName string, d interface{ Seconds() float64 })
@@ -55,9 +143,92 @@ type Logger interface {
 }
 
 // Registry maps Format values to Decoder/Encoder implementations.
+// DefaultRegistry also implements the optional DecoderChainer,
+// EncoderChainer, PriorityRegistry and FormatLister; the package helpers
+// DecodersFor, EncodersFor, AddDecoder, AddEncoder, SupportedFormats and
+// RegistryCapabilities fall back to the methods here for registries that
+// do not.
 type Registry interface {
 	DecoderFor(format Format) (Decoder, bool)
 	EncoderFor(format Format) (Encoder, bool)
 	RegisterDecoder(format Format, d Decoder)
 	RegisterEncoder(format Format, e Encoder)
-}
\ No newline at end of file
+}
+
+// DecoderChainer is optionally implemented by a Registry that can offer
+// fallback decoders, as DefaultRegistry does.
+type DecoderChainer interface {
+	// DecoderChain returns the decoders registered for format followed by
+	// every other registered decoder whose CanDecode accepts format, in
+	// registration (priority) order and without duplicates.  Callers try
+	// them in turn.
+	DecoderChain(format Format) []Decoder
+}
+
+// DecodersFor returns reg's DecoderChain for format when reg is a
+// DecoderChainer, otherwise its single DecoderFor match, if any.
+func DecodersFor(reg Registry, format Format) []Decoder {
+	if c, ok := reg.(DecoderChainer); ok {
+		return c.DecoderChain(format)
+	}
+	if d, ok := reg.DecoderFor(format); ok {
+		return []Decoder{d}
+	}
+	return nil
+}
+
+// EncoderChainer is optionally implemented by a Registry that can offer
+// fallback encoders, as DefaultRegistry does.
+type EncoderChainer interface {
+	// EncoderChain returns the encoders registered for format in priority
+	// order.  Callers try them in turn.
+	EncoderChain(format Format) []Encoder
+}
+
+// EncodersFor returns reg's EncoderChain for format when reg is an
+// EncoderChainer, otherwise its single EncoderFor match, if any.
+func EncodersFor(reg Registry, format Format) []Encoder {
+	if c, ok := reg.(EncoderChainer); ok {
+		return c.EncoderChain(format)
+	}
+	if e, ok := reg.EncoderFor(format); ok {
+		return []Encoder{e}
+	}
+	return nil
+}
+
+// PriorityRegistry is optionally implemented by a Registry that keeps
+// several codecs per format, as DefaultRegistry does.  RegisterDecoder and
+// RegisterEncoder then add a codec at priority 0, ahead of those already
+// registered at that priority.
+type PriorityRegistry interface {
+	// AddDecoder and AddEncoder add a codec for format at priority: codecs
+	// of higher priority are tried first and the rest are fallbacks.
+	AddDecoder(format Format, d Decoder, priority int)
+	AddEncoder(format Format, e Encoder, priority int)
+}
+
+// AddDecoder adds d to reg for format at priority.  A registry that is not
+// a PriorityRegistry holds one decoder per format: d replaces it unless
+// priority is negative, when d is only registered if there is none.
+func AddDecoder(reg Registry, format Format, d Decoder, priority int) {
+	if p, ok := reg.(PriorityRegistry); ok {
+		p.AddDecoder(format, d, priority)
+		return
+	}
+	if _, ok := reg.DecoderFor(format); !ok || priority >= 0 {
+		reg.RegisterDecoder(format, d)
+	}
+}
+
+// AddEncoder adds e to reg for format at priority, falling back as
+// AddDecoder does.
+func AddEncoder(reg Registry, format Format, e Encoder, priority int) {
+	if p, ok := reg.(PriorityRegistry); ok {
+		p.AddEncoder(format, e, priority)
+		return
+	}
+	if _, ok := reg.EncoderFor(format); !ok || priority >= 0 {
+		reg.RegisterEncoder(format, e)
+	}
+}
@@ -0,0 +1,176 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Job tracking ──────────────────────────────────────────────────────────────
+
+// JobState is the lifecycle stage of a submitted job.
+type JobState string
+
+const (
+	JobScheduled JobState = "scheduled"
+	JobQueued    JobState = "queued"
+	JobRunning   JobState = "running"
+	JobDone      JobState = "done"
+	JobFailed    JobState = "failed"
+	JobCanceled  JobState = "canceled"
+)
+
+// JobStatus is a snapshot of a job submitted with an ID.
+type JobStatus struct {
+	ID       string
+	State    JobState
+	Priority Priority
+	// Progress is the fraction of the job's steps completed, 0 to 1.
+	Progress float64
+	// Err is the job's error once it failed or was canceled.
+	Err error
+
+	Submitted time.Time
+	Scheduled time.Time // when a job from SubmitAt falls due
+	Started   time.Time // zero while queued
+	Finished  time.Time // zero until done, failed or canceled
+}
+
+// finishedJobTTL is how long the status of a finished job stays available.
+const finishedJobTTL = 15 * time.Minute
+
+// jobTable tracks jobs submitted with an ID from Submit until finishedJobTTL
+// after they finish.
+type jobTable struct {
+	mu   sync.Mutex
+	jobs map[string]*trackedJob
+}
+
+type trackedJob struct {
+	status JobStatus
+	cancel context.CancelFunc
+}
+
+// add registers job as queued and returns it with a cancelable context.  IDs
+// must be unique among jobs not yet finished.
+func (t *jobTable) add(job Job) (Job, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	for id, tj := range t.jobs {
+		if !tj.status.Finished.IsZero() && now.Sub(tj.status.Finished) > finishedJobTTL {
+			delete(t.jobs, id)
+		}
+	}
+	if tj, ok := t.jobs[job.ID]; ok && tj.status.Finished.IsZero() {
+		return job, apperrors.New(apperrors.CategoryConfig, "submit", fmt.Errorf("job %q is already %s", job.ID, tj.status.State))
+	}
+	if t.jobs == nil {
+		t.jobs = make(map[string]*trackedJob)
+	}
+	ctx := job.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cancel context.CancelFunc
+	job.Ctx, cancel = context.WithCancel(ctx)
+	t.jobs[job.ID] = &trackedJob{
+		status: JobStatus{ID: job.ID, State: JobQueued, Priority: job.Priority, Submitted: now},
+		cancel: cancel,
+	}
+	return job, nil
+}
+
+// remove forgets a job that never made it into a queue.
+func (t *jobTable) remove(id string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tj, ok := t.jobs[id]; ok {
+		tj.cancel()
+		delete(t.jobs, id)
+	}
+}
+
+// update applies fn to the status of job id, if tracked.
+func (t *jobTable) update(id string, fn func(*JobStatus)) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if tj, ok := t.jobs[id]; ok {
+		fn(&tj.status)
+	}
+}
+
+// finish records the outcome of job id.  Jobs canceled earlier stay
+// canceled whatever they returned.
+func (t *jobTable) finish(id string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	tj, ok := t.jobs[id]
+	if !ok {
+		return
+	}
+	tj.cancel()
+	s := &tj.status
+	s.Finished = time.Now()
+	switch {
+	case s.State == JobCanceled:
+		if err != nil {
+			s.Err = err
+		}
+	case err != nil:
+		s.State, s.Err = JobFailed, err
+	default:
+		s.State, s.Progress = JobDone, 1
+	}
+}
+
+// JobStatus reports the state of the job submitted under id.  Jobs are
+// tracked from Submit until 15 minutes after they finish; jobs submitted
+// without an ID are not tracked.
+func (p *Processor) JobStatus(id string) (JobStatus, bool) {
+	p.jobs.mu.Lock()
+	defer p.jobs.mu.Unlock()
+	tj, ok := p.jobs.jobs[id]
+	if !ok {
+		return JobStatus{}, false
+	}
+	return tj.status, true
+}
+
+// CancelJob aborts the job submitted under id: a scheduled job is taken
+// off the schedule, a queued job is skipped when a worker reaches it and a
+// running one has its context canceled, so it stops at the next step.
+// Either way the job's ResultCh receives a context.Canceled error.
+// Canceling a finished job does nothing; unknown IDs fail with
+// apperrors.ErrJobNotFound.
+func (p *Processor) CancelJob(id string) error {
+	p.jobs.mu.Lock()
+	tj, ok := p.jobs.jobs[id]
+	if !ok {
+		p.jobs.mu.Unlock()
+		return apperrors.New(apperrors.CategoryInput, "cancel_job", fmt.Errorf("%w: %q", apperrors.ErrJobNotFound, id))
+	}
+	if tj.status.Finished.IsZero() {
+		tj.status.State = JobCanceled
+		tj.cancel()
+	}
+	p.jobs.mu.Unlock()
+
+	if job, ok := p.sched.remove(id); ok {
+		p.canceledJob(job)
+	}
+	return nil
+}
+
+type jobProgressKey struct{}
+
+// reportProgress tells the job running under ctx, if any, that done of
+// total steps have completed.
+func reportProgress(ctx context.Context, done, total int) {
+	if fn, ok := ctx.Value(jobProgressKey{}).(func(float64)); ok && total > 0 {
+		fn(float64(done) / float64(total))
+	}
+}
@@ -0,0 +1,313 @@
+package core
+
+import (
+	"fmt"
+	"image/color"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// EncodeOptions carries encoding parameters.  The fields below apply to every
+// format; knobs that only make sense for one format live in typed extensions
+// reached through JPEG, PNG, WebP and AVIF (or SetExt for formats defined
+// outside this package):
+//
+//	opts := core.EncodeOptions{Quality: 80}
+//	opts.JPEG().Subsample = core.Subsample444
+//	opts.AVIF().Effort = 4
+//
+// EncodeOptions keeps value semantics: copying it and then modifying an
+// extension on the copy never affects the original.  A pointer returned by
+// an accessor is only valid until the next accessor call or copy.
+type EncodeOptions struct {
+	Quality    int  // 1-100; 0 = use encoder default
+	Lossless   bool // WebP / PNG / AVIF lossless mode
+	StripEXIF  bool
+	Interlaced bool // progressive JPEG / interlaced PNG
+	// Deterministic makes identical inputs and pipelines produce
+	// byte-identical output from the same encoder build: all metadata
+	// (timestamps, software tags, EXIF, XMP) is stripped, a zero Quality
+	// means DeterministicQuality rather than the encoder's configured
+	// default (see QualityOr), and the WebP encoder stays in-process
+	// instead of running cwebp.  Effort and the other knobs already come
+	// from the options or fixed defaults.
+	Deterministic bool
+	// Metadata selects which metadata survives encoding when StripEXIF
+	// and Deterministic are off; the zero policy keeps everything.
+	Metadata MetadataPolicy
+	// Tags are written into JPEG, PNG and WebP output after encoding,
+	// after any stripping, so attribution survives StripEXIF.
+	Tags MetadataTags
+	// EmbedICC writes Meta.ICCProfile into JPEG, PNG and WebP output after
+	// encoding, replacing any profile the encoder wrote, so the colours
+	// of pixels left in a wide-gamut or CMYK space still read correctly.
+	EmbedICC bool
+	// Background fills transparent areas when the target format cannot
+	// store alpha (JPEG).  Default white.
+	Background color.Color
+
+	ext map[Format]FormatOptions
+}
+
+// MetadataPolicy selects metadata to keep or drop, between keeping
+// everything and StripEXIF's removing everything.  With Strip unset the
+// Remove fields name what goes; with Strip set all metadata goes except
+// what the Keep fields name.
+type MetadataPolicy struct {
+	Strip bool
+	// RemoveGPS drops the EXIF GPS directory, and XMP packets that record
+	// a location.
+	RemoveGPS bool
+	// RemoveThumbnails drops the preview image in EXIF IFD1.
+	RemoveThumbnails bool
+	// KeepCopyright keeps the EXIF Artist and Copyright tags, the IPTC
+	// by-line, credit and copyright, and PNG Author and Copyright text.
+	KeepCopyright    bool
+	KeepColorProfile bool // ICC profile
+	KeepOrientation  bool // EXIF orientation tag
+}
+
+// IsZero reports whether p keeps all metadata.
+func (p MetadataPolicy) IsZero() bool { return p == MetadataPolicy{} }
+
+// MetadataTags are EXIF and XMP values written into encoded output.  Empty
+// fields leave the file's own value.
+type MetadataTags struct {
+	Artist    string
+	Copyright string
+	Software  string
+	// XMP is a complete XMP packet; it replaces any the file carries.
+	XMP string
+}
+
+// IsZero reports whether t sets nothing.
+func (t MetadataTags) IsZero() bool { return t == MetadataTags{} }
+
+// FormatOptions is implemented by typed per-format option extensions.
+type FormatOptions interface {
+	// OptionsFormat reports the format the options apply to.
+	OptionsFormat() Format
+}
+
+// DeterministicQuality is the quality a Deterministic encode uses when
+// Quality is 0.
+const DeterministicQuality = 85
+
+// QualityOr returns Quality, or def when it is 0.  A Deterministic encode
+// falls back to DeterministicQuality instead, so its output does not depend
+// on how the encoder was configured.
+func (o EncodeOptions) QualityOr(def int) int {
+	switch {
+	case o.Quality > 0:
+		return o.Quality
+	case o.Deterministic:
+		return DeterministicQuality
+	}
+	return def
+}
+
+// Ext returns the extension registered for format, if any.
+func (o EncodeOptions) Ext(format Format) (FormatOptions, bool) {
+	fo, ok := o.ext[format]
+	return fo, ok
+}
+
+// SetExt stores fo as the extension for fo.OptionsFormat(), replacing any
+// previous one.  Pass a pointer when the encoder expects one.
+func (o *EncodeOptions) SetExt(fo FormatOptions) {
+	ext := make(map[Format]FormatOptions, len(o.ext)+1)
+	for f, v := range o.ext {
+		ext[f] = v
+	}
+	ext[fo.OptionsFormat()] = fo
+	o.ext = ext
+}
+
+// JPEG returns the JPEG extension, creating it if needed.
+func (o *EncodeOptions) JPEG() *JPEGOptions {
+	j := &JPEGOptions{}
+	if cur, ok := o.ext[FormatJPEG].(*JPEGOptions); ok {
+		*j = *cur
+	}
+	o.SetExt(j)
+	return j
+}
+
+// PNG returns the PNG extension, creating it if needed.
+func (o *EncodeOptions) PNG() *PNGOptions {
+	p := &PNGOptions{}
+	if cur, ok := o.ext[FormatPNG].(*PNGOptions); ok {
+		*p = *cur
+	}
+	o.SetExt(p)
+	return p
+}
+
+// WebP returns the WebP extension, creating it if needed.
+func (o *EncodeOptions) WebP() *WebPOptions {
+	w := &WebPOptions{}
+	if cur, ok := o.ext[FormatWebP].(*WebPOptions); ok {
+		*w = *cur
+	}
+	o.SetExt(w)
+	return w
+}
+
+// AVIF returns the AVIF extension, creating it if needed.
+func (o *EncodeOptions) AVIF() *AVIFOptions {
+	a := &AVIFOptions{}
+	if cur, ok := o.ext[FormatAVIF].(*AVIFOptions); ok {
+		*a = *cur
+	}
+	o.SetExt(a)
+	return a
+}
+
+// JPEGOptions carries JPEG-specific encoding parameters.
+type JPEGOptions struct {
+	// Subsample selects chroma subsampling; empty = encoder default (4:2:0).
+	Subsample SubsampleMode
+	// KeepCMYK writes CMYK sources as four-channel Adobe JPEGs for print
+	// workflows instead of converting them to RGB.
+	KeepCMYK bool
+	// Trellis, OvershootDeringing and OptimizeScans enable mozjpeg's size
+	// optimisations: trellis quantization, deringing of hard edges on white
+	// and, for progressive output, per-image scan splitting.  QuantTable
+	// picks one of mozjpeg's quantization tables, 0-8; 0 is the standard
+	// Annex K table and 3 the ImageMagick one mozjpeg tunes for.  Encoders
+	// not built on mozjpeg ignore all four.
+	Trellis            bool
+	OvershootDeringing bool
+	OptimizeScans      bool
+	QuantTable         int
+}
+
+func (*JPEGOptions) OptionsFormat() Format { return FormatJPEG }
+
+// SubsampleMode is a JPEG chroma subsampling layout.  Text-heavy images such
+// as screenshots need 4:4:4 to keep coloured edges crisp; photos are fine with
+// 4:2:0.
+type SubsampleMode string
+
+const (
+	SubsampleAuto SubsampleMode = ""
+	Subsample444  SubsampleMode = "4:4:4"
+	Subsample422  SubsampleMode = "4:2:2"
+	Subsample420  SubsampleMode = "4:2:0"
+)
+
+// PNGOptions carries PNG-specific encoding parameters.
+type PNGOptions struct {
+	// CompressionLevel is the zlib level, 1 (fastest) to 9 (smallest);
+	// 0 = encoder default.
+	CompressionLevel int
+	// Filter selects the row filter; empty = adaptive per-row choice.
+	Filter PNGFilter
+	// Reduce picks the smallest colour type and bit depth that represents
+	// the image: palette for ≤256 colours, gray for neutral images and no
+	// alpha channel for opaque ones.  Lossless on the stdlib encoder; libvips
+	// quantises with libimagequant at EncodeOptions.Quality (default 100).
+	Reduce bool
+	// Colors quantises to a palette of at most this many colours (2-256)
+	// before writing, producing PNG8; lossy, unlike Reduce.  0 = off.
+	Colors int
+	// Dither diffuses the error when Colors quantises.
+	Dither bool
+	// StripMetadata drops ancillary chunks (tEXt, eXIf, iCCP, tIME, …).
+	StripMetadata bool
+}
+
+func (*PNGOptions) OptionsFormat() Format { return FormatPNG }
+
+// PNGFilter is a PNG row filter strategy.
+type PNGFilter string
+
+const (
+	PNGFilterAdaptive PNGFilter = ""
+	PNGFilterNone     PNGFilter = "none"
+	PNGFilterSub      PNGFilter = "sub"
+	PNGFilterUp       PNGFilter = "up"
+	PNGFilterAverage  PNGFilter = "average"
+	PNGFilterPaeth    PNGFilter = "paeth"
+)
+
+// WebPOptions carries WebP-specific encoding parameters.
+type WebPOptions struct {
+	// Effort trades CPU for size: 1 (fast) to 6 (smallest), libwebp's
+	// "method".  0 keeps the encoder default (4); WebPEffortFastest selects
+	// method 0.
+	Effort int
+	// AlphaQuality is the lossy quality of the alpha plane, 1-100; 0 keeps
+	// the encoder default (100).
+	AlphaQuality int
+	// NearLossless enables libwebp's near-lossless preprocessing; Quality
+	// then controls the preprocessing strength.  Implies Lossless.
+	NearLossless bool
+}
+
+// WebPEffortFastest is the WebPOptions.Effort selecting libwebp method 0,
+// which the zero value cannot since it keeps the default.
+const WebPEffortFastest = -1
+
+// Method returns the libwebp method, 0-6, that Effort selects.
+func (o *WebPOptions) Method() int {
+	switch {
+	case o.Effort < 0:
+		return 0
+	case o.Effort == 0:
+		return 4
+	}
+	return min(o.Effort, 6)
+}
+
+func (*WebPOptions) OptionsFormat() Format { return FormatWebP }
+
+// AVIFOptions carries AVIF-specific encoding parameters.
+type AVIFOptions struct {
+	// Effort trades CPU for size: 0 (fastest) to 9 (smallest); 0 keeps the
+	// encoder default (5).
+	Effort int
+	// Bitdepth is 8, 10 or 12; 0 = 8.
+	Bitdepth int
+}
+
+func (*AVIFOptions) OptionsFormat() Format { return FormatAVIF }
+
+// DecodeOptions carries decoding parameters for pipeline.DecodeStep.
+type DecodeOptions struct {
+	// Page selects the page of a multi-page source (TIFF, PDF), numbered
+	// from 1; 0 decodes the first.  Needs a decoder that implements
+	// PageDecoder.
+	Page int
+	// DecodeLimits rejects images whose header declares too many pixels
+	// before they are decoded; zero fields take the Processor's
+	// config.MaxPixels / MaxDecodedBytes.
+	DecodeLimits
+}
+
+// DecodeLimits guards decoders against decompression bombs: images whose
+// header declares more than MaxPixels pixels, or a decoded size above
+// MaxDecodedBytes (width × height × bytes per pixel), are refused before
+// their pixels are decoded.  Zero fields do not limit.
+type DecodeLimits struct {
+	MaxPixels       int64
+	MaxDecodedBytes int64
+}
+
+// Check returns an ErrImageTooLarge error from op when a width × height
+// image of bytesPerPixel exceeds l.
+func (l DecodeLimits) Check(op string, width, height, bytesPerPixel int) error {
+	pixels := int64(width) * int64(height)
+	switch {
+	case l.MaxPixels > 0 && pixels > l.MaxPixels:
+		return apperrors.New(apperrors.CategoryInput, op,
+			fmt.Errorf("%w: %dx%d exceeds %d pixels", apperrors.ErrImageTooLarge, width, height, l.MaxPixels))
+	case l.MaxDecodedBytes > 0 && pixels*int64(bytesPerPixel) > l.MaxDecodedBytes:
+		return apperrors.New(apperrors.CategoryInput, op, fmt.Errorf("%w: %dx%d decodes to %d bytes, limit %d",
+			apperrors.ErrImageTooLarge, width, height, pixels*int64(bytesPerPixel), l.MaxDecodedBytes))
+	}
+	return nil
+}
+
+// IsZero reports whether l sets no limit.
+func (l DecodeLimits) IsZero() bool { return l.MaxPixels <= 0 && l.MaxDecodedBytes <= 0 }
@@ -0,0 +1,190 @@
+package core
+
+import (
+	"context"
+	"math"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ── Worker pool ───────────────────────────────────────────────────────────────
+
+// PoolStats is a snapshot of the worker pool.
+type PoolStats struct {
+	Workers int
+	Busy    int // workers running a job
+	// Queued is the number of jobs waiting, or -1 when the JobQueue cannot
+	// tell (queues report it with a Len() int method, as MemoryQueue does).
+	Queued int
+	// AvgLatency is the mean job time over the last scaling interval, or
+	// since Start for a fixed-size pool.
+	AvgLatency time.Duration
+}
+
+// pool tracks the running workers and their load.
+type pool struct {
+	mu      sync.Mutex
+	retires []context.CancelFunc // one per running worker, oldest first
+	busy    atomic.Int64
+	done    atomic.Int64 // jobs finished since Start
+
+	abortedIDs []string // jobs cut short by Stop, guarded by mu
+
+	latencyNs atomic.Int64 // job time and count since the last sample
+	jobs      atomic.Int64
+	avgNs     atomic.Int64 // last sampled mean
+}
+
+// PoolStats reports the size and load of the worker pool.
+func (p *Processor) PoolStats() PoolStats {
+	p.pool.mu.Lock()
+	workers := len(p.pool.retires)
+	p.pool.mu.Unlock()
+	s := PoolStats{
+		Workers:    workers,
+		Busy:       int(p.pool.busy.Load()),
+		Queued:     -1,
+		AvgLatency: time.Duration(p.pool.avgNs.Load()),
+	}
+	if l, ok := p.queue.(interface{ Len() int }); ok {
+		s.Queued = l.Len()
+	}
+	return s
+}
+
+// poolBounds returns the initial pool size and the range autoscaling keeps
+// it in; min == max for a fixed pool.
+func (p *Processor) poolBounds() (initial, lo, hi int) {
+	n := p.cfg.WorkerCount
+	if p.cfg.MaxWorkers <= 0 {
+		if n <= 0 {
+			n = runtime.NumCPU()
+		}
+		return n, n, n
+	}
+	lo = max(p.cfg.MinWorkers, 1)
+	hi = max(p.cfg.MaxWorkers, lo)
+	if n <= 0 {
+		n = lo
+	}
+	return clampInt(n, lo, hi), lo, hi
+}
+
+// spawn starts one more worker.
+func (p *Processor) spawn() {
+	ctx, retire := context.WithCancel(p.stopCtx)
+	p.pool.mu.Lock()
+	p.pool.retires = append(p.pool.retires, retire)
+	p.pool.mu.Unlock()
+	p.wg.Add(1)
+	go p.worker(ctx)
+}
+
+// retire asks the newest worker to exit once it has finished its job.
+func (p *Processor) retire() {
+	p.pool.mu.Lock()
+	defer p.pool.mu.Unlock()
+	if n := len(p.pool.retires); n > 0 {
+		p.pool.retires[n-1]()
+		p.pool.retires = p.pool.retires[:n-1]
+	}
+}
+
+// runJob processes job, accounting for the pool's load.
+func (p *Processor) runJob(job Job) {
+	p.pool.busy.Add(1)
+	start := time.Now()
+	p.processJob(job)
+	p.pool.latencyNs.Add(int64(time.Since(start)))
+	p.pool.jobs.Add(1)
+	p.pool.done.Add(1)
+	p.pool.busy.Add(-1)
+	if p.cfg.MaxWorkers <= 0 {
+		p.sampleLatency(false)
+	}
+}
+
+// sampleLatency updates the mean job time from the jobs finished since the
+// last sample, starting afresh when reset is set.
+func (p *Processor) sampleLatency(reset bool) {
+	var sum, n int64
+	if reset {
+		sum, n = p.pool.latencyNs.Swap(0), p.pool.jobs.Swap(0)
+	} else {
+		sum, n = p.pool.latencyNs.Load(), p.pool.jobs.Load()
+	}
+	if n > 0 {
+		p.pool.avgNs.Store(sum / n)
+	}
+}
+
+// autoscale resizes the pool every cfg.ScaleInterval until the processor
+// stops.
+func (p *Processor) autoscale(lo, hi int) {
+	interval := p.cfg.ScaleInterval
+	if interval <= 0 {
+		interval = time.Second
+	}
+	tick := time.NewTicker(interval)
+	defer tick.Stop()
+	for {
+		select {
+		case <-p.stopCtx.Done():
+			return
+		case <-tick.C:
+		}
+		p.sampleLatency(true)
+		s := p.PoolStats()
+		target := scaleTarget(s, lo, hi, interval)
+		for n := s.Workers; n < target; n++ {
+			p.spawn()
+		}
+		for n := s.Workers; n > target; n-- {
+			p.retire()
+		}
+		if target != s.Workers && p.logger != nil {
+			p.logger.Info("worker pool resized", "from", s.Workers, "to", target,
+				"queued", s.Queued, "busy", s.Busy, "avg_latency", s.AvgLatency)
+		}
+	}
+}
+
+// scaleTarget picks the pool size for the next interval.  While jobs wait
+// and every worker is busy, the pool grows enough to clear the backlog
+// within one interval at the recent job latency, or by one worker while
+// the latency is unknown.  With nothing waiting it sheds one idle worker
+// per interval, so short lulls do not empty it.
+func scaleTarget(s PoolStats, lo, hi int, interval time.Duration) int {
+	target := s.Workers
+	backlog := s.Queued > 0 || (s.Queued < 0 && s.Busy >= s.Workers)
+	switch {
+	case backlog && s.Busy >= s.Workers:
+		target++
+		if s.AvgLatency > 0 && s.Queued > 0 {
+			need := int(math.Ceil(float64(s.Queued) * float64(s.AvgLatency) / float64(interval)))
+			target = max(target, s.Busy+need)
+		}
+	case !backlog && s.Busy < s.Workers:
+		target--
+	}
+	return clampInt(target, lo, hi)
+}
+
+func clampInt(v, lo, hi int) int { return min(max(v, lo), hi) }
+
+// aborted records a job cut short by Stop.
+func (pl *pool) aborted(id string) {
+	pl.mu.Lock()
+	pl.abortedIDs = append(pl.abortedIDs, id)
+	pl.mu.Unlock()
+}
+
+func (pl *pool) takeAborted() []string {
+	pl.mu.Lock()
+	defer pl.mu.Unlock()
+	ids := pl.abortedIDs
+	pl.abortedIDs = nil
+	return ids
+}
@@ -2,7 +2,9 @@ package core
 
 import (
 	"context"
-	"runtime"
+	"errors"
+	"fmt"
+	"maps"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -28,33 +30,57 @@ type Processor struct {
 	metrics  MetricsCollector
 
 	// Worker pool.
-	jobQueue chan Job
+	queue    JobQueue
+	jobs     jobTable
+	sched    scheduler
+	pool     pool
 	wg       sync.WaitGroup
 	once     sync.Once
-	shutdown chan struct{}
+	stopCtx  context.Context //nolint:containedctx // canceled by Stop
+	stop     context.CancelFunc
+	abortCtx context.Context //nolint:containedctx // canceled when Stop gives up draining
+	abort    context.CancelFunc
+	stopping atomic.Bool
+	started  atomic.Bool
+	// submitMu is held shared by submit across its stopping check and
+	// enqueue, and exclusively by Stop before it drains, so no job lands
+	// in the queue after the drain began.  refuseCtx, canceled as Stop
+	// begins, releases submits waiting for room.
+	submitMu  sync.RWMutex
+	refuseCtx context.Context //nolint:containedctx // canceled when Stop begins
+	refuse    context.CancelFunc
 
 	// Atomic counters for lightweight internal metrics.
 	processedCount int64
 	errorCount     int64
+
+	// budget bounds the decoded bytes in flight; nil without
+	// cfg.MemoryBudget.
+	budget *memoryBudget
+
+	// Result cache consulted by Process; see SetResultCache.
+	cache    ResultCache
+	cacheTTL time.Duration
+
+	// Process runs in flight, shared with cfg.Coalesce.
+	flights flightGroup
 }
 
 // New creates a Processor with the given config.  Call Start() before
 // submitting jobs; call Stop() when done.
 func New(cfg config.Config, reg Registry) *Processor {
-	workerCount := cfg.WorkerCount
-	if workerCount <= 0 {
-		workerCount = runtime.NumCPU()
-	}
-	queueSize := cfg.QueueSize
-	if queueSize <= 0 {
-		queueSize = 256
-	}
-	return &Processor{
+	p := &Processor{
 		cfg:      cfg,
 		registry: reg,
-		jobQueue: make(chan Job, queueSize),
-		shutdown: make(chan struct{}),
+		queue:    NewMemoryQueue(cfg.QueueSize),
+	}
+	p.stopCtx, p.stop = context.WithCancel(context.Background())
+	p.abortCtx, p.abort = context.WithCancel(context.Background())
+	p.refuseCtx, p.refuse = context.WithCancel(context.Background())
+	if cfg.MemoryBudget > 0 {
+		p.budget = newMemoryBudget(cfg.MemoryBudget)
 	}
+	return p
 }
 
 // SetLogger attaches a structured logger.
@@ -63,6 +89,10 @@ func (p *Processor) SetLogger(l Logger) { p.logger = l }
 // SetMetrics attaches a metrics collector.
 func (p *Processor) SetMetrics(m MetricsCollector) { p.metrics = m }
 
+// SetJobQueue replaces the in-memory queue Submit feeds and the workers
+// drain.  Call it before Start.
+func (p *Processor) SetJobQueue(q JobQueue) { p.queue = q }
+
 // AddHook registers a pipeline hook.
 func (p *Processor) AddHook(h Hook) { p.hooks = append(p.hooks, h) }
 
@@ -70,28 +100,108 @@ func (p *Processor) AddHook(h Hook) { p.hooks = append(p.hooks, h) }
 // encoders/decoders after construction.
 func (p *Processor) Registry() Registry { return p.registry }
 
-// Start launches the worker pool.  It is idempotent.
+// Start launches the worker pool.  It is idempotent.  With
+// cfg.MaxWorkers set the pool is resized between cfg.MinWorkers and
+// cfg.MaxWorkers as the queue grows and drains; see PoolStats.
 func (p *Processor) Start() {
 	p.once.Do(func() {
-		workerCount := p.cfg.WorkerCount
-		if workerCount <= 0 {
-			workerCount = runtime.NumCPU()
+		p.started.Store(true)
+		n, lo, hi := p.poolBounds()
+		for i := 0; i < n; i++ {
+			p.spawn()
 		}
-		for i := 0; i < workerCount; i++ {
-			p.wg.Add(1)
-			go p.worker()
+		if hi > lo {
+			go p.autoscale(lo, hi)
 		}
 	})
 }
 
-// Stop drains the queue and shuts down all workers.
-func (p *Processor) Stop() {
-	close(p.shutdown)
+// StopReport summarizes a Stop.
+type StopReport struct {
+	// Drained counts the jobs the workers finished while stopping.
+	Drained int
+	// Abandoned lists the IDs ("" for jobs without one) of the jobs given
+	// up on: scheduled for later, still queued when ctx expired, or cut
+	// short by that.  Each received ErrProcessorStopped on its ResultCh.
+	Abandoned []string
+}
+
+// Stop shuts the processor down gracefully.  Submit and its variants fail
+// with ErrProcessorStopped from the start, including those waiting for
+// room in the queue; the workers then finish the
+// queued jobs until ctx is done.  Jobs still queued at that point, those
+// running, which are canceled, and those scheduled for later are
+// abandoned: each gets a terminal error on its ResultCh and is listed in
+// the report, so none is dropped silently.  Stop returns ctx's error when
+// it expires before the queue drains.  A persistent JobQueue keeps the
+// jobs Stop never took for the next process.  Only the first call does
+// anything.
+func (p *Processor) Stop(ctx context.Context) (StopReport, error) {
+	var report StopReport
+	if !p.stopping.CompareAndSwap(false, true) {
+		return report, nil
+	}
+	// Wait out submits that passed the stopping check.
+	p.refuse()
+	p.submitMu.Lock()
+	p.submitMu.Unlock() //nolint:staticcheck // an empty critical section is the point
+	// Scheduled jobs would fall due after the workers are gone.
+	for _, job := range p.sched.close() {
+		p.abandon(job, &report)
+	}
+	for _, job := range p.sched.wait() {
+		p.abandon(job, &report)
+	}
+	before := p.pool.done.Load()
+	var err error
+	if p.started.Load() {
+		err = p.drain(ctx)
+	}
+	if err != nil {
+		p.abort()
+	}
+	p.stop()
 	p.wg.Wait()
+
+	aborted := p.pool.takeAborted()
+	report.Drained = int(p.pool.done.Load()-before) - len(aborted)
+	report.Abandoned = append(report.Abandoned, aborted...)
+	if q, ok := p.queue.(*MemoryQueue); ok {
+		for _, job := range q.drain() {
+			p.abandon(job, &report)
+		}
+	}
+	return report, err
+}
+
+// drain waits until no job is queued or running, or ctx is done.
+func (p *Processor) drain(ctx context.Context) error {
+	tick := time.NewTicker(5 * time.Millisecond)
+	defer tick.Stop()
+	for {
+		s := p.PoolStats()
+		if s.Busy == 0 && s.Queued <= 0 {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return apperrors.Wrap(apperrors.CategoryPipeline, "stop", ctx.Err())
+		case <-tick.C:
+		}
+	}
+}
+
+// abandon fails a job that Stop will not run.
+func (p *Processor) abandon(job Job, report *StopReport) {
+	p.complete(job, nil, apperrors.New(apperrors.CategoryPipeline, "stop", apperrors.ErrProcessorStopped))
+	report.Abandoned = append(report.Abandoned, job.ID)
 }
 
 // Process is the primary synchronous API.  It reads from src, runs steps, and
-// returns a ProcessingResult.
+// returns a ProcessingResult.  With cfg.Coalesce, concurrent calls with the
+// same source bytes and steps share one run.  A result cache installed with
+// SetResultCache is consulted first.  With cfg.MemoryBudget set it then
+// waits until the image's estimated decoded size fits in the budget.
 func (p *Processor) Process(ctx context.Context, src Source, steps ...Step) (*ProcessingResult, error) {
 	if len(steps) == 0 {
 		return nil, apperrors.New(apperrors.CategoryPipeline, "process", apperrors.ErrEmptyInput)
@@ -113,9 +223,12 @@ func (p *Processor) Process(ctx context.Context, src Source, steps ...Step) (*Pr
 	utils.ReleaseBuffer(buf)
 
 	// --- 2. Detect format ----------------------------------------------------
-	format := Format(utils.DetectFormat(rawBytes))
-	if src.ContentType != "" {
-		format = contentTypeToFormat(src.ContentType)
+	// The content-type hint only wins when sniffing was not conclusive, so a
+	// mislabeled upload is still decoded by the right codec.
+	detected := utils.Detect(rawBytes)
+	format := Format(detected.Format)
+	if hint := contentTypeToFormat(src.ContentType); hint != FormatUnknown && detected.Confidence < utils.ConfidenceMagic {
+		format = hint
 	}
 
 	img := &ImageData{
@@ -124,10 +237,75 @@ func (p *Processor) Process(ctx context.Context, src Source, steps ...Step) (*Pr
 		OriginalSize: int64(len(rawBytes)),
 	}
 
-	// --- 3. Run steps --------------------------------------------------------
+	var key string
+	if p.cache != nil || p.cfg.Coalesce {
+		key = resultKey(rawBytes, steps)
+	}
+
+	// --- 3. Join an identical run in flight ----------------------------------
+	var result *ProcessingResult
+	if p.cfg.Coalesce && key != "" {
+		result, err = p.flights.do(ctx, key, func(ctx context.Context) (*ProcessingResult, error) {
+			return p.processRaw(ctx, key, img, steps)
+		})
+	} else {
+		result, err = p.processRaw(ctx, key, img, steps)
+	}
+	if err != nil {
+		return nil, err
+	}
+	result.ProcessingTime = time.Since(start)
+	return result, nil
+}
+
+// processRaw finishes Process for a drained source: key is its resultKey,
+// or "" when neither the cache nor coalescing needs one or steps have no
+// fingerprint.
+func (p *Processor) processRaw(ctx context.Context, key string, img *ImageData, steps []Step) (*ProcessingResult, error) {
+	// --- 4. Look up the result cache -----------------------------------------
+	if p.cache != nil && key != "" {
+		if res, ok := p.cachedResult(ctx, key); ok {
+			return res, nil
+		}
+	}
+
+	// --- 5. Reserve memory for the decoded image -----------------------------
+	// Sources whose header cannot be probed are not counted.
+	if p.budget != nil {
+		if h, err := utils.ProbeHeader(img.Data); err == nil {
+			n := h.DecodedBytes()
+			if err := p.budget.acquire(ctx, n); err != nil {
+				atomic.AddInt64(&p.errorCount, 1)
+				return nil, err
+			}
+			defer p.budget.release(n)
+		}
+	}
+
+	// --- 6. Run steps --------------------------------------------------------
+	result, err := p.ProcessImage(ctx, img, steps...)
+	if err != nil {
+		return nil, err
+	}
+	if p.cache != nil && key != "" {
+		p.storeResult(ctx, key, img.Data, result)
+	}
+	return result, nil
+}
+
+// ProcessImage runs steps on an ImageData the caller already holds, such as
+// a frame split out of an animation, with the same registry binding, hooks
+// and counters as Process.  Side outputs left in ImageData.Branches by tee
+// steps become the result's Variants.  Conversions between backends that
+// steps make along the way are reported to the hooks as pseudo-steps named
+// "convert.to_std" or "convert.to_vips".
+func (p *Processor) ProcessImage(ctx context.Context, img *ImageData, steps ...Step) (*ProcessingResult, error) {
+	ctx = WithConversionObserver(ctx, p.observeConversion)
+	start := time.Now()
 	timings := make(map[string]time.Duration, len(steps))
 	current := img
-	for _, step := range steps {
+	for i, step := range steps {
+		step = p.bind(step)
 		if err := ctx.Err(); err != nil {
 			atomic.AddInt64(&p.errorCount, 1)
 			return nil, apperrors.Wrap(apperrors.CategoryPipeline, step.Name(), err)
@@ -143,57 +321,140 @@ func (p *Processor) Process(ctx context.Context, src Source, steps ...Step) (*Pr
 			return nil, stepErr
 		}
 		current = next
+		reportProgress(ctx, i+1, len(steps))
 	}
 
 	atomic.AddInt64(&p.processedCount, 1)
 
 	total := time.Since(start)
-	return &ProcessingResult{
+	result := &ProcessingResult{
 		Primary:        current,
 		ProcessingTime: total,
 		StepTimings:    timings,
-	}, nil
+		Codecs:         current.Codecs,
+	}
+	if len(current.Branches) > 0 {
+		primary := *current
+		primary.Branches = nil
+		result.Primary = &primary
+		result.Variants = current.Branches
+	}
+	return result, nil
 }
 
-// Submit enqueues an async job.  Returns ErrWorkerPoolFull if the queue is full.
+// Submit enqueues an async job on the processor's JobQueue.  The default
+// MemoryQueue holds up to cfg.QueueSize jobs across all priorities; when it
+// is full, cfg.QueueFull decides whether Submit fails with
+// ErrWorkerPoolFull (the default), waits for room or drops an older job.
+// Jobs with an ID can be followed with JobStatus and aborted with
+// CancelJob.
 func (p *Processor) Submit(job Job) error {
-	select {
-	case p.jobQueue <- job:
-		return nil
-	default:
-		return apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrWorkerPoolFull)
+	ctx := job.Ctx
+	if ctx == nil {
+		ctx = context.Background()
 	}
+	return p.submit(ctx, job, p.cfg.QueueFull)
 }
 
-// Batch processes multiple sources concurrently (fan-out / fan-in).
-func (p *Processor) Batch(ctx context.Context, sources []Source, steps ...Step) ([]*ProcessingResult, []error) {
-	results := make([]*ProcessingResult, len(sources))
-	errs := make([]error, len(sources))
-	var wg sync.WaitGroup
+// SubmitWait enqueues job like Submit but, whatever cfg.QueueFull says,
+// waits while the job's queue is full until there is room or ctx is done.
+func (p *Processor) SubmitWait(ctx context.Context, job Job) error {
+	return p.submit(ctx, job, config.QueueFullBlock)
+}
 
-	for i, src := range sources {
-		wg.Add(1)
-		go func(idx int, s Source) {
-			defer wg.Done()
-			r, e := p.Process(ctx, s, steps...)
-			results[idx] = r
-			errs[idx] = e
-		}(i, src)
+func (p *Processor) submit(ctx context.Context, job Job, policy config.QueueFullPolicy) error {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.stopping.Load() {
+		return apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrProcessorStopped)
 	}
-	wg.Wait()
-	return results, errs
+	if _, err := priorityIndex(job.Priority); err != nil {
+		return err
+	}
+	var err error
+	if job.ID != "" {
+		if job, err = p.jobs.add(job); err != nil {
+			return err
+		}
+	}
+	if err = p.offer(ctx, job, policy); err != nil {
+		if job.ID != "" {
+			p.jobs.remove(job.ID)
+		}
+		return err
+	}
+	return nil
+}
+
+// offer enqueues job for submit and for scheduled jobs falling due; the
+// caller holds submitMu for reading and has checked p.stopping.
+func (p *Processor) offer(ctx context.Context, job Job, policy config.QueueFullPolicy) error {
+	if policy == config.QueueFullBlock {
+		// Stop waits for this submit, so waiting for room must end with it.
+		var cancel context.CancelCauseFunc
+		ctx, cancel = context.WithCancelCause(ctx)
+		defer cancel(nil)
+		defer context.AfterFunc(p.refuseCtx, func() { cancel(apperrors.ErrProcessorStopped) })()
+	}
+	if err := p.enqueue(ctx, job, policy); err != nil {
+		if errors.Is(context.Cause(ctx), apperrors.ErrProcessorStopped) {
+			return apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrProcessorStopped)
+		}
+		return err
+	}
+	return nil
+}
+
+// enqueue puts job on the queue, applying policy if the queue is bounded
+// and supports it.
+func (p *Processor) enqueue(ctx context.Context, job Job, policy config.QueueFullPolicy) error {
+	switch policy {
+	case config.QueueFullBlock:
+		if q, ok := p.queue.(BlockingQueue); ok {
+			return q.EnqueueWait(ctx, job)
+		}
+	case config.QueueFullDropOldest:
+		if q, ok := p.queue.(EvictingQueue); ok {
+			evicted, err := q.EnqueueEvict(ctx, job)
+			for _, old := range evicted {
+				p.complete(old, nil, apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrJobDropped))
+			}
+			return err
+		}
+	}
+	return p.queue.Enqueue(ctx, job)
 }
 
 // ProcessVariants runs each VariantDefinition against the decoded image in
 // parallel and returns a ProcessingResult with a populated Variants map.
+// A variant with From set waits for the variant it names and starts from
+// that output instead; unknown names and cycles fail before anything runs.
 func (p *Processor) ProcessVariants(ctx context.Context, src Source, baseSteps []Step, variants []VariantDefinition) (*ProcessingResult, error) {
+	if err := checkVariantGraph(variants); err != nil {
+		return nil, err
+	}
+	ctx = WithConversionObserver(ctx, p.observeConversion)
 	// First run base steps.
 	base, err := p.Process(ctx, src, baseSteps...)
 	if err != nil {
 		return nil, err
 	}
 
-	variantResults := make(map[string]*ImageData, len(variants))
+	// Tee branches from the base steps are kept; a variant of the same name
+	// replaces its branch.
+	variantResults := make(map[string]*ImageData, len(base.Variants)+len(variants))
+	maps.Copy(variantResults, base.Variants)
+	done := make(map[string]chan struct{}, len(variants))
+	for _, v := range variants {
+		done[v.Name] = make(chan struct{})
+	}
+	for _, v := range variants {
+		_, isVariant := done[v.From]
+		if _, isBranch := base.Variants[v.From]; v.From != "" && !isVariant && !isBranch {
+			return nil, apperrors.New(apperrors.CategoryConfig, "variants",
+				fmt.Errorf("variant %q derives from unknown variant %q", v.Name, v.From))
+		}
+	}
 	var mu sync.Mutex
 	var wg sync.WaitGroup
 	errs := make([]error, 0)
@@ -202,12 +463,29 @@ func (p *Processor) ProcessVariants(ctx context.Context, src Source, baseSteps [
 		wg.Add(1)
 		go func(vd VariantDefinition) {
 			defer wg.Done()
-			// Clone the base ImageData so variant steps don't mutate each other.
-			clone := *base.Primary
-			result := &clone
-			var stepErr error
+			defer close(done[vd.Name])
+			start := base.Primary
+			if vd.From != "" {
+				if ch, ok := done[vd.From]; ok {
+					<-ch
+				}
+				mu.Lock()
+				start = variantResults[vd.From]
+				mu.Unlock()
+				if start == nil {
+					return // the parent failed and reported its error
+				}
+			}
+			// Clone the starting ImageData so variant steps don't mutate each other.
+			result, stepErr := start.Clone()
+			if stepErr != nil {
+				mu.Lock()
+				errs = append(errs, stepErr)
+				mu.Unlock()
+				return
+			}
 			for _, step := range vd.Steps {
-				result, stepErr = step.Execute(ctx, result)
+				result, stepErr = ExecuteStep(ctx, p.bind(step), result, p.cfg.StepTimeout)
 				if stepErr != nil {
 					mu.Lock()
 					errs = append(errs, stepErr)
@@ -229,33 +507,135 @@ func (p *Processor) ProcessVariants(ctx context.Context, src Source, baseSteps [
 	return base, nil
 }
 
+// checkVariantGraph rejects variants sharing a name and variants whose From
+// chain loops back on itself.
+func checkVariantGraph(variants []VariantDefinition) error {
+	from := make(map[string]string, len(variants))
+	for _, v := range variants {
+		if _, dup := from[v.Name]; dup {
+			return apperrors.New(apperrors.CategoryConfig, "variants",
+				fmt.Errorf("variant %q defined more than once", v.Name))
+		}
+		from[v.Name] = v.From
+	}
+	for _, v := range variants {
+		seen := map[string]bool{v.Name: true}
+		for parent := v.From; parent != ""; parent = from[parent] {
+			if seen[parent] {
+				return apperrors.New(apperrors.CategoryConfig, "variants",
+					fmt.Errorf("variant %q derives from itself through %q", v.Name, parent))
+			}
+			seen[parent] = true
+		}
+	}
+	return nil
+}
+
 // ── worker pool internals ──────────────────────────────────────────────────────
 
-func (p *Processor) worker() {
+// worker runs jobs until ctx, canceled when the processor stops or the
+// pool shrinks, is done.
+func (p *Processor) worker(ctx context.Context) {
 	defer p.wg.Done()
 	for {
-		select {
-		case <-p.shutdown:
+		job, err := p.queue.Dequeue(ctx)
+		if err == nil && p.abortCtx.Err() != nil {
+			p.requeue(job)
 			return
-		case job, ok := <-p.jobQueue:
-			if !ok {
+		}
+		if err != nil && ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			// A persistent queue may be briefly unreachable; back off
+			// rather than spin.
+			p.logError("dequeue failed", "error", err)
+			select {
+			case <-ctx.Done():
 				return
+			case <-time.After(time.Second):
 			}
-			p.processJob(job)
+			continue
 		}
+		// A job in hand is run even if the worker is asked to exit, so
+		// none is lost between Dequeue and the exit.
+		p.runJob(job)
+		if err := p.queue.Ack(context.Background(), job); err != nil {
+			p.logError("ack failed", "job", job.ID, "error", err)
+		}
+	}
+}
+
+// requeue hands a job taken after Stop gave up back to the queue.
+func (p *Processor) requeue(job Job) {
+	if err := p.queue.Nack(context.Background(), job); err != nil {
+		p.logError("nack failed", "job", job.ID, "error", err)
+	}
+}
+
+func (p *Processor) logError(msg string, fields ...interface{}) {
+	if p.logger != nil {
+		p.logger.Error(msg, fields...)
 	}
 }
 
 func (p *Processor) processJob(job Job) {
 	ctx := job.Ctx
+	if ctx == nil {
+		// Jobs read back from a persistent queue carry no context.
+		ctx = context.Background()
+	}
 	timeout := p.cfg.JobTimeout
 	if timeout > 0 {
 		var cancel context.CancelFunc
 		ctx, cancel = context.WithTimeout(ctx, timeout)
 		defer cancel()
 	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	defer context.AfterFunc(p.abortCtx, cancel)()
+
+	canceled := false
+	if job.ID != "" {
+		p.jobs.update(job.ID, func(s *JobStatus) {
+			switch s.State {
+			case JobQueued:
+				s.State, s.Started = JobRunning, time.Now()
+			case JobCanceled:
+				canceled = true
+			}
+		})
+		ctx = context.WithValue(ctx, jobProgressKey{}, func(f float64) {
+			p.jobs.update(job.ID, func(s *JobStatus) { s.Progress = f })
+		})
+	}
 
-	result, err := p.Process(ctx, job.Source, job.Steps...)
+	var (
+		result *ProcessingResult
+		err    error
+	)
+	if err = ctx.Err(); err == nil && canceled {
+		// Canceled while queued in a persistent queue, which does not
+		// carry the job's context.
+		err = context.Canceled
+	}
+	if err != nil {
+		err = apperrors.Wrap(apperrors.CategoryPipeline, "job", err)
+	} else {
+		result, err = p.Process(ctx, job.Source, job.Steps...)
+	}
+	if err != nil && p.abortCtx.Err() != nil {
+		err = apperrors.New(apperrors.CategoryPipeline, "stop", apperrors.ErrProcessorStopped)
+		p.pool.aborted(job.ID)
+	}
+	p.complete(job, result, err)
+}
+
+// complete records job's outcome and sends it to the job's ResultCh.
+func (p *Processor) complete(job Job, result *ProcessingResult, err error) {
+	if job.ID != "" {
+		p.jobs.finish(job.ID, err)
+	}
 	if job.ResultCh != nil {
 		job.ResultCh <- JobResult{JobID: job.ID, Result: result, Err: err}
 	}
@@ -270,7 +650,7 @@ func (p *Processor) runWithRetry(ctx context.Context, step Step, img *ImageData)
 		err    error
 	)
 	for i := 0; i <= maxRetries; i++ {
-		result, err = step.Execute(ctx, img)
+		result, err = ExecuteStep(ctx, step, img, p.cfg.StepTimeout)
 		if err == nil || !apperrors.IsRetryable(err) {
 			return result, err
 		}
@@ -285,6 +665,23 @@ func (p *Processor) runWithRetry(ctx context.Context, step Step, img *ImageData)
 	return result, err
 }
 
+// Bind returns step bound to the processor's registry and configuration, as
+// Process does before running each step.
+func (p *Processor) Bind(step Step) Step { return p.bind(step) }
+
+// bind hands the processor's registry to steps that need one but were
+// constructed without it (e.g. imageprocessor.Decode()), and its config to
+// steps that take defaults from it.
+func (p *Processor) bind(step Step) Step {
+	if b, ok := step.(RegistryBinder); ok {
+		step = b.BindRegistry(p.registry)
+	}
+	if b, ok := step.(ConfigBinder); ok {
+		step = b.BindConfig(p.cfg)
+	}
+	return step
+}
+
 func (p *Processor) notifyBefore(ctx context.Context, name string, img *ImageData) {
 	for _, h := range p.hooks {
 		h.BeforeStep(ctx, name, img)
@@ -297,6 +694,13 @@ func (p *Processor) notifyAfter(ctx context.Context, name string, img *ImageData
 	}
 }
 
+// observeConversion is the processor's ConversionObserver: it reports a
+// finished conversion to the hooks as a step of its own.
+func (p *Processor) observeConversion(ctx context.Context, name string, img *ImageData, d time.Duration, err error) {
+	p.notifyBefore(ctx, name, img)
+	p.notifyAfter(ctx, name, img, d, err)
+}
+
 // contentTypeToFormat maps MIME types to Format values.
 func contentTypeToFormat(ct string) Format {
 	switch ct {
@@ -306,6 +710,26 @@ func contentTypeToFormat(ct string) Format {
 		return FormatPNG
 	case "image/webp":
 		return FormatWebP
+	case "image/gif":
+		return FormatGIF
+	case "image/tiff":
+		return FormatTIFF
+	case "image/bmp", "image/x-ms-bmp":
+		return FormatBMP
+	case "image/avif":
+		return FormatAVIF
+	case "image/heic", "image/heif":
+		return FormatHEIF
+	case "image/x-icon", "image/vnd.microsoft.icon":
+		return FormatICO
+	case "application/pdf":
+		return FormatPDF
+	case "image/jxl":
+		return FormatJXL
+	case "image/svg+xml":
+		return FormatSVG
+	case "image/x-dcraw", "image/x-adobe-dng", "image/x-canon-cr2", "image/x-nikon-nef":
+		return FormatRAW
 	}
 	return FormatUnknown
 }
@@ -0,0 +1,173 @@
+package core
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Job queues ────────────────────────────────────────────────────────────────
+
+// MemoryQueue is the default JobQueue: one buffered channel per Priority,
+// drained highest priority first, holding at most size jobs in all.  Jobs
+// live only as long as the process; use a persistent queue from
+// adapters/queue to survive restarts.
+type MemoryQueue struct {
+	queues [3]chan Job
+	slots  chan struct{} // one token per queued job, bounding the total
+}
+
+// NewMemoryQueue returns a MemoryQueue holding up to size jobs across all
+// priorities.
+func NewMemoryQueue(size int) *MemoryQueue {
+	if size <= 0 {
+		size = 256
+	}
+	q := &MemoryQueue{slots: make(chan struct{}, size)}
+	for i := range q.queues {
+		q.queues[i] = make(chan Job, size)
+	}
+	return q
+}
+
+// Enqueue adds job to the queue for its priority, failing with
+// ErrWorkerPoolFull when the queue is full.
+func (q *MemoryQueue) Enqueue(_ context.Context, job Job) error {
+	i, err := priorityIndex(job.Priority)
+	if err != nil {
+		return err
+	}
+	select {
+	case q.slots <- struct{}{}:
+		q.queues[i] <- job
+		return nil
+	default:
+		return apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrWorkerPoolFull)
+	}
+}
+
+// EnqueueWait implements BlockingQueue: it waits until the queue has room
+// or ctx is done.
+func (q *MemoryQueue) EnqueueWait(ctx context.Context, job Job) error {
+	i, err := priorityIndex(job.Priority)
+	if err != nil {
+		return err
+	}
+	select {
+	case q.slots <- struct{}{}:
+		q.queues[i] <- job
+		return nil
+	case <-ctx.Done():
+		return apperrors.Wrap(apperrors.CategoryPipeline, "submit", ctx.Err())
+	}
+}
+
+// EnqueueEvict implements EvictingQueue: when the queue is full it drops
+// the oldest job of job's priority, or failing that of the lowest priority
+// below it, and job takes its place.  A job never displaces one of higher
+// priority; with only those queued it fails with ErrWorkerPoolFull.
+func (q *MemoryQueue) EnqueueEvict(_ context.Context, job Job) ([]Job, error) {
+	i, err := priorityIndex(job.Priority)
+	if err != nil {
+		return nil, err
+	}
+	for {
+		select {
+		case q.slots <- struct{}{}:
+			q.queues[i] <- job
+			return nil, nil
+		default:
+		}
+		order := []int{i}
+		for k := len(q.queues) - 1; k > i; k-- {
+			order = append(order, k)
+		}
+		for _, k := range order {
+			select {
+			case old := <-q.queues[k]:
+				// job inherits the dropped job's slot.
+				q.queues[i] <- job
+				return []Job{old}, nil
+			default:
+			}
+		}
+		if q.Len() >= cap(q.slots) {
+			return nil, apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrWorkerPoolFull)
+		}
+		// A slot is on its way back from Dequeue.
+		runtime.Gosched()
+	}
+}
+
+// Dequeue waits for the oldest job of the highest priority queued.
+func (q *MemoryQueue) Dequeue(ctx context.Context) (Job, error) {
+	if err := ctx.Err(); err != nil {
+		return Job{}, err
+	}
+	// Take from the first non-empty queue in priority order …
+	for _, c := range q.queues {
+		select {
+		case job := <-c:
+			<-q.slots
+			return job, nil
+		default:
+		}
+	}
+	// … or else wait for whichever receives a job first.
+	var job Job
+	select {
+	case <-ctx.Done():
+		return Job{}, ctx.Err()
+	case job = <-q.queues[0]:
+	case job = <-q.queues[1]:
+	case job = <-q.queues[2]:
+	}
+	<-q.slots
+	return job, nil
+}
+
+// Ack does nothing: a dequeued job has already left the queue.
+func (q *MemoryQueue) Ack(context.Context, Job) error { return nil }
+
+// Nack puts job back at the end of its queue.
+func (q *MemoryQueue) Nack(ctx context.Context, job Job) error { return q.Enqueue(ctx, job) }
+
+// drain empties the queue and returns the jobs it held.
+func (q *MemoryQueue) drain() []Job {
+	var jobs []Job
+	for _, c := range q.queues {
+		for len(c) > 0 {
+			select {
+			case job := <-c:
+				<-q.slots
+				jobs = append(jobs, job)
+			default:
+			}
+		}
+	}
+	return jobs
+}
+
+// Len returns the number of jobs waiting.
+func (q *MemoryQueue) Len() int {
+	n := 0
+	for _, c := range q.queues {
+		n += len(c)
+	}
+	return n
+}
+
+// priorityIndex ranks pr from 0 (high) to 2 (low).
+func priorityIndex(pr Priority) (int, error) {
+	switch pr {
+	case PriorityHigh:
+		return 0, nil
+	case PriorityNormal, "":
+		return 1, nil
+	case PriorityLow:
+		return 2, nil
+	}
+	return 0, apperrors.New(apperrors.CategoryConfig, "submit", fmt.Errorf("unknown job priority %q", pr))
+}
@@ -1,46 +1,244 @@
 package core
 
-import "sync"
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
 
 // ── Registry ──────────────────────────────────────────────────────────────────
 
-// DefaultRegistry is a thread-safe implementation of Registry.
+// DefaultRegistry is a thread-safe implementation of Registry.  Each
+// format maps to a list of codecs ordered by priority, highest first, and
+// among equal priorities by registration, newest first: registering a
+// codec puts it ahead of those already registered at its priority, which
+// become its fallbacks.
 type DefaultRegistry struct {
 	mu       sync.RWMutex
-	decoders map[Format]Decoder
-	encoders map[Format]Encoder
+	decoders map[Format][]prioritized[Decoder]
+	encoders map[Format][]prioritized[Encoder]
+
+	decoderOrder []Format // registration order; defines fallback priority
+}
+
+type prioritized[T comparable] struct {
+	codec    T
+	priority int
 }
 
 // NewRegistry returns an empty DefaultRegistry.
 func NewRegistry() *DefaultRegistry {
 	return &DefaultRegistry{
-		decoders: make(map[Format]Decoder),
-		encoders: make(map[Format]Encoder),
+		decoders: make(map[Format][]prioritized[Decoder]),
+		encoders: make(map[Format][]prioritized[Encoder]),
 	}
 }
 
-func (r *DefaultRegistry) RegisterDecoder(f Format, d Decoder) {
+// RegisterDecoder adds d for f at priority 0.
+func (r *DefaultRegistry) RegisterDecoder(f Format, d Decoder) { r.AddDecoder(f, d, 0) }
+
+// RegisterEncoder adds e for f at priority 0.
+func (r *DefaultRegistry) RegisterEncoder(f Format, e Encoder) { r.AddEncoder(f, e, 0) }
+
+// AddDecoder adds d for f at the given priority, moving it if it was
+// registered for f before.
+func (r *DefaultRegistry) AddDecoder(f Format, d Decoder, priority int) {
 	r.mu.Lock()
-	r.decoders[f] = d
+	if _, exists := r.decoders[f]; !exists {
+		r.decoderOrder = append(r.decoderOrder, f)
+	}
+	r.decoders[f] = insertCodec(r.decoders[f], d, priority)
 	r.mu.Unlock()
 }
 
-func (r *DefaultRegistry) RegisterEncoder(f Format, e Encoder) {
+// AddEncoder adds e for f at the given priority, moving it if it was
+// registered for f before.
+func (r *DefaultRegistry) AddEncoder(f Format, e Encoder, priority int) {
 	r.mu.Lock()
-	r.encoders[f] = e
+	r.encoders[f] = insertCodec(r.encoders[f], e, priority)
 	r.mu.Unlock()
 }
 
+// insertCodec returns list with c placed ahead of the entries of priority
+// at most p, after removing any earlier entry for c.
+func insertCodec[T comparable](list []prioritized[T], c T, p int) []prioritized[T] {
+	out := make([]prioritized[T], 0, len(list)+1)
+	for _, e := range list {
+		if e.codec != c {
+			out = append(out, e)
+		}
+	}
+	i := 0
+	for i < len(out) && out[i].priority > p {
+		i++
+	}
+	return slices.Insert(out, i, prioritized[T]{codec: c, priority: p})
+}
+
 func (r *DefaultRegistry) DecoderFor(f Format) (Decoder, bool) {
 	r.mu.RLock()
-	d, ok := r.decoders[f]
-	r.mu.RUnlock()
-	return d, ok
+	defer r.mu.RUnlock()
+	if list := r.decoders[f]; len(list) > 0 {
+		return list[0].codec, true
+	}
+	return nil, false
 }
 
 func (r *DefaultRegistry) EncoderFor(f Format) (Encoder, bool) {
 	r.mu.RLock()
-	e, ok := r.encoders[f]
-	r.mu.RUnlock()
-	return e, ok
-}
\ No newline at end of file
+	defer r.mu.RUnlock()
+	if list := r.encoders[f]; len(list) > 0 {
+		return list[0].codec, true
+	}
+	return nil, false
+}
+
+// DecoderChain implements DecoderChainer.
+func (r *DefaultRegistry) DecoderChain(f Format) []Decoder {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var chain []Decoder
+	add := func(d Decoder) {
+		if !slices.Contains(chain, d) {
+			chain = append(chain, d)
+		}
+	}
+	for _, e := range r.decoders[f] {
+		add(e.codec)
+	}
+	for _, rf := range r.decoderOrder {
+		for _, e := range r.decoders[rf] {
+			if e.codec.CanDecode(f) {
+				add(e.codec)
+			}
+		}
+	}
+	return chain
+}
+
+// EncoderChain implements EncoderChainer.
+func (r *DefaultRegistry) EncoderChain(f Format) []Encoder {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	chain := make([]Encoder, len(r.encoders[f]))
+	for i, e := range r.encoders[f] {
+		chain[i] = e.codec
+	}
+	return chain
+}
+
+// SupportedFormats implements FormatLister.
+func (r *DefaultRegistry) SupportedFormats() []Format {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var formats []Format
+	for f := range r.decoders {
+		formats = append(formats, f)
+	}
+	for f := range r.encoders {
+		if _, ok := r.decoders[f]; !ok {
+			formats = append(formats, f)
+		}
+	}
+	slices.Sort(formats)
+	return formats
+}
+
+// Capabilities implements FormatLister.
+func (r *DefaultRegistry) Capabilities() Capabilities {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	var c Capabilities
+	for f := range r.decoders {
+		c.Decode = append(c.Decode, f)
+	}
+	for f := range r.encoders {
+		c.Encode = append(c.Encode, f)
+	}
+	slices.Sort(c.Decode)
+	slices.Sort(c.Encode)
+	for _, f := range c.Decode {
+		for _, e := range r.decoders[f] {
+			cc := CapabilitiesOf(e.codec, f)
+			cc.Decode = true
+			c.Codecs = append(c.Codecs, cc)
+		}
+	}
+	for _, f := range c.Encode {
+		for _, e := range r.encoders[f] {
+			cc := CapabilitiesOf(e.codec, f)
+			cc.Encode = true
+			c.Codecs = append(c.Codecs, cc)
+		}
+	}
+	return c
+}
+
+// ── Codec names ───────────────────────────────────────────────────────────────
+
+// NamedCodec is optionally implemented by a Decoder or Encoder to name
+// itself in CodecUsage; wrappers return the name of the codec they wrap.
+type NamedCodec interface {
+	CodecName() string
+}
+
+// CodecName returns c's CodecName, or its Go type such as "*decoder.JPEG".
+func CodecName(c any) string {
+	if n, ok := c.(NamedCodec); ok {
+		return n.CodecName()
+	}
+	return fmt.Sprintf("%T", c)
+}
+
+// ── Step factories ────────────────────────────────────────────────────────────
+
+// StepFactory builds a step from named parameters, as read from a pipeline
+// definition, an API request or command-line flags.  JSON numbers arrive as
+// float64 and YAML integers as int; factories should accept both.
+type StepFactory func(params map[string]any) (Step, error)
+
+var (
+	stepMu        sync.RWMutex
+	stepFactories = map[string]StepFactory{}
+)
+
+// RegisterStep makes f available under name, replacing any factory
+// registered under that name before.  The pipeline package registers its
+// built-in steps under their Name(); packages contributing their own steps
+// usually call RegisterStep from init.
+func RegisterStep(name string, f StepFactory) {
+	stepMu.Lock()
+	stepFactories[name] = f
+	stepMu.Unlock()
+}
+
+// LookupStep returns the factory registered under name.
+func LookupStep(name string) (StepFactory, bool) {
+	stepMu.RLock()
+	f, ok := stepFactories[name]
+	stepMu.RUnlock()
+	return f, ok
+}
+
+// StepNames returns the names of all registered step factories, sorted.
+func StepNames() []string {
+	stepMu.RLock()
+	names := make([]string, 0, len(stepFactories))
+	for name := range stepFactories {
+		names = append(names, name)
+	}
+	stepMu.RUnlock()
+	sort.Strings(names)
+	return names
+}
+
+// NewStep builds the step registered under name from params.
+func NewStep(name string, params map[string]any) (Step, error) {
+	f, ok := LookupStep(name)
+	if !ok {
+		return nil, fmt.Errorf("unknown step type %q", name)
+	}
+	return f(params)
+}
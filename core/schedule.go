@@ -0,0 +1,241 @@
+package core
+
+import (
+	"container/heap"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Scheduled jobs ────────────────────────────────────────────────────────────
+
+// SubmitAt holds job until at and then submits it, so work such as a
+// re-encoding campaign can be scheduled off-peak without an external
+// scheduler.  A time in the past submits the job at once.  Scheduled jobs
+// with an ID report JobScheduled until they fall due and can be canceled
+// with CancelJob in the meantime.  Should the queue refuse the job when it
+// falls due, the error goes to its ResultCh.  Scheduled jobs are held in
+// memory, even when a persistent JobQueue is installed; Stop abandons
+// those not yet due, and those falling due once it has begun.
+func (p *Processor) SubmitAt(job Job, at time.Time) error {
+	if p.stopping.Load() {
+		return apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrProcessorStopped)
+	}
+	if _, err := priorityIndex(job.Priority); err != nil {
+		return err
+	}
+	if job.ID != "" {
+		var err error
+		if job, err = p.jobs.add(job); err != nil {
+			return err
+		}
+		p.jobs.update(job.ID, func(s *JobStatus) { s.State, s.Scheduled = JobScheduled, at })
+	}
+	if !p.sched.push(job, at) {
+		if job.ID != "" {
+			p.jobs.remove(job.ID)
+		}
+		return apperrors.New(apperrors.CategoryPipeline, "submit", apperrors.ErrProcessorStopped)
+	}
+	p.sched.once.Do(func() { go p.runSchedule() })
+	return nil
+}
+
+// SubmitAfter submits job once delay has passed; see SubmitAt.
+func (p *Processor) SubmitAfter(job Job, delay time.Duration) error {
+	return p.SubmitAt(job, time.Now().Add(delay))
+}
+
+// runSchedule submits scheduled jobs as they fall due, sleeping on a single
+// timer set for the earliest, until the scheduler is closed.
+func (p *Processor) runSchedule() {
+	wake, quit, done := p.sched.signals()
+	defer close(done)
+	timer := time.NewTimer(time.Hour)
+	defer timer.Stop()
+	for {
+		due, next, ok := p.sched.due(time.Now())
+		for _, job := range due {
+			p.release(job)
+		}
+		var wait <-chan time.Time
+		if ok {
+			timer.Reset(time.Until(next))
+			wait = timer.C
+		}
+		select {
+		case <-quit:
+			return
+		case <-wake:
+		case <-wait:
+		}
+		timer.Stop()
+	}
+}
+
+// release moves a job that fell due onto the queue, through the same
+// guard as Submit.  A job Stop has refused is handed back to it.
+func (p *Processor) release(job Job) {
+	p.submitMu.RLock()
+	defer p.submitMu.RUnlock()
+	if p.stopping.Load() {
+		p.sched.refused(job)
+		return
+	}
+	if job.ID != "" {
+		p.jobs.update(job.ID, func(s *JobStatus) {
+			if s.State == JobScheduled {
+				s.State = JobQueued
+			}
+		})
+	}
+	ctx := job.Ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	if err := p.offer(ctx, job, p.cfg.QueueFull); err != nil {
+		if errors.Is(err, apperrors.ErrProcessorStopped) {
+			p.sched.refused(job)
+			return
+		}
+		p.complete(job, nil, err)
+	}
+}
+
+// scheduler holds jobs submitted with SubmitAt until they fall due.
+type scheduler struct {
+	mu     sync.Mutex
+	jobs   jobHeap
+	seq    uint64
+	closed bool
+	wake   chan struct{}
+	quit   chan struct{} // closed by close
+	done   chan struct{} // closed when runSchedule returns
+	late   []Job         // fell due but refused by Stop
+	once   sync.Once
+}
+
+type scheduledJob struct {
+	at  time.Time
+	seq uint64 // keeps jobs due at the same time in submission order
+	job Job
+}
+
+// push schedules job, reporting false once the scheduler is closed.
+func (s *scheduler) push(job Job, at time.Time) bool {
+	s.mu.Lock()
+	if s.closed {
+		s.mu.Unlock()
+		return false
+	}
+	if s.wake == nil {
+		s.wake = make(chan struct{}, 1)
+		s.quit = make(chan struct{})
+		s.done = make(chan struct{})
+	}
+	s.seq++
+	heap.Push(&s.jobs, &scheduledJob{at: at, seq: s.seq, job: job})
+	s.mu.Unlock()
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+	return true
+}
+
+// signals returns the channels runSchedule waits on and closes.
+func (s *scheduler) signals() (wake, quit, done chan struct{}) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.wake, s.quit, s.done
+}
+
+// close refuses further jobs, stops runSchedule and returns the jobs still
+// scheduled.
+func (s *scheduler) close() []Job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if !s.closed && s.quit != nil {
+		close(s.quit)
+	}
+	s.closed = true
+	jobs := make([]Job, 0, len(s.jobs))
+	for len(s.jobs) > 0 {
+		jobs = append(jobs, heap.Pop(&s.jobs).(*scheduledJob).job)
+	}
+	return jobs
+}
+
+// wait blocks until runSchedule, if it was started, has returned, and
+// returns the jobs it handed back because Stop refused them.
+func (s *scheduler) wait() []Job {
+	_, _, done := s.signals()
+	if done != nil {
+		<-done
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	late := s.late
+	s.late = nil
+	return late
+}
+
+// refused keeps a job that fell due as the processor stopped, for wait.
+func (s *scheduler) refused(job Job) {
+	s.mu.Lock()
+	s.late = append(s.late, job)
+	s.mu.Unlock()
+}
+
+// due pops the jobs due by now and reports when the next one is.
+func (s *scheduler) due(now time.Time) (jobs []Job, next time.Time, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.jobs) > 0 && !s.jobs[0].at.After(now) {
+		jobs = append(jobs, heap.Pop(&s.jobs).(*scheduledJob).job)
+	}
+	if len(s.jobs) > 0 {
+		return jobs, s.jobs[0].at, true
+	}
+	return jobs, time.Time{}, false
+}
+
+// remove takes the job scheduled under id off the schedule.
+func (s *scheduler) remove(id string) (Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i, sj := range s.jobs {
+		if sj.job.ID == id {
+			heap.Remove(&s.jobs, i)
+			return sj.job, true
+		}
+	}
+	return Job{}, false
+}
+
+// jobHeap orders scheduled jobs by due time; it implements heap.Interface.
+type jobHeap []*scheduledJob
+
+func (h jobHeap) Len() int { return len(h) }
+func (h jobHeap) Less(i, j int) bool {
+	if h[i].at.Equal(h[j].at) {
+		return h[i].seq < h[j].seq
+	}
+	return h[i].at.Before(h[j].at)
+}
+func (h jobHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+func (h *jobHeap) Push(x any)   { *h = append(*h, x.(*scheduledJob)) }
+func (h *jobHeap) Pop() any {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
+
+// canceledJob reports a job canceled before it reached a worker.
+func (p *Processor) canceledJob(job Job) {
+	p.complete(job, nil, apperrors.Wrap(apperrors.CategoryPipeline, "job", context.Canceled))
+}
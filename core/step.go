@@ -0,0 +1,58 @@
+package core
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime/debug"
+	"time"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// TimeoutHinter is optionally implemented by steps whose time limit should
+// differ from config.StepTimeout, such as a slow AVIF encode or a remote
+// classifier call.
+type TimeoutHinter interface {
+	// StepTimeout returns the step's time limit: 0 keeps the default and
+	// a negative value removes the limit.
+	StepTimeout() time.Duration
+}
+
+// ExecuteStep runs step.Execute with a time limit: the step's own when it
+// implements TimeoutHinter, def otherwise (0 = none).  When the limit
+// expires the step's context is canceled, and a step that returns because
+// of it fails with ErrStepTimeout naming the step, rather than leaving the
+// rest of the job's time to a single pathological step.  A panic in the
+// step is recovered and returned as ErrStepPanicked with the step name and
+// stack trace, so one faulty step cannot bring the process down.
+func ExecuteStep(ctx context.Context, step Step, img *ImageData, def time.Duration) (*ImageData, error) {
+	limit := def
+	if h, ok := step.(TimeoutHinter); ok {
+		if d := h.StepTimeout(); d != 0 {
+			limit = d
+		}
+	}
+	if limit <= 0 {
+		return safeExecute(ctx, step, img)
+	}
+	stepCtx, cancel := context.WithTimeout(ctx, limit)
+	defer cancel()
+	out, err := safeExecute(stepCtx, step, img)
+	if err != nil && ctx.Err() == nil && errors.Is(stepCtx.Err(), context.DeadlineExceeded) {
+		return nil, apperrors.New(apperrors.CategoryPipeline, step.Name(),
+			fmt.Errorf("%w: %s did not finish within %s", apperrors.ErrStepTimeout, step.Name(), limit))
+	}
+	return out, err
+}
+
+// safeExecute runs step, turning a panic into an error.
+func safeExecute(ctx context.Context, step Step, img *ImageData) (out *ImageData, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			out, err = nil, apperrors.New(apperrors.CategoryPipeline, step.Name(),
+				fmt.Errorf("%w: %s: %v\n%s", apperrors.ErrStepPanicked, step.Name(), r, debug.Stack()))
+		}
+	}()
+	return step.Execute(ctx, img)
+}
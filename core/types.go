@@ -2,18 +2,108 @@ package core
 
 import (
 	"context"
+	"image"
+	"image/color"
 	"io"
+	"slices"
 	"time"
+
+	"github.com/Skryldev/image-processor/config"
 )
 
-// Format identifies an image codec.
+// Format identifies an image codec, or a video container for outputs of
+// conversion steps such as the ffmpeg adapter's (see IsVideo).
 type Format string
 
 const (
 	FormatJPEG    Format = "jpeg"
 	FormatPNG     Format = "png"
 	FormatWebP    Format = "webp"
+	FormatGIF     Format = "gif"
+	FormatTIFF    Format = "tiff"
+	FormatBMP     Format = "bmp"
+	FormatAVIF    Format = "avif"
+	FormatHEIF    Format = "heif" // HEIF / HEIC
+	FormatICO     Format = "ico"
+	FormatPDF     Format = "pdf" // decode only, one page at a time
+	FormatJXL     Format = "jxl" // JPEG XL; libvips backend only
+	FormatSVG     Format = "svg" // source only; see pipeline.SVGRasterizeStep
+	FormatRAW     Format = "raw" // camera RAW (DNG, CR2, NEF); decode only, libvips backend opt-in
 	FormatUnknown Format = "unknown"
+
+	// Video containers.  ImageData in these formats carries encoded Data
+	// only; there are no pixels to decode or re-encode.
+	FormatMP4  Format = "mp4"
+	FormatWebM Format = "webm"
+)
+
+// IsVideo reports whether f is a video container rather than an image codec.
+func (f Format) IsVideo() bool { return f == FormatMP4 || f == FormatWebM }
+
+// ContentType returns the MIME type for f.
+func (f Format) ContentType() string {
+	switch f {
+	case FormatICO:
+		return "image/x-icon"
+	case FormatMP4, FormatWebM:
+		return "video/" + string(f)
+	case FormatPDF:
+		return "application/pdf"
+	case FormatSVG:
+		return "image/svg+xml"
+	case FormatRAW:
+		return "image/x-dcraw"
+	case FormatUnknown, "":
+		return "application/octet-stream"
+	}
+	return "image/" + string(f)
+}
+
+// Ext returns the conventional file extension for f, without the dot.
+func (f Format) Ext() string {
+	switch f {
+	case FormatJPEG:
+		return "jpg"
+	case FormatTIFF:
+		return "tif"
+	case FormatUnknown, "":
+		return "bin"
+	}
+	return string(f)
+}
+
+// SupportsAlpha reports whether f can store transparency.
+func (f Format) SupportsAlpha() bool {
+	switch f {
+	case FormatPNG, FormatWebP, FormatGIF, FormatTIFF, FormatAVIF, FormatHEIF, FormatICO, FormatJXL:
+		return true
+	}
+	return false
+}
+
+// MaxDimensions returns the largest width and height f can store, or 0
+// where the format sets no practical limit.
+func (f Format) MaxDimensions() (width, height int) {
+	switch f {
+	case FormatJPEG, FormatGIF:
+		return 65535, 65535
+	case FormatWebP:
+		return 16383, 16383
+	case FormatICO:
+		return 256, 256
+	}
+	return 0, 0
+}
+
+// Kernel selects the resampling filter used by resize steps.  Each backend
+// maps it to its closest native implementation.
+type Kernel string
+
+const (
+	KernelNearest    Kernel = "nearest"
+	KernelBilinear   Kernel = "bilinear"
+	KernelCatmullRom Kernel = "catmull-rom"
+	KernelLanczos    Kernel = "lanczos"
 )
 
 // ColorSpace represents the image colour model.
@@ -36,7 +126,110 @@ type Metadata struct {
 	SizeBytes   int64
 	EXIF        map[string]string // nil when stripped or absent
 	HasEXIF     bool
-	Orientation int // EXIF orientation tag (1-8)
+	Details     *Details           // parsed EXIF, XMP and IPTC; nil when stripped or absent
+	ICCProfile  []byte             // embedded ICC colour profile; nil when stripped or absent
+	Orientation int                // EXIF orientation tag (1-8)
+	Scores      map[string]float64 // classifier label → score (0-1); nil when unclassified
+	Contrast    *ContrastMetrics   // set by the contrast analysis step
+	Pages       int                // page count of multi-page sources (TIFF, PDF); 0 when single-page
+	Frames      int                // frame count of animated sources; 0 for stills
+	Candidates  []EncodeCandidate  // encodings tried by a format-picking encode step
+}
+
+// Details is the structured metadata the metadata package reads from an
+// image file's EXIF, XMP and IPTC blocks.  Zero fields were absent.
+type Details struct {
+	Orientation int // 1-8
+	Make        string
+	Model       string
+	LensModel   string
+	Software    string
+	Artist      string
+	Copyright   string
+	// DateTime is the file's modification time, DateTimeOriginal when the
+	// picture was taken and DateTimeDigitized when it was stored.  EXIF
+	// times carry no zone unless the matching OffsetTime tag is set; they
+	// are then read as UTC.
+	DateTime          time.Time
+	DateTimeOriginal  time.Time
+	DateTimeDigitized time.Time
+	ExposureTime      float64 // seconds
+	FNumber           float64
+	ISO               int
+	FocalLength       float64 // millimetres
+	GPS               *GPS
+	// XMP is the raw XMP packet.
+	XMP  string
+	IPTC *IPTC
+}
+
+// GPS is the location recorded in EXIF.
+type GPS struct {
+	Latitude  float64 // degrees, negative south
+	Longitude float64 // degrees, negative west
+	Altitude  float64 // metres, negative below sea level
+	Time      time.Time
+}
+
+// IPTC holds the IPTC-IIM fields editors and agencies fill in.
+type IPTC struct {
+	Headline  string
+	Caption   string
+	Keywords  []string
+	Byline    string
+	Credit    string
+	Copyright string
+	City      string
+	Country   string
+}
+
+// Clone returns a deep copy of d.
+func (d *Details) Clone() *Details {
+	if d == nil {
+		return nil
+	}
+	c := *d
+	if d.GPS != nil {
+		g := *d.GPS
+		c.GPS = &g
+	}
+	if d.IPTC != nil {
+		i := *d.IPTC
+		i.Keywords = slices.Clone(d.IPTC.Keywords)
+		c.IPTC = &i
+	}
+	return &c
+}
+
+// EncodeCandidate is one encoding tried by a step that picks among output
+// formats or qualities, such as pipeline.BestFormatEncodeStep and
+// pipeline.PerceptualCompressStep.
+type EncodeCandidate struct {
+	Format   Format
+	Quality  int           // encode quality, when the step chose it
+	Size     int64         // encoded bytes; 0 when encoding failed
+	SSIM     float64       // against the unencoded image; 0 when not measured
+	Duration time.Duration // spent encoding and measuring
+	// Rejected says why the candidate was not kept; empty for the one
+	// that was.
+	Rejected string
+}
+
+// ContrastMetrics summarises an image's legibility in WCAG 2 terms.  Ratios
+// are (L1+0.05)/(L2+0.05) over relative luminance, from 1 to 21.
+type ContrastMetrics struct {
+	// Ratio compares the 95th and 5th percentile luminance.
+	Ratio float64
+	// DominantRatio compares the mean colours of the light and dark halves
+	// of the image — roughly text against background for text-heavy images.
+	DominantRatio float64
+	// RMS is the standard deviation of relative luminance (0-0.5).
+	RMS float64
+
+	// WCAG 2 thresholds applied to DominantRatio.
+	PassesAA      bool // ≥ 4.5:1, normal text
+	PassesAALarge bool // ≥ 3:1, large text and UI components
+	PassesAAA     bool // ≥ 7:1
 }
 
 // ImageData is the in-memory representation passed through a pipeline.
@@ -47,15 +240,56 @@ type ImageData struct {
 	Format Format
 
 	// Decoded pixel buffer — populated lazily by decode steps only when needed.
-	// Using image.Image keeps us CGO-free; libvips adapters can use unsafe pointers
-	// wrapped in their own types and satisfy the Processor interface directly.
-	Image interface{} // actual type: image.Image or vips.Image depending on backend
+	// Using image.Image keeps us CGO-free; libvips adapters wrap their own
+	// buffers in types implementing DecodedImage and StdImageConverter.
+	Image DecodedImage // image.Image, *Animation or a backend type such as *vips.VipsImage
 
 	// Metadata extracted during decode.
 	Meta Metadata
 
 	// Size of the original raw input for adaptive compression decisions.
 	OriginalSize int64
+
+	// Directives left by earlier steps for later ones; see Attributes.
+	Attrs Attributes
+
+	// Side outputs forked off mid-pipeline by tee steps, keyed by branch
+	// name.  The Processor moves them into ProcessingResult.Variants.
+	Branches map[string]*ImageData
+
+	// Codecs that decoded and encoded the image.
+	Codecs CodecUsage
+}
+
+// CodecUsage names, as CodecName does, the codecs that decoded and encoded
+// an image, showing which fallback in a Registry chain did the work.  A
+// field is empty when that stage has not run.
+type CodecUsage struct {
+	Decoder string
+	Encoder string
+}
+
+// Animation is a decoded multi-frame image stored in ImageData.Image.  It
+// satisfies image.Image by presenting its first frame, so steps that are
+// not animation-aware still work and leave a still image behind; wrap
+// steps in pipeline.EachFrameStep to apply them to every frame instead.
+// Frames are fully composited and share the canvas size.
+type Animation struct {
+	Frames    []image.Image
+	Delays    []time.Duration // per frame; may be shorter than Frames
+	LoopCount int             // 0 loops forever, n > 0 plays n times
+}
+
+func (a *Animation) ColorModel() color.Model { return a.Frames[0].ColorModel() }
+func (a *Animation) Bounds() image.Rectangle { return a.Frames[0].Bounds() }
+func (a *Animation) At(x, y int) color.Color { return a.Frames[0].At(x, y) }
+
+// Delay returns how long frame i is shown.
+func (a *Animation) Delay(i int) time.Duration {
+	if i < len(a.Delays) {
+		return a.Delays[i]
+	}
+	return 0
 }
 
 // ProcessingResult is returned to the caller after the full pipeline completes.
@@ -67,6 +301,7 @@ type ProcessingResult struct {
 	ProcessingTime time.Duration
 	StepTimings    map[string]time.Duration
 	MemoryUsedB    int64
+	Codecs         CodecUsage // of Primary
 }
 
 // Source abstracts where raw bytes come from (reader, file path, URL, etc.).
@@ -84,10 +319,23 @@ type Job struct {
 	Source  Source
 	Steps   []Step
 	Options JobOptions
+	// Priority orders queued jobs; the zero value is PriorityNormal.
+	Priority Priority
 	// Result channel; nil for fire-and-forget.
 	ResultCh chan<- JobResult
 }
 
+// Priority ranks queued jobs.  Workers always take the oldest job of the
+// highest priority waiting, so interactive requests are not stuck behind
+// bulk work; lower priorities only run while higher queues are empty.
+type Priority string
+
+const (
+	PriorityHigh   Priority = "high"
+	PriorityNormal Priority = "normal"
+	PriorityLow    Priority = "low"
+)
+
 // JobOptions controls per-job behaviour.
 type JobOptions struct {
 	MaxRetries  int
@@ -99,6 +347,10 @@ type JobOptions struct {
 type VariantDefinition struct {
 	Name  string
 	Steps []Step
+	// From names another variant, or a tee branch of the base steps, whose
+	// output Steps start from instead of the base image, e.g. a thumbnail
+	// cut from the already resized "medium".  Empty = the base image.
+	From string
 }
 
 // JobResult wraps the outcome of an async job.
@@ -115,6 +367,22 @@ type Step interface {
 	Execute(ctx context.Context, img *ImageData) (*ImageData, error)
 }
 
+// RegistryBinder is implemented by steps that need a codec Registry.  The
+// Processor calls BindRegistry before executing such a step; implementations
+// return a copy bound to reg when they do not carry a registry of their own,
+// and themselves otherwise.
+type RegistryBinder interface {
+	BindRegistry(reg Registry) Step
+}
+
+// ConfigBinder is implemented by steps whose unset fields fall back to
+// Processor-wide defaults.  The Processor calls BindConfig before executing
+// such a step; implementations return a copy carrying the defaults when they
+// apply, and themselves otherwise.
+type ConfigBinder interface {
+	BindConfig(cfg config.Config) Step
+}
+
 // Hook is an optional observer invoked around pipeline steps.
 type Hook interface {
 	BeforeStep(ctx context.Context, stepName string, img *ImageData)
@@ -125,4 +393,69 @@ type Hook interface {
 type StorageKey struct {
 	Bucket string
 	Path   string
-}
\ No newline at end of file
+}
+
+// Gravity anchors a sub-rectangle (crop window, overlay) inside an image.
+type Gravity string
+
+const (
+	GravityCenter    Gravity = "center"
+	GravityNorth     Gravity = "north"
+	GravityNorthEast Gravity = "north-east"
+	GravityEast      Gravity = "east"
+	GravitySouthEast Gravity = "south-east"
+	GravitySouth     Gravity = "south"
+	GravitySouthWest Gravity = "south-west"
+	GravityWest      Gravity = "west"
+	GravityNorthWest Gravity = "north-west"
+)
+
+// Offset returns the top-left position of an innerW×innerH rectangle anchored
+// by g inside an outerW×outerH rectangle.  Unknown values behave like center.
+func (g Gravity) Offset(outerW, outerH, innerW, innerH int) (x, y int) {
+	x = (outerW - innerW) / 2
+	y = (outerH - innerH) / 2
+	switch g {
+	case GravityNorth, GravityNorthEast, GravityNorthWest:
+		y = 0
+	case GravitySouth, GravitySouthEast, GravitySouthWest:
+		y = outerH - innerH
+	}
+	switch g {
+	case GravityWest, GravityNorthWest, GravitySouthWest:
+		x = 0
+	case GravityEast, GravityNorthEast, GravitySouthEast:
+		x = outerW - innerW
+	}
+	return x, y
+}
+
+// CropStrategy chooses the window a smart crop keeps.
+type CropStrategy string
+
+const (
+	// CropCentre keeps the middle of the image.
+	CropCentre CropStrategy = "centre"
+	// CropEntropy trims the edge with the least detail until the window fits.
+	CropEntropy CropStrategy = "entropy"
+	// CropAttention centres the window on the most salient region: edges,
+	// skin tones and saturated colour.
+	CropAttention CropStrategy = "attention"
+)
+
+// BlendMode selects how a composited layer combines with the image below,
+// per the W3C Compositing and Blending separable blend modes.
+type BlendMode string
+
+const (
+	// BlendOver paints the layer on top (normal mode).
+	BlendOver BlendMode = "over"
+	// BlendMultiply darkens: white is neutral, black stays black.
+	BlendMultiply BlendMode = "multiply"
+	// BlendScreen lightens: black is neutral, white stays white.
+	BlendScreen BlendMode = "screen"
+	// BlendDarken keeps the darker of the two colours per channel.
+	BlendDarken BlendMode = "darken"
+	// BlendLighten keeps the lighter of the two colours per channel.
+	BlendLighten BlendMode = "lighten"
+)
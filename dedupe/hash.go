@@ -0,0 +1,130 @@
+// Package dedupe finds near-duplicate images.  It computes 64-bit perceptual
+// hashes that survive resizing, recompression and small edits, keeps them in
+// an Index, and provides a pipeline step that flags uploads matching entries
+// already in the index.
+package dedupe
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"math/bits"
+	"sort"
+	"strconv"
+)
+
+// Hash is a 64-bit DCT perceptual hash.  Similar images have hashes a small
+// Hamming distance apart; unrelated images differ in about half their bits.
+type Hash uint64
+
+// Distance returns the number of differing bits between h and o (0-64).
+func (h Hash) Distance(o Hash) int { return bits.OnesCount64(uint64(h ^ o)) }
+
+// String returns h as 16 hex digits.
+func (h Hash) String() string { return fmt.Sprintf("%016x", uint64(h)) }
+
+// MarshalText implements encoding.TextMarshaler, so hashes serialise as hex.
+func (h Hash) MarshalText() ([]byte, error) { return []byte(h.String()), nil }
+
+// UnmarshalText implements encoding.TextUnmarshaler.
+func (h *Hash) UnmarshalText(b []byte) error {
+	v, err := ParseHash(string(b))
+	*h = v
+	return err
+}
+
+// ParseHash parses the String form of a Hash.
+func ParseHash(s string) (Hash, error) {
+	v, err := strconv.ParseUint(s, 16, 64)
+	return Hash(v), err
+}
+
+const (
+	hashSample = 32 // side of the downsampled luma plane
+	hashLow    = 8  // side of the low-frequency block that forms the hash
+)
+
+// hashCos[x][u] = cos((2x+1)uπ/64), the unnormalised 32-point DCT-II basis.
+var hashCos = func() (c [hashSample][hashLow]float64) {
+	for x := 0; x < hashSample; x++ {
+		for u := 0; u < hashLow; u++ {
+			c[x][u] = math.Cos(float64(2*x+1) * float64(u) * math.Pi / (2 * hashSample))
+		}
+	}
+	return c
+}()
+
+// PHash computes the perceptual hash of img: the luma plane is area-averaged
+// down to 32×32, transformed with a 2-D DCT, and each of the 8×8 lowest
+// frequencies contributes one bit — set when it is above the median.
+func PHash(img image.Image) Hash {
+	lum := sampleLuma(img)
+
+	// Separable DCT, keeping only the low 8×8 block.
+	var rows [hashSample][hashLow]float64
+	for y := 0; y < hashSample; y++ {
+		for u := 0; u < hashLow; u++ {
+			s := 0.0
+			for x := 0; x < hashSample; x++ {
+				s += lum[y][x] * hashCos[x][u]
+			}
+			rows[y][u] = s
+		}
+	}
+	var coef [hashLow * hashLow]float64
+	for v := 0; v < hashLow; v++ {
+		for u := 0; u < hashLow; u++ {
+			s := 0.0
+			for y := 0; y < hashSample; y++ {
+				s += rows[y][u] * hashCos[y][v]
+			}
+			coef[v*hashLow+u] = s
+		}
+	}
+
+	// The DC term only encodes overall brightness; leave it out of the median.
+	sorted := append([]float64(nil), coef[1:]...)
+	sort.Float64s(sorted)
+	median := sorted[len(sorted)/2]
+
+	var h Hash
+	for i, c := range coef {
+		if c > median {
+			h |= 1 << uint(i)
+		}
+	}
+	return h
+}
+
+// sampleLuma area-averages img's Rec.709 luma into a 32×32 grid.
+func sampleLuma(img image.Image) (out [hashSample][hashSample]float64) {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w == 0 || h == 0 {
+		return out
+	}
+	var count [hashSample][hashSample]float64
+	for y := 0; y < h; y++ {
+		gy := y * hashSample / h
+		for x := 0; x < w; x++ {
+			gx := x * hashSample / w
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			out[gy][gx] += 0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(bl)
+			count[gy][gx]++
+		}
+	}
+	// Sources smaller than the grid leave cells empty; copy the nearest
+	// filled neighbour so upscaled thumbnails hash like their originals.
+	for gy := 0; gy < hashSample; gy++ {
+		for gx := 0; gx < hashSample; gx++ {
+			if count[gy][gx] > 0 {
+				out[gy][gx] /= count[gy][gx] * 257
+				continue
+			}
+			sy, sx := gy*h/hashSample, gx*w/hashSample
+			r, g, bl, _ := img.At(b.Min.X+sx, b.Min.Y+sy).RGBA()
+			out[gy][gx] = (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(bl)) / 257
+		}
+	}
+	return out
+}
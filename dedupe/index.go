@@ -0,0 +1,124 @@
+package dedupe
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"sort"
+	"sync"
+)
+
+// Match is an index entry found by Query.
+type Match struct {
+	ID       string `json:"id"`
+	Hash     Hash   `json:"hash"`
+	Distance int    `json:"distance"`
+}
+
+// Index stores perceptual hashes by ID.  MemoryIndex is the built-in
+// implementation; back it with a database or key-value store by
+// implementing this interface.  Implementations must be safe for concurrent
+// use.
+type Index interface {
+	// Add stores h under id, replacing any previous hash for id.
+	Add(ctx context.Context, id string, h Hash) error
+	// Remove deletes id; removing an unknown id is not an error.
+	Remove(ctx context.Context, id string) error
+	// Query returns the entries within maxDistance bits of h, closest first.
+	Query(ctx context.Context, h Hash, maxDistance int) ([]Match, error)
+}
+
+// MemoryIndex is an in-memory Index.  Query is a linear scan, which stays
+// well under a millisecond up to a few hundred thousand entries.
+type MemoryIndex struct {
+	mu      sync.RWMutex
+	entries map[string]Hash
+}
+
+// NewMemoryIndex returns an empty MemoryIndex.
+func NewMemoryIndex() *MemoryIndex {
+	return &MemoryIndex{entries: make(map[string]Hash)}
+}
+
+func (m *MemoryIndex) Add(_ context.Context, id string, h Hash) error {
+	m.mu.Lock()
+	m.entries[id] = h
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryIndex) Remove(_ context.Context, id string) error {
+	m.mu.Lock()
+	delete(m.entries, id)
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *MemoryIndex) Query(ctx context.Context, h Hash, maxDistance int) ([]Match, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	m.mu.RLock()
+	var out []Match
+	for id, e := range m.entries {
+		if d := h.Distance(e); d <= maxDistance {
+			out = append(out, Match{ID: id, Hash: e, Distance: d})
+		}
+	}
+	m.mu.RUnlock()
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].Distance != out[j].Distance {
+			return out[i].Distance < out[j].Distance
+		}
+		return out[i].ID < out[j].ID
+	})
+	return out, nil
+}
+
+// Len returns the number of entries.
+func (m *MemoryIndex) Len() int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return len(m.entries)
+}
+
+// Save writes every entry to w as JSON lines, sorted by ID, so the index can
+// be persisted and restored with Load.
+func (m *MemoryIndex) Save(w io.Writer) error {
+	m.mu.RLock()
+	ids := make([]string, 0, len(m.entries))
+	for id := range m.entries {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	enc := json.NewEncoder(w)
+	var err error
+	for _, id := range ids {
+		if err = enc.Encode(Match{ID: id, Hash: m.entries[id]}); err != nil {
+			break
+		}
+	}
+	m.mu.RUnlock()
+	return err
+}
+
+// Load adds the entries written by Save.
+func (m *MemoryIndex) Load(r io.Reader) error {
+	sc := bufio.NewScanner(r)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for sc.Scan() {
+		if len(sc.Bytes()) == 0 {
+			continue
+		}
+		var e Match
+		if err := json.Unmarshal(sc.Bytes(), &e); err != nil {
+			return err
+		}
+		m.entries[e.ID] = e.Hash
+	}
+	return sc.Err()
+}
+
+var _ Index = (*MemoryIndex)(nil)
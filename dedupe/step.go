@@ -0,0 +1,73 @@
+package dedupe
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/imagecompare"
+)
+
+// Attribute keys set by Step.
+const (
+	AttrHash    = "dedupe.hash"    // Hash
+	AttrMatches = "dedupe.matches" // []Match, empty when the image is new
+)
+
+// DefaultMaxDistance flags resized and recompressed copies while keeping
+// unrelated images apart.
+const DefaultMaxDistance = 10
+
+// Step hashes the image, looks it up in Index and records the hash and any
+// matches as attributes (AttrHash, AttrMatches) for later steps or the
+// caller.  The image itself passes through unchanged.
+type Step struct {
+	Index Index
+	// MaxDistance is the largest Hamming distance that counts as a
+	// duplicate; 0 = DefaultMaxDistance.
+	MaxDistance int
+	// Reject fails the pipeline with apperrors.ErrDuplicate on a match.
+	Reject bool
+	// ID returns the index key for a new image; when set, images without a
+	// match are added to the index.  Nil leaves the index read-only.
+	ID func(img *core.ImageData) string
+}
+
+func (s *Step) Name() string { return "dedupe" }
+
+func (s *Step) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := imagecompare.ImageOf(img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	h := PHash(src)
+
+	maxDist := s.MaxDistance
+	if maxDist <= 0 {
+		maxDist = DefaultMaxDistance
+	}
+	matches, err := s.Index.Query(ctx, h, maxDist)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if len(matches) > 0 && s.Reject {
+		return nil, apperrors.New(apperrors.CategoryInput, s.Name(),
+			fmt.Errorf("%w: %s (distance %d)", apperrors.ErrDuplicate, matches[0].ID, matches[0].Distance))
+	}
+	if len(matches) == 0 && s.ID != nil {
+		if err := s.Index.Add(ctx, s.ID(img), h); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+	}
+
+	out := *img
+	out.Attrs = img.Attrs.With(AttrHash, h).With(AttrMatches, matches)
+	return &out, nil
+}
+
+// Matches returns the matches recorded by Step on img.
+func Matches(img *core.ImageData) []Match {
+	m, _ := img.Attrs[AttrMatches].([]Match)
+	return m
+}
@@ -76,4 +76,20 @@ var (
 	ErrContextCanceled    = errors.New("context canceled")
 	ErrWorkerPoolFull     = errors.New("worker pool queue full")
 	ErrStorageUnavailable = errors.New("storage unavailable")
+	ErrNoRegistry         = errors.New("step has no codec registry bound")
+	ErrOutOfTolerance     = errors.New("image differs from reference beyond tolerance")
+	ErrDuplicate          = errors.New("near-duplicate of an existing image")
+	ErrRejected           = errors.New("rejected by classifier")
+	ErrLowContrast        = errors.New("contrast below minimum")
+	ErrNoWatermark        = errors.New("expected watermark not found")
+	ErrUnsafeContent      = errors.New("input contains unsafe content")
+	ErrUnknownPreset      = errors.New("unknown preset")
+	ErrJobNotFound        = errors.New("job not found")
+	ErrJobDropped         = errors.New("job dropped from full queue")
+	ErrProcessorStopped   = errors.New("processor stopped")
+	ErrImageTooLarge      = errors.New("image too large")
+	ErrBatchAborted       = errors.New("batch aborted after an earlier failure")
+	ErrStepTimeout        = errors.New("step timed out")
+	ErrStepPanicked       = errors.New("step panicked")
+	ErrCircuitOpen        = errors.New("circuit open")
 )
\ No newline at end of file
@@ -45,7 +45,7 @@ func main() {
 	proc.AddHook(hooks.NewMetricsHook(metrics))
 
 	proc.Start()
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 
 	raw, _ := os.ReadFile("./profile.jpg") // Read Real Source Image
 	// raw := os.Args[1] // Read Source Image From CLI
@@ -79,8 +79,8 @@ func main() {
 	fmt.Println("\n── Example 3: Strip EXIF + Auto Rotate")
 	result, err = proc.Process(ctx,
 		imageprocessor.FromReader(bytes.NewReader(raw)),
+		&vips.VipsAutoRotateStep{Backend: backend}, // before decode: lossless for JPEG
 		&pipeline.DecodeStep{Registry: reg},
-		&vips.VipsAutoRotateStep{},
 		&vips.VipsStripEXIFStep{},
 		imageprocessor.EncodeWith(reg, core.EncodeOptions{Quality: 85, StripEXIF: true}),
 	)
@@ -0,0 +1,74 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Skryldev/image-processor/animation"
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// ExtractFrames decodes every frame of an animated GIF, APNG or WebP source
+// and runs steps on the frames picked by sel, in parallel.  Frames arrive
+// already decoded as PNG-format ImageData, so steps should not include
+// Decode; end them with Encode (after ConvertFormat if PNG is not wanted) to
+// get bytes.
+//
+// Primary is the first selected frame.  Variants holds every selected frame
+// keyed by FrameName, including the primary.  Still images count as a
+// single-frame animation.
+func (p *Processor) ExtractFrames(ctx context.Context, src core.Source, sel animation.Selection, steps ...core.Step) (*core.ProcessingResult, error) {
+	start := time.Now()
+	r := src.Reader
+	if p.cfg.MaxImageBytes > 0 {
+		r = &utils.LimitedReader{R: r, Max: p.cfg.MaxImageBytes}
+	}
+	buf, err := utils.DrainReader(ctx, r, p.cfg.ChunkSize)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "frames.drain", err)
+	}
+	raw := utils.CloneBytes(buf.Bytes())
+	utils.ReleaseBuffer(buf)
+
+	frames, err := animation.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	idx, err := sel.Indices(len(frames))
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryInput, "frames.select", err)
+	}
+
+	results := make([]*core.ProcessingResult, len(idx))
+	errs := make([]error, len(idx))
+	var wg sync.WaitGroup
+	for k, i := range idx {
+		wg.Add(1)
+		go func(k int, f animation.Frame) {
+			defer wg.Done()
+			img := f.ImageData()
+			img.OriginalSize = int64(len(raw))
+			results[k], errs[k] = p.inner.ProcessImage(ctx, img, steps...)
+		}(k, frames[i])
+	}
+	wg.Wait()
+
+	out := &core.ProcessingResult{Variants: make(map[string]*core.ImageData, len(idx))}
+	for k, i := range idx {
+		if errs[k] != nil {
+			return nil, errs[k]
+		}
+		out.Variants[FrameName(i)] = results[k].Primary
+	}
+	out.Primary = results[0].Primary
+	out.StepTimings = results[0].StepTimings
+	out.ProcessingTime = time.Since(start)
+	return out, nil
+}
+
+// FrameName is the Variants key ExtractFrames uses for frame i.
+func FrameName(i int) string { return fmt.Sprintf("frame-%04d", i) }
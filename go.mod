@@ -3,8 +3,12 @@ module github.com/Skryldev/image-processor
 go 1.25.0
 
 require (
-	github.com/davidbyttow/govips/v2 v2.16.0 // indirect
-	golang.org/x/image v0.36.0 // indirect
+	github.com/boombuler/barcode v1.1.0
+	github.com/davidbyttow/govips/v2 v2.16.0
+	golang.org/x/image v0.36.0
+)
+
+require (
 	golang.org/x/net v0.25.0 // indirect
 	golang.org/x/text v0.34.0 // indirect
 )
@@ -0,0 +1,313 @@
+// Package icc reads ICC colour profiles and converts pixels from the colour
+// space a profile describes to sRGB, so that Display P3 photos, Adobe RGB
+// scans and CMYK prints keep their colours once the profile is dropped.
+//
+// RGB and gray matrix/TRC profiles, version 2 and 4, are read in full.
+// LUT-based profiles, which CMYK and some RGB profiles use, are read
+// through their perceptual AToB table (lut8, lut16 or lutAtoB).  Named
+// colour, DeviceLink and abstract profiles are not supported.
+package icc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"unicode/utf16"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Profile is a parsed ICC profile.
+type Profile struct {
+	Class       string // device class: "mntr", "scnr", "prtr", "spac", …
+	ColorSpace  string // data colour space: "RGB", "GRAY", "CMYK", …
+	PCS         string // profile connection space: "XYZ" or "Lab"
+	Description string
+
+	channels int
+	// pre maps each device channel on its own; post combines the
+	// results into PCS XYZ relative to D50.  Splitting them lets ToSRGB
+	// tabulate pre for 8- and 16-bit samples.
+	pre  []curve
+	post func(v []float64) [3]float64
+	// matrix holds the colorants of RGB matrix/TRC profiles, for IsSRGB.
+	matrix *[3][3]float64
+}
+
+var errMalformed = errors.New("malformed ICC profile")
+
+func malformed(format string, args ...any) error {
+	return apperrors.New(apperrors.CategoryDecode, "icc.parse", fmt.Errorf("%w: "+format, append([]any{errMalformed}, args...)...))
+}
+
+// Parse reads an ICC profile.
+func Parse(data []byte) (*Profile, error) {
+	if len(data) < 132 || string(data[36:40]) != "acsp" {
+		return nil, malformed("missing profile header")
+	}
+	if size := binary.BigEndian.Uint32(data); size < 132 || int64(size) > int64(len(data)) {
+		return nil, malformed("profile size %d, have %d bytes", size, len(data))
+	}
+	p := &Profile{
+		Class:      strings.TrimSpace(string(data[12:16])),
+		ColorSpace: strings.TrimSpace(string(data[16:20])),
+		PCS:        strings.TrimSpace(string(data[20:24])),
+	}
+	tags, err := tagTable(data)
+	if err != nil {
+		return nil, err
+	}
+	if d, ok := tags["desc"]; ok {
+		p.Description = description(d)
+	}
+
+	switch p.ColorSpace {
+	case "RGB":
+		p.channels = 3
+	case "GRAY":
+		p.channels = 1
+	case "CMYK":
+		p.channels = 4
+	default:
+		return nil, apperrors.New(apperrors.CategoryDecode, "icc.parse",
+			fmt.Errorf("%w: %q colour space", apperrors.ErrUnsupportedFormat, p.ColorSpace))
+	}
+	if p.PCS != "XYZ" && p.PCS != "Lab" {
+		return nil, malformed("connection space %q", p.PCS)
+	}
+
+	for _, sig := range []string{"A2B0", "A2B1", "A2B2"} {
+		if b, ok := tags[sig]; ok {
+			l, err := parseLUT(b, p.channels)
+			if err != nil {
+				return nil, err
+			}
+			p.pre = l.in
+			p.post = func(v []float64) [3]float64 { return l.pcs(l.eval(v), p.PCS) }
+			return p, nil
+		}
+	}
+
+	switch p.ColorSpace {
+	case "RGB":
+		var (
+			m [3][3]float64
+			c [3]curve
+		)
+		for i, ch := range []string{"r", "g", "b"} {
+			xyz, ok := tags[ch+"XYZ"]
+			trc, ok2 := tags[ch+"TRC"]
+			if !ok || !ok2 {
+				return nil, malformed("RGB profile without %sXYZ and %sTRC", ch, ch)
+			}
+			col, err := parseXYZ(xyz)
+			if err != nil {
+				return nil, err
+			}
+			for row := range 3 {
+				m[row][i] = col[row]
+			}
+			if c[i], _, err = parseCurve(trc); err != nil {
+				return nil, err
+			}
+		}
+		p.matrix = &m
+		p.pre = c[:]
+		p.post = func(v []float64) [3]float64 { return mul(&m, [3]float64{v[0], v[1], v[2]}) }
+	case "GRAY":
+		trc, ok := tags["kTRC"]
+		if !ok {
+			return nil, malformed("gray profile without kTRC")
+		}
+		c, _, err := parseCurve(trc)
+		if err != nil {
+			return nil, err
+		}
+		p.pre = []curve{c}
+		p.post = func(v []float64) [3]float64 { return [3]float64{v[0] * d50[0], v[0], v[0] * d50[2]} }
+	default:
+		return nil, malformed("%s profile without an AToB table", p.ColorSpace)
+	}
+	return p, nil
+}
+
+// IsSRGB reports whether p describes sRGB, within the rounding of the
+// profile's fixed-point values, so converting with it would be a no-op.
+func (p *Profile) IsSRGB() bool {
+	if p.matrix == nil {
+		return false
+	}
+	for r := range 3 {
+		for c := range 3 {
+			if math.Abs(p.matrix[r][c]-srgbColorants[r][c]) > 2e-3 {
+				return false
+			}
+		}
+	}
+	for _, c := range p.pre {
+		for _, x := range []float64{0.04, 0.2, 0.5, 0.8} {
+			if math.Abs(c(x)-srgbDecode(x)) > 2e-3 {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// tagTable maps tag signatures to their data.
+func tagTable(data []byte) (map[string][]byte, error) {
+	n := int(binary.BigEndian.Uint32(data[128:]))
+	if n > (len(data)-132)/12 {
+		return nil, malformed("%d tags overrun the profile", n)
+	}
+	tags := make(map[string][]byte, n)
+	for i := range n {
+		e := data[132+12*i:]
+		off, size := int64(binary.BigEndian.Uint32(e[4:])), int64(binary.BigEndian.Uint32(e[8:]))
+		if off+size > int64(len(data)) || size < 8 {
+			return nil, malformed("tag %q outside the profile", e[:4])
+		}
+		tags[string(e[:4])] = data[off : off+size]
+	}
+	return tags, nil
+}
+
+// description reads a v2 textDescriptionType or v4 multiLocalizedUnicode.
+func description(b []byte) string {
+	switch string(b[:4]) {
+	case "desc":
+		if len(b) < 12 {
+			return ""
+		}
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n > len(b)-12 {
+			return ""
+		}
+		s, _, _ := bytes.Cut(b[12:12+n], []byte{0})
+		return string(s)
+	case "mluc":
+		if len(b) < 28 || binary.BigEndian.Uint32(b[8:]) == 0 {
+			return ""
+		}
+		n, off := int(binary.BigEndian.Uint32(b[20:])), int(binary.BigEndian.Uint32(b[24:]))
+		if off+n > len(b) {
+			return ""
+		}
+		u := make([]uint16, n/2)
+		for i := range u {
+			u[i] = binary.BigEndian.Uint16(b[off+2*i:])
+		}
+		return string(utf16.Decode(u))
+	}
+	return ""
+}
+
+func s15Fixed16(b []byte) float64 { return float64(int32(binary.BigEndian.Uint32(b))) / 65536 }
+
+func parseXYZ(b []byte) ([3]float64, error) {
+	if len(b) < 20 || string(b[:4]) != "XYZ " {
+		return [3]float64{}, malformed("bad XYZ tag")
+	}
+	return [3]float64{s15Fixed16(b[8:]), s15Fixed16(b[12:]), s15Fixed16(b[16:])}, nil
+}
+
+// ── Curves ────────────────────────────────────────────────────────────────────
+
+// curve is a one-dimensional transfer function on [0, 1].
+type curve func(float64) float64
+
+func identity(x float64) float64 { return x }
+
+// parseCurve reads a curveType or parametricCurveType and reports the
+// bytes it spans, padding excluded.
+func parseCurve(b []byte) (curve, int, error) {
+	if len(b) < 12 {
+		return nil, 0, malformed("short curve")
+	}
+	switch string(b[:4]) {
+	case "curv":
+		n := int(binary.BigEndian.Uint32(b[8:]))
+		if n > (len(b)-12)/2 {
+			return nil, 0, malformed("curve of %d entries overruns its tag", n)
+		}
+		switch n {
+		case 0:
+			return identity, 12, nil
+		case 1:
+			g := float64(binary.BigEndian.Uint16(b[12:])) / 256
+			return func(x float64) float64 { return math.Pow(x, g) }, 14, nil
+		}
+		t := make([]float64, n)
+		for i := range t {
+			t[i] = float64(binary.BigEndian.Uint16(b[12+2*i:])) / 65535
+		}
+		return table(t), 12 + 2*n, nil
+	case "para":
+		kind := int(binary.BigEndian.Uint16(b[8:]))
+		counts := []int{1, 3, 4, 5, 7}
+		if kind >= len(counts) || len(b) < 12+4*counts[kind] {
+			return nil, 0, malformed("parametric curve type %d", kind)
+		}
+		var g [7]float64
+		for i := range counts[kind] {
+			g[i] = s15Fixed16(b[12+4*i:])
+		}
+		return parametric(kind, g), 12 + 4*counts[kind], nil
+	}
+	return nil, 0, malformed("curve type %q", b[:4])
+}
+
+// table interpolates linearly between n evenly spaced samples.
+func table(t []float64) curve {
+	return func(x float64) float64 {
+		x = clamp01(x) * float64(len(t)-1)
+		i := int(x)
+		if i >= len(t)-1 {
+			return t[len(t)-1]
+		}
+		f := x - float64(i)
+		return t[i]*(1-f) + t[i+1]*f
+	}
+}
+
+// parametric implements the five function types of parametricCurveType.
+func parametric(kind int, p [7]float64) curve {
+	g, a, b, c, d, e, f := p[0], p[1], p[2], p[3], p[4], p[5], p[6]
+	pow := func(x float64) float64 { return math.Pow(math.Max(x, 0), g) }
+	switch kind {
+	case 1:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return pow(a*x + b)
+			}
+			return 0
+		}
+	case 2:
+		return func(x float64) float64 {
+			if x >= -b/a {
+				return pow(a*x+b) + c
+			}
+			return c
+		}
+	case 3:
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(a*x + b)
+			}
+			return c * x
+		}
+	case 4:
+		return func(x float64) float64 {
+			if x >= d {
+				return pow(a*x+b) + e
+			}
+			return c*x + f
+		}
+	}
+	return pow
+}
+
+func clamp01(x float64) float64 { return math.Min(math.Max(x, 0), 1) }
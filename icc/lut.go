@@ -0,0 +1,324 @@
+package icc
+
+import "encoding/binary"
+
+// ── AToB tables ───────────────────────────────────────────────────────────────
+
+// pcsEncoding is how a table encodes the connection space in [0, 1].
+type pcsEncoding int
+
+const (
+	encLut8  pcsEncoding = iota // lut8: Lab L = v·100, a = v·255 − 128
+	encLut16                    // lut16: legacy 16-bit Lab, 0xFF00 = 100
+	encLutAB                    // lutAtoB: version 4 Lab, 0xFFFF = 100
+)
+
+// lut is a device-to-PCS table: per-channel input curves, an optional
+// multidimensional grid, then for lutAtoB the M curves and a matrix, and
+// per-channel output curves.
+type lut struct {
+	in      []curve
+	grid    *grid
+	m       []curve
+	matrix  *[12]float64
+	out     []curve
+	outputs int
+	enc     pcsEncoding
+}
+
+// grid is a colour lookup table sampled on points^inputs nodes, the first
+// input varying slowest, with outputs values per node.
+type grid struct {
+	points  []int
+	outputs int
+	data    []float64
+}
+
+func parseLUT(b []byte, channels int) (*lut, error) {
+	if len(b) < 12 {
+		return nil, malformed("short AToB tag")
+	}
+	in, out := int(b[8]), int(b[9])
+	if in != channels || out != 3 {
+		return nil, malformed("AToB table maps %d to %d channels", in, out)
+	}
+	switch string(b[:4]) {
+	case "mft1":
+		return parseLut8(b, in, out)
+	case "mft2":
+		return parseLut16(b, in, out)
+	case "mAB ":
+		return parseLutAtoB(b, in, out)
+	}
+	return nil, malformed("AToB type %q", b[:4])
+}
+
+// lut8Curves reads n 256-entry byte tables.
+func lut8Curves(b []byte, n int) []curve {
+	cs := make([]curve, n)
+	for i := range cs {
+		t := make([]float64, 256)
+		for j := range t {
+			t[j] = float64(b[256*i+j]) / 255
+		}
+		cs[i] = table(t)
+	}
+	return cs
+}
+
+// lut16Curves reads n tables of entries 16-bit values.
+func lut16Curves(b []byte, n, entries int) []curve {
+	cs := make([]curve, n)
+	for i := range cs {
+		t := make([]float64, entries)
+		for j := range t {
+			t[j] = float64(binary.BigEndian.Uint16(b[2*(entries*i+j):])) / 65535
+		}
+		cs[i] = table(t)
+	}
+	return cs
+}
+
+// gridSize returns points^in, or -1 when it exceeds limit.
+func gridSize(points []int, limit int) int {
+	n := 1
+	for _, p := range points {
+		if p < 2 || n > limit/p {
+			return -1
+		}
+		n *= p
+	}
+	return n
+}
+
+func parseLut8(b []byte, in, out int) (*lut, error) {
+	g := int(b[10])
+	points := make([]int, in)
+	for i := range points {
+		points[i] = g
+	}
+	nodes := gridSize(points, len(b))
+	const start = 48
+	if nodes < 0 || start+256*in+nodes*out+256*out > len(b) {
+		return nil, malformed("lut8 overruns its tag")
+	}
+	l := &lut{in: lut8Curves(b[start:], in), outputs: out, enc: encLut8}
+	pos := start + 256*in
+	gd := &grid{points: points, outputs: out, data: make([]float64, nodes*out)}
+	for i := range gd.data {
+		gd.data[i] = float64(b[pos+i]) / 255
+	}
+	l.grid = gd
+	l.out = lut8Curves(b[pos+nodes*out:], out)
+	return l, nil
+}
+
+func parseLut16(b []byte, in, out int) (*lut, error) {
+	if len(b) < 52 {
+		return nil, malformed("short lut16")
+	}
+	g := int(b[10])
+	n, m := int(binary.BigEndian.Uint16(b[48:])), int(binary.BigEndian.Uint16(b[50:]))
+	points := make([]int, in)
+	for i := range points {
+		points[i] = g
+	}
+	nodes := gridSize(points, len(b))
+	const start = 52
+	if n < 2 || m < 2 || nodes < 0 || start+2*(n*in+nodes*out+m*out) > len(b) {
+		return nil, malformed("lut16 overruns its tag")
+	}
+	l := &lut{in: lut16Curves(b[start:], in, n), outputs: out, enc: encLut16}
+	pos := start + 2*n*in
+	gd := &grid{points: points, outputs: out, data: make([]float64, nodes*out)}
+	for i := range gd.data {
+		gd.data[i] = float64(binary.BigEndian.Uint16(b[pos+2*i:])) / 65535
+	}
+	l.grid = gd
+	l.out = lut16Curves(b[pos+2*nodes*out:], out, m)
+	return l, nil
+}
+
+func parseLutAtoB(b []byte, in, out int) (*lut, error) {
+	if len(b) < 32 {
+		return nil, malformed("short lutAtoB")
+	}
+	offB, offMatrix := int(binary.BigEndian.Uint32(b[12:])), int(binary.BigEndian.Uint32(b[16:]))
+	offM, offCLUT, offA := int(binary.BigEndian.Uint32(b[20:])), int(binary.BigEndian.Uint32(b[24:])), int(binary.BigEndian.Uint32(b[28:]))
+	curves := func(off, n int) ([]curve, error) {
+		cs := make([]curve, n)
+		for i := range cs {
+			if off <= 0 || off >= len(b) {
+				return nil, malformed("lutAtoB curve outside its tag")
+			}
+			c, size, err := parseCurve(b[off:])
+			if err != nil {
+				return nil, err
+			}
+			cs[i] = c
+			off += (size + 3) &^ 3
+		}
+		return cs, nil
+	}
+
+	l := &lut{outputs: out, enc: encLutAB}
+	var err error
+	if offB == 0 {
+		return nil, malformed("lutAtoB without B curves")
+	}
+	if l.out, err = curves(offB, out); err != nil {
+		return nil, err
+	}
+	if offA != 0 {
+		if l.in, err = curves(offA, in); err != nil {
+			return nil, err
+		}
+	}
+	if offM != 0 {
+		if l.m, err = curves(offM, out); err != nil {
+			return nil, err
+		}
+	}
+	if offMatrix != 0 {
+		if offMatrix+48 > len(b) {
+			return nil, malformed("lutAtoB matrix outside its tag")
+		}
+		var mat [12]float64
+		for i := range mat {
+			mat[i] = s15Fixed16(b[offMatrix+4*i:])
+		}
+		l.matrix = &mat
+	}
+	if offCLUT != 0 {
+		if offCLUT+20 > len(b) {
+			return nil, malformed("lutAtoB grid outside its tag")
+		}
+		points := make([]int, in)
+		for i := range points {
+			points[i] = int(b[offCLUT+i])
+		}
+		prec := int(b[offCLUT+16])
+		nodes := gridSize(points, len(b))
+		pos := offCLUT + 20
+		if nodes < 0 || (prec != 1 && prec != 2) || pos+prec*nodes*out > len(b) {
+			return nil, malformed("lutAtoB grid overruns its tag")
+		}
+		gd := &grid{points: points, outputs: out, data: make([]float64, nodes*out)}
+		for i := range gd.data {
+			if prec == 1 {
+				gd.data[i] = float64(b[pos+i]) / 255
+			} else {
+				gd.data[i] = float64(binary.BigEndian.Uint16(b[pos+2*i:])) / 65535
+			}
+		}
+		l.grid = gd
+	} else if in != out {
+		return nil, malformed("lutAtoB without a grid maps %d to %d channels", in, out)
+	}
+	if l.in == nil {
+		l.in = make([]curve, in)
+		for i := range l.in {
+			l.in[i] = identity
+		}
+	}
+	return l, nil
+}
+
+// eval runs the stages after the input curves, which the caller applied,
+// returning the encoded PCS value.
+func (l *lut) eval(v []float64) [3]float64 {
+	var x [3]float64
+	if l.grid != nil {
+		x = l.grid.interpolate(v)
+	} else {
+		copy(x[:], v)
+	}
+	if l.m != nil {
+		for i, c := range l.m {
+			x[i] = c(x[i])
+		}
+	}
+	if m := l.matrix; m != nil {
+		x = [3]float64{
+			m[0]*x[0] + m[1]*x[1] + m[2]*x[2] + m[9],
+			m[3]*x[0] + m[4]*x[1] + m[5]*x[2] + m[10],
+			m[6]*x[0] + m[7]*x[1] + m[8]*x[2] + m[11],
+		}
+	}
+	for i, c := range l.out {
+		x[i] = c(clamp01(x[i]))
+	}
+	return x
+}
+
+// interpolate looks v up with multilinear interpolation between the 2^n
+// surrounding nodes.
+func (g *grid) interpolate(v []float64) [3]float64 {
+	n := len(g.points)
+	var (
+		base   int
+		frac   [15]float64
+		stride [15]int
+	)
+	s := g.outputs
+	for i := n - 1; i >= 0; i-- {
+		stride[i] = s
+		s *= g.points[i]
+	}
+	for i := range n {
+		x := clamp01(v[i]) * float64(g.points[i]-1)
+		k := min(int(x), g.points[i]-2)
+		frac[i] = x - float64(k)
+		base += k * stride[i]
+	}
+	var out [3]float64
+	for corner := 0; corner < 1<<n; corner++ {
+		w, off := 1.0, base
+		for i := range n {
+			if corner&(1<<i) != 0 {
+				w *= frac[i]
+				off += stride[i]
+			} else {
+				w *= 1 - frac[i]
+			}
+		}
+		if w == 0 {
+			continue
+		}
+		for o := range min(g.outputs, 3) {
+			out[o] += w * g.data[off+o]
+		}
+	}
+	return out
+}
+
+// pcs decodes an encoded connection space value to XYZ relative to D50.
+func (l *lut) pcs(x [3]float64, space string) [3]float64 {
+	if space == "XYZ" {
+		// u1Fixed15: 0x8000 is 1.0.
+		return [3]float64{x[0] * 65535 / 32768, x[1] * 65535 / 32768, x[2] * 65535 / 32768}
+	}
+	var L, a, b float64
+	switch l.enc {
+	case encLut8:
+		L, a, b = x[0]*100, x[1]*255-128, x[2]*255-128
+	case encLut16:
+		L, a, b = x[0]*65535/65280*100, x[1]*65535/256-128, x[2]*65535/256-128
+	default:
+		L, a, b = x[0]*100, x[1]*255-128, x[2]*255-128
+	}
+	return labToXYZ(L, a, b)
+}
+
+// labToXYZ converts CIELAB relative to D50 to XYZ.
+func labToXYZ(L, a, b float64) [3]float64 {
+	fy := (L + 16) / 116
+	fx, fz := fy+a/500, fy-b/200
+	inv := func(t float64) float64 {
+		if t > 6.0/29 {
+			return t * t * t
+		}
+		return 3 * (6.0 / 29) * (6.0 / 29) * (t - 4.0/29)
+	}
+	return [3]float64{d50[0] * inv(fx), d50[1] * inv(fy), d50[2] * inv(fz)}
+}
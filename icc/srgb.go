@@ -0,0 +1,184 @@
+package icc
+
+import (
+	"encoding/binary"
+	"math"
+	"sync"
+)
+
+// ── Built-in profiles ─────────────────────────────────────────────────────────
+
+// d50 is the PCS illuminant.
+var d50 = [3]float64{0.9642, 1, 0.8249}
+
+var (
+	srgbPrimaries = [3][2]float64{{0.64, 0.33}, {0.30, 0.60}, {0.15, 0.06}}
+	p3Primaries   = [3][2]float64{{0.680, 0.320}, {0.265, 0.690}, {0.150, 0.060}}
+
+	// srgbColorants maps linear sRGB to XYZ relative to D50, and
+	// fromXYZ back.
+	srgbColorants = colorants(srgbPrimaries)
+	fromXYZ       = invert(srgbColorants)
+)
+
+// SRGB returns an sRGB version 2 profile, the one ToSRGB output is in.
+// The slice is shared and must not be modified.
+var SRGB = sync.OnceValue(func() []byte { return build("sRGB IEC61966-2.1", srgbPrimaries) })
+
+// DisplayP3 returns a Display P3 version 2 profile: P3 primaries, D65 white
+// and the sRGB transfer curve.  The slice is shared and must not be
+// modified.
+var DisplayP3 = sync.OnceValue(func() []byte { return build("Display P3", p3Primaries) })
+
+// srgbDecode is the sRGB transfer function, encoded to linear.
+func srgbDecode(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+// srgbEncode is the inverse of srgbDecode.
+func srgbEncode(l float64) float64 {
+	if l <= 0.0031308 {
+		return l * 12.92
+	}
+	return 1.055*math.Pow(l, 1/2.4) - 0.055
+}
+
+// colorants returns the matrix taking linear RGB with the given xy
+// primaries and a D65 white to XYZ, Bradford-adapted to D50 as ICC
+// profiles store it.
+func colorants(xy [3][2]float64) [3][3]float64 {
+	const wx, wy = 0.3127, 0.3290
+	white := [3]float64{wx / wy, 1, (1 - wx - wy) / wy}
+	var m [3][3]float64
+	for i, p := range xy {
+		m[0][i], m[1][i], m[2][i] = p[0]/p[1], 1, (1-p[0]-p[1])/p[1]
+	}
+	inv := invert(m)
+	s := mul(&inv, white)
+	for r := range 3 {
+		for c := range 3 {
+			m[r][c] *= s[c]
+		}
+	}
+	bradford := [3][3]float64{
+		{0.8951, 0.2664, -0.1614},
+		{-0.7502, 1.7135, 0.0367},
+		{0.0389, -0.0685, 1.0296},
+	}
+	src, dst := mul(&bradford, white), mul(&bradford, d50)
+	var scale [3][3]float64
+	for i := range 3 {
+		scale[i][i] = dst[i] / src[i]
+	}
+	adapt := matmul(invert(bradford), matmul(scale, bradford))
+	return matmul(adapt, m)
+}
+
+func mul(m *[3][3]float64, v [3]float64) [3]float64 {
+	return [3]float64{
+		m[0][0]*v[0] + m[0][1]*v[1] + m[0][2]*v[2],
+		m[1][0]*v[0] + m[1][1]*v[1] + m[1][2]*v[2],
+		m[2][0]*v[0] + m[2][1]*v[1] + m[2][2]*v[2],
+	}
+}
+
+func matmul(a, b [3][3]float64) [3][3]float64 {
+	var m [3][3]float64
+	for r := range 3 {
+		for c := range 3 {
+			for k := range 3 {
+				m[r][c] += a[r][k] * b[k][c]
+			}
+		}
+	}
+	return m
+}
+
+func invert(m [3][3]float64) [3][3]float64 {
+	det := m[0][0]*(m[1][1]*m[2][2]-m[1][2]*m[2][1]) -
+		m[0][1]*(m[1][0]*m[2][2]-m[1][2]*m[2][0]) +
+		m[0][2]*(m[1][0]*m[2][1]-m[1][1]*m[2][0])
+	return [3][3]float64{
+		{(m[1][1]*m[2][2] - m[1][2]*m[2][1]) / det, (m[0][2]*m[2][1] - m[0][1]*m[2][2]) / det, (m[0][1]*m[1][2] - m[0][2]*m[1][1]) / det},
+		{(m[1][2]*m[2][0] - m[1][0]*m[2][2]) / det, (m[0][0]*m[2][2] - m[0][2]*m[2][0]) / det, (m[0][2]*m[1][0] - m[0][0]*m[1][2]) / det},
+		{(m[1][0]*m[2][1] - m[1][1]*m[2][0]) / det, (m[0][1]*m[2][0] - m[0][0]*m[2][1]) / det, (m[0][0]*m[1][1] - m[0][1]*m[1][0]) / det},
+	}
+}
+
+// build writes a version 2 display profile with the given primaries, a
+// D65 white and the sRGB transfer curve.
+func build(desc string, primaries [3][2]float64) []byte {
+	be := binary.BigEndian
+	fixed := func(v float64) uint32 { return uint32(int32(math.Round(v * 65536))) }
+	xyz := func(v [3]float64) []byte {
+		b := []byte("XYZ \x00\x00\x00\x00")
+		for _, c := range v {
+			b = be.AppendUint32(b, fixed(c))
+		}
+		return b
+	}
+
+	trc := be.AppendUint32([]byte("curv\x00\x00\x00\x00"), 1024)
+	for i := range 1024 {
+		trc = be.AppendUint16(trc, uint16(math.Round(srgbDecode(float64(i)/1023)*65535)))
+	}
+	text := be.AppendUint32([]byte("desc\x00\x00\x00\x00"), uint32(len(desc)+1))
+	text = append(text, desc...)
+	text = append(text, make([]byte, 1+4+4+2+1+67)...)
+
+	m := colorants(primaries)
+	type tag struct {
+		sig  string
+		data []byte
+	}
+	tags := []tag{
+		{"desc", text},
+		{"cprt", []byte("text\x00\x00\x00\x00No copyright, use freely\x00")},
+		{"wtpt", xyz(d50)},
+		{"rXYZ", xyz([3]float64{m[0][0], m[1][0], m[2][0]})},
+		{"gXYZ", xyz([3]float64{m[0][1], m[1][1], m[2][1]})},
+		{"bXYZ", xyz([3]float64{m[0][2], m[1][2], m[2][2]})},
+		{"rTRC", trc},
+		{"gTRC", nil},
+		{"bTRC", nil},
+	}
+
+	table := 132 + 12*len(tags)
+	body := make([]byte, 0, 4096)
+	out := make([]byte, table)
+	be.PutUint32(out[128:], uint32(len(tags)))
+	var shared uint32
+	for i, t := range tags {
+		e := out[132+12*i:]
+		copy(e, t.sig)
+		if t.data == nil {
+			// The green and blue curves share the red one.
+			be.PutUint32(e[4:], shared)
+			be.PutUint32(e[8:], uint32(len(trc)))
+			continue
+		}
+		off := uint32(table + len(body))
+		if t.sig == "rTRC" {
+			shared = off
+		}
+		be.PutUint32(e[4:], off)
+		be.PutUint32(e[8:], uint32(len(t.data)))
+		body = append(body, t.data...)
+		for len(body)%4 != 0 {
+			body = append(body, 0)
+		}
+	}
+	out = append(out, body...)
+
+	be.PutUint32(out[0:], uint32(len(out)))
+	be.PutUint32(out[8:], 0x02100000)
+	copy(out[12:], "mntrRGB XYZ ")
+	copy(out[36:], "acsp")
+	for i, c := range d50 {
+		be.PutUint32(out[68+4*i:], fixed(c))
+	}
+	return out
+}
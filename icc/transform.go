@@ -0,0 +1,183 @@
+package icc
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"sync"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Conversion ────────────────────────────────────────────────────────────────
+
+// ToSRGB converts img, whose samples are in p's colour space, to sRGB.
+// RGB and CMYK images come back as *image.NRGBA, or *image.NRGBA64 when
+// img has 16-bit samples; *image.Gray and *image.Gray16 stay gray.  Alpha
+// is carried over unchanged.
+func (p *Profile) ToSRGB(img image.Image) (image.Image, error) {
+	switch src := img.(type) {
+	case *image.Gray:
+		if p.channels != 1 {
+			return nil, p.mismatch("gray")
+		}
+		t := p.transform(8)
+		dst := image.NewGray(src.Rect)
+		rows(src.Pix, src.Stride, dst.Pix, dst.Stride, src.Rect, 1, func(s, d []byte) {
+			d[0] = to8(t.gray(int(s[0])))
+		})
+		return dst, nil
+	case *image.Gray16:
+		if p.channels != 1 {
+			return nil, p.mismatch("gray")
+		}
+		t := p.transform(16)
+		dst := image.NewGray16(src.Rect)
+		rows(src.Pix, src.Stride, dst.Pix, dst.Stride, src.Rect, 2, func(s, d []byte) {
+			v := t.gray(int(s[0])<<8 | int(s[1]))
+			d[0], d[1] = byte(v>>8), byte(v)
+		})
+		return dst, nil
+	case *image.CMYK:
+		if p.channels != 4 {
+			return nil, p.mismatch("CMYK")
+		}
+		t := p.transform(8)
+		dst := image.NewNRGBA(src.Rect)
+		rows(src.Pix, src.Stride, dst.Pix, dst.Stride, src.Rect, 4, func(s, d []byte) {
+			c := t.apply([4]int{int(s[0]), int(s[1]), int(s[2]), int(s[3])})
+			d[0], d[1], d[2], d[3] = to8(c[0]), to8(c[1]), to8(c[2]), 0xFF
+		})
+		return dst, nil
+	}
+	if p.channels == 4 {
+		return nil, p.mismatch("RGB")
+	}
+
+	if deep(img) {
+		src := nrgba64(img)
+		t := p.transform(16)
+		dst := image.NewNRGBA64(src.Rect)
+		rows(src.Pix, src.Stride, dst.Pix, dst.Stride, src.Rect, 8, func(s, d []byte) {
+			c := t.rgb(int(s[0])<<8|int(s[1]), int(s[2])<<8|int(s[3]), int(s[4])<<8|int(s[5]))
+			d[0], d[1], d[2], d[3], d[4], d[5] = byte(c[0]>>8), byte(c[0]), byte(c[1]>>8), byte(c[1]), byte(c[2]>>8), byte(c[2])
+			d[6], d[7] = s[6], s[7]
+		})
+		return dst, nil
+	}
+	src := nrgba(img)
+	t := p.transform(8)
+	dst := image.NewNRGBA(src.Rect)
+	rows(src.Pix, src.Stride, dst.Pix, dst.Stride, src.Rect, 4, func(s, d []byte) {
+		c := t.rgb(int(s[0]), int(s[1]), int(s[2]))
+		d[0], d[1], d[2], d[3] = to8(c[0]), to8(c[1]), to8(c[2]), s[3]
+	})
+	return dst, nil
+}
+
+// rows calls fn with each pixel of src, which may be a sub-image, and the
+// matching pixel of dst; both hold size bytes per pixel.
+func rows(src []byte, srcStride int, dst []byte, dstStride int, r image.Rectangle, size int, fn func(s, d []byte)) {
+	w := r.Dx() * size
+	for y := range r.Dy() {
+		s, d := src[y*srcStride:y*srcStride+w], dst[y*dstStride:y*dstStride+w]
+		for x := 0; x < w; x += size {
+			fn(s[x:x+size:x+size], d[x:x+size:x+size])
+		}
+	}
+}
+
+func (p *Profile) mismatch(kind string) error {
+	return apperrors.New(apperrors.CategoryDecode, "icc.to_srgb",
+		fmt.Errorf("%w: %s profile for a %s image", apperrors.ErrUnsupportedFormat, p.ColorSpace, kind))
+}
+
+// transform maps device samples of a given bit depth to sRGB.
+type transform struct {
+	pre  [][]float64 // p.pre tabulated for every sample value
+	post func([]float64) [3]float64
+	enc  []uint16
+}
+
+func (p *Profile) transform(bits int) *transform {
+	n := 1 << bits
+	t := &transform{post: p.post, enc: encoding()}
+	for _, c := range p.pre {
+		tab := make([]float64, n)
+		for i := range tab {
+			tab[i] = c(float64(i) / float64(n-1))
+		}
+		t.pre = append(t.pre, tab)
+	}
+	return t
+}
+
+// apply converts one pixel of len(t.pre) samples to 16-bit sRGB.
+func (t *transform) apply(s [4]int) [3]uint16 {
+	var v [4]float64
+	for i, tab := range t.pre {
+		v[i] = tab[s[i]]
+	}
+	l := mul(&fromXYZ, t.post(v[:len(t.pre)]))
+	return [3]uint16{t.encode(l[0]), t.encode(l[1]), t.encode(l[2])}
+}
+
+// rgb converts an RGB pixel; under a gray profile it reads the red sample.
+func (t *transform) rgb(r, g, b int) [3]uint16 {
+	if len(t.pre) == 1 {
+		v := t.gray(r)
+		return [3]uint16{v, v, v}
+	}
+	return t.apply([4]int{r, g, b})
+}
+
+// gray converts a gray sample; sRGB gray shares the sRGB curve.
+func (t *transform) gray(v int) uint16 {
+	return t.encode(t.post([]float64{t.pre[0][v]})[1])
+}
+
+func (t *transform) encode(l float64) uint16 { return t.enc[int(clamp01(l)*65535+0.5)] }
+
+// encoding tabulates srgbEncode for linear values in steps of 1/65535.
+var encoding = sync.OnceValue(func() []uint16 {
+	t := make([]uint16, 65536)
+	for i := range t {
+		t[i] = uint16(srgbEncode(float64(i)/65535)*65535 + 0.5)
+	}
+	return t
+})
+
+func to8(v uint16) uint8 { return uint8((uint32(v)*255 + 32767) / 65535) }
+
+// deep reports whether img has more than 8 bits per sample.
+func deep(img image.Image) bool {
+	switch img.(type) {
+	case *image.NRGBA64, *image.RGBA64, *image.Gray16:
+		return true
+	}
+	return false
+}
+
+func nrgba(img image.Image) *image.NRGBA {
+	switch src := img.(type) {
+	case *image.NRGBA:
+		return src
+	case *image.YCbCr:
+		// Opaque, so the fast RGBA conversion is already non-premultiplied.
+		dst := image.NewRGBA(src.Rect)
+		draw.Draw(dst, dst.Rect, src, src.Rect.Min, draw.Src)
+		return &image.NRGBA{Pix: dst.Pix, Stride: dst.Stride, Rect: dst.Rect}
+	}
+	dst := image.NewNRGBA(img.Bounds())
+	draw.Draw(dst, dst.Rect, img, dst.Rect.Min, draw.Src)
+	return dst
+}
+
+func nrgba64(img image.Image) *image.NRGBA64 {
+	if src, ok := img.(*image.NRGBA64); ok {
+		return src
+	}
+	dst := image.NewNRGBA64(img.Bounds())
+	draw.Draw(dst, dst.Rect, img, dst.Rect.Min, draw.Src)
+	return dst
+}
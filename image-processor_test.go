@@ -3,20 +3,63 @@ package imageprocessor_test
 import (
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
 	"image"
 	"image/color"
+	"image/draw"
+	"image/gif"
 	"image/jpeg"
 	"image/png"
+	"io"
+	"maps"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	imageprocessor "github.com/Skryldev/image-processor"
+	"github.com/Skryldev/image-processor/adapters/breaker"
+	"github.com/Skryldev/image-processor/adapters/cache"
+	"github.com/Skryldev/image-processor/adapters/classifier"
+	"github.com/Skryldev/image-processor/adapters/decoder"
+	"github.com/Skryldev/image-processor/adapters/encoder"
+	"github.com/Skryldev/image-processor/adapters/facedetect"
+	"github.com/Skryldev/image-processor/adapters/ffmpeg"
+	"github.com/Skryldev/image-processor/adapters/queue"
+	"github.com/Skryldev/image-processor/adapters/storage"
+	"github.com/Skryldev/image-processor/animation"
+	"github.com/Skryldev/image-processor/collage"
 	"github.com/Skryldev/image-processor/config"
 	"github.com/Skryldev/image-processor/core"
+	"github.com/Skryldev/image-processor/dedupe"
+	apperrors "github.com/Skryldev/image-processor/errors"
 	"github.com/Skryldev/image-processor/hooks"
+	"github.com/Skryldev/image-processor/icc"
+	"github.com/Skryldev/image-processor/imagecompare"
+	"github.com/Skryldev/image-processor/losslessjpeg"
+	"github.com/Skryldev/image-processor/manifest"
+	"github.com/Skryldev/image-processor/metadata"
 	"github.com/Skryldev/image-processor/pipeline"
+	"github.com/Skryldev/image-processor/pipeline/spec"
+	"github.com/Skryldev/image-processor/provenance"
+	"github.com/Skryldev/image-processor/sprite"
+	"github.com/Skryldev/image-processor/svg"
+	"github.com/Skryldev/image-processor/testutil"
 	"github.com/Skryldev/image-processor/utils"
+	xtiff "golang.org/x/image/tiff"
 )
 
 // ── Test helpers ──────────────────────────────────────────────────────────────
@@ -58,7 +101,7 @@ func newProc(t *testing.T) *imageprocessor.Processor {
 	cfg.QueueSize = 16
 	p := imageprocessor.New(cfg)
 	p.Start()
-	t.Cleanup(p.Stop)
+	t.Cleanup(func() { p.Stop(context.Background()) })
 	return p
 }
 
@@ -107,6 +150,4442 @@ func TestProcess_PNG_Decode(t *testing.T) {
 	}
 }
 
+func TestResizeWith_Kernels(t *testing.T) {
+	proc := newProc(t)
+	raw := newRedJPEG(t, 300, 200)
+
+	for _, k := range []core.Kernel{imageprocessor.NearestNeighbor, imageprocessor.Bilinear, imageprocessor.CatmullRom, imageprocessor.Lanczos} {
+		result, err := proc.Process(context.Background(),
+			imageprocessor.FromReader(bytes.NewReader(raw)),
+			&pipeline.DecodeStep{Registry: proc.Inner().Registry()},
+			imageprocessor.ResizeWith(150, 0, k),
+		)
+		if err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+		if result.Primary.Meta.Width != 150 || result.Primary.Meta.Height != 100 {
+			t.Errorf("%s: got %dx%d, want 150x100", k, result.Primary.Meta.Width, result.Primary.Meta.Height)
+		}
+	}
+}
+
+func TestProcess_AutoWiresRegistry(t *testing.T) {
+	proc := newProc(t)
+	raw := newRedJPEG(t, 200, 100)
+
+	result, err := proc.Process(context.Background(),
+		imageprocessor.FromReader(bytes.NewReader(raw)),
+		imageprocessor.Decode(),
+		imageprocessor.Resize(100, 0),
+		imageprocessor.ConvertFormat(imageprocessor.PNG),
+		imageprocessor.Encode(),
+	)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if utils.DetectFormat(result.Primary.Data) != "png" {
+		t.Errorf("output is not png")
+	}
+
+	// Outside a Processor the unbound step reports a config error, not a panic.
+	_, err = imageprocessor.Encode().Execute(context.Background(), result.Primary)
+	if !errors.Is(err, apperrors.ErrNoRegistry) {
+		t.Errorf("got %v, want ErrNoRegistry", err)
+	}
+}
+
+func TestDecodeStep_FallbackForMislabeledInput(t *testing.T) {
+	proc := newProc(t)
+	raw := newRedPNG(t, 40, 30)
+
+	// A PNG that claims to be a JPEG: the JPEG decoder fails, PNG takes over.
+	in := &core.ImageData{Data: raw, Format: core.FormatJPEG}
+	out, err := (&pipeline.DecodeStep{Registry: proc.Inner().Registry()}).Execute(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.Format != core.FormatPNG || out.Meta.Width != 40 {
+		t.Errorf("got %s %dpx wide, want png 40px wide", out.Format, out.Meta.Width)
+	}
+}
+
+func TestQualityStep_SurvivesStripEXIF(t *testing.T) {
+	proc := newProc(t)
+	reg := proc.Inner().Registry()
+	noisy := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range noisy.Pix {
+		noisy.Pix[i] = uint8(i * 7919 % 251)
+	}
+	base := &core.ImageData{Image: noisy, Format: core.FormatJPEG}
+
+	run := func(steps ...core.Step) *core.ImageData {
+		t.Helper()
+		img := base
+		for _, s := range steps {
+			var err error
+			if img, err = s.Execute(context.Background(), img); err != nil {
+				t.Fatalf("%s: %v", s.Name(), err)
+			}
+		}
+		return img
+	}
+	encode := imageprocessor.EncodeWith(reg, core.EncodeOptions{Quality: 10})
+	high := run(imageprocessor.Quality(95), imageprocessor.StripEXIF(), encode)
+	low := run(encode)
+
+	if len(high.Data) <= len(low.Data) {
+		t.Errorf("quality 95 after StripEXIF gave %d bytes, base quality 10 gave %d", len(high.Data), len(low.Data))
+	}
+	if q, _ := high.Attrs.Int(core.AttrQuality); q != 95 {
+		t.Errorf("quality attribute = %d, want 95", q)
+	}
+	if base.Attrs != nil {
+		t.Errorf("steps mutated the shared input's attributes: %v", base.Attrs)
+	}
+}
+
+func TestImageCompare(t *testing.T) {
+	ref := image.NewRGBA(image.Rect(0, 0, 48, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 48; x++ {
+			ref.SetRGBA(x, y, color.RGBA{R: uint8(x * 5), G: uint8(y * 8), B: 128, A: 255})
+		}
+	}
+	same, err := imagecompare.CompareImages(ref, ref)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if same.MeanError != 0 || same.MaxError != 0 || same.SSIM < 0.9999 {
+		t.Errorf("identical images: %+v", same)
+	}
+
+	// Round-trip through lossy JPEG: close but not identical.
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, ref, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	lossy := &core.ImageData{Data: buf.Bytes(), Format: core.FormatJPEG}
+	r, err := imagecompare.Compare(&core.ImageData{Image: ref}, lossy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.MeanError == 0 || r.MeanError > 0.02 || r.SSIM < 0.9 {
+		t.Errorf("jpeg q90 round trip: %+v", r)
+	}
+
+	step := imageprocessor.AssertSimilar(&core.ImageData{Image: ref}, imagecompare.Tolerance{MinSSIM: 0.9})
+	if _, err := step.Execute(context.Background(), lossy); err != nil {
+		t.Errorf("within tolerance: %v", err)
+	}
+	inverted := image.NewRGBA(ref.Bounds())
+	for i := range ref.Pix {
+		inverted.Pix[i] = 255 - ref.Pix[i]
+		if i%4 == 3 {
+			inverted.Pix[i] = 255
+		}
+	}
+	_, err = step.Execute(context.Background(), &core.ImageData{Image: inverted})
+	if !errors.Is(err, apperrors.ErrOutOfTolerance) {
+		t.Errorf("inverted image: got %v, want ErrOutOfTolerance", err)
+	}
+
+	diff, err := imagecompare.Diff(ref, inverted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff.GrayAt(0, 0).Y != 255 {
+		t.Errorf("diff at (0,0) = %d, want 255", diff.GrayAt(0, 0).Y)
+	}
+	if _, err := imagecompare.Diff(ref, image.NewRGBA(image.Rect(0, 0, 1, 1))); !errors.Is(err, apperrors.ErrInvalidDimensions) {
+		t.Errorf("size mismatch: got %v, want ErrInvalidDimensions", err)
+	}
+
+	// Another backend's pixels win over the stale source bytes.
+	white := image.NewGray(image.Rect(0, 0, 3, 1))
+	for i := range white.Pix {
+		white.Pix[i] = 255
+	}
+	processed := &core.ImageData{Image: fakeBackendImage{}, Data: testutil.EncodePNG(t, white), Format: core.FormatPNG}
+	r, err = imagecompare.Compare(processed, &core.ImageData{Image: image.NewGray(image.Rect(0, 0, 3, 1))})
+	if err != nil || r.MaxError != 0 {
+		t.Errorf("backend image compared as %+v, %v; want its own pixels", r, err)
+	}
+}
+
+func TestTestutil_FixturesAndGolden(t *testing.T) {
+	cmyk, err := jpeg.Decode(bytes.NewReader(testutil.CMYKJPEG(t, 24, 16)))
+	if err != nil {
+		t.Fatalf("cmyk jpeg: %v", err)
+	}
+	if _, ok := cmyk.(*image.CMYK); !ok || cmyk.Bounds().Dx() != 24 {
+		t.Errorf("cmyk jpeg decoded as %T %v", cmyk, cmyk.Bounds())
+	}
+	r, err := imagecompare.CompareImages(cmyk, testutil.Gradient(24, 16))
+	if err != nil || r.MeanError > 0.03 {
+		t.Errorf("cmyk jpeg drifted from its gradient: %+v, %v", r, err)
+	}
+
+	exif := testutil.EXIFJPEG(t, 16, 16, 6)
+	if !bytes.Contains(exif, []byte("Exif\x00\x00MM")) {
+		t.Error("EXIF JPEG has no APP1 Exif segment")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(exif)); err != nil {
+		t.Errorf("EXIF JPEG does not decode: %v", err)
+	}
+
+	out := testutil.Run(t, testutil.AlphaPNG(t, 40, 20), imageprocessor.Decode(), imageprocessor.Resize(20, 10))
+	golden := filepath.Join(t.TempDir(), "resize.png")
+	tol := imagecompare.Tolerance{MaxMeanError: 0.001}
+	if err := testutil.CompareGolden(golden, out, tol, false); err == nil {
+		t.Error("missing golden file should fail")
+	}
+	if err := testutil.CompareGolden(golden, out, tol, true); err != nil {
+		t.Fatalf("update: %v", err)
+	}
+	if err := testutil.CompareGolden(golden, out, tol, false); err != nil {
+		t.Errorf("compare after update: %v", err)
+	}
+	chart := &core.ImageData{Image: testutil.TextChart(20, 10)}
+	if err := testutil.CompareGolden(golden, chart, tol, false); !errors.Is(err, apperrors.ErrOutOfTolerance) {
+		t.Errorf("different image: got %v, want ErrOutOfTolerance", err)
+	}
+}
+
+func TestDeterministic_ByteIdenticalOutput(t *testing.T) {
+	input := testutil.EXIFJPEG(t, 64, 48, 1)
+	var pngOpts core.EncodeOptions
+	pngOpts.PNG().Reduce = true
+
+	for _, tc := range []struct {
+		name  string
+		steps []core.Step
+	}{
+		{"jpeg", []core.Step{imageprocessor.Decode(), imageprocessor.Deterministic(),
+			imageprocessor.Resize(32, 0), imageprocessor.Encode()}},
+		{"png", []core.Step{imageprocessor.Decode(), imageprocessor.Deterministic(),
+			imageprocessor.ConvertFormat(imageprocessor.PNG), imageprocessor.EncodeOpts(pngOpts)}},
+	} {
+		first := testutil.Run(t, input, tc.steps...)
+		second := testutil.Run(t, input, tc.steps...)
+		if !bytes.Equal(first.Data, second.Data) {
+			t.Errorf("%s: outputs differ (%d vs %d bytes)", tc.name, len(first.Data), len(second.Data))
+		}
+		if bytes.Contains(first.Data, []byte("Exif")) || bytes.Contains(first.Data, []byte("testutil")) {
+			t.Errorf("%s: output still carries metadata", tc.name)
+		}
+		if v, _ := first.Attrs.Bool(core.AttrDeterministic); !v {
+			t.Errorf("%s: deterministic directive not recorded", tc.name)
+		}
+	}
+}
+
+func TestDeterministic_PinsEncoderParameters(t *testing.T) {
+	ctx := context.Background()
+	img := &core.ImageData{Image: testutil.Gradient(64, 48), Meta: core.Metadata{Width: 64, Height: 48}}
+	encode := func(enc core.Encoder, f core.Format, deterministic bool) ([]byte, error) {
+		in := *img
+		in.Format = f
+		return enc.Encode(ctx, &in, core.EncodeOptions{Deterministic: deterministic})
+	}
+
+	// The configured default quality no longer reaches the output.
+	low, _ := encode(encoder.NewJPEG(40), core.FormatJPEG, true)
+	high, _ := encode(encoder.NewJPEG(95), core.FormatJPEG, true)
+	if len(low) == 0 || !bytes.Equal(low, high) {
+		t.Errorf("deterministic JPEG depends on the default quality (%d vs %d bytes)", len(low), len(high))
+	}
+	low, _ = encode(encoder.NewJPEG(40), core.FormatJPEG, false)
+	high, _ = encode(encoder.NewJPEG(95), core.FormatJPEG, false)
+	if bytes.Equal(low, high) {
+		t.Error("default quality ignored without Deterministic")
+	}
+	if q := (core.EncodeOptions{Quality: 30, Deterministic: true}).QualityOr(95); q != 30 {
+		t.Errorf("explicit quality = %d, want 30", q)
+	}
+
+	// cwebp is never consulted.
+	webp := &encoder.WebP{DefaultQuality: 85, CWebP: filepath.Join(t.TempDir(), "missing-cwebp")}
+	if _, err := encode(webp, core.FormatWebP, false); err == nil {
+		t.Error("expected the missing cwebp to fail a normal encode")
+	}
+	if out, err := encode(webp, core.FormatWebP, true); err != nil || len(out) == 0 {
+		t.Errorf("deterministic WebP: %v", err)
+	}
+}
+
+func TestDedupe_NearDuplicates(t *testing.T) {
+	photo := testutil.Gradient(200, 150)
+	for y := 40; y < 90; y++ {
+		for x := 60; x < 140; x++ {
+			photo.SetNRGBA(x, y, color.NRGBA{R: 240, G: 240, B: 30, A: 255})
+		}
+	}
+	original := testutil.EncodeJPEG(t, photo, 95)
+	resized := testutil.Run(t, original, imageprocessor.Decode(), imageprocessor.Resize(90, 0),
+		imageprocessor.Quality(40), imageprocessor.Encode())
+
+	idx := dedupe.NewMemoryIndex()
+	add := &dedupe.Step{Index: idx, ID: func(*core.ImageData) string { return "original" }}
+	first := testutil.Run(t, original, imageprocessor.Decode(), add)
+	if len(dedupe.Matches(first)) != 0 || idx.Len() != 1 {
+		t.Fatalf("first upload: matches %v, index size %d", dedupe.Matches(first), idx.Len())
+	}
+
+	dup := testutil.Run(t, resized.Data, imageprocessor.Decode(), imageprocessor.Dedupe(idx, 0))
+	if m := dedupe.Matches(dup); len(m) != 1 || m[0].ID != "original" {
+		t.Errorf("resized copy: matches %v, want [original]", m)
+	}
+	other := testutil.Run(t, testutil.EncodePNG(t, testutil.TextChart(200, 150)), imageprocessor.Decode(),
+		imageprocessor.Dedupe(idx, 0))
+	if m := dedupe.Matches(other); len(m) != 0 {
+		t.Errorf("unrelated image matched %v", m)
+	}
+
+	reject := &dedupe.Step{Index: idx, Reject: true}
+	if _, err := reject.Execute(context.Background(), dup); !errors.Is(err, apperrors.ErrDuplicate) {
+		t.Errorf("Reject: got %v, want ErrDuplicate", err)
+	}
+
+	var saved bytes.Buffer
+	if err := idx.Save(&saved); err != nil {
+		t.Fatal(err)
+	}
+	restored := dedupe.NewMemoryIndex()
+	if err := restored.Load(&saved); err != nil || restored.Len() != 1 {
+		t.Fatalf("Load: %v, %d entries", err, restored.Len())
+	}
+}
+
+func TestSpriteSheetFromStorage(t *testing.T) {
+	proc := newProc(t)
+	store, err := storage.NewLocal(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	colors := []color.NRGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}, {255, 255, 0, 255}, {0, 255, 255, 255}}
+	for i, c := range colors {
+		img := image.NewNRGBA(image.Rect(0, 0, 80, 60))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		key := core.StorageKey{Bucket: "frames", Path: fmt.Sprintf("scene/%02d.png", i)}
+		if err := store.Put(context.Background(), key, bytes.NewReader(testutil.EncodePNG(t, img)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sheet, err := proc.SpriteSheetFromStorage(context.Background(), store, "frames", "scene/",
+		sprite.Options{CellWidth: 40, CellHeight: 30, Gap: 2, Columns: 3})
+	if err != nil {
+		t.Fatalf("SpriteSheetFromStorage: %v", err)
+	}
+	m := sheet.Map
+	if m.Columns != 3 || m.Rows != 2 || m.Width != 3*40+4*2 || m.Height != 2*30+3*2 || len(m.Frames) != 5 {
+		t.Fatalf("map = %+v", m)
+	}
+	dec, err := png.Decode(bytes.NewReader(sheet.Image.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range m.Frames {
+		if f.Name != fmt.Sprintf("scene/%02d.png", i) {
+			t.Errorf("frame %d name = %q", i, f.Name)
+		}
+		got := color.NRGBAModel.Convert(dec.At(f.X+f.Width/2, f.Y+f.Height/2))
+		if got != colors[i] {
+			t.Errorf("frame %d (%s) centre = %v, want %v", i, f.Name, got, colors[i])
+		}
+	}
+	if _, err := json.Marshal(m); err != nil {
+		t.Errorf("map does not marshal: %v", err)
+	}
+}
+
+func TestCollage_RowTemplate(t *testing.T) {
+	solid := func(c color.NRGBA, w, h int) image.Image {
+		img := image.NewNRGBA(image.Rect(0, 0, w, h))
+		draw.Draw(img, img.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		return img
+	}
+	red, green, blue := color.NRGBA{255, 0, 0, 255}, color.NRGBA{0, 255, 0, 255}, color.NRGBA{0, 0, 255, 255}
+	white := color.NRGBA{255, 255, 255, 255}
+	layout := collage.Layout{
+		Width: 200, Height: 100, Rows: []int{1, 2}, Gap: 4, Background: white,
+		CellFit: []pipeline.Fit{"", pipeline.FitContain},
+	}
+
+	cells := layout.Cells(3)
+	want := []image.Rectangle{image.Rect(4, 4, 196, 48), image.Rect(4, 52, 98, 96), image.Rect(102, 52, 196, 96)}
+	for i := range want {
+		if cells[i] != want[i] {
+			t.Errorf("cell %d = %v, want %v", i, cells[i], want[i])
+		}
+	}
+
+	out, err := collage.Compose(context.Background(),
+		[]image.Image{solid(red, 50, 50), solid(green, 10, 40), solid(blue, 300, 30)}, layout)
+	if err != nil {
+		t.Fatalf("Compose: %v", err)
+	}
+	checks := []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{100, 26, red},   // cover fills the wide cell
+		{51, 74, green},  // contain keeps the narrow image centred
+		{10, 74, white},  // ...with background padding beside it
+		{149, 74, blue},  // cover crops the wide strip
+		{100, 50, white}, // gap between rows
+	}
+	for _, c := range checks {
+		if got := out.NRGBAAt(c.x, c.y); got != c.want {
+			t.Errorf("pixel (%d,%d) = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+
+	if _, err := collage.Compose(context.Background(), make([]image.Image, 4), layout); err == nil {
+		t.Error("four images in a three-cell template should fail")
+	}
+}
+
+func TestBarcodeStep_QROverlay(t *testing.T) {
+	bg := image.NewRGBA(image.Rect(0, 0, 200, 160))
+	draw.Draw(bg, bg.Bounds(), image.NewUniform(color.RGBA{90, 140, 200, 255}), image.Point{}, draw.Src)
+	in := &core.ImageData{Image: bg}
+
+	out, err := imageprocessor.QRCode("https://example.com/t/12345", 100, core.GravityNorthWest).
+		Execute(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	dst := out.Image.(*image.RGBA)
+	dark := func(x, y int) bool { return dst.RGBAAt(x, y).R < 64 }
+
+	// Walk the diagonal through the quiet zone to the finder pattern corner.
+	k := 0
+	for k < 100 && !dark(k, k) {
+		k++
+	}
+	scale := k / 4
+	if scale < 1 || k%4 != 0 {
+		t.Fatalf("finder corner at %d, want a 4-module quiet zone", k)
+	}
+	// Finder: 7 dark modules along the top edge, then the light separator.
+	if !dark(k+7*scale-1, k) || dark(k+7*scale, k) || dark(k+scale, k+scale) {
+		t.Errorf("finder pattern not where expected (module size %d)", scale)
+	}
+	if got := dst.RGBAAt(150, 150); got != (color.RGBA{90, 140, 200, 255}) {
+		t.Errorf("pixel outside the code changed to %v", got)
+	}
+
+	linear := &pipeline.BarcodeStep{Content: "SHIP-0042", Symbology: pipeline.SymbologyCode128, Size: 190, Height: 40}
+	if _, err := linear.Execute(context.Background(), in); err != nil {
+		t.Errorf("code128: %v", err)
+	}
+	tiny := &pipeline.BarcodeStep{Content: "https://example.com/t/12345", Size: 10}
+	if _, err := tiny.Execute(context.Background(), in); !errors.Is(err, apperrors.ErrInvalidDimensions) {
+		t.Errorf("10px QR: got %v, want ErrInvalidDimensions", err)
+	}
+}
+
+func TestGIFToVideoStep(t *testing.T) {
+	anim := &gif.GIF{}
+	for i := 0; i < 3; i++ {
+		frame := image.NewPaletted(image.Rect(0, 0, 31, 17), color.Palette{color.Black, color.White})
+		frame.SetColorIndex(i, i, 1)
+		anim.Image = append(anim.Image, frame)
+		anim.Delay = append(anim.Delay, 10)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, anim); err != nil {
+		t.Fatal(err)
+	}
+	animated := bytes.Clone(buf.Bytes())
+	if n := utils.GIFFrameCount(animated); n != 3 {
+		t.Fatalf("GIFFrameCount = %d, want 3", n)
+	}
+	buf.Reset()
+	gif.Encode(&buf, anim.Image[0], nil)
+	static := buf.Bytes()
+
+	// A stand-in ffmpeg that drains stdin and writes a marker to its last
+	// argument, the output path.
+	bin := filepath.Join(t.TempDir(), "ffmpeg")
+	script := "#!/bin/sh\ncat >/dev/null\nfor a; do out=$a; done\nprintf fakevideo >\"$out\"\n"
+	if err := os.WriteFile(bin, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	step := &ffmpeg.GIFToVideoStep{
+		Transcoder: ffmpeg.New(bin),
+		Options:    ffmpeg.VideoOptions{Container: core.FormatWebM},
+		MinBytes:   1,
+	}
+
+	out, err := step.Execute(context.Background(), &core.ImageData{Data: animated, Format: core.FormatGIF})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.Format != core.FormatWebM || !out.Format.IsVideo() || string(out.Data) != "fakevideo" || out.Image != nil {
+		t.Errorf("got format %s, data %q, image %T", out.Format, out.Data, out.Image)
+	}
+	encoded, err := (&pipeline.EncodeStep{Registry: core.NewRegistry()}).Execute(context.Background(), out)
+	if err != nil || string(encoded.Data) != "fakevideo" {
+		t.Errorf("EncodeStep should pass video through: %v", err)
+	}
+
+	in := &core.ImageData{Data: static, Format: core.FormatGIF}
+	if out, _ := step.Execute(context.Background(), in); out != in {
+		t.Error("static GIF should pass through")
+	}
+	step.MinBytes = len(animated) + 1
+	in = &core.ImageData{Data: animated, Format: core.FormatGIF}
+	if out, _ := step.Execute(context.Background(), in); out != in {
+		t.Error("GIF under MinBytes should pass through")
+	}
+}
+
+func TestExtractFrames(t *testing.T) {
+	proc := newProc(t)
+	const h, n = 8, 5
+	inputs := map[string][]byte{
+		"gif":  testutil.AnimatedGIF(t, h, n),
+		"apng": testutil.AnimatedPNG(t, h, n),
+	}
+	for name, data := range inputs {
+		t.Run(name, func(t *testing.T) {
+			result, err := proc.ExtractFrames(context.Background(),
+				imageprocessor.FromReader(bytes.NewReader(data)), animation.Evenly(3), imageprocessor.Encode())
+			if err != nil {
+				t.Fatalf("ExtractFrames: %v", err)
+			}
+			if len(result.Variants) != 3 {
+				t.Fatalf("got %d frames, want 3", len(result.Variants))
+			}
+			for _, i := range []int{0, 2, 4} {
+				v := result.Variants[imageprocessor.FrameName(i)]
+				if v == nil || len(v.Data) == 0 {
+					t.Fatalf("frame %d missing or not encoded", i)
+				}
+				frame := v.Image.(*image.NRGBA)
+				if i > 0 {
+					sq := testutil.FrameSquare(i, h)
+					if got := frame.NRGBAAt(sq.Min.X, 0); got != testutil.FrameColor(i) {
+						t.Errorf("frame %d: square is %v, want %v", i, got, testutil.FrameColor(i))
+					}
+				}
+				// Squares painted by earlier frames persist; later ones are
+				// still background.
+				if i >= 2 && frame.NRGBAAt(0, 0) != testutil.FrameColor(1) {
+					t.Errorf("frame %d lost frame 1's square", i)
+				}
+				if i < 4 && frame.NRGBAAt((n-2)*h, 0) != testutil.AnimationBackground {
+					t.Errorf("frame %d shows frame 4's square early", i)
+				}
+				if d, _ := v.Attrs[animation.AttrDelay].(time.Duration); d != 100*time.Millisecond {
+					t.Errorf("frame %d delay = %v", i, d)
+				}
+			}
+			if result.Primary != result.Variants[imageprocessor.FrameName(0)] {
+				t.Error("Primary should be the first selected frame")
+			}
+		})
+	}
+
+	idx, err := animation.EveryNth(2).Indices(5)
+	if err != nil || fmt.Sprint(idx) != "[0 2 4]" {
+		t.Errorf("EveryNth(2) = %v, %v", idx, err)
+	}
+	if idx, _ := animation.At(-1).Indices(5); fmt.Sprint(idx) != "[4]" {
+		t.Errorf("At(-1) = %v", idx)
+	}
+	if _, err := animation.At(5).Indices(5); err == nil {
+		t.Error("At(5) of 5 frames should fail")
+	}
+}
+
+func TestAutoEnhanceStep(t *testing.T) {
+	// A dull, blue-tinted photo: tones squeezed into 90-150 plus a blue cast.
+	dull := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(90 + x)
+			dull.SetNRGBA(x, y, color.NRGBA{v, v, v + 25, 255})
+		}
+	}
+	stats := func(m *image.NRGBA) (mean [3]float64, lo, hi uint8) {
+		lo = 255
+		for i := 0; i < len(m.Pix); i += 4 {
+			for c := 0; c < 3; c++ {
+				mean[c] += float64(m.Pix[i+c]) / float64(len(m.Pix)/4)
+			}
+			lo, hi = min(lo, m.Pix[i+1]), max(hi, m.Pix[i+1])
+		}
+		return mean, lo, hi
+	}
+
+	out, err := imageprocessor.AutoEnhance(1).Execute(context.Background(), &core.ImageData{Image: dull})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	before, lo0, hi0 := stats(dull)
+	after, lo1, hi1 := stats(out.Image.(*image.NRGBA))
+	if cast0, cast1 := before[2]-before[0], after[2]-after[0]; cast1 > cast0/3 {
+		t.Errorf("blue cast %.1f → %.1f, want it mostly removed", cast0, cast1)
+	}
+	if int(hi1)-int(lo1) < 2*(int(hi0)-int(lo0))-4 {
+		t.Errorf("tonal range %d-%d → %d-%d, want it stretched", lo0, hi0, lo1, hi1)
+	}
+
+	half, _ := (&pipeline.AutoEnhanceStep{Strength: 0.5}).Execute(context.Background(), &core.ImageData{Image: dull})
+	if _, lo, hi := stats(half.Image.(*image.NRGBA)); hi-lo <= hi0-lo0 || hi-lo >= hi1-lo1 {
+		t.Errorf("half strength range %d-%d should fall between %d-%d and %d-%d", lo, hi, lo0, hi0, lo1, hi1)
+	}
+
+	flat := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.NRGBA{120, 120, 120, 255}), image.Point{}, draw.Src)
+	out, _ = imageprocessor.AutoEnhance(1).Execute(context.Background(), &core.ImageData{Image: flat})
+	if got := out.Image.(*image.NRGBA).NRGBAAt(3, 3); got != (color.NRGBA{120, 120, 120, 255}) {
+		t.Errorf("neutral flat image changed to %v", got)
+	}
+}
+
+func TestAdjustStep(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 60, 30, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{40, 120, 220, 128})
+	run := func(s core.Step) *image.NRGBA {
+		t.Helper()
+		out, err := s.Execute(context.Background(), &core.ImageData{Image: src})
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name(), err)
+		}
+		return out.Image.(*image.NRGBA)
+	}
+	near := func(a, b uint8) bool { return a-b < 3 || b-a < 3 }
+
+	if got := run(imageprocessor.Adjust(-1, 0, 0, 0)); got.NRGBAAt(0, 0) != (color.NRGBA{0, 0, 0, 255}) {
+		t.Errorf("brightness -1 = %v, want black", got.NRGBAAt(0, 0))
+	}
+	gray := run(imageprocessor.Adjust(0, 0, -1, 0))
+	for x := 0; x < 2; x++ {
+		c := gray.NRGBAAt(x, 0)
+		if !near(c.R, c.G) || !near(c.G, c.B) || c.A != src.NRGBAAt(x, 0).A {
+			t.Errorf("saturation -1 pixel %d = %v, want gray with alpha kept", x, c)
+		}
+	}
+	flat := run(imageprocessor.Adjust(0, -1, 0, 0)).NRGBAAt(0, 0)
+	if flat.R != 128 || flat.G != 128 || flat.B != 128 {
+		t.Errorf("contrast -1 = %v, want mid-gray", flat)
+	}
+	full := run(imageprocessor.Adjust(0, 0, 0, 360)).NRGBAAt(0, 0)
+	if want := src.NRGBAAt(0, 0); !near(full.R, want.R) || !near(full.G, want.G) || !near(full.B, want.B) {
+		t.Errorf("hue 360 = %v, want %v", full, want)
+	}
+	if rot := run(imageprocessor.Adjust(0, 0, 0, 120)).NRGBAAt(0, 0); rot.G <= rot.R || rot.G <= rot.B {
+		t.Errorf("hue +120 on orange = %v, want it to turn green", rot)
+	}
+}
+
+func TestSmartCrop_KeepsOffCentreSubject(t *testing.T) {
+	// A flat backdrop with a detailed, skin-toned subject near the right edge.
+	src := image.NewNRGBA(image.Rect(0, 0, 300, 100))
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 300; x++ {
+			c := color.NRGBA{90, 100, 110, 255}
+			if x >= 220 && x < 280 && y >= 20 && y < 80 {
+				c = color.NRGBA{224, 172, 140, 255}
+				if (x/4+y/4)%2 == 0 {
+					c = color.NRGBA{120, 80, 60, 255}
+				}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	for _, tc := range []struct {
+		strategy core.CropStrategy
+		subject  bool // whether the window's centre lands on the subject
+	}{
+		{imageprocessor.CropCentre, false},
+		{imageprocessor.CropEntropy, true},
+		{imageprocessor.CropAttention, true},
+	} {
+		out, err := imageprocessor.SmartCrop(100, 100, tc.strategy).Execute(context.Background(), &core.ImageData{Image: src})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.strategy, err)
+		}
+		m := out.Image.(image.Image)
+		if m.Bounds().Dx() != 100 || m.Bounds().Dy() != 100 {
+			t.Fatalf("%s: size %v", tc.strategy, m.Bounds())
+		}
+		c := color.NRGBAModel.Convert(m.At(50, 50)).(color.NRGBA)
+		if onSubject := c != (color.NRGBA{90, 100, 110, 255}); onSubject != tc.subject {
+			t.Errorf("%s: centre pixel %v, subject in middle = %v, want %v", tc.strategy, c, onSubject, tc.subject)
+		}
+	}
+}
+
+func TestFaceCrop_KeepsFaceInFrame(t *testing.T) {
+	// A tall portrait whose face sits near the top: a centred square crop
+	// would cut through it.
+	src := image.NewNRGBA(image.Rect(0, 0, 120, 360))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{40, 60, 90, 255}), image.Point{}, draw.Src)
+	face := image.Rect(40, 20, 80, 70)
+	draw.Draw(src, face, image.NewUniform(color.NRGBA{224, 172, 140, 255}), image.Point{}, draw.Src)
+
+	faces, err := facedetect.NewSkinTone().Detect(context.Background(), src)
+	if err != nil || len(faces) != 1 || !faces[0].Overlaps(face) {
+		t.Fatalf("Detect = %v, %v; want one box on %v", faces, err, face)
+	}
+	out, err := imageprocessor.FaceCrop(facedetect.NewSkinTone(), 60, 60).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(image.Image)
+	if m.Bounds().Dx() != 60 || m.Bounds().Dy() != 60 {
+		t.Fatalf("size %v, want 60x60", m.Bounds())
+	}
+	// The 120x120 window starts at the top, so the face maps to y 10..35.
+	if c := color.NRGBAModel.Convert(m.At(30, 22)).(color.NRGBA); c.R < 200 {
+		t.Errorf("face centre pixel = %v, want skin tone", c)
+	}
+
+	// A face whose margin overflows every window of the target ratio is padded.
+	wide, err := (&pipeline.FaceCropStep{Detector: stubFaces{image.Rect(0, 100, 120, 200)}, Width: 40, Height: 80}).
+		Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute padded: %v", err)
+	}
+	if !wide.Meta.HasAlpha {
+		t.Error("padded crop should report alpha")
+	}
+}
+
+type stubFaces []image.Rectangle
+
+func (f stubFaces) Detect(context.Context, image.Image) ([]image.Rectangle, error) { return f, nil }
+
+func TestTextOverlay_DrawsAnchoredText(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 200, 100))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{20, 20, 60, 255}), image.Point{}, draw.Src)
+	out, err := imageprocessor.TextOverlay("Hello, world", 20, core.GravitySouth).
+		Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(*image.RGBA)
+	var top, bottom int
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 200; x++ {
+			if m.RGBAAt(x, y).R > 128 {
+				if y < 50 {
+					top++
+				} else {
+					bottom++
+				}
+			}
+		}
+	}
+	if top != 0 || bottom < 50 {
+		t.Errorf("text pixels top=%d bottom=%d, want all in the bottom half", top, bottom)
+	}
+	if src.RGBAAt(100, 90).R != 20 {
+		t.Error("source image was modified")
+	}
+
+	// Long text wraps instead of running off the edge.
+	long := strings.Repeat("word ", 30)
+	out, err = (&pipeline.TextOverlayStep{Text: long, Size: 14, Position: core.GravityNorthWest}).
+		Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute long: %v", err)
+	}
+	m = out.Image.(*image.RGBA)
+	for y := 0; y < 100; y++ {
+		if m.RGBAAt(199, y).R > 128 {
+			t.Fatalf("text reaches the right edge at y=%d", y)
+		}
+	}
+}
+
+func TestWatermark_PositionOpacityAndTile(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 100, 80))
+	draw.Draw(src, src.Bounds(), image.White, image.Point{}, draw.Src)
+	mark := image.NewRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(mark, mark.Bounds(), image.Black, image.Point{}, draw.Src)
+	run := func(s core.Step) *image.RGBA {
+		t.Helper()
+		out, err := s.Execute(context.Background(), &core.ImageData{Image: src})
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name(), err)
+		}
+		return out.Image.(*image.RGBA)
+	}
+
+	m := run(&pipeline.WatermarkStep{Watermark: mark, Position: core.GravitySouthEast, OffsetX: 5, OffsetYPercent: 10})
+	if m.RGBAAt(90, 68).R != 0 || m.RGBAAt(95, 75).R != 255 {
+		t.Errorf("south-east mark with margins misplaced: %v at (90,68), %v at (95,75)", m.RGBAAt(90, 68), m.RGBAAt(95, 75))
+	}
+	if m := run(imageprocessor.Watermark(mark, core.GravityCenter, 0.5)); m.RGBAAt(50, 40).R < 120 || m.RGBAAt(50, 40).R > 135 {
+		t.Errorf("half-opacity mark = %v, want mid-gray", m.RGBAAt(50, 40))
+	}
+
+	m = run(imageprocessor.WatermarkTiled(mark, 0.05, 0))
+	dark := 0
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			if m.RGBAAt(x, y).R == 0 {
+				dark++
+			}
+		}
+	}
+	// 5px tiles with no spacing cover the whole frame.
+	if dark != 100*80 {
+		t.Errorf("tiled 5px marks cover %d pixels, want all %d", dark, 100*80)
+	}
+	m = run(&pipeline.WatermarkStep{Watermark: mark, Tile: true, Spacing: 10})
+	if m.RGBAAt(5, 5).R != 0 || m.RGBAAt(15, 5).R != 255 || m.RGBAAt(25, 25).R != 0 {
+		t.Error("spaced tiles misplaced")
+	}
+}
+
+func TestRoundCornersAndCircleMask(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 40, 40))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{200, 0, 0, 255}), image.Point{}, draw.Src)
+	run := func(s core.Step) (*core.ImageData, *image.NRGBA) {
+		t.Helper()
+		out, err := s.Execute(context.Background(), &core.ImageData{Image: src, Format: core.FormatJPEG})
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name(), err)
+		}
+		return out, out.Image.(*image.NRGBA)
+	}
+
+	out, m := run(imageprocessor.RoundCorners(10))
+	if out.Format != core.FormatPNG || !out.Meta.HasAlpha {
+		t.Errorf("format %q, HasAlpha %v; want png with alpha", out.Format, out.Meta.HasAlpha)
+	}
+	for _, p := range []image.Point{{0, 0}, {39, 0}, {0, 39}, {39, 39}} {
+		if a := m.NRGBAAt(p.X, p.Y).A; a != 0 {
+			t.Errorf("corner %v alpha %d, want 0", p, a)
+		}
+	}
+	for _, p := range []image.Point{{10, 0}, {0, 20}, {20, 20}, {4, 4}} {
+		if a := m.NRGBAAt(p.X, p.Y).A; a != 255 {
+			t.Errorf("pixel %v alpha %d, want opaque", p, a)
+		}
+	}
+	if a := m.NRGBAAt(3, 2).A; a == 0 || a == 255 {
+		t.Errorf("edge pixel alpha %d, want anti-aliased", a)
+	}
+
+	_, m = run(imageprocessor.CircleMask())
+	if m.NRGBAAt(2, 2).A != 0 || m.NRGBAAt(20, 20).A != 255 || m.NRGBAAt(20, 0).A == 0 {
+		t.Errorf("circle mask alphas %d/%d/%d", m.NRGBAAt(2, 2).A, m.NRGBAAt(20, 20).A, m.NRGBAAt(20, 0).A)
+	}
+}
+
+func TestBorder_GrowsCanvasWithPerSideWidths(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	red := color.RGBA{255, 0, 0, 255}
+	out, err := (&pipeline.BorderStep{Width: 2, Left: 5, Color: red}).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(*image.RGBA)
+	if out.Meta.Width != 27 || out.Meta.Height != 14 || m.Bounds().Dx() != 27 {
+		t.Fatalf("size %dx%d, want 27x14", out.Meta.Width, out.Meta.Height)
+	}
+	if m.RGBAAt(4, 7) != red || m.RGBAAt(5, 7).B != 255 || m.RGBAAt(24, 1) != red || m.RGBAAt(24, 11).B != 255 {
+		t.Error("border or image pixels misplaced")
+	}
+	if got, _ := imageprocessor.Border(0, red).Execute(context.Background(), &core.ImageData{Image: src}); got.Image != image.Image(src) {
+		t.Error("zero-width border should pass the image through")
+	}
+}
+
+func TestEncode_FlattensAlphaForJPEG(t *testing.T) {
+	proc := newProc(t)
+	src := image.NewNRGBA(image.Rect(0, 0, 16, 16)) // fully transparent
+	draw.Draw(src, image.Rect(0, 0, 8, 16), image.NewUniform(color.NRGBA{0, 0, 255, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	decodeJPEG := func(opts core.EncodeOptions) image.Image {
+		t.Helper()
+		res, err := proc.Process(context.Background(),
+			imageprocessor.FromReader(bytes.NewReader(buf.Bytes())),
+			imageprocessor.Decode(),
+			imageprocessor.ConvertFormat(imageprocessor.JPEG),
+			imageprocessor.EncodeOpts(opts),
+		)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		m, err := jpeg.Decode(bytes.NewReader(res.Primary.Data))
+		if err != nil {
+			t.Fatalf("jpeg.Decode: %v", err)
+		}
+		return m
+	}
+	if r, g, b, _ := decodeJPEG(core.EncodeOptions{}).At(12, 8).RGBA(); r>>8 < 240 || g>>8 < 240 || b>>8 < 240 {
+		t.Errorf("transparent area = %d,%d,%d, want white", r>>8, g>>8, b>>8)
+	}
+	if r, g, _, _ := decodeJPEG(core.EncodeOptions{Background: color.RGBA{255, 0, 0, 255}}).At(12, 8).RGBA(); r>>8 < 230 || g>>8 > 30 {
+		t.Errorf("transparent area with red background = %d,%d", r>>8, g>>8)
+	}
+
+	out, err := imageprocessor.Flatten(nil).Execute(context.Background(), &core.ImageData{Image: src, Meta: core.Metadata{HasAlpha: true}})
+	if err != nil || out.Meta.HasAlpha || !out.Image.(*image.RGBA).Opaque() {
+		t.Errorf("Flatten: err %v, HasAlpha %v", err, out.Meta.HasAlpha)
+	}
+}
+
+func TestTrim_RemovesUniformBorders(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 60, 40))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.RGBA{250, 252, 250, 255}), image.Point{}, draw.Src)
+	src.Set(59, 39, color.RGBA{255, 255, 255, 255}) // scanner noise within tolerance
+	draw.Draw(src, image.Rect(12, 5, 30, 33), image.NewUniform(color.RGBA{30, 30, 30, 255}), image.Point{}, draw.Src)
+
+	out, err := imageprocessor.Trim(0).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.Meta.Width != 18 || out.Meta.Height != 28 {
+		t.Errorf("trimmed to %dx%d, want 18x28", out.Meta.Width, out.Meta.Height)
+	}
+
+	exact, err := (&pipeline.TrimStep{Tolerance: -1, Background: color.RGBA{250, 252, 250, 255}}).
+		Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute exact: %v", err)
+	}
+	if exact.Meta.Width != 48 || exact.Meta.Height != 35 {
+		t.Errorf("exact trim to %dx%d, want 48x35 (noise pixel kept)", exact.Meta.Width, exact.Meta.Height)
+	}
+
+	blank := image.NewRGBA(image.Rect(0, 0, 8, 8))
+	if got, err := imageprocessor.Trim(0).Execute(context.Background(), &core.ImageData{Image: blank}); err != nil || got.Image != image.Image(blank) {
+		t.Errorf("all-background image: %v, changed %v", err, got.Image != image.Image(blank))
+	}
+}
+
+func TestRedactRegions(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	for y := 0; y < 20; y++ {
+		for x := 0; x < 40; x++ {
+			v := uint8(0)
+			if (x+y)%2 == 0 {
+				v = 255
+			}
+			src.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	plate := image.Rect(4, 4, 20, 12)
+
+	for _, step := range []core.Step{
+		imageprocessor.Pixelate(4, plate),
+		imageprocessor.BlurRegion(2, plate),
+	} {
+		out, err := step.Execute(context.Background(), &core.ImageData{Image: src})
+		if err != nil {
+			t.Fatalf("%s: %v", step.Name(), err)
+		}
+		m := out.Image.(*image.NRGBA)
+		if c := m.NRGBAAt(8, 8); c.R < 100 || c.R > 155 {
+			t.Errorf("%s: inside pixel = %v, want mid-gray", step.Name(), c)
+		}
+		if m.NRGBAAt(30, 8) != src.NRGBAAt(30, 8) || m.NRGBAAt(3, 4) != src.NRGBAAt(3, 4) {
+			t.Errorf("%s: pixels outside the region changed", step.Name())
+		}
+		if src.NRGBAAt(8, 8).R != 255 {
+			t.Fatalf("%s modified its input", step.Name())
+		}
+	}
+
+	out, err := imageprocessor.Pixelate(0).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil || out.Image != image.Image(src) {
+		t.Errorf("no rects: err %v, image replaced", err)
+	}
+}
+
+func TestColorEffects(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 100, 50, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{255, 255, 255, 128})
+	run := func(s core.Step) *image.NRGBA {
+		t.Helper()
+		out, err := s.Execute(context.Background(), &core.ImageData{Image: src})
+		if err != nil {
+			t.Fatalf("%s: %v", s.Name(), err)
+		}
+		return out.Image.(*image.NRGBA)
+	}
+
+	if got := run(imageprocessor.Invert()).NRGBAAt(0, 0); got != (color.NRGBA{55, 155, 205, 255}) {
+		t.Errorf("invert = %v", got)
+	}
+	sepia := run(imageprocessor.Sepia())
+	if c := sepia.NRGBAAt(0, 0); !(c.R > c.G && c.G > c.B) {
+		t.Errorf("sepia = %v, want warm tone", c)
+	}
+	if c := sepia.NRGBAAt(1, 0); c.A != 128 {
+		t.Errorf("sepia alpha = %d, want 128", c.A)
+	}
+	duo := run(imageprocessor.Duotone(color.NRGBA{0, 0, 128, 255}, color.NRGBA{255, 200, 0, 255}))
+	if c := duo.NRGBAAt(1, 0); c.R != 255 || c.G != 200 || c.B != 0 {
+		t.Errorf("duotone white = %v, want the light colour", c)
+	}
+	halfAlpha := run(imageprocessor.ColorMatrix([4][5]float64{{1, 0, 0, 0, 0}, {0, 1, 0, 0, 0}, {0, 0, 1, 0, 0}, {0, 0, 0, 0.5, 0}}))
+	if c := halfAlpha.NRGBAAt(0, 0); c.A != 128 || c.R != 200 {
+		t.Errorf("custom matrix = %v", c)
+	}
+}
+
+func TestGammaAndAutoLevel(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 1))
+	for x := 0; x < 100; x++ {
+		v := uint8(60 + x) // a dim, flat 60..159 ramp
+		src.SetNRGBA(x, 0, color.NRGBA{v, v, v, 255})
+	}
+	ctx := context.Background()
+
+	out, err := imageprocessor.Gamma(2.2).Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("gamma: %v", err)
+	}
+	if got := out.Image.(*image.NRGBA).NRGBAAt(0, 0).R; got <= 60 {
+		t.Errorf("gamma 2.2 on 60 = %d, want brighter", got)
+	}
+	if _, err := (&pipeline.GammaStep{Gamma: -1}).Execute(ctx, &core.ImageData{Image: src}); err == nil {
+		t.Error("negative gamma: expected an error")
+	}
+
+	out, err = imageprocessor.AutoLevel().Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("auto level: %v", err)
+	}
+	m := out.Image.(*image.NRGBA)
+	if lo, hi := m.NRGBAAt(0, 0).R, m.NRGBAAt(99, 0).R; lo > 5 || hi < 250 {
+		t.Errorf("auto level range = %d..%d, want ~0..255", lo, hi)
+	}
+	if src.NRGBAAt(0, 0).R != 60 {
+		t.Error("auto level modified its input")
+	}
+}
+
+func TestResize_PostSharpen(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 120, 40))
+	for y := 0; y < 40; y++ {
+		for x := 0; x < 120; x++ {
+			v := uint8(40)
+			if (x/6)%2 == 0 {
+				v = 220
+			}
+			src.Set(x, y, color.RGBA{v, v, v, 255})
+		}
+	}
+	contrast := func(img *core.ImageData) int {
+		m := img.Image.(*image.RGBA)
+		sum := 0
+		for x := 1; x < m.Rect.Dx(); x++ {
+			d := int(m.RGBAAt(x, 10).R) - int(m.RGBAAt(x-1, 10).R)
+			sum += max(d, -d)
+		}
+		return sum
+	}
+	ctx := context.Background()
+
+	plain, err := imageprocessor.Resize(60, 0).Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("resize: %v", err)
+	}
+	sharp, err := imageprocessor.ResizeSharp(60, 0, 0.8).Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("resize sharp: %v", err)
+	}
+	if contrast(sharp) <= contrast(plain) {
+		t.Errorf("sharpened contrast %d not above plain %d", contrast(sharp), contrast(plain))
+	}
+
+	cfg := imageprocessor.DefaultConfig()
+	cfg.PostSharpen = 0.8
+	proc := imageprocessor.New(cfg)
+	res, err := proc.Inner().ProcessImage(ctx, &core.ImageData{Image: src}, imageprocessor.Resize(60, 0))
+	if err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+	if got := contrast(res.Primary); got != contrast(sharp) {
+		t.Errorf("config default contrast %d, want %d as with ResizeSharp", got, contrast(sharp))
+	}
+	res, err = proc.Inner().ProcessImage(ctx, &core.ImageData{Image: src}, imageprocessor.ResizeSharp(60, 0, -1))
+	if err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+	if got := contrast(res.Primary); got != contrast(plain) {
+		t.Errorf("opted-out contrast %d, want %d", got, contrast(plain))
+	}
+}
+
+func TestLUT_CubeGrade(t *testing.T) {
+	// A 2-point LUT that swaps red and blue, with a title and comments.
+	var cube strings.Builder
+	cube.WriteString("# swap\nTITLE \"Swap RB\"\nLUT_3D_SIZE 2\n\n")
+	for b := 0; b < 2; b++ {
+		for g := 0; g < 2; g++ {
+			for r := 0; r < 2; r++ {
+				fmt.Fprintf(&cube, "%d.0 %d.0 %d.0\n", b, g, r)
+			}
+		}
+	}
+	path := filepath.Join(t.TempDir(), "swap.cube")
+	if err := os.WriteFile(path, []byte(cube.String()), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	step, err := imageprocessor.LoadLUT(path)
+	if err != nil {
+		t.Fatalf("LoadLUT: %v", err)
+	}
+
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{200, 100, 50, 255})
+	src.SetNRGBA(1, 0, color.NRGBA{10, 20, 240, 128})
+	out, err := step.Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(*image.NRGBA)
+	if got := m.NRGBAAt(0, 0); got != (color.NRGBA{50, 100, 200, 255}) {
+		t.Errorf("graded = %v, want red and blue swapped", got)
+	}
+	if got := m.NRGBAAt(1, 0); got != (color.NRGBA{240, 20, 10, 128}) {
+		t.Errorf("graded translucent = %v", got)
+	}
+
+	for name, bad := range map[string]string{
+		"1d":    "LUT_1D_SIZE 2\n0 0 0\n1 1 1\n",
+		"short": "LUT_3D_SIZE 2\n0 0 0\n",
+		"size":  "LUT_3D_SIZE 1\n0 0 0\n",
+	} {
+		if _, err := pipeline.ParseCubeLUT(strings.NewReader(bad)); err == nil {
+			t.Errorf("%s: expected a parse error", name)
+		}
+	}
+}
+
+func TestQuantize_PNG8(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			h := uint32(x*7919^y*104729) * 2654435761 // noisy, photo-like detail
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x*4) ^ uint8(h>>24)&15, uint8(y * 4), uint8(h >> 16), 255})
+		}
+	}
+	src.SetNRGBA(0, 0, color.NRGBA{}) // one transparent pixel
+	ctx := context.Background()
+
+	out, err := imageprocessor.Quantize(16, true).Execute(ctx, &core.ImageData{Image: src, Format: core.FormatPNG})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	pm, ok := out.Image.(*image.Paletted)
+	if !ok {
+		t.Fatalf("image is %T, want *image.Paletted", out.Image)
+	}
+	if len(pm.Palette) > 16 || !out.Meta.HasAlpha {
+		t.Errorf("palette %d colours, HasAlpha %v", len(pm.Palette), out.Meta.HasAlpha)
+	}
+	if _, _, _, a := pm.At(0, 0).RGBA(); a != 0 {
+		t.Error("transparent pixel lost")
+	}
+
+	full, err := encoder.NewPNG().Encode(ctx, &core.ImageData{Image: src}, core.EncodeOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := core.EncodeOptions{}
+	opts.PNG().Colors = 16
+	small, err := encoder.NewPNG().Encode(ctx, &core.ImageData{Image: src}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := png.DecodeConfig(bytes.NewReader(small))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p, ok := cfg.ColorModel.(color.Palette); !ok || len(p) > 16 {
+		t.Errorf("PNG8 colour model = %T", cfg.ColorModel)
+	}
+	if len(small) >= len(full) {
+		t.Errorf("PNG8 %d bytes, not smaller than truecolour %d", len(small), len(full))
+	}
+}
+
+func TestSeamCarve_KeepsSubjects(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 40))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{90, 140, 200, 255}), image.Point{}, draw.Src)
+	red := image.NewUniform(color.NRGBA{220, 30, 30, 255})
+	draw.Draw(src, image.Rect(5, 10, 20, 30), red, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(80, 10, 95, 30), red, image.Point{}, draw.Src)
+	countRed := func(m image.Image) int {
+		n := 0
+		b := m.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if r, g, _, _ := m.At(x, y).RGBA(); r>>8 > 200 && g>>8 < 60 {
+					n++
+				}
+			}
+		}
+		return n
+	}
+
+	out, err := imageprocessor.SeamCarve(60, 0).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(*image.NRGBA)
+	if m.Rect.Dx() != 60 || m.Rect.Dy() != 40 || out.Meta.Width != 60 {
+		t.Fatalf("size = %v, want 60x40", m.Rect)
+	}
+	if got, want := countRed(m), countRed(src); got != want {
+		t.Errorf("red pixels = %d, want both squares intact (%d)", got, want)
+	}
+
+	tall, err := imageprocessor.SeamCarve(100, 30).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if b := tall.Image.(*image.NRGBA).Rect; b.Dx() != 100 || b.Dy() != 30 {
+		t.Errorf("horizontal seams: size = %v, want 100x30", b)
+	}
+}
+
+func TestPerspective_RectifiesQuad(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 100, 80))
+	for y := 0; y < 80; y++ {
+		for x := 0; x < 100; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 2), uint8(y * 3), 7, 255})
+		}
+	}
+	ctx := context.Background()
+
+	// An axis-aligned quad is a plain crop.
+	out, err := imageprocessor.Perspective([4]image.Point{{10, 5}, {50, 5}, {50, 35}, {10, 35}}).
+		Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(*image.NRGBA)
+	if m.Rect.Dx() != 40 || m.Rect.Dy() != 30 {
+		t.Fatalf("size = %v, want 40x30", m.Rect)
+	}
+	if got, want := m.NRGBAAt(7, 9), src.NRGBAAt(17, 14); got != want {
+		t.Errorf("pixel = %v, want %v", got, want)
+	}
+
+	// A keystoned quad: its corners land on the output corners.
+	quad := [4]image.Point{{30, 10}, {70, 10}, {90, 70}, {10, 70}}
+	out, err = (&pipeline.PerspectiveStep{Quad: quad, Width: 50, Height: 50}).Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute keystone: %v", err)
+	}
+	m = out.Image.(*image.NRGBA)
+	for i, p := range [4]image.Point{{0, 0}, {49, 0}, {49, 49}, {0, 49}} {
+		got, want := m.NRGBAAt(p.X, p.Y), src.NRGBAAt(quad[i].X, quad[i].Y)
+		if d := int(got.R) - int(want.R); d < -6 || d > 6 || got.A != 255 {
+			t.Errorf("corner %d = %v, want about %v", i, got, want)
+		}
+	}
+
+	if _, err := imageprocessor.Perspective([4]image.Point{{0, 0}, {10, 10}, {20, 20}, {30, 30}}).
+		Execute(ctx, &core.ImageData{Image: src}); err == nil {
+		t.Error("degenerate quad: expected an error")
+	}
+}
+
+func TestComposite_BlendModes(t *testing.T) {
+	base := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(base, base.Bounds(), image.NewUniform(color.NRGBA{200, 100, 50, 255}), image.Point{}, draw.Src)
+	layer := image.NewNRGBA(image.Rect(0, 0, 4, 4))
+	draw.Draw(layer, layer.Bounds(), image.NewUniform(color.NRGBA{128, 255, 0, 255}), image.Point{}, draw.Src)
+	ctx := context.Background()
+
+	cases := []struct {
+		mode core.BlendMode
+		want color.NRGBA
+	}{
+		{imageprocessor.BlendOver, color.NRGBA{128, 255, 0, 255}},
+		{imageprocessor.BlendMultiply, color.NRGBA{100, 100, 0, 255}},
+		{imageprocessor.BlendScreen, color.NRGBA{228, 255, 50, 255}},
+		{imageprocessor.BlendDarken, color.NRGBA{128, 100, 0, 255}},
+		{imageprocessor.BlendLighten, color.NRGBA{200, 255, 50, 255}},
+	}
+	for _, tc := range cases {
+		out, err := imageprocessor.Composite(layer, core.GravitySouthEast, tc.mode, 0).
+			Execute(ctx, &core.ImageData{Image: base})
+		if err != nil {
+			t.Fatalf("%s: %v", tc.mode, err)
+		}
+		m := out.Image.(*image.NRGBA)
+		if got := m.NRGBAAt(18, 8); got != tc.want {
+			t.Errorf("%s: blended = %v, want %v", tc.mode, got, tc.want)
+		}
+		if got := m.NRGBAAt(15, 8); got != base.NRGBAAt(15, 8) {
+			t.Errorf("%s: pixel outside the layer changed to %v", tc.mode, got)
+		}
+	}
+
+	half, err := (&pipeline.CompositeStep{Overlay: layer, OffsetX: 2, Opacity: 0.5}).Execute(ctx, &core.ImageData{Image: base})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := half.Image.(*image.NRGBA).NRGBAAt(2, 0); got.R != 164 || got.G != 178 {
+		t.Errorf("half opacity = %v, want the midpoint", got)
+	}
+	if _, err := imageprocessor.Composite(layer, "", "overlay", 1).Execute(ctx, &core.ImageData{Image: base}); err == nil {
+		t.Error("unknown blend mode: expected an error")
+	}
+}
+
+func TestVignetteAndGradientOverlay(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 40, 20))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{200, 200, 200, 255}), image.Point{}, draw.Src)
+	ctx := context.Background()
+
+	out, err := imageprocessor.Vignette(0.8).Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("vignette: %v", err)
+	}
+	m := out.Image.(*image.NRGBA)
+	if c := m.NRGBAAt(20, 10); c.R != 200 {
+		t.Errorf("vignette centre = %v, want unchanged", c)
+	}
+	if c := m.NRGBAAt(0, 0); c.R > 60 {
+		t.Errorf("vignette corner = %v, want dark", c)
+	}
+
+	out, err = imageprocessor.GradientOverlay(nil, color.Black, core.GravitySouth).Execute(ctx, &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("gradient: %v", err)
+	}
+	m = out.Image.(*image.NRGBA)
+	top, mid, bottom := m.NRGBAAt(5, 0).R, m.NRGBAAt(5, 10).R, m.NRGBAAt(5, 19).R
+	if !(top > mid && mid > bottom) || top < 190 || bottom > 10 {
+		t.Errorf("gradient top/mid/bottom = %d/%d/%d, want light to black", top, mid, bottom)
+	}
+	if m.NRGBAAt(0, 10) != m.NRGBAAt(39, 10) {
+		t.Error("vertical gradient varies across a row")
+	}
+}
+
+func TestCLAHE_StretchesLocalContrast(t *testing.T) {
+	// A dim scan: faint 20-40 texture on the left, a bright block on the right.
+	src := image.NewNRGBA(image.Rect(0, 0, 64, 32))
+	for y := 0; y < 32; y++ {
+		for x := 0; x < 64; x++ {
+			v := uint8(20 + (x*7+y*13)%21)
+			if x >= 32 {
+				v = 210
+			}
+			src.SetNRGBA(x, y, color.NRGBA{v, v, v, 255})
+		}
+	}
+	spread := func(m *image.NRGBA) int {
+		lo, hi := 255, 0
+		for y := 4; y < 28; y++ {
+			for x := 4; x < 24; x++ {
+				v := int(m.NRGBAAt(x, y).R)
+				lo, hi = min(lo, v), max(hi, v)
+			}
+		}
+		return hi - lo
+	}
+
+	out, err := imageprocessor.CLAHE(0, 16).Execute(context.Background(), &core.ImageData{Image: src})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	m := out.Image.(*image.NRGBA)
+	if before, after := spread(src), spread(m); after < 2*before {
+		t.Errorf("texture spread %d -> %d, want it at least doubled", before, after)
+	}
+	if c := m.NRGBAAt(0, 0); c.R != c.G || c.G != c.B {
+		t.Errorf("gray input gained a tint: %v", c)
+	}
+}
+
+func TestSpec_ParsesJSONAndYAML(t *testing.T) {
+	const doc = `
+# listing thumbnails
+steps:
+  - type: decode
+  - type: resize
+    width: 40
+  - {type: format, format: png}
+variants:
+  - name: thumb
+    steps:
+      - type: thumbnail
+        size: 10
+        background: "#ffffff80"
+      - type: encode
+`
+	fromYAML, err := spec.Parse([]byte(doc))
+	if err != nil {
+		t.Fatalf("Parse YAML: %v", err)
+	}
+	fromJSON, err := spec.Parse([]byte(`{
+		"steps": [{"type": "decode"}, {"type": "resize", "width": 40}, {"type": "format", "format": "png"}],
+		"variants": [{"name": "thumb", "steps": [
+			{"type": "thumbnail", "size": 10, "background": "#ffffff80"}, {"type": "encode"}]}]
+	}`))
+	if err != nil {
+		t.Fatalf("Parse JSON: %v", err)
+	}
+	for _, s := range []*spec.Spec{fromYAML, fromJSON} {
+		if len(s.Steps) != 3 || len(s.Variants) != 1 || s.Variants[0].Name != "thumb" {
+			t.Fatalf("parsed %d steps and variants %+v", len(s.Steps), s.Variants)
+		}
+		if r, ok := s.Steps[1].(*pipeline.ResizeStep); !ok || r.Width != 40 {
+			t.Errorf("steps[1] = %#v, want resize to width 40", s.Steps[1])
+		}
+		if th := s.Variants[0].Steps[0].(*pipeline.ThumbnailStep); th.Background != (color.NRGBA{255, 255, 255, 128}) {
+			t.Errorf("thumbnail background = %v", th.Background)
+		}
+	}
+
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	src := imageprocessor.FromReader(bytes.NewReader(testutil.EncodePNG(t, testutil.Gradient(80, 40))))
+	res, err := proc.ProcessVariants(context.Background(), src, fromYAML.Steps, fromYAML.Variants)
+	if err != nil {
+		t.Fatalf("ProcessVariants: %v", err)
+	}
+	if m := res.Primary.Meta; m.Width != 40 || m.Height != 20 {
+		t.Errorf("primary is %dx%d, want 40x20", m.Width, m.Height)
+	}
+	thumb, err := png.DecodeConfig(bytes.NewReader(res.Variants["thumb"].Data))
+	if err != nil || thumb.Width != 10 || thumb.Height != 10 {
+		t.Errorf("thumb = %+v, %v; want a 10x10 PNG", thumb, err)
+	}
+
+	for _, bad := range []string{
+		`{"steps": [{"type": "sharpen_more"}]}`,
+		`{"steps": [{"type": "resize", "widht": 40}]}`,
+		`{"steps": [{"type": "resize", "width": "wide"}]}`,
+		"steps:\n  - type: resize\n     width: 40\n",
+	} {
+		if _, err := spec.Parse([]byte(bad)); !apperrors.IsCategory(err, apperrors.CategoryConfig) {
+			t.Errorf("Parse(%q) = %v, want a config error", bad, err)
+		}
+	}
+}
+
+type stampStep struct{ label string }
+
+func (s *stampStep) Name() string { return "stamp" }
+
+func (s *stampStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	out := *img
+	out.Attrs = out.Attrs.With("stamp", s.label)
+	return &out, nil
+}
+
+func TestRegisterStep_CustomFactory(t *testing.T) {
+	core.RegisterStep("stamp", func(params map[string]any) (core.Step, error) {
+		label, ok := params["label"].(string)
+		if !ok {
+			return nil, fmt.Errorf("label: want a string")
+		}
+		return &stampStep{label: label}, nil
+	})
+	if names := core.StepNames(); !slices.Contains(names, "stamp") || !slices.Contains(names, "resize") {
+		t.Fatalf("StepNames() = %v, want stamp and the built-in resize", names)
+	}
+
+	s, err := spec.Parse([]byte("steps:\n  - type: stamp\n    label: reviewed\n"))
+	if err != nil {
+		t.Fatalf("Parse: %v", err)
+	}
+	out, err := s.Steps[0].Execute(context.Background(), &core.ImageData{})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := out.Attrs["stamp"]; got != "reviewed" {
+		t.Errorf("stamp attribute = %v, want reviewed", got)
+	}
+
+	if _, err := core.NewStep("stamp", nil); err == nil {
+		t.Error("NewStep without label succeeded")
+	}
+	step, err := core.NewStep("resize", map[string]any{"width": 32.0})
+	if r, ok := step.(*pipeline.ResizeStep); err != nil || !ok || r.Width != 32 {
+		t.Errorf("NewStep(resize) = %#v, %v", step, err)
+	}
+}
+
+func TestConditionalSteps(t *testing.T) {
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	flattened := false
+	run := func(w, h int, alpha bool) *core.ImageData {
+		t.Helper()
+		img := testutil.Gradient(w, h)
+		if alpha {
+			img = testutil.AlphaGradient(w, h)
+		}
+		res, err := proc.Process(context.Background(),
+			imageprocessor.FromReader(bytes.NewReader(testutil.EncodePNG(t, img))),
+			imageprocessor.Decode(),
+			pipeline.If(pipeline.WidthGreaterThan(50), imageprocessor.Resize(50, 0)),
+			pipeline.If(pipeline.HasAlpha(), &conditionProbe{hit: &flattened}, imageprocessor.Flatten(nil)),
+			pipeline.Unless(pipeline.FormatIs(core.FormatJPEG), imageprocessor.ConvertFormat(core.FormatWebP)),
+			pipeline.If(pipeline.SizeOver(1<<30), imageprocessor.Grayscale()),
+		)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		return res.Primary
+	}
+
+	big := run(80, 40, false)
+	if big.Meta.Width != 50 || big.Meta.Height != 25 {
+		t.Errorf("80x40 became %dx%d, want 50x25", big.Meta.Width, big.Meta.Height)
+	}
+	if big.Meta.Format != core.FormatWebP {
+		t.Errorf("format = %s, want webp from the Unless branch", big.Meta.Format)
+	}
+	if flattened {
+		t.Error("opaque image was flattened")
+	}
+	small := run(30, 20, true)
+	if small.Meta.Width != 30 || small.Meta.Height != 20 {
+		t.Errorf("30x20 became %dx%d, want it untouched", small.Meta.Width, small.Meta.Height)
+	}
+	if !flattened || small.Meta.HasAlpha {
+		t.Errorf("translucent image: flattened %v, HasAlpha %v", flattened, small.Meta.HasAlpha)
+	}
+}
+
+func TestTee_AttachesSideBranches(t *testing.T) {
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	src := func() core.Source {
+		return imageprocessor.FromReader(bytes.NewReader(testutil.EncodePNG(t, testutil.Gradient(80, 40))))
+	}
+	res, err := proc.Process(context.Background(), src(),
+		imageprocessor.Decode(),
+		imageprocessor.Resize(40, 0),
+		imageprocessor.Tee("thumb", imageprocessor.Thumbnail(8), imageprocessor.Encode()),
+		imageprocessor.Grayscale(),
+		imageprocessor.Encode(),
+	)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if res.Primary.Meta.Width != 40 || res.Primary.Branches != nil {
+		t.Errorf("primary width %d, branches %v", res.Primary.Meta.Width, res.Primary.Branches)
+	}
+	thumb := res.Variants["thumb"]
+	if thumb == nil || thumb.Meta.Width != 8 || len(thumb.Data) == 0 {
+		t.Fatalf("thumb variant = %+v, want an encoded 8px image", thumb)
+	}
+	if m, _, err := image.Decode(bytes.NewReader(thumb.Data)); err != nil {
+		t.Errorf("decode thumb: %v", err)
+	} else if r, g, b, _ := m.At(7, 4).RGBA(); r == g && g == b {
+		t.Error("thumb is gray; the branch should fork before Grayscale")
+	}
+
+	res, err = proc.ProcessVariants(context.Background(), src(),
+		[]core.Step{imageprocessor.Decode(), imageprocessor.Tee("side", imageprocessor.Resize(10, 0))},
+		[]core.VariantDefinition{{Name: "small", Steps: []core.Step{imageprocessor.Resize(20, 0)}}})
+	if err != nil {
+		t.Fatalf("ProcessVariants: %v", err)
+	}
+	if res.Variants["side"] == nil || res.Variants["small"] == nil {
+		t.Errorf("variants = %v, want side and small", slices.Collect(maps.Keys(res.Variants)))
+	}
+}
+
+func TestPresets_ProcessAndSubmitByName(t *testing.T) {
+	proc := newProc(t)
+	proc.RegisterPreset("avatar", imageprocessor.Decode(), imageprocessor.Thumbnail(32), imageprocessor.Encode())
+	proc.RegisterPreset("hero", imageprocessor.Decode(), imageprocessor.Resize(60, 0))
+	if got := proc.Presets(); !slices.Equal(got, []string{"avatar", "hero"}) {
+		t.Errorf("Presets() = %v", got)
+	}
+
+	res, err := proc.ProcessPreset(context.Background(),
+		imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 100, 80))), "avatar")
+	if err != nil {
+		t.Fatalf("ProcessPreset: %v", err)
+	}
+	if m := res.Primary.Meta; m.Width != 32 || m.Height != 32 || len(res.Primary.Data) == 0 {
+		t.Errorf("avatar = %dx%d with %d bytes", m.Width, m.Height, len(res.Primary.Data))
+	}
+
+	resultCh := make(chan core.JobResult, 1)
+	err = proc.SubmitPreset("hero", core.Job{
+		ID:       "hero-1",
+		Ctx:      context.Background(),
+		Source:   imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 100, 80))),
+		Steps:    []core.Step{imageprocessor.Grayscale()},
+		ResultCh: resultCh,
+	})
+	if err != nil {
+		t.Fatalf("SubmitPreset: %v", err)
+	}
+	select {
+	case r := <-resultCh:
+		if r.Err != nil {
+			t.Fatalf("job: %v", r.Err)
+		}
+		if m := r.Result.Primary.Meta; m.Width != 60 || m.ColorSpace != core.ColorSpaceGray {
+			t.Errorf("hero job = %dx%d %s, want 60 wide and gray", m.Width, m.Height, m.ColorSpace)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("preset job timed out")
+	}
+
+	_, err = proc.ProcessPreset(context.Background(), imageprocessor.FromReader(bytes.NewReader(nil)), "og-image")
+	if !errors.Is(err, apperrors.ErrUnknownPreset) {
+		t.Errorf("unknown preset: %v, want ErrUnknownPreset", err)
+	}
+}
+
+func TestProcessStream_WritesEncodedOutput(t *testing.T) {
+	proc := newProc(t)
+	raw := testutil.EncodePNG(t, testutil.Gradient(120, 60))
+	steps := []core.Step{imageprocessor.Decode(), imageprocessor.Resize(60, 0), imageprocessor.ConvertFormat(core.FormatJPEG),
+		imageprocessor.EncodeOpts(core.EncodeOptions{Quality: 70})}
+
+	want, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw)), steps...)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	var buf bytes.Buffer
+	res, err := proc.ProcessStream(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw)), &buf, steps...)
+	if err != nil {
+		t.Fatalf("ProcessStream: %v", err)
+	}
+	if !bytes.Equal(buf.Bytes(), want.Primary.Data) {
+		t.Errorf("streamed %d bytes, differing from the %d bytes Process returns", buf.Len(), len(want.Primary.Data))
+	}
+	if res.Primary.Data != nil || res.Primary.Meta.SizeBytes != int64(buf.Len()) {
+		t.Errorf("primary kept %d bytes of data, SizeBytes %d", len(res.Primary.Data), res.Primary.Meta.SizeBytes)
+	}
+
+	// Without a trailing Encode the image is encoded with defaults.
+	buf.Reset()
+	if _, err := proc.ProcessStream(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw)), &buf,
+		imageprocessor.Decode()); err != nil {
+		t.Fatalf("ProcessStream without Encode: %v", err)
+	}
+	if cfg, err := png.DecodeConfig(&buf); err != nil || cfg.Width != 120 {
+		t.Errorf("default encode = %+v, %v; want a 120px PNG", cfg, err)
+	}
+
+	_, err = proc.ProcessStream(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw)), failingWriter{},
+		imageprocessor.Decode())
+	if !apperrors.IsCategory(err, apperrors.CategoryEncode) {
+		t.Errorf("failing writer: %v, want an encode error", err)
+	}
+
+	// The encode is a step of the run: hooks see it, and a result cache
+	// never answers a later stream with an empty result.
+	m := hooks.NewInMemoryMetrics()
+	proc.AddHook(hooks.NewMetricsHook(m))
+	proc.SetResultCache(core.NewMemoryCache(8), time.Minute)
+	for i := range 2 {
+		buf.Reset()
+		if _, err := proc.ProcessStream(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw)), &buf,
+			steps...); err != nil || !bytes.Equal(buf.Bytes(), want.Primary.Data) {
+			t.Fatalf("cached stream %d: %v, wrote %d bytes", i, err, buf.Len())
+		}
+	}
+	if calls := m.Snapshot().StepCalls["encode"]; calls != 2 {
+		t.Errorf("hooks saw %d encodes, want 2", calls)
+	}
+
+	// The memory budget covers the encode.
+	cfg := imageprocessor.DefaultConfig()
+	cfg.MemoryBudget = 120 * 60 * 4
+	budgeted := imageprocessor.New(cfg)
+	entered := make(chan struct{}, 2)
+	release := make(chan struct{})
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := budgeted.ProcessStream(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw)),
+				&gateWriter{entered: entered, release: release}, imageprocessor.Decode())
+			errs <- err
+		}()
+	}
+	<-entered
+	select {
+	case <-entered:
+		t.Fatal("second stream encoded while the first held the budget")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(release)
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("budgeted stream: %v", err)
+		}
+	}
+}
+
+// gateWriter signals entered on its first write and holds it until
+// release is closed.
+type gateWriter struct {
+	entered chan<- struct{}
+	release <-chan struct{}
+	started bool
+}
+
+func (g *gateWriter) Write(b []byte) (int, error) {
+	if !g.started {
+		g.started = true
+		g.entered <- struct{}{}
+		<-g.release
+	}
+	return len(b), nil
+}
+
+type failingWriter struct{}
+
+func (failingWriter) Write([]byte) (int, error) { return 0, errors.New("connection reset") }
+
+func TestDecodedImage_StdlibAndConverters(t *testing.T) {
+	cases := []struct {
+		img  core.DecodedImage
+		want core.ColorSpace
+	}{
+		{image.NewGray(image.Rect(0, 0, 2, 2)), core.ColorSpaceGray},
+		{image.NewCMYK(image.Rect(0, 0, 2, 2)), core.ColorSpaceCMYK},
+		{image.NewYCbCr(image.Rect(0, 0, 2, 2), image.YCbCrSubsampleRatio420), core.ColorSpaceRGB},
+		{testutil.Gradient(2, 2), core.ColorSpaceRGB},
+		{testutil.AlphaGradient(2, 2), core.ColorSpaceRGBA},
+		{fakeBackendImage{}, core.ColorSpaceGray},
+	}
+	for _, c := range cases {
+		if got := core.ColorModelHint(c.img); got != c.want {
+			t.Errorf("ColorModelHint(%T) = %q, want %q", c.img, got, c.want)
+		}
+		std, err := core.ToStdImage(c.img)
+		if err != nil || std.Bounds() != c.img.Bounds() {
+			t.Errorf("ToStdImage(%T) = %v, %v", c.img, std, err)
+		}
+	}
+	if _, err := core.ToStdImage(nil); err == nil {
+		t.Error("ToStdImage(nil) succeeded")
+	}
+}
+
+// fakeBackendImage stands in for a non-stdlib buffer such as *vips.VipsImage.
+type fakeBackendImage struct{}
+
+func (fakeBackendImage) Bounds() image.Rectangle         { return image.Rect(0, 0, 3, 1) }
+func (fakeBackendImage) ColorModelHint() core.ColorSpace { return core.ColorSpaceGray }
+func (fakeBackendImage) ToStdImage() (image.Image, error) {
+	return image.NewGray(image.Rect(0, 0, 3, 1)), nil
+}
+
+func TestStdImage_ConvertsOtherBackends(t *testing.T) {
+	m := hooks.NewInMemoryMetrics()
+	proc := newProc(t)
+	proc.AddHook(hooks.NewMetricsHook(m))
+
+	img := &core.ImageData{Image: fakeBackendImage{}, Meta: core.Metadata{Width: 3, Height: 1}}
+	res, err := proc.Inner().ProcessImage(context.Background(), img,
+		imageprocessor.Grayscale(), imageprocessor.Crop(0, 0, 2, 1))
+	if err != nil {
+		t.Fatalf("ProcessImage: %v", err)
+	}
+	if got := res.Primary.Image.Bounds(); got.Dx() != 2 || got.Dy() != 1 {
+		t.Errorf("bounds = %v, want 2x1", got)
+	}
+	if calls := m.Snapshot().StepCalls["convert.to_std"]; calls != 1 {
+		t.Errorf("convert.to_std recorded %d times, want 1", calls)
+	}
+
+	if _, err := core.StdImage(context.Background(), &core.ImageData{}); !errors.Is(err, apperrors.ErrEmptyInput) {
+		t.Errorf("StdImage(empty) err = %v, want ErrEmptyInput", err)
+	}
+}
+
+func TestThumbnailStep_ContainsOtherBackendsAtBoxSize(t *testing.T) {
+	img := &core.ImageData{Image: fakeBackendImage{}, Meta: core.Metadata{Width: 3, Height: 1}}
+	out, err := (&pipeline.ThumbnailStep{Width: 3, Height: 1, Fit: pipeline.FitContain}).Execute(context.Background(), img)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if b := out.Image.Bounds(); b.Dx() != 3 || b.Dy() != 1 {
+		t.Errorf("thumbnail is %v, want 3x1", b)
+	}
+}
+
+func TestSubmit_HigherPriorityJobsRunFirst(t *testing.T) {
+	cfg := imageprocessor.DefaultConfig()
+	cfg.WorkerCount = 1
+	proc := imageprocessor.New(cfg)
+	proc.Start()
+	t.Cleanup(func() { proc.Stop(context.Background()) })
+
+	started, release := make(chan struct{}), make(chan struct{})
+	results := make(chan core.JobResult, 4)
+	submit := func(id string, pr core.Priority, steps ...core.Step) {
+		t.Helper()
+		err := proc.Submit(core.Job{
+			ID:       id,
+			Ctx:      context.Background(),
+			Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+			Steps:    append(steps, &passStep{}),
+			Priority: pr,
+			ResultCh: results,
+		})
+		if err != nil {
+			t.Fatalf("Submit(%s): %v", id, err)
+		}
+	}
+	// Occupy the only worker so the rest queue up.
+	submit("busy", imageprocessor.PriorityNormal, &gateStep{started: started, release: release})
+	<-started
+	submit("bulk", imageprocessor.PriorityLow)
+	submit("default", "")
+	submit("thumb", imageprocessor.PriorityHigh)
+	close(release)
+
+	var order []string
+	for range 4 {
+		select {
+		case r := <-results:
+			if r.Err != nil {
+				t.Fatalf("job %s: %v", r.JobID, r.Err)
+			}
+			order = append(order, r.JobID)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("jobs timed out; finished %v", order)
+		}
+	}
+	if want := []string{"busy", "thumb", "default", "bulk"}; !slices.Equal(order, want) {
+		t.Errorf("completion order = %v, want %v", order, want)
+	}
+
+	err := proc.Submit(core.Job{Ctx: context.Background(), Priority: "urgent"})
+	if !apperrors.IsCategory(err, apperrors.CategoryConfig) {
+		t.Errorf("unknown priority: %v, want config error", err)
+	}
+}
+
+func TestJobStatus_TracksAndCancelsJobs(t *testing.T) {
+	cfg := imageprocessor.DefaultConfig()
+	cfg.WorkerCount = 1
+	proc := imageprocessor.New(cfg)
+	proc.Start()
+	t.Cleanup(func() { proc.Stop(context.Background()) })
+
+	started, release := make(chan struct{}), make(chan struct{})
+	results := make(chan core.JobResult, 3)
+	submit := func(id string, steps ...core.Step) {
+		t.Helper()
+		err := proc.Submit(core.Job{
+			ID:       id,
+			Ctx:      context.Background(),
+			Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+			Steps:    steps,
+			ResultCh: results,
+		})
+		if err != nil {
+			t.Fatalf("Submit(%s): %v", id, err)
+		}
+	}
+	submit("running", &gateStep{started: started, release: release}, &passStep{})
+	<-started
+	submit("waiting", &passStep{})
+	submit("ok", &passStep{}, &passStep{})
+
+	if err := proc.Submit(core.Job{ID: "ok", Ctx: context.Background()}); err == nil {
+		t.Error("Submit with the ID of a queued job succeeded")
+	}
+	for id, want := range map[string]core.JobState{"running": core.JobRunning, "waiting": core.JobQueued} {
+		if s, ok := proc.JobStatus(id); !ok || s.State != want {
+			t.Errorf("JobStatus(%s) = %+v, %v; want %s", id, s, ok, want)
+		}
+	}
+	for _, id := range []string{"running", "waiting"} {
+		if err := proc.CancelJob(id); err != nil {
+			t.Fatalf("CancelJob(%s): %v", id, err)
+		}
+	}
+	close(release)
+
+	for range 3 {
+		select {
+		case r := <-results:
+			if canceled := r.JobID != "ok"; canceled != errors.Is(r.Err, context.Canceled) {
+				t.Errorf("job %s: err = %v", r.JobID, r.Err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("jobs timed out")
+		}
+	}
+	if s, _ := proc.JobStatus("ok"); s.State != core.JobDone || s.Progress != 1 || s.Finished.IsZero() {
+		t.Errorf("JobStatus(ok) = %+v, want done", s)
+	}
+	if s, _ := proc.JobStatus("waiting"); s.State != core.JobCanceled || !s.Started.IsZero() || s.Finished.IsZero() {
+		t.Errorf("JobStatus(waiting) = %+v, want canceled", s)
+	}
+	if err := proc.CancelJob("missing"); !errors.Is(err, apperrors.ErrJobNotFound) {
+		t.Errorf("CancelJob(missing) = %v, want ErrJobNotFound", err)
+	}
+}
+
+func TestSubmitAt_RunsScheduledJobsWhenDue(t *testing.T) {
+	proc := newProc(t)
+	results := make(chan core.JobResult, 3)
+	job := func(id string) core.Job {
+		return core.Job{
+			ID:       id,
+			Ctx:      context.Background(),
+			Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+			Steps:    []core.Step{&passStep{}},
+			ResultCh: results,
+		}
+	}
+	start := time.Now()
+	if err := proc.SubmitAfter(job("soon"), 50*time.Millisecond); err != nil {
+		t.Fatalf("SubmitAfter: %v", err)
+	}
+	if err := proc.SubmitAt(job("tonight"), start.Add(time.Hour)); err != nil {
+		t.Fatalf("SubmitAt: %v", err)
+	}
+	if err := proc.SubmitAt(job("overdue"), start.Add(-time.Minute)); err != nil {
+		t.Fatalf("SubmitAt: %v", err)
+	}
+	if s, _ := proc.JobStatus("tonight"); s.State != core.JobScheduled || !s.Scheduled.Equal(start.Add(time.Hour)) {
+		t.Errorf("JobStatus(tonight) = %+v, want scheduled", s)
+	}
+	if err := proc.CancelJob("tonight"); err != nil {
+		t.Fatalf("CancelJob: %v", err)
+	}
+
+	got := map[string]time.Duration{}
+	for range 3 {
+		select {
+		case r := <-results:
+			if canceled := r.JobID == "tonight"; canceled != errors.Is(r.Err, context.Canceled) {
+				t.Errorf("job %s: err = %v", r.JobID, r.Err)
+			}
+			got[r.JobID] = time.Since(start)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("scheduled jobs timed out; finished %v", got)
+		}
+	}
+	if got["soon"] < 50*time.Millisecond {
+		t.Errorf("soon ran after %v, before its delay", got["soon"])
+	}
+	if got["overdue"] > got["soon"] {
+		t.Errorf("overdue job ran after the delayed one")
+	}
+}
+
+func TestAutoscale_GrowsAndShrinksWithQueueDepth(t *testing.T) {
+	cfg := imageprocessor.DefaultConfig()
+	cfg.MinWorkers, cfg.MaxWorkers = 1, 4
+	cfg.ScaleInterval = 10 * time.Millisecond
+	proc := imageprocessor.New(cfg)
+	proc.Start()
+	t.Cleanup(func() { proc.Stop(context.Background()) })
+	if n := proc.PoolStats().Workers; n != 1 {
+		t.Fatalf("pool starts with %d workers, want MinWorkers", n)
+	}
+
+	started, release := make(chan struct{}, 8), make(chan struct{})
+	results := make(chan core.JobResult, 8)
+	for range 8 {
+		err := proc.Submit(core.Job{
+			Ctx:      context.Background(),
+			Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+			Steps:    []core.Step{&gateStep{started: started, release: release}},
+			ResultCh: results,
+		})
+		if err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	waitFor := func(what string, cond func(core.PoolStats) bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond(proc.PoolStats()) {
+			if time.Now().After(deadline) {
+				t.Fatalf("%s: pool = %+v", what, proc.PoolStats())
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitFor("grow to MaxWorkers", func(s core.PoolStats) bool { return s.Workers == 4 && s.Busy == 4 })
+
+	close(release)
+	for range 8 {
+		if r := <-results; r.Err != nil {
+			t.Fatalf("job: %v", r.Err)
+		}
+	}
+	waitFor("shrink to MinWorkers", func(s core.PoolStats) bool { return s.Workers == 1 && s.Queued == 0 })
+}
+
+func TestMemoryQueue_BoundsAllPriorities(t *testing.T) {
+	ctx := context.Background()
+	job := func(id string, pr core.Priority) core.Job { return core.Job{ID: id, Priority: pr} }
+	q := core.NewMemoryQueue(2)
+	for _, j := range []core.Job{job("h1", core.PriorityHigh), job("l1", core.PriorityLow)} {
+		if err := q.Enqueue(ctx, j); err != nil {
+			t.Fatalf("Enqueue(%s): %v", j.ID, err)
+		}
+	}
+	if err := q.Enqueue(ctx, job("n0", core.PriorityNormal)); !errors.Is(err, apperrors.ErrWorkerPoolFull) {
+		t.Errorf("third job = %v, want ErrWorkerPoolFull", err)
+	}
+	if q.Len() != 2 {
+		t.Errorf("Len = %d, want 2", q.Len())
+	}
+
+	// Eviction drops the oldest job of the same priority, else of a lower
+	// one, and never one of a higher priority.
+	for _, tc := range []struct {
+		job     core.Job
+		dropped string
+	}{
+		{job("l2", core.PriorityLow), "l1"},
+		{job("n1", core.PriorityNormal), "l2"},
+		{job("l3", core.PriorityLow), ""},
+	} {
+		evicted, err := q.EnqueueEvict(ctx, tc.job)
+		if tc.dropped == "" {
+			if !errors.Is(err, apperrors.ErrWorkerPoolFull) {
+				t.Errorf("EnqueueEvict(%s) = %v, want ErrWorkerPoolFull", tc.job.ID, err)
+			}
+			continue
+		}
+		if err != nil || len(evicted) != 1 || evicted[0].ID != tc.dropped {
+			t.Errorf("EnqueueEvict(%s) = %v, %v; want %s dropped", tc.job.ID, evicted, err, tc.dropped)
+		}
+	}
+
+	// A dequeue frees room for a waiting job.
+	waited := make(chan error, 1)
+	go func() { waited <- q.EnqueueWait(ctx, job("waiting", core.PriorityLow)) }()
+	for _, want := range []string{"h1", "n1", "waiting"} {
+		if got, err := q.Dequeue(ctx); err != nil || got.ID != want {
+			t.Fatalf("Dequeue = %q, %v; want %s", got.ID, err, want)
+		}
+	}
+	if err := <-waited; err != nil {
+		t.Errorf("EnqueueWait: %v", err)
+	}
+}
+
+func TestQueueFullPolicies(t *testing.T) {
+	// busyProc returns a processor whose only worker is held by a job and
+	// whose queue of one is full.
+	busyProc := func(policy config.QueueFullPolicy) (*imageprocessor.Processor, chan core.JobResult, chan struct{}) {
+		cfg := imageprocessor.DefaultConfig()
+		cfg.WorkerCount, cfg.QueueSize, cfg.QueueFull = 1, 1, policy
+		proc := imageprocessor.New(cfg)
+		proc.Start()
+		t.Cleanup(func() { proc.Stop(context.Background()) })
+		started, release := make(chan struct{}, 1), make(chan struct{})
+		results := make(chan core.JobResult, 4)
+		for _, id := range []string{"busy", "queued"} {
+			err := proc.Submit(core.Job{
+				ID:       id,
+				Ctx:      context.Background(),
+				Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+				Steps:    []core.Step{&gateStep{started: started, release: release}},
+				ResultCh: results,
+			})
+			if err != nil {
+				t.Fatalf("Submit(%s): %v", id, err)
+			}
+			if id == "busy" {
+				<-started
+			}
+		}
+		return proc, results, release
+	}
+	extra := func(results chan core.JobResult) core.Job {
+		return core.Job{
+			ID:       "extra",
+			Ctx:      context.Background(),
+			Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+			Steps:    []core.Step{&passStep{}},
+			ResultCh: results,
+		}
+	}
+
+	proc, results, release := busyProc(config.QueueFullReject)
+	if err := proc.Submit(extra(results)); !errors.Is(err, apperrors.ErrWorkerPoolFull) {
+		t.Errorf("reject: Submit = %v, want ErrWorkerPoolFull", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := proc.SubmitWait(ctx, extra(results)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("SubmitWait past its deadline = %v", err)
+	}
+	if _, ok := proc.JobStatus("extra"); ok {
+		t.Error("refused job is still tracked")
+	}
+	done := make(chan error, 1)
+	go func() { done <- proc.SubmitWait(context.Background(), extra(results)) }()
+	close(release)
+	if err := <-done; err != nil {
+		t.Errorf("SubmitWait = %v once the queue drained", err)
+	}
+
+	proc, results, release = busyProc(config.QueueFullDropOldest)
+	if err := proc.Submit(extra(results)); err != nil {
+		t.Fatalf("drop_oldest: Submit = %v", err)
+	}
+	if r := <-results; r.JobID != "queued" || !errors.Is(r.Err, apperrors.ErrJobDropped) {
+		t.Errorf("drop_oldest: first result = %s %v, want queued job dropped", r.JobID, r.Err)
+	}
+	close(release)
+	for range 2 {
+		if r := <-results; r.Err != nil {
+			t.Errorf("job %s: %v", r.JobID, r.Err)
+		}
+	}
+}
+
+func TestStop_DrainsThenAbandonsWithTerminalErrors(t *testing.T) {
+	cfg := imageprocessor.DefaultConfig()
+	cfg.WorkerCount = 1
+	results := make(chan core.JobResult, 8)
+	job := func(id string, steps ...core.Step) core.Job {
+		return core.Job{
+			ID:       id,
+			Ctx:      context.Background(),
+			Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+			Steps:    append(steps, &passStep{}),
+			ResultCh: results,
+		}
+	}
+
+	// With time to spare, queued jobs run to completion.
+	proc := imageprocessor.New(cfg)
+	proc.Start()
+	for _, id := range []string{"a", "b", "c"} {
+		if err := proc.Submit(job(id)); err != nil {
+			t.Fatalf("Submit: %v", err)
+		}
+	}
+	report, err := proc.Stop(context.Background())
+	if err != nil || report.Drained != 3 || len(report.Abandoned) != 0 {
+		t.Fatalf("Stop = %+v, %v; want 3 drained", report, err)
+	}
+	for range 3 {
+		if r := <-results; r.Err != nil {
+			t.Errorf("job %s: %v", r.JobID, r.Err)
+		}
+	}
+	if err := proc.Submit(job("late")); !errors.Is(err, apperrors.ErrProcessorStopped) {
+		t.Errorf("Submit after Stop = %v, want ErrProcessorStopped", err)
+	}
+
+	// Past the deadline, the running, queued and scheduled jobs all fail.
+	proc = imageprocessor.New(cfg)
+	proc.Start()
+	started := make(chan struct{}, 1)
+	if err := proc.Submit(job("running", &gateStep{started: started, release: make(chan struct{})})); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	<-started
+	if err := proc.Submit(job("queued")); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if err := proc.SubmitAfter(job("later"), time.Hour); err != nil {
+		t.Fatalf("SubmitAfter: %v", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	report, err = proc.Stop(ctx)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Stop = %v, want deadline exceeded", err)
+	}
+	slices.Sort(report.Abandoned)
+	if want := []string{"later", "queued", "running"}; !slices.Equal(report.Abandoned, want) {
+		t.Errorf("abandoned %v, want %v", report.Abandoned, want)
+	}
+	for range 3 {
+		if r := <-results; !errors.Is(r.Err, apperrors.ErrProcessorStopped) {
+			t.Errorf("job %s: err = %v, want ErrProcessorStopped", r.JobID, r.Err)
+		}
+	}
+
+	// Scheduled jobs are abandoned without being counted as drained.
+	proc = imageprocessor.New(cfg)
+	proc.Start()
+	if err := proc.SubmitAfter(job("later"), time.Hour); err != nil {
+		t.Fatalf("SubmitAfter: %v", err)
+	}
+	if report, err := proc.Stop(context.Background()); err != nil || report.Drained != 0 || len(report.Abandoned) != 1 {
+		t.Errorf("Stop = %+v, %v; want 0 drained, 1 abandoned", report, err)
+	}
+	<-results
+
+	// A submit waiting for room is released by Stop.
+	cfg.QueueSize = 1
+	proc = imageprocessor.New(cfg)
+	if err := proc.Submit(job("queued")); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	waiting := make(chan error, 1)
+	go func() { waiting <- proc.SubmitWait(context.Background(), job("waiting")) }()
+	time.Sleep(10 * time.Millisecond)
+	if _, err := proc.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	if err := <-waiting; !errors.Is(err, apperrors.ErrProcessorStopped) {
+		t.Errorf("waiting SubmitWait = %v, want ErrProcessorStopped", err)
+	}
+	<-results
+
+	// A scheduled job falling due into a full queue is abandoned, not lost.
+	cfg.QueueFull = config.QueueFullBlock
+	proc = imageprocessor.New(cfg)
+	if err := proc.Submit(job("queued")); err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	if err := proc.SubmitAfter(job("due"), 0); err != nil {
+		t.Fatalf("SubmitAfter: %v", err)
+	}
+	for deadline := time.Now().Add(time.Second); ; time.Sleep(time.Millisecond) {
+		if s, _ := proc.JobStatus("due"); s.State == core.JobQueued || time.Now().After(deadline) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	report, err = proc.Stop(context.Background())
+	slices.Sort(report.Abandoned)
+	if want := []string{"due", "queued"}; err != nil || !slices.Equal(report.Abandoned, want) {
+		t.Errorf("Stop = %+v, %v; want %v abandoned", report, err, want)
+	}
+	for range 2 {
+		if r := <-results; !errors.Is(r.Err, apperrors.ErrProcessorStopped) {
+			t.Errorf("job %s: err = %v, want ErrProcessorStopped", r.JobID, r.Err)
+		}
+	}
+	cfg.QueueFull = imageprocessor.DefaultConfig().QueueFull
+
+	// Jobs falling due while Stop runs still get a result.
+	for range 50 {
+		proc = imageprocessor.New(cfg)
+		if err := proc.SubmitAfter(job("racing"), 0); err != nil {
+			t.Fatalf("SubmitAfter: %v", err)
+		}
+		if _, err := proc.Stop(context.Background()); err != nil {
+			t.Fatalf("Stop: %v", err)
+		}
+		select {
+		case <-results:
+		case <-time.After(time.Second):
+			t.Fatal("a job due during Stop was lost")
+		}
+	}
+
+	// Every job accepted while Stop begins gets a result.
+	cfg.QueueSize = 64
+	proc = imageprocessor.New(cfg)
+	proc.Start()
+	all := make(chan core.JobResult, 1<<16)
+	var accepted atomic.Int32
+	var wg sync.WaitGroup
+	for range 4 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				err := proc.Submit(core.Job{
+					Ctx:      context.Background(),
+					Source:   imageprocessor.FromReader(bytes.NewReader([]byte("x"))),
+					Steps:    []core.Step{&passStep{}},
+					ResultCh: all,
+				})
+				if errors.Is(err, apperrors.ErrProcessorStopped) {
+					return
+				}
+				if err == nil {
+					accepted.Add(1)
+				}
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	if _, err := proc.Stop(context.Background()); err != nil {
+		t.Fatalf("Stop: %v", err)
+	}
+	wg.Wait()
+	if len(all) != int(accepted.Load()) {
+		t.Errorf("%d results for %d accepted jobs", len(all), accepted.Load())
+	}
+}
+
+func TestMemoryLimits_RejectAndQueueLargeImages(t *testing.T) {
+	src := newRedJPEG(t, 100, 100) // 40 000 decoded bytes
+
+	cfg := imageprocessor.DefaultConfig()
+	cfg.MaxPixels = 5000
+	proc := imageprocessor.New(cfg)
+	_, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), imageprocessor.Decode())
+	if !errors.Is(err, apperrors.ErrImageTooLarge) || !apperrors.IsCategory(err, apperrors.CategoryInput) {
+		t.Fatalf("MaxPixels: want ErrImageTooLarge, got %v", err)
+	}
+
+	cfg = imageprocessor.DefaultConfig()
+	cfg.MemoryBudget = 30000
+	proc = imageprocessor.New(cfg)
+	_, err = proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), imageprocessor.Decode())
+	if !errors.Is(err, apperrors.ErrImageTooLarge) {
+		t.Fatalf("over budget: want ErrImageTooLarge, got %v", err)
+	}
+
+	// With room for one image at a time, the second waits for the first.
+	cfg.MemoryBudget = 50000
+	proc = imageprocessor.New(cfg)
+	started := make(chan struct{}, 2)
+	release := make(chan struct{})
+	errs := make(chan error, 2)
+	for range 2 {
+		go func() {
+			_, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)),
+				imageprocessor.Decode(), &gateStep{started: started, release: release})
+			errs <- err
+		}()
+	}
+	<-started
+	select {
+	case <-started:
+		t.Fatal("second image started while the budget was taken")
+	case <-time.After(50 * time.Millisecond):
+	}
+	release <- struct{}{}
+	<-started
+	release <- struct{}{}
+	for range 2 {
+		if err := <-errs; err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+	}
+}
+
+// pngBomb returns a PNG header declaring a width × height image, with no
+// pixel data behind it.
+func pngBomb(t *testing.T, width, height uint32) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, image.NewGray(image.Rect(0, 0, 1, 1))); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+	ihdr := data[8+8 : 8+8+13] // after the signature and the chunk length and type
+	binary.BigEndian.PutUint32(ihdr[0:4], width)
+	binary.BigEndian.PutUint32(ihdr[4:8], height)
+	binary.BigEndian.PutUint32(data[8+8+13:], crc32.ChecksumIEEE(data[12:8+8+13]))
+	return data
+}
+
+func TestDecoders_RefuseDecompressionBombs(t *testing.T) {
+	limits := core.DecodeLimits{MaxPixels: 1 << 20}
+	ctx := context.Background()
+
+	_, err := (&decoder.PNG{Limits: limits}).Decode(ctx, bytes.NewReader(pngBomb(t, 50000, 50000)))
+	if !errors.Is(err, apperrors.ErrImageTooLarge) || !apperrors.IsCategory(err, apperrors.CategoryInput) {
+		t.Fatalf("PNG bomb: want ErrImageTooLarge, got %v", err)
+	}
+
+	src := newRedJPEG(t, 100, 100)
+	if _, err := (&decoder.JPEG{Limits: core.DecodeLimits{MaxDecodedBytes: 1000}}).Decode(ctx, bytes.NewReader(src)); !errors.Is(err, apperrors.ErrImageTooLarge) {
+		t.Fatalf("JPEG over MaxDecodedBytes: want ErrImageTooLarge, got %v", err)
+	}
+	// Within the limits the header bytes read by the probe are replayed.
+	img, err := (&decoder.JPEG{Limits: limits}).Decode(ctx, bytes.NewReader(src))
+	if err != nil {
+		t.Fatalf("JPEG within limits: %v", err)
+	}
+	if img.Meta.Width != 100 || img.Meta.Height != 100 {
+		t.Fatalf("decoded %dx%d, want 100x100", img.Meta.Width, img.Meta.Height)
+	}
+}
+
+type countStep struct{ calls *atomic.Int32 }
+
+func (s *countStep) Name() string { return "count" }
+
+func (s *countStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	s.calls.Add(1)
+	return img, nil
+}
+
+func TestResultCache_SkipsRepeatedPipelines(t *testing.T) {
+	src := newRedJPEG(t, 64, 64)
+	var calls atomic.Int32
+	steps := func(width int) []core.Step {
+		return []core.Step{imageprocessor.Decode(), &countStep{calls: &calls}, imageprocessor.Resize(width, 0), imageprocessor.Encode()}
+	}
+
+	redisCache, err := cache.NewRedis(&fakeRedis{}, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, c := range map[string]core.ResultCache{"memory": core.NewMemoryCache(8), "redis": redisCache} {
+		t.Run(name, func(t *testing.T) {
+			calls.Store(0)
+			proc := imageprocessor.New(imageprocessor.DefaultConfig())
+			proc.SetResultCache(c, time.Minute)
+
+			first, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), steps(32)...)
+			if err != nil {
+				t.Fatalf("first Process: %v", err)
+			}
+			again, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), steps(32)...)
+			if err != nil {
+				t.Fatalf("second Process: %v", err)
+			}
+			if calls.Load() != 1 {
+				t.Fatalf("pipeline ran %d times, want 1", calls.Load())
+			}
+			if !bytes.Equal(again.Primary.Data, first.Primary.Data) || again.Primary.Meta.Width != 32 {
+				t.Fatalf("cached result differs: %d bytes, width %d", len(again.Primary.Data), again.Primary.Meta.Width)
+			}
+			if _, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), steps(16)...); err != nil {
+				t.Fatal(err)
+			}
+			if calls.Load() != 2 {
+				t.Fatalf("different steps hit the cache")
+			}
+		})
+	}
+
+	// Pipelines that do not encode are never cached.
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	mem := core.NewMemoryCache(8)
+	proc.SetResultCache(mem, 0)
+	if _, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), imageprocessor.Decode()); err != nil {
+		t.Fatal(err)
+	}
+	if mem.Len() != 0 {
+		t.Fatalf("decode-only result was cached")
+	}
+}
+
+type paramStep struct {
+	Level int
+	cache map[string]int
+}
+
+func (s *paramStep) Name() string { return "param" }
+
+func (s *paramStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	return img, nil
+}
+
+func (s *paramStep) FingerprintParams() map[string]any { return map[string]any{"level": s.Level} }
+
+func TestFingerprint_IsDeterministic(t *testing.T) {
+	a, b := imageprocessor.New(imageprocessor.DefaultConfig()), imageprocessor.New(imageprocessor.DefaultConfig())
+	pipe := func(reg core.Registry, subsample core.SubsampleMode, level int) []core.Step {
+		opts := core.EncodeOptions{Quality: 80}
+		opts.JPEG().Subsample = subsample
+		return []core.Step{
+			&pipeline.DecodeStep{Registry: reg},
+			&pipeline.TeeStep{Branch: "thumb", Steps: []core.Step{imageprocessor.Resize(100, 0)}},
+			&paramStep{Level: level, cache: map[string]int{"x": level}},
+			imageprocessor.EncodeWith(reg, opts),
+		}
+	}
+	fp := core.Fingerprint(pipe(a.Inner().Registry(), core.Subsample444, 1))
+	if len(fp) != 16 {
+		t.Fatalf("fingerprint %q, want 16 hex digits", fp)
+	}
+	if got := core.Fingerprint(pipe(b.Inner().Registry(), core.Subsample444, 1)); got != fp {
+		t.Fatalf("same pipeline on another registry: %s != %s", got, fp)
+	}
+	if core.Fingerprint(pipe(a.Inner().Registry(), core.SubsampleAuto, 1)) == fp {
+		t.Fatal("encoder extension options not fingerprinted")
+	}
+	if core.Fingerprint(pipe(a.Inner().Registry(), core.Subsample444, 2)) == fp {
+		t.Fatal("Fingerprintable params not used")
+	}
+	if manifest.Fingerprint(pipe(a.Inner().Registry(), core.Subsample444, 1)...) != fp {
+		t.Fatal("manifest.Fingerprint disagrees with core.Fingerprint")
+	}
+
+	// Separators inside strings must not let parameters run together.
+	tags := func(artist, copyright string) string {
+		return core.Fingerprint([]core.Step{&pipeline.SetMetadataStep{Tags: core.MetadataTags{Artist: artist, Copyright: copyright}}})
+	}
+	if tags("A,Copyright:B", "C") == tags("A", "B,Copyright:C") {
+		t.Fatal("metadata tags collide")
+	}
+	params := func(m map[string]any) string {
+		return core.Fingerprint([]core.Step{&paramMapStep{params: m}})
+	}
+	if params(map[string]any{"a:1,b": "2"}) == params(map[string]any{"a": "1,b:2"}) {
+		t.Fatal("map entries collide")
+	}
+}
+
+type paramMapStep struct{ params map[string]any }
+
+func (s *paramMapStep) Name() string { return "param_map" }
+
+func (s *paramMapStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	return img, nil
+}
+
+func (s *paramMapStep) FingerprintParams() map[string]any { return s.params }
+
+func TestFingerprint_DistinguishesConditions(t *testing.T) {
+	pipe := func(cond pipeline.Condition) []core.Step {
+		return []core.Step{imageprocessor.Decode(), pipeline.If(cond, imageprocessor.Resize(5, 5)), imageprocessor.Encode()}
+	}
+	narrow, wide := core.Fingerprint(pipe(pipeline.WidthGreaterThan(10))), core.Fingerprint(pipe(pipeline.WidthGreaterThan(5000)))
+	if narrow == "" || narrow == wide {
+		t.Fatalf("fingerprints %q and %q, want distinct", narrow, wide)
+	}
+	if core.Fingerprint(pipe(pipeline.Predicate(func(*core.ImageData) bool { return true }))) != "" {
+		t.Fatal("function predicate fingerprinted")
+	}
+
+	src := newRedJPEG(t, 64, 64)
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	proc.SetResultCache(core.NewMemoryCache(8), 0)
+	for _, tc := range []struct {
+		cond  pipeline.Condition
+		width int
+	}{
+		{pipeline.WidthGreaterThan(10), 5},
+		{pipeline.WidthGreaterThan(5000), 64},
+		{pipeline.Predicate(func(*core.ImageData) bool { return true }), 5},
+		{pipeline.Predicate(func(*core.ImageData) bool { return false }), 64},
+	} {
+		res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), pipe(tc.cond)...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res.Primary.Meta.Width != tc.width {
+			t.Errorf("%#v: width %d, want %d", tc.cond, res.Primary.Meta.Width, tc.width)
+		}
+	}
+}
+
+func TestCoalesce_SharesConcurrentIdenticalRuns(t *testing.T) {
+	cfg := imageprocessor.DefaultConfig()
+	cfg.Coalesce = true
+	proc := imageprocessor.New(cfg)
+	src := newRedJPEG(t, 64, 64)
+
+	var calls atomic.Int32
+	started := make(chan struct{}, 8)
+	release := make(chan struct{})
+	steps := []core.Step{imageprocessor.Decode(), &countStep{calls: &calls}, &gateStep{started: started, release: release}, imageprocessor.Encode()}
+
+	quitter, quit := context.WithCancel(context.Background())
+	errs := make(chan error, 5)
+	for i := range 5 {
+		ctx := context.Background()
+		if i == 0 {
+			ctx = quitter
+		}
+		go func() {
+			res, err := proc.Process(ctx, imageprocessor.FromReader(bytes.NewReader(src)), steps...)
+			if err == nil && len(res.Primary.Data) == 0 {
+				err = errors.New("empty result")
+			}
+			errs <- err
+		}()
+	}
+	<-started
+	time.Sleep(50 * time.Millisecond) // let every caller join the run
+	quit()
+	if err := <-errs; !errors.Is(err, context.Canceled) {
+		t.Fatalf("canceled caller: want context.Canceled, got %v", err)
+	}
+	close(release)
+	for range 4 {
+		if err := <-errs; err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+	}
+	if n := calls.Load(); n != 1 {
+		t.Fatalf("pipeline ran %d times for 5 identical calls, want 1", n)
+	}
+}
+
+// peakStep records how many executions overlap.
+type peakStep struct{ cur, peak atomic.Int32 }
+
+func (s *peakStep) Name() string { return "peak" }
+
+func (s *peakStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	n := s.cur.Add(1)
+	defer s.cur.Add(-1)
+	for {
+		p := s.peak.Load()
+		if n <= p || s.peak.CompareAndSwap(p, n) {
+			break
+		}
+	}
+	time.Sleep(10 * time.Millisecond)
+	return img, nil
+}
+
+func TestBatchWithOptions_BoundsConcurrencyAndAppliesErrorPolicy(t *testing.T) {
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	good := newRedJPEG(t, 16, 16)
+	sources := func() []core.Source {
+		var srcs []core.Source
+		for i := range 6 {
+			data := good
+			if i == 1 {
+				data = []byte("not an image")
+			}
+			srcs = append(srcs, imageprocessor.FromReader(bytes.NewReader(data)))
+		}
+		return srcs
+	}
+
+	peak := &peakStep{}
+	res, err := proc.BatchWithOptions(context.Background(), sources(),
+		core.BatchOptions{MaxConcurrency: 2, ContinueOnError: true}, peak, imageprocessor.Decode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Succeeded != 5 || res.Failed != 1 || res.Items[1].Err == nil || res.Err() == nil {
+		t.Fatalf("ContinueOnError: %d succeeded, %d failed", res.Succeeded, res.Failed)
+	}
+	if p := peak.peak.Load(); p > 2 {
+		t.Fatalf("%d sources ran at once, MaxConcurrency is 2", p)
+	}
+	if res.Items[0].Duration <= 0 {
+		t.Fatal("item duration not recorded")
+	}
+
+	res, err = proc.BatchWithOptions(context.Background(), sources(), core.BatchOptions{MaxConcurrency: 1}, imageprocessor.Decode())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Succeeded != 1 || !errors.Is(res.Items[5].Err, apperrors.ErrBatchAborted) {
+		t.Fatalf("default policy: %d succeeded, last error %v", res.Succeeded, res.Items[5].Err)
+	}
+
+	if _, err := proc.BatchWithOptions(context.Background(), nil, core.BatchOptions{FailFast: true, ContinueOnError: true}); !apperrors.IsCategory(err, apperrors.CategoryConfig) {
+		t.Fatalf("contradictory options: got %v", err)
+	}
+}
+
+func TestBatchStream_ProcessesAChannelOfSources(t *testing.T) {
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	good := newRedJPEG(t, 16, 16)
+	sources := make(chan core.Source)
+	go func() {
+		defer close(sources)
+		for i := range 20 {
+			data := good
+			if i == 7 {
+				data = []byte("not an image")
+			}
+			sources <- imageprocessor.FromReader(bytes.NewReader(data))
+		}
+	}()
+
+	seen := make(map[int]bool)
+	for item := range proc.BatchStream(context.Background(), sources, imageprocessor.Decode()) {
+		if seen[item.Index] {
+			t.Fatalf("index %d delivered twice", item.Index)
+		}
+		seen[item.Index] = true
+		if (item.Err != nil) != (item.Index == 7) {
+			t.Fatalf("source %d: unexpected error state %v", item.Index, item.Err)
+		}
+	}
+	if len(seen) != 20 {
+		t.Fatalf("got %d results, want 20", len(seen))
+	}
+
+	// Canceling closes the output without draining the input.
+	ctx, cancel := context.WithCancel(context.Background())
+	endless := make(chan core.Source)
+	out := proc.BatchStream(ctx, endless, imageprocessor.Decode())
+	cancel()
+	select {
+	case _, ok := <-out:
+		for ok {
+			_, ok = <-out
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("output not closed after cancel")
+	}
+}
+
+// widthProbe records the width of the image it receives.
+type widthProbe struct{ width *int }
+
+func (p *widthProbe) Name() string { return "width_probe" }
+
+func (p *widthProbe) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	*p.width = img.Meta.Width
+	if src, ok := img.Image.(image.Image); ok {
+		*p.width = src.Bounds().Dx()
+	}
+	return img, nil
+}
+
+func TestProcessVariants_DerivesFromOtherVariants(t *testing.T) {
+	proc := newProc(t)
+	src := newRedJPEG(t, 400, 200)
+	var thumbInput int
+	variants := []core.VariantDefinition{
+		{Name: "thumb", From: "medium", Steps: []core.Step{&widthProbe{width: &thumbInput}, imageprocessor.Resize(50, 0)}},
+		{Name: "medium", Steps: []core.Step{imageprocessor.Resize(100, 0)}},
+	}
+	res, err := proc.ProcessVariants(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)),
+		[]core.Step{imageprocessor.Decode()}, variants)
+	if err != nil {
+		t.Fatalf("ProcessVariants: %v", err)
+	}
+	if thumbInput != 100 {
+		t.Fatalf("thumb started from a %dpx image, want medium's 100px", thumbInput)
+	}
+	if w := res.Variants["thumb"].Image.(image.Image).Bounds().Dx(); w != 50 {
+		t.Fatalf("thumb is %dpx wide, want 50", w)
+	}
+
+	for name, bad := range map[string][]core.VariantDefinition{
+		"unknown":   {{Name: "thumb", From: "missing"}},
+		"cycle":     {{Name: "a", From: "b"}, {Name: "b", From: "a"}},
+		"duplicate": {{Name: "thumb"}, {Name: "thumb", Steps: []core.Step{imageprocessor.Resize(50, 0)}}},
+	} {
+		_, err := proc.ProcessVariants(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)),
+			[]core.Step{imageprocessor.Decode()}, bad)
+		if !apperrors.IsCategory(err, apperrors.CategoryConfig) {
+			t.Fatalf("%s: want a config error, got %v", name, err)
+		}
+	}
+}
+
+// paintStep paints the decoded image's top-left pixel in place.
+type paintStep struct{}
+
+func (paintStep) Name() string { return "paint" }
+
+func (paintStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	img.Image.(*image.RGBA).Set(0, 0, color.RGBA{G: 255, A: 255})
+	return img, nil
+}
+
+func TestImageDataClone_IsolatesVariantsAndBranches(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	orig := &core.ImageData{Image: src, Meta: core.Metadata{EXIF: map[string]string{"Make": "x"}}}
+	c, err := orig.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	c.Image.(*image.RGBA).Set(1, 1, color.RGBA{R: 255, A: 255})
+	c.Meta.EXIF["Make"] = "y"
+	if src.RGBAAt(1, 1).R != 0 || orig.Meta.EXIF["Make"] != "x" {
+		t.Fatal("clone shares pixels or metadata with the original")
+	}
+
+	anim := &core.Animation{Frames: []image.Image{image.NewGray(image.Rect(0, 0, 2, 2))}}
+	ac, err := core.CloneImage(anim)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ac.(*core.Animation).Frames[0].(*image.Gray).Pix[0] = 9
+	if anim.Frames[0].(*image.Gray).Pix[0] != 0 {
+		t.Fatal("animation frames shared")
+	}
+
+	// A branch painting in place leaves the trunk untouched.
+	in := &core.ImageData{Image: image.NewRGBA(image.Rect(0, 0, 4, 4))}
+	out, err := (&pipeline.TeeStep{Branch: "b", Steps: []core.Step{paintStep{}}}).Execute(context.Background(), in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Image.(*image.RGBA).RGBAAt(0, 0).G != 0 || out.Branches["b"].Image.(*image.RGBA).RGBAAt(0, 0).G != 255 {
+		t.Fatal("tee branch mutated the trunk")
+	}
+}
+
+// slowStep blocks until its context is done, with an optional time limit.
+type slowStep struct{ limit time.Duration }
+
+func (s *slowStep) Name() string { return "slow" }
+
+func (s *slowStep) StepTimeout() time.Duration { return s.limit }
+
+func (s *slowStep) Execute(ctx context.Context, _ *core.ImageData) (*core.ImageData, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestStepTimeout_CancelsTheSlowStep(t *testing.T) {
+	cfg := imageprocessor.DefaultConfig()
+	cfg.StepTimeout = 30 * time.Millisecond
+	proc := imageprocessor.New(cfg)
+	src := newRedJPEG(t, 8, 8)
+
+	_, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), imageprocessor.Decode(), &slowStep{})
+	if !errors.Is(err, apperrors.ErrStepTimeout) || !strings.Contains(err.Error(), "slow") {
+		t.Fatalf("want ErrStepTimeout naming the step, got %v", err)
+	}
+
+	// A TimeoutHinter limits the step without a default.
+	start := time.Now()
+	_, _, err = pipeline.New().Use(&slowStep{limit: 20 * time.Millisecond}).Run(context.Background(), &core.ImageData{})
+	if !errors.Is(err, apperrors.ErrStepTimeout) {
+		t.Fatalf("hinted timeout: got %v", err)
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Fatal("step was not canceled")
+	}
+}
+
+type panicStep struct{}
+
+func (panicStep) Name() string { return "boom" }
+
+func (panicStep) Execute(context.Context, *core.ImageData) (*core.ImageData, error) {
+	var m map[string]int
+	m["x"]++ // nil map write
+	return nil, nil
+}
+
+func TestStepPanics_BecomePipelineErrors(t *testing.T) {
+	proc := newProc(t)
+	src := newRedJPEG(t, 8, 8)
+	_, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)), imageprocessor.Decode(), panicStep{})
+	if !errors.Is(err, apperrors.ErrStepPanicked) || !apperrors.IsCategory(err, apperrors.CategoryPipeline) {
+		t.Fatalf("want ErrStepPanicked, got %v", err)
+	}
+	if msg := err.Error(); !strings.Contains(msg, "boom") || !strings.Contains(msg, "goroutine") {
+		t.Fatalf("error lacks the step name or stack: %s", msg)
+	}
+
+	// The worker pool survives a panicking job.
+	results := make(chan core.JobResult, 1)
+	if err := proc.Submit(core.Job{Source: imageprocessor.FromReader(bytes.NewReader(src)), Steps: []core.Step{panicStep{}}, ResultCh: results}); err != nil {
+		t.Fatal(err)
+	}
+	if res := <-results; !errors.Is(res.Err, apperrors.ErrStepPanicked) {
+		t.Fatalf("job: want ErrStepPanicked, got %v", res.Err)
+	}
+	if _, _, err := pipeline.New().Use(panicStep{}).Run(context.Background(), &core.ImageData{}); !errors.Is(err, apperrors.ErrStepPanicked) {
+		t.Fatalf("pipeline: want ErrStepPanicked, got %v", err)
+	}
+}
+
+// downStore fails every Put while down is set, like an unreachable endpoint.
+type downStore struct {
+	core.StorageAdapter
+	down  atomic.Bool
+	calls atomic.Int32
+}
+
+func (s *downStore) Put(ctx context.Context, key core.StorageKey, r io.Reader, meta map[string]string) error {
+	s.calls.Add(1)
+	if s.down.Load() {
+		return apperrors.Transient("down.put", context.DeadlineExceeded)
+	}
+	return s.StorageAdapter.Put(ctx, key, r, meta)
+}
+
+func TestBreaker_FailsFastWhileOpen(t *testing.T) {
+	local, err := storage.NewLocal(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	inner := &downStore{StorageAdapter: local}
+	inner.down.Store(true)
+	b := breaker.New("store", breaker.Options{Threshold: 2, OpenFor: 50 * time.Millisecond})
+	store := breaker.Storage(inner, b)
+	ctx := context.Background()
+	key := core.StorageKey{Path: "a.jpg"}
+	put := func() error { return store.Put(ctx, key, bytes.NewReader([]byte("x")), nil) }
+
+	// Input errors are the caller's fault and do not count.
+	for range 3 {
+		err := b.Do("op", func() error { return apperrors.New(apperrors.CategoryInput, "op", apperrors.ErrEmptyInput) })
+		if err == nil {
+			t.Fatal("Do swallowed the error")
+		}
+	}
+	if b.State() != breaker.Closed {
+		t.Fatalf("input errors tripped the breaker: %v", b.State())
+	}
+
+	for range 2 {
+		if err := put(); errors.Is(err, apperrors.ErrCircuitOpen) {
+			t.Fatalf("circuit opened early: %v", err)
+		}
+	}
+	err = put()
+	if !errors.Is(err, apperrors.ErrCircuitOpen) || !apperrors.IsCategory(err, apperrors.CategoryTransient) {
+		t.Fatalf("want a transient circuit-open error, got %v", err)
+	}
+	if n := inner.calls.Load(); n != 2 {
+		t.Fatalf("open circuit still called the backend: %d calls", n)
+	}
+
+	// After OpenFor one trial call goes through; its failure reopens.
+	time.Sleep(60 * time.Millisecond)
+	if b.State() != breaker.HalfOpen {
+		t.Fatalf("state = %v, want half-open", b.State())
+	}
+	if err := put(); errors.Is(err, apperrors.ErrCircuitOpen) {
+		t.Fatalf("trial call rejected: %v", err)
+	}
+	if err := put(); !errors.Is(err, apperrors.ErrCircuitOpen) {
+		t.Fatalf("failed trial did not reopen: %v", err)
+	}
+
+	// A successful trial closes it again.
+	inner.down.Store(false)
+	time.Sleep(60 * time.Millisecond)
+	if err := put(); err != nil {
+		t.Fatalf("trial after recovery: %v", err)
+	}
+	if b.State() != breaker.Closed {
+		t.Fatalf("state = %v, want closed", b.State())
+	}
+
+	if _, ok := breaker.Storage(local, b).(core.Lister); !ok {
+		t.Error("wrapper hides core.Lister")
+	}
+	if _, ok := breaker.Decoder(&decoder.TIFF{}, b).(core.PageDecoder); !ok {
+		t.Error("wrapper hides core.PageDecoder")
+	}
+	if _, ok := breaker.Encoder(encoder.NewPNG(), b).(core.StreamEncoder); !ok {
+		t.Error("wrapper hides core.StreamEncoder")
+	}
+}
+
+// brokenCodec fails every decode and encode, like a broken native build.
+type brokenCodec struct{ calls atomic.Int32 }
+
+func (c *brokenCodec) CanDecode(core.Format) bool { return false }
+func (c *brokenCodec) CanEncode(core.Format) bool { return true }
+func (c *brokenCodec) CodecName() string          { return "broken" }
+
+func (c *brokenCodec) Decode(context.Context, io.Reader) (*core.ImageData, error) {
+	c.calls.Add(1)
+	return nil, apperrors.New(apperrors.CategoryDecode, "broken.decode", errors.New("no loader"))
+}
+
+func (c *brokenCodec) Encode(context.Context, *core.ImageData, core.EncodeOptions) ([]byte, error) {
+	c.calls.Add(1)
+	return nil, apperrors.New(apperrors.CategoryEncode, "broken.encode", errors.New("no saver"))
+}
+
+// plainRegistry hides every optional Registry method, as a caller's own
+// Registry implementation would.
+type plainRegistry struct{ core.Registry }
+
+func TestCodecSteps_UseRegistriesWithoutChains(t *testing.T) {
+	var _ interface {
+		core.DecoderChainer
+		core.EncoderChainer
+		core.PriorityRegistry
+		core.FormatLister
+	} = core.NewRegistry()
+	proc := newProc(t)
+	reg := plainRegistry{proc.Inner().Registry()}
+	if got, want := core.SupportedFormats(reg), proc.SupportedFormats(); !slices.Equal(got, want) {
+		t.Errorf("probed formats %v, want %v", got, want)
+	}
+	if caps := core.RegistryCapabilities(reg); !caps.CanDecode(core.FormatJPEG) || !caps.CanEncode(core.FormatPNG) {
+		t.Errorf("probed capabilities %+v", caps)
+	}
+	img := &core.ImageData{Data: newRedJPEG(t, 20, 10), Format: core.FormatJPEG}
+	out, err := (&pipeline.DecodeStep{Registry: reg}).Execute(context.Background(), img)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.Image.Bounds().Dx() != 20 {
+		t.Errorf("decoded width %d, want 20", out.Image.Bounds().Dx())
+	}
+	enc, err := (&pipeline.EncodeStep{Registry: reg}).Execute(context.Background(), out)
+	if err != nil || len(enc.Data) == 0 {
+		t.Fatalf("encode: %d bytes, %v", len(enc.Data), err)
+	}
+
+	// Without priorities a fallback only fills a gap; anything else wins.
+	plain := plainRegistry{core.NewRegistry()}
+	first, second := encoder.NewJPEG(80), encoder.NewJPEG(90)
+	core.AddEncoder(plain, core.FormatJPEG, first, 0)
+	core.AddEncoder(plain, core.FormatJPEG, second, -1)
+	if chain := core.EncodersFor(plain, core.FormatJPEG); len(chain) != 1 || chain[0] != first {
+		t.Errorf("after fallback: chain %v, want the first encoder", chain)
+	}
+	core.AddEncoder(plain, core.FormatJPEG, second, 1)
+	if chain := core.EncodersFor(plain, core.FormatJPEG); len(chain) != 1 || chain[0] != second {
+		t.Errorf("after preferred: chain %v, want the second encoder", chain)
+	}
+}
+
+func TestRegistry_FallsBackThroughCodecChains(t *testing.T) {
+	reg := core.NewRegistry()
+	first, second := &decoder.PNG{}, &decoder.JPEG{}
+	reg.RegisterDecoder(core.FormatJPEG, first)
+	reg.AddDecoder(core.FormatJPEG, second, 5)
+	reg.AddDecoder(core.FormatJPEG, first, -1)
+	if chain := reg.DecoderChain(core.FormatJPEG); len(chain) != 2 || chain[0] != second || chain[1] != first {
+		t.Fatalf("chain = %v, want priority order without duplicates", chain)
+	}
+
+	proc := newProc(t)
+	broken := &brokenCodec{}
+	proc.RegisterDecoder(core.FormatJPEG, broken)
+	proc.RegisterEncoder(core.FormatJPEG, broken)
+	res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 40, 30))),
+		imageprocessor.Decode(), imageprocessor.Encode())
+	if err != nil {
+		t.Fatalf("Process did not fall back: %v", err)
+	}
+	if broken.calls.Load() != 2 {
+		t.Errorf("broken codec tried %d times, want once per stage", broken.calls.Load())
+	}
+	want := core.CodecUsage{Decoder: "*decoder.JPEG", Encoder: "*encoder.JPEG"}
+	if res.Codecs != want || res.Primary.Codecs != want {
+		t.Errorf("Codecs = %+v, want %+v", res.Codecs, want)
+	}
+
+	// A negative priority runs only after the built-in codec fails.
+	proc = newProc(t)
+	broken = &brokenCodec{}
+	proc.AddEncoder(core.FormatJPEG, broken, -1)
+	res, err = proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 40, 30))),
+		imageprocessor.Decode(), imageprocessor.Encode())
+	if err != nil || broken.calls.Load() != 0 || res.Codecs.Encoder != "*encoder.JPEG" {
+		t.Fatalf("fallback ran first: err=%v calls=%d codecs=%+v", err, broken.calls.Load(), res.Codecs)
+	}
+}
+
+func TestRegistry_ReportsCapabilities(t *testing.T) {
+	proc := newProc(t)
+	formats := proc.SupportedFormats()
+	if !slices.IsSorted(formats) || !slices.Contains(formats, core.FormatTIFF) {
+		t.Fatalf("SupportedFormats = %v", formats)
+	}
+	caps := proc.Capabilities()
+	if !caps.CanDecode(core.FormatJPEG) || !caps.CanEncode(core.FormatWebP) || caps.CanEncode(core.FormatAVIF) {
+		t.Fatalf("Decode = %v, Encode = %v", caps.Decode, caps.Encode)
+	}
+	jpeg, ok := caps.Encoder(core.FormatJPEG)
+	if !ok || jpeg.Codec != "*encoder.JPEG" || jpeg.Alpha || jpeg.Animation || jpeg.MaxWidth != 65535 {
+		t.Errorf("JPEG encoder = %+v", jpeg)
+	}
+	if gif, _ := caps.Encoder(core.FormatGIF); !gif.Animation || !gif.Alpha {
+		t.Errorf("GIF encoder = %+v", gif)
+	}
+	if webp, _ := caps.Decoder(core.FormatWebP); !webp.Decode || !webp.Animation || webp.MaxWidth != 16383 {
+		t.Errorf("WebP decoder = %+v", webp)
+	}
+
+	// Wrapped codecs report what the codec they wrap does.
+	proc.RegisterEncoder(core.FormatGIF, breaker.Encoder(encoder.NewGIF(), breaker.New("gif", breaker.Options{})))
+	if gif, _ := proc.Capabilities().Encoder(core.FormatGIF); gif.Codec != "*encoder.GIF" || !gif.Animation {
+		t.Errorf("wrapped GIF encoder = %+v", gif)
+	}
+}
+
+func TestNegotiateFormat_FollowsAcceptAndCapabilities(t *testing.T) {
+	proc := newProc(t)
+	negotiate := func(src []byte, steps ...core.Step) core.Format {
+		t.Helper()
+		res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)),
+			append([]core.Step{imageprocessor.Decode()}, steps...)...)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		return res.Primary.Format
+	}
+	jpg := newRedJPEG(t, 40, 30)
+	const chrome = "image/avif,image/webp,image/apng,image/svg+xml,image/*,*/*;q=0.8"
+
+	// No AVIF encoder is registered, so WebP is the best the client takes.
+	if f := negotiate(jpg, &pipeline.NegotiateFormatStep{Accept: chrome}); f != core.FormatWebP {
+		t.Errorf("chrome without AVIF encoder: %s, want webp", f)
+	}
+	// Wildcards alone keep the source format.
+	if f := negotiate(jpg, &pipeline.NegotiateFormatStep{Accept: "*/*"}); f != core.FormatJPEG {
+		t.Errorf("*/*: %s, want jpeg", f)
+	}
+	// q values beat preference order; q=0 refuses a format.
+	if f := negotiate(jpg, &pipeline.NegotiateFormatStep{
+		Accept: "image/webp;q=0.5,image/png", Preference: []core.Format{core.FormatWebP, core.FormatPNG},
+	}); f != core.FormatPNG {
+		t.Errorf("q-weighted: %s, want png", f)
+	}
+	if f := negotiate(jpg, &pipeline.NegotiateFormatStep{Accept: "image/webp;q=0"}); f != core.FormatJPEG {
+		t.Errorf("q=0: %s, want jpeg", f)
+	}
+
+	// A transparent image never lands in a format without alpha.
+	nrgba := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, nrgba); err != nil {
+		t.Fatal(err)
+	}
+	step, err := core.NewStep("negotiate_format", map[string]any{"accept": "image/jpeg", "preference": []any{"jpeg"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f := negotiate(buf.Bytes(), step); f != core.FormatPNG {
+		t.Errorf("transparent: %s, want png", f)
+	}
+
+	proc.RegisterEncoder(core.FormatAVIF, &brokenCodec{})
+	if f := negotiate(jpg, &pipeline.NegotiateFormatStep{Accept: chrome}); f != core.FormatAVIF {
+		t.Errorf("chrome with AVIF encoder: %s, want avif", f)
+	}
+}
+
+func TestBestFormat_KeepsTheSmallestFaithfulOutput(t *testing.T) {
+	proc := newProc(t)
+	run := func(src image.Image, step *pipeline.BestFormatEncodeStep) *core.ImageData {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, src); err != nil {
+			t.Fatal(err)
+		}
+		res, err := proc.Process(context.Background(), imageprocessor.FromReader(&buf), imageprocessor.Decode(), step)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		return res.Primary
+	}
+	formats := []core.Format{core.FormatJPEG, core.FormatPNG, core.FormatAVIF}
+
+	// A flat graphic compresses best losslessly; AVIF has no encoder.
+	flat := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	draw.Draw(flat, flat.Bounds(), image.NewUniform(color.RGBA{R: 200, A: 255}), image.Point{}, draw.Src)
+	metrics := hooks.NewInMemoryMetrics()
+	out := run(flat, &pipeline.BestFormatEncodeStep{Formats: formats, Quality: 80, Metrics: metrics})
+	if out.Format != core.FormatPNG || len(out.Meta.Candidates) != 2 {
+		t.Fatalf("flat: got %s with candidates %+v", out.Format, out.Meta.Candidates)
+	}
+	if c := out.Meta.Candidates[0]; c.Format != core.FormatJPEG || c.Size <= int64(len(out.Data)) || c.Rejected == "" {
+		t.Errorf("rejected JPEG candidate = %+v", c)
+	}
+	if calls := metrics.Snapshot().StepCalls; calls["best_format.jpeg"] != 1 || calls["best_format.png"] != 1 {
+		t.Errorf("metrics = %v", calls)
+	}
+
+	// Noise is smaller as a rough JPEG, unless an SSIM floor forbids it.
+	noise := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for i := range noise.Pix {
+		noise.Pix[i] = byte(i * 7919 % 251)
+	}
+	if out := run(noise, &pipeline.BestFormatEncodeStep{Formats: formats, Quality: 20}); out.Format != core.FormatJPEG {
+		t.Errorf("noise: got %s, want jpeg", out.Format)
+	}
+	out = run(noise, &pipeline.BestFormatEncodeStep{Formats: formats, Quality: 20, MinSSIM: 0.99})
+	if out.Format != core.FormatPNG || !strings.Contains(out.Meta.Candidates[0].Rejected, "SSIM") {
+		t.Errorf("noise with SSIM floor: got %s with candidates %+v", out.Format, out.Meta.Candidates)
+	}
+}
+
+func TestPerceptualCompress_FindsTheLowestQualityMeetingTheTarget(t *testing.T) {
+	proc := newProc(t)
+	src := image.NewRGBA(image.Rect(0, 0, 96, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 96; x++ {
+			src.Set(x, y, color.RGBA{R: uint8(x * 2), G: uint8(y * 3), B: uint8((x * y) % 256), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, src, &jpeg.Options{Quality: 100}); err != nil {
+		t.Fatal(err)
+	}
+	run := func(target float64) *core.ImageData {
+		t.Helper()
+		step, err := core.NewStep("perceptual_compress", map[string]any{"target_ssim": target, "min_quality": 10})
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(buf.Bytes())),
+			imageprocessor.Decode(), step)
+		if err != nil {
+			t.Fatalf("Process: %v", err)
+		}
+		return res.Primary
+	}
+	chosen := func(img *core.ImageData) core.EncodeCandidate {
+		t.Helper()
+		for _, c := range img.Meta.Candidates {
+			if c.Rejected == "" {
+				return c
+			}
+		}
+		t.Fatalf("no candidate kept: %+v", img.Meta.Candidates)
+		return core.EncodeCandidate{}
+	}
+
+	loose, strict := run(0.90), run(0.97)
+	lc, sc := chosen(loose), chosen(strict)
+	if lc.SSIM < 0.90 || sc.SSIM < 0.97 {
+		t.Errorf("targets missed: %.4f, %.4f", lc.SSIM, sc.SSIM)
+	}
+	if lc.Quality >= sc.Quality || len(loose.Data) >= len(strict.Data) {
+		t.Errorf("loose target q=%d (%d bytes) not below strict q=%d (%d bytes)",
+			lc.Quality, len(loose.Data), sc.Quality, len(strict.Data))
+	}
+	if q, _ := strict.Attrs.Int(core.AttrQuality); q != sc.Quality {
+		t.Errorf("quality directive = %d, want %d", q, sc.Quality)
+	}
+	for _, c := range loose.Meta.Candidates {
+		if c.Quality < lc.Quality && c.SSIM >= 0.90 {
+			t.Errorf("lower quality %d also met the target", c.Quality)
+		}
+	}
+}
+
+func TestEncodeFactory_TakesCodecSpecificOptions(t *testing.T) {
+	step, err := core.NewStep("encode", map[string]any{
+		"quality": 90,
+		"jpeg":    map[string]any{"subsample": "4:4:4"},
+		"png":     map[string]any{"compression_level": 9, "filter": "paeth"},
+		"webp":    map[string]any{"effort": 6, "alpha_quality": 80},
+		"avif":    map[string]any{"effort": 3, "bitdepth": 10},
+	})
+	if err != nil {
+		t.Fatalf("NewStep: %v", err)
+	}
+	opts := step.(*pipeline.EncodeStep).BaseOptions
+	if opts.PNG().Filter != core.PNGFilterPaeth || opts.WebP().AlphaQuality != 80 || opts.AVIF().Bitdepth != 10 {
+		t.Errorf("options not carried: %+v %+v %+v", *opts.PNG(), *opts.WebP(), *opts.AVIF())
+	}
+	for effort, want := range map[int]int{0: 4, core.WebPEffortFastest: 0, 2: 2, 9: 6} {
+		if got := (&core.WebPOptions{Effort: effort}).Method(); got != want {
+			t.Errorf("WebP effort %d: method %d, want %d", effort, got, want)
+		}
+	}
+
+	proc := newProc(t)
+	res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 32, 32))),
+		imageprocessor.Decode(), step)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	decoded, err := jpeg.Decode(bytes.NewReader(res.Primary.Data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if y, ok := decoded.(*image.YCbCr); !ok || y.SubsampleRatio != image.YCbCrSubsampleRatio444 {
+		t.Errorf("output is not 4:4:4: %T", decoded)
+	}
+
+	for _, bad := range []map[string]any{
+		{"jpeg": map[string]any{"subsample": "4:1:1"}},
+		{"webp": map[string]any{"effrot": 6}},
+		{"png": "fast"},
+	} {
+		if _, err := core.NewStep("encode", bad); err == nil {
+			t.Errorf("encode %v accepted", bad)
+		}
+	}
+}
+
+func TestPNGEncoder_Interlaces16BitSources(t *testing.T) {
+	src := image.NewNRGBA64(image.Rect(0, 0, 13, 9))
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 13; x++ {
+			src.SetNRGBA64(x, y, color.NRGBA64{R: uint16(x * 5000), G: uint16(y * 7000), B: 0x1234, A: uint16(0xffff - x*y*300)})
+		}
+	}
+	out, err := encoder.NewPNG().Encode(context.Background(), &core.ImageData{Image: src}, core.EncodeOptions{Interlaced: true})
+	if err != nil {
+		t.Fatalf("Encode: %v", err)
+	}
+	if out[24] != 16 || out[28] != 1 {
+		t.Errorf("IHDR depth %d interlace %d, want 16 and 1", out[24], out[28])
+	}
+	decoded, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	for y := 0; y < 9; y++ {
+		for x := 0; x < 13; x++ {
+			if got := color.NRGBA64Model.Convert(decoded.At(x, y)); got != src.NRGBA64At(x, y) {
+				t.Fatalf("pixel %d,%d = %v, want %v", x, y, got, src.NRGBA64At(x, y))
+			}
+		}
+	}
+}
+
+func TestEncodeFactory_TakesMozJPEGOptions(t *testing.T) {
+	step, err := core.NewStep("encode", map[string]any{
+		"jpeg": map[string]any{"trellis": true, "optimize_scans": true, "quant_table": 3},
+	})
+	if err != nil {
+		t.Fatalf("NewStep: %v", err)
+	}
+	opts := step.(*pipeline.EncodeStep).BaseOptions
+	if jo := opts.JPEG(); !jo.Trellis || !jo.OptimizeScans || jo.OvershootDeringing || jo.QuantTable != 3 {
+		t.Errorf("options not carried: %+v", *jo)
+	}
+	// The stdlib encoder is not mozjpeg and ignores them.
+	res, err := newProc(t).Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 16, 16))),
+		imageprocessor.Decode(), step)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(res.Primary.Data)); err != nil {
+		t.Errorf("output does not decode: %v", err)
+	}
+	if _, err := core.NewStep("encode", map[string]any{"jpeg": map[string]any{"quant_table": 9}}); err == nil {
+		t.Error("quant_table 9 accepted")
+	}
+}
+
+func TestOptimizePNG_ShrinksScreenshots(t *testing.T) {
+	// A flat "screenshot" written by image/png at its fastest setting.
+	src := image.NewNRGBA(image.Rect(0, 0, 200, 120))
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 200; x++ {
+			c := color.NRGBA{0xf0, 0xf0, 0xf0, 0xff}
+			if y%20 < 4 || (x/25)%2 == 0 && y > 60 {
+				c = color.NRGBA{0x20, 0x60, uint8(0xa0 + x%4), 0xff}
+			}
+			src.SetNRGBA(x, y, c)
+		}
+	}
+	var buf bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.BestSpeed}).Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+
+	step, err := core.NewStep("optimize_png", map[string]any{"lossless": true})
+	if err != nil {
+		t.Fatalf("NewStep: %v", err)
+	}
+	res, err := newProc(t).Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(buf.Bytes())), step)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	out := res.Primary.Data
+	if len(out)*3 > buf.Len() {
+		t.Errorf("optimized to %d bytes from %d, want a third or less", len(out), buf.Len())
+	}
+	decoded, err := png.Decode(bytes.NewReader(out))
+	if err != nil {
+		t.Fatalf("png.Decode: %v", err)
+	}
+	for y := 0; y < 120; y++ {
+		for x := 0; x < 200; x++ {
+			if got := color.NRGBAModel.Convert(decoded.At(x, y)); got != src.NRGBAAt(x, y) {
+				t.Fatalf("pixel %d,%d changed: %v, want %v", x, y, got, src.NRGBAAt(x, y))
+			}
+		}
+	}
+	if n := len(res.Primary.Meta.Candidates); n != 4 {
+		t.Errorf("%d candidates, want the input and three filters", n)
+	}
+
+	// Already optimal input comes back as it was.
+	again, err := newProc(t).Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(out)), imageprocessor.OptimizePNG(false))
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if len(again.Primary.Data) > len(out) {
+		t.Errorf("second pass grew the file to %d bytes from %d", len(again.Primary.Data), len(out))
+	}
+}
+
+func TestLosslessJPEG_TransformsWithoutReencoding(t *testing.T) {
+	decode := func(data []byte) image.Image {
+		t.Helper()
+		img, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("jpeg.Decode: %v", err)
+		}
+		return img
+	}
+	// same checks that got(x, y) matches want(at(x, y)) within IDCT rounding.
+	same := func(name string, got, want image.Image, at func(x, y int) (int, int)) {
+		t.Helper()
+		b := got.Bounds()
+		for y := 0; y < b.Dy(); y++ {
+			for x := 0; x < b.Dx(); x++ {
+				sx, sy := at(x, y)
+				r1, g1, b1, _ := got.At(x, y).RGBA()
+				r2, g2, b2, _ := want.At(sx, sy).RGBA()
+				for _, d := range []int{int(r1>>8) - int(r2>>8), int(g1>>8) - int(g2>>8), int(b1>>8) - int(b2>>8)} {
+					if d < -3 || d > 3 {
+						t.Fatalf("%s: pixel %d,%d differs from %d,%d by %d", name, x, y, sx, sy, d)
+					}
+				}
+			}
+		}
+	}
+
+	// A 4:2:0 phone photo tagged "rotate 90° clockwise", whole MCUs.
+	data := testutil.EXIFJPEG(t, 48, 32, 6)
+	src := decode(data)
+	out, ok, err := losslessjpeg.AutoOrient(data)
+	if err != nil || !ok {
+		t.Fatalf("AutoOrient: %v %v", ok, err)
+	}
+	if o := losslessjpeg.Orientation(out); o != 1 {
+		t.Errorf("orientation %d after AutoOrient, want 1", o)
+	}
+	rotated := decode(out)
+	if b := rotated.Bounds(); b.Dx() != 32 || b.Dy() != 48 {
+		t.Fatalf("rotated to %v, want 32x48", b)
+	}
+	same("rotate90", rotated, src, func(x, y int) (int, int) { return y, 31 - x })
+
+	// Mirroring a width that is not whole MCUs trims the partial one.
+	odd := testutil.EncodeJPEG(t, testutil.Gradient(40, 24), 90)
+	if perfect, _ := losslessjpeg.Perfect(odd, losslessjpeg.FlipHorizontal); perfect {
+		t.Error("flipping 40 pixels of 16-pixel MCUs reported perfect")
+	}
+	flipped, err := losslessjpeg.Apply(odd, losslessjpeg.FlipHorizontal)
+	if err != nil {
+		t.Fatalf("Apply: %v", err)
+	}
+	if b := decode(flipped).Bounds(); b.Dx() != 32 || b.Dy() != 24 {
+		t.Errorf("flipped to %v, want 32x24", b)
+	}
+	same("flip", decode(flipped), decode(odd), func(x, y int) (int, int) { return 31 - x, y })
+
+	// Crops start on the MCU boundary at or before the requested corner.
+	cropped, err := losslessjpeg.Crop(data, image.Rect(20, 20, 40, 30))
+	if err != nil {
+		t.Fatalf("Crop: %v", err)
+	}
+	if b := decode(cropped).Bounds(); b.Dx() != 24 || b.Dy() != 14 {
+		t.Errorf("cropped to %v, want 24x14 from 16,16", b)
+	}
+	same("crop", decode(cropped), src, func(x, y int) (int, int) { return 16 + x, 16 + y })
+
+	// Progressive input is read too.
+	var opts core.EncodeOptions
+	opts.Interlaced = true
+	prog, err := encoder.NewJPEG(90).Encode(context.Background(), &core.ImageData{Image: testutil.Gradient(32, 32)}, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	transverse, err := losslessjpeg.Apply(prog, losslessjpeg.Transverse)
+	if err != nil {
+		t.Fatalf("Apply progressive: %v", err)
+	}
+	same("transverse", decode(transverse), decode(prog), func(x, y int) (int, int) { return 31 - y, 31 - x })
+}
+
+func TestDecode_ParsesEXIFAndIPTC(t *testing.T) {
+	proc := newProc(t)
+	out, err := (&pipeline.DecodeStep{Registry: proc.Inner().Registry()}).Execute(context.Background(),
+		&core.ImageData{Data: testutil.EXIFJPEG(t, 32, 32, 6), Format: core.FormatJPEG})
+	if err != nil {
+		t.Fatalf("decode: %v", err)
+	}
+	d := out.Meta.Details
+	if d == nil {
+		t.Fatal("Meta.Details not set")
+	}
+	want := time.Date(2006, 1, 2, 15, 4, 5, 0, time.UTC)
+	if d.Orientation != 6 || d.Software != "image-processor testutil" || !d.DateTime.Equal(want) {
+		t.Errorf("details = %d %q %v", d.Orientation, d.Software, d.DateTime)
+	}
+	if out.Meta.Orientation != 6 || out.Meta.EXIF["DateTime"] != "2006:01:02 15:04:05" {
+		t.Errorf("meta orientation %d, EXIF %v", out.Meta.Orientation, out.Meta.EXIF)
+	}
+
+	// IPTC travels in a Photoshop APP13 segment.
+	dataset := func(n byte, v string) []byte {
+		return append([]byte{0x1C, 2, n, 0, byte(len(v))}, v...)
+	}
+	iim := slices.Concat(dataset(105, "Harbour"), dataset(25, "boats"), dataset(25, "dusk"))
+	res := slices.Concat([]byte("Photoshop 3.0\x008BIM\x04\x04\x00\x00"), []byte{0, 0, 0, byte(len(iim))}, iim)
+	app13 := append([]byte{0xFF, 0xED, 0, byte(len(res) + 2)}, res...)
+	jpg := testutil.EncodeJPEG(t, testutil.Gradient(16, 16), 90)
+	d, err = metadata.Read(slices.Concat(jpg[:2], app13, jpg[2:]))
+	if err != nil || d == nil || d.IPTC == nil {
+		t.Fatalf("Read IPTC: %v %v", d, err)
+	}
+	if d.IPTC.Headline != "Harbour" || !slices.Equal(d.IPTC.Keywords, []string{"boats", "dusk"}) {
+		t.Errorf("IPTC = %+v", d.IPTC)
+	}
+
+	// An IFD pointing outside the block is reported, not read.
+	bad := slices.Concat([]byte{0xFF, 0xD8, 0xFF, 0xE1, 0, 16}, []byte("Exif\x00\x00MM\x00\x2a\x00\x00\x10\x00"), jpg[2:])
+	if _, err := metadata.Read(bad); !apperrors.IsCategory(err, apperrors.CategoryDecode) {
+		t.Errorf("malformed EXIF: err = %v", err)
+	}
+}
+
+func TestStripMetadata_KeepsWhatThePolicyNames(t *testing.T) {
+	src := testutil.CameraJPEG(t, 32, 32)
+	d, err := metadata.Read(src)
+	if err != nil || d == nil || d.GPS == nil {
+		t.Fatalf("Read: %+v %v", d, err)
+	}
+	if math.Abs(d.GPS.Latitude-48.8583) > 1e-3 || math.Abs(d.GPS.Longitude-2.2944) > 1e-3 {
+		t.Errorf("GPS = %+v", d.GPS)
+	}
+
+	// Privacy: location and thumbnail go, the rest stays.
+	step := &pipeline.StripMetadataStep{Policy: core.MetadataPolicy{RemoveGPS: true, RemoveThumbnails: true}}
+	out, err := step.Execute(context.Background(), &core.ImageData{Data: src, Format: core.FormatJPEG, Meta: core.Metadata{Details: d}})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.Meta.Details.GPS != nil || out.Meta.Details.Copyright == "" {
+		t.Errorf("meta details = %+v", out.Meta.Details)
+	}
+	if p, _ := out.Attrs[core.AttrMetadata].(core.MetadataPolicy); p != step.Policy {
+		t.Errorf("policy attribute = %+v", out.Attrs[core.AttrMetadata])
+	}
+	got, err := metadata.Read(out.Data)
+	if err != nil {
+		t.Fatalf("Read rewritten: %v", err)
+	}
+	if got.GPS != nil || got.XMP != "" || got.Orientation != 6 || got.Make != "testutil" {
+		t.Errorf("after RemoveGPS: %+v", got)
+	}
+	if n := bytes.Count(out.Data, []byte{0xFF, 0xD8}); n != 1 {
+		t.Errorf("%d SOI markers, thumbnail not removed", n)
+	}
+	if !bytes.Contains(out.Data, []byte("ICC_PROFILE")) {
+		t.Error("ICC profile removed")
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(out.Data)); err != nil {
+		t.Errorf("rewritten JPEG: %v", err)
+	}
+
+	// Strip everything but the copyright.
+	stripped, err := metadata.Rewrite(src, core.MetadataPolicy{Strip: true, KeepCopyright: true})
+	if err != nil {
+		t.Fatalf("Rewrite: %v", err)
+	}
+	got, err = metadata.Read(stripped)
+	if err != nil || got == nil {
+		t.Fatalf("Read stripped: %+v %v", got, err)
+	}
+	if got.Copyright != "(c) 2006 A. Photographer" || got.Artist == "" || got.Orientation != 0 || got.Make != "" || got.GPS != nil {
+		t.Errorf("after Strip+KeepCopyright: %+v", got)
+	}
+	if bytes.Contains(stripped, []byte("ICC_PROFILE")) {
+		t.Error("ICC profile kept without KeepColorProfile")
+	}
+
+	// PNG text chunks follow the same policy.
+	chunk := func(typ, data string) []byte {
+		b := binary.BigEndian.AppendUint32(nil, uint32(len(data)))
+		b = append(b, typ+data...)
+		return binary.BigEndian.AppendUint32(b, crc32.ChecksumIEEE([]byte(typ+data)))
+	}
+	pngData := testutil.EncodePNG(t, testutil.Gradient(8, 8))
+	const ihdrEnd = 8 + 12 + 13
+	tagged := slices.Concat(pngData[:ihdrEnd], chunk("tEXt", "Copyright\x00A. Photographer"), chunk("tEXt", "Comment\x00hello"), pngData[ihdrEnd:])
+	stripped, err = metadata.Rewrite(tagged, core.MetadataPolicy{Strip: true, KeepCopyright: true})
+	if err != nil {
+		t.Fatalf("Rewrite PNG: %v", err)
+	}
+	if !bytes.Contains(stripped, []byte("A. Photographer")) || bytes.Contains(stripped, []byte("hello")) {
+		t.Error("PNG text not filtered by policy")
+	}
+	if _, err := png.Decode(bytes.NewReader(stripped)); err != nil {
+		t.Errorf("rewritten PNG: %v", err)
+	}
+}
+
+func TestSetMetadata_WritesAttributionIntoOutput(t *testing.T) {
+	proc := newProc(t)
+	tags := core.MetadataTags{
+		Artist:    "A. Photographer",
+		Copyright: "(c) 2006 A. Photographer",
+		XMP:       `<x:xmpmeta xmlns:x="adobe:ns:meta/"/>`,
+	}
+	for _, f := range []core.Format{core.FormatJPEG, core.FormatPNG, core.FormatWebP} {
+		result, err := proc.Process(context.Background(),
+			imageprocessor.FromReader(bytes.NewReader(testutil.EXIFJPEG(t, 16, 16, 6))),
+			imageprocessor.Decode(),
+			imageprocessor.StripEXIF(),
+			imageprocessor.SetMetadata(tags),
+			imageprocessor.ConvertFormat(f),
+			imageprocessor.Encode(),
+		)
+		if err != nil {
+			t.Fatalf("%s: Process: %v", f, err)
+		}
+		data := result.Primary.Data
+		d, err := metadata.Read(data)
+		if err != nil || d == nil {
+			t.Fatalf("%s: Read: %+v %v", f, d, err)
+		}
+		if d.Artist != tags.Artist || d.Copyright != tags.Copyright || d.XMP != tags.XMP {
+			t.Errorf("%s: read back %q %q %q", f, d.Artist, d.Copyright, d.XMP)
+		}
+		if _, _, err := image.Decode(bytes.NewReader(data)); err != nil {
+			t.Errorf("%s: tagged output does not decode: %v", f, err)
+		}
+	}
+
+	// Already encoded data is tagged in place, keeping its other EXIF tags.
+	src := testutil.CameraJPEG(t, 16, 16)
+	out, err := (&pipeline.SetMetadataStep{Tags: core.MetadataTags{Software: "image-processor"}}).Execute(context.Background(),
+		&core.ImageData{Data: src, Format: core.FormatJPEG})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	d, err := metadata.Read(out.Data)
+	if err != nil || d == nil || d.Software != "image-processor" || d.GPS == nil || d.Orientation != 6 {
+		t.Errorf("in-place tags: %+v %v", d, err)
+	}
+}
+
+func TestToSRGB_ConvertsThroughEmbeddedProfile(t *testing.T) {
+	// A Display P3 green that sRGB renders as (65, 182, 27).
+	src := image.NewNRGBA(image.Rect(0, 0, 8, 8))
+	draw.Draw(src, src.Bounds(), image.NewUniform(color.NRGBA{100, 180, 60, 255}), image.Point{}, draw.Src)
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, src); err != nil {
+		t.Fatal(err)
+	}
+	p3, err := metadata.SetICC(buf.Bytes(), icc.DisplayP3())
+	if err != nil {
+		t.Fatalf("SetICC: %v", err)
+	}
+
+	result, err := newProc(t).Process(context.Background(),
+		imageprocessor.FromReader(bytes.NewReader(p3)),
+		imageprocessor.Decode(),
+		&pipeline.ToSRGBStep{Embed: true},
+		imageprocessor.Encode(),
+	)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	data := result.Primary.Data
+	out, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Decode: %v", err)
+	}
+	c := color.NRGBAModel.Convert(out.At(4, 4)).(color.NRGBA)
+	for i, want := range [3]uint8{65, 182, 27} {
+		if got := [3]uint8{c.R, c.G, c.B}[i]; math.Abs(float64(got)-float64(want)) > 2 {
+			t.Errorf("converted pixel %v, want about (65, 182, 27)", c)
+			break
+		}
+	}
+	p, err := icc.Parse(metadata.ICC(data))
+	if err != nil || !p.IsSRGB() {
+		t.Errorf("embedded profile: %+v %v", p, err)
+	}
+
+	// Profiles larger than a JPEG segment are split over several APP2s.
+	big := bytes.Repeat([]byte("profile "), 20000)
+	jpg, err := metadata.SetICC(testutil.EXIFJPEG(t, 16, 16, 1), big)
+	if err != nil {
+		t.Fatalf("SetICC: %v", err)
+	}
+	if got := metadata.ICC(jpg); !bytes.Equal(got, big) {
+		t.Errorf("JPEG profile round trip: %d bytes, want %d", len(got), len(big))
+	}
+	if _, err := jpeg.Decode(bytes.NewReader(jpg)); err != nil {
+		t.Errorf("JPEG with profile does not decode: %v", err)
+	}
+}
+
+func TestRedisQueue_PersistsJobsOutsideTheProcess(t *testing.T) {
+	results := make(chan core.JobResult, 1)
+	codec := &uploadCodec{results: results}
+	client := &fakeRedis{lists: map[string][][]byte{}}
+	q, err := queue.NewRedis(client, codec, "test")
+	if err != nil {
+		t.Fatalf("NewRedis: %v", err)
+	}
+	q.PollInterval = time.Millisecond
+
+	// Priorities and crash recovery, on the queue alone.
+	ctx := context.Background()
+	for _, pr := range []core.Priority{core.PriorityLow, core.PriorityHigh} {
+		if err := q.Enqueue(ctx, core.Job{ID: string(pr), Priority: pr}); err != nil {
+			t.Fatalf("Enqueue: %v", err)
+		}
+	}
+	first, err := q.Dequeue(ctx)
+	if err != nil || first.ID != "high" {
+		t.Fatalf("Dequeue = %q, %v; want the high priority job", first.ID, err)
+	}
+	if n, err := q.Recover(ctx); err != nil || n != 1 {
+		t.Fatalf("Recover = %d, %v; want 1", n, err)
+	}
+	for range 2 {
+		job, err := q.Dequeue(ctx)
+		if err != nil {
+			t.Fatalf("Dequeue: %v", err)
+		}
+		if err := q.Ack(ctx, job); err != nil {
+			t.Fatalf("Ack: %v", err)
+		}
+	}
+	if n := client.total(); n != 0 {
+		t.Fatalf("%d payloads left in redis after ack", n)
+	}
+
+	// The processor's workers drain the queue in place of the channels.
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	proc.SetJobQueue(q)
+	proc.Start()
+	t.Cleanup(func() { proc.Stop(context.Background()) })
+	err = proc.Submit(core.Job{
+		ID:     "upload-1",
+		Ctx:    ctx,
+		Source: imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 40, 20))),
+	})
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	select {
+	case r := <-results:
+		if r.Err != nil || r.JobID != "upload-1" || r.Result.Primary.Meta.Width != 20 {
+			t.Fatalf("job = %+v", r)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("queued job timed out")
+	}
+	if s, _ := proc.JobStatus("upload-1"); s.State != core.JobDone {
+		t.Errorf("JobStatus = %+v, want done", s)
+	}
+}
+
+// uploadCodec stores a job's ID, priority and source bytes; every job
+// resizes to 20px wide and reports to results.
+type uploadCodec struct{ results chan core.JobResult }
+
+type uploadRecord struct {
+	ID       string
+	Priority core.Priority
+	Data     []byte
+}
+
+func (c *uploadCodec) Marshal(job core.Job) ([]byte, error) {
+	rec := uploadRecord{ID: job.ID, Priority: job.Priority}
+	if job.Source.Reader != nil {
+		data, err := io.ReadAll(job.Source.Reader)
+		if err != nil {
+			return nil, err
+		}
+		rec.Data = data
+	}
+	return json.Marshal(rec)
+}
+
+func (c *uploadCodec) Unmarshal(data []byte) (core.Job, error) {
+	var rec uploadRecord
+	if err := json.Unmarshal(data, &rec); err != nil {
+		return core.Job{}, err
+	}
+	return core.Job{
+		ID:       rec.ID,
+		Priority: rec.Priority,
+		Source:   imageprocessor.FromReader(bytes.NewReader(rec.Data)),
+		Steps:    []core.Step{imageprocessor.Decode(), imageprocessor.Resize(20, 0)},
+		ResultCh: c.results,
+	}, nil
+}
+
+// fakeRedis implements queue.RedisClient over in-memory lists.
+type fakeRedis struct {
+	mu     sync.Mutex
+	lists  map[string][][]byte
+	values map[string][]byte
+}
+
+func (r *fakeRedis) Get(_ context.Context, key string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.values[key], nil
+}
+
+func (r *fakeRedis) Set(_ context.Context, key string, v []byte, _ time.Duration) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.values == nil {
+		r.values = map[string][]byte{}
+	}
+	r.values[key] = v
+	return nil
+}
+
+func (r *fakeRedis) LPush(_ context.Context, key string, v []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lists[key] = append([][]byte{v}, r.lists[key]...)
+	return nil
+}
+
+func (r *fakeRedis) RPush(_ context.Context, key string, v []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lists[key] = append(r.lists[key], v)
+	return nil
+}
+
+func (r *fakeRedis) RPopLPush(_ context.Context, src, dst string) ([]byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	l := r.lists[src]
+	if len(l) == 0 {
+		return nil, nil
+	}
+	v := l[len(l)-1]
+	r.lists[src] = l[:len(l)-1]
+	r.lists[dst] = append([][]byte{v}, r.lists[dst]...)
+	return v, nil
+}
+
+func (r *fakeRedis) LRem(_ context.Context, key string, v []byte) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lists[key] = slices.DeleteFunc(r.lists[key], func(e []byte) bool { return bytes.Equal(e, v) })
+	return nil
+}
+
+func (r *fakeRedis) total() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, l := range r.lists {
+		n += len(l)
+	}
+	return n
+}
+
+// gateStep signals started and blocks until release is closed or ctx is
+// done.
+type gateStep struct {
+	started chan<- struct{}
+	release <-chan struct{}
+}
+
+func (s *gateStep) Name() string { return "gate" }
+
+func (s *gateStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	s.started <- struct{}{}
+	select {
+	case <-s.release:
+		return img, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// passStep returns the image unchanged.
+type passStep struct{}
+
+func (passStep) Name() string { return "pass" }
+
+func (passStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	return img, nil
+}
+
+// conditionProbe records that a conditional branch ran.
+type conditionProbe struct{ hit *bool }
+
+func (p *conditionProbe) Name() string { return "probe" }
+
+func (p *conditionProbe) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	*p.hit = true
+	return img, nil
+}
+
+func TestClassifyStep_Moderation(t *testing.T) {
+	var gotType string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		gotType = r.Header.Get("Content-Type")
+		if r.Header.Get("Authorization") != "Bearer k" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Write([]byte(`{"scores": {"adult": 0.92, "violence": 0.05}}`))
+	}))
+	defer srv.Close()
+	c := classifier.NewHTTP(srv.URL)
+	c.Header = http.Header{"Authorization": {"Bearer k"}}
+	in := &core.ImageData{Data: newRedJPEG(t, 16, 16), Format: core.FormatJPEG}
+
+	var seen map[string]float64
+	step := &pipeline.ClassifyStep{
+		Classifier: c,
+		Thresholds: map[string]float64{"violence": 0.5},
+		OnScores:   func(_ context.Context, _ *core.ImageData, s map[string]float64) { seen = s },
+	}
+	out, err := step.Execute(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if out.Meta.Scores["adult"] != 0.92 || seen["violence"] != 0.05 || gotType != "image/jpeg" {
+		t.Errorf("scores %v, hook saw %v, content type %q", out.Meta.Scores, seen, gotType)
+	}
+
+	_, err = imageprocessor.Moderate(c, map[string]float64{"adult": 0.8}).Execute(context.Background(), in)
+	if !errors.Is(err, apperrors.ErrRejected) || !apperrors.IsCategory(err, apperrors.CategoryInput) {
+		t.Errorf("adult 0.92 ≥ 0.8: got %v, want ErrRejected", err)
+	}
+
+	c.Header = nil
+	if _, err := step.Execute(context.Background(), in); err == nil {
+		t.Error("401 from the API should fail the step")
+	}
+	step.FailOpen = true
+	if out, err := step.Execute(context.Background(), in); err != nil || out != in {
+		t.Errorf("FailOpen: got %v", err)
+	}
+}
+
+type countingUpscaler struct{ calls int }
+
+func (u *countingUpscaler) Upscale(ctx context.Context, img *core.ImageData, w, h int) (*core.ImageData, error) {
+	u.calls++
+	return pipeline.SharpenUpscaler{}.Upscale(ctx, img, w, h)
+}
+
+func TestUpscaleStep_RoutesEnlargements(t *testing.T) {
+	// Black/white vertical edge.
+	src := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(src, image.Rect(10, 0, 20, 10), image.White, image.Point{}, draw.Src)
+	draw.Draw(src, image.Rect(0, 0, 10, 10), image.Black, image.Point{}, draw.Src)
+	in := &core.ImageData{Image: src}
+
+	up := &countingUpscaler{}
+	out, err := imageprocessor.Upscale(80, 0, up).Execute(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if b := out.Image.(image.Image).Bounds(); b.Dx() != 80 || b.Dy() != 40 || up.calls != 1 {
+		t.Fatalf("got %v after %d upscaler calls, want 80x40 via the upscaler", b, up.calls)
+	}
+	if _, err := imageprocessor.Upscale(10, 0, up).Execute(context.Background(), in); err != nil || up.calls != 1 {
+		t.Errorf("downscale should not use the upscaler (calls %d, err %v)", up.calls, err)
+	}
+
+	// The sharpened edge is steeper than a plain bilinear enlargement.
+	bilinear, _ := imageprocessor.Resize(80, 0).Execute(context.Background(), in)
+	slope := func(m image.Image) int {
+		a, _, _, _ := m.At(38, 20).RGBA()
+		b, _, _, _ := m.At(42, 20).RGBA()
+		return int(b>>8) - int(a>>8)
+	}
+	if s, b := slope(out.Image.(image.Image)), slope(bilinear.Image.(image.Image)); s <= b {
+		t.Errorf("edge slope %d, want steeper than bilinear's %d", s, b)
+	}
+
+	capped := &pipeline.UpscaleStep{Width: 100, MaxFactor: 4}
+	if _, err := capped.Execute(context.Background(), in); !errors.Is(err, apperrors.ErrInvalidDimensions) {
+		t.Errorf("5x over a 4x cap: got %v", err)
+	}
+}
+
+func TestAccessibilitySteps(t *testing.T) {
+	// Red and green halves: distinct to normal vision, nearly identical to a
+	// deuteranope.
+	rg := image.NewNRGBA(image.Rect(0, 0, 20, 10))
+	draw.Draw(rg, image.Rect(0, 0, 10, 10), image.NewUniform(color.NRGBA{200, 60, 40, 255}), image.Point{}, draw.Src)
+	draw.Draw(rg, image.Rect(10, 0, 20, 10), image.NewUniform(color.NRGBA{90, 140, 40, 255}), image.Point{}, draw.Src)
+	dist := func(m *image.NRGBA) int {
+		a, b := m.NRGBAAt(2, 2), m.NRGBAAt(17, 2)
+		d := 0
+		for _, v := range []int{int(a.R) - int(b.R), int(a.G) - int(b.G), int(a.B) - int(b.B)} {
+			d += max(v, -v)
+		}
+		return d
+	}
+	out, err := imageprocessor.SimulateColorBlindness(pipeline.Deuteranopia).
+		Execute(context.Background(), &core.ImageData{Image: rg})
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if before, after := dist(rg), dist(out.Image.(*image.NRGBA)); after > before/3 {
+		t.Errorf("red/green distance %d → %d, want it mostly collapsed", before, after)
+	}
+	if _, err := (&pipeline.ColorBlindStep{Type: "achromatopsia"}).Execute(context.Background(), &core.ImageData{Image: rg}); err == nil {
+		t.Error("unknown deficiency should fail")
+	}
+
+	if r := pipeline.ContrastRatio(color.Black, color.White); r < 20.99 || r > 21.01 {
+		t.Errorf("black/white ratio = %.3f, want 21", r)
+	}
+	chart := &core.ImageData{Image: testutil.TextChart(120, 60)}
+	out, err = imageprocessor.CheckContrast(0).Execute(context.Background(), chart)
+	if err != nil || out.Meta.Contrast == nil {
+		t.Fatalf("CheckContrast: %v", err)
+	}
+	if c := out.Meta.Contrast; c.Ratio < 4.5 || c.RMS <= 0 {
+		t.Errorf("text chart metrics %+v, want high contrast", *c)
+	}
+
+	gray := image.NewNRGBA(image.Rect(0, 0, 10, 10))
+	draw.Draw(gray, image.Rect(0, 0, 5, 10), image.NewUniform(color.NRGBA{110, 110, 110, 255}), image.Point{}, draw.Src)
+	draw.Draw(gray, image.Rect(5, 0, 10, 10), image.NewUniform(color.NRGBA{140, 140, 140, 255}), image.Point{}, draw.Src)
+	if _, err := imageprocessor.CheckContrast(4.5).Execute(context.Background(), &core.ImageData{Image: gray}); !errors.Is(err, apperrors.ErrLowContrast) {
+		t.Errorf("gray-on-gray: got %v, want ErrLowContrast", err)
+	}
+}
+
+func TestProvenance_SurvivesJPEG(t *testing.T) {
+	const key, payload = "licence-key", uint32(0xC0FFEE42)
+	photo := &core.ImageData{Image: testutil.TextChart(320, 240), Format: core.FormatJPEG}
+
+	marked, err := imageprocessor.EmbedMark(key, payload).Execute(context.Background(), photo)
+	if err != nil {
+		t.Fatalf("embed: %v", err)
+	}
+	res, err := imagecompare.CompareImages(photo.Image.(image.Image), marked.Image.(image.Image))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.SSIM < 0.9 {
+		t.Errorf("mark is visible: SSIM %.3f", res.SSIM)
+	}
+
+	// Round-trip through JPEG q80 and verify.
+	jpg := testutil.EncodeJPEG(t, marked.Image.(image.Image), 80)
+	decoded, err := jpeg.Decode(bytes.NewReader(jpg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := payload
+	verify := &provenance.VerifyStep{Key: key, Require: true, Expect: &want}
+	out, err := verify.Execute(context.Background(), &core.ImageData{Image: decoded})
+	if err != nil {
+		m, _ := provenance.Extract(decoded, key)
+		t.Fatalf("verify after JPEG: %v (read %08x at %.2f)", err, m.Payload, m.Confidence)
+	}
+	if got, _ := out.Attrs[provenance.AttrPayload].(uint32); got != payload {
+		t.Errorf("payload %08x, want %08x", got, payload)
+	}
+
+	if _, found := provenance.Extract(decoded, "other-key"); found {
+		t.Error("mark should not be readable with another key")
+	}
+	_, err = verify.Execute(context.Background(), photo)
+	if !errors.Is(err, apperrors.ErrNoWatermark) {
+		t.Errorf("unmarked image: got %v, want ErrNoWatermark", err)
+	}
+	if _, err := imageprocessor.EmbedMark(key, 1).Execute(context.Background(),
+		&core.ImageData{Image: testutil.Gradient(40, 40)}); !errors.Is(err, apperrors.ErrInvalidDimensions) {
+		t.Errorf("40x40 image: got %v, want ErrInvalidDimensions", err)
+	}
+}
+
+func TestProcessFromStorage(t *testing.T) {
+	proc := newProc(t)
+	store, err := storage.NewLocal(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := core.StorageKey{Bucket: "uploads", Path: "photos/cat.jpeg"}
+	if err := store.Put(context.Background(), in, bytes.NewReader(newRedJPEG(t, 120, 80)), nil); err != nil {
+		t.Fatal(err)
+	}
+
+	res, err := proc.ProcessFromStorage(context.Background(), store, in, "out/{name}.{ext}",
+		imageprocessor.Decode(), imageprocessor.Resize(60, 0), imageprocessor.ConvertFormat(core.FormatPNG), imageprocessor.Encode())
+	if err != nil {
+		t.Fatalf("ProcessFromStorage: %v", err)
+	}
+	want := core.StorageKey{Bucket: "uploads", Path: "out/cat.png"}
+	if len(res.Written) != 1 || res.Written[0].Key != want || res.Written[0].Size != int64(len(res.Primary.Data)) {
+		t.Fatalf("written %+v, want %v", res.Written, want)
+	}
+	if ok, _ := store.Exists(context.Background(), want); !ok {
+		t.Error("output not stored")
+	}
+
+	res, err = proc.ProcessVariantsFromStorage(context.Background(), store, in, "{dir}/{name}_{variant}.{ext}",
+		[]core.Step{imageprocessor.Decode()},
+		[]core.VariantDefinition{
+			{Name: "sm", Steps: []core.Step{imageprocessor.Resize(30, 0), imageprocessor.Encode()}},
+			{Name: "md", Steps: []core.Step{imageprocessor.Resize(60, 0), imageprocessor.Encode()}},
+		})
+	if err != nil {
+		t.Fatalf("ProcessVariantsFromStorage: %v", err)
+	}
+	var keys []string
+	for _, w := range res.Written {
+		keys = append(keys, w.Key.Path)
+	}
+	if got := strings.Join(keys, " "); got != "photos/cat.jpg photos/cat_md.jpg photos/cat_sm.jpg" {
+		t.Errorf("written keys %s", got)
+	}
+	if _, err := proc.ProcessVariantsFromStorage(context.Background(), store, in, "{name}.{ext}", nil, nil); err == nil {
+		t.Error("variant template without {variant} should fail")
+	}
+}
+
+func TestProcessPages_MultiPageTIFF(t *testing.T) {
+	proc := newProc(t)
+	var pages []image.Image
+	for i := 1; i <= 4; i++ {
+		p := image.NewNRGBA(image.Rect(0, 0, 40*i, 30))
+		draw.Draw(p, p.Bounds(), image.NewUniform(testutil.FrameColor(i)), image.Point{}, draw.Src)
+		pages = append(pages, p)
+	}
+	doc := testutil.MultiPageTIFF(t, pages...)
+
+	res, err := proc.ProcessPages(context.Background(), imageprocessor.FromReader(bytes.NewReader(doc)),
+		imageprocessor.PageRange{From: 2}, "thumb-{page3}",
+		imageprocessor.ConvertFormat(core.FormatPNG), imageprocessor.Encode())
+	if err != nil {
+		t.Fatalf("ProcessPages: %v", err)
+	}
+	if len(res.Variants) != 3 {
+		t.Fatalf("got %d pages, want 3 (pages 2-4)", len(res.Variants))
+	}
+	for i := 2; i <= 4; i++ {
+		v := res.Variants[fmt.Sprintf("thumb-%03d", i)]
+		if v == nil || len(v.Data) == 0 {
+			t.Fatalf("page %d missing or not encoded", i)
+		}
+		decoded, err := png.Decode(bytes.NewReader(v.Data))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if w := decoded.Bounds().Dx(); w != 40*i {
+			t.Errorf("page %d is %dpx wide, want %d", i, w, 40*i)
+		}
+		if got := color.NRGBAModel.Convert(decoded.At(5, 5)); got != testutil.FrameColor(i) {
+			t.Errorf("page %d colour %v, want %v", i, got, testutil.FrameColor(i))
+		}
+		if v.Meta.Pages != 4 {
+			t.Errorf("page %d Meta.Pages = %d", i, v.Meta.Pages)
+		}
+	}
+
+	// The ordinary pipeline still decodes page 1.
+	first, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(doc)), imageprocessor.Decode())
+	if err != nil || first.Primary.Meta.Width != 40 {
+		t.Errorf("Process on TIFF: %v", err)
+	}
+	if _, err := proc.ProcessPages(context.Background(), imageprocessor.FromReader(bytes.NewReader(doc)),
+		imageprocessor.PageRange{From: 5}, ""); !errors.Is(err, apperrors.ErrInvalidDimensions) {
+		t.Errorf("page 5 of 4: got %v", err)
+	}
+}
+
+func TestStorageResult_UploadManifest(t *testing.T) {
+	proc := newProc(t)
+	store, err := storage.NewLocal(t.TempDir(), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	in := core.StorageKey{Bucket: "uploads", Path: "photos/cat.jpeg"}
+	if err := store.Put(ctx, in, bytes.NewReader(newRedJPEG(t, 120, 80)), nil); err != nil {
+		t.Fatal(err)
+	}
+	sm := []core.Step{imageprocessor.Resize(30, 0), imageprocessor.Encode()}
+	res, err := proc.ProcessVariantsFromStorage(ctx, store, in, "{dir}/{name}_{variant}.{ext}",
+		[]core.Step{imageprocessor.Decode()}, []core.VariantDefinition{{Name: "sm", Steps: sm}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	key := core.StorageKey{Bucket: "uploads", Path: "photos/cat.manifest.json"}
+	fp := manifest.Fingerprint(sm...)
+	if _, err := res.UploadManifest(ctx, store, key, manifest.Options{Source: in.Path, Fingerprint: fp}); err != nil {
+		t.Fatalf("UploadManifest: %v", err)
+	}
+	rc, err := store.Get(ctx, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rc.Close()
+	var m manifest.Manifest
+	if err := json.NewDecoder(rc).Decode(&m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Version != manifest.Version || m.Fingerprint != fp || len(m.Entries) != 2 {
+		t.Fatalf("manifest %+v", m)
+	}
+	e, ok := m.Entry("sm")
+	if !ok || e.Key != "photos/cat_sm.jpg" || e.Width != 30 || e.Height != 20 || e.ContentType != "image/jpeg" {
+		t.Fatalf("sm entry %+v", e)
+	}
+	sum := sha256.Sum256(res.Variants["sm"].Data)
+	if e.SHA256 != hex.EncodeToString(sum[:]) || e.Size != int64(len(res.Variants["sm"].Data)) {
+		t.Errorf("sm hash/size mismatch: %+v", e)
+	}
+	if len(e.BlurHash) != 28 { // 4×3 components
+		t.Errorf("blurhash %q", e.BlurHash)
+	}
+
+	if manifest.Fingerprint(imageprocessor.Resize(30, 0), imageprocessor.Encode()) != fp {
+		t.Error("fingerprint not deterministic")
+	}
+	if manifest.Fingerprint(imageprocessor.Resize(31, 0), imageprocessor.Encode()) == fp {
+		t.Error("fingerprint ignores step parameters")
+	}
+}
+
+func TestEncodeWebP_ProducesRealWebP(t *testing.T) {
+	proc := newProc(t)
+	src := image.NewNRGBA(image.Rect(0, 0, 90, 60))
+	for y := 0; y < 60; y++ {
+		for x := 0; x < 90; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{uint8(x * 2), uint8(y * 4), uint8(x ^ y), uint8(255 - x)})
+		}
+	}
+	var raw bytes.Buffer
+	if err := png.Encode(&raw, src); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, lossless := range []bool{true, false} {
+		res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(raw.Bytes())),
+			imageprocessor.Decode(), imageprocessor.ConvertFormat(imageprocessor.WebP),
+			imageprocessor.EncodeOpts(core.EncodeOptions{Lossless: lossless, Quality: 60}))
+		if err != nil {
+			t.Fatalf("lossless=%v: %v", lossless, err)
+		}
+		data := res.Primary.Data
+		if !bytes.HasPrefix(data, []byte("RIFF")) || string(data[8:12]) != "WEBP" {
+			t.Fatalf("lossless=%v: not a RIFF/WEBP file: % x", lossless, data[:12])
+		}
+		back, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(data)), imageprocessor.Decode())
+		if err != nil {
+			t.Fatalf("lossless=%v: decode: %v", lossless, err)
+		}
+		dec := back.Primary.Image.(image.Image)
+		if !lossless {
+			continue
+		}
+		for y := 0; y < 60; y++ {
+			for x := 0; x < 90; x++ {
+				if got := color.NRGBAModel.Convert(dec.At(x, y)); got != src.NRGBAAt(x, y) {
+					t.Fatalf("pixel (%d,%d) = %v, want %v", x, y, got, src.NRGBAAt(x, y))
+				}
+			}
+		}
+	}
+}
+
+func TestHEIF_WithoutDecoderIsUnsupported(t *testing.T) {
+	proc := newProc(t)
+	// ftyp box of an iPhone HEIC: major brand heic, compatible mif1/heic.
+	heic := append([]byte{0, 0, 0, 0x18}, "ftypheic\x00\x00\x00\x00mif1heic"...)
+	heic = append(heic, make([]byte, 64)...)
+	if got := utils.DetectFormat(heic); got != string(imageprocessor.HEIF) {
+		t.Fatalf("DetectFormat = %q", got)
+	}
+	if proc.CanDecode(imageprocessor.HEIF) {
+		t.Fatal("stdlib processor should not claim HEIF support")
+	}
+	if !proc.CanDecode(imageprocessor.JPEG) {
+		t.Fatal("JPEG should be decodable")
+	}
+	_, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(heic)),
+		imageprocessor.Decode(), imageprocessor.ConvertFormat(imageprocessor.JPEG), imageprocessor.Encode())
+	if !errors.Is(err, apperrors.ErrUnsupportedFormat) {
+		t.Fatalf("got %v, want ErrUnsupportedFormat", err)
+	}
+}
+
+func TestEachFrame_ResizesAnimatedGIF(t *testing.T) {
+	proc := newProc(t)
+	const h, n = 40, 4
+	src := testutil.AnimatedGIF(t, h, n) // 120×40
+
+	result, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(src)),
+		imageprocessor.Decode(),
+		imageprocessor.EachFrame(imageprocessor.Resize(60, 0)),
+		imageprocessor.Encode(),
+	)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if result.Primary.Format != imageprocessor.GIF || result.Primary.Meta.Frames != n {
+		t.Fatalf("format %s, frames %d", result.Primary.Format, result.Primary.Meta.Frames)
+	}
+	g, err := gif.DecodeAll(bytes.NewReader(result.Primary.Data))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if len(g.Image) != n || g.Config.Width != 60 || g.Config.Height != 20 {
+		t.Fatalf("got %d frames at %dx%d, want %d at 60x20", len(g.Image), g.Config.Width, g.Config.Height, n)
+	}
+	for i, d := range g.Delay {
+		if d != 10 {
+			t.Errorf("frame %d delay = %d, want 10", i, d)
+		}
+	}
+	// The last frame's square sits at the right edge of the canvas.
+	got := color.NRGBAModel.Convert(g.Image[n-1].At(55, 10)).(color.NRGBA)
+	want := testutil.FrameColor(n - 1)
+	near := func(a, b uint8) bool { return a-b < 16 || b-a < 16 }
+	if !near(got.R, want.R) || !near(got.G, want.G) || !near(got.B, want.B) {
+		t.Errorf("last frame pixel = %v, want %v", got, want)
+	}
+}
+
+func TestAnimatedWebP_RoundTripAndFirstFrameOnly(t *testing.T) {
+	proc := newProc(t)
+	const h, n = 40, 4
+	result, err := proc.Process(context.Background(),
+		imageprocessor.FromReader(bytes.NewReader(testutil.AnimatedGIF(t, h, n))),
+		imageprocessor.Decode(),
+		imageprocessor.EachFrame(imageprocessor.Resize(60, 0)),
+		imageprocessor.ConvertFormat(imageprocessor.WebP),
+		imageprocessor.EncodeOpts(core.EncodeOptions{Lossless: true}),
+	)
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	webpData := result.Primary.Data
+	if !utils.IsAnimatedWebP(webpData) {
+		t.Fatal("output is not an animated WebP")
+	}
+	a, err := animation.DecodeAnimation(webpData)
+	if err != nil {
+		t.Fatalf("DecodeAnimation: %v", err)
+	}
+	if len(a.Frames) != n || a.Bounds().Dx() != 60 || a.Bounds().Dy() != 20 {
+		t.Fatalf("got %d frames at %v, want %d at 60x20", len(a.Frames), a.Bounds(), n)
+	}
+	if a.Delay(1) != 100*time.Millisecond || a.LoopCount != 0 {
+		t.Errorf("delay %v, loops %d", a.Delay(1), a.LoopCount)
+	}
+
+	// Re-decoding the WebP keeps the animation unless told otherwise.
+	again, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(webpData)),
+		imageprocessor.Decode())
+	if err != nil {
+		t.Fatalf("decode WebP: %v", err)
+	}
+	if again.Primary.Meta.Frames != n {
+		t.Errorf("Frames = %d, want %d", again.Primary.Meta.Frames, n)
+	}
+
+	cfg := imageprocessor.DefaultConfig()
+	cfg.FirstFrameOnly = true
+	still := imageprocessor.New(cfg)
+	res, err := still.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(webpData)),
+		imageprocessor.Decode())
+	if err != nil {
+		t.Fatalf("decode first frame: %v", err)
+	}
+	if _, ok := res.Primary.Image.(*core.Animation); ok || res.Primary.Meta.Frames != 0 {
+		t.Errorf("FirstFrameOnly decoded %T with %d frames", res.Primary.Image, res.Primary.Meta.Frames)
+	}
+}
+
+func TestTIFF_DecodePageAndEncode(t *testing.T) {
+	proc := newProc(t)
+	var pages []image.Image
+	for i := 1; i <= 3; i++ {
+		p := image.NewNRGBA(image.Rect(0, 0, 20*i, 16))
+		draw.Draw(p, p.Bounds(), image.NewUniform(testutil.FrameColor(i)), image.Point{}, draw.Src)
+		pages = append(pages, p)
+	}
+	doc := testutil.MultiPageTIFF(t, pages...)
+
+	res, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(doc)),
+		imageprocessor.DecodePage(3), imageprocessor.Encode())
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	if res.Primary.Format != imageprocessor.TIFF || res.Primary.Meta.Width != 60 {
+		t.Fatalf("got %s %dpx wide, want TIFF page 3", res.Primary.Format, res.Primary.Meta.Width)
+	}
+	out, err := xtiff.Decode(bytes.NewReader(res.Primary.Data))
+	if err != nil {
+		t.Fatalf("decode output: %v", err)
+	}
+	if got := color.NRGBAModel.Convert(out.At(10, 8)); got != testutil.FrameColor(3) {
+		t.Errorf("colour %v, want %v", got, testutil.FrameColor(3))
+	}
+
+	if _, err := proc.Process(context.Background(), imageprocessor.FromReader(bytes.NewReader(newRedJPEG(t, 8, 8))),
+		imageprocessor.DecodePage(2)); !errors.Is(err, apperrors.ErrUnsupportedFormat) {
+		t.Errorf("page 2 of a JPEG: got %v", err)
+	}
+}
+
+func TestJXL_DetectedButNeedsVips(t *testing.T) {
+	container := []byte("\x00\x00\x00\x0cJXL \r\n\x87\n\x00\x00\x00\x14ftypjxl ")
+	for _, data := range [][]byte{{0xFF, 0x0A, 0xFA, 0x1F, 0x00}, container} {
+		if got := utils.DetectFormat(data); got != string(imageprocessor.JXL) {
+			t.Errorf("DetectFormat(% x) = %q, want jxl", data[:4], got)
+		}
+	}
+	if imageprocessor.JXL.ContentType() != "image/jxl" || imageprocessor.JXL.Ext() != "jxl" {
+		t.Errorf("JXL content type %q, ext %q", imageprocessor.JXL.ContentType(), imageprocessor.JXL.Ext())
+	}
+	if newProc(t).CanDecode(imageprocessor.JXL) {
+		t.Error("stdlib processor should not claim JXL support")
+	}
+}
+
+func TestRasterizeSVG_RendersAndSanitizes(t *testing.T) {
+	proc := newProc(t)
+	doc := `<?xml version="1.0" encoding="UTF-8"?>
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:xlink="http://www.w3.org/1999/xlink" viewBox="0 0 40 20">
+  <rect width="20" height="20" fill="#ff0000"/>
+  <circle cx="30" cy="10" r="8" style="fill: rgb(0, 0, 255)"/>
+  <image xlink:href="https://tracker.example/pixel.png" width="40" height="20"/>
+  <script>fetch("https://tracker.example/")</script>
+</svg>`
+	if got := utils.DetectFormat([]byte(doc)); got != "svg" {
+		t.Fatalf("DetectFormat = %q", got)
+	}
+
+	res, err := proc.Process(context.Background(), imageprocessor.FromReader(strings.NewReader(doc)),
+		imageprocessor.RasterizeSVG(80), imageprocessor.Decode(), imageprocessor.Encode())
+	if err != nil {
+		t.Fatalf("Process: %v", err)
+	}
+	out, err := png.Decode(bytes.NewReader(res.Primary.Data))
+	if err != nil {
+		t.Fatalf("output is not PNG: %v", err)
+	}
+	if b := out.Bounds(); b.Dx() != 80 || b.Dy() != 40 {
+		t.Fatalf("size %v, want 80x40", b)
+	}
+	for _, c := range []struct {
+		x, y int
+		want color.NRGBA
+	}{
+		{20, 20, color.NRGBA{255, 0, 0, 255}},
+		{60, 20, color.NRGBA{0, 0, 255, 255}},
+		{78, 2, color.NRGBA{}}, // outside both shapes
+	} {
+		if got := color.NRGBAModel.Convert(out.At(c.x, c.y)); got != c.want {
+			t.Errorf("pixel (%d,%d) = %v, want %v", c.x, c.y, got, c.want)
+		}
+	}
+
+	clean, err := svg.Sanitize([]byte(doc), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(clean, []byte("tracker.example")) || !bytes.Contains(clean, []byte("<circle")) {
+		t.Errorf("sanitized document:\n%s", clean)
+	}
+	if kept, _ := svg.Sanitize([]byte(doc), svg.AllowHosts("tracker.example")); !bytes.Contains(kept, []byte("<image")) {
+		t.Error("allowlisted image reference was removed")
+	}
+
+	xxe := `<?xml version="1.0"?><!DOCTYPE svg [<!ENTITY x SYSTEM "file:///etc/passwd">]><svg xmlns="http://www.w3.org/2000/svg"><text>&x;</text></svg>`
+	if _, err := proc.Process(context.Background(), imageprocessor.FromReader(strings.NewReader(xxe)),
+		imageprocessor.RasterizeSVG(0)); !errors.Is(err, apperrors.ErrUnsafeContent) {
+		t.Errorf("entity declaration: got %v, want ErrUnsafeContent", err)
+	}
+}
+
 func TestProcess_FormatConversion_JPEG_to_PNG(t *testing.T) {
 	proc := newProc(t)
 	raw := newRedJPEG(t, 200, 200)
@@ -125,6 +4604,200 @@ func TestProcess_FormatConversion_JPEG_to_PNG(t *testing.T) {
 	}
 }
 
+// sofSampling returns the luma sampling factors from a JPEG's SOF marker.
+func sofSampling(t *testing.T, data []byte) (h, v byte) {
+	t.Helper()
+	for i := 2; i+11 < len(data); {
+		if data[i] != 0xFF {
+			t.Fatalf("bad marker at %d", i)
+		}
+		m, length := data[i+1], int(data[i+2])<<8|int(data[i+3])
+		if m == 0xC0 || m == 0xC2 {
+			return data[i+11] >> 4, data[i+11] & 0x0F
+		}
+		i += 2 + length
+	}
+	t.Fatal("no SOF marker")
+	return 0, 0
+}
+
+func TestJPEGEncoder_Subsampling(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 37, 23))
+	for y := 0; y < 23; y++ {
+		for x := 0; x < 37; x++ {
+			src.SetRGBA(x, y, color.RGBA{R: uint8(x * 6), G: uint8(y * 10), B: 90, A: 255})
+		}
+	}
+	in := &core.ImageData{Image: src, Format: core.FormatJPEG}
+	enc := encoder.NewJPEG(90)
+
+	tests := []struct {
+		mode   core.SubsampleMode
+		wantHV [2]byte
+	}{
+		{core.Subsample444, [2]byte{1, 1}},
+		{core.Subsample422, [2]byte{2, 1}},
+		{core.Subsample420, [2]byte{2, 2}},
+	}
+	for _, tc := range tests {
+		var opts core.EncodeOptions
+		opts.JPEG().Subsample = tc.mode
+		data, err := enc.Encode(context.Background(), in, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.mode, err)
+		}
+		if h, v := sofSampling(t, data); [2]byte{h, v} != tc.wantHV {
+			t.Errorf("%s: sampling %dx%d, want %v", tc.mode, h, v, tc.wantHV)
+		}
+		dec, err := jpeg.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", tc.mode, err)
+		}
+		if dec.Bounds().Dx() != 37 || dec.Bounds().Dy() != 23 {
+			t.Fatalf("%s: decoded bounds %v", tc.mode, dec.Bounds())
+		}
+		r, g, _, _ := dec.At(30, 20).RGBA()
+		if d := int(r>>8) - 180; d < -12 || d > 12 {
+			t.Errorf("%s: red at (30,20) = %d, want ~180", tc.mode, r>>8)
+		}
+		if d := int(g>>8) - 200; d < -12 || d > 12 {
+			t.Errorf("%s: green at (30,20) = %d, want ~200", tc.mode, g>>8)
+		}
+	}
+}
+
+func TestPNGEncoder_Options(t *testing.T) {
+	gradient := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	twoColor := image.NewNRGBA(image.Rect(0, 0, 33, 17))
+	for y := 0; y < 17; y++ {
+		for x := 0; x < 33; x++ {
+			gradient.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 7), G: uint8(y * 15), B: 40, A: uint8(255 - x)})
+			c := color.NRGBA{R: 255, A: 255}
+			if (x+y)%2 == 0 {
+				c = color.NRGBA{A: 0}
+			}
+			twoColor.SetNRGBA(x, y, c)
+		}
+	}
+	enc := encoder.NewPNG()
+
+	tests := []struct {
+		name          string
+		src           *image.NRGBA
+		opts          core.PNGOptions
+		wantColorType byte
+		wantDepth     byte
+	}{
+		{"paeth", gradient, core.PNGOptions{Filter: core.PNGFilterPaeth, CompressionLevel: 9}, 6, 8},
+		{"adaptive", gradient, core.PNGOptions{CompressionLevel: 1}, 6, 8},
+		{"reduce palette", twoColor, core.PNGOptions{Reduce: true}, 3, 1},
+	}
+	for _, tc := range tests {
+		var opts core.EncodeOptions
+		opts.SetExt(&tc.opts)
+		data, err := enc.Encode(context.Background(), &core.ImageData{Image: tc.src}, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		// IHDR payload starts at offset 16: width, height, depth, colour type.
+		if data[24] != tc.wantDepth || data[25] != tc.wantColorType {
+			t.Errorf("%s: depth %d colour type %d, want %d/%d", tc.name, data[24], data[25], tc.wantDepth, tc.wantColorType)
+		}
+		dec, err := png.Decode(bytes.NewReader(data))
+		if err != nil {
+			t.Fatalf("%s: decode: %v", tc.name, err)
+		}
+		for y := 0; y < 17; y++ {
+			for x := 0; x < 33; x++ {
+				want := tc.src.NRGBAAt(x, y)
+				if got := color.NRGBAModel.Convert(dec.At(x, y)).(color.NRGBA); got != want && want.A != 0 {
+					t.Fatalf("%s: pixel (%d,%d) = %v, want %v", tc.name, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+func TestEncoders_Interlaced(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 29, 19))
+	for y := 0; y < 19; y++ {
+		for x := 0; x < 29; x++ {
+			src.SetNRGBA(x, y, color.NRGBA{R: uint8(x * 8), G: uint8(y * 13), B: 200, A: 255})
+		}
+	}
+	in := &core.ImageData{Image: src}
+	opts := core.EncodeOptions{Interlaced: true, Quality: 95}
+
+	pngData, err := encoder.NewPNG().Encode(context.Background(), in, opts)
+	if err != nil {
+		t.Fatalf("png: %v", err)
+	}
+	// IHDR interlace method is the last byte of the 13-byte payload.
+	if pngData[28] != 1 {
+		t.Errorf("png interlace method = %d, want 1 (Adam7)", pngData[28])
+	}
+	dec, err := png.Decode(bytes.NewReader(pngData))
+	if err != nil {
+		t.Fatalf("png decode: %v", err)
+	}
+	for y := 0; y < 19; y++ {
+		for x := 0; x < 29; x++ {
+			if got := color.NRGBAModel.Convert(dec.At(x, y)); got != src.NRGBAAt(x, y) {
+				t.Fatalf("png pixel (%d,%d) = %v, want %v", x, y, got, src.NRGBAAt(x, y))
+			}
+		}
+	}
+
+	for _, mode := range []core.SubsampleMode{core.SubsampleAuto, core.Subsample444} {
+		o := opts
+		o.JPEG().Subsample = mode
+		jpgData, err := encoder.NewJPEG(0).Encode(context.Background(), in, o)
+		if err != nil {
+			t.Fatalf("jpeg %q: %v", mode, err)
+		}
+		if !bytes.Contains(jpgData, []byte{0xFF, 0xC2}) || bytes.Contains(jpgData, []byte{0xFF, 0xC0}) {
+			t.Errorf("jpeg %q: want an SOF2 (progressive) frame header", mode)
+		}
+		dec, err := jpeg.Decode(bytes.NewReader(jpgData))
+		if err != nil {
+			t.Fatalf("jpeg %q decode: %v", mode, err)
+		}
+		r, g, b, _ := dec.At(20, 10).RGBA()
+		if d := int(r>>8) - 160; d < -10 || d > 10 {
+			t.Errorf("jpeg %q: red at (20,10) = %d, want ~160", mode, r>>8)
+		}
+		if d := int(g>>8) - 130; d < -10 || d > 10 {
+			t.Errorf("jpeg %q: green at (20,10) = %d, want ~130", mode, g>>8)
+		}
+		if d := int(b>>8) - 200; d < -10 || d > 10 {
+			t.Errorf("jpeg %q: blue at (20,10) = %d, want ~200", mode, b>>8)
+		}
+	}
+}
+
+func TestEncodeOptions_TypedExtensions(t *testing.T) {
+	var base core.EncodeOptions
+	base.JPEG().Subsample = core.Subsample444
+	base.AVIF().Effort = 4
+
+	cp := base
+	cp.JPEG().Subsample = core.Subsample420
+	cp.PNG().Reduce = true
+
+	if got := base.JPEG().Subsample; got != core.Subsample444 {
+		t.Errorf("original JPEG subsample = %q after modifying copy", got)
+	}
+	if _, ok := base.Ext(core.FormatPNG); ok {
+		t.Error("PNG extension leaked from copy into original")
+	}
+	if got := cp.AVIF().Effort; got != 4 {
+		t.Errorf("copy AVIF effort = %d, want 4", got)
+	}
+	fo, ok := cp.Ext(core.FormatJPEG)
+	if !ok || fo.(*core.JPEGOptions).Subsample != core.Subsample420 {
+		t.Errorf("copy JPEG extension = %#v", fo)
+	}
+}
 func TestProcess_Thumbnail(t *testing.T) {
 	proc := newProc(t)
 	raw := newRedJPEG(t, 800, 400) // wide landscape
@@ -144,6 +4817,60 @@ func TestProcess_Thumbnail(t *testing.T) {
 	}
 }
 
+func TestThumbnailStep_Fit(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	in := &core.ImageData{Image: src, Meta: core.Metadata{Width: 800, Height: 400}}
+
+	for _, fit := range []pipeline.Fit{pipeline.FitCover, pipeline.FitContain} {
+		out, err := imageprocessor.ThumbnailFit(320, 180, fit).Execute(context.Background(), in)
+		if err != nil {
+			t.Fatalf("%s: %v", fit, err)
+		}
+		if out.Meta.Width != 320 || out.Meta.Height != 180 {
+			t.Errorf("%s: got %dx%d, want 320x180", fit, out.Meta.Width, out.Meta.Height)
+		}
+	}
+}
+
+func TestCropStep_GravityAndAspect(t *testing.T) {
+	// Left half red, right half blue.
+	src := image.NewRGBA(image.Rect(0, 0, 800, 400))
+	for y := 0; y < 400; y++ {
+		for x := 0; x < 800; x++ {
+			c := color.RGBA{R: 255, A: 255}
+			if x >= 400 {
+				c = color.RGBA{B: 255, A: 255}
+			}
+			src.SetRGBA(x, y, c)
+		}
+	}
+	in := &core.ImageData{Image: src, Meta: core.Metadata{Width: 800, Height: 400}}
+
+	tests := []struct {
+		name         string
+		step         *pipeline.CropStep
+		wantW, wantH int
+		wantColor    color.RGBA
+	}{
+		{"aspect 4:5 center", &pipeline.CropStep{AspectRatio: 4.0 / 5.0}, 320, 400, color.RGBA{R: 255, A: 255}},
+		{"gravity east", &pipeline.CropStep{Width: 100, Height: 100, Gravity: core.GravityEast}, 100, 100, color.RGBA{B: 255, A: 255}},
+		{"percent", &pipeline.CropStep{WidthPercent: 25, HeightPercent: 50, XPercent: 75}, 200, 200, color.RGBA{B: 255, A: 255}},
+		{"aspect 16:9 west", &pipeline.CropStep{AspectRatio: 16.0 / 9.0, Gravity: core.GravityWest}, 711, 400, color.RGBA{R: 255, A: 255}},
+	}
+	for _, tc := range tests {
+		out, err := tc.step.Execute(context.Background(), in)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if out.Meta.Width != tc.wantW || out.Meta.Height != tc.wantH {
+			t.Errorf("%s: got %dx%d, want %dx%d", tc.name, out.Meta.Width, out.Meta.Height, tc.wantW, tc.wantH)
+		}
+		if got := out.Image.(*image.RGBA).RGBAAt(0, 0); got != tc.wantColor {
+			t.Errorf("%s: top-left pixel %v, want %v", tc.name, got, tc.wantColor)
+		}
+	}
+}
+
 func TestProcess_StripEXIF(t *testing.T) {
 	proc := newProc(t)
 	raw := newRedJPEG(t, 100, 100)
@@ -179,6 +4906,51 @@ func TestProcess_Grayscale(t *testing.T) {
 	}
 }
 
+func TestGrayscaleStep_Rec709AndAlpha(t *testing.T) {
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 1))
+	src.SetNRGBA(0, 0, color.NRGBA{G: 255, A: 255})
+	src.SetNRGBA(1, 0, color.NRGBA{R: 255, A: 128})
+	in := &core.ImageData{Image: src}
+
+	out, err := (&pipeline.GrayscaleStep{}).Execute(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Execute: %v", err)
+	}
+	if got := out.Image.(*image.NRGBA).NRGBAAt(1, 0); got != (color.NRGBA{R: 54, G: 54, B: 54, A: 128}) {
+		t.Errorf("red+alpha: got %v, want {54 54 54 128}", got)
+	}
+	if !out.Meta.HasAlpha {
+		t.Error("HasAlpha not set for gray+alpha output")
+	}
+
+	out, err = (&pipeline.GrayscaleStep{DropAlpha: true}).Execute(context.Background(), in)
+	if err != nil {
+		t.Fatalf("Execute(DropAlpha): %v", err)
+	}
+	if got := out.Image.(*image.Gray).GrayAt(0, 0).Y; got != 182 {
+		t.Errorf("green luma: got %d, want 182", got)
+	}
+	if out.Meta.HasAlpha {
+		t.Error("HasAlpha set after dropping alpha")
+	}
+
+	// 16-bit sources, premultiplied or not, keep 16 bits and their alpha.
+	n64 := image.NewNRGBA64(image.Rect(0, 0, 1, 1))
+	n64.SetNRGBA64(0, 0, color.NRGBA64{G: 0xffff, A: 0x8000})
+	r64 := image.NewRGBA64(image.Rect(0, 0, 1, 1))
+	r64.Set(0, 0, n64.NRGBA64At(0, 0))
+	for _, src := range []image.Image{n64, r64} {
+		out, err := (&pipeline.GrayscaleStep{}).Execute(context.Background(), &core.ImageData{Image: src})
+		if err != nil {
+			t.Fatalf("Execute(%T): %v", src, err)
+		}
+		got := out.Image.(*image.NRGBA64).NRGBA64At(0, 0)
+		if got != (color.NRGBA64{R: 46870, G: 46870, B: 46870, A: 0x8000}) {
+			t.Errorf("%T green+alpha: got %v, want luma 46870 at alpha 0x8000", src, got)
+		}
+	}
+}
+
 func TestProcess_ContextCancel(t *testing.T) {
 	proc := newProc(t)
 	raw := newRedJPEG(t, 100, 100)
@@ -216,6 +4988,68 @@ func TestScaleDimensions(t *testing.T) {
 	}
 }
 
+func TestDetect(t *testing.T) {
+	ftyp := func(major string, compat ...string) []byte {
+		b := append([]byte{0, 0, 0, byte(16 + 4*len(compat))}, "ftyp"+major+"\x00\x00\x00\x00"...)
+		for _, c := range compat {
+			b = append(b, c...)
+		}
+		return b
+	}
+	// ifd0 builds a little-endian TIFF header and IFD0 from 12-byte entries,
+	// followed by extra data (at offset 38 with two entries).
+	ifd0 := func(extra string, entries ...string) []byte {
+		b := append([]byte("II*\x00\x08\x00\x00\x00"), byte(len(entries)), 0)
+		for _, e := range entries {
+			b = append(b, e...)
+		}
+		return append(append(b, 0, 0, 0, 0), extra...)
+	}
+	makeTag := "\x0f\x01\x02\x00\x12\x00\x00\x00\x26\x00\x00\x00" // Make, 18 bytes at offset 38
+	subIFDs := "\x4a\x01\x04\x00\x01\x00\x00\x00\x00\x00\x00\x00"
+	width := "\x00\x01\x03\x00\x01\x00\x00\x00\x10\x00\x00\x00"
+	tests := []struct {
+		name string
+		data []byte
+		want string
+		conf float64
+	}{
+		{"gif", []byte("GIF89a\x01\x00\x01\x00"), "gif", utils.ConfidenceMagic},
+		{"tiff le", []byte("II*\x00\x08\x00\x00\x00"), "tiff", utils.ConfidenceMagic},
+		{"tiff be", []byte("MM\x00*\x00\x00\x00\x08"), "tiff", utils.ConfidenceMagic},
+		{"cr2", []byte("II*\x00\x10\x00\x00\x00CR\x02\x00\x00\x00\x00\x00"), "raw", utils.ConfidenceMagic},
+		{"dng", ifd0("", "\x12\xc6\x01\x00\x04\x00\x00\x00\x01\x04\x00\x00"), "raw", utils.ConfidenceMagic},
+		{"nef", ifd0("NIKON CORPORATION\x00", makeTag, subIFDs), "raw", utils.ConfidenceMagic},
+		{"nikon tiff", ifd0("NIKON CORPORATION\x00", makeTag, width), "tiff", utils.ConfidenceMagic},
+		{"bmp", []byte("BM\x36\x00\x00\x00"), "bmp", utils.ConfidenceWeak},
+		{"ico", []byte{0, 0, 1, 0, 1, 0}, "ico", utils.ConfidenceWeak},
+		{"avif", ftyp("avif", "mif1"), "avif", utils.ConfidenceMagic},
+		{"avif compat", ftyp("mif1", "avif"), "avif", utils.ConfidenceMagic},
+		{"heic", ftyp("heic", "mif1"), "heif", utils.ConfidenceMagic},
+		{"mp4", ftyp("isom", "mp41"), "unknown", utils.ConfidenceNone},
+		{"short", []byte{0xFF}, "unknown", utils.ConfidenceNone},
+	}
+	for _, tc := range tests {
+		got := utils.Detect(tc.data)
+		if got.Format != tc.want || got.Confidence != tc.conf {
+			t.Errorf("%s: got %+v, want %s/%v", tc.name, got, tc.want, tc.conf)
+		}
+	}
+}
+
+func TestProcess_UnsupportedFormatNamed(t *testing.T) {
+	proc := newProc(t)
+	heic := append([]byte{0, 0, 0, 24}, "ftypheic\x00\x00\x00\x00mif1heic"...)
+
+	_, err := proc.Process(context.Background(),
+		imageprocessor.FromReader(bytes.NewReader(heic)),
+		&pipeline.DecodeStep{Registry: proc.Inner().Registry()},
+	)
+	if err == nil || !strings.Contains(err.Error(), "unsupported image format: heif") {
+		t.Errorf("got %v, want unsupported image format: heif", err)
+	}
+}
+
 // ── Concurrency tests ─────────────────────────────────────────────────────────
 
 func TestProcess_ConcurrentSafety(t *testing.T) {
@@ -281,8 +5115,8 @@ func TestWorkerPool_Async(t *testing.T) {
 
 	resultCh := make(chan core.JobResult, 1)
 	job := core.Job{
-		ID:  "test-job-1",
-		Ctx: context.Background(),
+		ID:     "test-job-1",
+		Ctx:    context.Background(),
 		Source: imageprocessor.FromReader(bytes.NewReader(raw)),
 		Steps: []core.Step{
 			&pipeline.DecodeStep{Registry: proc.Inner().Registry()},
@@ -400,7 +5234,7 @@ func BenchmarkProcess_Resize_JPEG(b *testing.B) {
 	cfg := imageprocessor.DefaultConfig()
 	proc := imageprocessor.New(cfg)
 	proc.Start()
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 
 	raw := makeRedJPEGBench(b, 1920, 1080)
 	reg := proc.Inner().Registry()
@@ -424,7 +5258,7 @@ func BenchmarkProcess_Resize_JPEG(b *testing.B) {
 func BenchmarkProcess_Thumbnail(b *testing.B) {
 	proc := imageprocessor.New(imageprocessor.DefaultConfig())
 	proc.Start()
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 
 	raw := makeRedJPEGBench(b, 1024, 768)
 	reg := proc.Inner().Registry()
@@ -448,7 +5282,7 @@ func BenchmarkProcess_Thumbnail(b *testing.B) {
 func BenchmarkBatch_Parallel(b *testing.B) {
 	proc := imageprocessor.New(imageprocessor.DefaultConfig())
 	proc.Start()
-	defer proc.Stop()
+	defer proc.Stop(context.Background())
 
 	raw := makeRedJPEGBench(b, 800, 600)
 	reg := proc.Inner().Registry()
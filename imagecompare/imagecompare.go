@@ -0,0 +1,252 @@
+// Package imagecompare measures how far two images are apart: a per-pixel
+// difference map, mean and maximum error, and SSIM.  It is meant for
+// regression-testing pipelines and presets against reference outputs.
+package imagecompare
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	_ "image/gif" // register decoders for encoded ImageData
+	_ "image/jpeg"
+	_ "image/png"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Result summarises the difference between two images.  Errors are
+// normalised to [0,1] where 1 is black against white on every channel.
+type Result struct {
+	MeanError float64 // mean absolute difference over R, G, B and A
+	MaxError  float64 // largest single-channel difference
+	SSIM      float64 // structural similarity of the luma planes, 1 = identical
+}
+
+// Tolerance bounds an acceptable Result.  Zero fields are not checked.
+type Tolerance struct {
+	MaxMeanError float64
+	MaxError     float64
+	MinSSIM      float64
+}
+
+// Check returns apperrors.ErrOutOfTolerance, annotated with the failing
+// measure, when r falls outside t.
+func (t Tolerance) Check(r Result) error {
+	switch {
+	case t.MaxMeanError > 0 && r.MeanError > t.MaxMeanError:
+		return fmt.Errorf("%w: mean error %.4f > %.4f", apperrors.ErrOutOfTolerance, r.MeanError, t.MaxMeanError)
+	case t.MaxError > 0 && r.MaxError > t.MaxError:
+		return fmt.Errorf("%w: max error %.4f > %.4f", apperrors.ErrOutOfTolerance, r.MaxError, t.MaxError)
+	case t.MinSSIM > 0 && r.SSIM < t.MinSSIM:
+		return fmt.Errorf("%w: SSIM %.4f < %.4f", apperrors.ErrOutOfTolerance, r.SSIM, t.MinSSIM)
+	}
+	return nil
+}
+
+// Compare measures a against b.  Each ImageData contributes its decoded
+// Image when that is an image.Image, otherwise its encoded Data is decoded
+// with the standard library (JPEG, PNG, GIF).
+func Compare(a, b *core.ImageData) (Result, error) {
+	ia, err := ImageOf(a)
+	if err != nil {
+		return Result{}, err
+	}
+	ib, err := ImageOf(b)
+	if err != nil {
+		return Result{}, err
+	}
+	return CompareImages(ia, ib)
+}
+
+// CompareImages measures a against b, which must have the same size.
+func CompareImages(a, b image.Image) (Result, error) {
+	if err := sameSize(a, b); err != nil {
+		return Result{}, err
+	}
+	var r Result
+	var sum float64
+	ab, bb := a.Bounds(), b.Bounds()
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			d := channelDiffs(a.At(ab.Min.X+x, ab.Min.Y+y), b.At(bb.Min.X+x, bb.Min.Y+y))
+			for _, v := range d {
+				sum += v
+				r.MaxError = math.Max(r.MaxError, v)
+			}
+		}
+	}
+	if n := ab.Dx() * ab.Dy(); n > 0 {
+		r.MeanError = sum / float64(4*n)
+	}
+	r.SSIM = ssim(luma(a), luma(b), ab.Dx(), ab.Dy())
+	return r, nil
+}
+
+// Diff returns a difference map of a and b: each pixel is the largest
+// channel difference at that position, so identical regions are black and
+// changes show up bright.
+func Diff(a, b image.Image) (*image.Gray, error) {
+	if err := sameSize(a, b); err != nil {
+		return nil, err
+	}
+	ab, bb := a.Bounds(), b.Bounds()
+	out := image.NewGray(image.Rect(0, 0, ab.Dx(), ab.Dy()))
+	for y := 0; y < ab.Dy(); y++ {
+		for x := 0; x < ab.Dx(); x++ {
+			d := channelDiffs(a.At(ab.Min.X+x, ab.Min.Y+y), b.At(bb.Min.X+x, bb.Min.Y+y))
+			m := math.Max(math.Max(d[0], d[1]), math.Max(d[2], d[3]))
+			out.Pix[y*out.Stride+x] = uint8(math.Round(m * 255))
+		}
+	}
+	return out, nil
+}
+
+// ── DiffStep ──────────────────────────────────────────────────────────────────
+
+// DiffStep compares the image flowing through the pipeline with Reference
+// and fails with apperrors.ErrOutOfTolerance when it drifts beyond
+// Tolerance.  The image is passed through unchanged, so the step can sit
+// anywhere in a pipeline under test.
+type DiffStep struct {
+	Reference *core.ImageData
+	Tolerance Tolerance
+	// OnResult, when set, receives every measurement (for logging or
+	// recording new baselines).
+	OnResult func(Result)
+}
+
+func (s *DiffStep) Name() string { return "diff" }
+
+func (s *DiffStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	r, err := Compare(img, s.Reference)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.OnResult != nil {
+		s.OnResult(r)
+	}
+	if err := s.Tolerance.Check(r); err != nil {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	return img, nil
+}
+
+// ── helpers ───────────────────────────────────────────────────────────────────
+
+// ImageOf returns the pixels of d: its Image, converted from other
+// backends, or when nothing is decoded yet its Data decoded with the
+// standard library.
+func ImageOf(d *core.ImageData) (image.Image, error) {
+	if d == nil {
+		return nil, apperrors.ErrEmptyInput
+	}
+	if d.Image != nil {
+		return core.ToStdImage(d.Image)
+	}
+	if len(d.Data) == 0 {
+		return nil, apperrors.ErrEmptyInput
+	}
+	img, _, err := image.Decode(bytes.NewReader(d.Data))
+	if err != nil {
+		return nil, fmt.Errorf("decode %s: %w", d.Format, err)
+	}
+	return img, nil
+}
+
+func sameSize(a, b image.Image) error {
+	if a.Bounds().Size() != b.Bounds().Size() {
+		return fmt.Errorf("%w: %v vs %v", apperrors.ErrInvalidDimensions, a.Bounds().Size(), b.Bounds().Size())
+	}
+	return nil
+}
+
+// channelDiffs returns the absolute R, G, B, A differences in [0,1],
+// compared in straight (non-premultiplied) alpha.
+func channelDiffs(a, b color.Color) [4]float64 {
+	ar, ag, ab, aa := a.RGBA()
+	br, bg, bb, ba := b.RGBA()
+	ca := [4]float64{unpremul(ar, aa), unpremul(ag, aa), unpremul(ab, aa), float64(aa) / 0xffff}
+	cb := [4]float64{unpremul(br, ba), unpremul(bg, ba), unpremul(bb, ba), float64(ba) / 0xffff}
+	var d [4]float64
+	for i := range d {
+		d[i] = math.Abs(ca[i] - cb[i])
+	}
+	return d
+}
+
+func unpremul(v, a uint32) float64 {
+	if a == 0 {
+		return 0
+	}
+	return float64(v) / float64(a)
+}
+
+// luma returns the Rec.709 luma plane of img in [0,255].
+func luma(img image.Image) []float64 {
+	b := img.Bounds()
+	out := make([]float64, b.Dx()*b.Dy())
+	for y := 0; y < b.Dy(); y++ {
+		for x := 0; x < b.Dx(); x++ {
+			r, g, bl, _ := img.At(b.Min.X+x, b.Min.Y+y).RGBA()
+			out[y*b.Dx()+x] = (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(bl)) / 257
+		}
+	}
+	return out
+}
+
+// SSIM constants for 8-bit data (Wang et al. 2004).
+const (
+	ssimWindow = 8
+	ssimStride = 4
+	ssimC1     = (0.01 * 255) * (0.01 * 255)
+	ssimC2     = (0.03 * 255) * (0.03 * 255)
+)
+
+// ssim is the mean SSIM over 8×8 windows with a stride of 4.  Images smaller
+// than a window are treated as a single window.
+func ssim(a, b []float64, w, h int) float64 {
+	if w == 0 || h == 0 {
+		return 1
+	}
+	ww, wh := min(ssimWindow, w), min(ssimWindow, h)
+	var total float64
+	var n int
+	for y0 := 0; y0+wh <= h; y0 += ssimStride {
+		for x0 := 0; x0+ww <= w; x0 += ssimStride {
+			total += ssimWindowAt(a, b, w, x0, y0, ww, wh)
+			n++
+		}
+	}
+	return total / float64(n)
+}
+
+func ssimWindowAt(a, b []float64, stride, x0, y0, ww, wh int) float64 {
+	var ma, mb float64
+	for y := y0; y < y0+wh; y++ {
+		for x := x0; x < x0+ww; x++ {
+			ma += a[y*stride+x]
+			mb += b[y*stride+x]
+		}
+	}
+	cnt := float64(ww * wh)
+	ma /= cnt
+	mb /= cnt
+	var va, vb, cov float64
+	for y := y0; y < y0+wh; y++ {
+		for x := x0; x < x0+ww; x++ {
+			da, db := a[y*stride+x]-ma, b[y*stride+x]-mb
+			va += da * da
+			vb += db * db
+			cov += da * db
+		}
+	}
+	va /= cnt
+	vb /= cnt
+	cov /= cnt
+	return ((2*ma*mb + ssimC1) * (2*cov + ssimC2)) /
+		((ma*ma + mb*mb + ssimC1) * (va + vb + ssimC2))
+}
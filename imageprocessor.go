@@ -2,13 +2,21 @@ package imageprocessor
 
 import (
 	"context"
+	"image"
+	"image/color"
 	"io"
+	"sync"
+	"time"
 
 	"github.com/Skryldev/image-processor/adapters/decoder"
 	"github.com/Skryldev/image-processor/adapters/encoder"
+	"github.com/Skryldev/image-processor/adapters/ffmpeg"
 	"github.com/Skryldev/image-processor/config"
 	"github.com/Skryldev/image-processor/core"
+	"github.com/Skryldev/image-processor/dedupe"
+	"github.com/Skryldev/image-processor/imagecompare"
 	"github.com/Skryldev/image-processor/pipeline"
+	"github.com/Skryldev/image-processor/provenance"
 )
 
 // Re-export Format constants for convenience.
@@ -16,6 +24,43 @@ const (
 	JPEG = core.FormatJPEG
 	PNG  = core.FormatPNG
 	WebP = core.FormatWebP
+	GIF  = core.FormatGIF
+	TIFF = core.FormatTIFF
+	HEIF = core.FormatHEIF
+	JXL  = core.FormatJXL
+	MP4  = core.FormatMP4
+	WebM = core.FormatWebM
+)
+
+// Re-export resampling kernels for ResizeWith.
+const (
+	NearestNeighbor = core.KernelNearest
+	Bilinear        = core.KernelBilinear
+	CatmullRom      = core.KernelCatmullRom
+	Lanczos         = core.KernelLanczos
+)
+
+// Re-export smart-crop strategies for SmartCrop.
+const (
+	CropCentre    = core.CropCentre
+	CropEntropy   = core.CropEntropy
+	CropAttention = core.CropAttention
+)
+
+// Re-export blend modes for Composite.
+const (
+	BlendOver     = core.BlendOver
+	BlendMultiply = core.BlendMultiply
+	BlendScreen   = core.BlendScreen
+	BlendDarken   = core.BlendDarken
+	BlendLighten  = core.BlendLighten
+)
+
+// Re-export job priorities for Job.Priority.
+const (
+	PriorityHigh   = core.PriorityHigh
+	PriorityNormal = core.PriorityNormal
+	PriorityLow    = core.PriorityLow
 )
 
 // DefaultConfig returns a sensible production configuration.
@@ -25,22 +70,35 @@ func DefaultConfig() config.Config { return config.Default() }
 type Processor struct {
 	inner *core.Processor
 	reg   *core.DefaultRegistry
+	cfg   config.Config
+
+	presetMu sync.RWMutex
+	presets  map[string][]core.Step
 }
 
-// New creates a fully wired Processor with default JPEG, PNG, and WebP codecs
-// registered.  Pass a custom config.Config to override defaults.
+// New creates a fully wired Processor with default JPEG, PNG, WebP, GIF and
+// TIFF codecs registered; the TIFF decoder reads every page (see DecodePage
+// and ProcessPages).  Animated GIF and WebP decode to a *core.Animation
+// unless cfg.FirstFrameOnly; see EachFrame.  The decoders refuse images
+// whose header exceeds cfg.MaxPixels or cfg.MaxDecodedBytes with
+// ErrImageTooLarge.  Pass a custom config.Config to override defaults.
 func New(cfg config.Config) *Processor {
 	reg := core.NewRegistry()
 	// Register built-in codecs.
-	reg.RegisterDecoder(core.FormatJPEG, decoder.NewJPEG())
-	reg.RegisterDecoder(core.FormatPNG, decoder.NewPNG())
-	reg.RegisterDecoder(core.FormatWebP, decoder.NewWebP())
+	limits := core.DecodeLimits{MaxPixels: cfg.MaxPixels, MaxDecodedBytes: cfg.MaxDecodedBytes}
+	reg.RegisterDecoder(core.FormatJPEG, &decoder.JPEG{Limits: limits})
+	reg.RegisterDecoder(core.FormatPNG, &decoder.PNG{Limits: limits})
+	reg.RegisterDecoder(core.FormatWebP, &decoder.WebP{FirstFrameOnly: cfg.FirstFrameOnly, Limits: limits})
+	reg.RegisterDecoder(core.FormatTIFF, &decoder.TIFF{Limits: limits})
+	reg.RegisterDecoder(core.FormatGIF, &decoder.GIF{FirstFrameOnly: cfg.FirstFrameOnly, Limits: limits})
 	reg.RegisterEncoder(core.FormatJPEG, encoder.NewJPEG(cfg.DefaultQuality))
 	reg.RegisterEncoder(core.FormatPNG, encoder.NewPNG())
 	reg.RegisterEncoder(core.FormatWebP, encoder.NewWebP(cfg.DefaultQuality))
+	reg.RegisterEncoder(core.FormatGIF, encoder.NewGIF())
+	reg.RegisterEncoder(core.FormatTIFF, encoder.NewTIFF())
 
 	inner := core.New(cfg, reg)
-	return &Processor{inner: inner, reg: reg}
+	return &Processor{inner: inner, reg: reg, cfg: cfg}
 }
 
 // SetLogger attaches a structured logger.
@@ -49,20 +107,59 @@ func (p *Processor) SetLogger(l core.Logger) { p.inner.SetLogger(l) }
 // SetMetrics attaches a metrics collector.
 func (p *Processor) SetMetrics(m core.MetricsCollector) { p.inner.SetMetrics(m) }
 
+// SetJobQueue replaces the in-memory job queue, e.g. with a persistent
+// queue from adapters/queue.  Call it before Start.
+func (p *Processor) SetJobQueue(q core.JobQueue) { p.inner.SetJobQueue(q) }
+
+// SetResultCache makes Process reuse encoded outputs stored in c for ttl,
+// such as a core.MemoryCache or the Redis cache in adapters/cache; see
+// core.Processor.SetResultCache.
+func (p *Processor) SetResultCache(c core.ResultCache, ttl time.Duration) {
+	p.inner.SetResultCache(c, ttl)
+}
+
 // AddHook registers an observer for pipeline step events.
 func (p *Processor) AddHook(h core.Hook) { p.inner.AddHook(h) }
 
-// RegisterDecoder registers a custom decoder for the given format.
+// RegisterDecoder registers a custom decoder for the given format, ahead of
+// the built-in one, which remains as its fallback.
 func (p *Processor) RegisterDecoder(f core.Format, d core.Decoder) { p.reg.RegisterDecoder(f, d) }
 
-// RegisterEncoder registers a custom encoder for the given format.
+// RegisterEncoder registers a custom encoder for the given format, ahead of
+// the built-in one, which remains as its fallback.
 func (p *Processor) RegisterEncoder(f core.Format, e core.Encoder) { p.reg.RegisterEncoder(f, e) }
 
+// AddDecoder registers a decoder for f at priority.  The built-in codecs
+// have priority 0: a higher priority is tried before them and a negative
+// one only when they fail.  ProcessingResult.Codecs reports which one ran.
+func (p *Processor) AddDecoder(f core.Format, d core.Decoder, priority int) {
+	p.reg.AddDecoder(f, d, priority)
+}
+
+// AddEncoder registers an encoder for f at priority, as AddDecoder does.
+func (p *Processor) AddEncoder(f core.Format, e core.Encoder, priority int) {
+	p.reg.AddEncoder(f, e, priority)
+}
+
+// CanDecode reports whether some registered decoder accepts f.  Use it to
+// route uploads in optional formats such as HEIF, which need the libvips
+// backend built with libheif.
+func (p *Processor) CanDecode(f core.Format) bool { return len(p.reg.DecoderChain(f)) > 0 }
+
+// SupportedFormats returns the formats the Processor can decode or encode,
+// sorted.
+func (p *Processor) SupportedFormats() []core.Format { return p.reg.SupportedFormats() }
+
+// Capabilities describes the registered codecs, for negotiating output
+// formats; see core.Capabilities.
+func (p *Processor) Capabilities() core.Capabilities { return p.reg.Capabilities() }
+
 // Start starts the background worker pool.
 func (p *Processor) Start() { p.inner.Start() }
 
-// Stop drains and shuts down the worker pool.
-func (p *Processor) Stop() { p.inner.Stop() }
+// Stop stops accepting jobs, lets the worker pool finish the queued ones
+// until ctx is done and shuts it down, failing the jobs it abandons.
+func (p *Processor) Stop(ctx context.Context) (core.StopReport, error) { return p.inner.Stop(ctx) }
 
 // Process executes the provided steps synchronously and returns the result.
 func (p *Processor) Process(ctx context.Context, src core.Source, steps ...core.Step) (*core.ProcessingResult, error) {
@@ -74,6 +171,19 @@ func (p *Processor) Batch(ctx context.Context, sources []core.Source, steps ...c
 	return p.inner.Batch(ctx, sources, steps...)
 }
 
+// BatchWithOptions runs steps on sources with bounded concurrency and an
+// error policy; see core.BatchOptions.
+func (p *Processor) BatchWithOptions(ctx context.Context, sources []core.Source, opts core.BatchOptions, steps ...core.Step) (*core.BatchResult, error) {
+	return p.inner.BatchWithOptions(ctx, sources, opts, steps...)
+}
+
+// BatchStream runs steps on the sources received from a channel and
+// streams back the outcomes, for input sets too large to list up front;
+// see core.Processor.BatchStream.
+func (p *Processor) BatchStream(ctx context.Context, sources <-chan core.Source, steps ...core.Step) <-chan core.BatchItemResult {
+	return p.inner.BatchStream(ctx, sources, steps...)
+}
+
 // ProcessVariants runs base steps and then produces named variants in parallel.
 func (p *Processor) ProcessVariants(
 	ctx context.Context,
@@ -87,6 +197,26 @@ func (p *Processor) ProcessVariants(
 // Submit enqueues an async job for the worker pool.
 func (p *Processor) Submit(job core.Job) error { return p.inner.Submit(job) }
 
+// SubmitWait enqueues an async job, waiting while the queue is full until
+// there is room or ctx is done.
+func (p *Processor) SubmitWait(ctx context.Context, job core.Job) error {
+	return p.inner.SubmitWait(ctx, job)
+}
+
+// SubmitAt enqueues job for the worker pool once at is reached.
+func (p *Processor) SubmitAt(job core.Job, at time.Time) error { return p.inner.SubmitAt(job, at) }
+
+// SubmitAfter enqueues job for the worker pool once delay has passed.
+func (p *Processor) SubmitAfter(job core.Job, delay time.Duration) error {
+	return p.inner.SubmitAfter(job, delay)
+}
+
+// JobStatus reports the state and progress of the job submitted under id.
+func (p *Processor) JobStatus(id string) (core.JobStatus, bool) { return p.inner.JobStatus(id) }
+
+// CancelJob aborts the queued or running job submitted under id.
+func (p *Processor) CancelJob(id string) error { return p.inner.CancelJob(id) }
+
 // NewPipeline creates a reusable, standalone pipeline.
 func (p *Processor) NewPipeline(steps ...core.Step) *pipeline.Pipeline {
 	pl := pipeline.New()
@@ -94,6 +224,9 @@ func (p *Processor) NewPipeline(steps ...core.Step) *pipeline.Pipeline {
 	return pl
 }
 
+// PoolStats reports the worker pool's size and load.
+func (p *Processor) PoolStats() core.PoolStats { return p.inner.PoolStats() }
+
 // Stats returns lightweight processing statistics.
 func (p *Processor) Stats() (processed, errors int64) {
 	return p.inner.ProcessedCount(), p.inner.ErrorCount()
@@ -111,37 +244,109 @@ func FromReaderWithMeta(r io.Reader, size int64, contentType, name string) core.
 
 // ── Step constructors ─────────────────────────────────────────────────────────
 
-// Decode returns a step that decodes img.Data → img.Image.
-func Decode() core.Step {
-	// Lazy singleton registry for the decode step; the full registry is
-	// provided by the Processor.  For standalone use, pass the registry
-	// explicitly via DecodeWith.
-	return &pipeline.DecodeStep{} // registry injected at Process time via wrapper
-}
+// Decode returns a step that decodes img.Data → img.Image.  The Processor
+// binds its registry at Process time; for standalone pipelines use DecodeWith.
+func Decode() core.Step { return &pipeline.DecodeStep{} }
 
 // DecodeWith returns a decode step bound to the given registry.
 func DecodeWith(reg core.Registry) core.Step { return &pipeline.DecodeStep{Registry: reg} }
 
+// DecodePage returns a decode step for page (from 1) of a multi-page TIFF or
+// PDF source.  Use ProcessPages to process every page.
+func DecodePage(page int) core.Step {
+	return &pipeline.DecodeStep{Options: core.DecodeOptions{Page: page}}
+}
+
+// RasterizeSVG returns a step that renders SVG sources width pixels wide
+// (0 = their own size), after stripping scripts and external references.
+// Put it before Decode; other formats pass through.  See
+// pipeline.SVGRasterizeStep for DPI, reference allowlists and the libvips
+// renderer.
+func RasterizeSVG(width int) core.Step { return &pipeline.SVGRasterizeStep{Width: width} }
+
 // Resize returns a resize step.  Pass 0 for one axis to preserve aspect ratio.
 func Resize(width, height int) core.Step { return &pipeline.ResizeStep{Width: width, Height: height} }
 
+// ResizeWith returns a resize step using the given resampling kernel, trading
+// speed (NearestNeighbor) for quality (Lanczos).
+func ResizeWith(width, height int, kernel core.Kernel) core.Step {
+	return &pipeline.ResizeStep{Width: width, Height: height, Kernel: kernel}
+}
+
+// ResizeSharp returns a resize step that applies an unsharp mask of the
+// given amount (e.g. 0.5) after downscaling.  Processors apply
+// config.Config.PostSharpen to plain Resize steps.
+func ResizeSharp(width, height int, amount float64) core.Step {
+	return &pipeline.ResizeStep{Width: width, Height: height, PostSharpen: amount}
+}
+
 // Crop returns a crop step.
 func Crop(x, y, width, height int) core.Step {
 	return &pipeline.CropStep{X: x, Y: y, Width: width, Height: height}
 }
 
+// CropGravity returns a step that crops a width×height window anchored by g.
+func CropGravity(width, height int, g core.Gravity) core.Step {
+	return &pipeline.CropStep{Width: width, Height: height, Gravity: g}
+}
+
+// CropAspect returns a step that crops the largest ratioW:ratioH window
+// anchored by g, e.g. CropAspect(4, 5, core.GravityCenter).
+func CropAspect(ratioW, ratioH int, g core.Gravity) core.Step {
+	return &pipeline.CropStep{AspectRatio: float64(ratioW) / float64(ratioH), Gravity: g}
+}
+
+// SmartCrop returns a step that scales the image to cover width×height and
+// keeps the window strategy finds most interesting.
+func SmartCrop(width, height int, strategy core.CropStrategy) core.Step {
+	return &pipeline.SmartCropStep{Width: width, Height: height, Strategy: strategy}
+}
+
+// FaceCrop returns a width×height crop that keeps the faces d finds in
+// frame, e.g. FaceCrop(facedetect.NewSkinTone(), 256, 256) for avatars.
+func FaceCrop(d core.FaceDetector, width, height int) core.Step {
+	return &pipeline.FaceCropStep{Detector: d, Width: width, Height: height}
+}
+
 // Thumbnail returns a square thumbnail step.
 func Thumbnail(size int) core.Step { return &pipeline.ThumbnailStep{Size: size} }
 
+// ThumbnailFit returns a width×height thumbnail step.  pipeline.FitCover
+// crops the overflow; pipeline.FitContain pads with transparency.
+func ThumbnailFit(width, height int, fit pipeline.Fit) core.Step {
+	return &pipeline.ThumbnailStep{Width: width, Height: height, Fit: fit}
+}
+
 // Quality stores the desired encode quality (1-100) for the next Encode step.
 func Quality(q int) core.Step { return &pipeline.QualityStep{Quality: q} }
 
 // ConvertFormat instructs subsequent steps to use the given output format.
 func ConvertFormat(f core.Format) core.Step { return &pipeline.FormatStep{Format: f} }
 
+// NegotiateFormat returns a step that picks the output format from an HTTP
+// Accept header among the encodable formats; see
+// pipeline.NegotiateFormatStep.
+func NegotiateFormat(accept string) core.Step { return &pipeline.NegotiateFormatStep{Accept: accept} }
+
 // StripEXIF returns a step that removes EXIF metadata.
 func StripEXIF() core.Step { return &pipeline.StripEXIFStep{} }
 
+// StripMetadata returns a step that removes the metadata p names, such as
+// GPS positions, and keeps the rest; see pipeline.StripMetadataStep.
+func StripMetadata(p core.MetadataPolicy) core.Step { return &pipeline.StripMetadataStep{Policy: p} }
+
+// SetMetadata returns a step that writes tags, such as a copyright notice,
+// into the encoded output; see pipeline.SetMetadataStep.
+func SetMetadata(tags core.MetadataTags) core.Step { return &pipeline.SetMetadataStep{Tags: tags} }
+
+// ToSRGB returns a step that converts the pixels from the embedded colour
+// profile to sRGB; see pipeline.ToSRGBStep.
+func ToSRGB() core.Step { return &pipeline.ToSRGBStep{} }
+
+// Deterministic returns a step that makes the following encode reproducible:
+// identical inputs and pipelines yield byte-identical output.
+func Deterministic() core.Step { return &pipeline.DeterministicStep{} }
+
 // Grayscale returns a step that converts the image to grayscale.
 func Grayscale() core.Step { return &pipeline.GrayscaleStep{} }
 
@@ -150,10 +355,33 @@ func EncodeWith(reg core.Registry, opts core.EncodeOptions) core.Step {
 	return &pipeline.EncodeStep{Registry: reg, BaseOptions: opts}
 }
 
-// Encode returns an encode step with default options.
-// Prefer using the processor's Process method which auto-wires the registry.
+// Encode returns an encode step with default options.  The Processor binds
+// its registry at Process time; for standalone pipelines use EncodeWith.
 func Encode() core.Step { return &pipeline.EncodeStep{} }
 
+// BestFormat returns a step that encodes the image in each of formats
+// (default JPEG, WebP and AVIF, where encodable) at quality and keeps the
+// smallest output; see pipeline.BestFormatEncodeStep.
+func BestFormat(quality int, formats ...core.Format) core.Step {
+	return &pipeline.BestFormatEncodeStep{Formats: formats, Quality: quality}
+}
+
+// PerceptualCompress returns a step that encodes at the lowest quality
+// whose output keeps targetSSIM against the unencoded image; see
+// pipeline.PerceptualCompressStep.
+func PerceptualCompress(targetSSIM float64) core.Step {
+	return &pipeline.PerceptualCompressStep{TargetSSIM: targetSSIM}
+}
+
+// OptimizePNG returns a step that recompresses an encoded PNG with the
+// smallest settings, quantizing it unless lossless or the palette would
+// show; see pipeline.OptimizePNGStep.
+func OptimizePNG(lossless bool) core.Step { return &pipeline.OptimizePNGStep{Lossless: lossless} }
+
+// EncodeOpts returns a registry-less encode step with the given options,
+// bound to the Processor's registry at Process time.
+func EncodeOpts(opts core.EncodeOptions) core.Step { return &pipeline.EncodeStep{BaseOptions: opts} }
+
 // AdaptiveCompress returns a step that iteratively reduces quality to hit a
 // target size in bytes.
 func AdaptiveCompress(reg core.Registry, targetBytes int64, minQ, maxQ int) core.Step {
@@ -164,4 +392,205 @@ func AdaptiveCompress(reg core.Registry, targetBytes int64, minQ, maxQ int) core
 		MaxQuality:      maxQ,
 		StepSize:        5,
 	}
-}
\ No newline at end of file
+}
+
+// AssertSimilar returns a step that fails when the image differs from ref
+// beyond tol, passing it through unchanged otherwise.
+func AssertSimilar(ref *core.ImageData, tol imagecompare.Tolerance) core.Step {
+	return &imagecompare.DiffStep{Reference: ref, Tolerance: tol}
+}
+
+// Dedupe returns a step that flags images within maxDistance bits of an entry
+// in idx; see dedupe.Matches.  New images are not added to idx.
+func Dedupe(idx dedupe.Index, maxDistance int) core.Step {
+	return &dedupe.Step{Index: idx, MaxDistance: maxDistance}
+}
+
+// QRCode returns a step that draws a QR code encoding content, about size
+// pixels wide, anchored by g (default south-east).
+func QRCode(content string, size int, g core.Gravity) core.Step {
+	return &pipeline.BarcodeStep{Content: content, Size: size, Gravity: g}
+}
+
+// EachFrame returns a step that applies steps to every frame of an animated
+// image, e.g. EachFrame(Resize(320, 0)), keeping delays and loop count.
+// Stills run through steps once.
+func EachFrame(steps ...core.Step) core.Step { return &pipeline.EachFrameStep{Steps: steps} }
+
+// GIFToVideo returns a step that converts animated GIFs of at least minBytes
+// into container (core.FormatMP4 or core.FormatWebM) using the ffmpeg binary
+// on PATH.  Other inputs pass through unchanged.
+func GIFToVideo(container core.Format, minBytes int) core.Step {
+	return &ffmpeg.GIFToVideoStep{Options: ffmpeg.VideoOptions{Container: container}, MinBytes: minBytes}
+}
+
+// AutoEnhance returns a step that corrects white balance, stretches contrast
+// and lightly boosts saturation.  strength in (0, 1] scales the correction;
+// 1 applies it fully.
+func AutoEnhance(strength float64) core.Step { return &pipeline.AutoEnhanceStep{Strength: strength} }
+
+// TextOverlay returns a step that draws text in Go Regular at size pixels
+// (0 for 5% of the image height), anchored by g.
+func TextOverlay(text string, size float64, g core.Gravity) core.Step {
+	return &pipeline.TextOverlayStep{Text: text, Size: size, Position: g}
+}
+
+// Watermark returns a step that composites wm at the corner or edge g,
+// faded to opacity (0 for opaque).
+func Watermark(wm image.Image, g core.Gravity, opacity float64) core.Step {
+	return &pipeline.WatermarkStep{Watermark: wm, Position: g, Opacity: opacity}
+}
+
+// WatermarkTiled returns a step that repeats wm across the whole image, each
+// copy scale × the image width wide (0 for native size).
+func WatermarkTiled(wm image.Image, scale, opacity float64) core.Step {
+	return &pipeline.WatermarkStep{Watermark: wm, Scale: scale, Opacity: opacity, Tile: true}
+}
+
+// RoundCorners returns a step that rounds the corners to radius pixels,
+// switching JPEG output to PNG to keep the transparency.
+func RoundCorners(radius int) core.Step { return &pipeline.RoundCornersStep{Radius: radius} }
+
+// CircleMask returns a step that cuts the image to its largest centred
+// circle, switching JPEG output to PNG to keep the transparency.
+func CircleMask() core.Step { return &pipeline.CircleMaskStep{} }
+
+// Border returns a step that frames the image with a width-pixel border
+// of colour c, growing the canvas.
+func Border(width int, c color.Color) core.Step { return &pipeline.BorderStep{Width: width, Color: c} }
+
+// Flatten returns a step that composites the image over bg (nil for white),
+// removing transparency.  Encode does this by itself for JPEG output.
+func Flatten(bg color.Color) core.Step { return &pipeline.FlattenStep{Background: bg} }
+
+// Trim returns a step that crops away borders matching the top-left pixel
+// within tolerance (0 for the default of 10) per channel.
+func Trim(tolerance int) core.Step { return &pipeline.TrimStep{Tolerance: tolerance} }
+
+// Pixelate returns a step that pixelates rects for redaction, e.g. the
+// faces a core.FaceDetector reports; blockSize 0 picks one per rectangle.
+func Pixelate(blockSize int, rects ...image.Rectangle) core.Step {
+	return &pipeline.PixelateRegionStep{Rects: rects, BlockSize: blockSize}
+}
+
+// BlurRegion returns a step that Gaussian-blurs rects for redaction; sigma 0
+// picks one per rectangle.
+func BlurRegion(sigma float64, rects ...image.Rectangle) core.Step {
+	return &pipeline.BlurRegionStep{Rects: rects, Sigma: sigma}
+}
+
+// ColorMatrix returns a step transforming pixels by an feColorMatrix-style
+// 4×5 matrix; see pipeline.ColorMatrixStep.
+func ColorMatrix(m [4][5]float64) core.Step { return &pipeline.ColorMatrixStep{Matrix: m} }
+
+// Sepia returns a step giving the image a sepia tone.
+func Sepia() core.Step { return &pipeline.SepiaStep{} }
+
+// Invert returns a step producing the colour negative.
+func Invert() core.Step { return &pipeline.InvertStep{} }
+
+// Duotone returns a step mapping shadows to dark and highlights to light.
+func Duotone(dark, light color.Color) core.Step {
+	return &pipeline.DuotoneStep{Dark: dark, Light: light}
+}
+
+// Gamma returns a step applying gamma correction; values above 1 brighten.
+func Gamma(gamma float64) core.Step { return &pipeline.GammaStep{Gamma: gamma} }
+
+// AutoLevel returns a step stretching the histogram to the full tonal range.
+func AutoLevel() core.Step { return &pipeline.AutoLevelStep{} }
+
+// LUT returns a step colour-grading the image through a parsed 3D LUT.
+func LUT(lut *pipeline.CubeLUT) core.Step { return &pipeline.LUTStep{LUT: lut} }
+
+// LoadLUT reads a .cube file and returns a step applying it.  Load once and
+// reuse the step; it is safe for concurrent use.
+func LoadLUT(path string) (core.Step, error) {
+	lut, err := pipeline.LoadCubeLUT(path)
+	if err != nil {
+		return nil, err
+	}
+	return &pipeline.LUTStep{LUT: lut}, nil
+}
+
+// Quantize returns a step reducing the image to a palette of at most colors
+// colours, which PNG encoding writes as PNG8.
+func Quantize(colors int, dither bool) core.Step {
+	return &pipeline.QuantizeStep{Colors: colors, Dither: dither}
+}
+
+// SeamCarve returns a step that changes the aspect ratio to width×height by
+// seam carving, keeping subjects intact where a crop would cut them.
+func SeamCarve(width, height int) core.Step {
+	return &pipeline.SeamCarveStep{Width: width, Height: height}
+}
+
+// Perspective returns a step that warps quad (top-left, top-right,
+// bottom-right, bottom-left) onto an upright rectangle, e.g. to flatten a
+// photographed document.
+func Perspective(quad [4]image.Point) core.Step { return &pipeline.PerspectiveStep{Quad: quad} }
+
+// Composite returns a step that layers overlay onto the image at g with the
+// given blend mode and opacity (0 for opaque).
+func Composite(overlay image.Image, g core.Gravity, mode core.BlendMode, opacity float64) core.Step {
+	return &pipeline.CompositeStep{Overlay: overlay, Position: g, Mode: mode, Opacity: opacity}
+}
+
+// Vignette returns a step darkening the corners by strength (0..1; 0 for
+// the default of 0.5).
+func Vignette(strength float64) core.Step { return &pipeline.VignetteStep{Strength: strength} }
+
+// GradientOverlay returns a step painting a gradient from from to to,
+// running towards dir, over the image; GradientOverlay(nil, nil,
+// core.GravitySouth) is a dark caption scrim along the bottom.
+func GradientOverlay(from, to color.Color, dir core.Gravity) core.Step {
+	return &pipeline.GradientOverlayStep{From: from, To: to, Direction: dir}
+}
+
+// CLAHE returns a step applying contrast-limited adaptive histogram
+// equalisation; pass 0 for the default clip limit (3) and tile size.
+func CLAHE(clipLimit float64, tileSize int) core.Step {
+	return &pipeline.CLAHEStep{ClipLimit: clipLimit, TileSize: tileSize}
+}
+
+// Tee returns a step that runs steps on a copy of the image at this point
+// and returns the result as the variant named branch, leaving the main
+// pipeline unchanged.
+func Tee(branch string, steps ...core.Step) core.Step {
+	return &pipeline.TeeStep{Branch: branch, Steps: steps}
+}
+
+// Adjust returns a step applying brightness, contrast and saturation as
+// relative changes (0 = unchanged, 0.2 = +20%) and rotating hue by hue
+// degrees.
+func Adjust(brightness, contrast, saturation, hue float64) core.Step {
+	return &pipeline.AdjustStep{Brightness: brightness, Contrast: contrast, Saturation: saturation, Hue: hue}
+}
+
+// Moderate returns a step that scores the image with c, records the scores
+// in Meta.Scores and fails with apperrors.ErrRejected when a label reaches
+// its threshold.
+func Moderate(c core.Classifier, thresholds map[string]float64) core.Step {
+	return &pipeline.ClassifyStep{Classifier: c, Thresholds: thresholds}
+}
+
+// Upscale returns a resize step that hands enlargements to up (nil for the
+// built-in Lanczos + sharpen fallback) instead of interpolating them.
+func Upscale(width, height int, up core.Upscaler) core.Step {
+	return &pipeline.UpscaleStep{Width: width, Height: height, Upscaler: up}
+}
+
+// SimulateColorBlindness returns a step that renders the image as seen with
+// the given colour-vision deficiency.
+func SimulateColorBlindness(t pipeline.CVD) core.Step { return &pipeline.ColorBlindStep{Type: t} }
+
+// CheckContrast returns a step that records WCAG contrast metrics in
+// Meta.Contrast and, when minRatio > 0, fails images whose dominant
+// light/dark contrast is below it.
+func CheckContrast(minRatio float64) core.Step { return &pipeline.ContrastStep{MinRatio: minRatio} }
+
+// EmbedMark returns a step that hides payload in the image as an invisible
+// watermark readable only with key.  See package provenance.
+func EmbedMark(key string, payload uint32) core.Step {
+	return &provenance.EmbedStep{Key: key, Payload: payload}
+}
@@ -0,0 +1,583 @@
+package losslessjpeg
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// maxPixels bounds the images decode accepts; coefficients take about four
+// bytes per pixel and component.
+const maxPixels = 1 << 28
+
+// block holds one 8×8 block of quantised coefficients in zig-zag order.
+type block [64]int16
+
+// unzig maps a zig-zag index to its natural (row-major) block index.
+var unzig = [64]int{
+	0, 1, 8, 16, 9, 2, 3, 10,
+	17, 24, 32, 25, 18, 11, 4, 5,
+	12, 19, 26, 33, 40, 48, 41, 34,
+	27, 20, 13, 6, 7, 14, 21, 28,
+	35, 42, 49, 56, 57, 50, 43, 36,
+	29, 22, 15, 23, 30, 37, 44, 51,
+	58, 59, 52, 45, 38, 31, 39, 46,
+	53, 60, 61, 54, 47, 55, 62, 63,
+}
+
+// zig is the inverse of unzig.
+var zig = func() (z [64]int) {
+	for i, n := range unzig {
+		z[n] = i
+	}
+	return z
+}()
+
+type component struct {
+	id   uint8
+	h, v int // sampling factors
+	tq   uint8
+	// bw×bh blocks, padded to whole MCUs.
+	bw, bh int
+	blocks []block
+
+	td, ta uint8 // Huffman tables of the current scan
+}
+
+// frame is a whole JPEG file held as coefficients.
+type frame struct {
+	width, height int
+	comps         []*component
+	quant         [4][64]uint16 // zig-zag order
+	// segments are the APPn and COM segments, marker included, in file
+	// order.
+	segments    [][]byte
+	progressive bool
+}
+
+func (f *frame) hmax() int {
+	m := 1
+	for _, c := range f.comps {
+		m = max(m, c.h)
+	}
+	return m
+}
+
+func (f *frame) vmax() int {
+	m := 1
+	for _, c := range f.comps {
+		m = max(m, c.v)
+	}
+	return m
+}
+
+// mcus returns the number of MCUs across and down.
+func (f *frame) mcus() (int, int) {
+	return ceilDiv(f.width, 8*f.hmax()), ceilDiv(f.height, 8*f.vmax())
+}
+
+// compBlocks returns how many blocks of c cover the image, as coded by a
+// non-interleaved scan.
+func (f *frame) compBlocks(c *component) (int, int) {
+	return ceilDiv(ceilDiv(f.width*c.h, f.hmax()), 8), ceilDiv(ceilDiv(f.height*c.v, f.vmax()), 8)
+}
+
+// alloc sizes every component's block grid for the frame's dimensions.
+func (f *frame) alloc() {
+	mx, my := f.mcus()
+	for _, c := range f.comps {
+		c.bw, c.bh = mx*c.h, my*c.v
+		c.blocks = make([]block, c.bw*c.bh)
+	}
+}
+
+func ceilDiv(a, b int) int { return (a + b - 1) / b }
+
+func errFormat(format string, args ...any) error {
+	return apperrors.New(apperrors.CategoryDecode, "losslessjpeg.decode", fmt.Errorf(format, args...))
+}
+
+func errUnsupported(what string) error {
+	return apperrors.New(apperrors.CategoryDecode, "losslessjpeg.decode",
+		fmt.Errorf("%w: %s JPEG", apperrors.ErrUnsupportedFormat, what))
+}
+
+// decode reads data down to its quantised coefficients.
+func decode(data []byte) (*frame, error) {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return nil, errFormat("missing SOI marker")
+	}
+	var (
+		f       = &frame{}
+		huff    [2][4]*huffTable
+		restart int
+		scans   int
+	)
+	for pos := 2; ; {
+		if pos+2 > len(data) {
+			if scans > 0 {
+				break // tolerate a missing EOI
+			}
+			return nil, errFormat("truncated before the first scan")
+		}
+		if data[pos] != 0xFF {
+			return nil, errFormat("expected a marker at offset %d", pos)
+		}
+		m := data[pos+1]
+		switch {
+		case m == 0xFF: // fill byte
+			pos++
+			continue
+		case m == 0xD9: // EOI
+			if scans == 0 {
+				return nil, errFormat("no scans")
+			}
+			return f, nil
+		case m == 0x01 || m >= 0xD0 && m <= 0xD7: // no payload
+			pos += 2
+			continue
+		}
+		if pos+4 > len(data) {
+			return nil, errFormat("truncated marker")
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			return nil, errFormat("truncated marker %#x", m)
+		}
+		seg := data[pos+4 : pos+2+n]
+		switch {
+		case m == 0xDB:
+			if err := f.readDQT(seg); err != nil {
+				return nil, err
+			}
+		case m == 0xC4:
+			if err := readDHT(seg, &huff); err != nil {
+				return nil, err
+			}
+		case m == 0xDD:
+			if len(seg) < 2 {
+				return nil, errFormat("short DRI")
+			}
+			restart = int(binary.BigEndian.Uint16(seg))
+		case m == 0xC0 || m == 0xC1 || m == 0xC2:
+			if f.comps != nil {
+				return nil, errFormat("several frames")
+			}
+			if err := f.readSOF(seg, m == 0xC2); err != nil {
+				return nil, err
+			}
+		case m == 0xC3 || m >= 0xC5 && m <= 0xC7 || m >= 0xCB && m <= 0xCF:
+			return nil, errUnsupported("lossless or hierarchical")
+		case m == 0xC9 || m == 0xCA || m == 0xCC:
+			return nil, errUnsupported("arithmetic-coded")
+		case m == 0xDA:
+			if f.comps == nil {
+				return nil, errFormat("scan before frame header")
+			}
+			start := pos + 2 + n
+			end := scanEnd(data, start)
+			if err := f.decodeScan(seg, data[start:end], &huff, restart); err != nil {
+				return nil, err
+			}
+			scans++
+			pos = end
+			continue
+		case m >= 0xE0 && m <= 0xEF || m == 0xFE:
+			f.segments = append(f.segments, data[pos:pos+2+n])
+		}
+		pos += 2 + n
+	}
+	return f, nil
+}
+
+// scanEnd returns the offset of the first marker after the entropy-coded
+// data starting at start, skipping stuffed bytes and restart markers.
+func scanEnd(data []byte, start int) int {
+	for i := start; i+1 < len(data); i++ {
+		if data[i] != 0xFF {
+			continue
+		}
+		if m := data[i+1]; m != 0 && (m < 0xD0 || m > 0xD7) {
+			return i
+		}
+		i++
+	}
+	return len(data)
+}
+
+func (f *frame) readDQT(seg []byte) error {
+	for len(seg) > 0 {
+		pq, tq := seg[0]>>4, seg[0]&15
+		seg = seg[1:]
+		if tq > 3 || pq > 1 || len(seg) < 64*int(pq+1) {
+			return errFormat("bad DQT")
+		}
+		for i := range f.quant[tq] {
+			if pq == 0 {
+				f.quant[tq][i] = uint16(seg[i])
+			} else {
+				f.quant[tq][i] = binary.BigEndian.Uint16(seg[2*i:])
+			}
+		}
+		seg = seg[64*int(pq+1):]
+	}
+	return nil
+}
+
+func (f *frame) readSOF(seg []byte, progressive bool) error {
+	if len(seg) < 6 {
+		return errFormat("short SOF")
+	}
+	if seg[0] != 8 {
+		return errUnsupported(fmt.Sprintf("%d-bit", seg[0]))
+	}
+	f.height = int(binary.BigEndian.Uint16(seg[1:]))
+	f.width = int(binary.BigEndian.Uint16(seg[3:]))
+	f.progressive = progressive
+	nc := int(seg[5])
+	if f.height == 0 {
+		return errUnsupported("DNL-sized")
+	}
+	if f.width == 0 || nc < 1 || nc > 4 || len(seg) < 6+3*nc {
+		return errFormat("bad SOF")
+	}
+	if f.width*f.height > maxPixels {
+		return apperrors.New(apperrors.CategoryInput, "losslessjpeg.decode",
+			fmt.Errorf("%w: %dx%d", apperrors.ErrImageTooLarge, f.width, f.height))
+	}
+	for i := 0; i < nc; i++ {
+		p := seg[6+3*i:]
+		c := &component{id: p[0], h: int(p[1] >> 4), v: int(p[1] & 15), tq: p[2]}
+		if c.h < 1 || c.h > 4 || c.v < 1 || c.v > 4 || c.tq > 3 {
+			return errFormat("bad component %d", c.id)
+		}
+		f.comps = append(f.comps, c)
+	}
+	// A lone component is never interleaved, so its factors are moot.
+	if nc == 1 {
+		f.comps[0].h, f.comps[0].v = 1, 1
+	}
+	hmax, vmax := f.hmax(), f.vmax()
+	for _, c := range f.comps {
+		if hmax%c.h != 0 || vmax%c.v != 0 {
+			return errUnsupported("fractionally subsampled")
+		}
+	}
+	f.alloc()
+	return nil
+}
+
+// huffTable is a Huffman decoding table in the T.81 F.2.2.3 form.
+type huffTable struct {
+	maxcode [17]int32 // -1 when no code has the length
+	valptr  [17]int32
+	mincode [17]int32
+	values  []uint8
+}
+
+func readDHT(seg []byte, huff *[2][4]*huffTable) error {
+	for len(seg) > 0 {
+		if len(seg) < 17 {
+			return errFormat("short DHT")
+		}
+		tc, th := seg[0]>>4, seg[0]&15
+		total := 0
+		for _, n := range seg[1:17] {
+			total += int(n)
+		}
+		if tc > 1 || th > 3 || total > 256 || len(seg) < 17+total {
+			return errFormat("bad DHT")
+		}
+		t := &huffTable{values: append([]uint8(nil), seg[17:17+total]...)}
+		code, k := int32(0), int32(0)
+		for l := 1; l <= 16; l++ {
+			n := int32(seg[l])
+			t.valptr[l], t.mincode[l], t.maxcode[l] = k, code, code+n-1
+			if n == 0 {
+				t.maxcode[l] = -1
+			}
+			code, k = (code+n)<<1, k+n
+		}
+		huff[tc][th] = t
+		seg = seg[17+total:]
+	}
+	return nil
+}
+
+// bitReader reads entropy-coded data, removing stuffed zero bytes.  At a
+// marker or the end of data it returns zero bits, which is how truncated
+// files decode elsewhere too.
+type bitReader struct {
+	data []byte
+	pos  int
+	acc  uint32
+	n    uint
+}
+
+func (r *bitReader) bit() int32 {
+	if r.n == 0 {
+		b := byte(0)
+		if r.pos < len(r.data) {
+			b = r.data[r.pos]
+			if b != 0xFF {
+				r.pos++
+			} else if r.pos+1 < len(r.data) && r.data[r.pos+1] == 0 {
+				r.pos += 2
+			} else {
+				b = 0 // a restart marker: stay in front of it
+			}
+		}
+		r.acc, r.n = uint32(b), 8
+	}
+	r.n--
+	return int32(r.acc>>r.n) & 1
+}
+
+func (r *bitReader) bits(n uint8) int32 {
+	v := int32(0)
+	for ; n > 0; n-- {
+		v = v<<1 | r.bit()
+	}
+	return v
+}
+
+// receiveExtend reads an n-bit magnitude and sign-extends it (T.81 F.2.2.1).
+func (r *bitReader) receiveExtend(n uint8) int32 {
+	v := r.bits(n)
+	if n > 0 && v < 1<<(n-1) {
+		v += -1<<n + 1
+	}
+	return v
+}
+
+var errBadCode = errors.New("invalid Huffman code")
+
+func (r *bitReader) decode(t *huffTable) (uint8, error) {
+	if t == nil {
+		return 0, errFormat("scan uses an undefined Huffman table")
+	}
+	code := int32(0)
+	for l := 1; l <= 16; l++ {
+		code = code<<1 | r.bit()
+		if code <= t.maxcode[l] {
+			return t.values[t.valptr[l]+code-t.mincode[l]], nil
+		}
+	}
+	return 0, errFormat("%w", errBadCode)
+}
+
+// restart skips to just past the next restart marker.
+func (r *bitReader) restart() {
+	r.n = 0
+	for ; r.pos+1 < len(r.data); r.pos++ {
+		if r.data[r.pos] == 0xFF && r.data[r.pos+1] >= 0xD0 && r.data[r.pos+1] <= 0xD7 {
+			r.pos += 2
+			return
+		}
+	}
+}
+
+// decodeScan decodes one scan, whose header is seg and entropy-coded data
+// is data, into the frame's blocks.
+func (f *frame) decodeScan(seg, data []byte, huff *[2][4]*huffTable, restart int) error {
+	if len(seg) < 1 {
+		return errFormat("short SOS")
+	}
+	ns := int(seg[0])
+	if ns < 1 || ns > 4 || len(seg) < 1+2*ns+3 {
+		return errFormat("bad SOS")
+	}
+	comps := make([]*component, ns)
+	for i := range comps {
+		id := seg[1+2*i]
+		for _, c := range f.comps {
+			if c.id == id {
+				comps[i] = c
+			}
+		}
+		if comps[i] == nil {
+			return errFormat("scan names unknown component %d", id)
+		}
+		comps[i].td, comps[i].ta = seg[2+2*i]>>4, seg[2+2*i]&15
+		if comps[i].td > 3 || comps[i].ta > 3 {
+			return errFormat("bad SOS table selector")
+		}
+	}
+	p := seg[1+2*ns:]
+	ss, se, ah, al := int(p[0]), int(p[1]), p[2]>>4, p[2]&15
+	if !f.progressive {
+		ss, se, ah, al = 0, 63, 0, 0
+	} else if ss > se || se > 63 || (ss == 0) != (se == 0) || ss > 0 && ns > 1 || al > 13 {
+		return errFormat("bad progressive scan %d-%d", ss, se)
+	}
+
+	var (
+		r      = &bitReader{data: data}
+		dc     [4]int32
+		eobrun int32
+	)
+	decodeBlock := func(i int, c *component, b *block) error {
+		if ss == 0 {
+			if ah > 0 {
+				if r.bit() == 1 {
+					b[0] |= 1 << al
+				}
+				return nil
+			}
+			s, err := r.decode(huff[0][c.td])
+			if err != nil {
+				return err
+			}
+			if s > 15 {
+				return errFormat("%w", errBadCode)
+			}
+			dc[i] += r.receiveExtend(s)
+			b[0] = int16(dc[i] << al)
+			if f.progressive {
+				return nil
+			}
+			return acFirst(r, huff[1][c.ta], b, 1, 63, 0, &eobrun)
+		}
+		if ah == 0 {
+			return acFirst(r, huff[1][c.ta], b, ss, se, al, &eobrun)
+		}
+		return acRefine(r, huff[1][c.ta], b, ss, se, al, &eobrun)
+	}
+
+	n := 0
+	next := func() {
+		if restart > 0 && n > 0 && n%restart == 0 {
+			r.restart()
+			dc, eobrun = [4]int32{}, 0
+		}
+		n++
+	}
+	if ns == 1 {
+		c := comps[0]
+		cw, ch := f.compBlocks(c)
+		for by := 0; by < ch; by++ {
+			for bx := 0; bx < cw; bx++ {
+				next()
+				if err := decodeBlock(0, c, &c.blocks[by*c.bw+bx]); err != nil {
+					return err
+				}
+			}
+		}
+		return nil
+	}
+	mx, my := f.mcus()
+	for y := 0; y < my; y++ {
+		for x := 0; x < mx; x++ {
+			next()
+			for i, c := range comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						if err := decodeBlock(i, c, &c.blocks[(y*c.v+v)*c.bw+x*c.h+h]); err != nil {
+							return err
+						}
+					}
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// acFirst decodes AC coefficients ss..se of b, scaled by al, in a
+// sequential scan or the first progressive pass over them (T.81 G.1.2.2).
+func acFirst(r *bitReader, t *huffTable, b *block, ss, se int, al uint8, eobrun *int32) error {
+	if *eobrun > 0 {
+		*eobrun--
+		return nil
+	}
+	for k := ss; k <= se; k++ {
+		rs, err := r.decode(t)
+		if err != nil {
+			return err
+		}
+		run, s := int(rs>>4), rs&15
+		if s == 0 {
+			if run < 15 {
+				*eobrun = 1<<run + r.bits(uint8(run)) - 1
+				return nil
+			}
+			k += 15 // ZRL
+			continue
+		}
+		k += run
+		if k > se {
+			return errFormat("too many coefficients")
+		}
+		b[k] = int16(r.receiveExtend(s) << al)
+	}
+	return nil
+}
+
+// acRefine adds bit al to AC coefficients ss..se of b in a successive
+// approximation pass (T.81 G.1.2.3).
+func acRefine(r *bitReader, t *huffTable, b *block, ss, se int, al uint8, eobrun *int32) error {
+	delta := int16(1) << al
+	k := ss
+	if *eobrun == 0 {
+	coefs:
+		for ; k <= se; k++ {
+			rs, err := r.decode(t)
+			if err != nil {
+				return err
+			}
+			run, s := int(rs>>4), rs&15
+			z := int16(0)
+			switch s {
+			case 0:
+				if run < 15 {
+					*eobrun = 1<<run + r.bits(uint8(run))
+					break coefs
+				}
+			case 1:
+				z = delta
+				if r.bit() == 0 {
+					z = -z
+				}
+			default:
+				return errFormat("%w", errBadCode)
+			}
+			k = refineNonZeroes(r, b, k, se, run, delta)
+			if k > se {
+				return errFormat("too many coefficients")
+			}
+			if z != 0 {
+				b[k] = z
+			}
+		}
+	}
+	if *eobrun > 0 {
+		*eobrun--
+		refineNonZeroes(r, b, k, se, -1, delta)
+	}
+	return nil
+}
+
+// refineNonZeroes refines the non-zero coefficients from k on, stopping at
+// the zero coefficient after skipping nz of them, and returns its index.
+func refineNonZeroes(r *bitReader, b *block, k, se, nz int, delta int16) int {
+	for ; k <= se; k++ {
+		if b[k] == 0 {
+			if nz == 0 {
+				break
+			}
+			nz--
+			continue
+		}
+		if r.bit() == 0 {
+			continue
+		}
+		if b[k] >= 0 {
+			b[k] += delta
+		} else {
+			b[k] -= delta
+		}
+	}
+	return k
+}
@@ -0,0 +1,275 @@
+package losslessjpeg
+
+import "bytes"
+
+// encode writes f as a baseline JPEG with Huffman tables optimised for its
+// coefficients: table 0 for the first component, table 1 for the others.
+func (f *frame) encode() []byte {
+	var freq [2][2][257]int // [class][table][symbol]
+	f.entropy(func(class, table int, sym uint8, _ uint32, _ uint8) { freq[class][table][sym]++ })
+	tables := min(len(f.comps), 2)
+
+	var buf bytes.Buffer
+	buf.Write([]byte{0xFF, 0xD8})
+	for _, seg := range f.segments {
+		buf.Write(seg)
+	}
+
+	// Baseline only allows 8-bit quantisation tables; wider ones need the
+	// extended sequential SOF1, which every decoder reading 16-bit tables
+	// supports too.
+	sof := byte(0xC0)
+	var used [4]bool
+	for _, c := range f.comps {
+		used[c.tq] = true
+	}
+	for tq, q := range f.quant {
+		if !used[tq] {
+			continue
+		}
+		wide := false
+		for _, v := range q {
+			wide = wide || v > 255
+		}
+		if !wide {
+			marker(&buf, 0xDB, 2+65)
+			buf.WriteByte(byte(tq))
+			for _, v := range q {
+				buf.WriteByte(byte(v))
+			}
+			continue
+		}
+		sof = 0xC1
+		marker(&buf, 0xDB, 2+129)
+		buf.WriteByte(0x10 | byte(tq))
+		for _, v := range q {
+			buf.Write([]byte{byte(v >> 8), byte(v)})
+		}
+	}
+
+	marker(&buf, sof, 8+3*len(f.comps))
+	buf.Write([]byte{8, byte(f.height >> 8), byte(f.height), byte(f.width >> 8), byte(f.width), byte(len(f.comps))})
+	for _, c := range f.comps {
+		buf.Write([]byte{c.id, byte(c.h<<4 | c.v), c.tq})
+	}
+
+	var codes [2][2][256]huffCode
+	for class := range freq {
+		for t := 0; t < tables; t++ {
+			counts, values := optimalHuffman(&freq[class][t])
+			marker(&buf, 0xC4, 2+17+len(values))
+			buf.WriteByte(byte(class<<4 | t))
+			buf.Write(counts[:])
+			buf.Write(values)
+			codes[class][t] = huffCodes(counts, values)
+		}
+	}
+
+	marker(&buf, 0xDA, 6+2*len(f.comps))
+	buf.WriteByte(byte(len(f.comps)))
+	for i, c := range f.comps {
+		t := byte(min(i, 1))
+		buf.Write([]byte{c.id, t<<4 | t})
+	}
+	buf.Write([]byte{0, 63, 0})
+	bw := &bitWriter{buf: &buf}
+	f.entropy(func(class, table int, sym uint8, bits uint32, n uint8) {
+		c := codes[class][table][sym]
+		bw.emit(c.code, c.size)
+		bw.emit(bits, n)
+	})
+	bw.flush()
+	buf.Write([]byte{0xFF, 0xD9})
+	return buf.Bytes()
+}
+
+func marker(buf *bytes.Buffer, m byte, length int) {
+	buf.Write([]byte{0xFF, m, byte(length >> 8), byte(length)})
+}
+
+// entropy walks f's blocks in baseline scan order and passes each Huffman
+// symbol, with the magnitude bits that follow it, to emit.
+func (f *frame) entropy(emit func(class, table int, sym uint8, bits uint32, n uint8)) {
+	prevDC := make([]int16, len(f.comps))
+	code := func(i int, b *block) {
+		t := min(i, 1)
+		n, bits := category(int32(b[0]) - int32(prevDC[i]))
+		prevDC[i] = b[0]
+		emit(0, t, n, bits, n)
+		run := uint8(0)
+		for k := 1; k < 64; k++ {
+			if b[k] == 0 {
+				run++
+				continue
+			}
+			for ; run > 15; run -= 16 {
+				emit(1, t, 0xF0, 0, 0) // ZRL
+			}
+			n, bits := category(int32(b[k]))
+			emit(1, t, run<<4|n, bits, n)
+			run = 0
+		}
+		if run > 0 {
+			emit(1, t, 0x00, 0, 0) // EOB
+		}
+	}
+
+	if len(f.comps) == 1 {
+		c := f.comps[0]
+		cw, ch := f.compBlocks(c)
+		for by := 0; by < ch; by++ {
+			for bx := 0; bx < cw; bx++ {
+				code(0, &c.blocks[by*c.bw+bx])
+			}
+		}
+		return
+	}
+	mx, my := f.mcus()
+	for y := 0; y < my; y++ {
+		for x := 0; x < mx; x++ {
+			for i, c := range f.comps {
+				for v := 0; v < c.v; v++ {
+					for h := 0; h < c.h; h++ {
+						code(i, &c.blocks[(y*c.v+v)*c.bw+x*c.h+h])
+					}
+				}
+			}
+		}
+	}
+}
+
+// category returns the magnitude category of v and its low bits as coded
+// after the Huffman symbol (T.81 F.1.2.1).
+func category(v int32) (uint8, uint32) {
+	a, bits := v, v
+	if a < 0 {
+		a, bits = -v, v-1
+	}
+	n := uint8(0)
+	for ; a > 0; a >>= 1 {
+		n++
+	}
+	return n, uint32(bits) & (1<<n - 1)
+}
+
+// optimalHuffman builds a length-limited Huffman table for freq, in DHT
+// form, with the procedure of T.81 K.2.  freq[256] is scratch space for
+// the reserved all-ones code.
+func optimalHuffman(freq *[257]int) (counts [16]uint8, values []uint8) {
+	var (
+		f      = *freq
+		size   [257]int
+		others [257]int
+	)
+	for i := range others {
+		others[i] = -1
+	}
+	f[256] = 1
+	for {
+		c1, c2 := -1, -1
+		for i, v := range f {
+			if v > 0 && (c1 < 0 || v <= f[c1]) {
+				c1 = i
+			}
+		}
+		for i, v := range f {
+			if v > 0 && i != c1 && (c2 < 0 || v <= f[c2]) {
+				c2 = i
+			}
+		}
+		if c2 < 0 {
+			break
+		}
+		f[c1] += f[c2]
+		f[c2] = 0
+		for size[c1]++; others[c1] >= 0; size[c1]++ {
+			c1 = others[c1]
+		}
+		others[c1] = c2
+		for size[c2]++; others[c2] >= 0; size[c2]++ {
+			c2 = others[c2]
+		}
+	}
+
+	var bits [33]int
+	for _, s := range size {
+		if s > 0 {
+			bits[min(s, 32)]++
+		}
+	}
+	for i := 32; i > 16; i-- {
+		for bits[i] > 0 {
+			j := i - 2
+			for bits[j] == 0 {
+				j--
+			}
+			bits[i] -= 2
+			bits[i-1]++
+			bits[j+1] += 2
+			bits[j]--
+		}
+	}
+	i := 16
+	for bits[i] == 0 {
+		i--
+	}
+	bits[i]-- // drop the reserved code
+	for l := 1; l <= 16; l++ {
+		counts[l-1] = uint8(bits[l])
+	}
+	for l := 1; l <= 32; l++ {
+		for sym := 0; sym < 256; sym++ {
+			if size[sym] == l {
+				values = append(values, uint8(sym))
+			}
+		}
+	}
+	return counts, values
+}
+
+// huffCode is a single Huffman code: the low size bits of code.
+type huffCode struct {
+	code uint32
+	size uint8
+}
+
+func huffCodes(counts [16]uint8, values []uint8) (t [256]huffCode) {
+	code, k := uint32(0), 0
+	for l, n := range counts {
+		for j := 0; j < int(n); j++ {
+			t[values[k]] = huffCode{code: code, size: uint8(l + 1)}
+			code++
+			k++
+		}
+		code <<= 1
+	}
+	return t
+}
+
+type bitWriter struct {
+	buf   *bytes.Buffer
+	bits  uint32
+	nbits uint8
+}
+
+// emit appends the low n bits of bits, stuffing 0x00 after 0xFF.
+func (w *bitWriter) emit(bits uint32, n uint8) {
+	w.bits = w.bits<<n | bits&(1<<n-1)
+	w.nbits += n
+	for w.nbits >= 8 {
+		b := byte(w.bits >> (w.nbits - 8))
+		w.nbits -= 8
+		w.buf.WriteByte(b)
+		if b == 0xFF {
+			w.buf.WriteByte(0)
+		}
+	}
+	w.bits &= 1<<w.nbits - 1
+}
+
+// flush pads the final partial byte with 1s.
+func (w *bitWriter) flush() {
+	if w.nbits > 0 {
+		w.emit(1<<(8-w.nbits)-1, 8-w.nbits)
+	}
+}
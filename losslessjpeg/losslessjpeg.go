@@ -0,0 +1,200 @@
+// Package losslessjpeg rotates, flips and crops JPEG files without decoding
+// them to pixels, in the manner of jpegtran: the quantised DCT coefficients
+// are rearranged and written back with the original quantisation tables,
+// so no generation loss occurs.  Baseline and progressive Huffman-coded
+// 8-bit files are read; the output is always baseline with optimised
+// Huffman tables.  APPn and COM segments (EXIF, ICC, XMP) are copied
+// unchanged.
+//
+// Transforms work on whole MCUs, 16×16 pixels for 4:2:0 files.  Mirroring
+// an axis whose length is not a multiple of the MCU size drops the partial
+// MCU at its far edge, like jpegtran -trim; Perfect reports whether a
+// transform keeps every pixel.
+package losslessjpeg
+
+import (
+	"encoding/binary"
+	"fmt"
+	"image"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Transform is a lossless geometric transform.  The values follow the EXIF
+// orientation tag: the transform that displays an image tagged with
+// orientation o upright is Transform(o-1), see ForOrientation.
+type Transform int
+
+const (
+	None           Transform = iota
+	FlipHorizontal           // mirror left to right
+	Rotate180
+	FlipVertical // mirror top to bottom
+	Transpose    // mirror across the top-left to bottom-right diagonal
+	Rotate90     // clockwise
+	Transverse   // mirror across the top-right to bottom-left diagonal
+	Rotate270    // clockwise, i.e. 90° counter-clockwise
+)
+
+// ForOrientation returns the transform that displays an image carrying
+// EXIF orientation o (1-8) upright; other values map to None.
+func ForOrientation(o int) Transform {
+	if o < 1 || o > 8 {
+		return None
+	}
+	return Transform(o - 1)
+}
+
+// axes reports which source axes t mirrors and whether it swaps them.
+func (t Transform) axes() (mirrorX, mirrorY, transpose bool) {
+	switch t {
+	case FlipHorizontal:
+		return true, false, false
+	case Rotate180:
+		return true, true, false
+	case FlipVertical:
+		return false, true, false
+	case Transpose:
+		return false, false, true
+	case Rotate90:
+		return false, true, true
+	case Transverse:
+		return true, true, true
+	case Rotate270:
+		return true, false, true
+	}
+	return false, false, false
+}
+
+// Apply returns data transformed by t.  Partial MCUs on mirrored edges are
+// dropped; see Perfect.
+func Apply(data []byte, t Transform) ([]byte, error) {
+	if t < None || t > Rotate270 {
+		return nil, apperrors.New(apperrors.CategoryConfig, "losslessjpeg.apply", fmt.Errorf("unknown transform %d", t))
+	}
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	return f.transform(t).encode(), nil
+}
+
+// Perfect reports whether t keeps every pixel of data, i.e. whether each
+// axis it mirrors is a whole number of MCUs long.
+func Perfect(data []byte, t Transform) (bool, error) {
+	f, err := decode(data)
+	if err != nil {
+		return false, err
+	}
+	return f.perfect(t), nil
+}
+
+// Crop returns the part of data inside r.  The top-left corner is moved
+// up and left to the nearest MCU boundary, so the result may start up to
+// one MCU before r; its bottom-right corner is r.Max clipped to the image.
+func Crop(data []byte, r image.Rectangle) ([]byte, error) {
+	f, err := decode(data)
+	if err != nil {
+		return nil, err
+	}
+	r = r.Intersect(image.Rect(0, 0, f.width, f.height))
+	if r.Empty() {
+		return nil, apperrors.New(apperrors.CategoryInput, "losslessjpeg.crop",
+			fmt.Errorf("%w: crop outside the %dx%d image", apperrors.ErrInvalidDimensions, f.width, f.height))
+	}
+	return f.crop(r).encode(), nil
+}
+
+// AutoOrient applies the transform named by data's EXIF orientation tag
+// and resets the tag to 1.  It reports false, returning data unchanged,
+// when there is nothing to do or the transform would not be perfect;
+// callers then fall back to rotating decoded pixels.
+func AutoOrient(data []byte) ([]byte, bool, error) {
+	o := Orientation(data)
+	if o <= 1 || o > 8 {
+		return data, false, nil
+	}
+	f, err := decode(data)
+	if err != nil {
+		return nil, false, err
+	}
+	t := ForOrientation(o)
+	if !f.perfect(t) {
+		return data, false, nil
+	}
+	out := f.transform(t)
+	out.segments = make([][]byte, len(f.segments))
+	for i, seg := range f.segments {
+		if _, off := exifOrientation(seg); off > 0 {
+			seg = append([]byte(nil), seg...)
+			order := exifByteOrder(seg)
+			order.PutUint16(seg[off:], 1)
+		}
+		out.segments[i] = seg
+	}
+	return out.encode(), true, nil
+}
+
+// Orientation returns the EXIF orientation tag of data, or 1 when it has
+// none.
+func Orientation(data []byte) int {
+	if len(data) < 4 || data[0] != 0xFF || data[1] != 0xD8 {
+		return 1
+	}
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		m := data[pos+1]
+		if m == 0xDA || m == 0xD9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			break
+		}
+		if o, off := exifOrientation(data[pos : pos+2+n]); off > 0 {
+			return o
+		}
+		pos += 2 + n
+	}
+	return 1
+}
+
+// exifOrientation finds the orientation tag in an APP1 EXIF segment,
+// marker included, and returns its value and offset in seg; the offset is
+// 0 when seg is not EXIF or carries no orientation.
+func exifOrientation(seg []byte) (int, int) {
+	const hdr = 4 + 6 // marker, length, "Exif\0\0"
+	if len(seg) < hdr+8 || seg[1] != 0xE1 || string(seg[4:hdr]) != "Exif\x00\x00" {
+		return 1, 0
+	}
+	tiff := seg[hdr:]
+	order := exifByteOrder(seg)
+	if order == nil {
+		return 1, 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 1, 0
+	}
+	n := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < n; i++ {
+		e := ifd + 2 + 12*i
+		if e+12 > len(tiff) {
+			break
+		}
+		if order.Uint16(tiff[e:]) == 0x0112 && order.Uint16(tiff[e+2:]) == 3 {
+			return int(order.Uint16(tiff[e+8:])), hdr + e + 8
+		}
+	}
+	return 1, 0
+}
+
+// exifByteOrder returns the byte order of an APP1 EXIF segment.
+func exifByteOrder(seg []byte) binary.ByteOrder {
+	switch string(seg[10:12]) {
+	case "II":
+		return binary.LittleEndian
+	case "MM":
+		return binary.BigEndian
+	}
+	return nil
+}
@@ -0,0 +1,104 @@
+package losslessjpeg
+
+import "image"
+
+func (f *frame) perfect(t Transform) bool {
+	mirrorX, mirrorY, _ := t.axes()
+	return (!mirrorX || f.width%(8*f.hmax()) == 0) && (!mirrorY || f.height%(8*f.vmax()) == 0)
+}
+
+// transform returns f transformed by t, trimming partial MCUs on mirrored
+// edges.
+func (f *frame) transform(t Transform) *frame {
+	if t == None {
+		return f
+	}
+	mirrorX, mirrorY, transpose := t.axes()
+	mw, mh := 8*f.hmax(), 8*f.vmax()
+	w, h := f.width, f.height
+	if mirrorX && w >= mw {
+		w -= w % mw
+	}
+	if mirrorY && h >= mh {
+		h -= h % mh
+	}
+
+	out := &frame{width: w, height: h, quant: f.quant, segments: f.segments}
+	if transpose {
+		out.width, out.height = h, w
+		for tq := range out.quant {
+			for k := range out.quant[tq] {
+				n := unzig[k]
+				out.quant[tq][k] = f.quant[tq][zig[n%8*8+n/8]]
+			}
+		}
+	}
+	for _, c := range f.comps {
+		oc := &component{id: c.id, h: c.h, v: c.v, tq: c.tq}
+		if transpose {
+			oc.h, oc.v = c.v, c.h
+		}
+		out.comps = append(out.comps, oc)
+	}
+	out.alloc()
+
+	trimmed := &frame{width: w, height: h, comps: f.comps}
+	for i, oc := range out.comps {
+		c := f.comps[i]
+		nbx, nby := trimmed.compBlocks(c)
+		for oy := 0; oy < oc.bh; oy++ {
+			for ox := 0; ox < oc.bw; ox++ {
+				sx, sy := ox, oy
+				if transpose {
+					sx, sy = oy, ox
+				}
+				if mirrorX {
+					sx = nbx - 1 - sx
+				}
+				if mirrorY {
+					sy = nby - 1 - sy
+				}
+				src := &c.blocks[clamp(sy, c.bh)*c.bw+clamp(sx, c.bw)]
+				dst := &oc.blocks[oy*oc.bw+ox]
+				for k, v := range src {
+					n := unzig[k]
+					u, vf := n%8, n/8 // horizontal and vertical frequency
+					if mirrorX && u%2 == 1 || mirrorY && vf%2 == 1 {
+						v = -v
+					}
+					if transpose {
+						dst[zig[u*8+vf]] = v
+					} else {
+						dst[k] = v
+					}
+				}
+			}
+		}
+	}
+	return out
+}
+
+// crop returns the part of f inside r, which must lie within the image,
+// extended up and left to an MCU boundary.
+func (f *frame) crop(r image.Rectangle) *frame {
+	mw, mh := 8*f.hmax(), 8*f.vmax()
+	x0, y0 := r.Min.X/mw, r.Min.Y/mh // in MCUs
+	out := &frame{width: r.Max.X - x0*mw, height: r.Max.Y - y0*mh, quant: f.quant, segments: f.segments}
+	for _, c := range f.comps {
+		out.comps = append(out.comps, &component{id: c.id, h: c.h, v: c.v, tq: c.tq})
+	}
+	out.alloc()
+	for i, oc := range out.comps {
+		c := f.comps[i]
+		for oy := 0; oy < oc.bh; oy++ {
+			for ox := 0; ox < oc.bw; ox++ {
+				sx, sy := clamp(x0*c.h+ox, c.bw), clamp(y0*c.v+oy, c.bh)
+				oc.blocks[oy*oc.bw+ox] = c.blocks[sy*c.bw+sx]
+			}
+		}
+	}
+	return out
+}
+
+// clamp limits i to [0, n).
+func clamp(i, n int) int { return max(0, min(i, n-1)) }
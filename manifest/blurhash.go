@@ -0,0 +1,120 @@
+package manifest
+
+import (
+	"fmt"
+	"image"
+	"math"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// blurHashSample bounds the image BlurHash is computed from; the hash only
+// keeps a handful of low frequencies, so a thumbnail gives the same result.
+const blurHashSample = 32
+
+// BlurHash encodes img as a BlurHash (https://blurha.sh) with x×y
+// components, each 1-9.
+func BlurHash(img image.Image, x, y int) (string, error) {
+	if x < 1 || x > 9 || y < 1 || y > 9 {
+		return "", fmt.Errorf("blurhash components %dx%d outside 1-9", x, y)
+	}
+	b := img.Bounds()
+	if b.Empty() {
+		return "", fmt.Errorf("blurhash of empty image")
+	}
+	w, h := b.Dx(), b.Dy()
+	if w > blurHashSample || h > blurHashSample {
+		scale := float64(blurHashSample) / float64(max(w, h))
+		w, h = max(1, int(float64(w)*scale)), max(1, int(float64(h)*scale))
+	}
+	px := image.NewNRGBA(image.Rect(0, 0, w, h))
+	xdraw.ApproxBiLinear.Scale(px, px.Rect, img, b, xdraw.Src, nil)
+
+	lin := make([][3]float64, w*h)
+	for i := range lin {
+		p := px.Pix[i*4 : i*4+3 : i*4+3]
+		lin[i] = [3]float64{srgbToLinear(p[0]), srgbToLinear(p[1]), srgbToLinear(p[2])}
+	}
+
+	factors := make([][3]float64, 0, x*y)
+	for j := 0; j < y; j++ {
+		for i := 0; i < x; i++ {
+			norm := 2.0
+			if i == 0 && j == 0 {
+				norm = 1
+			}
+			var f [3]float64
+			for sy := 0; sy < h; sy++ {
+				cy := math.Cos(math.Pi * float64(j) * float64(sy) / float64(h))
+				for sx := 0; sx < w; sx++ {
+					basis := cy * math.Cos(math.Pi*float64(i)*float64(sx)/float64(w))
+					c := lin[sy*w+sx]
+					f[0] += basis * c[0]
+					f[1] += basis * c[1]
+					f[2] += basis * c[2]
+				}
+			}
+			scale := norm / float64(w*h)
+			factors = append(factors, [3]float64{f[0] * scale, f[1] * scale, f[2] * scale})
+		}
+	}
+
+	var sb strings.Builder
+	base83(&sb, (x-1)+(y-1)*9, 1)
+
+	dc, ac := factors[0], factors[1:]
+	maxAC := 1.0
+	if len(ac) > 0 {
+		actual := 0.0
+		for _, f := range ac {
+			actual = math.Max(actual, math.Max(math.Abs(f[0]), math.Max(math.Abs(f[1]), math.Abs(f[2]))))
+		}
+		q := int(math.Max(0, math.Min(82, math.Floor(actual*166-0.5))))
+		maxAC = float64(q+1) / 166
+		base83(&sb, q, 1)
+	} else {
+		base83(&sb, 0, 1)
+	}
+
+	base83(&sb, linearToSRGB(dc[0])<<16|linearToSRGB(dc[1])<<8|linearToSRGB(dc[2]), 4)
+	for _, f := range ac {
+		q := func(v float64) int {
+			return int(math.Max(0, math.Min(18, math.Floor(signPow(v/maxAC, 0.5)*9+9.5))))
+		}
+		base83(&sb, q(f[0])*19*19+q(f[1])*19+q(f[2]), 2)
+	}
+	return sb.String(), nil
+}
+
+const base83Chars = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz#$%*+,-.:;=?@[]^_{|}~"
+
+func base83(sb *strings.Builder, v, digits int) {
+	div := 1
+	for i := 1; i < digits; i++ {
+		div *= 83
+	}
+	for ; div > 0; div /= 83 {
+		sb.WriteByte(base83Chars[(v/div)%83])
+	}
+}
+
+func srgbToLinear(v uint8) float64 {
+	f := float64(v) / 255
+	if f <= 0.04045 {
+		return f / 12.92
+	}
+	return math.Pow((f+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB(v float64) int {
+	v = math.Max(0, math.Min(1, v))
+	if v <= 0.0031308 {
+		return int(v*12.92*255 + 0.5)
+	}
+	return int((1.055*math.Pow(v, 1/2.4)-0.055)*255 + 0.5)
+}
+
+func signPow(v, exp float64) float64 {
+	return math.Copysign(math.Pow(math.Abs(v), exp), v)
+}
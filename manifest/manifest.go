@@ -0,0 +1,176 @@
+// Package manifest describes a set of processed derivatives as one JSON
+// document: dimensions, formats, sizes, content hashes, BlurHash
+// placeholders and storage keys for the primary output and every variant.
+// Frontends and CDNs read the manifest instead of probing each object.
+package manifest
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Version is the manifest schema version written to Manifest.Version.
+const Version = 1
+
+// Entry describes one derivative.
+type Entry struct {
+	Variant     string      `json:"variant,omitempty"` // "" for the primary output
+	Key         string      `json:"key,omitempty"`     // storage path, when stored
+	Bucket      string      `json:"bucket,omitempty"`
+	Width       int         `json:"width"`
+	Height      int         `json:"height"`
+	Format      core.Format `json:"format"`
+	ContentType string      `json:"content_type"`
+	Size        int64       `json:"size"`
+	SHA256      string      `json:"sha256"`
+	BlurHash    string      `json:"blurhash,omitempty"`
+}
+
+// Manifest describes a ProcessingResult.  Entries holds the primary output
+// first, then variants sorted by name.
+type Manifest struct {
+	Version     int       `json:"version"`
+	Source      string    `json:"source,omitempty"`
+	Fingerprint string    `json:"fingerprint,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	Entries     []Entry   `json:"entries"`
+}
+
+// Options controls Build.
+type Options struct {
+	// Source names the original, e.g. its storage path.
+	Source string
+	// Fingerprint identifies the pipeline; see Fingerprint.
+	Fingerprint string
+	// Keys maps variant name ("" for primary) to where it was stored.
+	Keys map[string]core.StorageKey
+	// BlurHashX and BlurHashY are the BlurHash component counts (1-9).
+	// Zero selects 4×3; negative disables BlurHash.
+	BlurHashX, BlurHashY int
+	// Now overrides the CreatedAt clock; defaults to time.Now.
+	Now func() time.Time
+}
+
+// Build returns the manifest for res.  Every output must carry encoded
+// bytes, so steps should end with Encode.
+func Build(res *core.ProcessingResult, opts Options) (*Manifest, error) {
+	if res == nil || res.Primary == nil {
+		return nil, apperrors.New(apperrors.CategoryInput, "manifest.build", apperrors.ErrEmptyInput)
+	}
+	now := time.Now
+	if opts.Now != nil {
+		now = opts.Now
+	}
+	m := &Manifest{
+		Version:     Version,
+		Source:      opts.Source,
+		Fingerprint: opts.Fingerprint,
+		CreatedAt:   now().UTC(),
+	}
+
+	names := make([]string, 0, len(res.Variants))
+	for name := range res.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	add := func(variant string, img *core.ImageData) error {
+		e, err := NewEntry(variant, img, opts.BlurHashX, opts.BlurHashY)
+		if err != nil {
+			return err
+		}
+		if k, ok := opts.Keys[variant]; ok {
+			e.Key, e.Bucket = k.Path, k.Bucket
+		}
+		m.Entries = append(m.Entries, e)
+		return nil
+	}
+	if err := add("", res.Primary); err != nil {
+		return nil, err
+	}
+	for _, name := range names {
+		if err := add(name, res.Variants[name]); err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// NewEntry describes a single encoded image.  bx and by are the BlurHash
+// component counts as in Options.
+func NewEntry(variant string, img *core.ImageData, bx, by int) (Entry, error) {
+	if len(img.Data) == 0 {
+		return Entry{}, apperrors.New(apperrors.CategoryInput, "manifest.entry",
+			fmt.Errorf("%w: output %q has no encoded bytes", apperrors.ErrEmptyInput, variant))
+	}
+	sum := sha256.Sum256(img.Data)
+	e := Entry{
+		Variant:     variant,
+		Width:       img.Meta.Width,
+		Height:      img.Meta.Height,
+		Format:      img.Format,
+		ContentType: img.Format.ContentType(),
+		Size:        int64(len(img.Data)),
+		SHA256:      hex.EncodeToString(sum[:]),
+	}
+	if img.Image != nil {
+		b := img.Image.Bounds()
+		e.Width, e.Height = b.Dx(), b.Dy()
+		if bx >= 0 && by >= 0 {
+			if bx == 0 && by == 0 {
+				bx, by = 4, 3
+			}
+			src, err := core.ToStdImage(img.Image)
+			if err != nil {
+				return Entry{}, apperrors.New(apperrors.CategoryConfig, "manifest.entry", err)
+			}
+			h, err := BlurHash(src, bx, by)
+			if err != nil {
+				return Entry{}, apperrors.New(apperrors.CategoryConfig, "manifest.entry", err)
+			}
+			e.BlurHash = h
+		}
+	}
+	return e, nil
+}
+
+// Entry returns the entry for variant ("" for the primary output).
+func (m *Manifest) Entry(variant string) (Entry, bool) {
+	for _, e := range m.Entries {
+		if e.Variant == variant {
+			return e, true
+		}
+	}
+	return Entry{}, false
+}
+
+// JSON returns m as indented JSON.
+func (m *Manifest) JSON() ([]byte, error) {
+	return json.MarshalIndent(m, "", "  ")
+}
+
+// Fingerprint returns a stable identifier for a step list; see
+// core.Fingerprint.  Two pipelines with the same steps and parameters share
+// a fingerprint, so it can key caches and version CDN paths.  It is "" for
+// steps holding functions, which cannot be fingerprinted.
+func Fingerprint(steps ...core.Step) string {
+	return core.Fingerprint(steps)
+}
+
+// Upload stores m as JSON at key.
+func Upload(ctx context.Context, store core.StorageAdapter, key core.StorageKey, m *Manifest) error {
+	data, err := m.JSON()
+	if err != nil {
+		return apperrors.New(apperrors.CategoryStorage, "manifest.upload", err)
+	}
+	return store.Put(ctx, key, bytes.NewReader(data), map[string]string{"Content-Type": "application/json"})
+}
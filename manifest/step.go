@@ -0,0 +1,34 @@
+package manifest
+
+import (
+	"context"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// UploadStep writes a single-entry manifest for the image to Store at Key
+// and passes the image through unchanged.  Place it after Encode.  For a
+// primary output with variants use imageprocessor's
+// StorageResult.UploadManifest, which describes the whole set.
+type UploadStep struct {
+	Store   core.StorageAdapter
+	Key     core.StorageKey
+	Options Options // Keys[""] names the image's own storage key
+}
+
+func (s *UploadStep) Name() string { return "manifest_upload" }
+
+func (s *UploadStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Store == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrStorageUnavailable)
+	}
+	m, err := Build(&core.ProcessingResult{Primary: img}, s.Options)
+	if err != nil {
+		return nil, err
+	}
+	if err := Upload(ctx, s.Store, s.Key, m); err != nil {
+		return nil, err
+	}
+	return img, nil
+}
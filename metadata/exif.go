@@ -0,0 +1,301 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── TIFF structure ────────────────────────────────────────────────────────────
+
+// EXIF tags read by Read, by IFD.
+const (
+	tagMake        = 0x010F
+	tagModel       = 0x0110
+	tagOrientation = 0x0112
+	tagSoftware    = 0x0131
+	tagDateTime    = 0x0132
+	tagArtist      = 0x013B
+	tagXMP         = 0x02BC
+	tagCopyright   = 0x8298
+	tagIPTC        = 0x83BB
+	tagExifIFD     = 0x8769
+	tagICC         = 0x8773
+	tagGPSIFD      = 0x8825
+	tagSubIFDs     = 0x014A
+	tagStrips      = 0x0111
+	tagThumbOffset = 0x0201
+	tagThumbLength = 0x0202
+
+	tagExposureTime      = 0x829A
+	tagFNumber           = 0x829D
+	tagISO               = 0x8827
+	tagDateTimeOriginal  = 0x9003
+	tagDateTimeDigitized = 0x9004
+	tagOffsetTime        = 0x9010
+	tagOffsetOriginal    = 0x9011
+	tagOffsetDigitized   = 0x9012
+	tagFocalLength       = 0x920A
+	tagLensModel         = 0xA434
+	tagInteropIFD        = 0xA005
+
+	tagGPSLatitudeRef  = 1
+	tagGPSLatitude     = 2
+	tagGPSLongitudeRef = 3
+	tagGPSLongitude    = 4
+	tagGPSAltitudeRef  = 5
+	tagGPSAltitude     = 6
+	tagGPSTimeStamp    = 7
+	tagGPSDateStamp    = 29
+)
+
+// typeSize is the byte size of one value of each TIFF field type.
+var typeSize = [...]int{1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8}
+
+// field is one IFD entry with its value bytes resolved.
+type field struct {
+	typ   uint16
+	count int
+	raw   []byte
+}
+
+// ifd maps the tags of one image file directory to their fields.
+type ifd map[uint16]field
+
+// tiffBlock is a parsed EXIF block: IFD0, the EXIF, GPS and interoperability
+// IFDs it points to and the thumbnail IFD1 that follows it, any of which
+// but IFD0 may be nil.
+type tiffBlock struct {
+	order                          binary.ByteOrder
+	ifd0, exif, gps, interop, ifd1 ifd
+	thumb                          []byte // IFD1's JPEG thumbnail
+	xmp, iptc                      []byte
+}
+
+var errMalformed = errors.New("malformed EXIF")
+
+// parseTIFF reads the TIFF structure of an EXIF block.  IFD0 must parse;
+// a broken EXIF or GPS IFD is skipped.
+func parseTIFF(b []byte) (*tiffBlock, error) {
+	if len(b) < 8 {
+		return nil, apperrors.New(apperrors.CategoryDecode, "metadata.read", fmt.Errorf("%w: %d-byte TIFF header", errMalformed, len(b)))
+	}
+	t := &tiffBlock{}
+	switch string(b[:2]) {
+	case "II":
+		t.order = binary.LittleEndian
+	case "MM":
+		t.order = binary.BigEndian
+	default:
+		return nil, apperrors.New(apperrors.CategoryDecode, "metadata.read", fmt.Errorf("%w: byte order %q", errMalformed, b[:2]))
+	}
+	if t.order.Uint16(b[2:]) != 42 {
+		return nil, apperrors.New(apperrors.CategoryDecode, "metadata.read", fmt.Errorf("%w: bad TIFF magic", errMalformed))
+	}
+
+	seen := map[uint32]bool{}
+	var (
+		next uint32
+		err  error
+	)
+	if t.ifd0, next, err = t.readIFD(b, t.order.Uint32(b[4:]), seen); err != nil {
+		return nil, apperrors.New(apperrors.CategoryDecode, "metadata.read", err)
+	}
+	if next != 0 {
+		t.ifd1, _, _ = t.readIFD(b, next, seen)
+	}
+	if off, ok := t.integer(t.ifd0, tagExifIFD); ok {
+		t.exif, _, _ = t.readIFD(b, uint32(off), seen)
+	}
+	if off, ok := t.integer(t.ifd0, tagGPSIFD); ok {
+		t.gps, _, _ = t.readIFD(b, uint32(off), seen)
+	}
+	if off, ok := t.integer(t.exif, tagInteropIFD); ok {
+		t.interop, _, _ = t.readIFD(b, uint32(off), seen)
+	}
+	off, _ := t.integer(t.ifd1, tagThumbOffset)
+	n, _ := t.integer(t.ifd1, tagThumbLength)
+	if off > 0 && n > 0 && off+n <= uint64(len(b)) {
+		t.thumb = b[off : off+n]
+	}
+	t.xmp = t.ifd0[tagXMP].raw
+	t.iptc = t.ifd0[tagIPTC].raw
+	return t, nil
+}
+
+// readIFD parses the directory at off and returns it with the offset of
+// the next one, refusing offsets already visited so that a pointer loop
+// cannot recurse.
+func (t *tiffBlock) readIFD(b []byte, off uint32, seen map[uint32]bool) (ifd, uint32, error) {
+	if seen[off] {
+		return nil, 0, fmt.Errorf("%w: IFD at %d visited twice", errMalformed, off)
+	}
+	seen[off] = true
+	if off < 8 || int64(off)+2 > int64(len(b)) {
+		return nil, 0, fmt.Errorf("%w: IFD offset %d outside %d bytes", errMalformed, off, len(b))
+	}
+	n := int(t.order.Uint16(b[off:]))
+	start := int(off) + 2
+	if start+12*n > len(b) {
+		return nil, 0, fmt.Errorf("%w: %d IFD entries overrun the block", errMalformed, n)
+	}
+	dir := make(ifd, n)
+	for i := 0; i < n; i++ {
+		e := b[start+12*i:]
+		typ := t.order.Uint16(e[2:])
+		count := int64(t.order.Uint32(e[4:]))
+		if int(typ) >= len(typeSize) || typeSize[typ] == 0 {
+			continue // unknown type: skip, as TIFF readers must
+		}
+		size := count * int64(typeSize[typ])
+		var raw []byte
+		if size <= 4 {
+			raw = e[8 : 8+size]
+		} else {
+			p := int64(t.order.Uint32(e[8:]))
+			if p+size > int64(len(b)) {
+				continue
+			}
+			raw = b[p : p+size]
+		}
+		dir[t.order.Uint16(e)] = field{typ: typ, count: int(count), raw: raw}
+	}
+	var next uint32
+	if end := start + 12*n; end+4 <= len(b) {
+		next = t.order.Uint32(b[end:])
+	}
+	return dir, next, nil
+}
+
+// ── Values ────────────────────────────────────────────────────────────────────
+
+func (t *tiffBlock) ascii(d ifd, tag uint16) string {
+	f, ok := d[tag]
+	if !ok || (f.typ != 2 && f.typ != 7 && f.typ != 1) {
+		return ""
+	}
+	s, _, _ := bytes.Cut(f.raw, []byte{0})
+	return strings.TrimSpace(string(s))
+}
+
+func (t *tiffBlock) integer(d ifd, tag uint16) (uint64, bool) {
+	f, ok := d[tag]
+	if !ok || f.count == 0 {
+		return 0, false
+	}
+	switch f.typ {
+	case 1, 7:
+		return uint64(f.raw[0]), true
+	case 3:
+		return uint64(t.order.Uint16(f.raw)), true
+	case 4:
+		return uint64(t.order.Uint32(f.raw)), true
+	}
+	return 0, false
+}
+
+// rationals returns the values of a RATIONAL or SRATIONAL field.  A zero
+// denominator yields 0 rather than an infinity.
+func (t *tiffBlock) rationals(d ifd, tag uint16) []float64 {
+	f, ok := d[tag]
+	if !ok || (f.typ != 5 && f.typ != 10) {
+		return nil
+	}
+	out := make([]float64, f.count)
+	for i := range out {
+		num, den := t.order.Uint32(f.raw[8*i:]), t.order.Uint32(f.raw[8*i+4:])
+		if den == 0 {
+			continue
+		}
+		if f.typ == 10 {
+			out[i] = float64(int32(num)) / float64(int32(den))
+		} else {
+			out[i] = float64(num) / float64(den)
+		}
+	}
+	return out
+}
+
+func (t *tiffBlock) rational(d ifd, tag uint16) float64 {
+	if r := t.rationals(d, tag); len(r) > 0 {
+		return r[0]
+	}
+	return 0
+}
+
+// stamp parses an EXIF "YYYY:MM:DD HH:MM:SS" stamp in the zone of the
+// offset tag, or UTC without one.
+func (t *tiffBlock) stamp(d ifd, tag, offsetTag uint16) time.Time {
+	s := t.ascii(d, tag)
+	if s == "" {
+		return time.Time{}
+	}
+	if off := t.ascii(t.exif, offsetTag); off != "" {
+		if v, err := time.Parse("2006:01:02 15:04:05-07:00", s+off); err == nil {
+			return v
+		}
+	}
+	v, _ := time.Parse("2006:01:02 15:04:05", s)
+	return v
+}
+
+// fill copies the tags Read understands into d.
+func (t *tiffBlock) fill(d *core.Details) {
+	if o, ok := t.integer(t.ifd0, tagOrientation); ok && o >= 1 && o <= 8 {
+		d.Orientation = int(o)
+	}
+	d.Make = t.ascii(t.ifd0, tagMake)
+	d.Model = t.ascii(t.ifd0, tagModel)
+	d.Software = t.ascii(t.ifd0, tagSoftware)
+	d.Artist = t.ascii(t.ifd0, tagArtist)
+	d.Copyright = t.ascii(t.ifd0, tagCopyright)
+	d.DateTime = t.stamp(t.ifd0, tagDateTime, tagOffsetTime)
+
+	d.LensModel = t.ascii(t.exif, tagLensModel)
+	d.DateTimeOriginal = t.stamp(t.exif, tagDateTimeOriginal, tagOffsetOriginal)
+	d.DateTimeDigitized = t.stamp(t.exif, tagDateTimeDigitized, tagOffsetDigitized)
+	d.ExposureTime = t.rational(t.exif, tagExposureTime)
+	d.FNumber = t.rational(t.exif, tagFNumber)
+	d.FocalLength = t.rational(t.exif, tagFocalLength)
+	if iso, ok := t.integer(t.exif, tagISO); ok {
+		d.ISO = int(iso)
+	}
+	d.GPS = t.location()
+}
+
+// location converts the GPS IFD, returning nil when it has no position.
+func (t *tiffBlock) location() *core.GPS {
+	lat, lon := t.rationals(t.gps, tagGPSLatitude), t.rationals(t.gps, tagGPSLongitude)
+	if len(lat) != 3 || len(lon) != 3 {
+		return nil
+	}
+	g := &core.GPS{
+		Latitude:  lat[0] + lat[1]/60 + lat[2]/3600,
+		Longitude: lon[0] + lon[1]/60 + lon[2]/3600,
+		Altitude:  t.rational(t.gps, tagGPSAltitude),
+	}
+	if t.ascii(t.gps, tagGPSLatitudeRef) == "S" {
+		g.Latitude = -g.Latitude
+	}
+	if t.ascii(t.gps, tagGPSLongitudeRef) == "W" {
+		g.Longitude = -g.Longitude
+	}
+	if ref, ok := t.integer(t.gps, tagGPSAltitudeRef); ok && ref == 1 {
+		g.Altitude = -g.Altitude
+	}
+	if day, err := time.Parse("2006:01:02", t.ascii(t.gps, tagGPSDateStamp)); err == nil {
+		if hms := t.rationals(t.gps, tagGPSTimeStamp); len(hms) == 3 {
+			secs := hms[0]*3600 + hms[1]*60 + hms[2]
+			day = day.Add(time.Duration(math.Round(secs * float64(time.Second))))
+		}
+		g.Time = day
+	}
+	return g
+}
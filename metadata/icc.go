@@ -0,0 +1,198 @@
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"slices"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── ICC profiles ──────────────────────────────────────────────────────────────
+
+// maxICC bounds the size of a compressed ICC profile once inflated.
+const maxICC = 16 << 20
+
+// ICC returns the ICC profile embedded in the encoded image data, or nil
+// when there is none or it is incomplete: the APP2 chunks of a JPEG, the
+// iCCP chunk of a PNG, the ICCP chunk of a WebP or the InterColorProfile
+// tag of a TIFF.
+func ICC(data []byte) []byte {
+	switch {
+	case len(data) > 3 && data[0] == 0xFF && data[1] == 0xD8:
+		return jpegICC(data)
+	case len(data) > 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		return pngICC(data)
+	case len(data) > 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		for pos := 12; pos+8 <= len(data); {
+			n := int(binary.LittleEndian.Uint32(data[pos+4:]))
+			if n < 0 || pos+8+n > len(data) {
+				break
+			}
+			if string(data[pos:pos+4]) == "ICCP" {
+				return data[pos+8 : pos+8+n]
+			}
+			pos += 8 + (n+1)&^1
+		}
+	case len(data) > 8 && (string(data[:4]) == "II*\x00" || string(data[:4]) == "MM\x00*"):
+		if t, err := parseTIFF(data); err == nil {
+			return t.ifd0[tagICC].raw
+		}
+	}
+	return nil
+}
+
+// jpegICC joins the profile's APP2 chunks, each numbered 1 to count.
+func jpegICC(data []byte) []byte {
+	var chunks [][]byte
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		m := data[pos+1]
+		if m == 0xDA || m == 0xD9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			break
+		}
+		seg := data[pos+4 : pos+2+n]
+		if m == 0xE2 && bytes.HasPrefix(seg, iccHeader) && len(seg) > len(iccHeader)+2 {
+			seq, count := int(seg[len(iccHeader)]), int(seg[len(iccHeader)+1])
+			if chunks == nil {
+				chunks = make([][]byte, count)
+			}
+			if seq < 1 || seq > len(chunks) || count != len(chunks) {
+				return nil
+			}
+			chunks[seq-1] = seg[len(iccHeader)+2:]
+		}
+		pos += 2 + n
+	}
+	if len(chunks) == 0 || slices.ContainsFunc(chunks, func(c []byte) bool { return c == nil }) {
+		return nil
+	}
+	return bytes.Join(chunks, nil)
+}
+
+// pngICC inflates the profile of the iCCP chunk.
+func pngICC(data []byte) []byte {
+	for pos := 8; pos+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if n < 0 || pos+12+n > len(data) || typ == "IDAT" || typ == "IEND" {
+			break
+		}
+		if typ == "iCCP" {
+			// Profile name, compression method, then the zlib stream.
+			_, rest, ok := bytes.Cut(data[pos+8:pos+8+n], []byte{0})
+			if !ok || len(rest) < 1 || rest[0] != 0 {
+				return nil
+			}
+			zr, err := zlib.NewReader(bytes.NewReader(rest[1:]))
+			if err != nil {
+				return nil
+			}
+			defer zr.Close()
+			profile, err := io.ReadAll(io.LimitReader(zr, maxICC))
+			if err != nil {
+				return nil
+			}
+			return profile
+		}
+		pos += 12 + n
+	}
+	return nil
+}
+
+// SetICC returns data with profile embedded in place of any profile it
+// carried, without touching the compressed pixels.  JPEG, PNG and WebP are
+// supported; a PNG loses its sRGB chunk, which the profile supersedes.
+func SetICC(data, profile []byte) ([]byte, error) {
+	if len(profile) == 0 {
+		return data, nil
+	}
+	switch {
+	case len(data) > 3 && data[0] == 0xFF && data[1] == 0xD8:
+		return setJPEGICC(data, profile)
+	case len(data) > 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		return setPNGICC(data, profile)
+	case len(data) > 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		chunks, err := webpChunks(data, "metadata.set_icc")
+		if err != nil {
+			return nil, err
+		}
+		chunks[0].payload[0] |= webpICC
+		// ICCP directly follows VP8X.
+		chunks = slices.DeleteFunc(chunks, func(c chunk) bool { return c.fourcc == "ICCP" })
+		return joinWebP(slices.Insert(chunks, 1, chunk{"ICCP", profile})), nil
+	}
+	return nil, apperrors.New(apperrors.CategoryEncode, "metadata.set_icc",
+		fmt.Errorf("%w: colour profiles can only be embedded in JPEG, PNG and WebP", apperrors.ErrUnsupportedFormat))
+}
+
+func setJPEGICC(data, profile []byte) ([]byte, error) {
+	segs, pos, err := jpegSegments(data, "metadata.set_icc")
+	if err != nil {
+		return nil, err
+	}
+	segs = slices.DeleteFunc(segs, func(s segment) bool {
+		return s.marker == 0xE2 && bytes.HasPrefix(s.payload, iccHeader)
+	})
+
+	// Each APP2 holds the header, a sequence number and a count.
+	const size = 0xFFFF - 2 - 14
+	count := (len(profile) + size - 1) / size
+	if count > 255 {
+		return nil, apperrors.New(apperrors.CategoryEncode, "metadata.set_icc",
+			fmt.Errorf("%d-byte colour profile is too large for JPEG", len(profile)))
+	}
+	app2 := make([]segment, 0, count)
+	for i := range count {
+		part := profile[i*size : min((i+1)*size, len(profile))]
+		payload := append(slices.Clip(iccHeader), byte(i+1), byte(count))
+		app2 = append(app2, segment{0xE2, append(payload, part...)})
+	}
+
+	// The profile goes after JFIF, EXIF and XMP.
+	at := 0
+	for at < len(segs) && (segs[at].marker == 0xE0 || segs[at].marker == 0xE1) {
+		at++
+	}
+	return joinJPEG(data, slices.Insert(segs, at, app2...), pos, "metadata.set_icc")
+}
+
+func setPNGICC(data, profile []byte) ([]byte, error) {
+	var z bytes.Buffer
+	zw := zlib.NewWriter(&z)
+	zw.Write(profile)
+	zw.Close()
+	iccp := append([]byte("ICC Profile\x00\x00"), z.Bytes()...)
+
+	out := make([]byte, 8, len(data)+len(iccp)+12)
+	copy(out, data[:8])
+	for pos := 8; pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.set_icc",
+				fmt.Errorf("%w: truncated PNG chunk", errMalformed))
+		}
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n < 0 || pos+12+n > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.set_icc",
+				fmt.Errorf("%w: PNG chunk overruns the file", errMalformed))
+		}
+		typ, whole := string(data[pos+4:pos+8]), data[pos:pos+12+n]
+		pos += 12 + n
+		switch typ {
+		case "iCCP", "sRGB":
+			continue
+		}
+		out = append(out, whole...)
+		if typ == "IHDR" {
+			// iCCP must precede PLTE and IDAT.
+			out = appendChunk(out, "iCCP", iccp)
+		}
+	}
+	return out, nil
+}
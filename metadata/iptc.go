@@ -0,0 +1,62 @@
+package metadata
+
+import (
+	"encoding/binary"
+	"strings"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// ── IPTC-IIM ──────────────────────────────────────────────────────────────────
+
+// parseIPTC reads the application record (2) datasets of an IPTC-IIM
+// stream.  Parsing stops at the first malformed dataset, keeping what was
+// read; nil is returned when no known field was found.
+func parseIPTC(b []byte) *core.IPTC {
+	var (
+		p     core.IPTC
+		found bool
+	)
+	// Extended lengths (high bit set) only occur in binary records, so
+	// they end the walk too.
+	for len(b) >= 5 && b[0] == 0x1C && b[3]&0x80 == 0 {
+		record, dataset := b[1], b[2]
+		n := int(binary.BigEndian.Uint16(b[3:]))
+		if 5+n > len(b) {
+			break
+		}
+		v := strings.TrimSpace(string(b[5 : 5+n]))
+		b = b[5+n:]
+		if record != 2 {
+			continue
+		}
+		var dst *string
+		switch dataset {
+		case 105:
+			dst = &p.Headline
+		case 120:
+			dst = &p.Caption
+		case 25:
+			p.Keywords = append(p.Keywords, v)
+			found = true
+		case 80:
+			dst = &p.Byline
+		case 110:
+			dst = &p.Credit
+		case 116:
+			dst = &p.Copyright
+		case 90:
+			dst = &p.City
+		case 101:
+			dst = &p.Country
+		}
+		if dst != nil {
+			*dst = v
+			found = true
+		}
+	}
+	if !found {
+		return nil
+	}
+	return &p
+}
@@ -0,0 +1,237 @@
+// Package metadata reads the EXIF, XMP and IPTC blocks of JPEG, PNG, WebP
+// and TIFF files into a core.Details, for either backend: the pipeline's
+// decode step calls Read on every input, so Meta.Details is filled whether
+// libvips or the standard library decoded the pixels.
+package metadata
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// maxXMP bounds the size of a compressed XMP packet once inflated.
+const maxXMP = 1 << 20
+
+// Read returns the metadata of the encoded image data, or nil when it
+// carries none or its container is not one Read knows.  A malformed EXIF
+// block is an error; XMP and IPTC are taken as found.
+func Read(data []byte) (*core.Details, error) {
+	var exif, xmp, iptc []byte
+	switch {
+	case len(data) > 3 && data[0] == 0xFF && data[1] == 0xD8:
+		exif, xmp, iptc = jpegBlocks(data)
+	case len(data) > 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		exif, xmp = pngBlocks(data)
+	case len(data) > 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		exif, xmp = webpBlocks(data)
+	case len(data) > 8 && (string(data[:4]) == "II*\x00" || string(data[:4]) == "MM\x00*"):
+		exif = data
+	default:
+		return nil, nil
+	}
+	if exif == nil && xmp == nil && iptc == nil {
+		return nil, nil
+	}
+
+	d := &core.Details{XMP: string(xmp)}
+	if exif != nil {
+		t, err := parseTIFF(exif)
+		if err != nil {
+			return nil, err
+		}
+		t.fill(d)
+		if xmp == nil && t.xmp != nil {
+			d.XMP = string(t.xmp)
+		}
+		if iptc == nil {
+			iptc = t.iptc
+		}
+	}
+	if iptc != nil {
+		d.IPTC = parseIPTC(iptc)
+	}
+	return d, nil
+}
+
+// Flatten renders d as the flat tag map of Metadata.EXIF, keyed by EXIF
+// tag name, for callers that only handle strings.  Absent fields are left
+// out.
+func Flatten(d *core.Details) map[string]string {
+	m := map[string]string{}
+	set := func(k, v string) {
+		if v != "" {
+			m[k] = v
+		}
+	}
+	num := func(k string, v float64) {
+		if v != 0 {
+			m[k] = strconv.FormatFloat(v, 'g', -1, 64)
+		}
+	}
+	date := func(k string, t time.Time) {
+		if !t.IsZero() {
+			m[k] = t.Format("2006:01:02 15:04:05")
+		}
+	}
+	if d.Orientation > 0 {
+		m["Orientation"] = strconv.Itoa(d.Orientation)
+	}
+	set("Make", d.Make)
+	set("Model", d.Model)
+	set("LensModel", d.LensModel)
+	set("Software", d.Software)
+	set("Artist", d.Artist)
+	set("Copyright", d.Copyright)
+	date("DateTime", d.DateTime)
+	date("DateTimeOriginal", d.DateTimeOriginal)
+	date("DateTimeDigitized", d.DateTimeDigitized)
+	num("ExposureTime", d.ExposureTime)
+	num("FNumber", d.FNumber)
+	if d.ISO > 0 {
+		m["ISOSpeedRatings"] = strconv.Itoa(d.ISO)
+	}
+	num("FocalLength", d.FocalLength)
+	if g := d.GPS; g != nil {
+		num("GPSLatitude", g.Latitude)
+		num("GPSLongitude", g.Longitude)
+		num("GPSAltitude", g.Altitude)
+	}
+	return m
+}
+
+// ── Containers ────────────────────────────────────────────────────────────────
+
+var (
+	exifHeader = []byte("Exif\x00\x00")
+	xmpHeader  = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	psHeader   = []byte("Photoshop 3.0\x00")
+)
+
+// jpegBlocks returns the TIFF structure of the APP1 EXIF segment, the APP1
+// XMP packet and the IPTC record of the APP13 Photoshop segment.
+func jpegBlocks(data []byte) (exif, xmp, iptc []byte) {
+	for pos := 2; pos+4 <= len(data) && data[pos] == 0xFF; {
+		m := data[pos+1]
+		if m == 0xDA || m == 0xD9 {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			break
+		}
+		seg := data[pos+4 : pos+2+n]
+		switch {
+		case m == 0xE1 && bytes.HasPrefix(seg, exifHeader) && exif == nil:
+			exif = seg[len(exifHeader):]
+		case m == 0xE1 && bytes.HasPrefix(seg, xmpHeader) && xmp == nil:
+			xmp = seg[len(xmpHeader):]
+		case m == 0xED && bytes.HasPrefix(seg, psHeader) && iptc == nil:
+			iptc = photoshopIPTC(seg[len(psHeader):])
+		}
+		pos += 2 + n
+	}
+	return exif, xmp, iptc
+}
+
+// photoshopIPTC finds the IPTC-IIM resource (0x0404) among Photoshop image
+// resource blocks.
+func photoshopIPTC(res []byte) []byte {
+	for len(res) >= 12 && string(res[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(res[4:])
+		nameLen := int(res[6])
+		off := 6 + (nameLen+2)&^1 // Pascal name padded to even length
+		if off+4 > len(res) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(res[off:]))
+		off += 4
+		if size < 0 || off+size > len(res) {
+			return nil
+		}
+		if id == 0x0404 {
+			return res[off : off+size]
+		}
+		res = res[off+(size+1)&^1:]
+	}
+	return nil
+}
+
+// pngBlocks returns the eXIf chunk and the XMP packet of the
+// XML:com.adobe.xmp iTXt chunk.
+func pngBlocks(data []byte) (exif, xmp []byte) {
+	for pos := 8; pos+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if n < 0 || pos+12+n > len(data) || typ == "IDAT" || typ == "IEND" {
+			break
+		}
+		chunk := data[pos+8 : pos+8+n]
+		switch typ {
+		case "eXIf":
+			exif = chunk
+		case "iTXt":
+			if x := pngXMP(chunk); x != nil {
+				xmp = x
+			}
+		}
+		pos += 12 + n
+	}
+	return exif, xmp
+}
+
+// pngXMP returns the text of an iTXt chunk holding XMP, inflating it when
+// compressed.
+func pngXMP(chunk []byte) []byte {
+	const keyword = "XML:com.adobe.xmp\x00"
+	if !bytes.HasPrefix(chunk, []byte(keyword)) || len(chunk) < len(keyword)+2 {
+		return nil
+	}
+	compressed := chunk[len(keyword)] == 1
+	rest := chunk[len(keyword)+2:]
+	for range 2 { // language tag and translated keyword
+		i := bytes.IndexByte(rest, 0)
+		if i < 0 {
+			return nil
+		}
+		rest = rest[i+1:]
+	}
+	if !compressed {
+		return rest
+	}
+	zr, err := zlib.NewReader(bytes.NewReader(rest))
+	if err != nil {
+		return nil
+	}
+	defer zr.Close()
+	text, err := io.ReadAll(io.LimitReader(zr, maxXMP))
+	if err != nil {
+		return nil
+	}
+	return text
+}
+
+// webpBlocks returns the EXIF and XMP chunks of an extended WebP file.
+func webpBlocks(data []byte) (exif, xmp []byte) {
+	for pos := 12; pos+8 <= len(data); {
+		n := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if n < 0 || pos+8+n > len(data) {
+			break
+		}
+		chunk := data[pos+8 : pos+8+n]
+		switch string(data[pos : pos+4]) {
+		case "EXIF":
+			// Some writers keep the JPEG APP1 prefix.
+			exif = bytes.TrimPrefix(chunk, exifHeader)
+		case "XMP ":
+			xmp = chunk
+		}
+		pos += 8 + (n+1)&^1
+	}
+	return exif, xmp
+}
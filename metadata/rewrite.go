@@ -0,0 +1,389 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"maps"
+	"slices"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Rewrite ───────────────────────────────────────────────────────────────────
+
+// Rewrite returns data with its metadata cut down to what p keeps, without
+// touching the compressed pixels.  JPEG and PNG files are rewritten; other
+// formats are returned unchanged, as is data under the zero policy.
+//
+// EXIF blocks are rebuilt from their parsed directories, so removed values
+// do not linger in the file.  Maker notes whose internal offsets are
+// absolute may not survive the move.
+func Rewrite(data []byte, p core.MetadataPolicy) ([]byte, error) {
+	if p.IsZero() {
+		return data, nil
+	}
+	switch {
+	case len(data) > 3 && data[0] == 0xFF && data[1] == 0xD8:
+		return rewriteJPEG(data, p)
+	case len(data) > 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		return rewritePNG(data, p)
+	}
+	return data, nil
+}
+
+// Filter returns the part of d that p keeps, or nil when nothing is left.
+func Filter(d *core.Details, p core.MetadataPolicy) *core.Details {
+	if d == nil || p.IsZero() {
+		return d
+	}
+	if !p.Strip {
+		c := d.Clone()
+		if p.RemoveGPS {
+			c.GPS = nil
+			if hasLocation([]byte(c.XMP)) {
+				c.XMP = ""
+			}
+		}
+		return c
+	}
+	var c core.Details
+	if p.KeepOrientation {
+		c.Orientation = d.Orientation
+	}
+	if p.KeepCopyright {
+		c.Artist, c.Copyright = d.Artist, d.Copyright
+		if i := d.IPTC; i != nil && (i.Byline != "" || i.Credit != "" || i.Copyright != "") {
+			c.IPTC = &core.IPTC{Byline: i.Byline, Credit: i.Credit, Copyright: i.Copyright}
+		}
+	}
+	if c.Orientation == 0 && c.Artist == "" && c.Copyright == "" && c.IPTC == nil {
+		return nil
+	}
+	return &c
+}
+
+// hasLocation reports whether an XMP packet records GPS coordinates.
+func hasLocation(xmp []byte) bool {
+	return bytes.Contains(xmp, []byte("exif:GPSLatitude")) || bytes.Contains(xmp, []byte("exif:GPSLongitude"))
+}
+
+// keptIPTC is the IPTC datasets KeepCopyright retains: by-line, credit and
+// copyright notice.
+var keptIPTC = map[byte]bool{80: true, 110: true, 116: true}
+
+// ── JPEG ──────────────────────────────────────────────────────────────────────
+
+var (
+	iccHeader    = []byte("ICC_PROFILE\x00")
+	xmpExtHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+func rewriteJPEG(data []byte, p core.MetadataPolicy) ([]byte, error) {
+	out := make([]byte, 2, len(data))
+	copy(out, data[:2])
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF {
+		m := data[pos+1]
+		if m == 0xDA {
+			break
+		}
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.rewrite",
+				fmt.Errorf("%w: JPEG segment %#02x overruns the file", errMalformed, m))
+		}
+		seg := data[pos+4 : pos+2+n]
+		pos += 2 + n
+
+		var payload []byte // replacement for seg; nil drops it
+		switch {
+		case m == 0xE1 && bytes.HasPrefix(seg, exifHeader):
+			tiff, err := rewriteTIFF(seg[len(exifHeader):], p)
+			if err != nil {
+				return nil, err
+			}
+			if tiff != nil {
+				payload = append(slices.Clip(exifHeader), tiff...)
+			}
+		case m == 0xE1 && (bytes.HasPrefix(seg, xmpHeader) || bytes.HasPrefix(seg, xmpExtHeader)):
+			if !p.Strip && !(p.RemoveGPS && hasLocation(seg)) {
+				payload = seg
+			}
+		case m == 0xED && bytes.HasPrefix(seg, psHeader):
+			switch {
+			case !p.Strip:
+				payload = seg
+			case p.KeepCopyright:
+				if iim := keepIPTC(photoshopIPTC(seg[len(psHeader):])); iim != nil {
+					payload = photoshopResource(iim)
+				}
+			}
+		case m == 0xE2 && bytes.HasPrefix(seg, iccHeader):
+			if !p.Strip || p.KeepColorProfile {
+				payload = seg
+			}
+		case m == 0xE0 || m == 0xEE:
+			payload = seg // JFIF and Adobe colour transform: needed to decode
+		case m >= 0xE1 && m <= 0xEF || m == 0xFE:
+			if !p.Strip {
+				payload = seg
+			}
+		default:
+			payload = seg
+		}
+		if payload == nil {
+			continue
+		}
+		if len(payload) > 0xFFFF-2 {
+			return nil, apperrors.New(apperrors.CategoryEncode, "metadata.rewrite",
+				fmt.Errorf("rewritten JPEG segment %#02x is %d bytes", m, len(payload)))
+		}
+		out = append(out, 0xFF, m)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(payload)+2))
+		out = append(out, payload...)
+	}
+	return append(out, data[pos:]...), nil
+}
+
+// keepIPTC returns the IIM datasets of record 2 that KeepCopyright keeps,
+// or nil when there are none.
+func keepIPTC(iim []byte) []byte {
+	var out []byte
+	for len(iim) >= 5 && iim[0] == 0x1C && iim[3]&0x80 == 0 {
+		n := 5 + int(binary.BigEndian.Uint16(iim[3:]))
+		if n > len(iim) {
+			break
+		}
+		if iim[1] == 2 && keptIPTC[iim[2]] {
+			out = append(out, iim[:n]...)
+		}
+		iim = iim[n:]
+	}
+	return out
+}
+
+// photoshopResource wraps an IIM stream as the APP13 payload holding a
+// single, unnamed 0x0404 image resource.
+func photoshopResource(iim []byte) []byte {
+	out := append(slices.Clip(psHeader), "8BIM\x04\x04\x00\x00"...)
+	out = binary.BigEndian.AppendUint32(out, uint32(len(iim)))
+	out = append(out, iim...)
+	if len(iim)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
+
+// ── PNG ───────────────────────────────────────────────────────────────────────
+
+// keptText is the PNG text keywords KeepCopyright retains.
+var keptText = map[string]bool{"Author": true, "Copyright": true}
+
+func rewritePNG(data []byte, p core.MetadataPolicy) ([]byte, error) {
+	out := make([]byte, 8, len(data))
+	copy(out, data[:8])
+	for pos := 8; pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.rewrite",
+				fmt.Errorf("%w: truncated PNG chunk", errMalformed))
+		}
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n < 0 || pos+12+n > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.rewrite",
+				fmt.Errorf("%w: PNG chunk overruns the file", errMalformed))
+		}
+		typ := string(data[pos+4 : pos+8])
+		chunk := data[pos+8 : pos+8+n]
+		whole := data[pos : pos+12+n]
+		pos += 12 + n
+
+		switch typ {
+		case "eXIf":
+			tiff, err := rewriteTIFF(chunk, p)
+			if err != nil {
+				return nil, err
+			}
+			if tiff != nil {
+				out = appendChunk(out, typ, tiff)
+			}
+			continue
+		case "iCCP":
+			if p.Strip && !p.KeepColorProfile {
+				continue
+			}
+		case "tEXt", "zTXt", "iTXt":
+			keyword, _, _ := bytes.Cut(chunk, []byte{0})
+			switch {
+			case p.Strip && !(p.KeepCopyright && keptText[string(keyword)]):
+				continue
+			case p.RemoveGPS && typ == "iTXt" && string(keyword) == "XML:com.adobe.xmp" && hasLocation(pngXMP(chunk)):
+				continue
+			}
+		case "tIME":
+			if p.Strip {
+				continue
+			}
+		}
+		out = append(out, whole...)
+	}
+	return out, nil
+}
+
+func appendChunk(out []byte, typ string, data []byte) []byte {
+	out = binary.BigEndian.AppendUint32(out, uint32(len(data)))
+	start := len(out)
+	out = append(out, typ...)
+	out = append(out, data...)
+	return binary.BigEndian.AppendUint32(out, crc32.ChecksumIEEE(out[start:]))
+}
+
+// ── TIFF ──────────────────────────────────────────────────────────────────────
+
+// dir is an IFD to write: its own fields, the directories its pointer tags
+// lead to, the IFD chained after it and, for IFD1, the JPEG thumbnail.
+type dir struct {
+	fields ifd
+	subs   map[uint16]*dir
+	next   *dir
+	thumb  []byte
+}
+
+// pointerTags are the tags whose values are offsets; they are never copied
+// verbatim, only written for the directories dir.subs and dir.thumb hold.
+var pointerTags = map[uint16]bool{
+	tagExifIFD: true, tagGPSIFD: true, tagInteropIFD: true, tagSubIFDs: true,
+	tagThumbOffset: true, tagThumbLength: true,
+}
+
+// rewriteTIFF rebuilds an EXIF block with what p keeps, returning nil when
+// nothing is left.
+func rewriteTIFF(b []byte, p core.MetadataPolicy) ([]byte, error) {
+	t, err := parseTIFF(b)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "metadata.rewrite", err)
+	}
+	if !p.Strip {
+		return writeTIFF(t.order, t.tree(p)), nil
+	}
+	root := &dir{fields: ifd{}}
+	keep := map[uint16]bool{tagOrientation: p.KeepOrientation, tagArtist: p.KeepCopyright, tagCopyright: p.KeepCopyright}
+	for tag, f := range t.ifd0 {
+		if keep[tag] {
+			root.fields[tag] = f
+		}
+	}
+	if len(root.fields) == 0 {
+		return nil, nil
+	}
+	return writeTIFF(t.order, root), nil
+}
+
+// tree returns t's directories, less those p's Remove fields name, ready
+// for writeTIFF.
+func (t *tiffBlock) tree(p core.MetadataPolicy) *dir {
+	fields := func(d ifd) ifd {
+		out := make(ifd, len(d))
+		for tag, f := range d {
+			if !pointerTags[tag] {
+				out[tag] = f
+			}
+		}
+		return out
+	}
+
+	root := &dir{fields: fields(t.ifd0), subs: map[uint16]*dir{}}
+	if t.exif != nil {
+		exif := &dir{fields: fields(t.exif)}
+		if t.interop != nil {
+			exif.subs = map[uint16]*dir{tagInteropIFD: {fields: fields(t.interop)}}
+		}
+		root.subs[tagExifIFD] = exif
+	}
+	if t.gps != nil && !p.RemoveGPS {
+		root.subs[tagGPSIFD] = &dir{fields: fields(t.gps)}
+	}
+	// Uncompressed thumbnails point into the file with strip offsets,
+	// which are not rewritten; they are dropped with their IFD.
+	if t.ifd1 != nil && !p.RemoveThumbnails {
+		if _, strips := t.ifd1[tagStrips]; !strips {
+			root.next = &dir{fields: fields(t.ifd1), thumb: t.thumb}
+		}
+	}
+	return root
+}
+
+// writeTIFF serialises root and the directories it leads to.
+func writeTIFF(order binary.ByteOrder, root *dir) []byte {
+	out := []byte("II*\x00\x08\x00\x00\x00")
+	if order == binary.BigEndian {
+		out = []byte("MM\x00*\x00\x00\x00\x08")
+	}
+	return writeDir(order, out, root)
+}
+
+// writeDir appends d at the end of out, its values and the directories it
+// points to after it, and returns the extended buffer.  Pointer entries
+// are patched once their targets are placed.
+func writeDir(order binary.ByteOrder, out []byte, d *dir) []byte {
+	tags := slices.Collect(maps.Keys(d.fields))
+	for tag := range d.subs {
+		tags = append(tags, tag)
+	}
+	if d.thumb != nil {
+		tags = append(tags, tagThumbOffset, tagThumbLength)
+	}
+	slices.Sort(tags)
+
+	start := len(out)
+	entry := func(i int) int { return start + 2 + 12*i }
+	out = append(out, make([]byte, 2+12*len(tags)+4)...)
+	order.PutUint16(out[start:], uint16(len(tags)))
+	for i, tag := range tags {
+		e := entry(i)
+		order.PutUint16(out[e:], tag)
+		f, ok := d.fields[tag]
+		if !ok { // patched below
+			order.PutUint16(out[e+2:], 4)
+			order.PutUint32(out[e+4:], 1)
+			continue
+		}
+		order.PutUint16(out[e+2:], f.typ)
+		order.PutUint32(out[e+4:], uint32(f.count))
+		if len(f.raw) <= 4 {
+			copy(out[e+8:e+12], f.raw)
+			continue
+		}
+		order.PutUint32(out[e+8:], uint32(len(out)))
+		out = align(append(out, f.raw...))
+	}
+
+	for i, tag := range tags {
+		e := entry(i)
+		switch {
+		case d.subs[tag] != nil:
+			order.PutUint32(out[e+8:], uint32(len(out)))
+			out = align(writeDir(order, out, d.subs[tag]))
+		case tag == tagThumbOffset && d.thumb != nil:
+			order.PutUint32(out[e+8:], uint32(len(out)))
+			out = align(append(out, d.thumb...))
+		case tag == tagThumbLength && d.thumb != nil:
+			order.PutUint32(out[e+8:], uint32(len(d.thumb)))
+		}
+	}
+	if d.next != nil {
+		order.PutUint32(out[entry(len(tags)):], uint32(len(out)))
+		out = writeDir(order, out, d.next)
+	}
+	return out
+}
+
+// align pads out to the word boundary TIFF offsets must fall on.
+func align(out []byte) []byte {
+	if len(out)%2 == 1 {
+		out = append(out, 0)
+	}
+	return out
+}
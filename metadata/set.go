@@ -0,0 +1,326 @@
+package metadata
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"slices"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Set ───────────────────────────────────────────────────────────────────────
+
+// Set returns data with tags written into its metadata, without touching
+// the compressed pixels: the EXIF block is rebuilt with the given Artist,
+// Copyright and Software, keeping its other tags, and XMP replaces the
+// file's packet.  JPEG, PNG and WebP are supported; a lossy or lossless
+// WebP is promoted to the extended format to hold the chunks.
+func Set(data []byte, tags core.MetadataTags) ([]byte, error) {
+	if tags.IsZero() {
+		return data, nil
+	}
+	switch {
+	case len(data) > 3 && data[0] == 0xFF && data[1] == 0xD8:
+		return setJPEG(data, tags)
+	case len(data) > 8 && string(data[:8]) == "\x89PNG\r\n\x1a\n":
+		return setPNG(data, tags)
+	case len(data) > 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP":
+		return setWebP(data, tags)
+	}
+	return nil, apperrors.New(apperrors.CategoryEncode, "metadata.set",
+		fmt.Errorf("%w: metadata can only be set on JPEG, PNG and WebP", apperrors.ErrUnsupportedFormat))
+}
+
+// writesEXIF reports whether tags has values that go into EXIF.
+func writesEXIF(tags core.MetadataTags) bool {
+	return tags.Artist != "" || tags.Copyright != "" || tags.Software != ""
+}
+
+// setTIFF returns the EXIF block b, which may be nil, with tags' values in
+// IFD0.
+func setTIFF(b []byte, tags core.MetadataTags) ([]byte, error) {
+	var (
+		order binary.ByteOrder = binary.BigEndian
+		root                   = &dir{fields: ifd{}}
+	)
+	if b != nil {
+		t, err := parseTIFF(b)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryDecode, "metadata.set", err)
+		}
+		order, root = t.order, t.tree(core.MetadataPolicy{})
+	}
+	for tag, v := range map[uint16]string{tagArtist: tags.Artist, tagCopyright: tags.Copyright, tagSoftware: tags.Software} {
+		if v != "" {
+			root.fields[tag] = field{typ: 2, count: len(v) + 1, raw: append([]byte(v), 0)}
+		}
+	}
+	return writeTIFF(order, root), nil
+}
+
+// ── JPEG ──────────────────────────────────────────────────────────────────────
+
+type segment struct {
+	marker  byte
+	payload []byte
+}
+
+// jpegSegments splits the header of a JPEG into its marker segments and
+// returns the offset of the scan that follows them.
+func jpegSegments(data []byte, op string) (segs []segment, body int, err error) {
+	pos := 2
+	for pos+4 <= len(data) && data[pos] == 0xFF && data[pos+1] != 0xDA {
+		n := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if n < 2 || pos+2+n > len(data) {
+			return nil, 0, apperrors.New(apperrors.CategoryDecode, op,
+				fmt.Errorf("%w: JPEG segment %#02x overruns the file", errMalformed, data[pos+1]))
+		}
+		segs = append(segs, segment{data[pos+1], data[pos+4 : pos+2+n]})
+		pos += 2 + n
+	}
+	return segs, pos, nil
+}
+
+// joinJPEG writes segs followed by data[body:].
+func joinJPEG(data []byte, segs []segment, body int, op string) ([]byte, error) {
+	size := len(data)
+	for _, s := range segs {
+		size += 4 + len(s.payload)
+	}
+	out := make([]byte, 2, size)
+	copy(out, data[:2])
+	for _, s := range segs {
+		if len(s.payload) > 0xFFFF-2 {
+			return nil, apperrors.New(apperrors.CategoryEncode, op,
+				fmt.Errorf("JPEG segment %#02x is %d bytes, over the 65533 limit", s.marker, len(s.payload)))
+		}
+		out = append(out, 0xFF, s.marker)
+		out = binary.BigEndian.AppendUint16(out, uint16(len(s.payload)+2))
+		out = append(out, s.payload...)
+	}
+	return append(out, data[body:]...), nil
+}
+
+func setJPEG(data []byte, tags core.MetadataTags) ([]byte, error) {
+	segs, pos, err := jpegSegments(data, "metadata.set")
+	if err != nil {
+		return nil, err
+	}
+
+	exifDone, xmpDone := !writesEXIF(tags), tags.XMP == ""
+	for i := 0; i < len(segs); i++ {
+		s := &segs[i]
+		switch {
+		case s.marker == 0xE1 && bytes.HasPrefix(s.payload, exifHeader) && !exifDone:
+			tiff, err := setTIFF(s.payload[len(exifHeader):], tags)
+			if err != nil {
+				return nil, err
+			}
+			s.payload = append(slices.Clip(exifHeader), tiff...)
+			exifDone = true
+		case s.marker == 0xE1 && bytes.HasPrefix(s.payload, xmpHeader) && tags.XMP != "":
+			s.payload = append(slices.Clip(xmpHeader), tags.XMP...)
+			xmpDone = true
+		case s.marker == 0xE1 && bytes.HasPrefix(s.payload, xmpExtHeader) && tags.XMP != "":
+			// Extended XMP belongs to the packet being replaced.
+			segs = slices.Delete(segs, i, i+1)
+			i--
+		}
+	}
+
+	// New segments go after JFIF, where readers look for them.
+	at := 0
+	for at < len(segs) && segs[at].marker == 0xE0 {
+		at++
+	}
+	if !xmpDone {
+		segs = slices.Insert(segs, at, segment{0xE1, append(slices.Clip(xmpHeader), tags.XMP...)})
+	}
+	if !exifDone {
+		tiff, err := setTIFF(nil, tags)
+		if err != nil {
+			return nil, err
+		}
+		segs = slices.Insert(segs, at, segment{0xE1, append(slices.Clip(exifHeader), tiff...)})
+	}
+	return joinJPEG(data, segs, pos, "metadata.set")
+}
+
+// ── PNG ───────────────────────────────────────────────────────────────────────
+
+func setPNG(data []byte, tags core.MetadataTags) ([]byte, error) {
+	var exif []byte
+	if writesEXIF(tags) {
+		old, _ := pngBlocks(data)
+		var err error
+		if exif, err = setTIFF(old, tags); err != nil {
+			return nil, err
+		}
+	}
+	var xmp []byte
+	if tags.XMP != "" {
+		// Uncompressed, with empty language tag and translated keyword.
+		xmp = append([]byte("XML:com.adobe.xmp\x00\x00\x00\x00\x00"), tags.XMP...)
+	}
+
+	out := make([]byte, 8, len(data)+len(exif)+len(xmp)+24)
+	copy(out, data[:8])
+	for pos := 8; pos < len(data); {
+		if pos+12 > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.set",
+				fmt.Errorf("%w: truncated PNG chunk", errMalformed))
+		}
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		if n < 0 || pos+12+n > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, "metadata.set",
+				fmt.Errorf("%w: PNG chunk overruns the file", errMalformed))
+		}
+		typ := string(data[pos+4 : pos+8])
+		chunk, whole := data[pos+8:pos+8+n], data[pos:pos+12+n]
+		pos += 12 + n
+
+		switch {
+		case typ == "eXIf" && exif != nil:
+			continue
+		case typ == "iTXt" && xmp != nil && bytes.HasPrefix(chunk, []byte("XML:com.adobe.xmp\x00")):
+			continue
+		case typ == "IDAT" && (exif != nil || xmp != nil):
+			// eXIf and iTXt go before the image data.
+			if exif != nil {
+				out = appendChunk(out, "eXIf", exif)
+			}
+			if xmp != nil {
+				out = appendChunk(out, "iTXt", xmp)
+			}
+			exif, xmp = nil, nil
+		}
+		out = append(out, whole...)
+	}
+	return out, nil
+}
+
+// ── WebP ──────────────────────────────────────────────────────────────────────
+
+// VP8X feature flags.
+const (
+	webpXMP   = 0x04
+	webpEXIF  = 0x08
+	webpAlpha = 0x10
+	webpICC   = 0x20
+)
+
+type chunk struct {
+	fourcc  string
+	payload []byte
+}
+
+// webpChunks splits a WebP file into its chunks, promoting a simple file
+// to the extended format so the first chunk is always VP8X.  The VP8X
+// payload is a copy the caller may change.
+func webpChunks(data []byte, op string) ([]chunk, error) {
+	var chunks []chunk
+	for pos := 12; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, op,
+				fmt.Errorf("%w: truncated WebP chunk", errMalformed))
+		}
+		n := int(binary.LittleEndian.Uint32(data[pos+4:]))
+		if n < 0 || pos+8+n > len(data) {
+			return nil, apperrors.New(apperrors.CategoryDecode, op,
+				fmt.Errorf("%w: WebP chunk overruns the file", errMalformed))
+		}
+		chunks = append(chunks, chunk{string(data[pos : pos+4]), data[pos+8 : pos+8+n]})
+		pos += 8 + (n+1)&^1
+	}
+	if len(chunks) == 0 {
+		return nil, apperrors.New(apperrors.CategoryDecode, op,
+			fmt.Errorf("%w: WebP without chunks", errMalformed))
+	}
+
+	if chunks[0].fourcc != "VP8X" {
+		w, h, alpha, err := webpCanvas(chunks[0].fourcc, chunks[0].payload)
+		if err != nil {
+			return nil, err
+		}
+		vp8x := make([]byte, 10)
+		if alpha {
+			vp8x[0] = webpAlpha
+		}
+		vp8x[4], vp8x[5], vp8x[6] = byte(w-1), byte((w-1)>>8), byte((w-1)>>16)
+		vp8x[7], vp8x[8], vp8x[9] = byte(h-1), byte((h-1)>>8), byte((h-1)>>16)
+		return slices.Insert(chunks, 0, chunk{"VP8X", vp8x}), nil
+	}
+	if len(chunks[0].payload) < 10 {
+		return nil, apperrors.New(apperrors.CategoryDecode, op,
+			fmt.Errorf("%w: short VP8X chunk", errMalformed))
+	}
+	chunks[0].payload = slices.Clone(chunks[0].payload)
+	return chunks, nil
+}
+
+// joinWebP writes chunks as a RIFF WebP file.
+func joinWebP(chunks []chunk) []byte {
+	size := 12
+	for _, c := range chunks {
+		size += 9 + len(c.payload)
+	}
+	out := make([]byte, 12, size)
+	copy(out, "RIFF\x00\x00\x00\x00WEBP")
+	for _, c := range chunks {
+		out = append(out, c.fourcc...)
+		out = binary.LittleEndian.AppendUint32(out, uint32(len(c.payload)))
+		out = append(out, c.payload...)
+		if len(c.payload)%2 == 1 {
+			out = append(out, 0)
+		}
+	}
+	binary.LittleEndian.PutUint32(out[4:], uint32(len(out)-8))
+	return out
+}
+
+func setWebP(data []byte, tags core.MetadataTags) ([]byte, error) {
+	chunks, err := webpChunks(data, "metadata.set")
+	if err != nil {
+		return nil, err
+	}
+	vp8x := chunks[0].payload
+
+	var exif []byte
+	if writesEXIF(tags) {
+		old, _ := webpBlocks(data)
+		if exif, err = setTIFF(old, tags); err != nil {
+			return nil, err
+		}
+		vp8x[0] |= webpEXIF
+	}
+	if tags.XMP != "" {
+		vp8x[0] |= webpXMP
+	}
+
+	// EXIF and XMP chunks follow the image data.
+	chunks = slices.DeleteFunc(chunks, func(c chunk) bool {
+		return c.fourcc == "EXIF" && exif != nil || c.fourcc == "XMP " && tags.XMP != ""
+	})
+	if exif != nil {
+		chunks = append(chunks, chunk{"EXIF", exif})
+	}
+	if tags.XMP != "" {
+		chunks = append(chunks, chunk{"XMP ", []byte(tags.XMP)})
+	}
+	return joinWebP(chunks), nil
+}
+
+// webpCanvas reads the dimensions of a simple-format WebP bitstream.
+func webpCanvas(fourcc string, b []byte) (w, h int, alpha bool, err error) {
+	switch {
+	case fourcc == "VP8 " && len(b) >= 10 && string(b[3:6]) == "\x9d\x01\x2a":
+		return int(binary.LittleEndian.Uint16(b[6:]) & 0x3FFF), int(binary.LittleEndian.Uint16(b[8:]) & 0x3FFF), false, nil
+	case fourcc == "VP8L" && len(b) >= 5 && b[0] == 0x2F:
+		v := binary.LittleEndian.Uint32(b[1:])
+		return int(v&0x3FFF) + 1, int(v>>14&0x3FFF) + 1, v>>28&1 == 1, nil
+	}
+	return 0, 0, false, apperrors.New(apperrors.CategoryDecode, "metadata.set",
+		fmt.Errorf("%w: unrecognised WebP bitstream %q", errMalformed, fourcc))
+}
@@ -0,0 +1,120 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// PageRange selects pages of a multi-page document, numbered from 1 and
+// inclusive.  Zero From means the first page and zero To the last, so the
+// zero value selects every page.
+type PageRange struct {
+	From, To int
+}
+
+// DefaultPageName is the variant name template ProcessPages uses when none
+// is given.
+const DefaultPageName = "page-{page}"
+
+// ProcessPages runs steps on every page of a multi-page TIFF or PDF source
+// in pages, in parallel, and returns one variant per page.  Variant names
+// come from nameTemplate, where {page} is the page number and {page3} the
+// number zero-padded to three digits ("" selects DefaultPageName).  Primary
+// is the first selected page.
+//
+// Pages are decoded by the registered decoder for the source format, which
+// must implement core.PageDecoder: the built-in TIFF decoder does, and the
+// libvips backend does for TIFF and PDF.  Steps run on already-decoded pages,
+// so they should not include Decode.
+func (p *Processor) ProcessPages(ctx context.Context, src core.Source, pages PageRange, nameTemplate string, steps ...core.Step) (*core.ProcessingResult, error) {
+	start := time.Now()
+	r := src.Reader
+	if p.cfg.MaxImageBytes > 0 {
+		r = &utils.LimitedReader{R: r, Max: p.cfg.MaxImageBytes}
+	}
+	buf, err := utils.DrainReader(ctx, r, p.cfg.ChunkSize)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryDecode, "pages.drain", err)
+	}
+	raw := utils.CloneBytes(buf.Bytes())
+	utils.ReleaseBuffer(buf)
+
+	format := core.Format(utils.DetectFormat(raw))
+	var dec core.PageDecoder
+	for _, d := range p.reg.DecoderChain(format) {
+		if pd, ok := d.(core.PageDecoder); ok {
+			dec = pd
+			break
+		}
+	}
+	if dec == nil {
+		return nil, apperrors.New(apperrors.CategoryDecode, "pages",
+			fmt.Errorf("%w: no page decoder for %s", apperrors.ErrUnsupportedFormat, format))
+	}
+	total, err := dec.PageCount(ctx, raw)
+	if err != nil {
+		return nil, err
+	}
+	from, to := pages.From, pages.To
+	if from <= 0 {
+		from = 1
+	}
+	if to <= 0 || to > total {
+		to = total
+	}
+	if from > to {
+		return nil, apperrors.New(apperrors.CategoryInput, "pages",
+			fmt.Errorf("%w: pages %d-%d of %d", apperrors.ErrInvalidDimensions, pages.From, pages.To, total))
+	}
+	if nameTemplate == "" {
+		nameTemplate = DefaultPageName
+	}
+
+	n := to - from + 1
+	results := make([]*core.ProcessingResult, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for k := 0; k < n; k++ {
+		wg.Add(1)
+		go func(k int) {
+			defer wg.Done()
+			img, err := dec.DecodePage(ctx, raw, from+k)
+			if err != nil {
+				errs[k] = err
+				return
+			}
+			img.OriginalSize = int64(len(raw))
+			img.Meta.Pages = total
+			results[k], errs[k] = p.inner.ProcessImage(ctx, img, steps...)
+		}(k)
+	}
+	wg.Wait()
+
+	out := &core.ProcessingResult{Variants: make(map[string]*core.ImageData, n)}
+	for k := 0; k < n; k++ {
+		if errs[k] != nil {
+			return nil, errs[k]
+		}
+		out.Variants[PageName(nameTemplate, from+k)] = results[k].Primary
+	}
+	out.Primary = results[0].Primary
+	out.StepTimings = results[0].StepTimings
+	out.ProcessingTime = time.Since(start)
+	return out, nil
+}
+
+// PageName expands a ProcessPages name template for page.
+func PageName(tmpl string, page int) string {
+	return strings.NewReplacer(
+		"{page}", strconv.Itoa(page),
+		"{page3}", fmt.Sprintf("%03d", page),
+	).Replace(tmpl)
+}
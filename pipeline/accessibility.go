@@ -0,0 +1,227 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"sort"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Colour-vision deficiency simulation ───────────────────────────────────────
+
+// CVD is a colour-vision deficiency simulated by ColorBlindStep.
+type CVD string
+
+const (
+	Protanopia   CVD = "protanopia"   // no L (red) cones
+	Deuteranopia CVD = "deuteranopia" // no M (green) cones
+	Tritanopia   CVD = "tritanopia"   // no S (blue) cones
+)
+
+// cvdMatrices are the full-severity simulation matrices of Machado, Oliveira
+// and Fernandes (2009), applied in linear RGB.
+var cvdMatrices = map[CVD][9]float64{
+	Protanopia: {
+		0.152286, 1.052583, -0.204868,
+		0.114503, 0.786281, 0.099216,
+		-0.003882, -0.048116, 1.051998,
+	},
+	Deuteranopia: {
+		0.367322, 0.860646, -0.227968,
+		0.280085, 0.672501, 0.047413,
+		-0.011820, 0.042940, 0.968881,
+	},
+	Tritanopia: {
+		1.255528, -0.076749, -0.178779,
+		-0.078411, 0.930809, 0.147602,
+		0.004733, 0.691367, 0.303900,
+	},
+}
+
+// ColorBlindStep re-renders the image as seen with a colour-vision
+// deficiency, for previewing designs and checking that colour-coded content
+// survives.  The result is *image.NRGBA with alpha preserved.
+type ColorBlindStep struct {
+	Type CVD
+	// Severity blends from normal vision (0) to full dichromacy (1).
+	// Default 1.
+	Severity float64
+}
+
+func (s *ColorBlindStep) Name() string { return "color_blind" }
+
+func (s *ColorBlindStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	full, ok := cvdMatrices[s.Type]
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("unknown deficiency %q", s.Type))
+	}
+	sev := s.Severity
+	if sev <= 0 || sev > 1 {
+		sev = 1
+	}
+	var m [9]float64
+	for i := range m {
+		id := 0.0
+		if i%4 == 0 {
+			id = 1
+		}
+		m[i] = id + (full[i]-id)*sev
+	}
+
+	dst := cloneNRGBA(src)
+	for i := 0; i+3 < len(dst.Pix); i += 4 {
+		r, g, b := srgbToLinear[dst.Pix[i]], srgbToLinear[dst.Pix[i+1]], srgbToLinear[dst.Pix[i+2]]
+		dst.Pix[i] = linearToSRGB(m[0]*r + m[1]*g + m[2]*b)
+		dst.Pix[i+1] = linearToSRGB(m[3]*r + m[4]*g + m[5]*b)
+		dst.Pix[i+2] = linearToSRGB(m[6]*r + m[7]*g + m[8]*b)
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
+
+// ── Contrast analysis ─────────────────────────────────────────────────────────
+
+// ContrastStep measures the image's luminance contrast and stores WCAG 2
+// metrics in Meta.Contrast.  Pixels are not modified.  With MinRatio set
+// the step fails when DominantRatio falls below it, so banner and
+// text-on-image uploads can be rejected as illegible.
+type ContrastStep struct {
+	MinRatio float64
+}
+
+func (s *ContrastStep) Name() string { return "contrast" }
+
+func (s *ContrastStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	m := MeasureContrast(src)
+	if s.MinRatio > 0 && m.DominantRatio < s.MinRatio {
+		return nil, apperrors.New(apperrors.CategoryInput, s.Name(),
+			fmt.Errorf("%w: contrast %.2f:1 below %.2f:1", apperrors.ErrLowContrast, m.DominantRatio, s.MinRatio))
+	}
+	out := *img
+	out.Meta.Contrast = &m
+	return &out, nil
+}
+
+// MeasureContrast computes ContrastMetrics over the visible pixels of src.
+func MeasureContrast(src image.Image) core.ContrastMetrics {
+	px := cloneNRGBA(src)
+	lum := make([]float64, 0, len(px.Pix)/4)
+	for i := 0; i+3 < len(px.Pix); i += 4 {
+		if px.Pix[i+3] == 0 {
+			continue
+		}
+		lum = append(lum, relLuminance(px.Pix[i], px.Pix[i+1], px.Pix[i+2]))
+	}
+	if len(lum) == 0 {
+		return core.ContrastMetrics{Ratio: 1, DominantRatio: 1}
+	}
+
+	var mean float64
+	for _, l := range lum {
+		mean += l
+	}
+	mean /= float64(len(lum))
+	var variance float64
+	for _, l := range lum {
+		variance += (l - mean) * (l - mean)
+	}
+
+	sorted := append([]float64(nil), lum...)
+	sort.Float64s(sorted)
+	pct := func(p float64) float64 { return sorted[int(p*float64(len(sorted)-1))] }
+	median := pct(0.5)
+
+	// Split at the median and compare the mean colour of each half.  The
+	// mean is taken in linear light so it is the colour a viewer perceives.
+	var sums [2][3]float64
+	var counts [2]float64
+	k := 0
+	for i := 0; i+3 < len(px.Pix); i += 4 {
+		if px.Pix[i+3] == 0 {
+			continue
+		}
+		half := 0
+		if lum[k] > median {
+			half = 1
+		}
+		k++
+		for c := 0; c < 3; c++ {
+			sums[half][c] += srgbToLinear[px.Pix[i+c]]
+		}
+		counts[half]++
+	}
+	dominant := 1.0
+	if counts[0] > 0 && counts[1] > 0 {
+		l := func(h int) float64 {
+			return 0.2126*sums[h][0]/counts[h] + 0.7152*sums[h][1]/counts[h] + 0.0722*sums[h][2]/counts[h]
+		}
+		dominant = wcagRatio(l(1), l(0))
+	}
+
+	return core.ContrastMetrics{
+		Ratio:         wcagRatio(pct(0.95), pct(0.05)),
+		DominantRatio: dominant,
+		RMS:           math.Sqrt(variance / float64(len(lum))),
+		PassesAA:      dominant >= 4.5,
+		PassesAALarge: dominant >= 3,
+		PassesAAA:     dominant >= 7,
+	}
+}
+
+// ContrastRatio returns the WCAG 2 contrast ratio between two colours.
+func ContrastRatio(a, b color.Color) float64 {
+	ca := color.NRGBAModel.Convert(a).(color.NRGBA)
+	cb := color.NRGBAModel.Convert(b).(color.NRGBA)
+	return wcagRatio(relLuminance(ca.R, ca.G, ca.B), relLuminance(cb.R, cb.G, cb.B))
+}
+
+func wcagRatio(l1, l2 float64) float64 {
+	if l1 < l2 {
+		l1, l2 = l2, l1
+	}
+	return (l1 + 0.05) / (l2 + 0.05)
+}
+
+// relLuminance is the WCAG relative luminance of an sRGB colour.
+func relLuminance(r, g, b uint8) float64 {
+	return 0.2126*srgbToLinear[r] + 0.7152*srgbToLinear[g] + 0.0722*srgbToLinear[b]
+}
+
+// srgbToLinear maps 8-bit sRGB values to linear light in 0..1.
+var srgbToLinear = func() (t [256]float64) {
+	for i := range t {
+		v := float64(i) / 255
+		if v <= 0.04045 {
+			t[i] = v / 12.92
+		} else {
+			t[i] = math.Pow((v+0.055)/1.055, 2.4)
+		}
+	}
+	return t
+}()
+
+func linearToSRGB(v float64) uint8 {
+	v = clampf(v, 0, 1)
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(v*255 + 0.5)
+}
@@ -0,0 +1,99 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+
+	"github.com/Skryldev/image-processor/config"
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── EachFrame ─────────────────────────────────────────────────────────────────
+
+// EachFrameStep runs Steps over every frame of a *core.Animation and
+// reassembles the results, keeping delays and loop count, so an animated
+// GIF can be resized or cropped without collapsing to its first frame.
+// Still images run through Steps once.  Steps must leave a decoded image
+// behind; put Encode after EachFrame, not inside it.
+type EachFrameStep struct {
+	Steps []core.Step
+}
+
+func (s *EachFrameStep) Name() string { return "each_frame" }
+
+// BindRegistry implements core.RegistryBinder by binding the inner steps.
+func (s *EachFrameStep) BindRegistry(reg core.Registry) core.Step {
+	steps := make([]core.Step, len(s.Steps))
+	for i, st := range s.Steps {
+		if b, ok := st.(core.RegistryBinder); ok {
+			st = b.BindRegistry(reg)
+		}
+		steps[i] = st
+	}
+	return &EachFrameStep{Steps: steps}
+}
+
+// BindConfig implements core.ConfigBinder by binding the inner steps.
+func (s *EachFrameStep) BindConfig(cfg config.Config) core.Step {
+	steps := make([]core.Step, len(s.Steps))
+	for i, st := range s.Steps {
+		if b, ok := st.(core.ConfigBinder); ok {
+			st = b.BindConfig(cfg)
+		}
+		steps[i] = st
+	}
+	return &EachFrameStep{Steps: steps}
+}
+
+func (s *EachFrameStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	anim, ok := img.Image.(*core.Animation)
+	if !ok || len(anim.Frames) == 0 {
+		return s.run(ctx, img)
+	}
+
+	out := &core.Animation{
+		Frames:    make([]image.Image, len(anim.Frames)),
+		Delays:    anim.Delays,
+		LoopCount: anim.LoopCount,
+	}
+	var first *core.ImageData
+	for i, f := range anim.Frames {
+		frame := *img
+		frame.Image = f
+		frame.Meta.Frames = 0
+		res, err := s.run(ctx, &frame)
+		if err != nil {
+			return nil, err
+		}
+		fi, ok := res.Image.(image.Image)
+		if !ok || fi == nil {
+			return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(),
+				fmt.Errorf("%w: frame %d has no decoded image", apperrors.ErrEmptyInput, i))
+		}
+		out.Frames[i] = fi
+		if first == nil {
+			first = res
+		}
+	}
+
+	result := *first
+	result.Image = out
+	result.Data = nil
+	result.Meta.Frames = len(out.Frames)
+	return &result, nil
+}
+
+func (s *EachFrameStep) run(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	var err error
+	for _, st := range s.Steps {
+		if err = ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		if img, err = st.Execute(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
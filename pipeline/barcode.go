@@ -0,0 +1,194 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/boombuler/barcode"
+	"github.com/boombuler/barcode/code128"
+	"github.com/boombuler/barcode/datamatrix"
+	"github.com/boombuler/barcode/ean"
+	"github.com/boombuler/barcode/qr"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Barcode ───────────────────────────────────────────────────────────────────
+
+// Symbology selects the kind of code BarcodeStep draws.
+type Symbology string
+
+const (
+	SymbologyQR         Symbology = "qr"
+	SymbologyDataMatrix Symbology = "datamatrix"
+	SymbologyCode128    Symbology = "code128"
+	SymbologyEAN        Symbology = "ean" // EAN-8 or EAN-13 by content length
+)
+
+// QRLevel is the QR error-correction level: the share of the symbol that can
+// be damaged (or covered by a logo) and still decode.
+type QRLevel string
+
+const (
+	QRLevelL QRLevel = "L" // ~7%
+	QRLevelM QRLevel = "M" // ~15%
+	QRLevelQ QRLevel = "Q" // ~25%
+	QRLevelH QRLevel = "H" // ~30%
+)
+
+// BarcodeStep generates a QR code (or other barcode) from Content and
+// composites it onto the image at Gravity, inset by Margin pixels, so
+// ticketing and shipping-label pipelines need no separate renderer.
+//
+// Modules are drawn at a whole number of pixels each, so the code stays
+// crisp; the rendered symbol is therefore at most Size pixels wide.
+type BarcodeStep struct {
+	Content   string
+	Symbology Symbology // default SymbologyQR
+	Level     QRLevel   // QR only; default QRLevelM
+	// Size is the target width in pixels including the quiet zone; 0 = a
+	// fifth of the image's shorter side.  2-D codes are square.
+	Size int
+	// Height of 1-D codes in pixels; 0 = Size/3.
+	Height  int
+	Gravity core.Gravity // default south-east
+	Margin  int          // inset from the anchored edges
+	// QuietZone in modules around the symbol; 0 = the symbology's minimum
+	// (4 for QR, 1 for Data Matrix, 10 for linear codes).
+	QuietZone  int
+	Foreground color.Color // default black
+	Background color.Color // quiet zone and light modules; default white
+}
+
+func (s *BarcodeStep) Name() string { return "barcode" }
+
+func (s *BarcodeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	code, quiet, err := s.encode()
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryInput, s.Name(), err)
+	}
+
+	b := src.Bounds()
+	size := s.Size
+	if size <= 0 {
+		size = min(b.Dx(), b.Dy()) / 5
+	}
+	symbol, err := s.render(code, quiet, size)
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	sw, sh := symbol.Bounds().Dx(), symbol.Bounds().Dy()
+	if sw+2*s.Margin > b.Dx() || sh+2*s.Margin > b.Dy() {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(),
+			fmt.Errorf("%w: %dx%d code does not fit a %dx%d image", apperrors.ErrInvalidDimensions, sw, sh, b.Dx(), b.Dy()))
+	}
+
+	gravity := s.Gravity
+	if gravity == "" {
+		gravity = core.GravitySouthEast
+	}
+	x, y := gravity.Offset(b.Dx()-2*s.Margin, b.Dy()-2*s.Margin, sw, sh)
+	at := image.Pt(x+s.Margin, y+s.Margin)
+
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	draw.Draw(dst, image.Rectangle{Min: at, Max: at.Add(image.Pt(sw, sh))}, symbol, image.Point{}, draw.Src)
+
+	out := *img
+	out.Image = dst
+	out.Meta.Width = b.Dx()
+	out.Meta.Height = b.Dy()
+	return &out, nil
+}
+
+// encode returns the module matrix and the quiet zone in modules.
+func (s *BarcodeStep) encode() (barcode.Barcode, int, error) {
+	var (
+		code  barcode.Barcode
+		err   error
+		quiet int
+	)
+	switch s.Symbology {
+	case SymbologyQR, "":
+		level := map[QRLevel]qr.ErrorCorrectionLevel{
+			QRLevelL: qr.L, QRLevelM: qr.M, QRLevelQ: qr.Q, QRLevelH: qr.H, "": qr.M,
+		}
+		ecl, ok := level[s.Level]
+		if !ok {
+			return nil, 0, fmt.Errorf("unknown QR error-correction level %q", s.Level)
+		}
+		code, err = qr.Encode(s.Content, ecl, qr.Auto)
+		quiet = 4
+	case SymbologyDataMatrix:
+		code, err = datamatrix.Encode(s.Content)
+		quiet = 1
+	case SymbologyCode128:
+		code, err = code128.Encode(s.Content)
+		quiet = 10
+	case SymbologyEAN:
+		code, err = ean.Encode(s.Content)
+		quiet = 10
+	default:
+		return nil, 0, fmt.Errorf("unknown symbology %q", s.Symbology)
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	if s.QuietZone > 0 {
+		quiet = s.QuietZone
+	}
+	return code, quiet, nil
+}
+
+// render draws code with its quiet zone at the largest whole-pixel module
+// size whose total width fits in size.
+func (s *BarcodeStep) render(code barcode.Barcode, quiet, size int) (*image.RGBA, error) {
+	cb := code.Bounds()
+	modsW, modsH := cb.Dx()+2*quiet, cb.Dy()+2*quiet
+	scale := size / modsW
+	if scale < 1 {
+		return nil, fmt.Errorf("%w: %d px is narrower than the %d-module code", apperrors.ErrInvalidDimensions, size, modsW)
+	}
+
+	w, h := modsW*scale, modsH*scale
+	padY, rowH := quiet*scale, scale
+	if code.Metadata().Dimensions == 1 {
+		// 1-D codes are one module tall: stretch the bars to the requested
+		// height and keep only a thin border above and below.
+		rowH = s.Height
+		if rowH <= 0 {
+			rowH = size / 3
+		}
+		padY = 2 * scale
+		h = rowH + 2*padY
+	}
+
+	fg, bg := s.Foreground, s.Background
+	if fg == nil {
+		fg = color.Black
+	}
+	if bg == nil {
+		bg = color.White
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	ink := image.NewUniform(fg)
+	for my := 0; my < cb.Dy(); my++ {
+		for mx := 0; mx < cb.Dx(); mx++ {
+			if r, _, _, _ := code.At(cb.Min.X+mx, cb.Min.Y+my).RGBA(); r > 0x7fff {
+				continue // light module
+			}
+			x0, y0 := (quiet+mx)*scale, padY+my*rowH
+			draw.Draw(dst, image.Rect(x0, y0, x0+scale, y0+rowH), ink, image.Point{}, draw.Src)
+		}
+	}
+	return dst, nil
+}
@@ -0,0 +1,167 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/imagecompare"
+)
+
+// ── Best format encode ────────────────────────────────────────────────────────
+
+// DefaultCandidates are the formats BestFormatEncodeStep tries when
+// Formats is empty.
+var DefaultCandidates = []core.Format{core.FormatJPEG, core.FormatWebP, core.FormatAVIF}
+
+// BestFormatEncodeStep encodes the image in each of Formats and keeps the
+// smallest output, so every image ships in whichever format suits it best:
+// photos usually in WebP or AVIF, flat graphics sometimes in PNG.  Formats
+// without a registered encoder are skipped.  With MinSSIM set, each output
+// is decoded again and compared with the unencoded image, and outputs
+// below the floor are passed over; when none reaches it, the most faithful
+// one is kept.  Every attempt, with its size, SSIM and reason for
+// rejection, is recorded in Meta.Candidates, and its encode time is
+// reported to Metrics as "best_format.<format>".
+type BestFormatEncodeStep struct {
+	Registry core.Registry
+	Formats  []core.Format // default DefaultCandidates
+	// Quality applies to every candidate, overriding an earlier
+	// QualityStep; 0 leaves the quality to it or the encoder default.
+	Quality int
+	MinSSIM float64
+	Metrics core.MetricsCollector
+}
+
+func (s *BestFormatEncodeStep) Name() string { return "best_format" }
+
+// BindRegistry implements core.RegistryBinder.
+func (s *BestFormatEncodeStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
+func (s *BestFormatEncodeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Registry == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
+	formats := s.Formats
+	if len(formats) == 0 {
+		formats = DefaultCandidates
+	}
+	src := *img
+	if s.Quality > 0 {
+		src.Attrs = img.Attrs.With(core.AttrQuality, s.Quality)
+	}
+
+	var (
+		outputs    = make([]*core.ImageData, len(formats))
+		candidates = make([]core.EncodeCandidate, 0, len(formats))
+		best       = -1
+		firstErr   error
+	)
+	better := func(i int) bool {
+		if best < 0 {
+			return true
+		}
+		a, b := candidates[i], candidates[best]
+		pass := func(c core.EncodeCandidate) bool { return s.MinSSIM <= 0 || c.SSIM >= s.MinSSIM }
+		switch {
+		case pass(a) != pass(b):
+			return pass(a)
+		case !pass(a):
+			return a.SSIM > b.SSIM
+		}
+		return a.Size < b.Size
+	}
+	for _, f := range formats {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+		}
+		if len(core.EncodersFor(s.Registry, f)) == 0 {
+			continue
+		}
+		start := time.Now()
+		c := core.EncodeCandidate{Format: f, Quality: s.Quality}
+		out, err := s.encode(ctx, &src, f)
+		if err == nil && s.MinSSIM > 0 {
+			c.SSIM, err = measureSSIM(ctx, s.Registry, s.Name(), img, out)
+		}
+		c.Duration = time.Since(start)
+		if s.Metrics != nil {
+			s.Metrics.RecordProcessingTime(s.Name()+"."+string(f), c.Duration)
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			c.Rejected = "failed: " + err.Error()
+			candidates = append(candidates, c)
+			continue
+		}
+		c.Size = int64(len(out.Data))
+		outputs[len(candidates)] = out
+		candidates = append(candidates, c)
+		if better(len(candidates) - 1) {
+			best = len(candidates) - 1
+		}
+	}
+	if best < 0 {
+		if firstErr == nil {
+			firstErr = apperrors.New(apperrors.CategoryEncode, s.Name(),
+				fmt.Errorf("%w: no encoder for %v", apperrors.ErrUnsupportedFormat, formats))
+		}
+		return nil, firstErr
+	}
+
+	winner := candidates[best]
+	for i := range candidates {
+		switch {
+		case i == best || candidates[i].Rejected != "":
+		case s.MinSSIM > 0 && candidates[i].SSIM < s.MinSSIM:
+			candidates[i].Rejected = fmt.Sprintf("SSIM %.4f below %.4f", candidates[i].SSIM, s.MinSSIM)
+		default:
+			candidates[i].Rejected = fmt.Sprintf("larger than %s", winner.Format)
+		}
+	}
+	out := outputs[best]
+	out.Attrs = img.Attrs
+	out.Meta.Candidates = candidates
+	return out, nil
+}
+
+// encode encodes img as f with the registry's encoders.
+func (s *BestFormatEncodeStep) encode(ctx context.Context, img *core.ImageData, f core.Format) (*core.ImageData, error) {
+	in := *img
+	in.Format = f
+	in.Meta.Format = f
+	return (&EncodeStep{Registry: s.Registry}).Execute(ctx, &in)
+}
+
+// measureSSIM decodes out again with reg and measures its SSIM against
+// img; op names the step in errors.
+func measureSSIM(ctx context.Context, reg core.Registry, op string, img, out *core.ImageData) (float64, error) {
+	orig, err := core.StdImage(ctx, img)
+	if err != nil {
+		return 0, apperrors.Wrap(apperrors.CategoryPipeline, op, err)
+	}
+	decoded, err := (&DecodeStep{Registry: reg}).Execute(ctx, &core.ImageData{Data: out.Data, Format: out.Format})
+	if err != nil {
+		return 0, err
+	}
+	got, err := core.StdImage(ctx, decoded)
+	if err != nil {
+		return 0, apperrors.Wrap(apperrors.CategoryPipeline, op, err)
+	}
+	r, err := imagecompare.CompareImages(orig, got)
+	if err != nil {
+		return 0, apperrors.Wrap(apperrors.CategoryPipeline, op, err)
+	}
+	return r.SSIM, nil
+}
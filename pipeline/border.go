@@ -0,0 +1,58 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Border ────────────────────────────────────────────────────────────────────
+
+// BorderStep frames the image with a solid border, growing the canvas by
+// the border widths so nothing is covered; place it after resizing so the
+// frame keeps its width.  A non-zero side field takes precedence over Width.
+// The result is *image.RGBA.
+type BorderStep struct {
+	Width                    int
+	Top, Right, Bottom, Left int
+	// Color fills the border.  Default white.
+	Color color.Color
+}
+
+func (s *BorderStep) Name() string { return "border" }
+
+func (s *BorderStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	side := func(v int) int {
+		if v != 0 {
+			return max(0, v)
+		}
+		return max(0, s.Width)
+	}
+	top, right, bottom, left := side(s.Top), side(s.Right), side(s.Bottom), side(s.Left)
+	if top+right+bottom+left == 0 {
+		return img, nil
+	}
+	c := s.Color
+	if c == nil {
+		c = color.White
+	}
+
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx()+left+right, b.Dy()+top+bottom))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+	draw.Draw(dst, image.Rect(left, top, left+b.Dx(), top+b.Dy()), src, b.Min, draw.Src)
+
+	out := *img
+	out.Image = dst
+	out.Meta.Width = dst.Rect.Dx()
+	out.Meta.Height = dst.Rect.Dy()
+	return &out, nil
+}
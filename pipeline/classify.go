@@ -0,0 +1,73 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Classify ──────────────────────────────────────────────────────────────────
+
+// ClassifyStep runs a core.Classifier (typically a moderation model) on the
+// image in the same pass as the other steps.  Scores are merged into
+// Meta.Scores; when any label reaches its entry in Thresholds the step fails
+// with apperrors.ErrRejected, stopping the pipeline before anything is
+// encoded or stored.
+type ClassifyStep struct {
+	Classifier core.Classifier
+	// Thresholds maps label → score at or above which the image is
+	// rejected.  Labels without a threshold are recorded only.
+	Thresholds map[string]float64
+	// FailOpen lets the image through unscored when the classifier errors,
+	// instead of failing the pipeline.
+	FailOpen bool
+	// OnScores, when set, is called with every successful classification,
+	// before the thresholds are checked — the hook point for audit logs and
+	// review queues.
+	OnScores func(ctx context.Context, img *core.ImageData, scores map[string]float64)
+}
+
+func (s *ClassifyStep) Name() string { return "classify" }
+
+func (s *ClassifyStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Classifier == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("no classifier configured"))
+	}
+	scores, err := s.Classifier.Classify(ctx, img)
+	if err != nil {
+		if s.FailOpen && ctx.Err() == nil {
+			return img, nil
+		}
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+
+	out := *img
+	merged := make(map[string]float64, len(img.Meta.Scores)+len(scores))
+	for k, v := range img.Meta.Scores {
+		merged[k] = v
+	}
+	for k, v := range scores {
+		merged[k] = v
+	}
+	out.Meta.Scores = merged
+	if s.OnScores != nil {
+		s.OnScores(ctx, &out, scores)
+	}
+
+	// Report labels in a stable order so the error is reproducible.
+	labels := make([]string, 0, len(s.Thresholds))
+	for l := range s.Thresholds {
+		labels = append(labels, l)
+	}
+	sort.Strings(labels)
+	for _, l := range labels {
+		if score, ok := scores[l]; ok && score >= s.Thresholds[l] {
+			return nil, apperrors.New(apperrors.CategoryInput, s.Name(),
+				fmt.Errorf("%w: %s %.3f ≥ %.3f", apperrors.ErrRejected, l, score, s.Thresholds[l]))
+		}
+	}
+	return &out, nil
+}
@@ -0,0 +1,63 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/icc"
+)
+
+// ── To sRGB ───────────────────────────────────────────────────────────────────
+
+// ToSRGBStep converts the pixels from the colour space of the embedded ICC
+// profile, Meta.ICCProfile, to sRGB, so Display P3, Adobe RGB and CMYK
+// sources keep their colours through steps and encoders that assume sRGB.
+// Afterwards Meta.ICCProfile is icc.SRGB.  Images without a profile, and
+// those whose profile already is sRGB, are left as they are; a profile the
+// icc package cannot read is an error.  With Embed the profile is also
+// written into the output (core.AttrEmbedICC).
+type ToSRGBStep struct {
+	Embed bool
+}
+
+func (s *ToSRGBStep) Name() string { return "to_srgb" }
+
+func (s *ToSRGBStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	out := *img
+	if s.Embed {
+		out.Attrs = img.Attrs.With(core.AttrEmbedICC, true)
+	}
+	if len(img.Meta.ICCProfile) == 0 {
+		return &out, nil
+	}
+	p, err := icc.Parse(img.Meta.ICCProfile)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if p.IsSRGB() {
+		return &out, nil
+	}
+
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	dst, err := p.ToSRGB(src)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	out.Image = dst
+	out.Meta.ICCProfile = icc.SRGB()
+	switch dst.(type) {
+	case *image.Gray, *image.Gray16:
+		out.Meta.ColorSpace = core.ColorSpaceGray
+	default:
+		out.Meta.ColorSpace = core.ColorSpaceRGB
+		if img.Meta.HasAlpha {
+			out.Meta.ColorSpace = core.ColorSpaceRGBA
+		}
+	}
+	return &out, nil
+}
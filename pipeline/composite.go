@@ -0,0 +1,105 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Composite ─────────────────────────────────────────────────────────────────
+
+// CompositeStep layers Overlay onto the image with a blend mode, so badges,
+// frames and gradient washes can be stacked in one pipeline by chaining
+// steps.  Position anchors the layer as for WatermarkStep, and the offsets
+// move it away from the anchored edges.  Compositing follows the W3C
+// source-over rule with the blend applied where both layers are opaque.
+// The result is *image.NRGBA.
+type CompositeStep struct {
+	// Overlay is the layer; pass a decoded ImageData's Image to stack
+	// pipeline outputs.
+	Overlay          image.Image
+	Position         core.Gravity // default core.GravityNorthWest
+	OffsetX, OffsetY int
+	// Mode defaults to core.BlendOver.
+	Mode core.BlendMode
+	// Opacity in (0, 1] fades the layer.  Default 1.
+	Opacity float64
+}
+
+func (s *CompositeStep) Name() string { return "composite" }
+
+func (s *CompositeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Overlay == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("no overlay image configured"))
+	}
+	blend, ok := blendFuncs[s.Mode]
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("unknown blend mode %q", s.Mode))
+	}
+	opacity := s.Opacity
+	if opacity <= 0 || opacity > 1 {
+		opacity = 1
+	}
+
+	dst := cloneNRGBA(src)
+	layer := cloneNRGBA(s.Overlay)
+	g := s.Position
+	if g == "" {
+		g = core.GravityNorthWest
+	}
+	ox, oy := s.OffsetX, s.OffsetY
+	switch g {
+	case core.GravityEast, core.GravityNorthEast, core.GravitySouthEast:
+		ox = -ox
+	}
+	switch g {
+	case core.GravitySouth, core.GravitySouthEast, core.GravitySouthWest:
+		oy = -oy
+	}
+	x, y := g.Offset(dst.Rect.Dx(), dst.Rect.Dy(), layer.Rect.Dx(), layer.Rect.Dy())
+	at := image.Pt(x+ox, y+oy)
+	r := layer.Rect.Add(at).Intersect(dst.Rect)
+
+	for py := r.Min.Y; py < r.Max.Y; py++ {
+		for px := r.Min.X; px < r.Max.X; px++ {
+			d := dst.Pix[dst.PixOffset(px, py):][:4:4]
+			l := layer.Pix[layer.PixOffset(px-at.X, py-at.Y):][:4:4]
+			as := float64(l[3]) / 255 * opacity
+			if as == 0 {
+				continue
+			}
+			ab := float64(d[3]) / 255
+			ao := as + ab*(1-as)
+			for c := 0; c < 3; c++ {
+				cs, cb := float64(l[c])/255, float64(d[c])/255
+				co := as*(1-ab)*cs + as*ab*blend(cb, cs) + (1-as)*ab*cb
+				d[c] = uint8(clampf(co/ao, 0, 1)*255 + 0.5)
+			}
+			d[3] = uint8(ao*255 + 0.5)
+		}
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
+
+// blendFuncs maps each mode to its separable blend function B(cb, cs) of
+// backdrop and source channel values in 0..1.
+var blendFuncs = map[core.BlendMode]func(cb, cs float64) float64{
+	"":                 func(_, cs float64) float64 { return cs },
+	core.BlendOver:     func(_, cs float64) float64 { return cs },
+	core.BlendMultiply: func(cb, cs float64) float64 { return cb * cs },
+	core.BlendScreen:   func(cb, cs float64) float64 { return cb + cs - cb*cs },
+	core.BlendDarken:   math.Min,
+	core.BlendLighten:  math.Max,
+}
@@ -0,0 +1,162 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"slices"
+
+	"github.com/Skryldev/image-processor/config"
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Conditional ───────────────────────────────────────────────────────────────
+
+// Condition decides whether a ConditionalStep runs its steps.  It sees the
+// image as left by the preceding steps and must not modify it.  Conditions
+// are fingerprinted by their exported fields, so pipelines using the
+// built-in ones can be cached and coalesced.
+type Condition interface {
+	Holds(img *core.ImageData) bool
+}
+
+// Predicate adapts a function to a Condition.  A function cannot be
+// fingerprinted, so pipelines holding one are neither cached nor coalesced.
+type Predicate func(img *core.ImageData) bool
+
+// Holds implements Condition.
+func (p Predicate) Holds(img *core.ImageData) bool { return p(img) }
+
+// ConditionalStep runs Steps only when Condition holds, or only when it
+// does not if Negate is set; otherwise the image passes through unchanged.
+// Build it with If or Unless.
+type ConditionalStep struct {
+	Condition Condition
+	Negate    bool
+	Steps     []core.Step
+}
+
+// If returns a step that runs steps when cond holds, e.g.
+//
+//	pipeline.If(pipeline.WidthGreaterThan(1600), &pipeline.ResizeStep{Width: 1600})
+func If(cond Condition, steps ...core.Step) core.Step {
+	return &ConditionalStep{Condition: cond, Steps: steps}
+}
+
+// Unless returns a step that runs steps when cond does not hold.
+func Unless(cond Condition, steps ...core.Step) core.Step {
+	return &ConditionalStep{Condition: cond, Negate: true, Steps: steps}
+}
+
+func (s *ConditionalStep) Name() string {
+	if s.Negate {
+		return "unless"
+	}
+	return "if"
+}
+
+// BindRegistry implements core.RegistryBinder by binding the inner steps.
+func (s *ConditionalStep) BindRegistry(reg core.Registry) core.Step {
+	c := *s
+	c.Steps = make([]core.Step, len(s.Steps))
+	for i, st := range s.Steps {
+		if b, ok := st.(core.RegistryBinder); ok {
+			st = b.BindRegistry(reg)
+		}
+		c.Steps[i] = st
+	}
+	return &c
+}
+
+// BindConfig implements core.ConfigBinder by binding the inner steps.
+func (s *ConditionalStep) BindConfig(cfg config.Config) core.Step {
+	c := *s
+	c.Steps = make([]core.Step, len(s.Steps))
+	for i, st := range s.Steps {
+		if b, ok := st.(core.ConfigBinder); ok {
+			st = b.BindConfig(cfg)
+		}
+		c.Steps[i] = st
+	}
+	return &c
+}
+
+// FingerprintParams implements core.Fingerprintable.
+func (s *ConditionalStep) FingerprintParams() map[string]any {
+	return map[string]any{"condition": s.Condition, "negate": s.Negate, "steps": s.Steps}
+}
+
+func (s *ConditionalStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Condition == nil || s.Condition.Holds(img) == s.Negate {
+		return img, nil
+	}
+	var err error
+	for _, st := range s.Steps {
+		if err = ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		if img, err = st.Execute(ctx, img); err != nil {
+			return nil, err
+		}
+	}
+	return img, nil
+}
+
+// WidthGreaterThan holds for images wider than width pixels, judged by the
+// decoded image or, before decoding, Meta.Width.
+func WidthGreaterThan(width int) Condition { return widthGreaterThan{Width: width} }
+
+type widthGreaterThan struct{ Width int }
+
+func (c widthGreaterThan) Holds(img *core.ImageData) bool {
+	w := img.Meta.Width
+	if img.Image != nil {
+		w = img.Image.Bounds().Dx()
+	}
+	return w > c.Width
+}
+
+// FormatIs holds when Meta.Format is one of formats: the source format
+// after decoding, or the target format once a format step has run.
+func FormatIs(formats ...core.Format) Condition { return formatIs{Formats: formats} }
+
+type formatIs struct{ Formats []core.Format }
+
+func (c formatIs) Holds(img *core.ImageData) bool {
+	return slices.Contains(c.Formats, img.Meta.Format)
+}
+
+// HasAlpha holds for images with an alpha channel, unless the decoded image
+// reports itself fully opaque; use it to skip flattening opaque images.
+func HasAlpha() Condition { return hasAlpha{} }
+
+type hasAlpha struct{}
+
+func (hasAlpha) Holds(img *core.ImageData) bool {
+	switch src := img.Image.(type) {
+	case nil:
+	case image.Image:
+		if isOpaque(src) {
+			return false
+		}
+	default:
+		if core.ColorModelHint(src) != core.ColorSpaceRGBA {
+			return false
+		}
+	}
+	return img.Meta.HasAlpha
+}
+
+// SizeOver holds when the source, or the encoded output after Encode, is
+// larger than bytes.
+func SizeOver(bytes int64) Condition { return sizeOver{Bytes: bytes} }
+
+type sizeOver struct{ Bytes int64 }
+
+func (c sizeOver) Holds(img *core.ImageData) bool {
+	size := img.Meta.SizeBytes
+	if len(img.Data) > 0 {
+		size = int64(len(img.Data))
+	}
+	return size > c.Bytes
+}
@@ -0,0 +1,127 @@
+package pipeline
+
+import (
+	"context"
+	"image/color"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── ColorMatrix ───────────────────────────────────────────────────────────────
+
+// ColorMatrixStep transforms every pixel by a 4×5 matrix, as SVG's
+// feColorMatrix does: rows produce R, G, B and A from the unpremultiplied
+// channels (r, g, b, a, 1) scaled to 0..1, and results are clamped.  The
+// colour effects below are built on it, and custom ones can be written as a
+// matrix.  The result is *image.NRGBA.
+type ColorMatrixStep struct {
+	Matrix [4][5]float64
+}
+
+// identityMatrix leaves every pixel unchanged.
+var identityMatrix = [4][5]float64{
+	{1, 0, 0, 0, 0},
+	{0, 1, 0, 0, 0},
+	{0, 0, 1, 0, 0},
+	{0, 0, 0, 1, 0},
+}
+
+func (s *ColorMatrixStep) Name() string { return "color_matrix" }
+
+func (s *ColorMatrixStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Matrix == identityMatrix {
+		return img, nil
+	}
+	m := s.Matrix
+	dst := cloneNRGBA(src)
+	pix := dst.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		in := [5]float64{
+			float64(pix[i]) / 255, float64(pix[i+1]) / 255,
+			float64(pix[i+2]) / 255, float64(pix[i+3]) / 255, 1,
+		}
+		for c := 0; c < 4; c++ {
+			v := 0.0
+			for k, w := range m[c] {
+				v += w * in[k]
+			}
+			pix[i+c] = uint8(clampf(v, 0, 1)*255 + 0.5)
+		}
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	if m[3] != identityMatrix[3] {
+		out.Meta.HasAlpha = !isOpaque(dst)
+	}
+	return &out, nil
+}
+
+// ── Colour effects ────────────────────────────────────────────────────────────
+
+// SepiaStep gives the image a warm brown, old-photograph tone using the
+// CSS Filter Effects sepia(1) matrix.
+type SepiaStep struct{}
+
+func (s *SepiaStep) Name() string { return "sepia" }
+
+func (s *SepiaStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	return (&ColorMatrixStep{Matrix: [4][5]float64{
+		{0.393, 0.769, 0.189, 0, 0},
+		{0.349, 0.686, 0.168, 0, 0},
+		{0.272, 0.534, 0.131, 0, 0},
+		{0, 0, 0, 1, 0},
+	}}).Execute(ctx, img)
+}
+
+// InvertStep replaces every colour with its negative; alpha is kept.
+type InvertStep struct{}
+
+func (s *InvertStep) Name() string { return "invert" }
+
+func (s *InvertStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	return (&ColorMatrixStep{Matrix: [4][5]float64{
+		{-1, 0, 0, 0, 1},
+		{0, -1, 0, 0, 1},
+		{0, 0, -1, 0, 1},
+		{0, 0, 0, 1, 0},
+	}}).Execute(ctx, img)
+}
+
+// DuotoneStep maps luma onto a two-colour gradient: black becomes Dark,
+// white becomes Light and the tones between are blended linearly.  Nil
+// colours default to black and white, which gives a plain grayscale.
+type DuotoneStep struct {
+	Dark, Light color.Color
+}
+
+func (s *DuotoneStep) Name() string { return "duotone" }
+
+func (s *DuotoneStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	dark, light := s.Dark, s.Light
+	if dark == nil {
+		dark = color.Black
+	}
+	if light == nil {
+		light = color.White
+	}
+	d := color.NRGBAModel.Convert(dark).(color.NRGBA)
+	l := color.NRGBAModel.Convert(light).(color.NRGBA)
+	const lr, lg, lb = 0.2126, 0.7152, 0.0722
+	row := func(dc, lc uint8) [5]float64 {
+		span := (float64(lc) - float64(dc)) / 255
+		return [5]float64{span * lr, span * lg, span * lb, 0, float64(dc) / 255}
+	}
+	return (&ColorMatrixStep{Matrix: [4][5]float64{
+		row(d.R, l.R),
+		row(d.G, l.G),
+		row(d.B, l.B),
+		{0, 0, 0, 1, 0},
+	}}).Execute(ctx, img)
+}
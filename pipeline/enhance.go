@@ -0,0 +1,207 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/draw"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── AutoEnhance ───────────────────────────────────────────────────────────────
+
+// AutoEnhanceStep is a one-flag "improve photo" step: it neutralises colour
+// casts (gray-world white balance), stretches the tonal range to fill the
+// histogram, and gently boosts saturation.  Each correction is clamped so
+// already-good photos come through nearly unchanged.
+//
+// The result is 8-bit *image.NRGBA; alpha is preserved and fully transparent
+// pixels are ignored when measuring the image.
+type AutoEnhanceStep struct {
+	// Strength blends between the original (0) and the full correction (1).
+	// Default 1.
+	Strength float64
+	// Saturation is the relative saturation boost.  Default 0.1 (10%);
+	// negative disables it.
+	Saturation float64
+	// Clip is the fraction of pixels allowed to clip at each end of the
+	// histogram when stretching.  Default 0.005.
+	Clip float64
+
+	// SkipWhiteBalance and SkipStretch disable those corrections.
+	SkipWhiteBalance bool
+	SkipStretch      bool
+}
+
+func (s *AutoEnhanceStep) Name() string { return "auto_enhance" }
+
+// Limits that keep the corrections conservative.
+const (
+	enhanceMaxWBGain  = 1.25 // per-channel white-balance gain, and 1/x
+	enhanceMaxStretch = 2.0  // tonal range expansion factor
+	enhanceMinRange   = 16   // flat images (solid fills) are left alone
+)
+
+func (s *AutoEnhanceStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	strength := s.Strength
+	if strength <= 0 {
+		strength = 1
+	}
+	if strength > 1 {
+		strength = 1
+	}
+	sat := s.Saturation
+	if sat == 0 {
+		sat = 0.1
+	}
+	if sat < 0 {
+		sat = 0
+	}
+	clip := s.Clip
+	if clip <= 0 {
+		clip = 0.005
+	}
+
+	dst := cloneNRGBA(src)
+	gains := [3]float64{1, 1, 1}
+	if !s.SkipWhiteBalance {
+		gains = grayWorldGains(dst)
+	}
+
+	// Per-channel lookup tables for white balance followed by the stretch,
+	// so the stretch is measured on the balanced image.
+	var lut [3][256]float64
+	for c := 0; c < 3; c++ {
+		for v := 0; v < 256; v++ {
+			lut[c][v] = clampf(float64(v)*gains[c], 0, 255)
+		}
+	}
+	if !s.SkipStretch {
+		lo, hi := lumaPercentiles(dst, &lut, clip)
+		if hi-lo >= enhanceMinRange {
+			scale := 255 / (hi - lo)
+			if scale > enhanceMaxStretch {
+				// Keep the midpoint where it was rather than pinning black.
+				scale = enhanceMaxStretch
+				lo = (lo+hi)/2 - 127.5/scale
+			}
+			for c := 0; c < 3; c++ {
+				for v := range lut[c] {
+					lut[c][v] = clampf((lut[c][v]-lo)*scale, 0, 255)
+				}
+			}
+		}
+	}
+
+	pix := dst.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		if pix[i+3] == 0 {
+			continue
+		}
+		r, g, b := lut[0][pix[i]], lut[1][pix[i+1]], lut[2][pix[i+2]]
+		if sat > 0 {
+			l := (float64(lumaR)*r + float64(lumaG)*g + float64(lumaB)*b) / 65536
+			r, g, b = l+(r-l)*(1+sat), l+(g-l)*(1+sat), l+(b-l)*(1+sat)
+		}
+		pix[i] = blend8(pix[i], r, strength)
+		pix[i+1] = blend8(pix[i+1], g, strength)
+		pix[i+2] = blend8(pix[i+2], b, strength)
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
+
+// grayWorldGains returns per-channel gains that move the mean colour to
+// neutral gray, each clamped to [1/enhanceMaxWBGain, enhanceMaxWBGain].
+func grayWorldGains(m *image.NRGBA) [3]float64 {
+	var sum [3]float64
+	var n float64
+	for i := 0; i+3 < len(m.Pix); i += 4 {
+		if m.Pix[i+3] == 0 {
+			continue
+		}
+		sum[0] += float64(m.Pix[i])
+		sum[1] += float64(m.Pix[i+1])
+		sum[2] += float64(m.Pix[i+2])
+		n++
+	}
+	gains := [3]float64{1, 1, 1}
+	if n == 0 || sum[0] == 0 || sum[1] == 0 || sum[2] == 0 {
+		return gains
+	}
+	gray := (sum[0] + sum[1] + sum[2]) / 3
+	for c := range gains {
+		gains[c] = clampf(gray/sum[c], 1/enhanceMaxWBGain, enhanceMaxWBGain)
+	}
+	return gains
+}
+
+// lumaPercentiles returns the luma values below which clip and 1-clip of
+// the visible pixels fall, after mapping channels through lut.
+func lumaPercentiles(m *image.NRGBA, lut *[3][256]float64, clip float64) (lo, hi float64) {
+	var hist [256]int
+	total := 0
+	for i := 0; i+3 < len(m.Pix); i += 4 {
+		if m.Pix[i+3] == 0 {
+			continue
+		}
+		r, g, b := lut[0][m.Pix[i]], lut[1][m.Pix[i+1]], lut[2][m.Pix[i+2]]
+		l := (float64(lumaR)*r + float64(lumaG)*g + float64(lumaB)*b) / 65536
+		hist[int(l+0.5)]++
+		total++
+	}
+	if total == 0 {
+		return 0, 255
+	}
+	cut := int(clip * float64(total))
+	acc, l := 0, 0
+	for ; l < 255; l++ {
+		if acc += hist[l]; acc > cut {
+			break
+		}
+	}
+	acc, h := 0, 255
+	for ; h > 0; h-- {
+		if acc += hist[h]; acc > cut {
+			break
+		}
+	}
+	return float64(l), float64(h)
+}
+
+// cloneNRGBA returns a fresh, zero-origin *image.NRGBA copy of src.
+func cloneNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	if n, ok := src.(*image.NRGBA); ok {
+		for y := 0; y < b.Dy(); y++ {
+			copy(dst.Pix[y*dst.Stride:y*dst.Stride+b.Dx()*4], n.Pix[n.PixOffset(b.Min.X, b.Min.Y+y):])
+		}
+		return dst
+	}
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
+
+// blend8 moves v towards target by t (0..1) and rounds to a byte.
+func blend8(v uint8, target, t float64) uint8 {
+	return uint8(clampf(float64(v)+(target-float64(v))*t, 0, 255) + 0.5)
+}
+
+func clampf(v, lo, hi float64) float64 {
+	if v < lo {
+		return lo
+	}
+	if v > hi {
+		return hi
+	}
+	return v
+}
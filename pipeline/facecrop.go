@@ -0,0 +1,109 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── FaceCrop ──────────────────────────────────────────────────────────────────
+
+// FaceCropStep produces a Width×Height image that keeps every face Detector
+// finds in frame.  It takes the largest window of the target aspect ratio
+// that fits the image and slides it over the faces; when the faces (plus
+// Padding) do not fit in such a window, the window grows past the image
+// edges and the overflow is filled with Background.  Without faces it falls
+// back to SmartCropStep with Fallback.
+type FaceCropStep struct {
+	Detector      core.FaceDetector
+	Width, Height int
+	// Padding is the margin kept around each face, as a fraction of the
+	// face's size.  Default 0.5; negative means none.
+	Padding float64
+	// Fallback is the SmartCropStep strategy used when no face is found.
+	// Default core.CropAttention.
+	Fallback core.CropStrategy
+	// Background fills any area outside the source.  Default transparent.
+	Background color.Color
+}
+
+func (s *FaceCropStep) Name() string { return "face_crop" }
+
+func (s *FaceCropStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Detector == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("no face detector configured"))
+	}
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+	faces, err := s.Detector.Detect(ctx, src)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if len(faces) == 0 {
+		return (&SmartCropStep{Width: s.Width, Height: s.Height, Strategy: s.Fallback}).Execute(ctx, img)
+	}
+
+	pad := s.Padding
+	if pad == 0 {
+		pad = 0.5
+	}
+	pad = math.Max(0, pad)
+	var keep image.Rectangle
+	for _, f := range faces {
+		mx, my := int(float64(f.Dx())*pad+0.5), int(float64(f.Dy())*pad+0.5)
+		keep = keep.Union(image.Rect(f.Min.X-mx, f.Min.Y-my, f.Max.X+mx, f.Max.Y+my))
+	}
+
+	win := faceWindow(src.Bounds(), keep, float64(s.Width)/float64(s.Height))
+	dst := image.NewRGBA(image.Rect(0, 0, win.Dx(), win.Dy()))
+	if s.Background != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(s.Background), image.Point{}, draw.Src)
+	}
+	draw.Draw(dst, dst.Bounds(), src, win.Min, draw.Over)
+
+	cropped := *img
+	cropped.Image = dst
+	cropped.Meta.Width, cropped.Meta.Height = win.Dx(), win.Dy()
+	if !win.In(src.Bounds()) && s.Background == nil {
+		cropped.Meta.HasAlpha = true
+	}
+	return (&ResizeStep{Width: s.Width, Height: s.Height}).Execute(ctx, &cropped)
+}
+
+// faceWindow returns a window of the given aspect ratio (width / height)
+// that contains keep: the largest such window inside bounds when keep fits
+// in it, otherwise the smallest window around keep, centred on it.
+func faceWindow(bounds, keep image.Rectangle, aspect float64) image.Rectangle {
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	if w/h > aspect {
+		w = h * aspect
+	} else {
+		h = w / aspect
+	}
+	kw, kh := float64(keep.Dx()), float64(keep.Dy())
+	if kw > w || kh > h {
+		// Grow the window around the faces; it extends past the image.
+		w, h = math.Max(kw, kh*aspect), math.Max(kh, kw/aspect)
+	}
+	ww, wh := int(math.Round(w)), int(math.Round(h))
+	cx, cy := (keep.Min.X+keep.Max.X)/2, (keep.Min.Y+keep.Max.Y)/2
+	x, y := cx-ww/2, cy-wh/2
+	if ww <= bounds.Dx() {
+		x = max(bounds.Min.X, min(x, bounds.Max.X-ww))
+	}
+	if wh <= bounds.Dy() {
+		y = max(bounds.Min.Y, min(y, bounds.Max.Y-wh))
+	}
+	return image.Rect(x, y, x+ww, y+wh)
+}
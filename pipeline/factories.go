@@ -0,0 +1,460 @@
+package pipeline
+
+import (
+	"fmt"
+	"image/color"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+
+	"github.com/Skryldev/image-processor/core"
+)
+
+// ── Step factories ────────────────────────────────────────────────────────────
+
+// init registers factories for the steps that can be described by plain
+// parameters, under their Name(), taking the step's fields in snake_case
+// and colours as "#rrggbb[aa]" or CSS names.  Steps that need images,
+// fonts or adapters (watermarks, classifiers, face detection) are left to
+// callers.
+func init() {
+	builtin := map[string]func(a *args) core.Step{
+		"decode": func(a *args) core.Step {
+			return &DecodeStep{Options: core.DecodeOptions{Page: a.int("page")}}
+		},
+		"encode": func(a *args) core.Step {
+			opts := core.EncodeOptions{
+				Quality:       a.int("quality"),
+				Lossless:      a.bool("lossless"),
+				StripEXIF:     a.bool("strip_exif"),
+				Interlaced:    a.bool("interlaced"),
+				Deterministic: a.bool("deterministic"),
+				EmbedICC:      a.bool("embed_icc"),
+				Background:    a.color("background"),
+			}
+			a.formatOptions(&opts)
+			return &EncodeStep{BaseOptions: opts}
+		},
+		"format":        func(a *args) core.Step { return &FormatStep{Format: core.Format(a.string("format"))} },
+		"quality":       func(a *args) core.Step { return &QualityStep{Quality: a.int("quality")} },
+		"strip_exif":    func(*args) core.Step { return &StripEXIFStep{} },
+		"deterministic": func(*args) core.Step { return &DeterministicStep{} },
+		"resize": func(a *args) core.Step {
+			return &ResizeStep{
+				Width:       a.int("width"),
+				Height:      a.int("height"),
+				Kernel:      core.Kernel(a.string("kernel")),
+				PostSharpen: a.float("post_sharpen"),
+			}
+		},
+		"crop": func(a *args) core.Step {
+			return &CropStep{
+				X: a.int("x"), Y: a.int("y"), Width: a.int("width"), Height: a.int("height"),
+				Gravity:     core.Gravity(a.string("gravity")),
+				AspectRatio: a.float("aspect_ratio"),
+			}
+		},
+		"smart_crop": func(a *args) core.Step {
+			return &SmartCropStep{
+				Width: a.int("width"), Height: a.int("height"),
+				Strategy: core.CropStrategy(a.string("strategy")),
+			}
+		},
+		"seam_carve": func(a *args) core.Step {
+			return &SeamCarveStep{Width: a.int("width"), Height: a.int("height")}
+		},
+		"thumbnail": func(a *args) core.Step {
+			return &ThumbnailStep{
+				Size: a.int("size"), Width: a.int("width"), Height: a.int("height"),
+				Fit:        Fit(a.string("fit")),
+				Gravity:    core.Gravity(a.string("gravity")),
+				Background: a.color("background"),
+			}
+		},
+		"grayscale": func(a *args) core.Step { return &GrayscaleStep{DropAlpha: a.bool("drop_alpha")} },
+		"adjust": func(a *args) core.Step {
+			return &AdjustStep{
+				Brightness: a.float("brightness"),
+				Contrast:   a.float("contrast"),
+				Saturation: a.float("saturation"),
+				Hue:        a.float("hue"),
+			}
+		},
+		"auto_enhance": func(a *args) core.Step {
+			return &AutoEnhanceStep{
+				Strength:   a.float("strength"),
+				Saturation: a.float("saturation"),
+				Clip:       a.float("clip"),
+			}
+		},
+		"auto_level": func(a *args) core.Step { return &AutoLevelStep{Clip: a.float("clip")} },
+		"gamma":      func(a *args) core.Step { return &GammaStep{Gamma: a.float("gamma")} },
+		"clahe": func(a *args) core.Step {
+			return &CLAHEStep{ClipLimit: a.float("clip_limit"), TileSize: a.int("tile_size")}
+		},
+		"sepia":  func(*args) core.Step { return &SepiaStep{} },
+		"invert": func(*args) core.Step { return &InvertStep{} },
+		"duotone": func(a *args) core.Step {
+			return &DuotoneStep{Dark: a.color("dark"), Light: a.color("light")}
+		},
+		"lut": func(a *args) core.Step {
+			step := &LUTStep{Intensity: a.float("intensity")}
+			if path := a.string("path"); path != "" && a.err == nil {
+				step.LUT, a.err = LoadCubeLUT(path)
+			}
+			return step
+		},
+		"vignette": func(a *args) core.Step { return &VignetteStep{Strength: a.float("strength")} },
+		"gradient_overlay": func(a *args) core.Step {
+			return &GradientOverlayStep{
+				From: a.color("from"), To: a.color("to"),
+				Direction: core.Gravity(a.string("direction")),
+			}
+		},
+		"text_overlay": func(a *args) core.Step {
+			return &TextOverlayStep{
+				Text:     a.string("text"),
+				Size:     a.float("size"),
+				Color:    a.color("color"),
+				Position: core.Gravity(a.string("position")),
+				Padding:  a.int("padding"),
+			}
+		},
+		"flatten": func(a *args) core.Step { return &FlattenStep{Background: a.color("background")} },
+		"border": func(a *args) core.Step {
+			return &BorderStep{
+				Width: a.int("width"),
+				Top:   a.int("top"), Right: a.int("right"), Bottom: a.int("bottom"), Left: a.int("left"),
+				Color: a.color("color"),
+			}
+		},
+		"round_corners": func(a *args) core.Step { return &RoundCornersStep{Radius: a.int("radius")} },
+		"circle_mask":   func(*args) core.Step { return &CircleMaskStep{} },
+		"trim": func(a *args) core.Step {
+			return &TrimStep{Tolerance: a.int("tolerance"), Background: a.color("background")}
+		},
+		"quantize": func(a *args) core.Step {
+			return &QuantizeStep{Colors: a.int("colors"), Dither: a.bool("dither")}
+		},
+		"each_frame": func(a *args) core.Step { return &EachFrameStep{Steps: a.steps("steps")} },
+		"tee": func(a *args) core.Step {
+			return &TeeStep{Branch: a.string("branch"), Steps: a.steps("steps")}
+		},
+		"negotiate_format": func(a *args) core.Step {
+			return &NegotiateFormatStep{
+				Accept:     a.string("accept"),
+				Preference: a.formats("preference"),
+				Fallback:   core.Format(a.string("fallback")),
+			}
+		},
+		"best_format": func(a *args) core.Step {
+			return &BestFormatEncodeStep{
+				Formats: a.formats("formats"),
+				Quality: a.int("quality"),
+				MinSSIM: a.float("min_ssim"),
+			}
+		},
+		"perceptual_compress": func(a *args) core.Step {
+			return &PerceptualCompressStep{
+				TargetSSIM: a.float("target_ssim"),
+				MinQuality: a.int("min_quality"),
+				MaxQuality: a.int("max_quality"),
+			}
+		},
+		"optimize_png": func(a *args) core.Step {
+			return &OptimizePNGStep{
+				Lossless: a.bool("lossless"),
+				Colors:   a.int("colors"),
+				MinSSIM:  a.float("min_ssim"),
+			}
+		},
+		"strip_metadata": func(a *args) core.Step {
+			return &StripMetadataStep{Policy: core.MetadataPolicy{
+				Strip:            a.bool("strip"),
+				RemoveGPS:        a.bool("remove_gps"),
+				RemoveThumbnails: a.bool("remove_thumbnails"),
+				KeepCopyright:    a.bool("keep_copyright"),
+				KeepColorProfile: a.bool("keep_color_profile"),
+				KeepOrientation:  a.bool("keep_orientation"),
+			}}
+		},
+		"set_metadata": func(a *args) core.Step {
+			return &SetMetadataStep{Tags: core.MetadataTags{
+				Artist:    a.string("artist"),
+				Copyright: a.string("copyright"),
+				Software:  a.string("software"),
+				XMP:       a.string("xmp"),
+			}}
+		},
+		"to_srgb": func(a *args) core.Step { return &ToSRGBStep{Embed: a.bool("embed")} },
+	}
+	for name, build := range builtin {
+		core.RegisterStep(name, func(params map[string]any) (core.Step, error) {
+			a := &args{params: params, used: map[string]bool{}}
+			step := build(a)
+			return step, a.done()
+		})
+	}
+}
+
+// args reads the parameters of a built-in step, remembering the first
+// error and which keys were consumed.
+type args struct {
+	params map[string]any
+	used   map[string]bool
+	err    error
+}
+
+func (a *args) get(key string) (any, bool) {
+	a.used[key] = true
+	v, ok := a.params[key]
+	return v, ok && v != nil
+}
+
+func (a *args) fail(key, want string, v any) {
+	if a.err == nil {
+		a.err = fmt.Errorf("%s: want %s, got %v", key, want, v)
+	}
+}
+
+func (a *args) float(key string) float64 {
+	v, ok := a.get(key)
+	if !ok {
+		return 0
+	}
+	switch n := v.(type) {
+	case float64:
+		return n
+	case int:
+		return float64(n)
+	}
+	a.fail(key, "a number", v)
+	return 0
+}
+
+func (a *args) int(key string) int {
+	v, ok := a.get(key)
+	if !ok {
+		return 0
+	}
+	switch n := v.(type) {
+	case int:
+		return n
+	case float64:
+		if n == math.Trunc(n) && math.Abs(n) <= math.MaxInt32 {
+			return int(n)
+		}
+	}
+	a.fail(key, "an integer", v)
+	return 0
+}
+
+func (a *args) bool(key string) bool {
+	v, ok := a.get(key)
+	if !ok {
+		return false
+	}
+	b, isBool := v.(bool)
+	if !isBool {
+		a.fail(key, "true or false", v)
+	}
+	return b
+}
+
+func (a *args) string(key string) string {
+	v, ok := a.get(key)
+	if !ok {
+		return ""
+	}
+	s, isString := v.(string)
+	if !isString {
+		a.fail(key, "a string", v)
+	}
+	return s
+}
+
+// color reads "#rgb", "#rrggbb", "#rrggbbaa" or a CSS colour name; absent
+// keys give nil so the step's default applies.
+func (a *args) color(key string) color.Color {
+	s := a.string(key)
+	if s == "" {
+		return nil
+	}
+	c, ok := parseColor(s)
+	if !ok {
+		a.fail(key, "a colour", s)
+		return nil
+	}
+	return c
+}
+
+// formats reads a list of format names.
+func (a *args) formats(key string) []core.Format {
+	v, ok := a.get(key)
+	if !ok {
+		return nil
+	}
+	list, isList := v.([]any)
+	if !isList {
+		a.fail(key, "a list of formats", v)
+		return nil
+	}
+	formats := make([]core.Format, len(list))
+	for i, entry := range list {
+		s, isString := entry.(string)
+		if !isString {
+			a.fail(key, "a list of formats", v)
+			return nil
+		}
+		formats[i] = core.Format(strings.ToLower(s))
+	}
+	return formats
+}
+
+// formatOptions reads the per-format encode extensions from the "jpeg",
+// "png", "webp" and "avif" mappings, e.g. {"jpeg": {"subsample": "4:4:4"}}.
+func (a *args) formatOptions(opts *core.EncodeOptions) {
+	nested := func(key string, read func(n *args)) {
+		v, ok := a.get(key)
+		if !ok || a.err != nil {
+			return
+		}
+		m, isMap := v.(map[string]any)
+		if !isMap {
+			a.fail(key, "a mapping", v)
+			return
+		}
+		n := &args{params: m, used: map[string]bool{}}
+		read(n)
+		if err := n.done(); err != nil {
+			a.err = fmt.Errorf("%s: %w", key, err)
+		}
+	}
+	nested("jpeg", func(n *args) {
+		jo := opts.JPEG()
+		jo.Subsample = core.SubsampleMode(n.string("subsample"))
+		jo.KeepCMYK = n.bool("keep_cmyk")
+		jo.Trellis = n.bool("trellis")
+		jo.OvershootDeringing = n.bool("overshoot_deringing")
+		jo.OptimizeScans = n.bool("optimize_scans")
+		jo.QuantTable = n.int("quant_table")
+		switch jo.Subsample {
+		case core.SubsampleAuto, core.Subsample444, core.Subsample422, core.Subsample420:
+		default:
+			n.fail("subsample", `"4:4:4", "4:2:2" or "4:2:0"`, jo.Subsample)
+		}
+		if jo.QuantTable < 0 || jo.QuantTable > 8 {
+			n.fail("quant_table", "0 to 8", jo.QuantTable)
+		}
+	})
+	nested("png", func(n *args) {
+		po := opts.PNG()
+		po.CompressionLevel = n.int("compression_level")
+		po.Filter = core.PNGFilter(n.string("filter"))
+		po.Reduce = n.bool("reduce")
+		po.Colors = n.int("colors")
+		po.Dither = n.bool("dither")
+		po.StripMetadata = n.bool("strip_metadata")
+		switch po.Filter {
+		case core.PNGFilterAdaptive, core.PNGFilterNone, core.PNGFilterSub, core.PNGFilterUp,
+			core.PNGFilterAverage, core.PNGFilterPaeth:
+		default:
+			n.fail("filter", "none, sub, up, average or paeth", po.Filter)
+		}
+	})
+	nested("webp", func(n *args) {
+		wo := opts.WebP()
+		wo.Effort = n.int("effort")
+		wo.AlphaQuality = n.int("alpha_quality")
+		wo.NearLossless = n.bool("near_lossless")
+	})
+	nested("avif", func(n *args) {
+		ao := opts.AVIF()
+		ao.Effort = n.int("effort")
+		ao.Bitdepth = n.int("bitdepth")
+	})
+}
+
+// steps builds a nested list of step entries, each a mapping with a "type".
+func (a *args) steps(key string) []core.Step {
+	v, _ := a.get(key)
+	if v == nil || a.err != nil {
+		return nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		a.fail(key, "a list of steps", v)
+		return nil
+	}
+	steps := make([]core.Step, 0, len(list))
+	for i, entry := range list {
+		m, ok := entry.(map[string]any)
+		if !ok {
+			a.fail(fmt.Sprintf("%s[%d]", key, i), "a mapping", entry)
+			return nil
+		}
+		typ, _ := m["type"].(string)
+		params := make(map[string]any, len(m))
+		for k, v := range m {
+			if k != "type" {
+				params[k] = v
+			}
+		}
+		step, err := core.NewStep(typ, params)
+		if err != nil {
+			a.err = fmt.Errorf("%s[%d]: %w", key, i, err)
+			return nil
+		}
+		steps = append(steps, step)
+	}
+	return steps
+}
+
+// done reports the first bad parameter, or the first unknown one: a
+// misspelt key would otherwise be ignored silently.
+func (a *args) done() error {
+	if a.err != nil {
+		return a.err
+	}
+	var unknown []string
+	for key := range a.params {
+		if !a.used[key] {
+			unknown = append(unknown, key)
+		}
+	}
+	if len(unknown) > 0 {
+		sort.Strings(unknown)
+		return fmt.Errorf("unknown parameter %s", strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// parseColor reads "#rgb", "#rrggbb", "#rrggbbaa", "transparent" or a CSS
+// colour name.
+func parseColor(s string) (color.Color, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	hex, ok := strings.CutPrefix(s, "#")
+	if !ok {
+		if s == "transparent" {
+			return color.Transparent, true
+		}
+		c, ok := colornames.Map[s]
+		return c, ok
+	}
+	v, err := strconv.ParseUint(hex, 16, 32)
+	if err != nil {
+		return nil, false
+	}
+	switch len(hex) {
+	case 3:
+		return color.NRGBA{uint8(v>>8&0xf) * 17, uint8(v>>4&0xf) * 17, uint8(v&0xf) * 17, 255}, true
+	case 6:
+		return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, true
+	case 8:
+		return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+	}
+	return nil, false
+}
@@ -0,0 +1,238 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Grayscale ─────────────────────────────────────────────────────────────────
+
+// GrayscaleStep converts the image to grayscale using Rec.709 luma weights.
+//
+// 16-bit sources produce 16-bit output.  A source with transparency
+// produces a gray+alpha image (R=G=B), so cut-outs stay cut out; opaque
+// sources, or any source with DropAlpha, produce a single channel image.
+type GrayscaleStep struct {
+	DropAlpha bool
+}
+
+func (s *GrayscaleStep) Name() string { return "grayscale" }
+
+func (s *GrayscaleStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+
+	keepAlpha := !s.DropAlpha && !isOpaque(src)
+	var dst image.Image
+	if is16Bit(src) {
+		dst = grayscale16(src, keepAlpha)
+	} else {
+		dst = grayscale8(src, keepAlpha)
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceGray
+	out.Meta.HasAlpha = keepAlpha
+	return &out, nil
+}
+
+// Rec.709 luma weights scaled to 1<<16 (they sum to exactly 65536).
+const (
+	lumaR = 13933
+	lumaG = 46871
+	lumaB = 4732
+)
+
+// luma709 returns the Rec.709 luma of straight (non-premultiplied) components.
+// It works for both 8-bit and 16-bit values without overflowing uint32.
+func luma709(r, g, b uint32) uint32 {
+	return (lumaR*r + lumaG*g + lumaB*b + 1<<15) >> 16
+}
+
+// grayscale8 converts src to *image.Gray, or *image.NRGBA when keepAlpha.
+func grayscale8(src image.Image, keepAlpha bool) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var gray *image.Gray
+	var nrgba *image.NRGBA
+	if keepAlpha {
+		nrgba = image.NewNRGBA(image.Rect(0, 0, w, h))
+	} else {
+		gray = image.NewGray(image.Rect(0, 0, w, h))
+	}
+	put := func(x, y int, l, a uint8) {
+		if gray != nil {
+			gray.Pix[y*gray.Stride+x] = l
+			return
+		}
+		i := y*nrgba.Stride + x*4
+		nrgba.Pix[i], nrgba.Pix[i+1], nrgba.Pix[i+2], nrgba.Pix[i+3] = l, l, l, a
+	}
+
+	switch m := src.(type) {
+	case *image.Gray:
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w]
+			for x, l := range row {
+				put(x, y, l, 0xff)
+			}
+		}
+	case *image.NRGBA:
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w*4]
+			for x := 0; x < w; x++ {
+				p := row[x*4 : x*4+4 : x*4+4]
+				put(x, y, uint8(luma709(uint32(p[0]), uint32(p[1]), uint32(p[2]))), p[3])
+			}
+		}
+	case *image.RGBA:
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w*4]
+			for x := 0; x < w; x++ {
+				p := row[x*4 : x*4+4 : x*4+4]
+				r, g, bl, a := uint32(p[0]), uint32(p[1]), uint32(p[2]), uint32(p[3])
+				if a != 0xff && a != 0 {
+					r, g, bl = r*0xff/a, g*0xff/a, bl*0xff/a
+				}
+				put(x, y, uint8(luma709(r, g, bl)), uint8(a))
+			}
+		}
+	case *image.YCbCr:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				yi, ci := m.YOffset(b.Min.X+x, b.Min.Y+y), m.COffset(b.Min.X+x, b.Min.Y+y)
+				r, g, bl := color.YCbCrToRGB(m.Y[yi], m.Cb[ci], m.Cr[ci])
+				put(x, y, uint8(luma709(uint32(r), uint32(g), uint32(bl))), 0xff)
+			}
+		}
+	case *image.Paletted:
+		var lut [256][2]uint8 // luma, alpha per palette index
+		for i, c := range m.Palette {
+			r, g, bl, a := straightRGBA(c)
+			lut[i] = [2]uint8{uint8(luma709(r, g, bl) >> 8), uint8(a >> 8)}
+		}
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w]
+			for x, i := range row {
+				put(x, y, lut[i][0], lut[i][1])
+			}
+		}
+	default:
+		// Only for image types without a pixel buffer of their own.
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, bl, a := straightRGBA(src.At(b.Min.X+x, b.Min.Y+y))
+				put(x, y, uint8(luma709(r, g, bl)>>8), uint8(a>>8))
+			}
+		}
+	}
+
+	if gray != nil {
+		return gray
+	}
+	return nrgba
+}
+
+// grayscale16 converts src to *image.Gray16, or *image.NRGBA64 when keepAlpha.
+func grayscale16(src image.Image, keepAlpha bool) image.Image {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+
+	var gray *image.Gray16
+	var nrgba *image.NRGBA64
+	if keepAlpha {
+		nrgba = image.NewNRGBA64(image.Rect(0, 0, w, h))
+	} else {
+		gray = image.NewGray16(image.Rect(0, 0, w, h))
+	}
+	put := func(x, y int, l, a uint32) {
+		if gray != nil {
+			i := y*gray.Stride + x*2
+			gray.Pix[i], gray.Pix[i+1] = uint8(l>>8), uint8(l)
+			return
+		}
+		i := y*nrgba.Stride + x*8
+		for k := 0; k < 3; k++ {
+			nrgba.Pix[i+2*k], nrgba.Pix[i+2*k+1] = uint8(l>>8), uint8(l)
+		}
+		nrgba.Pix[i+6], nrgba.Pix[i+7] = uint8(a>>8), uint8(a)
+	}
+	be16 := func(p []uint8) uint32 { return uint32(p[0])<<8 | uint32(p[1]) }
+
+	switch m := src.(type) {
+	case *image.Gray16:
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w*2]
+			for x := 0; x < w; x++ {
+				put(x, y, be16(row[x*2:]), 0xffff)
+			}
+		}
+	case *image.NRGBA64:
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w*8]
+			for x := 0; x < w; x++ {
+				p := row[x*8 : x*8+8 : x*8+8]
+				put(x, y, luma709(be16(p[0:]), be16(p[2:]), be16(p[4:])), be16(p[6:]))
+			}
+		}
+	case *image.RGBA64:
+		for y := 0; y < h; y++ {
+			row := m.Pix[y*m.Stride : y*m.Stride+w*8]
+			for x := 0; x < w; x++ {
+				p := row[x*8 : x*8+8 : x*8+8]
+				r, g, bl, a := be16(p[0:]), be16(p[2:]), be16(p[4:]), be16(p[6:])
+				if a != 0xffff && a != 0 {
+					r, g, bl = r*0xffff/a, g*0xffff/a, bl*0xffff/a
+				}
+				put(x, y, luma709(r, g, bl), a)
+			}
+		}
+	default:
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				r, g, bl, a := straightRGBA(src.At(b.Min.X+x, b.Min.Y+y))
+				put(x, y, luma709(r, g, bl), a)
+			}
+		}
+	}
+
+	if gray != nil {
+		return gray
+	}
+	return nrgba
+}
+
+// straightRGBA returns c's 16-bit components with the alpha premultiplication
+// undone.
+func straightRGBA(c color.Color) (r, g, b, a uint32) {
+	r, g, b, a = c.RGBA()
+	if a != 0xffff && a != 0 {
+		r, g, b = r*0xffff/a, g*0xffff/a, b*0xffff/a
+	}
+	return r, g, b, a
+}
+
+// is16Bit reports whether src carries more than 8 bits per channel.
+func is16Bit(src image.Image) bool {
+	switch src.(type) {
+	case *image.Gray16, *image.RGBA64, *image.NRGBA64:
+		return true
+	}
+	return false
+}
+
+// isOpaque reports whether every pixel of src is fully opaque.
+func isOpaque(src image.Image) bool {
+	if o, ok := src.(interface{ Opaque() bool }); ok {
+		return o.Opaque()
+	}
+	return false
+}
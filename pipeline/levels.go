@@ -0,0 +1,98 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Gamma ─────────────────────────────────────────────────────────────────────
+
+// GammaStep applies gamma correction, out = in^(1/Gamma) on 0..1 channels,
+// as libvips' gamma operation does: values above 1 lift the shadows of an
+// underexposed image, values below 1 deepen an overexposed one.  Gamma 0 or
+// 1 leaves the image alone; alpha is kept.  The result is *image.NRGBA.
+// See the vips adapter's VipsGammaStep for the libvips equivalent.
+type GammaStep struct {
+	Gamma float64
+}
+
+func (s *GammaStep) Name() string { return "gamma" }
+
+func (s *GammaStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Gamma == 0 || s.Gamma == 1 {
+		return img, nil
+	}
+	if s.Gamma < 0 {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("gamma %g must be positive", s.Gamma))
+	}
+	var lut [256]uint8
+	for v := range lut {
+		lut[v] = uint8(255*math.Pow(float64(v)/255, 1/s.Gamma) + 0.5)
+	}
+	return applyLUT(img, cloneNRGBA(src), &lut), nil
+}
+
+// ── AutoLevel ─────────────────────────────────────────────────────────────────
+
+// AutoLevelStep stretches the histogram so the darkest Clip of the visible
+// pixels becomes black and the brightest Clip becomes white, fixing washed
+// out or dim uploads before thumbnailing.  The stretch is measured on luma
+// and applied equally to every channel, so hues do not shift.  The result
+// is *image.NRGBA.  See the vips adapter's VipsAutoLevelStep for the libvips
+// equivalent.
+type AutoLevelStep struct {
+	// Clip is the fraction of pixels allowed to saturate at each end.
+	// Default 0.005; negative means none.
+	Clip float64
+}
+
+func (s *AutoLevelStep) Name() string { return "auto_level" }
+
+func (s *AutoLevelStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	clip := s.Clip
+	if clip == 0 {
+		clip = 0.005
+	}
+	clip = clampf(clip, 0, 0.49)
+
+	m := cloneNRGBA(src)
+	var identity [3][256]float64
+	for v := range identity[0] {
+		identity[0][v], identity[1][v], identity[2][v] = float64(v), float64(v), float64(v)
+	}
+	lo, hi := lumaPercentiles(m, &identity, clip)
+	if hi <= lo || (lo == 0 && hi == 255) {
+		return img, nil
+	}
+	var lut [256]uint8
+	for v := range lut {
+		lut[v] = uint8(clampf((float64(v)-lo)*255/(hi-lo), 0, 255) + 0.5)
+	}
+	return applyLUT(img, m, &lut), nil
+}
+
+// applyLUT maps the colour channels of dst through lut in place, keeping
+// alpha, and returns img carrying dst.
+func applyLUT(img *core.ImageData, dst *image.NRGBA, lut *[256]uint8) *core.ImageData {
+	pix := dst.Pix
+	for i := 0; i+3 < len(pix); i += 4 {
+		pix[i], pix[i+1], pix[i+2] = lut[pix[i]], lut[pix[i+1]], lut[pix[i+2]]
+	}
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out
+}
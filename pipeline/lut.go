@@ -0,0 +1,226 @@
+package pipeline
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── LUT ───────────────────────────────────────────────────────────────────────
+
+// CubeLUT is a 3D colour lookup table in the Adobe/Resolve .cube format.
+// It is immutable once parsed and may be shared between steps.
+type CubeLUT struct {
+	Title string
+	// Size is the number of samples along each axis.
+	Size int
+	// DomainMin and DomainMax bound the input range; default 0 and 1.
+	DomainMin, DomainMax [3]float64
+	// Table holds Size³ output colours with red varying fastest, then
+	// green, then blue.
+	Table [][3]float64
+}
+
+// maxCubeSize bounds LUT_3D_SIZE; 256³ entries is already 400 MiB.
+const maxCubeSize = 256
+
+// LoadCubeLUT reads a .cube file from path.
+func LoadCubeLUT(path string) (*CubeLUT, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, "lut.load", err)
+	}
+	defer f.Close()
+	return ParseCubeLUT(f)
+}
+
+// ParseCubeLUT parses a 3D LUT in .cube format.  1D LUTs are rejected.
+func ParseCubeLUT(r io.Reader) (*CubeLUT, error) {
+	fail := func(line int, format string, args ...any) (*CubeLUT, error) {
+		return nil, apperrors.New(apperrors.CategoryConfig, "lut.parse",
+			fmt.Errorf("line %d: "+format, append([]any{line}, args...)...))
+	}
+	lut := &CubeLUT{DomainMax: [3]float64{1, 1, 1}}
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		text := strings.TrimSpace(sc.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		switch key := fields[0]; key {
+		case "TITLE":
+			lut.Title = strings.Trim(strings.TrimSpace(strings.TrimPrefix(text, "TITLE")), `"`)
+			continue
+		case "LUT_1D_SIZE", "LUT_1D_INPUT_RANGE":
+			return fail(line, "1D LUTs are not supported")
+		case "LUT_3D_SIZE":
+			if len(fields) != 2 {
+				return fail(line, "malformed %s", key)
+			}
+			n, err := strconv.Atoi(fields[1])
+			if err != nil || n < 2 || n > maxCubeSize {
+				return fail(line, "LUT_3D_SIZE %q outside 2-%d", fields[1], maxCubeSize)
+			}
+			lut.Size = n
+			lut.Table = make([][3]float64, 0, n*n*n)
+			continue
+		case "DOMAIN_MIN", "DOMAIN_MAX", "LUT_3D_INPUT_RANGE":
+			want := 4
+			if key == "LUT_3D_INPUT_RANGE" {
+				want = 3 // min max, shared by all channels
+			}
+			if len(fields) != want {
+				return fail(line, "malformed %s", key)
+			}
+			var v [3]float64
+			for i := range v {
+				f, err := strconv.ParseFloat(fields[min(i+1, len(fields)-1)], 64)
+				if err != nil {
+					return fail(line, "malformed %s: %v", key, err)
+				}
+				v[i] = f
+			}
+			switch key {
+			case "DOMAIN_MIN":
+				lut.DomainMin = v
+			case "DOMAIN_MAX":
+				lut.DomainMax = v
+			default:
+				lut.DomainMin = [3]float64{v[0], v[0], v[0]}
+				lut.DomainMax = [3]float64{v[1], v[1], v[1]}
+			}
+			continue
+		}
+		if len(fields) != 3 {
+			return fail(line, "unexpected %q", text)
+		}
+		if lut.Size == 0 {
+			return fail(line, "table entry before LUT_3D_SIZE")
+		}
+		var c [3]float64
+		for i, s := range fields {
+			f, err := strconv.ParseFloat(s, 64)
+			if err != nil {
+				return fail(line, "malformed table entry: %v", err)
+			}
+			c[i] = f
+		}
+		if len(lut.Table) == cap(lut.Table) {
+			return fail(line, "more than %d table entries", cap(lut.Table))
+		}
+		lut.Table = append(lut.Table, c)
+	}
+	if err := sc.Err(); err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, "lut.parse", err)
+	}
+	if lut.Size == 0 {
+		return fail(line, "missing LUT_3D_SIZE")
+	}
+	if n := lut.Size * lut.Size * lut.Size; len(lut.Table) != n {
+		return fail(line, "%d table entries, want %d", len(lut.Table), n)
+	}
+	for i := range 3 {
+		if lut.DomainMax[i] <= lut.DomainMin[i] {
+			return fail(line, "empty domain on channel %d", i)
+		}
+	}
+	return lut, nil
+}
+
+// At maps an RGB colour with 0..1 channels through the table using
+// trilinear interpolation.  Inputs outside the domain are clamped.
+func (l *CubeLUT) At(r, g, b float64) (float64, float64, float64) {
+	n := l.Size
+	var idx [3]int
+	var frac [3]float64
+	for i, v := range [3]float64{r, g, b} {
+		v = (v - l.DomainMin[i]) / (l.DomainMax[i] - l.DomainMin[i])
+		v = clampf(v, 0, 1) * float64(n-1)
+		idx[i] = min(int(v), n-2)
+		frac[i] = v - float64(idx[i])
+	}
+	at := func(dr, dg, db int) [3]float64 {
+		return l.Table[(idx[2]+db)*n*n+(idx[1]+dg)*n+idx[0]+dr]
+	}
+	lerp := func(a, b [3]float64, t float64) [3]float64 {
+		return [3]float64{a[0] + (b[0]-a[0])*t, a[1] + (b[1]-a[1])*t, a[2] + (b[2]-a[2])*t}
+	}
+	c00 := lerp(at(0, 0, 0), at(1, 0, 0), frac[0])
+	c10 := lerp(at(0, 1, 0), at(1, 1, 0), frac[0])
+	c01 := lerp(at(0, 0, 1), at(1, 0, 1), frac[0])
+	c11 := lerp(at(0, 1, 1), at(1, 1, 1), frac[0])
+	c := lerp(lerp(c00, c10, frac[1]), lerp(c01, c11, frac[1]), frac[2])
+	return c[0], c[1], c[2]
+}
+
+// LUTStep colour-grades the image through a 3D LUT, for example a brand
+// look exported from DaVinci Resolve or Photoshop as .cube.  Intensity
+// blends the graded result with the original.  The result is *image.NRGBA
+// with alpha preserved.
+type LUTStep struct {
+	LUT *CubeLUT
+	// Intensity is the strength of the grade, 0..1.  Default 1; negative
+	// means none.
+	Intensity float64
+}
+
+func (s *LUTStep) Name() string { return "lut" }
+
+func (s *LUTStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.LUT == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("no LUT configured"))
+	}
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	t := s.Intensity
+	if t == 0 {
+		t = 1
+	}
+	if t < 0 {
+		return img, nil
+	}
+	t = math.Min(t, 1)
+
+	dst := cloneNRGBA(src)
+	pix := dst.Pix
+	// Identical colours are common (flat backgrounds), so remember the last.
+	var lastIn [3]uint8
+	var lastOut [3]uint8
+	have := false
+	for i := 0; i+3 < len(pix); i += 4 {
+		if i%(4<<16) == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+			}
+		}
+		in := [3]uint8{pix[i], pix[i+1], pix[i+2]}
+		if !have || in != lastIn {
+			r, g, b := s.LUT.At(float64(in[0])/255, float64(in[1])/255, float64(in[2])/255)
+			lastOut = [3]uint8{
+				blend8(in[0], clampf(r, 0, 1)*255, t),
+				blend8(in[1], clampf(g, 0, 1)*255, t),
+				blend8(in[2], clampf(b, 0, 1)*255, t),
+			}
+			lastIn, have = in, true
+		}
+		pix[i], pix[i+1], pix[i+2] = lastOut[0], lastOut[1], lastOut[2]
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
@@ -0,0 +1,103 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── RoundCorners / CircleMask ─────────────────────────────────────────────────
+
+// RoundCornersStep makes the corners outside a quarter circle of Radius
+// pixels transparent, with anti-aliased edges, for card images.  The result
+// is *image.NRGBA; a target format without alpha (JPEG) is switched to PNG
+// so the transparency survives encoding.
+type RoundCornersStep struct {
+	Radius int
+}
+
+func (s *RoundCornersStep) Name() string { return "round_corners" }
+
+func (s *RoundCornersStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Radius <= 0 {
+		return img, nil
+	}
+	dst := cloneNRGBA(src)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	r := float64(min(s.Radius, w/2, h/2))
+	ri := int(math.Ceil(r))
+	for y := 0; y < h; y++ {
+		// Distance into the corner band, measured from the nearer edge.
+		cy := r - (float64(min(y, h-1-y)) + 0.5)
+		if cy <= 0 {
+			continue
+		}
+		for x := 0; x < w; x++ {
+			if x >= ri && x < w-ri {
+				x = w - ri - 1
+				continue
+			}
+			cx := r - (float64(min(x, w-1-x)) + 0.5)
+			if cx <= 0 {
+				continue
+			}
+			scaleAlpha(dst, x, y, coverage(r, math.Hypot(cx, cy)))
+		}
+	}
+	return maskedResult(img, dst), nil
+}
+
+// CircleMaskStep keeps the largest centred circle and makes the rest
+// transparent, for avatars; crop to a square first (ThumbnailStep,
+// FaceCropStep) for a full circle.  Output as RoundCornersStep.
+type CircleMaskStep struct{}
+
+func (s *CircleMaskStep) Name() string { return "circle_mask" }
+
+func (s *CircleMaskStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	dst := cloneNRGBA(src)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	r := float64(min(w, h)) / 2
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			d := math.Hypot(float64(x)+0.5-float64(w)/2, float64(y)+0.5-float64(h)/2)
+			scaleAlpha(dst, x, y, coverage(r, d))
+		}
+	}
+	return maskedResult(img, dst), nil
+}
+
+// coverage approximates how much of a pixel whose centre lies d from the
+// centre of a circle of radius r falls inside it.
+func coverage(r, d float64) float64 { return clampf(r-d+0.5, 0, 1) }
+
+func scaleAlpha(m *image.NRGBA, x, y int, f float64) {
+	if f < 1 {
+		i := m.PixOffset(x, y) + 3
+		m.Pix[i] = uint8(float64(m.Pix[i])*f + 0.5)
+	}
+}
+
+// maskedResult wraps a masked image, switching to PNG when the target
+// format cannot store alpha.
+func maskedResult(img *core.ImageData, dst *image.NRGBA) *core.ImageData {
+	out := *img
+	out.Image = dst
+	out.Meta.HasAlpha = true
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	if !out.Format.SupportsAlpha() {
+		out.Format = core.FormatPNG
+	}
+	return &out
+}
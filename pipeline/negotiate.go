@@ -0,0 +1,128 @@
+package pipeline
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Negotiate format ──────────────────────────────────────────────────────────
+
+// DefaultPreference is the order in which NegotiateFormatStep offers
+// formats when Preference is empty: smallest output first.
+var DefaultPreference = []core.Format{core.FormatAVIF, core.FormatWebP}
+
+// NegotiateFormatStep picks the output format from a browser's Accept
+// header, for pipelines that serve one URL to every client:
+//
+//	pipeline.NegotiateFormatStep{Accept: r.Header.Get("Accept")}
+//
+// It takes the first format in Preference that Accept names explicitly
+// with a non-zero q and that the registry has an encoder for; among those,
+// a higher q wins over preference order.  Wildcards such as image/* are
+// not enough, since clients sending only them may not decode newer
+// formats.  Encoders whose capabilities cannot hold the image are skipped:
+// without alpha for a transparent image, without animation for an
+// animated one, or with a smaller size limit.  When nothing fits, the
+// image is encoded as Fallback, or when that is empty as its source
+// format if encodable and JPEG otherwise.  Responses should carry
+// "Vary: Accept".
+type NegotiateFormatStep struct {
+	Registry   core.Registry
+	Accept     string
+	Preference []core.Format // default DefaultPreference
+	Fallback   core.Format
+}
+
+func (s *NegotiateFormatStep) Name() string { return "negotiate_format" }
+
+// BindRegistry implements core.RegistryBinder.
+func (s *NegotiateFormatStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
+func (s *NegotiateFormatStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Registry == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
+	out := *img
+	out.Format = s.choose(img)
+	out.Meta.Format = out.Format
+	return &out, nil
+}
+
+func (s *NegotiateFormatStep) choose(img *core.ImageData) core.Format {
+	caps := core.RegistryCapabilities(s.Registry)
+	fits := func(f core.Format) bool {
+		enc, ok := caps.Encoder(f)
+		if !ok {
+			return false
+		}
+		if !enc.Alpha && HasAlpha().Holds(img) {
+			return false
+		}
+		if _, animated := img.Image.(*core.Animation); animated && !enc.Animation {
+			return false
+		}
+		w, h := img.Meta.Width, img.Meta.Height
+		if img.Image != nil {
+			w, h = img.Image.Bounds().Dx(), img.Image.Bounds().Dy()
+		}
+		return (enc.MaxWidth == 0 || w <= enc.MaxWidth) && (enc.MaxHeight == 0 || h <= enc.MaxHeight)
+	}
+
+	accepted := parseAccept(s.Accept)
+	preference := s.Preference
+	if len(preference) == 0 {
+		preference = DefaultPreference
+	}
+	best, bestQ := core.Format(""), 0.0
+	for _, f := range preference {
+		if q := accepted[f.ContentType()]; q > bestQ && fits(f) {
+			best, bestQ = f, q
+		}
+	}
+	switch {
+	case best != "":
+		return best
+	case s.Fallback != "":
+		return s.Fallback
+	case caps.CanEncode(img.Format) && fits(img.Format):
+		return img.Format
+	}
+	return core.FormatJPEG
+}
+
+// parseAccept maps the media types named in an Accept header to their q
+// values, lower-cased.  Wildcard ranges are dropped.
+func parseAccept(header string) map[string]float64 {
+	accepted := map[string]float64{}
+	for _, part := range strings.Split(header, ",") {
+		params := strings.Split(part, ";")
+		typ := strings.ToLower(strings.TrimSpace(params[0]))
+		if typ == "" || strings.Contains(typ, "*") {
+			continue
+		}
+		q := 1.0
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			if strings.EqualFold(k, "q") {
+				if f, err := strconv.ParseFloat(v, 64); err == nil && f >= 0 && f <= 1 {
+					q = f
+				}
+			}
+		}
+		if prev, ok := accepted[typ]; !ok || q > prev {
+			accepted[typ] = q
+		}
+	}
+	return accepted
+}
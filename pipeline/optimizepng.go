@@ -0,0 +1,143 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Optimize PNG ──────────────────────────────────────────────────────────────
+
+// OptimizePNGStep shrinks an already encoded PNG, in the manner of oxipng
+// and pngquant, for screenshots and UI graphics that encoders write with
+// fast settings.  It decodes img.Data and re-encodes it at zlib level 9
+// with ancillary chunks stripped, the smallest lossless colour type and
+// several row filters; unless Lossless is set it also tries a palette of
+// Colors colours.  Every attempt is decoded again and kept only if it
+// matches the original: quantized ones need MinSSIM, the others must be
+// practically identical.  The smallest passing output wins, or the input
+// when nothing beats it.  Attempts are recorded in Meta.Candidates.  Other
+// formats pass through unchanged.
+type OptimizePNGStep struct {
+	Registry core.Registry
+	// Lossless skips the quantization attempt.
+	Lossless bool
+	Colors   int     // 2-256; default 256
+	MinSSIM  float64 // for the quantized attempt; default 0.99
+}
+
+func (s *OptimizePNGStep) Name() string { return "optimize_png" }
+
+// BindRegistry implements core.RegistryBinder.
+func (s *OptimizePNGStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
+// losslessSSIM is the floor for attempts that should not change a pixel;
+// it only allows for rounding in the comparison.
+const losslessSSIM = 0.9999
+
+func (s *OptimizePNGStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Registry == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
+	if img.Format != core.FormatPNG {
+		return img, nil
+	}
+	if len(img.Data) == 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(),
+			fmt.Errorf("%w: optimize_png needs an encoded PNG", apperrors.ErrEmptyInput))
+	}
+	colors, minSSIM := s.Colors, s.MinSSIM
+	if colors == 0 {
+		colors = 256
+	}
+	if minSSIM == 0 {
+		minSSIM = 0.99
+	}
+	if colors < 2 || colors > 256 || minSSIM < 0 || minSSIM > 1 {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(),
+			fmt.Errorf("colors %d and min SSIM %v out of range", colors, minSSIM))
+	}
+
+	orig, err := (&DecodeStep{Registry: s.Registry}).Execute(ctx, &core.ImageData{Data: img.Data, Format: core.FormatPNG})
+	if err != nil {
+		return nil, err
+	}
+
+	type attempt struct {
+		png   core.PNGOptions
+		floor float64
+	}
+	var attempts []attempt
+	for _, f := range []core.PNGFilter{core.PNGFilterAdaptive, core.PNGFilterNone, core.PNGFilterPaeth} {
+		attempts = append(attempts, attempt{
+			png:   core.PNGOptions{CompressionLevel: 9, Filter: f, Reduce: true, StripMetadata: true},
+			floor: losslessSSIM,
+		})
+	}
+	if !s.Lossless {
+		attempts = append(attempts, attempt{
+			png:   core.PNGOptions{CompressionLevel: 9, Colors: colors, Reduce: true, StripMetadata: true},
+			floor: minSSIM,
+		})
+	}
+
+	var (
+		best       []byte
+		candidates = []core.EncodeCandidate{{Format: core.FormatPNG, Size: int64(len(img.Data)), SSIM: 1}}
+	)
+	for _, a := range attempts {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+		}
+		start := time.Now()
+		opts := core.EncodeOptions{StripEXIF: true}
+		*opts.PNG() = a.png
+		out, err := (&EncodeStep{Registry: s.Registry, BaseOptions: opts}).Execute(ctx, orig)
+		if err != nil {
+			return nil, err
+		}
+		c := core.EncodeCandidate{Format: core.FormatPNG, Size: int64(len(out.Data))}
+		if c.Size < int64(len(img.Data)) && (best == nil || c.Size < int64(len(best))) {
+			if c.SSIM, err = measureSSIM(ctx, s.Registry, s.Name(), orig, out); err != nil {
+				return nil, err
+			}
+		}
+		c.Duration = time.Since(start)
+		switch {
+		case c.SSIM == 0:
+			c.Rejected = "not smaller"
+		case c.SSIM < a.floor:
+			c.Rejected = fmt.Sprintf("SSIM %.4f below %.4f", c.SSIM, a.floor)
+		default:
+			best = out.Data
+		}
+		candidates = append(candidates, c)
+	}
+
+	winner := len(candidates) - 1
+	for winner > 0 && candidates[winner].Rejected != "" {
+		winner--
+	}
+	for i := range candidates {
+		if i != winner && candidates[i].Rejected == "" {
+			candidates[i].Rejected = "larger than the smallest"
+		}
+	}
+	out := *img
+	if best != nil {
+		out.Data = best
+		out.Meta.SizeBytes = int64(len(best))
+	}
+	out.Meta.Candidates = candidates
+	return &out, nil
+}
@@ -0,0 +1,152 @@
+package pipeline
+
+import (
+	"context"
+	"image/color"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Vignette ──────────────────────────────────────────────────────────────────
+
+// VignetteStep darkens the image towards its corners, drawing the eye to the
+// centre and giving overlaid text a calmer frame.  The falloff is
+// elliptical, following the image's aspect ratio, and starts halfway to the
+// corners.  The result is *image.NRGBA.
+type VignetteStep struct {
+	// Strength is how much the corners darken, 0..1.  Default 0.5.
+	Strength float64
+}
+
+func (s *VignetteStep) Name() string { return "vignette" }
+
+func (s *VignetteStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	strength := s.Strength
+	if strength == 0 {
+		strength = 0.5
+	}
+	strength = clampf(strength, 0, 1)
+
+	dst := cloneNRGBA(src)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	cx, cy := float64(w)/2, float64(h)/2
+	for y := 0; y < h; y++ {
+		dy := (float64(y) + 0.5 - cy) / cy
+		for x := 0; x < w; x++ {
+			dx := (float64(x) + 0.5 - cx) / cx
+			// d is 0 at the centre and 1 at the corners.
+			d := math.Sqrt((dx*dx + dy*dy) / 2)
+			t := clampf((d-0.5)/0.5, 0, 1)
+			f := 1 - strength*t*t*(3-2*t)
+			i := dst.PixOffset(x, y)
+			dst.Pix[i] = uint8(float64(dst.Pix[i])*f + 0.5)
+			dst.Pix[i+1] = uint8(float64(dst.Pix[i+1])*f + 0.5)
+			dst.Pix[i+2] = uint8(float64(dst.Pix[i+2])*f + 0.5)
+		}
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
+
+// ── GradientOverlay ───────────────────────────────────────────────────────────
+
+// GradientOverlayStep paints a linear gradient over the image, e.g. a scrim
+// from transparent to dark behind a caption.  Direction is the side the
+// gradient runs towards: From lies on the opposite side and To on the
+// Direction side, so core.GravitySouth darkens the bottom for a caption
+// placed there; corner gravities run diagonally and core.GravityCenter
+// runs radially from From at the centre to To at the corners.  Colours
+// mix premultiplied and are composited over the image.  The result is
+// *image.NRGBA.
+type GradientOverlayStep struct {
+	// From and To default to transparent and 60% black.
+	From, To  color.Color
+	Direction core.Gravity // default core.GravitySouth
+}
+
+func (s *GradientOverlayStep) Name() string { return "gradient_overlay" }
+
+func (s *GradientOverlayStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	from, to := s.From, s.To
+	if from == nil {
+		from = color.Transparent
+	}
+	if to == nil {
+		to = color.NRGBA{A: 153}
+	}
+	premul := func(c color.Color) [4]float64 {
+		r, g, b, a := c.RGBA()
+		return [4]float64{float64(r) / 0xffff, float64(g) / 0xffff, float64(b) / 0xffff, float64(a) / 0xffff}
+	}
+	c0, c1 := premul(from), premul(to)
+
+	// (vx, vy) points towards Direction; t runs 0..1 along it.
+	dir := s.Direction
+	if dir == "" {
+		dir = core.GravitySouth
+	}
+	var vx, vy float64
+	switch dir {
+	case core.GravityEast, core.GravityNorthEast, core.GravitySouthEast:
+		vx = 1
+	case core.GravityWest, core.GravityNorthWest, core.GravitySouthWest:
+		vx = -1
+	}
+	switch dir {
+	case core.GravitySouth, core.GravitySouthEast, core.GravitySouthWest:
+		vy = 1
+	case core.GravityNorth, core.GravityNorthEast, core.GravityNorthWest:
+		vy = -1
+	}
+
+	dst := cloneNRGBA(src)
+	w, h := dst.Rect.Dx(), dst.Rect.Dy()
+	for y := 0; y < h; y++ {
+		// u and v are -1..1 across the image.
+		v := (float64(y)+0.5)/float64(h)*2 - 1
+		for x := 0; x < w; x++ {
+			u := (float64(x)+0.5)/float64(w)*2 - 1
+			var t float64
+			if vx == 0 && vy == 0 {
+				t = math.Sqrt((u*u + v*v) / 2)
+			} else {
+				t = ((u*vx+v*vy)/(math.Abs(vx)+math.Abs(vy)) + 1) / 2
+			}
+			t = clampf(t, 0, 1)
+			var g [4]float64
+			for k := range g {
+				g[k] = c0[k] + (c1[k]-c0[k])*t
+			}
+			if g[3] == 0 {
+				continue
+			}
+			i := dst.PixOffset(x, y)
+			p := dst.Pix[i : i+4 : i+4]
+			ab := float64(p[3]) / 255
+			ao := g[3] + ab*(1-g[3])
+			for c := 0; c < 3; c++ {
+				co := g[c] + float64(p[c])/255*ab*(1-g[3])
+				p[c] = uint8(clampf(co/ao, 0, 1)*255 + 0.5)
+			}
+			p[3] = uint8(ao*255 + 0.5)
+		}
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
@@ -0,0 +1,129 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Perceptual compress ───────────────────────────────────────────────────────
+
+// PerceptualCompressStep encodes the image at the lowest quality whose
+// output still reaches TargetSSIM against the unencoded image, for
+// "visually lossless but smallest" policies; AdaptiveCompressStep aims at
+// a byte budget instead.  The quality is found by binary search between
+// MinQuality and MaxQuality, decoding each attempt to measure it, so the
+// step costs several encodes.  When even MaxQuality falls short, its
+// output is kept.  Lossless formats are encoded once as they are.  The
+// attempts are recorded in Meta.Candidates and the chosen quality is left
+// as the core.AttrQuality directive.
+//
+// A DSSIM budget d corresponds to TargetSSIM 1/(1+d).
+type PerceptualCompressStep struct {
+	Registry   core.Registry
+	TargetSSIM float64 // in (0,1]; default 0.98
+	MinQuality int     // default 30
+	MaxQuality int     // default 95
+}
+
+func (s *PerceptualCompressStep) Name() string { return "perceptual_compress" }
+
+// BindRegistry implements core.RegistryBinder.
+func (s *PerceptualCompressStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
+func (s *PerceptualCompressStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Registry == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
+	target, minQ, maxQ := s.TargetSSIM, s.MinQuality, s.MaxQuality
+	if target == 0 {
+		target = 0.98
+	}
+	if minQ <= 0 {
+		minQ = 30
+	}
+	if maxQ <= 0 {
+		maxQ = 95
+	}
+	if target < 0 || target > 1 || minQ > maxQ || maxQ > 100 {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(),
+			fmt.Errorf("target SSIM %v and qualities %d-%d out of range", target, minQ, maxQ))
+	}
+	enc := &EncodeStep{Registry: s.Registry}
+	switch img.Format {
+	case core.FormatJPEG, core.FormatWebP, core.FormatAVIF, core.FormatHEIF, core.FormatJXL:
+	default:
+		return enc.Execute(ctx, img)
+	}
+
+	var (
+		candidates []core.EncodeCandidate
+		outputs    = map[int]*core.ImageData{}
+		chosen     = -1
+	)
+	try := func(q int) (bool, error) {
+		if err := ctx.Err(); err != nil {
+			return false, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+		}
+		start := time.Now()
+		in := *img
+		in.Attrs = img.Attrs.With(core.AttrQuality, q)
+		out, err := enc.Execute(ctx, &in)
+		if err != nil {
+			return false, err
+		}
+		ssim, err := measureSSIM(ctx, s.Registry, s.Name(), img, out)
+		if err != nil {
+			return false, err
+		}
+		outputs[q] = out
+		candidates = append(candidates, core.EncodeCandidate{
+			Format: img.Format, Quality: q, Size: int64(len(out.Data)), SSIM: ssim, Duration: time.Since(start),
+		})
+		return ssim >= target, nil
+	}
+	for lo, hi := minQ, maxQ; lo <= hi; {
+		q := (lo + hi) / 2
+		ok, err := try(q)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			chosen, hi = q, q-1
+		} else {
+			lo = q + 1
+		}
+	}
+	if chosen < 0 {
+		chosen = maxQ
+		if outputs[maxQ] == nil {
+			if _, err := try(maxQ); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	for i, c := range candidates {
+		switch {
+		case c.Quality == chosen:
+		case c.SSIM < target:
+			candidates[i].Rejected = fmt.Sprintf("SSIM %.4f below %.4f", c.SSIM, target)
+		default:
+			candidates[i].Rejected = fmt.Sprintf("quality %d is enough", chosen)
+		}
+	}
+	out := outputs[chosen]
+	out.Attrs = img.Attrs.With(core.AttrQuality, chosen)
+	out.Meta.Candidates = candidates
+	return out, nil
+}
@@ -0,0 +1,116 @@
+package pipeline
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/utils"
+)
+
+// ── Perspective ───────────────────────────────────────────────────────────────
+
+// PerspectiveStep warps the quadrilateral Quad onto an upright rectangle,
+// undoing the keystone of a receipt, ID card or whiteboard photographed at
+// an angle.  Quad lists the corners in image coordinates, clockwise from
+// top-left: top-left, top-right, bottom-right, bottom-left.  Sampling is
+// bilinear.  The result is *image.NRGBA.  See the vips adapter's
+// VipsPerspectiveStep for the libvips equivalent.
+type PerspectiveStep struct {
+	Quad [4]image.Point
+	// Width and Height size the output.  Zero uses the longer of each pair
+	// of opposite edges of Quad.
+	Width, Height int
+	// Background fills output pixels that map outside the source.  Default
+	// transparent.
+	Background color.Color
+}
+
+func (s *PerspectiveStep) Name() string { return "perspective" }
+
+func (s *PerspectiveStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	qw, qh := utils.QuadSize(s.Quad)
+	w, h := cmp.Or(s.Width, qw), cmp.Or(s.Height, qh)
+	if w <= 0 || h <= 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+	// Quad is in image coordinates; sampling works on zero-origin indices.
+	b := src.Bounds()
+	quad := s.Quad
+	for i := range quad {
+		quad[i] = quad[i].Sub(b.Min)
+	}
+	m, ok := utils.PerspectiveMatrix(quad, w, h)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("degenerate quad %v", s.Quad))
+	}
+
+	in := cloneNRGBA(src)
+	var bg [4]float64
+	if s.Background != nil {
+		c := color.NRGBAModel.Convert(s.Background).(color.NRGBA)
+		a := float64(c.A) / 255
+		bg = [4]float64{float64(c.R) * a, float64(c.G) * a, float64(c.B) * a, float64(c.A)}
+	}
+	// texel returns the premultiplied colour of source index (x, y).
+	texel := func(x, y int) [4]float64 {
+		if x < 0 || y < 0 || x >= b.Dx() || y >= b.Dy() {
+			return bg
+		}
+		i := in.PixOffset(x, y)
+		a := float64(in.Pix[i+3]) / 255
+		return [4]float64{float64(in.Pix[i]) * a, float64(in.Pix[i+1]) * a, float64(in.Pix[i+2]) * a, float64(in.Pix[i+3])}
+	}
+
+	dst := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		if y%64 == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+			}
+		}
+		for x := 0; x < w; x++ {
+			fx, fy := float64(x), float64(y)
+			den := m[6]*fx + m[7]*fy + m[8]
+			sx := (m[0]*fx + m[1]*fy + m[2]) / den
+			sy := (m[3]*fx + m[4]*fy + m[5]) / den
+			x0, y0 := int(math.Floor(sx)), int(math.Floor(sy))
+			tx, ty := sx-float64(x0), sy-float64(y0)
+			c00, c10 := texel(x0, y0), texel(x0+1, y0)
+			c01, c11 := texel(x0, y0+1), texel(x0+1, y0+1)
+			var c [4]float64
+			for k := range c {
+				top := c00[k] + (c10[k]-c00[k])*tx
+				bot := c01[k] + (c11[k]-c01[k])*tx
+				c[k] = top + (bot-top)*ty
+			}
+			i := dst.PixOffset(x, y)
+			if c[3] <= 0 {
+				continue
+			}
+			un := 255 / c[3]
+			dst.Pix[i] = uint8(clampf(c[0]*un, 0, 255) + 0.5)
+			dst.Pix[i+1] = uint8(clampf(c[1]*un, 0, 255) + 0.5)
+			dst.Pix[i+2] = uint8(clampf(c[2]*un, 0, 255) + 0.5)
+			dst.Pix[i+3] = uint8(clampf(c[3], 0, 255) + 0.5)
+		}
+	}
+
+	out := *img
+	out.Image = dst
+	out.Meta.Width = w
+	out.Meta.Height = h
+	if !isOpaque(dst) {
+		out.Meta.HasAlpha = true
+	}
+	return &out, nil
+}
@@ -11,10 +11,11 @@ import (
 
 // Pipeline executes a sequence of Steps with hook and retry support.
 type Pipeline struct {
-	steps      []core.Step
-	hooks      []core.Hook
-	maxRetries int
-	retryDelay time.Duration
+	steps       []core.Step
+	hooks       []core.Hook
+	maxRetries  int
+	retryDelay  time.Duration
+	stepTimeout time.Duration
 }
 
 // New returns an empty Pipeline.
@@ -39,6 +40,13 @@ func (p *Pipeline) WithRetry(maxRetries int, delay time.Duration) *Pipeline {
 	return p
 }
 
+// WithStepTimeout limits each step to d (0 = no limit) unless it sets its
+// own through core.TimeoutHinter; see core.ExecuteStep.
+func (p *Pipeline) WithStepTimeout(d time.Duration) *Pipeline {
+	p.stepTimeout = d
+	return p
+}
+
 // Run executes the pipeline on img.  It returns the final ImageData and a map
 // of per-step timing observations.
 func (p *Pipeline) Run(ctx context.Context, img *core.ImageData) (*core.ImageData, map[string]time.Duration, error) {
@@ -73,7 +81,7 @@ func (p *Pipeline) runStep(ctx context.Context, step core.Step, img *core.ImageD
 	attempts := p.maxRetries + 1
 	for i := 0; i < attempts; i++ {
 		start := time.Now()
-		result, err = step.Execute(ctx, img)
+		result, err = core.ExecuteStep(ctx, step, img, p.stepTimeout)
 		elapsed = time.Since(start)
 
 		if err == nil {
@@ -112,10 +120,11 @@ func (p *Pipeline) callHooksAfter(ctx context.Context, name string, img *core.Im
 // safely across goroutines.
 func (p *Pipeline) Clone() *Pipeline {
 	cp := &Pipeline{
-		steps:      make([]core.Step, len(p.steps)),
-		hooks:      make([]core.Hook, len(p.hooks)),
-		maxRetries: p.maxRetries,
-		retryDelay: p.retryDelay,
+		steps:       make([]core.Step, len(p.steps)),
+		hooks:       make([]core.Hook, len(p.hooks)),
+		maxRetries:  p.maxRetries,
+		retryDelay:  p.retryDelay,
+		stepTimeout: p.stepTimeout,
 	}
 	copy(cp.steps, p.steps)
 	copy(cp.hooks, p.hooks)
@@ -0,0 +1,205 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Redaction ─────────────────────────────────────────────────────────────────
+
+// PixelateRegionStep obscures Rects, e.g. faces or licence plates, by
+// replacing each BlockSize×BlockSize block inside them with its average
+// colour.  Rects are in image coordinates (the output of a
+// core.FaceDetector can be passed straight in) and are clipped to the
+// image; with no Rects the step does nothing.  The result is *image.NRGBA.
+type PixelateRegionStep struct {
+	Rects []image.Rectangle
+	// BlockSize is the block edge in pixels.  Default 1/8 of the shorter
+	// side of each rectangle, at least 4.
+	BlockSize int
+}
+
+func (s *PixelateRegionStep) Name() string { return "pixelate_region" }
+
+func (s *PixelateRegionStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	rects := clipRects(s.Rects, src.Bounds())
+	if len(rects) == 0 {
+		return img, nil
+	}
+	dst := cloneNRGBA(src)
+	for _, r := range rects {
+		r = r.Sub(src.Bounds().Min)
+		bs := s.BlockSize
+		if bs <= 0 {
+			bs = max(4, min(r.Dx(), r.Dy())/8)
+		}
+		for by := r.Min.Y; by < r.Max.Y; by += bs {
+			for bx := r.Min.X; bx < r.Max.X; bx += bs {
+				block := image.Rect(bx, by, bx+bs, by+bs).Intersect(r)
+				fillNRGBA(dst, block, averageNRGBA(dst, block))
+			}
+		}
+	}
+	out := *img
+	out.Image = dst
+	return &out, nil
+}
+
+// BlurRegionStep obscures Rects with a Gaussian blur.  The blur only
+// samples pixels inside each rectangle, so nothing outside bleeds in and
+// the redacted content does not bleed out.  Rects are in image coordinates
+// and clipped to the image; with no Rects the step does nothing.  The
+// result is *image.NRGBA.
+type BlurRegionStep struct {
+	Rects []image.Rectangle
+	// Sigma is the blur standard deviation in pixels.  Default 1/6 of the
+	// shorter side of each rectangle, at least 2.
+	Sigma float64
+}
+
+func (s *BlurRegionStep) Name() string { return "blur_region" }
+
+func (s *BlurRegionStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	rects := clipRects(s.Rects, src.Bounds())
+	if len(rects) == 0 {
+		return img, nil
+	}
+	dst := cloneNRGBA(src)
+	for _, r := range rects {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		sigma := s.Sigma
+		if sigma <= 0 {
+			sigma = math.Max(2, float64(min(r.Dx(), r.Dy()))/6)
+		}
+		blurNRGBA(dst, r.Sub(src.Bounds().Min), sigma)
+	}
+	out := *img
+	out.Image = dst
+	return &out, nil
+}
+
+// clipRects returns the non-empty intersections of rects with b.
+func clipRects(rects []image.Rectangle, b image.Rectangle) []image.Rectangle {
+	var out []image.Rectangle
+	for _, r := range rects {
+		if r = r.Canon().Intersect(b); !r.Empty() {
+			out = append(out, r)
+		}
+	}
+	return out
+}
+
+// averageNRGBA returns the alpha-weighted mean colour of r.
+func averageNRGBA(m *image.NRGBA, r image.Rectangle) [4]uint8 {
+	var sum [4]float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for i := m.PixOffset(r.Min.X, y); i < m.PixOffset(r.Max.X, y); i += 4 {
+			a := float64(m.Pix[i+3])
+			sum[0] += float64(m.Pix[i]) * a
+			sum[1] += float64(m.Pix[i+1]) * a
+			sum[2] += float64(m.Pix[i+2]) * a
+			sum[3] += a
+		}
+	}
+	if sum[3] == 0 {
+		return [4]uint8{}
+	}
+	n := float64(r.Dx() * r.Dy())
+	return [4]uint8{
+		uint8(sum[0]/sum[3] + 0.5),
+		uint8(sum[1]/sum[3] + 0.5),
+		uint8(sum[2]/sum[3] + 0.5),
+		uint8(sum[3]/n + 0.5),
+	}
+}
+
+// fillNRGBA sets every pixel of r to c.
+func fillNRGBA(m *image.NRGBA, r image.Rectangle, c [4]uint8) {
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for i := m.PixOffset(r.Min.X, y); i < m.PixOffset(r.Max.X, y); i += 4 {
+			copy(m.Pix[i:i+4], c[:])
+		}
+	}
+}
+
+// blurNRGBA applies a separable Gaussian blur to r in place, clamping
+// samples to r.  Colour is blurred premultiplied so transparent pixels do
+// not darken their neighbours.
+func blurNRGBA(m *image.NRGBA, r image.Rectangle, sigma float64) {
+	radius := int(math.Ceil(3 * sigma))
+	kernel := make([]float64, 2*radius+1)
+	total := 0.0
+	for i := range kernel {
+		d := float64(i - radius)
+		kernel[i] = math.Exp(-d * d / (2 * sigma * sigma))
+		total += kernel[i]
+	}
+	for i := range kernel {
+		kernel[i] /= total
+	}
+
+	w, h := r.Dx(), r.Dy()
+	buf := make([]float64, w*h*4)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := m.PixOffset(r.Min.X+x, r.Min.Y+y)
+			a := float64(m.Pix[i+3]) / 255
+			j := (y*w + x) * 4
+			buf[j] = float64(m.Pix[i]) * a
+			buf[j+1] = float64(m.Pix[i+1]) * a
+			buf[j+2] = float64(m.Pix[i+2]) * a
+			buf[j+3] = float64(m.Pix[i+3])
+		}
+	}
+	tmp := make([]float64, len(buf))
+	pass := func(dst, src []float64, dx, dy int) {
+		for y := 0; y < h; y++ {
+			for x := 0; x < w; x++ {
+				var acc [4]float64
+				for k, kv := range kernel {
+					sx := max(0, min(w-1, x+(k-radius)*dx))
+					sy := max(0, min(h-1, y+(k-radius)*dy))
+					j := (sy*w + sx) * 4
+					acc[0] += src[j] * kv
+					acc[1] += src[j+1] * kv
+					acc[2] += src[j+2] * kv
+					acc[3] += src[j+3] * kv
+				}
+				copy(dst[(y*w+x)*4:], acc[:])
+			}
+		}
+	}
+	pass(tmp, buf, 1, 0)
+	pass(buf, tmp, 0, 1)
+
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := m.PixOffset(r.Min.X+x, r.Min.Y+y)
+			j := (y*w + x) * 4
+			a := buf[j+3]
+			if a <= 0 {
+				fillNRGBA(m, image.Rect(r.Min.X+x, r.Min.Y+y, r.Min.X+x+1, r.Min.Y+y+1), [4]uint8{})
+				continue
+			}
+			un := 255 / a
+			m.Pix[i] = uint8(clampf(buf[j]*un, 0, 255) + 0.5)
+			m.Pix[i+1] = uint8(clampf(buf[j+1]*un, 0, 255) + 0.5)
+			m.Pix[i+2] = uint8(clampf(buf[j+2]*un, 0, 255) + 0.5)
+			m.Pix[i+3] = uint8(clampf(a, 0, 255) + 0.5)
+		}
+	}
+}
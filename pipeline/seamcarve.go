@@ -0,0 +1,190 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── SeamCarve ─────────────────────────────────────────────────────────────────
+
+// SeamCarveStep changes the aspect ratio by seam carving (Avidan & Shamir):
+// it repeatedly removes the connected path of pixels with the least gradient
+// energy, so sky, water and plain backgrounds shrink while subjects keep
+// their shape, where a crop would cut them off.  The image is first scaled
+// to cover Width×Height, as SmartCropStep does, and the excess on the other
+// axis is carved away.  A zero dimension keeps the source size on that axis.
+//
+// Each seam costs a pass over the image, so carving hundreds of seams from a
+// large photo takes seconds; resize close to the target first.  The result
+// is *image.NRGBA.
+type SeamCarveStep struct {
+	Width, Height int
+}
+
+func (s *SeamCarveStep) Name() string { return "seam_carve" }
+
+func (s *SeamCarveStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	b := src.Bounds()
+	tw, th := s.Width, s.Height
+	if tw == 0 {
+		tw = b.Dx()
+	}
+	if th == 0 {
+		th = b.Dy()
+	}
+	if tw <= 0 || th <= 0 || b.Empty() {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+	if tw == b.Dx() && th == b.Dy() {
+		return img, nil
+	}
+
+	w, h := float64(b.Dx()), float64(b.Dy())
+	if scale := math.Max(float64(tw)/w, float64(th)/h); scale != 1 {
+		rw := max(int(math.Round(w*scale)), tw)
+		rh := max(int(math.Round(h*scale)), th)
+		resized, err := (&ResizeStep{Width: rw, Height: rh}).Execute(ctx, img)
+		if err != nil {
+			return nil, err
+		}
+		src = resized.Image.(image.Image)
+	}
+
+	c := newCarveImage(cloneNRGBA(src))
+	for c.w > tw {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		c.removeSeam(c.verticalSeam())
+	}
+	if c.h > th {
+		c.transpose()
+		for c.w > th {
+			if err := ctx.Err(); err != nil {
+				return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+			}
+			c.removeSeam(c.verticalSeam())
+		}
+		c.transpose()
+	}
+
+	out := *img
+	out.Image = c.image()
+	out.Meta.Width = c.w
+	out.Meta.Height = c.h
+	return &out, nil
+}
+
+// carveImage is a row-major pixel grid that seams are removed from, with
+// the alpha-weighted luma seam energy is computed on.
+type carveImage struct {
+	w, h int
+	pix  [][4]uint8
+	luma []float64
+}
+
+func newCarveImage(m *image.NRGBA) *carveImage {
+	w, h := m.Rect.Dx(), m.Rect.Dy()
+	c := &carveImage{w: w, h: h, pix: make([][4]uint8, w*h), luma: make([]float64, w*h)}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := m.PixOffset(x, y)
+			p := [4]uint8{m.Pix[i], m.Pix[i+1], m.Pix[i+2], m.Pix[i+3]}
+			c.pix[y*w+x] = p
+			c.luma[y*w+x] = float64(luma8(p[0], p[1], p[2])) * float64(p[3]) / 255
+		}
+	}
+	return c
+}
+
+// verticalSeam returns, for each row, the column of the top-to-bottom
+// 8-connected path with the least total gradient energy.
+func (c *carveImage) verticalSeam() []int {
+	w, h := c.w, c.h
+	at := func(x, y int) float64 {
+		return c.luma[max(0, min(y, h-1))*w+max(0, min(x, w-1))]
+	}
+	cost := make([]float64, w*h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			e := math.Abs(at(x+1, y)-at(x-1, y)) + math.Abs(at(x, y+1)-at(x, y-1))
+			if y > 0 {
+				prev := cost[(y-1)*w+x]
+				if x > 0 {
+					prev = math.Min(prev, cost[(y-1)*w+x-1])
+				}
+				if x < w-1 {
+					prev = math.Min(prev, cost[(y-1)*w+x+1])
+				}
+				e += prev
+			}
+			cost[y*w+x] = e
+		}
+	}
+
+	seam := make([]int, h)
+	best := 0
+	for x := 1; x < w; x++ {
+		if cost[(h-1)*w+x] < cost[(h-1)*w+best] {
+			best = x
+		}
+	}
+	seam[h-1] = best
+	for y := h - 2; y >= 0; y-- {
+		x := seam[y+1]
+		best := x
+		for _, nx := range [2]int{x - 1, x + 1} {
+			if nx >= 0 && nx < w && cost[y*w+nx] < cost[y*w+best] {
+				best = nx
+			}
+		}
+		seam[y] = best
+	}
+	return seam
+}
+
+// removeSeam deletes column seam[y] from every row y.
+func (c *carveImage) removeSeam(seam []int) {
+	w := c.w
+	for y := 0; y < c.h; y++ {
+		row, dst := y*w, y*(w-1)
+		x := seam[y]
+		copy(c.pix[dst:], c.pix[row:row+x])
+		copy(c.pix[dst+x:], c.pix[row+x+1:row+w])
+		copy(c.luma[dst:], c.luma[row:row+x])
+		copy(c.luma[dst+x:], c.luma[row+x+1:row+w])
+	}
+	c.w--
+	c.pix = c.pix[:c.w*c.h]
+	c.luma = c.luma[:c.w*c.h]
+}
+
+// transpose swaps rows and columns, so horizontal seams can be carved as
+// vertical ones.
+func (c *carveImage) transpose() {
+	pix := make([][4]uint8, len(c.pix))
+	luma := make([]float64, len(c.luma))
+	for y := 0; y < c.h; y++ {
+		for x := 0; x < c.w; x++ {
+			pix[x*c.h+y] = c.pix[y*c.w+x]
+			luma[x*c.h+y] = c.luma[y*c.w+x]
+		}
+	}
+	c.pix, c.luma, c.w, c.h = pix, luma, c.h, c.w
+}
+
+func (c *carveImage) image() *image.NRGBA {
+	m := image.NewNRGBA(image.Rect(0, 0, c.w, c.h))
+	for i, p := range c.pix {
+		copy(m.Pix[i*4:i*4+4], p[:])
+	}
+	return m
+}
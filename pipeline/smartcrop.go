@@ -0,0 +1,175 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── SmartCrop ─────────────────────────────────────────────────────────────────
+
+// SmartCropStep scales the image to cover a Width×Height box, as a FitCover
+// thumbnail does, then keeps the window Strategy picks rather than a fixed
+// gravity, so subjects away from the centre stay in frame.  An empty
+// Strategy means core.CropAttention.  See the vips adapter's
+// VipsSmartCropStep for the libvips equivalent.
+type SmartCropStep struct {
+	Width, Height int
+	Strategy      core.CropStrategy
+}
+
+func (s *SmartCropStep) Name() string { return "smart_crop" }
+
+func (s *SmartCropStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Width <= 0 || s.Height <= 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+
+	b := src.Bounds()
+	w, h := float64(b.Dx()), float64(b.Dy())
+	scale := math.Max(float64(s.Width)/w, float64(s.Height)/h)
+	rw := max(int(math.Round(w*scale)), s.Width)
+	rh := max(int(math.Round(h*scale)), s.Height)
+	resized, err := (&ResizeStep{Width: rw, Height: rh}).Execute(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+
+	x, y := smartCropOffset(resized.Image.(image.Image), s.Width, s.Height, s.Strategy)
+	return (&CropStep{X: x, Y: y, Width: s.Width, Height: s.Height}).Execute(ctx, resized)
+}
+
+// smartCropOffset returns the top-left corner, relative to m's origin, of
+// the w×h window strategy keeps.
+func smartCropOffset(m image.Image, w, h int, strategy core.CropStrategy) (x, y int) {
+	b := m.Bounds()
+	if b.Dx() <= w && b.Dy() <= h {
+		return 0, 0
+	}
+	switch strategy {
+	case core.CropCentre:
+		return (b.Dx() - w) / 2, (b.Dy() - h) / 2
+	case core.CropEntropy:
+		return entropyWindow(cloneNRGBA(m), w, h)
+	default:
+		return attentionWindow(cloneNRGBA(m), w, h)
+	}
+}
+
+// entropyWindow repeatedly trims a strip from whichever end of the excess
+// axis has the lower luma entropy, in the manner of libvips' entropy
+// strategy.  Equal strips are trimmed from both ends.
+func entropyWindow(m *image.NRGBA, w, h int) (int, int) {
+	r := m.Bounds()
+	for r.Dx() > w {
+		step := min(r.Dx()-w, max(1, r.Dx()/16))
+		lo := entropy(m, image.Rect(r.Min.X, r.Min.Y, r.Min.X+step, r.Max.Y))
+		hi := entropy(m, image.Rect(r.Max.X-step, r.Min.Y, r.Max.X, r.Max.Y))
+		switch {
+		case lo < hi:
+			r.Min.X += step
+		case hi < lo:
+			r.Max.X -= step
+		default:
+			r.Min.X += step / 2
+			r.Max.X -= step - step/2
+		}
+	}
+	for r.Dy() > h {
+		step := min(r.Dy()-h, max(1, r.Dy()/16))
+		lo := entropy(m, image.Rect(r.Min.X, r.Min.Y, r.Max.X, r.Min.Y+step))
+		hi := entropy(m, image.Rect(r.Min.X, r.Max.Y-step, r.Max.X, r.Max.Y))
+		switch {
+		case lo < hi:
+			r.Min.Y += step
+		case hi < lo:
+			r.Max.Y -= step
+		default:
+			r.Min.Y += step / 2
+			r.Max.Y -= step - step/2
+		}
+	}
+	return r.Min.X, r.Min.Y
+}
+
+// entropy is the Shannon entropy, in bits, of the luma histogram of r.
+func entropy(m *image.NRGBA, r image.Rectangle) float64 {
+	var hist [256]int
+	n := 0
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		for i := m.PixOffset(r.Min.X, y); i < m.PixOffset(r.Max.X, y); i += 4 {
+			if m.Pix[i+3] == 0 {
+				continue
+			}
+			hist[luma8(m.Pix[i], m.Pix[i+1], m.Pix[i+2])]++
+			n++
+		}
+	}
+	e := 0.0
+	for _, c := range hist {
+		if c > 0 {
+			p := float64(c) / float64(n)
+			e -= p * math.Log2(p)
+		}
+	}
+	return e
+}
+
+// attentionWindow centres the window on the centroid of a saliency map
+// built from edges, skin tones and saturated colour.
+func attentionWindow(m *image.NRGBA, w, h int) (int, int) {
+	W, H := m.Rect.Dx(), m.Rect.Dy()
+	cols, rows := make([]float64, W), make([]float64, H)
+	total := 0.0
+	for y := 0; y < H; y++ {
+		for x := 0; x < W; x++ {
+			v := saliency(m, x, y)
+			cols[x] += v
+			rows[y] += v
+			total += v
+		}
+	}
+	if total == 0 {
+		return (W - w) / 2, (H - h) / 2
+	}
+	centre := func(sums []float64, size int) int {
+		c := 0.0
+		for i, v := range sums {
+			c += float64(i) * v
+		}
+		return max(0, min(int(math.Round(c/total))-size/2, len(sums)-size))
+	}
+	return centre(cols, w), centre(rows, h)
+}
+
+// saliency scores pixel (x, y) of a zero-origin m.
+func saliency(m *image.NRGBA, x, y int) float64 {
+	i := m.PixOffset(x, y)
+	p := m.Pix[i : i+4 : i+4]
+	if p[3] == 0 {
+		return 0
+	}
+	at := func(x, y int) float64 {
+		x = max(0, min(x, m.Rect.Dx()-1))
+		y = max(0, min(y, m.Rect.Dy()-1))
+		j := m.PixOffset(x, y)
+		return float64(luma8(m.Pix[j], m.Pix[j+1], m.Pix[j+2]))
+	}
+	s := math.Abs(at(x+1, y)-at(x-1, y)) + math.Abs(at(x, y+1)-at(x, y-1))
+	if yy, cb, cr := color.RGBToYCbCr(p[0], p[1], p[2]); yy > 40 && cb >= 77 && cb <= 127 && cr >= 133 && cr <= 173 {
+		s += 192
+	}
+	sat := int(max(p[0], p[1], p[2])) - int(min(p[0], p[1], p[2]))
+	return s + float64(max(0, sat-64))
+}
+
+// luma8 is luma709 for an 8-bit colour.
+func luma8(r, g, b uint8) uint8 { return uint8(luma709(uint32(r), uint32(g), uint32(b))) }
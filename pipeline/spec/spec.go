@@ -0,0 +1,177 @@
+// Package spec builds pipelines from declarative JSON or YAML documents, so
+// operators can change how images are processed without redeploying Go
+// code:
+//
+//	{
+//	  "steps": [
+//	    {"type": "resize", "width": 1600},
+//	    {"type": "format", "format": "webp"},
+//	    {"type": "quality", "quality": 80}
+//	  ],
+//	  "variants": [
+//	    {"name": "medium", "steps": [{"type": "resize", "width": 800}]},
+//	    {"name": "thumb", "from": "medium", "steps": [{"type": "thumbnail", "size": 200}]}
+//	  ]
+//	}
+//
+// A variant with "from" starts from the output of the variant it names
+// rather than the base image.
+//
+// Each step entry names, under "type", a factory registered with
+// core.RegisterStep; its other keys are the factory's parameters.  The
+// pipeline package registers its built-in steps under their Name(), taking
+// the step's fields in snake_case and colours as "#rrggbb[aa]" or CSS names
+// (core.StepNames lists them).  Decode and encode steps are listed like any
+// other; an encode step takes codec-specific settings in "jpeg", "png",
+// "webp" and "avif" mappings of the core.JPEGOptions, PNGOptions,
+// WebPOptions and AVIFOptions fields:
+//
+//	{"type": "encode", "quality": 85, "jpeg": {"subsample": "4:4:4"}, "webp": {"effort": 6}}
+//
+// The same document in YAML:
+//
+//	steps:
+//	  - type: resize
+//	    width: 1600
+//	  - {type: format, format: webp}
+//	variants:
+//	  - name: thumb
+//	    steps:
+//	      - {type: thumbnail, size: 200}
+//
+// Only the block-style subset shown is understood: mappings, sequences,
+// scalars, one-line flow collections and comments, without anchors, tags
+// or multi-line strings.
+package spec
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	_ "github.com/Skryldev/image-processor/pipeline" // built-in step factories
+)
+
+// Spec is a parsed pipeline definition.
+type Spec struct {
+	Steps    []core.Step
+	Variants []core.VariantDefinition
+}
+
+// Parse builds a Spec from a JSON or YAML document.  Input starting with
+// '{' is read as JSON and anything else as YAML; see the package docs for
+// the YAML subset understood.
+func Parse(data []byte) (*Spec, error) {
+	var (
+		doc any
+		err error
+	)
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '{' {
+		err = json.Unmarshal(trimmed, &doc)
+	} else {
+		doc, err = parseYAML(data)
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, "spec.parse", err)
+	}
+	return build(doc)
+}
+
+// Load reads and parses the pipeline definition at path.
+func Load(path string) (*Spec, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, "spec.load", err)
+	}
+	return Parse(data)
+}
+
+func build(doc any) (*Spec, error) {
+	root, ok := doc.(map[string]any)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse",
+			fmt.Errorf("document must be a mapping with a steps list"))
+	}
+	for key := range root {
+		if key != "steps" && key != "variants" {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse", fmt.Errorf("unknown key %q", key))
+		}
+	}
+	steps, err := buildSteps(root["steps"], "steps")
+	if err != nil {
+		return nil, err
+	}
+	s := &Spec{Steps: steps}
+
+	if root["variants"] == nil {
+		return s, nil
+	}
+	variants, ok := root["variants"].([]any)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse", fmt.Errorf("variants must be a list"))
+	}
+	for i, v := range variants {
+		path := fmt.Sprintf("variants[%d]", i)
+		m, ok := v.(map[string]any)
+		if !ok {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse", fmt.Errorf("%s must be a mapping", path))
+		}
+		name, _ := m["name"].(string)
+		if name == "" {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse", fmt.Errorf("%s needs a name", path))
+		}
+		if slices.ContainsFunc(s.Variants, func(d core.VariantDefinition) bool { return d.Name == name }) {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse", fmt.Errorf("duplicate variant %q", name))
+		}
+		vs, err := buildSteps(m["steps"], path+".steps")
+		if err != nil {
+			return nil, err
+		}
+		from, _ := m["from"].(string)
+		s.Variants = append(s.Variants, core.VariantDefinition{Name: name, Steps: vs, From: from})
+	}
+	return s, nil
+}
+
+// buildSteps instantiates a list of step entries; path locates the list in
+// error messages.
+func buildSteps(v any, path string) ([]core.Step, error) {
+	if v == nil {
+		return nil, nil
+	}
+	list, ok := v.([]any)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse", fmt.Errorf("%s must be a list", path))
+	}
+	steps := make([]core.Step, 0, len(list))
+	for i, entry := range list {
+		m, ok := entry.(map[string]any)
+		if !ok {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse",
+				fmt.Errorf("%s[%d] must be a mapping", path, i))
+		}
+		typ, _ := m["type"].(string)
+		f, ok := core.LookupStep(typ)
+		if !ok {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse",
+				fmt.Errorf("%s[%d]: unknown step type %q", path, i, typ))
+		}
+		params := make(map[string]any, len(m))
+		for k, v := range m {
+			if k != "type" {
+				params[k] = v
+			}
+		}
+		step, err := f(params)
+		if err != nil {
+			return nil, apperrors.New(apperrors.CategoryConfig, "spec.parse",
+				fmt.Errorf("%s[%d] (%s): %w", path, i, typ, err))
+		}
+		steps = append(steps, step)
+	}
+	return steps, nil
+}
@@ -0,0 +1,370 @@
+package spec
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// yamlLine is one significant line of a YAML document.
+type yamlLine struct {
+	num    int // 1-based, for errors
+	indent int
+	text   string
+}
+
+// parseYAML reads the block-style YAML subset pipeline definitions need:
+// nested mappings and sequences, plain and quoted scalars, flow
+// collections ([a, b] and {k: v}) and comments.  Anchors, tags, multi-line
+// scalars and multiple documents are not supported.
+func parseYAML(data []byte) (any, error) {
+	var lines []yamlLine
+	for i, raw := range strings.Split(string(data), "\n") {
+		raw = strings.TrimRight(raw, " \r")
+		text := strings.TrimLeft(raw, " ")
+		if strings.HasPrefix(text, "\t") {
+			return nil, fmt.Errorf("line %d: tabs are not allowed for indentation", i+1)
+		}
+		text = stripComment(text)
+		if text == "" || (text == "---" && len(lines) == 0) {
+			continue
+		}
+		lines = append(lines, yamlLine{num: i + 1, indent: len(raw) - len(strings.TrimLeft(raw, " ")), text: text})
+	}
+	if len(lines) == 0 {
+		return nil, fmt.Errorf("empty document")
+	}
+	p := &yamlParser{lines: lines}
+	v, err := p.node(lines[0].indent)
+	if err != nil {
+		return nil, err
+	}
+	if p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+	}
+	return v, nil
+}
+
+// stripComment removes a trailing "# comment" outside quotes.
+func stripComment(s string) string {
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case (c == '"' || c == '\'') && (i == 0 || strings.IndexByte(" [{,", s[i-1]) >= 0):
+			quote = c
+		case c == '#' && (i == 0 || s[i-1] == ' '):
+			return strings.TrimRight(s[:i], " ")
+		}
+	}
+	return s
+}
+
+type yamlParser struct {
+	lines []yamlLine
+	pos   int
+}
+
+// node parses the block starting at the current line, which is indented by
+// indent spaces.
+func (p *yamlParser) node(indent int) (any, error) {
+	l := p.lines[p.pos]
+	if l.text == "-" || strings.HasPrefix(l.text, "- ") {
+		return p.sequence(indent)
+	}
+	if _, _, ok := splitKey(l.text); ok {
+		return p.mapping(indent)
+	}
+	p.pos++
+	return scalar(l.text, l.num)
+}
+
+func (p *yamlParser) sequence(indent int) ([]any, error) {
+	list := []any{}
+	for p.pos < len(p.lines) {
+		l := &p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		if l.text != "-" && !strings.HasPrefix(l.text, "- ") {
+			break
+		}
+		if l.text == "-" {
+			p.pos++
+			v, err := p.child(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			continue
+		}
+		// "- item": reparse the item as a block at the column it starts in,
+		// so "- type: resize" opens a mapping continued on later lines.
+		rest := strings.TrimLeft(l.text[1:], " ")
+		l.indent += len(l.text) - len(rest)
+		l.text = rest
+		v, err := p.node(l.indent)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+	}
+	return list, nil
+}
+
+func (p *yamlParser) mapping(indent int) (map[string]any, error) {
+	m := map[string]any{}
+	for p.pos < len(p.lines) {
+		l := p.lines[p.pos]
+		if l.indent < indent {
+			break
+		}
+		if l.indent > indent {
+			return nil, fmt.Errorf("line %d: unexpected indentation", l.num)
+		}
+		key, value, ok := splitKey(l.text)
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected \"key: value\"", l.num)
+		}
+		if _, dup := m[key]; dup {
+			return nil, fmt.Errorf("line %d: duplicate key %q", l.num, key)
+		}
+		p.pos++
+		var (
+			v   any
+			err error
+		)
+		if value == "" {
+			v, err = p.child(indent, true)
+		} else {
+			v, err = scalar(value, l.num)
+		}
+		if err != nil {
+			return nil, err
+		}
+		m[key] = v
+	}
+	return m, nil
+}
+
+// child parses the block nested under a "key:" or "-" line at indent: a
+// deeper block or, under a mapping key, a sequence at the same indent, as
+// YAML allows.  With neither, the value is null.
+func (p *yamlParser) child(indent int, underKey bool) (any, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	next := p.lines[p.pos]
+	switch {
+	case next.indent > indent:
+		return p.node(next.indent)
+	case underKey && next.indent == indent && (next.text == "-" || strings.HasPrefix(next.text, "- ")):
+		return p.sequence(indent)
+	}
+	return nil, nil
+}
+
+// splitKey splits "key: value" or "key:" outside quotes and flow
+// collections.
+func splitKey(s string) (key, value string, ok bool) {
+	if s == "" || s[0] == '[' || s[0] == '{' {
+		return "", "", false
+	}
+	var quote byte
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; {
+		case quote != 0:
+			if c == quote {
+				quote = 0
+			} else if c == '\\' && quote == '"' {
+				i++
+			}
+		case (c == '"' || c == '\'') && i == 0:
+			quote = c
+		case c == ':' && (i == len(s)-1 || s[i+1] == ' '):
+			key = strings.TrimSpace(s[:i])
+			if k, err := unquote(key); err == nil {
+				key = k
+			}
+			return key, strings.TrimSpace(s[i+1:]), key != ""
+		}
+	}
+	return "", "", false
+}
+
+// scalar converts a value written on one line.
+func scalar(s string, num int) (any, error) {
+	if s[0] == '[' || s[0] == '{' {
+		f := &flowParser{s: s}
+		v, err := f.value()
+		if err == nil && strings.TrimSpace(f.s[f.pos:]) != "" {
+			err = fmt.Errorf("trailing %q", f.s[f.pos:])
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+		return v, nil
+	}
+	if s[0] == '"' || s[0] == '\'' {
+		v, err := unquote(s)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+		return v, nil
+	}
+	return plain(s), nil
+}
+
+func unquote(s string) (string, error) {
+	switch {
+	case len(s) >= 2 && s[0] == '"' && s[len(s)-1] == '"':
+		return strconv.Unquote(s)
+	case len(s) >= 2 && s[0] == '\'' && s[len(s)-1] == '\'':
+		return strings.ReplaceAll(s[1:len(s)-1], "''", "'"), nil
+	case s != "" && (s[0] == '"' || s[0] == '\''):
+		return "", fmt.Errorf("unterminated string %s", s)
+	}
+	return s, nil
+}
+
+// plain resolves an unquoted scalar to null, a bool, an int, a float64 or
+// a string.
+func plain(s string) any {
+	switch s {
+	case "~", "null", "Null", "NULL":
+		return nil
+	case "true", "True", "TRUE":
+		return true
+	case "false", "False", "FALSE":
+		return false
+	}
+	if n, err := strconv.Atoi(s); err == nil {
+		return n
+	}
+	if f, err := strconv.ParseFloat(s, 64); err == nil {
+		return f
+	}
+	return s
+}
+
+// flowParser reads a flow collection: [a, b] or {k: v}, nested freely.
+type flowParser struct {
+	s   string
+	pos int
+}
+
+func (f *flowParser) skip() {
+	for f.pos < len(f.s) && f.s[f.pos] == ' ' {
+		f.pos++
+	}
+}
+
+func (f *flowParser) value() (any, error) {
+	f.skip()
+	if f.pos >= len(f.s) {
+		return nil, fmt.Errorf("unexpected end of flow collection")
+	}
+	switch f.s[f.pos] {
+	case '[':
+		f.pos++
+		list := []any{}
+		for {
+			f.skip()
+			if f.pos < len(f.s) && f.s[f.pos] == ']' {
+				f.pos++
+				return list, nil
+			}
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, v)
+			if err := f.separator(']'); err != nil {
+				return nil, err
+			}
+		}
+	case '{':
+		f.pos++
+		m := map[string]any{}
+		for {
+			f.skip()
+			if f.pos < len(f.s) && f.s[f.pos] == '}' {
+				f.pos++
+				return m, nil
+			}
+			k, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				key = fmt.Sprint(k)
+			}
+			f.skip()
+			if f.pos >= len(f.s) || f.s[f.pos] != ':' {
+				return nil, fmt.Errorf("expected ':' after key %q", key)
+			}
+			f.pos++
+			v, err := f.value()
+			if err != nil {
+				return nil, err
+			}
+			m[key] = v
+			if err := f.separator('}'); err != nil {
+				return nil, err
+			}
+		}
+	case '"', '\'':
+		q := f.s[f.pos]
+		end := f.pos + 1
+		for end < len(f.s) {
+			c := f.s[end]
+			if c == '\\' && q == '"' {
+				end += 2
+				continue
+			}
+			if c == q {
+				if q == '\'' && end+1 < len(f.s) && f.s[end+1] == '\'' {
+					end += 2
+					continue
+				}
+				break
+			}
+			end++
+		}
+		if end >= len(f.s) {
+			return nil, fmt.Errorf("unterminated string")
+		}
+		v, err := unquote(f.s[f.pos : end+1])
+		f.pos = end + 1
+		return v, err
+	}
+	start := f.pos
+	for f.pos < len(f.s) && !strings.ContainsRune(",]}", rune(f.s[f.pos])) &&
+		(f.s[f.pos] != ':' || (f.pos+1 < len(f.s) && f.s[f.pos+1] != ' ')) {
+		f.pos++
+	}
+	return plain(strings.TrimSpace(f.s[start:f.pos])), nil
+}
+
+// separator consumes the ',' between items, leaving the closing bracket.
+func (f *flowParser) separator(closing byte) error {
+	f.skip()
+	switch {
+	case f.pos < len(f.s) && f.s[f.pos] == ',':
+		f.pos++
+		return nil
+	case f.pos < len(f.s) && f.s[f.pos] == closing:
+		return nil
+	}
+	return fmt.Errorf("expected ',' or '%c' in flow collection", closing)
+}
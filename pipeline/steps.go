@@ -3,14 +3,21 @@ package pipeline
 
 import (
 	"bytes"
+	"cmp"
 	"context"
 	"fmt"
 	"image"
 	"image/color"
 	"image/draw"
+	"io"
+	"maps"
+	"math"
+	"strings"
 
+	"github.com/Skryldev/image-processor/config"
 	"github.com/Skryldev/image-processor/core"
 	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/metadata"
 	"github.com/Skryldev/image-processor/utils"
 	xdraw "golang.org/x/image/draw"
 )
@@ -21,20 +28,36 @@ import (
 // when one axis is 0.
 type ResizeStep struct {
 	Width, Height int
+	// Kernel selects a named resampling filter.  Ignored when Resampler is set.
+	Kernel core.Kernel
 	// Resampler controls quality vs speed.  Defaults to draw.BiLinear.
 	Resampler xdraw.Interpolator
+	// PostSharpen is the unsharp-mask amount applied after downscaling,
+	// restoring the crispness resampling filters smooth away.  0 takes
+	// config.Config.PostSharpen when run by a Processor; negative disables.
+	PostSharpen float64
 }
 
 func (s *ResizeStep) Name() string { return "resize" }
 
+// BindConfig implements core.ConfigBinder.
+func (s *ResizeStep) BindConfig(cfg config.Config) core.Step {
+	if s.PostSharpen != 0 || cfg.PostSharpen == 0 {
+		return s
+	}
+	cp := *s
+	cp.PostSharpen = cfg.PostSharpen
+	return &cp
+}
+
 func (s *ResizeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
 	if err := ctx.Err(); err != nil {
 		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
 
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrEmptyInput)
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
 
 	srcB := src.Bounds()
@@ -49,11 +72,14 @@ func (s *ResizeStep) Execute(ctx context.Context, img *core.ImageData) (*core.Im
 
 	sampler := s.Resampler
 	if sampler == nil {
-		sampler = xdraw.BiLinear
+		sampler = Interpolator(s.Kernel)
 	}
 
 	dst := image.NewRGBA(image.Rect(0, 0, dstW, dstH))
 	sampler.Scale(dst, dst.Bounds(), src, srcB, xdraw.Over, nil)
+	if s.PostSharpen > 0 && dstW*dstH < srcB.Dx()*srcB.Dy() {
+		sharpenRGBA(dst, s.PostSharpen)
+	}
 
 	out := *img
 	out.Image = dst
@@ -62,11 +88,52 @@ func (s *ResizeStep) Execute(ctx context.Context, img *core.ImageData) (*core.Im
 	return &out, nil
 }
 
+// lanczos3 is a Lanczos windowed-sinc kernel with a support of 3 lobes;
+// x/image/draw does not ship one.
+var lanczos3 = &xdraw.Kernel{Support: 3, At: func(t float64) float64 {
+	if t == 0 {
+		return 1
+	}
+	if t >= 3 {
+		return 0
+	}
+	t *= math.Pi
+	return 3 * math.Sin(t) * math.Sin(t/3) / (t * t)
+}}
+
+// Interpolator maps a core.Kernel to an x/image/draw interpolator.  Unknown
+// or empty kernels map to draw.BiLinear.
+func Interpolator(k core.Kernel) xdraw.Interpolator {
+	switch k {
+	case core.KernelNearest:
+		return xdraw.NearestNeighbor
+	case core.KernelCatmullRom:
+		return xdraw.CatmullRom
+	case core.KernelLanczos:
+		return lanczos3
+	}
+	return xdraw.BiLinear
+}
+
 // ── Crop ──────────────────────────────────────────────────────────────────────
 
 // CropStep crops a rectangle from the image.
+//
+// The rectangle is given either in pixels (X, Y, Width, Height) or as
+// percentages of the source dimensions (XPercent, …); a non-zero percentage
+// takes precedence over the matching pixel field.  When Gravity is set the
+// position is derived from the anchor instead of X/Y.
+//
+// AspectRatio (width / height, e.g. 4.0/5.0) selects the largest window of
+// that ratio, or derives the missing axis when only one is given.  In aspect
+// mode an empty Gravity means center.
 type CropStep struct {
 	X, Y, Width, Height int
+
+	XPercent, YPercent, WidthPercent, HeightPercent float64
+
+	Gravity     core.Gravity
+	AspectRatio float64
 }
 
 func (s *CropStep) Name() string { return "crop" }
@@ -76,27 +143,76 @@ func (s *CropStep) Execute(ctx context.Context, img *core.ImageData) (*core.Imag
 		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
 
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrEmptyInput)
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
 
-	rect := image.Rect(s.X, s.Y, s.X+s.Width, s.Y+s.Height)
-	if !rect.In(src.Bounds()) {
+	bounds := src.Bounds()
+	rect, err := s.resolve(bounds.Dx(), bounds.Dy())
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	rect = rect.Add(bounds.Min)
+	if !rect.In(bounds) {
 		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(),
-			fmt.Errorf("crop rect %v exceeds image bounds %v", rect, src.Bounds()))
+			fmt.Errorf("crop rect %v exceeds image bounds %v", rect, bounds))
 	}
 
-	dst := image.NewRGBA(image.Rect(0, 0, s.Width, s.Height))
+	dst := image.NewRGBA(image.Rect(0, 0, rect.Dx(), rect.Dy()))
 	draw.Draw(dst, dst.Bounds(), src, rect.Min, draw.Src)
 
 	out := *img
 	out.Image = dst
-	out.Meta.Width = s.Width
-	out.Meta.Height = s.Height
+	out.Meta.Width = rect.Dx()
+	out.Meta.Height = rect.Dy()
 	return &out, nil
 }
 
+// resolve computes the crop window relative to a srcW×srcH origin-based image.
+func (s *CropStep) resolve(srcW, srcH int) (image.Rectangle, error) {
+	w := percentOr(s.WidthPercent, srcW, s.Width)
+	h := percentOr(s.HeightPercent, srcH, s.Height)
+
+	gravity := s.Gravity
+	if s.AspectRatio > 0 {
+		switch {
+		case w == 0 && h == 0:
+			w, h = srcW, int(math.Round(float64(srcW)/s.AspectRatio))
+			if h > srcH {
+				w, h = int(math.Round(float64(srcH)*s.AspectRatio)), srcH
+			}
+		case h == 0:
+			h = int(math.Round(float64(w) / s.AspectRatio))
+		case w == 0:
+			w = int(math.Round(float64(h) * s.AspectRatio))
+		}
+		if gravity == "" {
+			gravity = core.GravityCenter
+		}
+	}
+	if w <= 0 || h <= 0 {
+		return image.Rectangle{}, apperrors.ErrInvalidDimensions
+	}
+
+	var x, y int
+	if gravity != "" {
+		x, y = gravity.Offset(srcW, srcH, w, h)
+	} else {
+		x = percentOr(s.XPercent, srcW, s.X)
+		y = percentOr(s.YPercent, srcH, s.Y)
+	}
+	return image.Rect(x, y, x+w, y+h), nil
+}
+
+// percentOr returns pct% of total when pct is non-zero, otherwise fallback.
+func percentOr(pct float64, total, fallback int) int {
+	if pct == 0 {
+		return fallback
+	}
+	return int(math.Round(float64(total) * pct / 100))
+}
+
 // ── Format conversion ─────────────────────────────────────────────────────────
 
 // FormatStep converts the image to a new format (sets img.Format for the
@@ -116,8 +232,9 @@ func (s *FormatStep) Execute(_ context.Context, img *core.ImageData) (*core.Imag
 
 // ── Quality ───────────────────────────────────────────────────────────────────
 
-// QualityStep records the desired encode quality.  The actual quality is
-// consumed by EncodeStep.
+// QualityStep records the desired encode quality as the core.AttrQuality
+// directive.  The quality is consumed by EncodeStep; the last QualityStep
+// before it wins.
 type QualityStep struct {
 	Quality int
 }
@@ -126,17 +243,14 @@ func (s *QualityStep) Name() string { return "quality" }
 
 func (s *QualityStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
 	out := *img
-	// Store as a tag in Meta so EncodeStep can read it without coupling.
-	if out.Meta.EXIF == nil {
-		out.Meta.EXIF = make(map[string]string)
-	}
-	out.Meta.EXIF["_quality"] = fmt.Sprintf("%d", s.Quality)
+	out.Attrs = img.Attrs.With(core.AttrQuality, s.Quality)
 	return &out, nil
 }
 
 // ── EXIF strip ────────────────────────────────────────────────────────────────
 
-// StripEXIFStep removes EXIF metadata from the ImageData.
+// StripEXIFStep removes EXIF metadata from the ImageData and asks the encoder
+// not to write any back (core.AttrStripMetadata).
 type StripEXIFStep struct{}
 
 func (s *StripEXIFStep) Name() string { return "strip_exif" }
@@ -145,45 +259,241 @@ func (s *StripEXIFStep) Execute(_ context.Context, img *core.ImageData) (*core.I
 	out := *img
 	out.Meta.EXIF = nil
 	out.Meta.HasEXIF = false
+	out.Meta.Details = nil
 	out.Meta.Orientation = 0
+	out.Attrs = img.Attrs.With(core.AttrStripMetadata, true)
+	return &out, nil
+}
+
+// ── Metadata strip ────────────────────────────────────────────────────────────
+
+// StripMetadataStep removes metadata selectively, by Policy, where
+// StripEXIFStep removes all of it: for example GPS and thumbnails but not
+// the photographer's copyright.  It narrows Meta to what the policy keeps
+// and leaves it as core.AttrMetadata for the encoder; the libvips encoder
+// honours it, the built-in ones write no metadata at all.  When img.Data
+// is already encoded, that is when nothing is decoded or an encode step
+// ran, JPEG and PNG bytes are rewritten in place with metadata.Rewrite,
+// leaving the compressed pixels untouched.
+type StripMetadataStep struct {
+	Policy core.MetadataPolicy
+}
+
+func (s *StripMetadataStep) Name() string { return "strip_metadata" }
+
+func (s *StripMetadataStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	out := *img
+	p := s.Policy
+	out.Meta.Details = metadata.Filter(img.Meta.Details, p)
+	switch {
+	case p.Strip:
+		out.Meta.EXIF = nil
+		if out.Meta.Details != nil {
+			out.Meta.EXIF = metadata.Flatten(out.Meta.Details)
+		}
+		out.Meta.HasEXIF = len(out.Meta.EXIF) > 0
+		if !p.KeepOrientation {
+			out.Meta.Orientation = 0
+		}
+		if !p.KeepColorProfile {
+			out.Meta.ICCProfile = nil
+		}
+	case p.RemoveGPS || p.RemoveThumbnails:
+		// Tag names differ by decoder ("GPSLatitude", "exif-ifd3-GPSLatitude",
+		// "exif-ifd1-…" for the thumbnail directory).
+		exif := make(map[string]string, len(img.Meta.EXIF))
+		for k, v := range img.Meta.EXIF {
+			gps := strings.Contains(k, "GPS") || strings.HasPrefix(k, "exif-ifd3-")
+			thumb := strings.HasPrefix(k, "exif-ifd1-")
+			if !(p.RemoveGPS && gps) && !(p.RemoveThumbnails && thumb) {
+				exif[k] = v
+			}
+		}
+		if img.Meta.EXIF != nil {
+			out.Meta.EXIF = exif
+		}
+	}
+
+	if len(img.Data) > 0 && (img.Image == nil || img.Codecs.Encoder != "") {
+		data, err := metadata.Rewrite(img.Data, p)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		out.Data = data
+		out.Meta.SizeBytes = int64(len(data))
+	}
+	out.Attrs = img.Attrs.With(core.AttrMetadata, p)
+	return &out, nil
+}
+
+// ── Metadata tags ─────────────────────────────────────────────────────────────
+
+// SetMetadataStep writes attribution and provenance into the output: the
+// EXIF Artist, Copyright and Software tags and an XMP packet.  It records
+// Tags as core.AttrMetadataTags, merged over those of an earlier
+// SetMetadataStep, and EncodeStep writes them into the encoded JPEG, PNG or
+// WebP with metadata.Set, after any stripping; other formats are left
+// untagged.  Data that is already encoded, as for StripMetadataStep, is
+// tagged in place.
+type SetMetadataStep struct {
+	Tags core.MetadataTags
+}
+
+func (s *SetMetadataStep) Name() string { return "set_metadata" }
+
+func (s *SetMetadataStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	prev, _ := img.Attrs[core.AttrMetadataTags].(core.MetadataTags)
+	tags := core.MetadataTags{
+		Artist:    cmp.Or(s.Tags.Artist, prev.Artist),
+		Copyright: cmp.Or(s.Tags.Copyright, prev.Copyright),
+		Software:  cmp.Or(s.Tags.Software, prev.Software),
+		XMP:       cmp.Or(s.Tags.XMP, prev.XMP),
+	}
+
+	out := *img
+	d := img.Meta.Details.Clone()
+	if d == nil {
+		d = &core.Details{}
+	}
+	d.Artist = cmp.Or(s.Tags.Artist, d.Artist)
+	d.Copyright = cmp.Or(s.Tags.Copyright, d.Copyright)
+	d.Software = cmp.Or(s.Tags.Software, d.Software)
+	d.XMP = cmp.Or(s.Tags.XMP, d.XMP)
+	out.Meta.Details = d
+	exif := maps.Clone(img.Meta.EXIF)
+	for k, v := range map[string]string{"Artist": s.Tags.Artist, "Copyright": s.Tags.Copyright, "Software": s.Tags.Software} {
+		if v != "" {
+			if exif == nil {
+				exif = map[string]string{}
+			}
+			exif[k] = v
+		}
+	}
+	out.Meta.EXIF = exif
+	out.Meta.HasEXIF = len(exif) > 0
+
+	if len(img.Data) > 0 && (img.Image == nil || img.Codecs.Encoder != "") && taggable(img.Format) {
+		data, err := metadata.Set(img.Data, s.Tags)
+		if err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		out.Data = data
+		out.Meta.SizeBytes = int64(len(data))
+	}
+	out.Attrs = img.Attrs.With(core.AttrMetadataTags, tags)
+	return &out, nil
+}
+
+// ── Deterministic ─────────────────────────────────────────────────────────────
+
+// DeterministicStep switches the rest of the pipeline to reproducible output:
+// it drops EXIF like StripEXIFStep and sets core.AttrDeterministic so the
+// encoder strips all metadata and pins its parameters.  Use it for
+// content-addressed storage and cache validation.
+type DeterministicStep struct{}
+
+func (s *DeterministicStep) Name() string { return "deterministic" }
+
+func (s *DeterministicStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
+	out := *img
+	out.Meta.EXIF = nil
+	out.Meta.HasEXIF = false
+	out.Meta.Details = nil
+	out.Attrs = img.Attrs.With(core.AttrStripMetadata, true).With(core.AttrDeterministic, true)
 	return &out, nil
 }
 
 // ── Thumbnail ────────────────────────────────────────────────────────────────
 
-// ThumbnailStep is a convenience step that combines Resize with square cropping.
+// Fit controls how ThumbnailStep maps the source onto the target box.
+type Fit string
+
+const (
+	// FitCover scales the image to fill the box and crops the overflow.
+	FitCover Fit = "cover"
+	// FitContain scales the image to fit inside the box and pads the rest
+	// with Background.
+	FitContain Fit = "contain"
+)
+
+// ThumbnailStep is a convenience step that combines Resize with cropping or
+// padding to an exact Width×Height box.  Size is shorthand for a square box
+// and is used when Width and Height are both zero.
 type ThumbnailStep struct {
-	Size int // square size in pixels
+	Size          int // square size in pixels
+	Width, Height int
+	Fit           Fit          // default FitCover
+	Gravity       core.Gravity // anchor for the crop / padding; default center
+	Background    color.Color  // FitContain padding; default transparent
 }
 
 func (s *ThumbnailStep) Name() string { return "thumbnail" }
 
 func (s *ThumbnailStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrEmptyInput)
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 	}
 
-	// Step 1: resize so smallest dimension == s.Size.
+	boxW, boxH := s.Width, s.Height
+	if boxW == 0 && boxH == 0 {
+		boxW, boxH = s.Size, s.Size
+	}
+	if boxW <= 0 || boxH <= 0 {
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrInvalidDimensions)
+	}
+	gravity := s.Gravity
+	if gravity == "" {
+		gravity = core.GravityCenter
+	}
+
+	// Step 1: resize so the image covers (or fits inside) the box.
 	bounds := src.Bounds()
-	w, h := bounds.Dx(), bounds.Dy()
-	var rw, rh int
-	if w < h {
-		rw, rh = s.Size, 0
+	w, h := float64(bounds.Dx()), float64(bounds.Dy())
+	scaleW, scaleH := float64(boxW)/w, float64(boxH)/h
+	scale := math.Max(scaleW, scaleH)
+	if s.Fit == FitContain {
+		scale = math.Min(scaleW, scaleH)
+	}
+	rw, rh := int(math.Round(w*scale)), int(math.Round(h*scale))
+	if s.Fit == FitContain {
+		rw, rh = max(1, min(rw, boxW)), max(1, min(rh, boxH))
 	} else {
-		rw, rh = 0, s.Size
+		rw, rh = max(rw, boxW), max(rh, boxH)
 	}
 
-	resized, err := (&ResizeStep{Width: rw, Height: rh}).Execute(ctx, img)
+	// Resize the converted pixels, so other backends are converted once.
+	std := *img
+	std.Image = src
+	resized, err := (&ResizeStep{Width: rw, Height: rh}).Execute(ctx, &std)
 	if err != nil {
 		return nil, err
 	}
 
-	// Step 2: centre-crop to square.
-	rb := resized.Image.(image.Image).Bounds()
-	ox := (rb.Dx() - s.Size) / 2
-	oy := (rb.Dy() - s.Size) / 2
-	return (&CropStep{X: ox, Y: oy, Width: s.Size, Height: s.Size}).Execute(ctx, resized)
+	// Step 2: crop the overflow (cover) or pad onto the box (contain).
+	if s.Fit != FitContain {
+		return (&CropStep{Width: boxW, Height: boxH, Gravity: gravity}).Execute(ctx, resized)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, boxW, boxH))
+	if s.Background != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(s.Background), image.Point{}, draw.Src)
+	}
+	fitted, err := core.StdImage(ctx, resized)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	ox, oy := gravity.Offset(boxW, boxH, rw, rh)
+	draw.Draw(dst, image.Rect(ox, oy, ox+rw, oy+rh), fitted, fitted.Bounds().Min, draw.Over)
+
+	out := *resized
+	out.Image = dst
+	out.Meta.Width = boxW
+	out.Meta.Height = boxH
+	if s.Background == nil {
+		out.Meta.HasAlpha = true
+	}
+	return &out, nil
 }
 
 // ── Encode ────────────────────────────────────────────────────────────────────
@@ -196,31 +506,214 @@ type EncodeStep struct {
 
 func (s *EncodeStep) Name() string { return "encode" }
 
+// BindRegistry implements core.RegistryBinder.
+func (s *EncodeStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
 func (s *EncodeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
-	enc, ok := s.Registry.EncoderFor(img.Format)
-	if !ok {
-		return nil, apperrors.New(apperrors.CategoryEncode, s.Name(),
-			fmt.Errorf("%w: %s", apperrors.ErrUnsupportedFormat, img.Format))
+	chain, img, opts, err := s.prepare(ctx, img)
+	if err != nil {
+		return nil, err
+	}
+	// Video produced by a conversion step is already final.
+	if chain == nil {
+		return img, nil
 	}
 
-	opts := s.BaseOptions
-	// Apply quality override stored by QualityStep.
-	if img.Meta.EXIF != nil {
-		if qs, found := img.Meta.EXIF["_quality"]; found {
-			var q int
-			fmt.Sscanf(qs, "%d", &q)
-			opts.Quality = q
+	var firstErr error
+	for _, enc := range chain {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+		}
+		data, err := enc.Encode(ctx, img, opts)
+		if err == nil {
+			data, err = s.tag(data, img, opts)
 		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		out := *img
+		out.Data = data
+		out.Meta.SizeBytes = int64(len(data))
+		out.Codecs.Encoder = core.CodecName(enc)
+		return &out, nil
 	}
+	return nil, firstErr
+}
 
-	data, err := enc.Encode(ctx, img, opts)
+// EncodeTo encodes img as Execute does but writes the output to w, straight
+// from the encoder when it implements core.StreamEncoder.  The returned
+// image carries no Data; Meta.SizeBytes is the number of bytes written.
+// A failing encoder falls back to the next one only if it wrote nothing.
+func (s *EncodeStep) EncodeTo(ctx context.Context, w io.Writer, img *core.ImageData) (*core.ImageData, error) {
+	chain, img, opts, err := s.prepare(ctx, img)
 	if err != nil {
 		return nil, err
 	}
+	cw := &countingWriter{w: w}
+	if chain == nil {
+		// Video is already final.
+		if _, err := cw.Write(img.Data); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+		}
+		out := *img
+		out.Data = nil
+		out.Meta.SizeBytes = cw.n
+		return &out, nil
+	}
+
+	var firstErr error
+	for _, enc := range chain {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+		}
+		// Tags are written into the finished file, so it is buffered.
+		if se, ok := enc.(core.StreamEncoder); ok && !hasTags(img, opts) {
+			err = se.EncodeTo(ctx, cw, img, opts)
+		} else {
+			var data []byte
+			if data, err = enc.Encode(ctx, img, opts); err == nil {
+				data, err = s.tag(data, img, opts)
+			}
+			if err == nil {
+				if _, err := cw.Write(data); err != nil {
+					return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+				}
+			}
+		}
+		if err != nil {
+			if cw.n > 0 {
+				return nil, err
+			}
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		out := *img
+		out.Data = nil
+		out.Meta.SizeBytes = cw.n
+		out.Codecs.Encoder = core.CodecName(enc)
+		return &out, nil
+	}
+	return nil, firstErr
+}
+
+// prepare picks the encoders to try for img and the options, and flattens
+// img when the target format has no alpha channel.  The chain is nil for
+// video, which is already final.
+func (s *EncodeStep) prepare(ctx context.Context, img *core.ImageData) ([]core.Encoder, *core.ImageData, core.EncodeOptions, error) {
+	if s.Registry == nil {
+		return nil, nil, core.EncodeOptions{}, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
+	if img.Format.IsVideo() {
+		return nil, img, core.EncodeOptions{}, nil
+	}
+	chain := core.EncodersFor(s.Registry, img.Format)
+	if len(chain) == 0 {
+		return nil, nil, core.EncodeOptions{}, apperrors.New(apperrors.CategoryEncode, s.Name(),
+			fmt.Errorf("%w: %s", apperrors.ErrUnsupportedFormat, img.Format))
+	}
+
+	// Directives from earlier steps (QualityStep, StripEXIFStep, …) override
+	// the base options.
+	opts := img.Attrs.ApplyEncode(s.BaseOptions)
+
+	// Transparent pixels would otherwise encode as black in JPEG.
+	if src, ok := img.Image.(image.Image); ok && src != nil && !img.Format.SupportsAlpha() &&
+		(img.Meta.HasAlpha || !isOpaque(src)) {
+		flat, err := (&FlattenStep{Background: opts.Background}).Execute(ctx, img)
+		if err != nil {
+			return nil, nil, core.EncodeOptions{}, err
+		}
+		img = flat
+	}
+	return chain, img, opts, nil
+}
+
+// tag writes opts.Tags, and with opts.EmbedICC the image's colour profile,
+// into the encoded data.  Formats metadata.Set cannot write are returned
+// untagged.
+func (s *EncodeStep) tag(data []byte, img *core.ImageData, opts core.EncodeOptions) ([]byte, error) {
+	if !hasTags(img, opts) || !taggable(img.Format) {
+		return data, nil
+	}
+	var err error
+	if opts.EmbedICC {
+		data, err = metadata.SetICC(data, img.Meta.ICCProfile)
+	}
+	if err == nil {
+		data, err = metadata.Set(data, opts.Tags)
+	}
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+	}
+	return data, nil
+}
+
+// hasTags reports whether tag has anything to write for img.
+func hasTags(img *core.ImageData, opts core.EncodeOptions) bool {
+	return !opts.Tags.IsZero() || opts.EmbedICC && len(img.Meta.ICCProfile) > 0
+}
+
+// taggable reports whether metadata.Set writes format.
+func taggable(format core.Format) bool {
+	return format == core.FormatJPEG || format == core.FormatPNG || format == core.FormatWebP
+}
+
+// countingWriter counts the bytes written through it.
+type countingWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ── Flatten ───────────────────────────────────────────────────────────────────
+
+// FlattenStep composites the image over a solid Background, removing
+// transparency.  EncodeStep applies it automatically, with
+// EncodeOptions.Background, when the target format has no alpha channel.
+type FlattenStep struct {
+	// Background is the colour behind transparent areas.  Default white.
+	Background color.Color
+}
+
+func (s *FlattenStep) Name() string { return "flatten" }
+
+func (s *FlattenStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	bg := s.Background
+	if bg == nil {
+		bg = color.White
+	}
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(bg), image.Point{}, draw.Src)
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Over)
 
 	out := *img
-	out.Data = data
-	out.Meta.SizeBytes = int64(len(data))
+	out.Image = dst
+	out.Meta.HasAlpha = false
+	if out.Meta.ColorSpace == core.ColorSpaceRGBA {
+		out.Meta.ColorSpace = core.ColorSpaceRGB
+	}
 	return &out, nil
 }
 
@@ -238,10 +731,23 @@ type AdaptiveCompressStep struct {
 
 func (s *AdaptiveCompressStep) Name() string { return "adaptive_compress" }
 
+// BindRegistry implements core.RegistryBinder.
+func (s *AdaptiveCompressStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
 func (s *AdaptiveCompressStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
 	if s.TargetSizeBytes <= 0 {
 		return img, nil
 	}
+	if s.Registry == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
 	enc, ok := s.Registry.EncoderFor(img.Format)
 	if !ok {
 		return img, nil // skip; unsupported format
@@ -257,12 +763,16 @@ func (s *AdaptiveCompressStep) Execute(ctx context.Context, img *core.ImageData)
 		step = 5
 	}
 
+	// Honour non-quality directives (strip, interlace, …); the search owns
+	// the quality.
+	opts := img.Attrs.ApplyEncode(core.EncodeOptions{})
 	var best []byte
 	for quality >= minQ {
 		if err := ctx.Err(); err != nil {
 			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
 		}
-		data, err := enc.Encode(ctx, img, core.EncodeOptions{Quality: quality})
+		opts.Quality = quality
+		data, err := enc.Encode(ctx, img, opts)
 		if err != nil {
 			return nil, err
 		}
@@ -276,18 +786,53 @@ func (s *AdaptiveCompressStep) Execute(ctx context.Context, img *core.ImageData)
 	out := *img
 	out.Data = best
 	out.Meta.SizeBytes = int64(len(best))
+	out.Codecs.Encoder = core.CodecName(enc)
 	return &out, nil
 }
 
 // ── Decode ────────────────────────────────────────────────────────────────────
 
 // DecodeStep decodes raw bytes in img.Data into an image.Image.
+//
+// When the decoder mapped to img.Format fails, or the format is unknown, the
+// step falls back to the other registered decoders that accept either the
+// hinted or the sniffed format, so mislabeled uploads still decode.
+// Options.Page selects a page of a multi-page source; only decoders that
+// implement core.PageDecoder are tried then.  Images whose header exceeds
+// Options.MaxPixels or MaxDecodedBytes fail with ErrImageTooLarge before
+// any decoder runs.  The file's EXIF, XMP and IPTC blocks are parsed into
+// Meta.Details, whichever decoder ran; Meta.EXIF and Orientation are filled
+// from them when the decoder left them empty.
 type DecodeStep struct {
 	Registry core.Registry
+	Options  core.DecodeOptions
 }
 
 func (s *DecodeStep) Name() string { return "decode" }
 
+// BindRegistry implements core.RegistryBinder.
+func (s *DecodeStep) BindRegistry(reg core.Registry) core.Step {
+	if s.Registry != nil {
+		return s
+	}
+	cp := *s
+	cp.Registry = reg
+	return &cp
+}
+
+// BindConfig implements core.ConfigBinder, applying cfg's decode limits
+// where Options leaves them at zero.
+func (s *DecodeStep) BindConfig(cfg config.Config) core.Step {
+	cp := *s
+	if cp.Options.MaxPixels == 0 {
+		cp.Options.MaxPixels = cfg.MaxPixels
+	}
+	if cp.Options.MaxDecodedBytes == 0 {
+		cp.Options.MaxDecodedBytes = cfg.MaxDecodedBytes
+	}
+	return &cp
+}
+
 func (s *DecodeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
 	if img.Image != nil {
 		return img, nil // already decoded
@@ -295,73 +840,206 @@ func (s *DecodeStep) Execute(ctx context.Context, img *core.ImageData) (*core.Im
 	if len(img.Data) == 0 {
 		return nil, apperrors.New(apperrors.CategoryDecode, s.Name(), apperrors.ErrEmptyInput)
 	}
-	dec, ok := s.Registry.DecoderFor(img.Format)
-	if !ok {
+	if s.Registry == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), apperrors.ErrNoRegistry)
+	}
+	if err := s.checkLimits(img.Data); err != nil {
+		return nil, err
+	}
+
+	chain := core.DecodersFor(s.Registry, img.Format)
+	if sniffed := core.Format(utils.DetectFormat(img.Data)); sniffed != img.Format {
+		chain = appendDecoders(chain, core.DecodersFor(s.Registry, sniffed)...)
+	}
+	if len(chain) == 0 {
 		return nil, apperrors.New(apperrors.CategoryDecode, s.Name(),
 			fmt.Errorf("%w: %s", apperrors.ErrUnsupportedFormat, img.Format))
 	}
 
-	decoded, err := dec.Decode(ctx, bytes.NewReader(img.Data))
-	if err != nil {
-		return nil, err
+	var firstErr error
+	for _, dec := range chain {
+		if err := ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryDecode, s.Name(), err)
+		}
+		var (
+			decoded *core.ImageData
+			err     error
+		)
+		if s.Options.Page > 1 {
+			pd, ok := dec.(core.PageDecoder)
+			if !ok {
+				continue
+			}
+			decoded, err = pd.DecodePage(ctx, img.Data, s.Options.Page)
+		} else {
+			decoded, err = dec.Decode(ctx, bytes.NewReader(img.Data))
+		}
+		if err != nil {
+			if firstErr == nil {
+				firstErr = err
+			}
+			continue
+		}
+		// Preserve the raw data bytes alongside the decoded representation.
+		decoded.Data = img.Data
+		decoded.OriginalSize = img.OriginalSize
+		decoded.Codecs.Decoder = core.CodecName(dec)
+		readDetails(decoded)
+		return decoded, nil
 	}
-
-	// Preserve the raw data bytes alongside the decoded representation.
-	decoded.Data = img.Data
-	decoded.OriginalSize = img.OriginalSize
-	return decoded, nil
+	if firstErr == nil {
+		return nil, apperrors.New(apperrors.CategoryDecode, s.Name(),
+			fmt.Errorf("%w: no page decoder for %s", apperrors.ErrUnsupportedFormat, img.Format))
+	}
+	return nil, firstErr
 }
 
-// ── Grayscale ─────────────────────────────────────────────────────────────────
-
-// GrayscaleStep converts the image to grayscale.
-type GrayscaleStep struct{}
-
-func (s *GrayscaleStep) Name() string { return "grayscale" }
+// readDetails parses the metadata blocks and colour profile of img.Data
+// into img.Meta.  It is best effort: a malformed block leaves the decoder's
+// metadata as it was.
+func readDetails(img *core.ImageData) {
+	if img.Meta.ICCProfile == nil {
+		img.Meta.ICCProfile = metadata.ICC(img.Data)
+	}
+	d, err := metadata.Read(img.Data)
+	if err != nil || d == nil {
+		return
+	}
+	img.Meta.Details = d
+	if img.Meta.EXIF == nil {
+		if m := metadata.Flatten(d); len(m) > 0 {
+			img.Meta.EXIF = m
+			img.Meta.HasEXIF = true
+		}
+	}
+	if img.Meta.Orientation == 0 {
+		img.Meta.Orientation = d.Orientation
+	}
+}
 
-func (s *GrayscaleStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrEmptyInput)
+// checkLimits rejects data whose header exceeds the step's limits.
+// Formats the header probe cannot read are left to the decoders, which
+// check their own limits.
+func (s *DecodeStep) checkLimits(data []byte) error {
+	if s.Options.IsZero() {
+		return nil
+	}
+	h, err := utils.ProbeHeader(data)
+	if err != nil {
+		return nil
 	}
+	return s.Options.Check(s.Name(), h.Width, h.Height, h.BytesPerPixel)
+}
 
-	bounds := src.Bounds()
-	dst := image.NewGray(bounds)
-	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
-		for x := bounds.Min.X; x < bounds.Max.X; x++ {
-			dst.Set(x, y, color.GrayModel.Convert(src.At(x, y)))
+// appendDecoders appends the decoders from extra that are not already in chain.
+func appendDecoders(chain []core.Decoder, extra ...core.Decoder) []core.Decoder {
+next:
+	for _, d := range extra {
+		for _, existing := range chain {
+			if existing == d {
+				continue next
+			}
 		}
+		chain = append(chain, d)
 	}
-
-	out := *img
-	out.Image = dst
-	out.Meta.ColorSpace = core.ColorSpaceGray
-	return &out, nil
+	return chain
 }
 
 // ── Watermark ─────────────────────────────────────────────────────────────────
 
-// WatermarkStep composites a watermark image onto the top-left corner.
+// WatermarkStep composites a watermark image onto the image.  Position
+// anchors it (default core.GravityNorthWest); the offsets are margins that
+// move it away from the anchored edges, or right and down from the centre.
+// A non-zero percentage offset takes precedence over the pixel field.
+//
+// Scale sizes the watermark relative to the image width, so one asset
+// suits every output size, and Tile repeats it over the whole frame with
+// Spacing pixels between copies, starting from the offsets.
 type WatermarkStep struct {
 	Watermark image.Image
 	OffsetX   int
 	OffsetY   int
+
+	Position                       core.Gravity
+	OffsetXPercent, OffsetYPercent float64
+	// Opacity in (0, 1] fades the watermark.  Default 1.
+	Opacity float64
+	// Scale is the watermark width as a fraction of the image width;
+	// 0 keeps its native size.
+	Scale   float64
+	Tile    bool
+	Spacing int
 }
 
 func (s *WatermarkStep) Name() string { return "watermark" }
 
-func (s *WatermarkStep) Execute(_ context.Context, img *core.ImageData) (*core.ImageData, error) {
-	src, ok := img.Image.(image.Image)
-	if !ok || src == nil {
-		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(), apperrors.ErrEmptyInput)
+func (s *WatermarkStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if s.Watermark == nil {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("no watermark image configured"))
 	}
 
-	dst := image.NewRGBA(src.Bounds())
-	draw.Draw(dst, dst.Bounds(), src, image.Point{}, draw.Src)
-	offset := image.Point{X: s.OffsetX, Y: s.OffsetY}
-	draw.Draw(dst, s.Watermark.Bounds().Add(offset), s.Watermark, image.Point{}, draw.Over)
+	b := src.Bounds()
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+
+	wm := s.Watermark
+	if s.Scale > 0 {
+		wb := wm.Bounds()
+		w := max(1, int(math.Round(s.Scale*float64(b.Dx()))))
+		h := max(1, int(math.Round(float64(w)*float64(wb.Dy())/float64(wb.Dx()))))
+		scaled := image.NewRGBA(image.Rect(0, 0, w, h))
+		xdraw.CatmullRom.Scale(scaled, scaled.Bounds(), wm, wb, draw.Src, nil)
+		wm = scaled
+	}
+	var mask image.Image
+	if s.Opacity > 0 && s.Opacity < 1 {
+		mask = image.NewUniform(color.Alpha{A: uint8(s.Opacity*255 + 0.5)})
+	}
+	wb := wm.Bounds()
+	ox := percentOr(s.OffsetXPercent, b.Dx(), s.OffsetX)
+	oy := percentOr(s.OffsetYPercent, b.Dy(), s.OffsetY)
+	place := func(x, y int) {
+		r := image.Rect(x, y, x+wb.Dx(), y+wb.Dy())
+		draw.DrawMask(dst, r, wm, wb.Min, mask, image.Point{}, draw.Over)
+	}
+
+	if s.Tile {
+		stepX, stepY := wb.Dx()+max(0, s.Spacing), wb.Dy()+max(0, s.Spacing)
+		// Start one tile before the edge so the pattern covers it.
+		x0, y0 := ox%stepX, oy%stepY
+		if x0 > 0 {
+			x0 -= stepX
+		}
+		if y0 > 0 {
+			y0 -= stepY
+		}
+		for y := y0; y < b.Dy(); y += stepY {
+			for x := x0; x < b.Dx(); x += stepX {
+				place(x, y)
+			}
+		}
+	} else {
+		g := s.Position
+		if g == "" {
+			g = core.GravityNorthWest
+		}
+		x, y := g.Offset(b.Dx(), b.Dy(), wb.Dx(), wb.Dy())
+		switch g {
+		case core.GravityEast, core.GravityNorthEast, core.GravitySouthEast:
+			ox = -ox
+		}
+		switch g {
+		case core.GravitySouth, core.GravitySouthEast, core.GravitySouthWest:
+			oy = -oy
+		}
+		place(x+ox, y+oy)
+	}
 
 	out := *img
 	out.Image = dst
 	return &out, nil
-}
\ No newline at end of file
+}
@@ -0,0 +1,78 @@
+package pipeline
+
+import (
+	"context"
+	"fmt"
+	"maps"
+
+	"github.com/Skryldev/image-processor/config"
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Tee ───────────────────────────────────────────────────────────────────────
+
+// TeeStep forks the image into a side branch: Steps run on a deep copy
+// (see core.ImageData.Clone) of the image as it stands and the result is stored in ImageData.Branches under
+// Branch, which the Processor returns as the variant of that name.  The
+// main pipeline carries on with the image unchanged, so a thumbnail can be
+// cut from the decoded, rotated image half way through without decoding
+// the source again.  A failing branch fails the pipeline.
+type TeeStep struct {
+	Branch string
+	Steps  []core.Step
+}
+
+func (s *TeeStep) Name() string { return "tee" }
+
+// BindRegistry implements core.RegistryBinder by binding the inner steps.
+func (s *TeeStep) BindRegistry(reg core.Registry) core.Step {
+	steps := make([]core.Step, len(s.Steps))
+	for i, st := range s.Steps {
+		if b, ok := st.(core.RegistryBinder); ok {
+			st = b.BindRegistry(reg)
+		}
+		steps[i] = st
+	}
+	return &TeeStep{Branch: s.Branch, Steps: steps}
+}
+
+// BindConfig implements core.ConfigBinder by binding the inner steps.
+func (s *TeeStep) BindConfig(cfg config.Config) core.Step {
+	steps := make([]core.Step, len(s.Steps))
+	for i, st := range s.Steps {
+		if b, ok := st.(core.ConfigBinder); ok {
+			st = b.BindConfig(cfg)
+		}
+		steps[i] = st
+	}
+	return &TeeStep{Branch: s.Branch, Steps: steps}
+}
+
+func (s *TeeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	if s.Branch == "" {
+		return nil, apperrors.New(apperrors.CategoryConfig, s.Name(), fmt.Errorf("branch name is empty"))
+	}
+	trunk := *img
+	trunk.Branches = nil
+	side, err := trunk.Clone()
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range s.Steps {
+		if err = ctx.Err(); err != nil {
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+		}
+		if side, err = st.Execute(ctx, side); err != nil {
+			return nil, err
+		}
+	}
+
+	out := *img
+	out.Branches = maps.Clone(img.Branches)
+	if out.Branches == nil {
+		out.Branches = make(map[string]*core.ImageData, 1)
+	}
+	out.Branches[s.Branch] = side
+	return &out, nil
+}
@@ -0,0 +1,140 @@
+package pipeline
+
+import (
+	"context"
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"strings"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/gofont/goregular"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/math/fixed"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── TextOverlay ───────────────────────────────────────────────────────────────
+
+// TextOverlayStep renders Text onto the image, e.g. the title of a
+// social-share card.  Lines break at "\n" and wrap at word boundaries to
+// fit between the Padding margins; the block is aligned by Position.
+// The result is *image.RGBA.  See the vips adapter's VipsTextOverlayStep
+// for a Pango-rendered variant.
+type TextOverlayStep struct {
+	Text string
+	// Font is TrueType or OpenType data; nil uses Go Regular.
+	Font []byte
+	// Size is the font size in pixels.  Default 5% of the image height,
+	// at least 12.
+	Size float64
+	// Color is the text colour.  Default white.
+	Color color.Color
+	// Position anchors the text block.  Default core.GravitySouth.
+	Position core.Gravity
+	// Padding is the margin between the block and the image edges, in
+	// pixels.  Default half of Size; negative means none.
+	Padding int
+}
+
+func (s *TextOverlayStep) Name() string { return "text_overlay" }
+
+func (s *TextOverlayStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	if strings.TrimSpace(s.Text) == "" {
+		return img, nil
+	}
+	b := src.Bounds()
+	size := s.Size
+	if size <= 0 {
+		size = math.Max(12, float64(b.Dy())*0.05)
+	}
+	pad := s.Padding
+	if pad == 0 {
+		pad = int(size / 2)
+	}
+	pad = max(0, pad)
+	data := s.Font
+	if data == nil {
+		data = goregular.TTF
+	}
+	f, err := opentype.Parse(data)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, s.Name(), err)
+	}
+	face, err := opentype.NewFace(f, &opentype.FaceOptions{Size: size, DPI: 72, Hinting: font.HintingFull})
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryConfig, s.Name(), err)
+	}
+	defer face.Close()
+
+	d := &font.Drawer{Face: face}
+	lines := wrapText(d, s.Text, fixed.I(b.Dx()-2*pad))
+	m := face.Metrics()
+	lineH := m.Height.Ceil()
+	blockW := 0
+	for _, l := range lines {
+		blockW = max(blockW, d.MeasureString(l).Ceil())
+	}
+	blockH := lineH * len(lines)
+
+	gravity := s.Position
+	if gravity == "" {
+		gravity = core.GravitySouth
+	}
+	bx, by := gravity.Offset(b.Dx()-2*pad, b.Dy()-2*pad, blockW, blockH)
+	bx, by = bx+pad, by+pad
+
+	dst := image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	col := s.Color
+	if col == nil {
+		col = color.White
+	}
+	d.Dst, d.Src = dst, image.NewUniform(col)
+	for i, l := range lines {
+		w := d.MeasureString(l).Ceil()
+		x := bx + (blockW-w)/2
+		switch gravity {
+		case core.GravityWest, core.GravityNorthWest, core.GravitySouthWest:
+			x = bx
+		case core.GravityEast, core.GravityNorthEast, core.GravitySouthEast:
+			x = bx + blockW - w
+		}
+		d.Dot = fixed.Point26_6{X: fixed.I(x), Y: fixed.I(by+i*lineH) + m.Ascent}
+		d.DrawString(l)
+	}
+
+	out := *img
+	out.Image = dst
+	return &out, nil
+}
+
+// wrapText splits text into lines no wider than maxW, breaking at "\n" and
+// between words.  A word wider than maxW gets a line of its own.
+func wrapText(d *font.Drawer, text string, maxW fixed.Int26_6) []string {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		line := ""
+		for _, word := range strings.Fields(para) {
+			if line == "" {
+				line = word
+				continue
+			}
+			if d.MeasureString(line+" "+word) > maxW {
+				lines = append(lines, line)
+				line = word
+				continue
+			}
+			line += " " + word
+		}
+		lines = append(lines, line)
+	}
+	return lines
+}
@@ -0,0 +1,91 @@
+package pipeline
+
+import (
+	"context"
+	"image/color"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// ── Trim ──────────────────────────────────────────────────────────────────────
+
+// TrimStep crops away uniform borders, such as the white margin of a scan or
+// product shot.  Rows and columns are trimmed from each edge while every
+// pixel stays within Tolerance of Background on all channels, alpha
+// included.  An image that is entirely background is left unchanged.  See
+// the vips adapter's VipsTrimStep for the libvips find_trim equivalent.
+type TrimStep struct {
+	// Tolerance is the largest per-channel difference (0-255) still counted
+	// as background.  Default 10; negative means exact matches only.
+	Tolerance int
+	// Background is the border colour.  Default the top-left pixel.
+	Background color.Color
+}
+
+func (s *TrimStep) Name() string { return "trim" }
+
+func (s *TrimStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	tol := s.Tolerance
+	if tol == 0 {
+		tol = 10
+	}
+	tol = max(0, tol)
+
+	m := cloneNRGBA(src)
+	bg := color.NRGBAModel.Convert(m.At(0, 0)).(color.NRGBA)
+	if s.Background != nil {
+		bg = color.NRGBAModel.Convert(s.Background).(color.NRGBA)
+	}
+	ref := [4]int{int(bg.R), int(bg.G), int(bg.B), int(bg.A)}
+	isBG := func(x, y int) bool {
+		p := m.Pix[m.PixOffset(x, y):]
+		for c := 0; c < 4; c++ {
+			if d := int(p[c]) - ref[c]; d > tol || d < -tol {
+				return false
+			}
+		}
+		return true
+	}
+	rowBG := func(y, x0, x1 int) bool {
+		for x := x0; x < x1; x++ {
+			if !isBG(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+	colBG := func(x, y0, y1 int) bool {
+		for y := y0; y < y1; y++ {
+			if !isBG(x, y) {
+				return false
+			}
+		}
+		return true
+	}
+
+	r := m.Rect
+	for r.Min.Y < r.Max.Y && rowBG(r.Min.Y, r.Min.X, r.Max.X) {
+		r.Min.Y++
+	}
+	if r.Empty() {
+		return img, nil
+	}
+	for rowBG(r.Max.Y-1, r.Min.X, r.Max.X) {
+		r.Max.Y--
+	}
+	for colBG(r.Min.X, r.Min.Y, r.Max.Y) {
+		r.Min.X++
+	}
+	for colBG(r.Max.X-1, r.Min.Y, r.Max.Y) {
+		r.Max.X--
+	}
+	if r == m.Rect {
+		return img, nil
+	}
+	return (&CropStep{X: r.Min.X, Y: r.Min.Y, Width: r.Dx(), Height: r.Dy()}).Execute(ctx, img)
+}
@@ -0,0 +1,73 @@
+package imageprocessor
+
+import (
+	"context"
+	"slices"
+	"sort"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// RegisterPreset stores steps under name, replacing any preset of that name,
+// so a service can keep a catalogue ("avatar", "hero", "og-image") and run
+// it with ProcessPreset or SubmitPreset instead of rebuilding the steps on
+// every request.  Steps are shared by every run and must be safe for
+// concurrent use, as all steps are.
+func (p *Processor) RegisterPreset(name string, steps ...core.Step) {
+	p.presetMu.Lock()
+	defer p.presetMu.Unlock()
+	if p.presets == nil {
+		p.presets = make(map[string][]core.Step)
+	}
+	p.presets[name] = slices.Clone(steps)
+}
+
+// Preset returns a copy of the steps registered under name.
+func (p *Processor) Preset(name string) ([]core.Step, bool) {
+	p.presetMu.RLock()
+	defer p.presetMu.RUnlock()
+	steps, ok := p.presets[name]
+	return slices.Clone(steps), ok
+}
+
+// Presets returns the registered preset names, sorted.
+func (p *Processor) Presets() []string {
+	p.presetMu.RLock()
+	defer p.presetMu.RUnlock()
+	names := make([]string, 0, len(p.presets))
+	for name := range p.presets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ProcessPreset runs the preset registered under name on src.  Unknown
+// names fail with apperrors.ErrUnknownPreset.
+func (p *Processor) ProcessPreset(ctx context.Context, src core.Source, name string) (*core.ProcessingResult, error) {
+	steps, err := p.presetSteps(name)
+	if err != nil {
+		return nil, err
+	}
+	return p.inner.Process(ctx, src, steps...)
+}
+
+// SubmitPreset enqueues job for the worker pool with the preset registered
+// under name in front of any steps the job already carries.
+func (p *Processor) SubmitPreset(name string, job core.Job) error {
+	steps, err := p.presetSteps(name)
+	if err != nil {
+		return err
+	}
+	job.Steps = append(steps, job.Steps...)
+	return p.inner.Submit(job)
+}
+
+func (p *Processor) presetSteps(name string) ([]core.Step, error) {
+	steps, ok := p.Preset(name)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, "preset."+name, apperrors.ErrUnknownPreset)
+	}
+	return steps, nil
+}
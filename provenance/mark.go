@@ -0,0 +1,219 @@
+// Package provenance embeds and detects invisible watermarks for tracking
+// licensed imagery.  A 32-bit payload is spread over the mid-frequency DCT
+// coefficients of 8×8 luma blocks, each bit repeated across many blocks in
+// a key-dependent order, so it survives JPEG recompression and moderate
+// colour or contrast edits.  Rescaling, rotation and crops that move the
+// 8-pixel block grid destroy it; embed after the final resize.
+package provenance
+
+import (
+	"hash/fnv"
+	"image"
+	"image/draw"
+	"math"
+	"math/rand/v2"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+const (
+	payloadBits = 32
+	checkBits   = 16
+	markBits    = payloadBits + checkBits
+
+	// minRepeats is the fewest blocks per bit that still decodes reliably.
+	minRepeats = 4
+
+	// DefaultStrength is the coefficient separation enforced per block.
+	// Higher survives harsher recompression at the cost of visible texture.
+	DefaultStrength = 24.0
+)
+
+// The coefficient pair compared in each block: symmetric mid frequencies,
+// coarse enough to survive JPEG quantisation, fine enough to stay invisible.
+var coefA, coefB = [2]int{2, 3}, [2]int{3, 2}
+
+// Mark is a watermark read back from an image.
+type Mark struct {
+	Payload uint32
+	// Confidence is the fraction of blocks agreeing with the decoded bits,
+	// from 0.5 (noise) to 1 (pristine).
+	Confidence float64
+}
+
+// MinPixels returns the smallest width×height that can carry a mark.
+func MinPixels() int { return markBits * minRepeats * 64 }
+
+// Embed returns a copy of src carrying payload, keyed by key.  Only the
+// key holder can read the mark back.  strength <= 0 selects DefaultStrength.
+func Embed(src image.Image, key string, payload uint32, strength float64) (*image.NRGBA, error) {
+	if strength <= 0 {
+		strength = DefaultStrength
+	}
+	dst := toNRGBA(src)
+	blocks := layout(dst.Rect, key)
+	if blocks == nil {
+		return nil, apperrors.ErrInvalidDimensions
+	}
+	bits := markWord(key, payload)
+
+	var y, coef [64]float64
+	for i, blk := range blocks {
+		bit := bits>>(i%markBits)&1 == 1
+		lumaBlock(dst, blk, &y)
+		dct8(&y, &coef)
+		a, b := coef[coefA[0]*8+coefA[1]], coef[coefB[0]*8+coefB[1]]
+		d := a - b
+		want := strength
+		if !bit {
+			want = -strength
+		}
+		if (bit && d >= strength) || (!bit && d <= -strength) {
+			continue
+		}
+		shift := (want - d) / 2
+		addBasis(dst, blk, coefA, shift)
+		addBasis(dst, blk, coefB, -shift)
+	}
+	return dst, nil
+}
+
+// Extract reads the mark keyed by key from img.  ok is false when no mark
+// made with this key is present.
+func Extract(img image.Image, key string) (m Mark, ok bool) {
+	px := toNRGBA(img)
+	blocks := layout(px.Rect, key)
+	if blocks == nil {
+		return Mark{}, false
+	}
+
+	var votes [markBits]float64
+	var y, coef [64]float64
+	signs := make([]float64, len(blocks))
+	for i, blk := range blocks {
+		lumaBlock(px, blk, &y)
+		dct8(&y, &coef)
+		s := 1.0
+		if coef[coefA[0]*8+coefA[1]]-coef[coefB[0]*8+coefB[1]] < 0 {
+			s = -1
+		}
+		signs[i] = s
+		votes[i%markBits] += s
+	}
+
+	var word uint64
+	for b, v := range votes {
+		if v > 0 {
+			word |= 1 << b
+		}
+	}
+	agree := 0
+	for i, s := range signs {
+		if (s > 0) == (word>>(i%markBits)&1 == 1) {
+			agree++
+		}
+	}
+	m = Mark{Payload: uint32(word), Confidence: float64(agree) / float64(len(signs))}
+	return m, markWord(key, m.Payload) == word && m.Confidence > 0.6
+}
+
+// markWord appends the key-derived check bits to payload.
+func markWord(key string, payload uint32) uint64 {
+	h := fnv.New32a()
+	h.Write([]byte(key))
+	h.Write([]byte{byte(payload), byte(payload >> 8), byte(payload >> 16), byte(payload >> 24)})
+	return uint64(payload) | uint64(h.Sum32()&(1<<checkBits-1))<<payloadBits
+}
+
+// layout returns the top-left corners of the 8×8 blocks used for the mark
+// in a key-dependent order, or nil when r is too small.
+func layout(r image.Rectangle, key string) []image.Point {
+	bw, bh := r.Dx()/8, r.Dy()/8
+	if bw*bh < markBits*minRepeats {
+		return nil
+	}
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	seed := h.Sum64()
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+
+	n := bw * bh
+	n -= n % markBits // equal repeats per bit
+	perm := rng.Perm(bw * bh)[:n]
+	blocks := make([]image.Point, n)
+	for i, p := range perm {
+		blocks[i] = image.Pt(r.Min.X+(p%bw)*8, r.Min.Y+(p/bw)*8)
+	}
+	return blocks
+}
+
+func lumaBlock(m *image.NRGBA, at image.Point, y *[64]float64) {
+	for j := 0; j < 8; j++ {
+		o := m.PixOffset(at.X, at.Y+j)
+		for i := 0; i < 8; i++ {
+			p := m.Pix[o+i*4 : o+i*4+3 : o+i*4+3]
+			y[j*8+i] = 0.299*float64(p[0]) + 0.587*float64(p[1]) + 0.114*float64(p[2])
+		}
+	}
+}
+
+// addBasis adds amount × the (u, v) DCT basis function to the block's luma
+// by shifting R, G and B equally.
+func addBasis(m *image.NRGBA, at image.Point, uv [2]int, amount float64) {
+	for j := 0; j < 8; j++ {
+		o := m.PixOffset(at.X, at.Y+j)
+		for i := 0; i < 8; i++ {
+			d := amount * dctCos[uv[0]][j] * dctCos[uv[1]][i]
+			for c := 0; c < 3; c++ {
+				v := float64(m.Pix[o+i*4+c]) + d
+				m.Pix[o+i*4+c] = uint8(math.Max(0, math.Min(255, math.Round(v))))
+			}
+		}
+	}
+}
+
+// dctCos[k][n] is the orthonormal DCT-II basis value for frequency k at
+// sample n.
+var dctCos = func() (t [8][8]float64) {
+	for k := 0; k < 8; k++ {
+		scale := math.Sqrt(2.0 / 8)
+		if k == 0 {
+			scale = math.Sqrt(1.0 / 8)
+		}
+		for n := 0; n < 8; n++ {
+			t[k][n] = scale * math.Cos(math.Pi*float64(2*n+1)*float64(k)/16)
+		}
+	}
+	return t
+}()
+
+// dct8 computes the 2-D orthonormal DCT of an 8×8 block (row-major, rows
+// are v, columns u).
+func dct8(in, out *[64]float64) {
+	var tmp [64]float64
+	for j := 0; j < 8; j++ {
+		for k := 0; k < 8; k++ {
+			var s float64
+			for n := 0; n < 8; n++ {
+				s += in[j*8+n] * dctCos[k][n]
+			}
+			tmp[j*8+k] = s
+		}
+	}
+	for k := 0; k < 8; k++ {
+		for i := 0; i < 8; i++ {
+			var s float64
+			for n := 0; n < 8; n++ {
+				s += tmp[n*8+i] * dctCos[k][n]
+			}
+			out[k*8+i] = s
+		}
+	}
+}
+
+func toNRGBA(src image.Image) *image.NRGBA {
+	b := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+	draw.Draw(dst, dst.Bounds(), src, b.Min, draw.Src)
+	return dst
+}
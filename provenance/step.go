@@ -0,0 +1,76 @@
+package provenance
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// Attribute keys set by VerifyStep.
+const (
+	AttrFound      = "provenance.found"      // bool
+	AttrPayload    = "provenance.payload"    // uint32, when found
+	AttrConfidence = "provenance.confidence" // float64, when found
+)
+
+// EmbedStep watermarks the image with Payload under Key.  Place it after
+// the last resize or crop and before Encode.
+type EmbedStep struct {
+	Key      string
+	Payload  uint32
+	Strength float64 // default DefaultStrength
+}
+
+func (s *EmbedStep) Name() string { return "provenance_embed" }
+
+func (s *EmbedStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	dst, err := Embed(src, s.Key, s.Payload, s.Strength)
+	if err != nil {
+		b := src.Bounds()
+		return nil, apperrors.New(apperrors.CategoryPipeline, s.Name(),
+			fmt.Errorf("%w: %dx%d is below the %d pixels a mark needs", err, b.Dx(), b.Dy(), MinPixels()))
+	}
+	out := *img
+	out.Image = dst
+	out.Meta.ColorSpace = core.ColorSpaceRGBA
+	return &out, nil
+}
+
+// VerifyStep looks for a mark made with Key and records the outcome in
+// Attrs (AttrFound, AttrPayload, AttrConfidence).  With Require it fails
+// with ErrNoWatermark when no mark is found, or when Expect is set and the
+// payload differs.
+type VerifyStep struct {
+	Key     string
+	Require bool
+	Expect  *uint32
+}
+
+func (s *VerifyStep) Name() string { return "provenance_verify" }
+
+func (s *VerifyStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	src, err := core.StdImage(ctx, img)
+	if err != nil {
+		return nil, apperrors.Wrap(apperrors.CategoryPipeline, s.Name(), err)
+	}
+	m, found := Extract(src, s.Key)
+	if found && s.Expect != nil && m.Payload != *s.Expect {
+		found = false
+	}
+	if s.Require && !found {
+		return nil, apperrors.New(apperrors.CategoryInput, s.Name(), apperrors.ErrNoWatermark)
+	}
+
+	out := *img
+	out.Attrs = img.Attrs.With(AttrFound, found)
+	if found {
+		out.Attrs = out.Attrs.With(AttrPayload, m.Payload).With(AttrConfidence, m.Confidence)
+	}
+	return &out, nil
+}
@@ -0,0 +1,156 @@
+package imageprocessor
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strconv"
+	"sync"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/pipeline"
+	"github.com/Skryldev/image-processor/sprite"
+)
+
+// SpriteSheet thumbnails every source on the worker pool (starting it if
+// needed) and packs the thumbnails, in source order, into one sheet encoded
+// as opts.Format.  Frame names come from Source.Name, or the source index
+// when empty.  When the queue is full the remaining thumbnails are made on
+// the calling goroutine instead of failing.
+func (p *Processor) SpriteSheet(ctx context.Context, sources []core.Source, opts sprite.Options) (*sprite.Sheet, error) {
+	if len(sources) == 0 {
+		return nil, apperrors.New(apperrors.CategoryInput, "sprite", apperrors.ErrEmptyInput)
+	}
+	opts = opts.WithDefaults()
+	enc, ok := p.reg.EncoderFor(opts.Format)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryEncode, "sprite",
+			fmt.Errorf("%w: %s", apperrors.ErrUnsupportedFormat, opts.Format))
+	}
+
+	p.Start()
+	steps := []core.Step{
+		Decode(),
+		&pipeline.ThumbnailStep{Width: opts.CellWidth, Height: opts.CellHeight, Fit: opts.Fit, Background: opts.Background},
+	}
+	// Buffered so workers never block on a caller that gave up early.
+	results := make(chan core.JobResult, len(sources))
+	for i, src := range sources {
+		job := core.Job{ID: strconv.Itoa(i), Ctx: ctx, Source: src, Steps: steps, ResultCh: results}
+		if err := p.inner.Submit(job); err != nil {
+			r, err := p.inner.Process(ctx, src, steps...)
+			results <- core.JobResult{JobID: job.ID, Result: r, Err: err}
+		}
+	}
+
+	cells := make([]sprite.Cell, len(sources))
+	for range sources {
+		var jr core.JobResult
+		select {
+		case <-ctx.Done():
+			return nil, apperrors.Wrap(apperrors.CategoryPipeline, "sprite", ctx.Err())
+		case jr = <-results:
+		}
+		if jr.Err != nil {
+			return nil, jr.Err
+		}
+		i, _ := strconv.Atoi(jr.JobID)
+		thumb, ok := jr.Result.Primary.Image.(image.Image)
+		if !ok {
+			return nil, apperrors.New(apperrors.CategoryPipeline, "sprite",
+				fmt.Errorf("source %d: thumbnail is %T, not image.Image", i, jr.Result.Primary.Image))
+		}
+		name := sources[i].Name
+		if name == "" {
+			name = jr.JobID
+		}
+		cells[i] = sprite.Cell{Name: name, Image: thumb}
+	}
+
+	sheet, m := sprite.Pack(cells, opts)
+	img := &core.ImageData{
+		Image:  sheet,
+		Format: opts.Format,
+		Meta: core.Metadata{
+			Width:      m.Width,
+			Height:     m.Height,
+			Format:     opts.Format,
+			ColorSpace: core.ColorSpaceRGBA,
+			HasAlpha:   opts.Background == nil,
+		},
+	}
+	data, err := enc.Encode(ctx, img, core.EncodeOptions{Quality: opts.Quality})
+	if err != nil {
+		return nil, err
+	}
+	img.Data = data
+	img.Meta.SizeBytes = int64(len(data))
+	return &sprite.Sheet{Image: img, Map: m}, nil
+}
+
+// SpriteSheetFromStorage builds a sheet from every key under prefix in
+// bucket.  store must implement core.Lister; frames are named by key path.
+func (p *Processor) SpriteSheetFromStorage(ctx context.Context, store core.StorageAdapter, bucket, prefix string, opts sprite.Options) (*sprite.Sheet, error) {
+	lister, ok := store.(core.Lister)
+	if !ok {
+		return nil, apperrors.New(apperrors.CategoryConfig, "sprite",
+			fmt.Errorf("storage adapter %T cannot list keys", store))
+	}
+	keys, err := lister.List(ctx, bucket, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	sources := make([]core.Source, len(keys))
+	readers := make([]*lazyReader, len(keys))
+	for i, key := range keys {
+		readers[i] = &lazyReader{open: func() (io.ReadCloser, error) { return store.Get(ctx, key) }}
+		sources[i] = core.Source{Reader: readers[i], Name: key.Path, Size: -1}
+	}
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+	}()
+	return p.SpriteSheet(ctx, sources, opts)
+}
+
+// lazyReader defers opening a stored object until a worker first reads it,
+// so large prefixes do not hold every object open at once.
+type lazyReader struct {
+	open func() (io.ReadCloser, error)
+
+	mu  sync.Mutex
+	rc  io.ReadCloser
+	err error
+}
+
+func (l *lazyReader) Read(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rc == nil && l.err == nil {
+		l.rc, l.err = l.open()
+	}
+	if l.err != nil {
+		return 0, l.err
+	}
+	n, err := l.rc.Read(p)
+	if err == io.EOF {
+		l.rc.Close()
+		l.err = io.EOF
+	}
+	return n, err
+}
+
+func (l *lazyReader) Close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.rc != nil && l.err == nil {
+		l.rc.Close()
+	}
+	if l.err == nil {
+		l.err = io.ErrClosedPipe
+	}
+}
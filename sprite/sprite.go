@@ -0,0 +1,112 @@
+// Package sprite packs thumbnails into a single sprite / contact sheet image
+// and describes where each one landed, for video scrubbing previews and
+// asset review pages.  Processor.SpriteSheet in the root package runs the
+// thumbnailing on the worker pool and calls Pack.
+package sprite
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+
+	"github.com/Skryldev/image-processor/core"
+	"github.com/Skryldev/image-processor/pipeline"
+)
+
+// Options controls the sheet layout.
+type Options struct {
+	CellWidth, CellHeight int // thumbnail box; default 160×90
+	// Columns per row; 0 = the smallest count giving a roughly square sheet.
+	Columns int
+	Gap     int          // pixels between cells and around the edge
+	Fit     pipeline.Fit // default FitCover
+	// Background fills gaps and FitContain padding; nil = transparent.
+	Background color.Color
+	Format     core.Format // output encoding; default PNG
+	Quality    int         // encode quality; 0 = encoder default
+}
+
+// WithDefaults returns o with zero fields replaced by their defaults.
+func (o Options) WithDefaults() Options {
+	if o.CellWidth <= 0 {
+		o.CellWidth = 160
+	}
+	if o.CellHeight <= 0 {
+		o.CellHeight = 90
+	}
+	if o.Fit == "" {
+		o.Fit = pipeline.FitCover
+	}
+	if o.Format == "" || o.Format == core.FormatUnknown {
+		o.Format = core.FormatPNG
+	}
+	return o
+}
+
+// Cell is one thumbnail to place.
+type Cell struct {
+	Name  string
+	Image image.Image
+}
+
+// Frame locates one cell inside the sheet.
+type Frame struct {
+	Name   string `json:"name"`
+	X      int    `json:"x"`
+	Y      int    `json:"y"`
+	Width  int    `json:"width"`
+	Height int    `json:"height"`
+}
+
+// Map is the JSON coordinate map that accompanies a sheet.
+type Map struct {
+	Width   int     `json:"width"`
+	Height  int     `json:"height"`
+	Columns int     `json:"columns"`
+	Rows    int     `json:"rows"`
+	Frames  []Frame `json:"frames"`
+}
+
+// Sheet is a packed, encoded sprite sheet and its coordinate map.
+type Sheet struct {
+	Image *core.ImageData
+	Map   Map
+}
+
+// Pack draws cells row by row into a grid of opts.CellWidth×opts.CellHeight
+// slots.  Images smaller than a slot are centred in it.
+func Pack(cells []Cell, opts Options) (*image.NRGBA, Map) {
+	opts = opts.WithDefaults()
+	n := len(cells)
+	cols := opts.Columns
+	if cols <= 0 {
+		cols = int(math.Ceil(math.Sqrt(float64(n))))
+	}
+	cols = max(1, min(cols, max(n, 1)))
+	rows := max(1, (n+cols-1)/cols)
+
+	m := Map{
+		Width:   cols*opts.CellWidth + (cols+1)*opts.Gap,
+		Height:  rows*opts.CellHeight + (rows+1)*opts.Gap,
+		Columns: cols,
+		Rows:    rows,
+		Frames:  make([]Frame, 0, n),
+	}
+	dst := image.NewNRGBA(image.Rect(0, 0, m.Width, m.Height))
+	if opts.Background != nil {
+		draw.Draw(dst, dst.Bounds(), image.NewUniform(opts.Background), image.Point{}, draw.Src)
+	}
+
+	for i, c := range cells {
+		slotX := opts.Gap + (i%cols)*(opts.CellWidth+opts.Gap)
+		slotY := opts.Gap + (i/cols)*(opts.CellHeight+opts.Gap)
+		b := c.Image.Bounds()
+		w, h := min(b.Dx(), opts.CellWidth), min(b.Dy(), opts.CellHeight)
+		x := slotX + (opts.CellWidth-w)/2
+		y := slotY + (opts.CellHeight-h)/2
+		draw.Draw(dst, image.Rect(x, y, x+w, y+h), c.Image, b.Min, draw.Over)
+		m.Frames = append(m.Frames, Frame{Name: c.Name, X: x, Y: y, Width: w, Height: h})
+	}
+	return dst, m
+}
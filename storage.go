@@ -0,0 +1,164 @@
+package imageprocessor
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/manifest"
+)
+
+// Written describes one object stored by ProcessFromStorage.
+type Written struct {
+	Variant string // "" for the primary output
+	Key     core.StorageKey
+	Format  core.Format
+	Size    int64
+}
+
+// StorageResult is returned by ProcessFromStorage and
+// ProcessVariantsFromStorage.
+type StorageResult struct {
+	*core.ProcessingResult
+	Written []Written // primary first, then variants sorted by name
+}
+
+// ProcessFromStorage reads in from store, runs steps and writes the result
+// back to the key produced by expanding outTemplate (see OutputKey).  steps
+// should end with Encode so the stored bytes reflect the pipeline; without
+// it the source bytes are written unchanged.
+func (p *Processor) ProcessFromStorage(ctx context.Context, store core.StorageAdapter, in core.StorageKey, outTemplate string, steps ...core.Step) (*StorageResult, error) {
+	rc, err := store.Get(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	res, err := p.Process(ctx, core.Source{Reader: rc, Name: in.Path, Size: -1}, steps...)
+	if err != nil {
+		return nil, err
+	}
+	return p.writeOutputs(ctx, store, in, outTemplate, res)
+}
+
+// ProcessVariantsFromStorage is ProcessFromStorage for ProcessVariants: the
+// primary result and every variant are written, each to outTemplate expanded
+// with its variant name.  Include {variant} in the template so the keys do
+// not collide.
+func (p *Processor) ProcessVariantsFromStorage(ctx context.Context, store core.StorageAdapter, in core.StorageKey, outTemplate string, baseSteps []core.Step, variants []core.VariantDefinition) (*StorageResult, error) {
+	if !strings.Contains(outTemplate, "{variant}") {
+		return nil, apperrors.New(apperrors.CategoryConfig, "storage.variants",
+			fmt.Errorf("output template %q lacks {variant}", outTemplate))
+	}
+	rc, err := store.Get(ctx, in)
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+
+	res, err := p.ProcessVariants(ctx, core.Source{Reader: rc, Name: in.Path, Size: -1}, baseSteps, variants)
+	if err != nil {
+		return nil, err
+	}
+	return p.writeOutputs(ctx, store, in, outTemplate, res)
+}
+
+func (p *Processor) writeOutputs(ctx context.Context, store core.StorageAdapter, in core.StorageKey, tmpl string, res *core.ProcessingResult) (*StorageResult, error) {
+	out := &StorageResult{ProcessingResult: res}
+	put := func(variant string, img *core.ImageData) error {
+		if len(img.Data) == 0 {
+			return apperrors.New(apperrors.CategoryStorage, "storage.put",
+				fmt.Errorf("%w: output %q has no encoded bytes", apperrors.ErrEmptyInput, variant))
+		}
+		key := OutputKey(tmpl, in, variant, img.Format)
+		meta := map[string]string{"Content-Type": img.Format.ContentType()}
+		if err := store.Put(ctx, key, bytes.NewReader(img.Data), meta); err != nil {
+			return err
+		}
+		out.Written = append(out.Written, Written{Variant: variant, Key: key, Format: img.Format, Size: int64(len(img.Data))})
+		return nil
+	}
+
+	if err := put("", res.Primary); err != nil {
+		return nil, err
+	}
+	names := make([]string, 0, len(res.Variants))
+	for name := range res.Variants {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := put(name, res.Variants[name]); err != nil {
+			return nil, err
+		}
+	}
+	return out, nil
+}
+
+// Manifest describes every written output, with storage keys filled in
+// from Written.
+func (r *StorageResult) Manifest(opts manifest.Options) (*manifest.Manifest, error) {
+	keys := make(map[string]core.StorageKey, len(r.Written)+len(opts.Keys))
+	for k, v := range opts.Keys {
+		keys[k] = v
+	}
+	for _, w := range r.Written {
+		keys[w.Variant] = w.Key
+	}
+	opts.Keys = keys
+	return manifest.Build(r.ProcessingResult, opts)
+}
+
+// UploadManifest stores r.Manifest(opts) as JSON at key, typically next to
+// the outputs, and returns it.
+func (r *StorageResult) UploadManifest(ctx context.Context, store core.StorageAdapter, key core.StorageKey, opts manifest.Options) (*manifest.Manifest, error) {
+	m, err := r.Manifest(opts)
+	if err != nil {
+		return nil, err
+	}
+	if err := manifest.Upload(ctx, store, key, m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// OutputKey expands an output key template for source key in.  The result
+// stays in in's bucket.  Placeholders:
+//
+//	{path}     source path without extension   photos/2024/cat
+//	{dir}      source directory                photos/2024
+//	{name}     source file name w/o extension  cat
+//	{ext}      output extension                jpg
+//	{variant}  variant name ("" for primary)   thumb
+//
+// Separators left dangling by an empty {variant} ("cat_.jpg", "a//b") are
+// tidied away.
+func OutputKey(tmpl string, in core.StorageKey, variant string, f core.Format) core.StorageKey {
+	ext := path.Ext(in.Path)
+	noExt := strings.TrimSuffix(in.Path, ext)
+	dir := path.Dir(in.Path)
+	if dir == "." {
+		dir = ""
+	}
+	r := strings.NewReplacer(
+		"{path}", noExt,
+		"{dir}", dir,
+		"{name}", path.Base(noExt),
+		"{ext}", f.Ext(),
+		"{variant}", variant,
+	)
+	key := r.Replace(tmpl)
+	if variant == "" {
+		for _, sep := range []string{"_", "-", "."} {
+			key = strings.ReplaceAll(key, sep+".", ".")
+			key = strings.ReplaceAll(key, sep+"/", "/")
+		}
+	}
+	key = strings.TrimPrefix(path.Clean("/"+key), "/")
+	return core.StorageKey{Bucket: in.Bucket, Path: key}
+}
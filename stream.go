@@ -0,0 +1,74 @@
+package imageprocessor
+
+import (
+	"context"
+	"io"
+
+	"github.com/Skryldev/image-processor/core"
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"github.com/Skryldev/image-processor/pipeline"
+)
+
+// ProcessStream runs steps on src and writes the encoded output to w, such
+// as an HTTP response or a multipart upload, instead of returning it in
+// Primary.Data.  Encoders implementing core.StreamEncoder (the built-in
+// JPEG, PNG, GIF and TIFF encoders) write straight to w, so very large
+// outputs are never held in memory as a whole.  A trailing Encode step in
+// steps supplies the encode options; without one the image is encoded with
+// defaults.  The encode runs as the last step of the pipeline, inside the
+// memory budget and seen by hooks and counters like any other; streamed
+// runs are never cached or coalesced.  The result's Primary carries no
+// Data; Meta.SizeBytes is the number of bytes written.  On error, part of
+// the output may already have been written to w.
+func (p *Processor) ProcessStream(ctx context.Context, src core.Source, w io.Writer, steps ...core.Step) (*core.ProcessingResult, error) {
+	enc := &pipeline.EncodeStep{}
+	if n := len(steps); n > 0 {
+		if last, ok := steps[n-1].(*pipeline.EncodeStep); ok {
+			enc, steps = last, steps[:n-1]
+		}
+	}
+	if bound, ok := p.inner.Bind(enc).(*pipeline.EncodeStep); ok {
+		enc = bound
+	}
+	sink := &streamEncodeStep{enc: enc, w: &sinkWriter{w: w}}
+	return p.inner.Process(ctx, src, append(steps[:len(steps):len(steps)], sink)...)
+}
+
+// streamEncodeStep is the final step of ProcessStream: enc writing to w.
+type streamEncodeStep struct {
+	enc *pipeline.EncodeStep
+	w   *sinkWriter
+}
+
+func (s *streamEncodeStep) Name() string { return s.enc.Name() }
+
+// FingerprintParams implements core.Fingerprintable.  Output goes to a
+// writer no fingerprint can identify, so its Write method is listed: a
+// function leaves the pipeline without a fingerprint, and Process neither
+// caches nor coalesces it.
+func (s *streamEncodeStep) FingerprintParams() map[string]any {
+	return map[string]any{"encode": s.enc, "sink": s.w.Write}
+}
+
+func (s *streamEncodeStep) Execute(ctx context.Context, img *core.ImageData) (*core.ImageData, error) {
+	out, err := s.enc.EncodeTo(ctx, s.w, img)
+	if err != nil && s.w.wrote {
+		// Bytes already sent cannot be taken back, so no retry.
+		return nil, apperrors.Wrap(apperrors.CategoryEncode, s.Name(), err)
+	}
+	return out, err
+}
+
+// sinkWriter records whether anything reached w.
+type sinkWriter struct {
+	w     io.Writer
+	wrote bool
+}
+
+func (s *sinkWriter) Write(b []byte) (int, error) {
+	n, err := s.w.Write(b)
+	if n > 0 {
+		s.wrote = true
+	}
+	return n, err
+}
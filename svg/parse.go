@@ -0,0 +1,319 @@
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"image/color"
+	"io"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/colornames"
+)
+
+// node is one parsed element.  Attribute keys are local names, so
+// xlink:href is "href".
+type node struct {
+	name     string
+	attrs    map[string]string
+	children []*node
+}
+
+// maxNodes bounds the element count of a document.
+const maxNodes = 100000
+
+func parseDocument(data []byte) (*node, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	var (
+		root  *node
+		stack []*node
+		count int
+	)
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if count++; count > maxNodes {
+				return nil, errors.New("too many elements")
+			}
+			n := &node{name: t.Name.Local, attrs: make(map[string]string, len(t.Attr))}
+			for _, a := range t.Attr {
+				n.attrs[a.Name.Local] = a.Value
+			}
+			if len(stack) == 0 {
+				if root != nil {
+					return nil, errors.New("multiple root elements")
+				}
+				root = n
+			} else {
+				p := stack[len(stack)-1]
+				p.children = append(p.children, n)
+			}
+			stack = append(stack, n)
+		case xml.EndElement:
+			if len(stack) > 0 {
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+	if root == nil || root.name != "svg" {
+		return nil, errors.New("no <svg> root element")
+	}
+	return root, nil
+}
+
+// index maps element ids to nodes, for <use> and paint servers.
+func index(n *node, ids map[string]*node) {
+	if id := n.attrs["id"]; id != "" {
+		if _, dup := ids[id]; !dup {
+			ids[id] = n
+		}
+	}
+	for _, c := range n.children {
+		index(c, ids)
+	}
+}
+
+// scanner reads SVG number lists, where separators are optional wherever
+// the grammar allows ("1-2", ".5.5").
+type scanner struct {
+	s string
+	i int
+}
+
+func (sc *scanner) skipSep() {
+	for sc.i < len(sc.s) {
+		switch sc.s[sc.i] {
+		case ' ', '\t', '\n', '\r', ',':
+			sc.i++
+		default:
+			return
+		}
+	}
+}
+
+func (sc *scanner) done() bool {
+	sc.skipSep()
+	return sc.i >= len(sc.s)
+}
+
+func (sc *scanner) number() (float64, bool) {
+	sc.skipSep()
+	start, i := sc.i, sc.i
+	if i < len(sc.s) && (sc.s[i] == '+' || sc.s[i] == '-') {
+		i++
+	}
+	digits, dot := false, false
+	for ; i < len(sc.s); i++ {
+		c := sc.s[i]
+		if c >= '0' && c <= '9' {
+			digits = true
+		} else if c == '.' && !dot {
+			dot = true
+		} else {
+			break
+		}
+	}
+	if !digits {
+		return 0, false
+	}
+	if i < len(sc.s) && (sc.s[i] == 'e' || sc.s[i] == 'E') {
+		j := i + 1
+		if j < len(sc.s) && (sc.s[j] == '+' || sc.s[j] == '-') {
+			j++
+		}
+		if j < len(sc.s) && sc.s[j] >= '0' && sc.s[j] <= '9' {
+			for j < len(sc.s) && sc.s[j] >= '0' && sc.s[j] <= '9' {
+				j++
+			}
+			i = j
+		}
+	}
+	v, err := strconv.ParseFloat(sc.s[start:i], 64)
+	if err != nil {
+		return 0, false
+	}
+	sc.i = i
+	return v, true
+}
+
+// flag reads an arc flag, which may be written without a separator.
+func (sc *scanner) flag() (bool, bool) {
+	sc.skipSep()
+	if sc.i < len(sc.s) && (sc.s[sc.i] == '0' || sc.s[sc.i] == '1') {
+		sc.i++
+		return sc.s[sc.i-1] == '1', true
+	}
+	return false, false
+}
+
+func numbers(s string) []float64 {
+	sc := &scanner{s: s}
+	var out []float64
+	for {
+		v, ok := sc.number()
+		if !ok {
+			return out
+		}
+		out = append(out, v)
+	}
+}
+
+// parseLength converts a length to user units; percentages are of ref.
+func parseLength(s string, ref float64) (float64, bool) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return 0, false
+	}
+	if p, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		return v / 100 * ref, err == nil
+	}
+	unit := 1.0
+	for _, u := range []struct {
+		suffix string
+		scale  float64
+	}{{"px", 1}, {"pt", 96.0 / 72}, {"pc", 16}, {"in", 96}, {"cm", 96 / 2.54}, {"mm", 96 / 25.4}, {"em", 16}, {"ex", 8}} {
+		if v, ok := strings.CutSuffix(s, u.suffix); ok {
+			s, unit = strings.TrimSpace(v), u.scale
+			break
+		}
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, false
+	}
+	return v * unit, true
+}
+
+// matrix is an affine transform: x' = a·x + c·y + e, y' = b·x + d·y + f.
+type matrix struct{ a, b, c, d, e, f float64 }
+
+var identity = matrix{a: 1, d: 1}
+
+// mul returns m·n, which applies n first.
+func (m matrix) mul(n matrix) matrix {
+	return matrix{
+		a: m.a*n.a + m.c*n.b,
+		b: m.b*n.a + m.d*n.b,
+		c: m.a*n.c + m.c*n.d,
+		d: m.b*n.c + m.d*n.d,
+		e: m.a*n.e + m.c*n.f + m.e,
+		f: m.b*n.e + m.d*n.f + m.f,
+	}
+}
+
+func (m matrix) apply(p pt) pt {
+	return pt{m.a*p.x + m.c*p.y + m.e, m.b*p.x + m.d*p.y + m.f}
+}
+
+// scale is the factor m scales lengths by on average.
+func (m matrix) scale() float64 { return math.Sqrt(math.Abs(m.a*m.d - m.b*m.c)) }
+
+func translate(x, y float64) matrix { return matrix{a: 1, d: 1, e: x, f: y} }
+
+var transformFunc = regexp.MustCompile(`([a-zA-Z]+)\s*\(([^)]*)\)`)
+
+func parseTransform(s string) matrix {
+	m := identity
+	for _, f := range transformFunc.FindAllStringSubmatch(s, -1) {
+		v := numbers(f[2])
+		arg := func(i int, def float64) float64 {
+			if i < len(v) {
+				return v[i]
+			}
+			return def
+		}
+		var t matrix
+		switch f[1] {
+		case "matrix":
+			if len(v) < 6 {
+				continue
+			}
+			t = matrix{v[0], v[1], v[2], v[3], v[4], v[5]}
+		case "translate":
+			t = translate(arg(0, 0), arg(1, 0))
+		case "scale":
+			sx := arg(0, 1)
+			t = matrix{a: sx, d: arg(1, sx)}
+		case "rotate":
+			r := arg(0, 0) * math.Pi / 180
+			cx, cy := arg(1, 0), arg(2, 0)
+			sin, cos := math.Sincos(r)
+			t = translate(cx, cy).mul(matrix{a: cos, b: sin, c: -sin, d: cos}).mul(translate(-cx, -cy))
+		case "skewX":
+			t = matrix{a: 1, c: math.Tan(arg(0, 0) * math.Pi / 180), d: 1}
+		case "skewY":
+			t = matrix{a: 1, b: math.Tan(arg(0, 0) * math.Pi / 180), d: 1}
+		default:
+			continue
+		}
+		m = m.mul(t)
+	}
+	return m
+}
+
+// parseColor reads a CSS colour: #rgb, #rgba, #rrggbb, #rrggbbaa, rgb(),
+// rgba() or a named colour.
+func parseColor(s string) (color.NRGBA, bool) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	if hex, ok := strings.CutPrefix(s, "#"); ok {
+		v, err := strconv.ParseUint(hex, 16, 32)
+		if err != nil {
+			return color.NRGBA{}, false
+		}
+		switch len(hex) {
+		case 3:
+			return color.NRGBA{uint8(v>>8&0xf) * 17, uint8(v>>4&0xf) * 17, uint8(v&0xf) * 17, 255}, true
+		case 4:
+			return color.NRGBA{uint8(v>>12&0xf) * 17, uint8(v>>8&0xf) * 17, uint8(v>>4&0xf) * 17, uint8(v&0xf) * 17}, true
+		case 6:
+			return color.NRGBA{uint8(v >> 16), uint8(v >> 8), uint8(v), 255}, true
+		case 8:
+			return color.NRGBA{uint8(v >> 24), uint8(v >> 16), uint8(v >> 8), uint8(v)}, true
+		}
+		return color.NRGBA{}, false
+	}
+	if args, ok := strings.CutPrefix(s, "rgb"); ok {
+		args = strings.TrimPrefix(args, "a")
+		args = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(args), "("), ")")
+		parts := strings.FieldsFunc(args, func(r rune) bool { return r == ',' || r == ' ' || r == '/' })
+		if len(parts) < 3 {
+			return color.NRGBA{}, false
+		}
+		c := color.NRGBA{A: 255}
+		ch := []*uint8{&c.R, &c.G, &c.B, &c.A}
+		for i, p := range parts[:min(len(parts), 4)] {
+			scale := 255.0
+			if i < 3 {
+				scale = 1
+			}
+			if pct, ok := strings.CutSuffix(p, "%"); ok {
+				p, scale = pct, 2.55
+			}
+			v, err := strconv.ParseFloat(p, 64)
+			if err != nil {
+				return color.NRGBA{}, false
+			}
+			*ch[i] = uint8(math.Round(math.Max(0, math.Min(255, v*scale))))
+		}
+		return c, true
+	}
+	if s == "transparent" {
+		return color.NRGBA{}, true
+	}
+	if c, ok := colornames.Map[s]; ok {
+		return color.NRGBA{c.R, c.G, c.B, c.A}, true
+	}
+	return color.NRGBA{}, false
+}
@@ -0,0 +1,297 @@
+package svg
+
+import (
+	"math"
+)
+
+type pt struct{ x, y float64 }
+
+// polyline is a flattened subpath in device space.
+type polyline struct {
+	pts    []pt
+	closed bool
+}
+
+// builder flattens path commands given in user space into device-space
+// polylines.
+type builder struct {
+	m     matrix
+	lines []polyline
+	cur   *polyline
+	start pt // user-space start of the current subpath
+	last  pt // user-space current point
+}
+
+func (b *builder) moveTo(p pt) {
+	b.finish()
+	b.cur = &polyline{pts: []pt{b.m.apply(p)}}
+	b.start, b.last = p, p
+}
+
+func (b *builder) lineTo(p pt) {
+	if b.cur == nil {
+		b.moveTo(b.last)
+	}
+	b.cur.pts = append(b.cur.pts, b.m.apply(p))
+	b.last = p
+}
+
+func (b *builder) cubicTo(c1, c2, p pt) {
+	if b.cur == nil {
+		b.moveTo(b.last)
+	}
+	p0 := b.m.apply(b.last)
+	d1, d2, d3 := b.m.apply(c1), b.m.apply(c2), b.m.apply(p)
+	n := segments(dist(p0, d1) + dist(d1, d2) + dist(d2, d3))
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		b.cur.pts = append(b.cur.pts, pt{
+			u*u*u*p0.x + 3*u*u*t*d1.x + 3*u*t*t*d2.x + t*t*t*d3.x,
+			u*u*u*p0.y + 3*u*u*t*d1.y + 3*u*t*t*d2.y + t*t*t*d3.y,
+		})
+	}
+	b.last = p
+}
+
+func (b *builder) quadTo(c, p pt) {
+	q0 := b.last
+	b.cubicTo(pt{q0.x + 2.0/3*(c.x-q0.x), q0.y + 2.0/3*(c.y-q0.y)},
+		pt{p.x + 2.0/3*(c.x-p.x), p.y + 2.0/3*(c.y-p.y)}, p)
+}
+
+// arcTo draws an SVG elliptical arc (SVG 1.1 appendix F.6) as cubics.
+func (b *builder) arcTo(rx, ry, rot float64, large, sweep bool, p pt) {
+	p0 := b.last
+	rx, ry = math.Abs(rx), math.Abs(ry)
+	if rx == 0 || ry == 0 || p0 == p {
+		b.lineTo(p)
+		return
+	}
+	sinPhi, cosPhi := math.Sincos(rot * math.Pi / 180)
+	dx, dy := (p0.x-p.x)/2, (p0.y-p.y)/2
+	x1 := cosPhi*dx + sinPhi*dy
+	y1 := -sinPhi*dx + cosPhi*dy
+	if l := x1*x1/(rx*rx) + y1*y1/(ry*ry); l > 1 {
+		rx, ry = rx*math.Sqrt(l), ry*math.Sqrt(l)
+	}
+	num := rx*rx*ry*ry - rx*rx*y1*y1 - ry*ry*x1*x1
+	den := rx*rx*y1*y1 + ry*ry*x1*x1
+	co := math.Sqrt(math.Max(0, num/den))
+	if large == sweep {
+		co = -co
+	}
+	cx1, cy1 := co*rx*y1/ry, -co*ry*x1/rx
+	cx := cosPhi*cx1 - sinPhi*cy1 + (p0.x+p.x)/2
+	cy := sinPhi*cx1 + cosPhi*cy1 + (p0.y+p.y)/2
+
+	angle := func(ux, uy, vx, vy float64) float64 {
+		return math.Atan2(ux*vy-uy*vx, ux*vx+uy*vy)
+	}
+	t1 := angle(1, 0, (x1-cx1)/rx, (y1-cy1)/ry)
+	dt := angle((x1-cx1)/rx, (y1-cy1)/ry, (-x1-cx1)/rx, (-y1-cy1)/ry)
+	if !sweep && dt > 0 {
+		dt -= 2 * math.Pi
+	} else if sweep && dt < 0 {
+		dt += 2 * math.Pi
+	}
+
+	n := int(math.Ceil(math.Abs(dt) / (math.Pi / 2)))
+	step := dt / float64(n)
+	k := 4.0 / 3 * math.Tan(step/4)
+	onEllipse := func(t float64) (pt, pt) {
+		sin, cos := math.Sincos(t)
+		pos := pt{cx + rx*cos*cosPhi - ry*sin*sinPhi, cy + rx*cos*sinPhi + ry*sin*cosPhi}
+		deriv := pt{-rx*sin*cosPhi - ry*cos*sinPhi, -rx*sin*sinPhi + ry*cos*cosPhi}
+		return pos, deriv
+	}
+	for i := 0; i < n; i++ {
+		a, b0 := t1+float64(i)*step, t1+float64(i+1)*step
+		pa, da := onEllipse(a)
+		pb, db := onEllipse(b0)
+		end := pb
+		if i == n-1 {
+			end = p
+		}
+		b.cubicTo(pt{pa.x + k*da.x, pa.y + k*da.y}, pt{pb.x - k*db.x, pb.y - k*db.y}, end)
+	}
+}
+
+func (b *builder) close() {
+	if b.cur != nil {
+		b.cur.closed = true
+		b.finish()
+	}
+	b.last = b.start
+}
+
+func (b *builder) finish() {
+	if b.cur != nil && len(b.cur.pts) > 0 {
+		b.lines = append(b.lines, *b.cur)
+	}
+	b.cur = nil
+}
+
+func (b *builder) result() []polyline {
+	b.finish()
+	return b.lines
+}
+
+// segments picks how many lines approximate a curve whose control polygon
+// is length device pixels long.
+func segments(length float64) int {
+	return max(1, min(int(math.Sqrt(length)*2), 128))
+}
+
+func dist(a, b pt) float64 { return math.Hypot(a.x-b.x, a.y-b.y) }
+
+// buildPath interprets SVG path data.  Parsing stops at the first error,
+// keeping what was drawn so far, as the SVG error-handling rules require.
+func buildPath(b *builder, d string) {
+	sc := &scanner{s: d}
+	var (
+		cmd       byte
+		ctrl      pt // last control point, for S and T
+		prevCubic bool
+		prevQuad  bool
+	)
+	num := func() (float64, bool) { return sc.number() }
+	for !sc.done() {
+		if c := sc.s[sc.i]; (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') {
+			cmd = c
+			sc.i++
+		} else if cmd == 0 || cmd|0x20 == 'z' {
+			return // stray numbers
+		}
+		rel := cmd >= 'a'
+		origin := pt{}
+		if rel {
+			origin = b.last
+		}
+		point := func() (pt, bool) {
+			x, ok1 := num()
+			y, ok2 := num()
+			return pt{origin.x + x, origin.y + y}, ok1 && ok2
+		}
+		isCubic, isQuad := false, false
+		switch cmd | 0x20 { // lower case
+		case 'm':
+			p, ok := point()
+			if !ok {
+				return
+			}
+			b.moveTo(p)
+			// Further coordinate pairs are implicit lineto commands.
+			if rel {
+				cmd = 'l'
+			} else {
+				cmd = 'L'
+			}
+		case 'l':
+			p, ok := point()
+			if !ok {
+				return
+			}
+			b.lineTo(p)
+		case 'h':
+			x, ok := num()
+			if !ok {
+				return
+			}
+			b.lineTo(pt{origin.x + x, b.last.y})
+		case 'v':
+			y, ok := num()
+			if !ok {
+				return
+			}
+			b.lineTo(pt{b.last.x, origin.y + y})
+		case 'c':
+			c1, ok1 := point()
+			c2, ok2 := point()
+			p, ok3 := point()
+			if !ok1 || !ok2 || !ok3 {
+				return
+			}
+			b.cubicTo(c1, c2, p)
+			ctrl, isCubic = c2, true
+		case 's':
+			c1 := b.last
+			if prevCubic {
+				c1 = pt{2*b.last.x - ctrl.x, 2*b.last.y - ctrl.y}
+			}
+			c2, ok1 := point()
+			p, ok2 := point()
+			if !ok1 || !ok2 {
+				return
+			}
+			b.cubicTo(c1, c2, p)
+			ctrl, isCubic = c2, true
+		case 'q':
+			c, ok1 := point()
+			p, ok2 := point()
+			if !ok1 || !ok2 {
+				return
+			}
+			b.quadTo(c, p)
+			ctrl, isQuad = c, true
+		case 't':
+			c := b.last
+			if prevQuad {
+				c = pt{2*b.last.x - ctrl.x, 2*b.last.y - ctrl.y}
+			}
+			p, ok := point()
+			if !ok {
+				return
+			}
+			b.quadTo(c, p)
+			ctrl, isQuad = c, true
+		case 'a':
+			rx, ok1 := num()
+			ry, ok2 := num()
+			rot, ok3 := num()
+			large, ok4 := sc.flag()
+			sweep, ok5 := sc.flag()
+			p, ok6 := point()
+			if !ok1 || !ok2 || !ok3 || !ok4 || !ok5 || !ok6 {
+				return
+			}
+			b.arcTo(rx, ry, rot, large, sweep, p)
+		case 'z':
+			b.close()
+		default:
+			return
+		}
+		prevCubic, prevQuad = isCubic, isQuad
+	}
+}
+
+// ellipse adds a closed ellipse centred on c.
+func (b *builder) ellipse(c pt, rx, ry float64) {
+	b.moveTo(pt{c.x + rx, c.y})
+	b.arcTo(rx, ry, 0, false, true, pt{c.x - rx, c.y})
+	b.arcTo(rx, ry, 0, false, true, pt{c.x + rx, c.y})
+	b.close()
+}
+
+// roundRect adds a rectangle with corner radii rx, ry.
+func (b *builder) roundRect(x, y, w, h, rx, ry float64) {
+	if rx <= 0 || ry <= 0 {
+		b.moveTo(pt{x, y})
+		b.lineTo(pt{x + w, y})
+		b.lineTo(pt{x + w, y + h})
+		b.lineTo(pt{x, y + h})
+		b.close()
+		return
+	}
+	rx, ry = math.Min(rx, w/2), math.Min(ry, h/2)
+	b.moveTo(pt{x + rx, y})
+	b.lineTo(pt{x + w - rx, y})
+	b.arcTo(rx, ry, 0, false, true, pt{x + w, y + ry})
+	b.lineTo(pt{x + w, y + h - ry})
+	b.arcTo(rx, ry, 0, false, true, pt{x + w - rx, y + h})
+	b.lineTo(pt{x + rx, y + h})
+	b.arcTo(rx, ry, 0, false, true, pt{x, y + h - ry})
+	b.lineTo(pt{x, y + ry})
+	b.arcTo(rx, ry, 0, false, true, pt{x + rx, y})
+	b.close()
+}
@@ -0,0 +1,505 @@
+package svg
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strconv"
+	"strings"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+	"golang.org/x/image/vector"
+)
+
+// MaxSide bounds each side of a rasterized image.
+const MaxSide = 16384
+
+// Options controls Rasterize.
+type Options struct {
+	// Width is the output width in pixels; the height follows the
+	// document's aspect ratio.  Zero renders at DPI.
+	Width int
+	// DPI scales the document's own size, where 96 is one CSS pixel per
+	// pixel.  Zero means 96.
+	DPI float64
+}
+
+// Size returns the document's intrinsic size in CSS pixels: its width and
+// height attributes, else its viewBox, else 300×150.
+func Size(data []byte) (w, h float64, err error) {
+	root, err := parseDocument(data)
+	if err != nil {
+		return 0, 0, apperrors.New(apperrors.CategoryDecode, "svg.size", err)
+	}
+	w, h, _ = intrinsicSize(root)
+	return w, h, nil
+}
+
+// Rasterize renders data.  Run untrusted documents through Sanitize first;
+// Rasterize itself never fetches anything.
+func Rasterize(data []byte, opts Options) (*image.RGBA, error) {
+	root, err := parseDocument(data)
+	if err != nil {
+		return nil, apperrors.New(apperrors.CategoryDecode, "svg.rasterize", err)
+	}
+	iw, ih, vb := intrinsicSize(root)
+	scale := 1.0
+	switch {
+	case opts.Width > 0:
+		scale = float64(opts.Width) / iw
+	case opts.DPI > 0:
+		scale = opts.DPI / 96
+	}
+	w, h := int(math.Round(iw*scale)), int(math.Round(ih*scale))
+	if opts.Width > 0 {
+		w = opts.Width
+	}
+	if w < 1 || h < 1 || w > MaxSide || h > MaxSide {
+		return nil, apperrors.New(apperrors.CategoryInput, "svg.rasterize",
+			fmt.Errorf("%w: %dx%d", apperrors.ErrInvalidDimensions, w, h))
+	}
+
+	m := matrix{a: scale, d: scale}
+	if vb != nil {
+		m = m.mul(viewBoxTransform(vb, iw, ih, root.attrs["preserveAspectRatio"]))
+	}
+	r := &renderer{
+		dst:  image.NewRGBA(image.Rect(0, 0, w, h)),
+		ids:  map[string]*node{},
+		rast: vector.NewRasterizer(w, h),
+	}
+	index(root, r.ids)
+	r.children(root, m, defaultStyle().apply(properties(root)), 0)
+	return r.dst, nil
+}
+
+// intrinsicSize resolves the root size and viewBox.
+func intrinsicSize(root *node) (w, h float64, vb []float64) {
+	if v := numbers(root.attrs["viewBox"]); len(v) == 4 && v[2] > 0 && v[3] > 0 {
+		vb = v
+	}
+	w, wok := parseLength(root.attrs["width"], 0)
+	h, hok := parseLength(root.attrs["height"], 0)
+	if strings.HasSuffix(root.attrs["width"], "%") {
+		wok = false
+	}
+	if strings.HasSuffix(root.attrs["height"], "%") {
+		hok = false
+	}
+	switch {
+	case wok && hok:
+	case vb != nil && wok:
+		h = w * vb[3] / vb[2]
+	case vb != nil && hok:
+		w = h * vb[2] / vb[3]
+	case vb != nil:
+		w, h = vb[2], vb[3]
+	default:
+		if !wok {
+			w = 300
+		}
+		if !hok {
+			h = 150
+		}
+	}
+	return max(w, 1), max(h, 1), vb
+}
+
+// viewBoxTransform maps vb onto a w×h viewport per preserveAspectRatio.
+func viewBoxTransform(vb []float64, w, h float64, par string) matrix {
+	sx, sy := w/vb[2], h/vb[3]
+	fields := strings.Fields(par)
+	align := "xMidYMid"
+	if len(fields) > 0 {
+		align = fields[0]
+	}
+	if align == "none" {
+		return matrix{a: sx, d: sy, e: -vb[0] * sx, f: -vb[1] * sy}
+	}
+	s := math.Min(sx, sy)
+	if len(fields) > 1 && fields[1] == "slice" {
+		s = math.Max(sx, sy)
+	}
+	tx, ty := -vb[0]*s, -vb[1]*s
+	switch {
+	case strings.HasPrefix(align, "xMid"):
+		tx += (w - vb[2]*s) / 2
+	case strings.HasPrefix(align, "xMax"):
+		tx += w - vb[2]*s
+	}
+	switch {
+	case strings.HasSuffix(align, "YMid"):
+		ty += (h - vb[3]*s) / 2
+	case strings.HasSuffix(align, "YMax"):
+		ty += h - vb[3]*s
+	}
+	return matrix{a: s, d: s, e: tx, f: ty}
+}
+
+// paint is a fill or stroke value.
+type paint struct {
+	none    bool
+	current bool   // currentColor
+	ref     string // paint server id from url(#id)
+	c       color.NRGBA
+}
+
+type style struct {
+	fill, stroke               paint
+	fillOpacity, strokeOpacity float64
+	opacity                    float64
+	strokeWidth                float64
+	lineCap                    string
+	color                      color.NRGBA
+}
+
+func defaultStyle() style {
+	return style{
+		fill:          paint{c: color.NRGBA{A: 255}},
+		stroke:        paint{none: true},
+		fillOpacity:   1,
+		strokeOpacity: 1,
+		opacity:       1,
+		strokeWidth:   1,
+		lineCap:       "butt",
+		color:         color.NRGBA{A: 255},
+	}
+}
+
+// properties returns n's presentation attributes overridden by its style
+// attribute.
+func properties(n *node) map[string]string {
+	props := make(map[string]string, len(n.attrs))
+	for k, v := range n.attrs {
+		props[k] = v
+	}
+	for _, decl := range strings.Split(n.attrs["style"], ";") {
+		if k, v, ok := strings.Cut(decl, ":"); ok {
+			props[strings.TrimSpace(k)] = strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(v), "!important"))
+		}
+	}
+	return props
+}
+
+func (s style) apply(props map[string]string) style {
+	num := func(k string, into *float64) {
+		if v, ok := props[k]; ok {
+			if pct, isPct := strings.CutSuffix(strings.TrimSpace(v), "%"); isPct {
+				if f, err := strconv.ParseFloat(pct, 64); err == nil {
+					*into = math.Max(0, math.Min(1, f/100))
+				}
+			} else if f, err := strconv.ParseFloat(strings.TrimSpace(v), 64); err == nil {
+				*into = math.Max(0, math.Min(1, f))
+			}
+		}
+	}
+	if v, ok := props["color"]; ok {
+		if c, ok := parseColor(v); ok {
+			s.color = c
+		}
+	}
+	if v, ok := props["fill"]; ok {
+		s.fill = parsePaint(v, s.fill)
+	}
+	if v, ok := props["stroke"]; ok {
+		s.stroke = parsePaint(v, s.stroke)
+	}
+	num("fill-opacity", &s.fillOpacity)
+	num("stroke-opacity", &s.strokeOpacity)
+	op := 1.0
+	num("opacity", &op)
+	s.opacity *= op
+	if v, ok := props["stroke-width"]; ok {
+		if w, ok := parseLength(v, 100); ok && w >= 0 {
+			s.strokeWidth = w
+		}
+	}
+	if v, ok := props["stroke-linecap"]; ok {
+		s.lineCap = strings.TrimSpace(v)
+	}
+	return s
+}
+
+func parsePaint(v string, inherited paint) paint {
+	v = strings.TrimSpace(v)
+	switch v {
+	case "none":
+		return paint{none: true}
+	case "currentColor":
+		return paint{current: true}
+	case "inherit", "":
+		return inherited
+	}
+	if rest, ok := strings.CutPrefix(v, "url("); ok {
+		id, fallback, _ := strings.Cut(rest, ")")
+		p := paint{ref: strings.TrimPrefix(strings.Trim(strings.TrimSpace(id), `'"`), "#")}
+		if fb := strings.TrimSpace(fallback); fb != "" {
+			if c, ok := parseColor(fb); ok {
+				p.c = c
+			} else if fb == "none" {
+				p.none = true
+			}
+		}
+		return p
+	}
+	if c, ok := parseColor(v); ok {
+		return paint{c: c}
+	}
+	return inherited
+}
+
+// maxDepth bounds element nesting, including <use> indirection, so
+// self-referencing documents cannot recurse forever; maxRendered bounds the
+// elements drawn, so fanned-out <use> chains cannot multiply without end.
+const (
+	maxDepth    = 32
+	maxRendered = 4 * maxNodes
+)
+
+type renderer struct {
+	dst      *image.RGBA
+	ids      map[string]*node
+	rast     *vector.Rasterizer
+	rendered int
+}
+
+func (r *renderer) children(n *node, m matrix, st style, depth int) {
+	for _, c := range n.children {
+		r.render(c, m, st, depth+1)
+	}
+}
+
+func (r *renderer) render(n *node, m matrix, parent style, depth int) {
+	if depth > maxDepth || r.rendered >= maxRendered {
+		return
+	}
+	r.rendered++
+	props := properties(n)
+	if props["display"] == "none" {
+		return
+	}
+	st := parent.apply(props)
+	if t, ok := n.attrs["transform"]; ok {
+		m = m.mul(parseTransform(t))
+	}
+	length := func(k string) float64 {
+		v, _ := parseLength(n.attrs[k], 100)
+		return v
+	}
+
+	b := &builder{m: m}
+	switch n.name {
+	case "g", "a", "switch":
+		r.children(n, m, st, depth)
+		return
+	case "svg":
+		r.children(n, m.mul(translate(length("x"), length("y"))), st, depth)
+		return
+	case "use":
+		ref := strings.TrimPrefix(n.attrs["href"], "#")
+		target, ok := r.ids[ref]
+		if !ok || ref == "" {
+			return
+		}
+		m = m.mul(translate(length("x"), length("y")))
+		if target.name == "symbol" {
+			r.children(target, m, st.apply(properties(target)), depth+1)
+		} else {
+			r.render(target, m, st, depth+1)
+		}
+		return
+	case "rect":
+		w, h := length("width"), length("height")
+		if w <= 0 || h <= 0 {
+			return
+		}
+		rx, rxok := parseLength(n.attrs["rx"], 100)
+		ry, ryok := parseLength(n.attrs["ry"], 100)
+		if !rxok {
+			rx = ry
+		}
+		if !ryok {
+			ry = rx
+		}
+		b.roundRect(length("x"), length("y"), w, h, rx, ry)
+	case "circle":
+		if rad := length("r"); rad > 0 {
+			b.ellipse(pt{length("cx"), length("cy")}, rad, rad)
+		}
+	case "ellipse":
+		if rx, ry := length("rx"), length("ry"); rx > 0 && ry > 0 {
+			b.ellipse(pt{length("cx"), length("cy")}, rx, ry)
+		}
+	case "line":
+		b.moveTo(pt{length("x1"), length("y1")})
+		b.lineTo(pt{length("x2"), length("y2")})
+	case "polyline", "polygon":
+		v := numbers(n.attrs["points"])
+		for i := 0; i+1 < len(v); i += 2 {
+			if i == 0 {
+				b.moveTo(pt{v[0], v[1]})
+			} else {
+				b.lineTo(pt{v[i], v[i+1]})
+			}
+		}
+		if n.name == "polygon" && len(v) >= 2 {
+			b.close()
+		}
+	case "path":
+		buildPath(b, n.attrs["d"])
+	default:
+		// defs, symbol, gradients, text and the rest are not drawn.
+		return
+	}
+
+	lines := b.result()
+	if len(lines) == 0 {
+		return
+	}
+	if c, ok := r.resolve(st.fill, st.color); ok && n.name != "line" {
+		r.fill(lines, c, st.fillOpacity*st.opacity)
+	}
+	if c, ok := r.resolve(st.stroke, st.color); ok && st.strokeWidth > 0 {
+		r.stroke(lines, st.strokeWidth*m.scale(), st.lineCap, c, st.strokeOpacity*st.opacity)
+	}
+}
+
+// resolve turns p into a colour; paint servers give the average of their
+// stops.
+func (r *renderer) resolve(p paint, current color.NRGBA) (color.NRGBA, bool) {
+	switch {
+	case p.none:
+		return color.NRGBA{}, false
+	case p.current:
+		return current, true
+	case p.ref != "":
+		if c, ok := r.gradientColor(p.ref, 0); ok {
+			return c, true
+		}
+	}
+	return p.c, true
+}
+
+func (r *renderer) gradientColor(id string, depth int) (color.NRGBA, bool) {
+	g, ok := r.ids[id]
+	if !ok || depth > 4 || (g.name != "linearGradient" && g.name != "radialGradient") {
+		return color.NRGBA{}, false
+	}
+	var sum [4]float64
+	n := 0
+	for _, stop := range g.children {
+		if stop.name != "stop" {
+			continue
+		}
+		props := properties(stop)
+		c := color.NRGBA{A: 255}
+		if v, ok := parseColor(props["stop-color"]); ok {
+			c = v
+		}
+		a := float64(c.A) / 255
+		if v, err := strconv.ParseFloat(strings.TrimSpace(props["stop-opacity"]), 64); err == nil {
+			a *= math.Max(0, math.Min(1, v))
+		}
+		sum[0] += float64(c.R)
+		sum[1] += float64(c.G)
+		sum[2] += float64(c.B)
+		sum[3] += a * 255
+		n++
+	}
+	if n == 0 {
+		// Stops may come from the gradient this one references.
+		return r.gradientColor(strings.TrimPrefix(g.attrs["href"], "#"), depth+1)
+	}
+	f := float64(n)
+	return color.NRGBA{uint8(sum[0]/f + 0.5), uint8(sum[1]/f + 0.5), uint8(sum[2]/f + 0.5), uint8(sum[3]/f + 0.5)}, true
+}
+
+func (r *renderer) fill(lines []polyline, c color.NRGBA, opacity float64) {
+	r.rast.Reset(r.dst.Rect.Dx(), r.dst.Rect.Dy())
+	for _, l := range lines {
+		if len(l.pts) < 3 {
+			continue
+		}
+		r.rast.MoveTo(float32(l.pts[0].x), float32(l.pts[0].y))
+		for _, p := range l.pts[1:] {
+			r.rast.LineTo(float32(p.x), float32(p.y))
+		}
+		r.rast.ClosePath()
+	}
+	r.draw(c, opacity)
+}
+
+// stroke outlines each polyline with quads per segment and round joins.
+// Every polygon is added with the same winding so overlaps do not cancel.
+func (r *renderer) stroke(lines []polyline, width float64, lineCap string, c color.NRGBA, opacity float64) {
+	r.rast.Reset(r.dst.Rect.Dx(), r.dst.Rect.Dy())
+	hw := width / 2
+	for _, l := range lines {
+		pts := l.pts
+		if l.closed && len(pts) > 1 && pts[0] != pts[len(pts)-1] {
+			pts = append(pts[:len(pts):len(pts)], pts[0])
+		}
+		for i := 0; i+1 < len(pts); i++ {
+			p, q := pts[i], pts[i+1]
+			d := dist(p, q)
+			if d == 0 {
+				continue
+			}
+			ux, uy := (q.x-p.x)/d, (q.y-p.y)/d
+			if lineCap == "square" && !l.closed {
+				if i == 0 {
+					p = pt{p.x - ux*hw, p.y - uy*hw}
+				}
+				if i == len(pts)-2 {
+					q = pt{q.x + ux*hw, q.y + uy*hw}
+				}
+			}
+			nx, ny := -uy*hw, ux*hw
+			r.polygon([]pt{{p.x + nx, p.y + ny}, {q.x + nx, q.y + ny}, {q.x - nx, q.y - ny}, {p.x - nx, p.y - ny}})
+		}
+		for i, p := range pts {
+			end := i == 0 || i == len(pts)-1
+			if !end || l.closed || lineCap == "round" {
+				r.polygon(circle(p, hw))
+			}
+		}
+	}
+	r.draw(c, opacity)
+}
+
+func (r *renderer) polygon(pts []pt) {
+	area := 0.0
+	for i, p := range pts {
+		q := pts[(i+1)%len(pts)]
+		area += p.x*q.y - q.x*p.y
+	}
+	if area < 0 {
+		for i, j := 0, len(pts)-1; i < j; i, j = i+1, j-1 {
+			pts[i], pts[j] = pts[j], pts[i]
+		}
+	}
+	r.rast.MoveTo(float32(pts[0].x), float32(pts[0].y))
+	for _, p := range pts[1:] {
+		r.rast.LineTo(float32(p.x), float32(p.y))
+	}
+	r.rast.ClosePath()
+}
+
+func circle(c pt, rad float64) []pt {
+	n := max(8, min(int(rad*2), 64))
+	out := make([]pt, n)
+	for i := range out {
+		sin, cos := math.Sincos(2 * math.Pi * float64(i) / float64(n))
+		out[i] = pt{c.x + rad*cos, c.y + rad*sin}
+	}
+	return out
+}
+
+func (r *renderer) draw(c color.NRGBA, opacity float64) {
+	c.A = uint8(float64(c.A)*opacity + 0.5)
+	if c.A == 0 {
+		return
+	}
+	r.rast.Draw(r.dst, r.dst.Rect, image.NewUniform(c), image.Point{})
+}
@@ -0,0 +1,163 @@
+// Package svg rasterizes SVG documents in pure Go and strips the parts of
+// them that reach outside the document.  The renderer covers the static
+// subset used by logos, icons and charts: basic shapes, paths, groups,
+// transforms, <use>, solid fills and strokes.  Gradients fill with their
+// average stop colour; text, filters, masks and embedded images are not
+// drawn.  Render through libvips (adapters/vips) for full SVG support.
+package svg
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+
+	apperrors "github.com/Skryldev/image-processor/errors"
+)
+
+// AllowFunc reports whether an external reference (an href or url() target
+// that is not a #fragment) may stay in the document.
+type AllowFunc func(ref string) bool
+
+// AllowHosts returns an AllowFunc accepting http(s) references to the given
+// hosts only.
+func AllowHosts(hosts ...string) AllowFunc {
+	set := make(map[string]bool, len(hosts))
+	for _, h := range hosts {
+		set[strings.ToLower(h)] = true
+	}
+	return func(ref string) bool {
+		rest, ok := strings.CutPrefix(ref, "https://")
+		if !ok {
+			if rest, ok = strings.CutPrefix(ref, "http://"); !ok {
+				return false
+			}
+		}
+		host, _, _ := strings.Cut(rest, "/")
+		return set[strings.ToLower(host)]
+	}
+}
+
+// dropped are elements removed whatever their attributes.
+var dropped = map[string]bool{"script": true, "foreignObject": true, "iframe": true}
+
+var cssURL = regexp.MustCompile(`(?i)url\(\s*['"]?([^'")\s]*)|@import\s+['"]?([^'";\s]*)`)
+
+// Sanitize returns data without scripts, event handlers, foreign objects
+// and elements holding external references that allow rejects (nil allows
+// none).  Documents declaring entities are rejected outright, as they are
+// the vehicle for XXE and entity-expansion attacks.
+func Sanitize(data []byte, allow AllowFunc) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	d.Strict = false
+	type span struct{ from, to int64 }
+	var (
+		drops []span
+		depth int   // depth inside a dropped element; 0 when keeping
+		from  int64 // offset where the dropped element starts
+		style bool  // inside a kept <style>
+	)
+	for {
+		off := d.InputOffset()
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, apperrors.New(apperrors.CategoryInput, "svg.sanitize", err)
+		}
+		switch t := tok.(type) {
+		case xml.Directive:
+			if bytes.Contains(bytes.ToUpper(t), []byte("ENTITY")) {
+				return nil, apperrors.New(apperrors.CategoryInput, "svg.sanitize",
+					fmt.Errorf("%w: SVG declares entities", apperrors.ErrUnsafeContent))
+			}
+		case xml.StartElement:
+			if depth > 0 {
+				depth++
+				continue
+			}
+			if unsafeElement(t, allow) {
+				depth, from = 1, off
+			}
+			style = t.Name.Local == "style"
+		case xml.EndElement:
+			if depth > 0 {
+				if depth--; depth == 0 {
+					drops = append(drops, span{from, d.InputOffset()})
+				}
+			}
+			style = false
+		case xml.CharData:
+			// External url() and @import in a stylesheet cannot be cut
+			// out reliably, so the document is rejected.
+			if style && depth == 0 && externalCSS(string(t), allow) {
+				return nil, apperrors.New(apperrors.CategoryInput, "svg.sanitize",
+					fmt.Errorf("%w: stylesheet references external resources", apperrors.ErrUnsafeContent))
+			}
+		}
+	}
+	if depth > 0 {
+		return nil, apperrors.New(apperrors.CategoryInput, "svg.sanitize", errors.New("unterminated element"))
+	}
+	if len(drops) == 0 {
+		return data, nil
+	}
+
+	sort.Slice(drops, func(i, j int) bool { return drops[i].from < drops[j].from })
+	out := make([]byte, 0, len(data))
+	pos := int64(0)
+	for _, s := range drops {
+		out = append(out, data[pos:s.from]...)
+		pos = s.to
+	}
+	return append(out, data[pos:]...), nil
+}
+
+// unsafeElement reports whether el must be removed.
+func unsafeElement(el xml.StartElement, allow AllowFunc) bool {
+	if dropped[el.Name.Local] {
+		return true
+	}
+	for _, a := range el.Attr {
+		name := strings.ToLower(a.Name.Local)
+		switch {
+		case strings.HasPrefix(name, "on"):
+			return true
+		case name == "href":
+			if external(a.Value, allow) {
+				return true
+			}
+		case externalCSS(a.Value, allow):
+			return true
+		}
+	}
+	return false
+}
+
+// externalCSS reports whether s holds a url() or @import target that is
+// external and not allowed.
+func externalCSS(s string, allow AllowFunc) bool {
+	for _, m := range cssURL.FindAllStringSubmatch(s, -1) {
+		ref := m[1]
+		if m[2] != "" {
+			ref = m[2]
+		}
+		if external(ref, allow) {
+			return true
+		}
+	}
+	return false
+}
+
+func external(ref string, allow AllowFunc) bool {
+	ref = strings.TrimSpace(ref)
+	if ref == "" || strings.HasPrefix(ref, "#") {
+		return false
+	}
+	return allow == nil || !allow(ref)
+}
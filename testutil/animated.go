@@ -0,0 +1,133 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"testing"
+)
+
+// Animation fixtures share one layout: frame 0 fills the canvas with
+// AnimationBackground, and each later frame i paints only an h×h square of
+// FrameColor(i) at FrameSquare(i, h), leaving the rest to compositing.
+
+// AnimationBackground is the colour of frame 0 in the animation fixtures.
+var AnimationBackground = color.NRGBA{128, 128, 128, 255}
+
+// FrameColor returns the colour painted by frame i of the animation fixtures.
+func FrameColor(i int) color.NRGBA {
+	return color.NRGBA{uint8(40 * i), uint8(255 - 40*i), uint8(100 + 20*i), 255}
+}
+
+// FrameSquare returns the region painted by frame i > 0 of an h-pixel-high
+// animation fixture.
+func FrameSquare(i, h int) image.Rectangle {
+	return image.Rect((i-1)*h, 0, i*h, h)
+}
+
+// AnimatedGIF returns an n-frame GIF, (n-1)·h pixels wide and h high, in the
+// animation fixture layout, with 100ms per frame.
+func AnimatedGIF(t testing.TB, h, n int) []byte {
+	t.Helper()
+	pal := color.Palette{AnimationBackground}
+	for i := 1; i < n; i++ {
+		pal = append(pal, FrameColor(i))
+	}
+	canvas := image.Rect(0, 0, (n-1)*h, h)
+	g := &gif.GIF{Config: image.Config{ColorModel: pal, Width: canvas.Dx(), Height: canvas.Dy()}}
+	for i := 0; i < n; i++ {
+		r := canvas
+		if i > 0 {
+			r = FrameSquare(i, h)
+		}
+		frame := image.NewPaletted(r, pal)
+		draw.Draw(frame, r, image.NewUniform(pal[i]), image.Point{}, draw.Src)
+		g.Image = append(g.Image, frame)
+		g.Delay = append(g.Delay, 10)
+		g.Disposal = append(g.Disposal, gif.DisposalNone)
+	}
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, g); err != nil {
+		t.Fatalf("testutil: encode GIF: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// AnimatedPNG returns an n-frame APNG in the same layout as AnimatedGIF.
+// The default image is frame 0.
+func AnimatedPNG(t testing.TB, h, n int) []byte {
+	t.Helper()
+	canvas := image.Rect(0, 0, (n-1)*h, h)
+	var out bytes.Buffer
+	out.WriteString("\x89PNG\r\n\x1a\n")
+	seq := uint32(0)
+	for i := 0; i < n; i++ {
+		r, c := canvas, AnimationBackground
+		if i > 0 {
+			r, c = FrameSquare(i, h), FrameColor(i)
+		}
+		frame := image.NewNRGBA(image.Rect(0, 0, r.Dx(), r.Dy()))
+		draw.Draw(frame, frame.Bounds(), image.NewUniform(c), image.Point{}, draw.Src)
+		ihdr, idat := pngParts(t, EncodePNG(t, frame))
+		if i == 0 {
+			writeChunk(&out, "IHDR", ihdr)
+			actl := make([]byte, 8)
+			binary.BigEndian.PutUint32(actl, uint32(n))
+			writeChunk(&out, "acTL", actl)
+		}
+
+		fctl := make([]byte, 26)
+		binary.BigEndian.PutUint32(fctl[0:], seq)
+		binary.BigEndian.PutUint32(fctl[4:], uint32(r.Dx()))
+		binary.BigEndian.PutUint32(fctl[8:], uint32(r.Dy()))
+		binary.BigEndian.PutUint32(fctl[12:], uint32(r.Min.X))
+		binary.BigEndian.PutUint32(fctl[16:], uint32(r.Min.Y))
+		binary.BigEndian.PutUint16(fctl[20:], 1)
+		binary.BigEndian.PutUint16(fctl[22:], 10)
+		writeChunk(&out, "fcTL", fctl)
+		seq++
+
+		if i == 0 {
+			writeChunk(&out, "IDAT", idat)
+			continue
+		}
+		fdat := make([]byte, 4, 4+len(idat))
+		binary.BigEndian.PutUint32(fdat, seq)
+		writeChunk(&out, "fdAT", append(fdat, idat...))
+		seq++
+	}
+	writeChunk(&out, "IEND", nil)
+	return out.Bytes()
+}
+
+// pngParts returns the IHDR payload and the concatenated IDAT payloads of a
+// PNG stream.
+func pngParts(t testing.TB, data []byte) (ihdr, idat []byte) {
+	t.Helper()
+	for pos := 8; pos+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[pos:]))
+		typ, body := string(data[pos+4:pos+8]), data[pos+8:pos+8+n]
+		switch typ {
+		case "IHDR":
+			ihdr = body
+		case "IDAT":
+			idat = append(idat, body...)
+		}
+		pos += 12 + n
+	}
+	if ihdr == nil || idat == nil {
+		t.Fatal("testutil: PNG without IHDR or IDAT")
+	}
+	return ihdr, idat
+}
+
+func writeChunk(buf *bytes.Buffer, typ string, data []byte) {
+	binary.Write(buf, binary.BigEndian, uint32(len(data)))
+	buf.WriteString(typ)
+	buf.Write(data)
+	binary.Write(buf, binary.BigEndian, crc32.ChecksumIEEE(append([]byte(typ), data...)))
+}
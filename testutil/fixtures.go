@@ -0,0 +1,267 @@
+// Package testutil helps test pipelines and custom steps: it generates
+// deterministic fixture images, runs pipelines, and compares results with
+// golden files under a perceptual tolerance.
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	"image/png"
+	"testing"
+
+	"github.com/Skryldev/image-processor/adapters/encoder"
+	"github.com/Skryldev/image-processor/core"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/basicfont"
+	"golang.org/x/image/math/fixed"
+)
+
+// Gradient returns an opaque w×h image with red rising left to right, green
+// rising top to bottom and blue along the diagonal.
+func Gradient(w, h int) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetNRGBA(x, y, color.NRGBA{
+				R: ramp(x, w),
+				G: ramp(y, h),
+				B: ramp(x+y, w+h-1),
+				A: 255,
+			})
+		}
+	}
+	return img
+}
+
+// AlphaGradient returns Gradient(w, h) with alpha rising left to right from
+// fully transparent to opaque.
+func AlphaGradient(w, h int) *image.NRGBA {
+	img := Gradient(w, h)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.Pix[img.PixOffset(x, y)+3] = ramp(x, w)
+		}
+	}
+	return img
+}
+
+// TextChart returns a white w×h chart with saturated colour bars along the
+// top and rows of black text below: sharp edges that expose resampling,
+// subsampling and compression artefacts.
+func TextChart(w, h int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, w, h))
+	draw.Draw(img, img.Bounds(), image.White, image.Point{}, draw.Src)
+
+	bars := []color.RGBA{
+		{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255},
+		{0, 255, 255, 255}, {255, 0, 255, 255}, {255, 255, 0, 255},
+	}
+	barH := min(h/4, 16)
+	for i, c := range bars {
+		r := image.Rect(i*w/len(bars), 0, (i+1)*w/len(bars), barH)
+		draw.Draw(img, r, image.NewUniform(c), image.Point{}, draw.Src)
+	}
+
+	d := &font.Drawer{Dst: img, Src: image.Black, Face: basicfont.Face7x13}
+	lines := []string{"The quick brown fox", "jumps over the lazy dog", "0123456789 !?#%&"}
+	for i, y := 0, barH+13; y < h; i, y = i+1, y+15 {
+		d.Dot = fixed.P(2, y)
+		d.DrawString(lines[i%len(lines)])
+	}
+	return img
+}
+
+// EncodeJPEG encodes img as a baseline JPEG at quality q.
+func EncodeJPEG(t testing.TB, img image.Image, q int) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: q}); err != nil {
+		t.Fatalf("testutil: encode jpeg: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// EncodePNG encodes img as a PNG.
+func EncodePNG(t testing.TB, img image.Image) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		t.Fatalf("testutil: encode png: %v", err)
+	}
+	return buf.Bytes()
+}
+
+// AlphaPNG returns AlphaGradient(w, h) encoded as an RGBA PNG.
+func AlphaPNG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	return EncodePNG(t, AlphaGradient(w, h))
+}
+
+// EXIFJPEG returns a Gradient(w, h) JPEG carrying an EXIF APP1 segment with
+// the given Orientation (1-8) plus fixed Software and DateTime tags.
+func EXIFJPEG(t testing.TB, w, h, orientation int) []byte {
+	t.Helper()
+	jpg := EncodeJPEG(t, Gradient(w, h), 90)
+	app1 := exifSegment(orientation, "image-processor testutil", "2006:01:02 15:04:05")
+	out := make([]byte, 0, len(jpg)+len(app1))
+	out = append(out, jpg[:2]...) // SOI
+	out = append(out, app1...)
+	return append(out, jpg[2:]...)
+}
+
+// CameraJPEG returns a Gradient(w, h) JPEG carrying the metadata a phone
+// camera writes: EXIF Orientation 6, Make, Artist and Copyright, a GPS
+// position of 48°51'30"N 2°17'40"E and an IFD1 thumbnail, plus an ICC
+// profile segment and an XMP packet recording the same location.
+func CameraJPEG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	jpg := EncodeJPEG(t, Gradient(w, h), 90)
+	exif := exifSegmentOf([]exifEntry{
+		exifASCII(0x010F, "testutil"),
+		exifShort(0x0112, 6),
+		exifASCII(0x013B, "A. Photographer"),
+		exifASCII(0x8298, "(c) 2006 A. Photographer"),
+	}, []exifEntry{
+		exifASCII(1, "N"),
+		exifRationals(2, 48, 51, 30),
+		exifASCII(3, "E"),
+		exifRationals(4, 2, 17, 40),
+	}, EncodeJPEG(t, Gradient(8, 8), 50))
+
+	app := func(marker byte, payload string) []byte {
+		seg := binary.BigEndian.AppendUint16([]byte{0xFF, marker}, uint16(len(payload)+2))
+		return append(seg, payload...)
+	}
+	var out []byte
+	out = append(out, jpg[:2]...) // SOI
+	out = append(out, exif...)
+	out = append(out, app(0xE2, "ICC_PROFILE\x00\x01\x01not a real profile")...)
+	out = append(out, app(0xE1, "http://ns.adobe.com/xap/1.0/\x00"+
+		`<x:xmpmeta xmlns:x="adobe:ns:meta/"><rdf:Description exif:GPSLatitude="48,51.5N" exif:GPSLongitude="2,17.667E"/></x:xmpmeta>`)...)
+	return append(out, jpg[2:]...)
+}
+
+// CMYKJPEG returns a w×h four-channel Adobe CMYK JPEG whose ink values
+// follow Gradient(w, h).
+func CMYKJPEG(t testing.TB, w, h int) []byte {
+	t.Helper()
+	src := Gradient(w, h)
+	cmyk := image.NewCMYK(src.Bounds())
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			cmyk.Set(x, y, src.At(x, y))
+		}
+	}
+	var opts core.EncodeOptions
+	opts.Quality = 95
+	opts.JPEG().KeepCMYK = true
+	data, err := encoder.NewJPEG(95).Encode(t.Context(), &core.ImageData{Image: cmyk}, opts)
+	if err != nil {
+		t.Fatalf("testutil: encode cmyk jpeg: %v", err)
+	}
+	return data
+}
+
+// exifEntry is one big-endian TIFF directory entry.
+type exifEntry struct {
+	tag, typ uint16
+	count    uint32
+	value    []byte
+}
+
+func exifShort(tag uint16, v int) exifEntry {
+	return exifEntry{tag, 3, 1, binary.BigEndian.AppendUint16(nil, uint16(v))}
+}
+
+func exifASCII(tag uint16, s string) exifEntry {
+	return exifEntry{tag, 2, uint32(len(s) + 1), append([]byte(s), 0)}
+}
+
+// exifRationals holds whole-number RATIONAL values.
+func exifRationals(tag uint16, vs ...uint32) exifEntry {
+	var b []byte
+	for _, v := range vs {
+		b = binary.BigEndian.AppendUint32(b, v)
+		b = binary.BigEndian.AppendUint32(b, 1)
+	}
+	return exifEntry{tag, 5, uint32(len(vs)), b}
+}
+
+// exifSegment builds a big-endian APP1 EXIF segment holding IFD0 with the
+// Orientation, Software and DateTime tags.
+func exifSegment(orientation int, software, datetime string) []byte {
+	return exifSegmentOf([]exifEntry{
+		exifShort(0x0112, orientation),
+		exifASCII(0x0131, software),
+		exifASCII(0x0132, datetime),
+	}, nil, nil)
+}
+
+// exifSegmentOf builds a big-endian APP1 EXIF segment from IFD0's entries,
+// sorted by tag, adding a GPS IFD when gps is non-empty and an IFD1 with
+// a JPEG thumbnail when thumb is non-nil.
+func exifSegmentOf(ifd0, gps []exifEntry, thumb []byte) []byte {
+	tiff := []byte{'M', 'M', 0, 42, 0, 0, 0, 8}
+	// write appends a directory and its values, returning its offset and
+	// that of its next-IFD pointer.
+	write := func(entries []exifEntry) (at, next int) {
+		at = len(tiff)
+		dataOffset := at + 2 + 12*len(entries) + 4
+		var data []byte
+		tiff = binary.BigEndian.AppendUint16(tiff, uint16(len(entries)))
+		for _, e := range entries {
+			tiff = binary.BigEndian.AppendUint16(tiff, e.tag)
+			tiff = binary.BigEndian.AppendUint16(tiff, e.typ)
+			tiff = binary.BigEndian.AppendUint32(tiff, e.count)
+			if len(e.value) <= 4 {
+				var inline [4]byte
+				copy(inline[:], e.value)
+				tiff = append(tiff, inline[:]...)
+				continue
+			}
+			tiff = binary.BigEndian.AppendUint32(tiff, uint32(dataOffset+len(data)))
+			data = append(data, e.value...)
+		}
+		next = len(tiff)
+		tiff = binary.BigEndian.AppendUint32(tiff, 0)
+		tiff = append(tiff, data...)
+		return at, next
+	}
+
+	var gpsPointer int
+	if len(gps) > 0 {
+		gpsPointer = 2 + 12*len(ifd0) + 8 // value of the entry appended last
+		ifd0 = append(ifd0, exifEntry{0x8825, 4, 1, make([]byte, 4)})
+	}
+	at0, next0 := write(ifd0)
+	if len(gps) > 0 {
+		at, _ := write(gps)
+		binary.BigEndian.PutUint32(tiff[at0+gpsPointer:], uint32(at))
+	}
+	if thumb != nil {
+		at1, _ := write([]exifEntry{
+			{0x0201, 4, 1, make([]byte, 4)},
+			{0x0202, 4, 1, binary.BigEndian.AppendUint32(nil, uint32(len(thumb)))},
+		})
+		binary.BigEndian.PutUint32(tiff[next0:], uint32(at1))
+		binary.BigEndian.PutUint32(tiff[at1+2+8:], uint32(len(tiff)))
+		tiff = append(tiff, thumb...)
+	}
+
+	payload := append([]byte("Exif\x00\x00"), tiff...)
+	seg := []byte{0xFF, 0xE1}
+	seg = binary.BigEndian.AppendUint16(seg, uint16(len(payload)+2))
+	return append(seg, payload...)
+}
+
+// ramp maps i in [0, n) onto 0..255.
+func ramp(i, n int) uint8 {
+	if n <= 1 {
+		return 0
+	}
+	return uint8(i * 255 / (n - 1))
+}
@@ -0,0 +1,99 @@
+package testutil
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+
+	imageprocessor "github.com/Skryldev/image-processor"
+	"github.com/Skryldev/image-processor/core"
+	"github.com/Skryldev/image-processor/imagecompare"
+)
+
+// GoldenDir is where Golden reads and writes reference images, relative to
+// the package under test.
+var GoldenDir = filepath.Join("testdata", "golden")
+
+// DefaultTolerance absorbs codec noise (JPEG rounding, resampler
+// differences) while catching visible regressions.
+var DefaultTolerance = imagecompare.Tolerance{MaxMeanError: 0.01, MinSSIM: 0.98}
+
+func init() {
+	// Share -update with callers that already define it.
+	if flag.Lookup("update") == nil {
+		flag.Bool("update", false, "rewrite golden files instead of comparing against them")
+	}
+}
+
+// Updating reports whether the test binary was run with -update.
+func Updating() bool {
+	f := flag.Lookup("update")
+	return f != nil && f.Value.String() == "true"
+}
+
+// Run processes input through steps with a default Processor (stdlib codecs,
+// registry bound automatically) and fails t on error.
+func Run(t testing.TB, input []byte, steps ...core.Step) *core.ImageData {
+	t.Helper()
+	proc := imageprocessor.New(imageprocessor.DefaultConfig())
+	res, err := proc.Process(t.Context(), imageprocessor.FromReader(bytes.NewReader(input)), steps...)
+	if err != nil {
+		t.Fatalf("testutil: process: %v", err)
+	}
+	return res.Primary
+}
+
+// Golden compares got with GoldenDir/name.png under tol (DefaultTolerance
+// when zero) and fails t when it differs.  With -update it rewrites the
+// golden file instead.
+func Golden(t testing.TB, name string, got *core.ImageData, tol imagecompare.Tolerance) {
+	t.Helper()
+	if tol == (imagecompare.Tolerance{}) {
+		tol = DefaultTolerance
+	}
+	path := filepath.Join(GoldenDir, name+".png")
+	if err := CompareGolden(path, got, tol, Updating()); err != nil {
+		t.Fatalf("testutil: golden %s: %v", name, err)
+	}
+}
+
+// CompareGolden is Golden without the testing.TB: it compares got with the
+// PNG at path, or writes got there when update is set.
+func CompareGolden(path string, got *core.ImageData, tol imagecompare.Tolerance, update bool) error {
+	img, err := imagecompare.ImageOf(got)
+	if err != nil {
+		return err
+	}
+	if update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, img); err != nil {
+			return err
+		}
+		return os.WriteFile(path, buf.Bytes(), 0o644)
+	}
+
+	raw, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%s missing; run the test with -update to create it", path)
+	}
+	if err != nil {
+		return err
+	}
+	want, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	r, err := imagecompare.CompareImages(img, want)
+	if err != nil {
+		return err
+	}
+	return tol.Check(r)
+}
@@ -0,0 +1,79 @@
+package testutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/draw"
+	"testing"
+)
+
+// MultiPageTIFF returns an uncompressed little-endian TIFF with one RGBA
+// page per image, in order.
+func MultiPageTIFF(t testing.TB, pages ...image.Image) []byte {
+	t.Helper()
+	if len(pages) == 0 {
+		t.Fatal("testutil: MultiPageTIFF needs at least one page")
+	}
+	le := binary.LittleEndian
+	var buf bytes.Buffer
+	buf.WriteString("II*\x00")
+	binary.Write(&buf, le, uint32(0)) // first IFD offset, patched below
+
+	type entry struct {
+		tag, typ uint16
+		count    uint32
+		value    uint32
+	}
+	const (
+		typShort = 3
+		typLong  = 4
+	)
+	nextPtr := 4 // where the offset of the next IFD is written
+	for _, p := range pages {
+		b := p.Bounds()
+		px := image.NewNRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(px, px.Bounds(), p, b.Min, draw.Src)
+
+		bpsAt := buf.Len()
+		for i := 0; i < 4; i++ {
+			binary.Write(&buf, le, uint16(8))
+		}
+		stripAt := buf.Len()
+		buf.Write(px.Pix)
+		if buf.Len()%2 == 1 {
+			buf.WriteByte(0) // IFDs start on a word boundary
+		}
+
+		entries := []entry{
+			{256, typLong, 1, uint32(b.Dx())},
+			{257, typLong, 1, uint32(b.Dy())},
+			{258, typShort, 4, uint32(bpsAt)},
+			{259, typShort, 1, 1}, // no compression
+			{262, typShort, 1, 2}, // RGB
+			{273, typLong, 1, uint32(stripAt)},
+			{277, typShort, 1, 4},
+			{278, typLong, 1, uint32(b.Dy())},
+			{279, typLong, 1, uint32(len(px.Pix))},
+			{284, typShort, 1, 1}, // chunky
+			{338, typShort, 1, 2}, // unassociated alpha
+		}
+		ifdAt := buf.Len()
+		le.PutUint32(buf.Bytes()[nextPtr:], uint32(ifdAt))
+		binary.Write(&buf, le, uint16(len(entries)))
+		for _, e := range entries {
+			binary.Write(&buf, le, e.tag)
+			binary.Write(&buf, le, e.typ)
+			binary.Write(&buf, le, e.count)
+			if e.typ == typShort && e.count == 1 {
+				binary.Write(&buf, le, uint16(e.value))
+				binary.Write(&buf, le, uint16(0))
+				continue
+			}
+			binary.Write(&buf, le, e.value)
+		}
+		nextPtr = buf.Len()
+		binary.Write(&buf, le, uint32(0))
+	}
+	return buf.Bytes()
+}
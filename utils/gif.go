@@ -0,0 +1,66 @@
+package utils
+
+import "bytes"
+
+// GIFFrameCount walks the block structure of a GIF stream and returns the
+// number of image descriptors (frames) without decoding any pixel data.  It
+// returns 0 when data is not a well-formed GIF.
+func GIFFrameCount(data []byte) int {
+	if len(data) < 13 || !(bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a"))) {
+		return 0
+	}
+	pos := 13
+	if flags := data[10]; flags&0x80 != 0 {
+		pos += 3 << (flags&0x07 + 1) // global color table
+	}
+
+	// skipSubBlocks advances past a chain of length-prefixed sub-blocks.
+	skipSubBlocks := func() bool {
+		for pos < len(data) {
+			n := int(data[pos])
+			pos++
+			if n == 0 {
+				return true
+			}
+			pos += n
+		}
+		return false
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x21: // extension: introducer, label, sub-blocks
+			pos += 2
+			if !skipSubBlocks() {
+				return frames
+			}
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return frames
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1) // local color table
+			}
+			pos++ // LZW minimum code size
+			if !skipSubBlocks() {
+				return frames
+			}
+			frames++
+		case 0x3B: // trailer
+			return frames
+		default:
+			return frames
+		}
+	}
+	return frames
+}
+
+// IsAnimatedWebP reports whether data is a WebP whose VP8X header sets the
+// animation flag.
+func IsAnimatedWebP(data []byte) bool {
+	return len(data) >= 21 && bytes.HasPrefix(data, []byte("RIFF")) &&
+		string(data[8:16]) == "WEBPVP8X" && data[20]&0x02 != 0
+}
@@ -0,0 +1,49 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+)
+
+// Header is what ProbeHeader learns about an image without decoding it.
+type Header struct {
+	Width, Height int
+	// BytesPerPixel is the size of a decoded pixel: 1 or 2 for grey, 8 for
+	// 16-bit colour and 4 otherwise, as steps work on RGBA buffers.
+	BytesPerPixel int
+}
+
+// Pixels returns Width × Height.
+func (h Header) Pixels() int64 { return int64(h.Width) * int64(h.Height) }
+
+// DecodedBytes estimates the memory the decoded image takes.
+func (h Header) DecodedBytes() int64 { return h.Pixels() * int64(h.BytesPerPixel) }
+
+// ProbeHeader reads the dimensions and colour model from the header of an
+// image in a format registered with package image, which the stdlib
+// decoders in adapters/decoder do on import.  Only the header is parsed.
+func ProbeHeader(data []byte) (Header, error) {
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return Header{}, err
+	}
+	return HeaderOf(cfg), nil
+}
+
+// HeaderOf converts the result of a DecodeConfig function.
+func HeaderOf(cfg image.Config) Header {
+	return Header{Width: cfg.Width, Height: cfg.Height, BytesPerPixel: bytesPerPixel(cfg.ColorModel)}
+}
+
+func bytesPerPixel(m color.Model) int {
+	switch m {
+	case color.GrayModel:
+		return 1
+	case color.Gray16Model:
+		return 2
+	case color.RGBA64Model, color.NRGBA64Model:
+		return 8
+	}
+	return 4
+}
@@ -2,6 +2,7 @@ package utils
 
 import (
 	"bytes"
+	"encoding/binary"
 	"net/http"
 )
 
@@ -9,37 +10,176 @@ const (
 	formatJPEG    = "jpeg"
 	formatPNG     = "png"
 	formatWebP    = "webp"
+	formatGIF     = "gif"
+	formatTIFF    = "tiff"
+	formatBMP     = "bmp"
+	formatAVIF    = "avif"
+	formatHEIF    = "heif"
+	formatICO     = "ico"
+	formatPDF     = "pdf"
+	formatJXL     = "jxl"
+	formatSVG     = "svg"
+	formatRAW     = "raw"
 	formatUnknown = "unknown"
 )
 
+// Detection confidence levels returned by Detect.
+const (
+	ConfidenceNone  = 0.0
+	ConfidenceSniff = 0.5 // matched by net/http content sniffing
+	ConfidenceWeak  = 0.8 // short or ambiguous magic number (BMP, ICO)
+	ConfidenceMagic = 1.0 // unambiguous magic number / container brand
+)
+
+// Detection is the structured result of Detect.
+type Detection struct {
+	Format     string  // one of the core.Format values, "unknown" if unmatched
+	Confidence float64 // 0 (no match) .. 1 (unambiguous magic number)
+}
+
 // DetectFormat sniffs the first 512 bytes of data and returns the image format.
-func DetectFormat(data []byte) string {
+func DetectFormat(data []byte) string { return Detect(data).Format }
+
+// Detect sniffs the leading bytes of data and reports the image format along
+// with how confident the match is.
+func Detect(data []byte) Detection {
 	if len(data) < 4 {
-		return formatUnknown
+		return Detection{Format: formatUnknown}
 	}
+	magic := func(f string) Detection { return Detection{Format: f, Confidence: ConfidenceMagic} }
+	switch {
 	// JPEG: FF D8 FF
-	if data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF {
-		return formatJPEG
-	}
+	case data[0] == 0xFF && data[1] == 0xD8 && data[2] == 0xFF:
+		return magic(formatJPEG)
 	// PNG: 89 50 4E 47
-	if data[0] == 0x89 && data[1] == 0x50 && data[2] == 0x4E && data[3] == 0x47 {
-		return formatPNG
-	}
+	case data[0] == 0x89 && data[1] == 0x50 && data[2] == 0x4E && data[3] == 0x47:
+		return magic(formatPNG)
 	// WebP: RIFF....WEBP
-	if len(data) >= 12 &&
-		data[0] == 'R' && data[1] == 'I' && data[2] == 'F' && data[3] == 'F' &&
-		data[8] == 'W' && data[9] == 'E' && data[10] == 'B' && data[11] == 'P' {
-		return formatWebP
+	case len(data) >= 12 && bytes.Equal(data[0:4], []byte("RIFF")) && bytes.Equal(data[8:12], []byte("WEBP")):
+		return magic(formatWebP)
+	// GIF: GIF87a / GIF89a
+	case bytes.HasPrefix(data, []byte("GIF87a")) || bytes.HasPrefix(data, []byte("GIF89a")):
+		return magic(formatGIF)
+	// TIFF: II*\0 (little endian) / MM\0* (big endian).  Camera RAW
+	// formats share the header.
+	case bytes.HasPrefix(data, []byte("II*\x00")) || bytes.HasPrefix(data, []byte("MM\x00*")):
+		if isCameraRaw(data) {
+			return magic(formatRAW)
+		}
+		return magic(formatTIFF)
+	// PDF: %PDF-
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return magic(formatPDF)
+	// JPEG XL: bare codestream FF 0A, or the ISO-BMFF "JXL " signature box.
+	case data[0] == 0xFF && data[1] == 0x0A,
+		len(data) >= 12 && bytes.Equal(data[:12], []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")):
+		return magic(formatJXL)
+	// ISO-BMFF: ....ftyp<brand>
+	case len(data) >= 12 && bytes.Equal(data[4:8], []byte("ftyp")):
+		if f := ftypFormat(data); f != formatUnknown {
+			return magic(f)
+		}
+	// BMP: BM
+	case data[0] == 'B' && data[1] == 'M':
+		return Detection{Format: formatBMP, Confidence: ConfidenceWeak}
+	// ICO: 00 00 01 00
+	case data[0] == 0 && data[1] == 0 && data[2] == 1 && data[3] == 0:
+		return Detection{Format: formatICO, Confidence: ConfidenceWeak}
+	}
+	if isSVG(data) {
+		return magic(formatSVG)
 	}
 	// Fallback to net/http sniffing.
 	ct := http.DetectContentType(data)
 	switch ct {
 	case "image/jpeg":
-		return formatJPEG
+		return Detection{Format: formatJPEG, Confidence: ConfidenceSniff}
 	case "image/png":
-		return formatPNG
+		return Detection{Format: formatPNG, Confidence: ConfidenceSniff}
 	case "image/webp":
-		return formatWebP
+		return Detection{Format: formatWebP, Confidence: ConfidenceSniff}
+	}
+	return Detection{Format: formatUnknown}
+}
+
+// isSVG reports whether data is an XML document with an <svg> root: it
+// starts with markup and an <svg element appears in the first 4 KiB.
+func isSVG(data []byte) bool {
+	head := bytes.TrimLeft(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")), " \t\r\n")
+	if len(head) == 0 || head[0] != '<' {
+		return false
+	}
+	return bytes.Contains(head[:min(len(head), 4096)], []byte("<svg"))
+}
+
+// isCameraRaw reports whether a TIFF-structured file is a camera RAW: a
+// Canon CR2 (the "CR" marker after the header), a DNG (the DNGVersion tag in
+// IFD0) or a Nikon NEF (a NIKON Make with SubIFDs, which camera-written TIFFs
+// lack).
+func isCameraRaw(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+	if data[8] == 'C' && data[9] == 'R' {
+		return true
+	}
+	var bo binary.ByteOrder = binary.LittleEndian
+	if data[0] == 'M' {
+		bo = binary.BigEndian
+	}
+	off := int64(bo.Uint32(data[4:8]))
+	if off < 8 || off+2 > int64(len(data)) {
+		return false
+	}
+	nikon, subIFDs := false, false
+	for i, n := 0, int(bo.Uint16(data[off:])); i < n; i++ {
+		e := off + 2 + 12*int64(i)
+		if e+12 > int64(len(data)) {
+			break
+		}
+		switch bo.Uint16(data[e:]) {
+		case 0xC612: // DNGVersion
+			return true
+		case 0x014A: // SubIFDs
+			subIFDs = true
+		case 0x010F: // Make, ASCII
+			count := min(int64(bo.Uint32(data[e+4:])), 32)
+			val := data[e+8 : e+12]
+			if count > 4 {
+				p := int64(bo.Uint32(data[e+8:]))
+				if p+count > int64(len(data)) {
+					continue
+				}
+				val = data[p : p+count]
+			}
+			nikon = bytes.HasPrefix(val, []byte("NIKON"))
+		}
+	}
+	return nikon && subIFDs
+}
+
+// ftypFormat maps the major and compatible brands of an ISO-BMFF ftyp box to
+// AVIF or HEIF.
+func ftypFormat(data []byte) string {
+	size := int(data[0])<<24 | int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if size < 16 || size > len(data) {
+		size = min(len(data), 64)
+	}
+	brands := [][]byte{data[8:12]} // major brand
+	for i := 16; i+4 <= size; i += 4 {
+		brands = append(brands, data[i:i+4])
+	}
+	heif := false
+	for _, b := range brands {
+		switch string(b) {
+		case "avif", "avis":
+			return formatAVIF
+		case "heic", "heix", "hevc", "hevx", "heim", "heis", "mif1", "msf1":
+			heif = true
+		}
+	}
+	if heif {
+		return formatHEIF
 	}
 	return formatUnknown
 }
@@ -0,0 +1,186 @@
+package utils
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"sort"
+)
+
+// paletteSample caps how many pixels MedianCutPalette reads per image.
+const paletteSample = 1 << 18
+
+// MedianCutPalette returns up to n colours representing the opaque pixels
+// of imgs, found by median cut over a 15-bit colour histogram.  Pixels with
+// alpha below half are ignored; callers that need transparency add their own
+// entry.  Images with at most n distinct colours keep them exactly.
+func MedianCutPalette(n int, imgs ...image.Image) color.Palette {
+	if n <= 0 {
+		return nil
+	}
+	exact := map[color.NRGBA]struct{}{}
+	var hist [1 << 15]int
+	for _, img := range imgs {
+		b := img.Bounds()
+		step := 1
+		for b.Dx()*b.Dy()/(step*step) > paletteSample {
+			step++
+		}
+		for y := b.Min.Y; y < b.Max.Y; y += step {
+			for x := b.Min.X; x < b.Max.X; x += step {
+				c := color.NRGBAModel.Convert(img.At(x, y)).(color.NRGBA)
+				if c.A < 0x80 {
+					continue
+				}
+				c.A = 0xff
+				if exact != nil {
+					exact[c] = struct{}{}
+					if len(exact) > n {
+						exact = nil
+					}
+				}
+				hist[int(c.R>>3)<<10|int(c.G>>3)<<5|int(c.B>>3)]++
+			}
+		}
+	}
+	if exact != nil {
+		pal := make(color.Palette, 0, len(exact))
+		for c := range exact {
+			pal = append(pal, c)
+		}
+		sort.Slice(pal, func(i, j int) bool {
+			a, b := pal[i].(color.NRGBA), pal[j].(color.NRGBA)
+			return uint32(a.R)<<16|uint32(a.G)<<8|uint32(a.B) < uint32(b.R)<<16|uint32(b.G)<<8|uint32(b.B)
+		})
+		return pal
+	}
+
+	type box struct{ cells []int } // histogram indices
+	var all []int
+	for i, c := range hist {
+		if c > 0 {
+			all = append(all, i)
+		}
+	}
+	chans := func(i int) [3]int { return [3]int{i >> 10 & 31, i >> 5 & 31, i & 31} }
+	// widest returns the channel with the largest range and that range.
+	widest := func(b box) (int, int) {
+		lo, hi := [3]int{31, 31, 31}, [3]int{}
+		for _, i := range b.cells {
+			c := chans(i)
+			for k := range c {
+				lo[k], hi[k] = min(lo[k], c[k]), max(hi[k], c[k])
+			}
+		}
+		ch := 0
+		for k := 1; k < 3; k++ {
+			if hi[k]-lo[k] > hi[ch]-lo[ch] {
+				ch = k
+			}
+		}
+		return ch, hi[ch] - lo[ch]
+	}
+
+	boxes := []box{{all}}
+	for len(boxes) < n {
+		// Split the box with the most pixels among those that can split.
+		best, bestCount := -1, 0
+		for i, b := range boxes {
+			if _, r := widest(b); r == 0 {
+				continue
+			}
+			count := 0
+			for _, c := range b.cells {
+				count += hist[c]
+			}
+			if count > bestCount {
+				best, bestCount = i, count
+			}
+		}
+		if best < 0 {
+			break
+		}
+		b := boxes[best]
+		ch, _ := widest(b)
+		sort.Slice(b.cells, func(i, j int) bool { return chans(b.cells[i])[ch] < chans(b.cells[j])[ch] })
+		half, acc, cut := bestCount/2, 0, 1
+		for i, c := range b.cells {
+			acc += hist[c]
+			if acc >= half {
+				cut = max(1, min(i+1, len(b.cells)-1))
+				break
+			}
+		}
+		// Do not split cells sharing a channel value across boxes.
+		for cut < len(b.cells)-1 && chans(b.cells[cut])[ch] == chans(b.cells[cut-1])[ch] {
+			cut++
+		}
+		if chans(b.cells[cut])[ch] == chans(b.cells[cut-1])[ch] {
+			for cut > 1 && chans(b.cells[cut])[ch] == chans(b.cells[cut-1])[ch] {
+				cut--
+			}
+		}
+		boxes[best] = box{b.cells[:cut:cut]}
+		boxes = append(boxes, box{b.cells[cut:]})
+	}
+
+	pal := make(color.Palette, 0, len(boxes))
+	for _, b := range boxes {
+		var sum [3]int
+		total := 0
+		for _, i := range b.cells {
+			c := chans(i)
+			w := hist[i]
+			for k := range sum {
+				sum[k] += (c[k]<<3 | 4) * w
+			}
+			total += w
+		}
+		if total == 0 {
+			continue
+		}
+		pal = append(pal, color.NRGBA{uint8(sum[0] / total), uint8(sum[1] / total), uint8(sum[2] / total), 0xff})
+	}
+	return pal
+}
+
+// Quantize reduces img to a paletted image of at most n colours (clamped to
+// 2-256) using MedianCutPalette.  When img has pixels with alpha below half,
+// one entry is reserved for full transparency; partial alpha is otherwise
+// dropped.  dither selects Floyd-Steinberg error diffusion over plain
+// nearest-colour mapping.
+func Quantize(img image.Image, n int, dither bool) *image.Paletted {
+	n = max(2, min(n, 256))
+	b := img.Bounds()
+	transparent := false
+	if o, ok := img.(interface{ Opaque() bool }); !ok || !o.Opaque() {
+	scan:
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				if _, _, _, a := img.At(x, y).RGBA(); a < 0x8000 {
+					transparent = true
+					break scan
+				}
+			}
+		}
+	}
+	size := n
+	if transparent {
+		size--
+	}
+	pal := MedianCutPalette(size, img)
+	if len(pal) == 0 {
+		pal = color.Palette{color.NRGBA{A: 0xff}}
+	}
+	if transparent {
+		pal = append(pal, color.NRGBA{})
+	}
+
+	dst := image.NewPaletted(image.Rect(0, 0, b.Dx(), b.Dy()), pal)
+	if dither {
+		draw.FloydSteinberg.Draw(dst, dst.Rect, img, b.Min)
+	} else {
+		draw.Draw(dst, dst.Rect, img, b.Min, draw.Src)
+	}
+	return dst
+}
@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"image"
+	"math"
+)
+
+// QuadSize returns the natural output size for rectifying quad (top-left,
+// top-right, bottom-right, bottom-left): the longer of each pair of
+// opposite edges, rounded.
+func QuadSize(quad [4]image.Point) (int, int) {
+	dist := func(a, b image.Point) float64 {
+		return math.Hypot(float64(b.X-a.X), float64(b.Y-a.Y))
+	}
+	w := math.Max(dist(quad[0], quad[1]), dist(quad[3], quad[2]))
+	h := math.Max(dist(quad[0], quad[3]), dist(quad[1], quad[2]))
+	return int(math.Round(w)), int(math.Round(h))
+}
+
+// PerspectiveMatrix returns the row-major 3×3 homography taking the pixel
+// indices (x, y, 1) of a w×h output to the source pixel indices inside quad
+// (top-left, top-right, bottom-right, bottom-left): dividing the first two
+// components of the product by the third gives the position to sample.
+// Pixel centres are matched, so an axis-aligned quad covering a w×h source
+// maps every pixel onto itself.  ok is false for a degenerate quad.
+func PerspectiveMatrix(quad [4]image.Point, w, h int) (m [9]float64, ok bool) {
+	if w <= 0 || h <= 0 {
+		return m, false
+	}
+	x0, y0 := float64(quad[0].X), float64(quad[0].Y)
+	x1, y1 := float64(quad[1].X), float64(quad[1].Y)
+	x2, y2 := float64(quad[2].X), float64(quad[2].Y)
+	x3, y3 := float64(quad[3].X), float64(quad[3].Y)
+
+	// Unit square to quad (Heckbert, "Fundamentals of Texture Mapping").
+	var a, b, c, d, e, f, g, hh float64
+	dx3, dy3 := x0-x1+x2-x3, y0-y1+y2-y3
+	if dx3 == 0 && dy3 == 0 {
+		a, b, c = x1-x0, x3-x0, x0
+		d, e, f = y1-y0, y3-y0, y0
+	} else {
+		dx1, dx2 := x1-x2, x3-x2
+		dy1, dy2 := y1-y2, y3-y2
+		det := dx1*dy2 - dx2*dy1
+		if det == 0 {
+			return m, false
+		}
+		g = (dx3*dy2 - dx2*dy3) / det
+		hh = (dx1*dy3 - dx3*dy1) / det
+		a, b, c = x1-x0+g*x1, x3-x0+hh*x3, x0
+		d, e, f = y1-y0+g*y1, y3-y0+hh*y3, y0
+	}
+	if a*e-b*d == 0 {
+		return m, false
+	}
+
+	// Output index x has its centre at u = (x+0.5)/w on the unit square;
+	// the source centre of index X lies at X+0.5.
+	su, tu := 1/float64(w), 0.5/float64(w)
+	sv, tv := 1/float64(h), 0.5/float64(h)
+	row := func(p, q, r float64) [3]float64 { return [3]float64{p * su, q * sv, p*tu + q*tv + r} }
+	nx, ny, den := row(a, b, c), row(d, e, f), row(g, hh, 1)
+	for i := 0; i < 3; i++ {
+		m[i] = nx[i] - 0.5*den[i]
+		m[3+i] = ny[i] - 0.5*den[i]
+		m[6+i] = den[i]
+	}
+	return m, true
+}